@@ -0,0 +1,288 @@
+// Command loadtest joins rooms of a Peer Calls SFU with synthetic publishers
+// and subscribers and reports join times, packet loss and the CPU usage of
+// the server, for capacity planning.
+//
+// Publishers send an Ogg Opus file, silence when no file is given, and an
+// IVF VP8 file when one is given. Files are played in a loop until the test
+// ends.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/peer-calls/peer-calls/pkg/client"
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/peer-calls/peer-calls/server/logger"
+	"github.com/pion/webrtc/v2"
+)
+
+type options struct {
+	url         string
+	room        string
+	rooms       int
+	password    string
+	token       string
+	publishers  int
+	subscribers int
+	audio       string
+	video       string
+	duration    time.Duration
+	ramp        time.Duration
+	joinTimeout time.Duration
+	serverPID   int
+}
+
+type loadTest struct {
+	log       logger.Logger
+	loggers   server.LoggerFactory
+	options   options
+	media     []*mediaFile
+	collector *collector
+	// runID makes client IDs unique across runs
+	runID string
+}
+
+func main() {
+	var o options
+	flags := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	flags.StringVar(&o.url, "url", "http://localhost:3000", "Base URL of the server")
+	flags.StringVar(&o.room, "room", "loadtest", "Prefix of room names")
+	flags.IntVar(&o.rooms, "rooms", 1, "Number of rooms participants are distributed to")
+	flags.StringVar(&o.password, "password", "", "Password of the rooms")
+	flags.StringVar(&o.token, "token", "", "Join token of a tenant")
+	flags.IntVar(&o.publishers, "publishers", 1, "Number of publishers")
+	flags.IntVar(&o.subscribers, "subscribers", 1, "Number of subscribers")
+	flags.StringVar(&o.audio, "audio", "", "Ogg Opus file sent by publishers, silence when empty")
+	flags.StringVar(&o.video, "video", "", "IVF VP8 file sent by publishers, no video when empty")
+	flags.DurationVar(&o.duration, "duration", 30*time.Second, "Time to send media after all participants started joining")
+	flags.DurationVar(&o.ramp, "ramp", 100*time.Millisecond, "Delay between joins of participants")
+	flags.DurationVar(&o.joinTimeout, "join-timeout", 20*time.Second, "Time to wait until a participant is connected")
+	flags.IntVar(&o.serverPID, "server-pid", 0, "PID of a server on this host to measure CPU usage of (Linux only)")
+	flags.Parse(os.Args[1:])
+
+	if o.rooms < 1 {
+		fmt.Fprintln(os.Stderr, "rooms must be at least 1")
+		os.Exit(2)
+	}
+
+	loggerFactory := logger.NewFactoryFromEnv("PEERCALLS_", os.Stderr)
+	loggerFactory.SetDefaultEnabled([]string{"loadtest"})
+
+	t := &loadTest{
+		log:       loggerFactory.GetLogger("loadtest"),
+		loggers:   loggerFactory,
+		options:   o,
+		collector: &collector{},
+		runID:     server.NewUUIDBase62()[:6],
+	}
+
+	if err := t.loadMedia(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// stop early and report on interrupt
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	if err := t.run(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func (t *loadTest) loadMedia() error {
+	audio := silenceFile()
+	if t.options.audio != "" {
+		var err error
+		if audio, err = readOggFile(t.options.audio); err != nil {
+			return err
+		}
+	}
+	t.media = append(t.media, audio)
+
+	if t.options.video != "" {
+		video, err := readIVFFile(t.options.video)
+		if err != nil {
+			return err
+		}
+		t.media = append(t.media, video)
+	}
+
+	return nil
+}
+
+func (t *loadTest) run(ctx context.Context) error {
+	var cpuStart time.Duration
+	if t.options.serverPID != 0 {
+		var err error
+		if cpuStart, err = processCPU(t.options.serverPID); err != nil {
+			return fmt.Errorf("reading CPU usage of server: %w", err)
+		}
+	}
+	start := time.Now()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	participants := t.options.publishers + t.options.subscribers
+	for i := 0; i < participants; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(t.options.ramp):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			t.participate(ctx, i, i < t.options.publishers, done)
+		}(i)
+	}
+
+	t.log.Printf("All participants started, sending media for %s", t.options.duration)
+	select {
+	case <-time.After(t.options.duration):
+	case <-ctx.Done():
+	}
+
+	close(done)
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	r := t.collector.report()
+
+	fmt.Printf("participants: %d publishers, %d subscribers in %d rooms\n", t.options.publishers, t.options.subscribers, t.options.rooms)
+	fmt.Printf("joins:        %d ok, %d failed\n", r.Joins, r.JoinErrors)
+	fmt.Printf("join time:    %s\n", r.JoinTime)
+	fmt.Printf("tracks:       %d received\n", r.Tracks)
+	fmt.Printf("packets:      %d received, %d lost (%.2f%%)\n", r.Received, r.Lost, r.LossPercent())
+
+	if t.options.serverPID != 0 {
+		cpuEnd, err := processCPU(t.options.serverPID)
+		if err != nil {
+			return fmt.Errorf("reading CPU usage of server: %w", err)
+		}
+		fmt.Printf("server CPU:   %.1f%% of a core\n", float64(cpuEnd-cpuStart)*100/float64(elapsed))
+	}
+
+	return nil
+}
+
+// participate joins a room as participant i, publishes media when publisher
+// is set, and stays in the room until done is closed.
+func (t *loadTest) participate(ctx context.Context, i int, publisher bool, done <-chan struct{}) {
+	role := "sub"
+	if publisher {
+		role = "pub"
+	}
+	room := fmt.Sprintf("%s-%d", t.options.room, i%t.options.rooms)
+	clientID := fmt.Sprintf("loadtest-%s-%s-%d", t.runID, role, i)
+	log := t.log.WithCtx(logger.Ctx{"clientID": clientID})
+
+	params := client.Params{
+		Room:          room,
+		ClientID:      clientID,
+		LoggerFactory: t.loggers,
+	}
+	if !publisher {
+		params.OnTrack = t.receive
+	}
+
+	c, err := t.join(ctx, params)
+	if err != nil {
+		log.Errorf("Error joining room: %s: %s", room, err)
+		t.collector.failed(err)
+		return
+	}
+	defer c.Close()
+
+	if publisher {
+		for _, file := range t.media {
+			go t.publish(c, file, done)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-c.Done():
+		log.Errorf("Signaling ended: %s", c.Err())
+	}
+}
+
+func (t *loadTest) join(ctx context.Context, params client.Params) (*client.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.options.joinTimeout)
+	defer cancel()
+
+	start := time.Now()
+
+	conn, err := client.DialWebSocket(ctx, t.options.url, server.SignalingHello{
+		Room:     params.Room,
+		ClientID: params.ClientID,
+		Password: t.options.password,
+		Token:    t.options.token,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := client.Join(ctx, conn, params)
+	if err != nil {
+		return nil, err
+	}
+
+	t.collector.joined(time.Since(start))
+	return c, nil
+}
+
+func (t *loadTest) publish(c *client.Client, file *mediaFile, done <-chan struct{}) {
+	payloadType := uint8(webrtc.DefaultPayloadTypeOpus)
+	if file.kind == webrtc.RTPCodecTypeVideo {
+		payloadType = webrtc.DefaultPayloadTypeVP8
+	}
+
+	track, err := c.PeerConnection().NewTrack(payloadType, rand.Uint32(), file.kind.String(), c.ID())
+	if err != nil {
+		t.log.Errorf("[%s] Error creating %s track: %s", c.ID(), file.kind, err)
+		return
+	}
+
+	if _, err := c.Publish(track); err != nil {
+		t.log.Errorf("[%s] Error publishing %s track: %s", c.ID(), file.kind, err)
+		return
+	}
+
+	if err := file.play(track, done); err != nil {
+		t.log.Errorf("[%s] Error sending %s: %s", c.ID(), file.kind, err)
+	}
+}
+
+func (t *loadTest) receive(track *webrtc.Track, receiver *webrtc.RTPReceiver) {
+	stats := t.collector.newTrack()
+	for {
+		packet, err := track.ReadRTP()
+		if err != nil {
+			return
+		}
+		t.collector.observe(stats, packet.SequenceNumber)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/pion/webrtc/v2"
+	"github.com/pion/webrtc/v2/pkg/media"
+	"github.com/pion/webrtc/v2/pkg/media/ivfreader"
+)
+
+// opusSilence is an Opus packet of 20 ms of silence, sent when no audio file
+// is given.
+var opusSilence = []byte{0xf8, 0xff, 0xfe}
+
+// sample is a frame of a media file with its duration.
+type sample struct {
+	data     []byte
+	samples  uint32
+	duration time.Duration
+}
+
+// mediaFile is a media file read into memory, so that all publishers can
+// loop it without reading from disk.
+type mediaFile struct {
+	kind    webrtc.RTPCodecType
+	samples []sample
+}
+
+func readOggFile(name string) (*mediaFile, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := server.NewOggOpusReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	file := &mediaFile{kind: webrtc.RTPCodecTypeAudio}
+	for {
+		packet, err := reader.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		samples := server.OpusPacketSamples(packet)
+		file.samples = append(file.samples, sample{
+			data:     packet,
+			samples:  samples,
+			duration: time.Duration(samples) * time.Second / 48000,
+		})
+	}
+
+	return file, checkSamples(name, file)
+}
+
+func readIVFFile(name string) (*mediaFile, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, header, err := ivfreader.NewWith(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if header.FourCC != "VP80" {
+		return nil, fmt.Errorf("%s: unsupported codec: %s, only VP8 is supported", name, header.FourCC)
+	}
+	if header.TimebaseDenominator == 0 || header.TimebaseNumerator == 0 {
+		return nil, fmt.Errorf("%s: invalid timebase", name)
+	}
+
+	frameDuration := time.Duration(header.TimebaseNumerator) * time.Second / time.Duration(header.TimebaseDenominator)
+
+	file := &mediaFile{kind: webrtc.RTPCodecTypeVideo}
+	for {
+		frame, _, err := reader.ParseNextFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		file.samples = append(file.samples, sample{
+			data:     frame,
+			samples:  uint32(frameDuration * 90000 / time.Second),
+			duration: frameDuration,
+		})
+	}
+
+	return file, checkSamples(name, file)
+}
+
+func checkSamples(name string, file *mediaFile) error {
+	if len(file.samples) == 0 {
+		return fmt.Errorf("%s: no frames", name)
+	}
+	return nil
+}
+
+// silenceFile returns a second of Opus silence.
+func silenceFile() *mediaFile {
+	file := &mediaFile{kind: webrtc.RTPCodecTypeAudio}
+	for i := 0; i < 50; i++ {
+		file.samples = append(file.samples, sample{
+			data:     opusSilence,
+			samples:  960,
+			duration: 20 * time.Millisecond,
+		})
+	}
+	return file
+}
+
+// play writes the samples of file to track in real time, starting over at
+// the end, until done is closed.
+func (f *mediaFile) play(track *webrtc.Track, done <-chan struct{}) error {
+	next := time.Now()
+	for i := 0; ; i = (i + 1) % len(f.samples) {
+		select {
+		case <-time.After(time.Until(next)):
+		case <-done:
+			return nil
+		}
+
+		s := f.samples[i]
+		err := track.WriteSample(media.Sample{Data: s.data, Samples: s.samples})
+		if err != nil && err != io.ErrClosedPipe {
+			return err
+		}
+
+		next = next.Add(s.duration)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// trackStats counts the packets received on a track and the packets lost,
+// detected from gaps in sequence numbers.
+type trackStats struct {
+	started        bool
+	sequenceNumber uint16
+	received       uint64
+	lost           uint64
+}
+
+func (s *trackStats) observe(sequenceNumber uint16) {
+	if !s.started {
+		s.started = true
+		s.sequenceNumber = sequenceNumber
+		s.received++
+		return
+	}
+
+	diff := sequenceNumber - s.sequenceNumber
+	switch {
+	case diff == 0:
+		// duplicate
+		return
+	case diff < 0x8000:
+		s.lost += uint64(diff - 1)
+		s.sequenceNumber = sequenceNumber
+	default:
+		// a late packet which was counted as lost
+		if s.lost > 0 {
+			s.lost--
+		}
+	}
+	s.received++
+}
+
+// collector collects the results of all participants.
+type collector struct {
+	mu         sync.Mutex
+	joins      []time.Duration
+	joinErrors []error
+	tracks     []*trackStats
+}
+
+func (c *collector) joined(duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.joins = append(c.joins, duration)
+}
+
+func (c *collector) failed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.joinErrors = append(c.joinErrors, err)
+}
+
+// newTrack returns the stats of a received track, which must be observed
+// with observe of the collector.
+func (c *collector) newTrack() *trackStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := &trackStats{}
+	c.tracks = append(c.tracks, stats)
+	return stats
+}
+
+func (c *collector) observe(stats *trackStats, sequenceNumber uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats.observe(sequenceNumber)
+}
+
+type report struct {
+	Joins      int
+	JoinErrors int
+	JoinTime   latencySummary
+	Tracks     int
+	Received   uint64
+	Lost       uint64
+}
+
+func (c *collector) report() report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := report{
+		Joins:      len(c.joins),
+		JoinErrors: len(c.joinErrors),
+		JoinTime:   summarize(c.joins),
+		Tracks:     len(c.tracks),
+	}
+	for _, stats := range c.tracks {
+		r.Received += stats.received
+		r.Lost += stats.lost
+	}
+	return r
+}
+
+// LossPercent returns the percentage of packets lost of all packets sent.
+func (r report) LossPercent() float64 {
+	if total := r.Received + r.Lost; total > 0 {
+		return float64(r.Lost) * 100 / float64(total)
+	}
+	return 0
+}
+
+type latencySummary struct {
+	Min, Avg, P50, P95, Max time.Duration
+}
+
+func summarize(durations []time.Duration) (s latencySummary) {
+	if len(durations) == 0 {
+		return s
+	}
+
+	sorted := append([]time.Duration{}, durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+
+	percentile := func(p int) time.Duration {
+		return sorted[(len(sorted)-1)*p/100]
+	}
+
+	return latencySummary{
+		Min: sorted[0],
+		Avg: sum / time.Duration(len(sorted)),
+		P50: percentile(50),
+		P95: percentile(95),
+		Max: sorted[len(sorted)-1],
+	}
+}
+
+func (s latencySummary) String() string {
+	round := func(d time.Duration) time.Duration { return d.Round(time.Millisecond) }
+	return fmt.Sprintf("min %s, avg %s, p50 %s, p95 %s, max %s",
+		round(s.Min), round(s.Avg), round(s.P50), round(s.P95), round(s.Max))
+}
+
+// clockTicks is the unit of CPU times in /proc/[pid]/stat, which is 100 Hz
+// on all common Linux platforms.
+const clockTicks = 100
+
+// processCPU returns the CPU time used by the process with pid. It reads
+// /proc, so it only works on Linux and for a server on the same host.
+func processCPU(pid int) (time.Duration, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	// the command name in parentheses can contain spaces
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return 0, fmt.Errorf("invalid stat of process: %d", pid)
+	}
+
+	// utime and stime are the 14th and 15th fields, counting the pid and the
+	// command name
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 13 {
+		return 0, fmt.Errorf("invalid stat of process: %d", pid)
+	}
+
+	var ticks uint64
+	for _, field := range fields[11:13] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid stat of process: %d: %w", pid, err)
+		}
+		ticks += value
+	}
+
+	return time.Duration(ticks) * time.Second / clockTicks, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackStats(t *testing.T) {
+	var s trackStats
+
+	for _, sequenceNumber := range []uint16{65533, 65534, 65534, 0, 2, 1, 5} {
+		s.observe(sequenceNumber)
+	}
+
+	// 65535 was lost around the wrap, 1 arrived late, 3 and 4 were lost
+	assert.Equal(t, uint64(6), s.received)
+	assert.Equal(t, uint64(3), s.lost)
+}
+
+func TestSummarize(t *testing.T) {
+	var durations []time.Duration
+	for i := 100; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+
+	assert.Equal(t, latencySummary{
+		Min: 1 * time.Millisecond,
+		Avg: 50500 * time.Microsecond,
+		P50: 50 * time.Millisecond,
+		P95: 95 * time.Millisecond,
+		Max: 100 * time.Millisecond,
+	}, summarize(durations))
+	assert.Equal(t, time.Duration(100), durations[0]/time.Millisecond, "durations must not be sorted in place")
+	assert.Equal(t, latencySummary{}, summarize(nil))
+}
+
+func TestCollector_report(t *testing.T) {
+	c := &collector{}
+	c.joined(10 * time.Millisecond)
+	c.failed(errors.New("connect: context deadline exceeded"))
+
+	stats := c.newTrack()
+	for _, sequenceNumber := range []uint16{1, 2, 4} {
+		c.observe(stats, sequenceNumber)
+	}
+
+	r := c.report()
+	assert.Equal(t, 1, r.Joins)
+	assert.Equal(t, 1, r.JoinErrors)
+	assert.Equal(t, 1, r.Tracks)
+	assert.Equal(t, uint64(3), r.Received)
+	assert.Equal(t, uint64(1), r.Lost)
+	assert.Equal(t, 25.0, r.LossPercent())
+}
+
+func TestProcessCPU(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no /proc filesystem")
+	}
+
+	_, err := processCPU(os.Getpid())
+	require.NoError(t, err)
+}
@@ -7,14 +7,20 @@ require (
 	github.com/go-redis/redis/v7 v7.2.0
 	github.com/gobuffalo/packd v0.3.0
 	github.com/gobuffalo/packr v1.30.1
+	github.com/golang/protobuf v1.3.3
 	github.com/google/uuid v1.1.1
 	github.com/oxtoacart/bpool v0.0.0-20190530202638-03653db5a59c
 	github.com/pion/logging v0.2.2
 	github.com/pion/rtcp v1.2.1
+	github.com/pion/rtp v1.4.0
+	github.com/pion/sdp/v2 v2.3.7
 	github.com/pion/webrtc/v2 v2.2.6-0.20200423072255-ada4e48a9b1b
 	github.com/stretchr/testify v1.5.1
+	golang.org/x/crypto v0.0.0-20200323165209-0ec3e9974c59
+	google.golang.org/grpc v1.29.1
 	gopkg.in/yaml.v2 v2.2.8
 	nhooyr.io/websocket v1.8.4
 )
 
 // replace github.com/pion/webrtc/v2 => github.com/jeremija/webrtc/v2 v2.2.6-0.20200420091005-4cc16a2df9e0
+
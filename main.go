@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/peer-calls/peer-calls/server"
 	"github.com/peer-calls/peer-calls/server/logger"
@@ -19,6 +25,210 @@ func panicOnError(err error, message string) {
 	}
 }
 
+func startSIPGateway(loggerFactory *logger.Factory, c server.SIPConfig, tracks *server.MemoryTracksManager, handler server.SignalingHandler) {
+	conn, err := net.ListenPacket("udp", c.ListenAddr)
+	panicOnError(err, "Error starting SIP listener")
+
+	publicIP := net.ParseIP(c.PublicIP)
+	if publicIP == nil {
+		publicIP = conn.LocalAddr().(*net.UDPAddr).IP
+	}
+	if publicIP.IsUnspecified() {
+		panic(fmt.Errorf("SIP public IP must be set when listening on %s", c.ListenAddr))
+	}
+
+	gateway, err := server.NewSIPGateway(loggerFactory, conn, publicIP, tracks, handler, c)
+	panicOnError(err, "Error configuring SIP gateway")
+	go gateway.Serve()
+}
+
+func startAdminRPC(loggerFactory *logger.Factory, c server.Config, wss *server.WSS, tracks *server.MemoryTracksManager, recorder *server.RoomRecorder) {
+	opts, err := server.GRPCServerOptions(c.Admin.GRPCTLS, c.Admin.GRPCInsecure)
+	panicOnError(err, "Error configuring admin gRPC API")
+
+	l, err := net.Listen("tcp", c.Admin.GRPCListenAddr)
+	panicOnError(err, "Error starting admin gRPC listener")
+
+	rpc := server.NewAdminRPCServer(loggerFactory, c.BaseURL, c.Admin.APIToken(), wss, tracks, recorder, opts...)
+	go func() {
+		err := rpc.Serve(l)
+		panicOnError(err, "Error serving admin gRPC API")
+	}()
+}
+
+func startSignaling(loggerFactory *logger.Factory, c server.SignalingConfig, handler server.SignalingHandler) {
+	if c.GRPCListenAddr != "" {
+		opts, err := server.GRPCServerOptions(c.GRPCTLS, c.GRPCInsecure)
+		panicOnError(err, "Error configuring signaling gRPC service")
+
+		l, err := net.Listen("tcp", c.GRPCListenAddr)
+		panicOnError(err, "Error starting signaling gRPC listener")
+
+		rpc := server.NewSignalingRPCServer(loggerFactory, handler, opts...)
+		go func() {
+			err := rpc.Serve(l)
+			panicOnError(err, "Error serving signaling gRPC API")
+		}()
+	}
+
+	if c.TCPListenAddr != "" {
+		l, err := net.Listen("tcp", c.TCPListenAddr)
+		panicOnError(err, "Error starting signaling TCP listener")
+
+		tcp := server.NewTCPSignalingServer(loggerFactory, handler)
+		go func() {
+			err := tcp.Serve(l)
+			panicOnError(err, "Error serving signaling TCP protocol")
+		}()
+	}
+}
+
+func newAuditLog(loggerFactory *logger.Factory, c server.AuditConfig) *server.AuditLog {
+	var sinks []server.AuditSink
+	if c.Syslog.Addr != "" {
+		network := c.Syslog.Network
+		if network == "" {
+			network = "udp"
+		}
+		sinks = append(sinks, server.NewSyslogAuditSink(network, c.Syslog.Addr))
+	}
+	if c.HTTP.URL != "" {
+		sinks = append(sinks, server.NewHTTPAuditSink(c.HTTP.URL, c.HTTP.Token, &http.Client{
+			Timeout: 10 * time.Second,
+		}))
+	}
+	return server.NewAuditLog(loggerFactory, sinks...)
+}
+
+func newWebhooks(loggerFactory *logger.Factory, c server.WebhookConfig, apiKeys *server.APIKeys) *server.Webhooks {
+	if c.URL == "" {
+		return nil
+	}
+	webhooks := server.NewWebhooks(loggerFactory, c, &http.Client{
+		Timeout: 10 * time.Second,
+	})
+	webhooks.SetAPIKeys(apiKeys)
+	return webhooks
+}
+
+func newUploads(loggerFactory *logger.Factory, c server.StorageConfig, webhooks *server.Webhooks) (*server.Uploads, error) {
+	store, err := server.NewBlobStore(c, &http.Client{
+		Timeout: 10 * time.Minute,
+	})
+	if store == nil || err != nil {
+		return nil, err
+	}
+	uploads := server.NewUploads(loggerFactory, store, c)
+	uploads.OnUploaded(func(upload server.Upload) {
+		webhooks.Emit(server.WebhookUploadFinished, upload.Room, "", upload)
+	})
+	uploads.OnFailed(func(upload server.Upload, err error) {
+		webhooks.Emit(server.WebhookUploadFailed, upload.Room, "", upload)
+	})
+	return uploads, nil
+}
+
+func newTracer(loggerFactory *logger.Factory, c server.TracingConfig) *server.Tracer {
+	if c.Endpoint == "" {
+		return nil
+	}
+	return server.NewTracer(loggerFactory, server.NewOTLPExporter(c.Endpoint, c.ServiceName, &http.Client{
+		Timeout: 10 * time.Second,
+	}))
+}
+
+func newEgress(c server.EgressConfig) (server.Egress, error) {
+	if server.IsGRPCEndpoint(c.Endpoint) {
+		conn, err := server.DialGRPC(c.Endpoint, c.CA, c.Secret, c.Insecure)
+		if err != nil {
+			return nil, err
+		}
+		return server.NewGRPCEgress(conn, c.Secret), nil
+	}
+	return server.NewHTTPEgress(c.Endpoint, c.Secret, &http.Client{
+		Timeout: 10 * time.Second,
+	}), nil
+}
+
+func setLogLevel(loggerFactory *logger.Factory, c server.LogConfig) error {
+	if c.Level == "" {
+		return nil
+	}
+	level, err := logger.ParseLevel(c.Level)
+	if err != nil {
+		return err
+	}
+	loggerFactory.SetLevel(level)
+	return nil
+}
+
+func readConfig(configFiles []string) (server.Config, error) {
+	c, err := server.ReadConfig(configFiles)
+	if err != nil {
+		return c, err
+	}
+	if err = resolveConfigSecrets(&c); err != nil {
+		return c, err
+	}
+	if err = server.ValidateICEServers(c.ICEServers); err != nil {
+		return c, err
+	}
+	err = server.ValidateSFUUDPConfig(c.Network.SFU.UDP)
+	return c, err
+}
+
+func resolveConfigSecrets(c *server.Config) error {
+	return server.ResolveConfigSecrets(server.NewSecretResolver(), c, &http.Client{
+		Timeout: 10 * time.Second,
+	})
+}
+
+// reloadOnSignal reloads the config when the process receives SIGHUP.
+func reloadOnSignal(log logger.Logger, reloader *server.ConfigReloader) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			log.Printf("Received SIGHUP, reloading config")
+			if err := reloader.Reload(); err != nil {
+				log.Errorf("%s", err)
+			}
+		}
+	}()
+}
+
+// drainOnSignal calls drain when the process receives SIGTERM, so that a new
+// instance listening on the same port takes over new connections.
+func drainOnSignal(log logger.Logger, drain func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Printf("Received SIGTERM, draining")
+		drain()
+	}()
+}
+
+// drain stops accepting connections and waits for the clients of this
+// instance to leave, at most for the drain timeout.
+func drain(log logger.Logger, c server.UpgradeConfig, httpServer *server.StartStopper, wss *server.WSS) {
+	ctx := context.Background()
+	if c.DrainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.DrainTimeout)
+		defer cancel()
+	}
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Errorf("Error shutting down server: %s", err)
+	}
+	if count := wss.Drain(ctx); count > 0 {
+		log.Printf("Drain timeout, disconnecting %d clients", count)
+		return
+	}
+	log.Printf("All clients left")
+}
+
 func main() {
 	loggerFactory := logger.NewFactoryFromEnv("PEERCALLS_", os.Stderr)
 	loggerFactory.SetDefaultEnabled([]string{
@@ -43,19 +253,199 @@ func main() {
 	c, err := server.ReadConfig(configFiles)
 	panicOnError(err, "Error reading config")
 
-	log.Printf("Using config: %+v", c)
+	log.Printf("Using config: %+v", server.RedactConfigSecrets(c))
+	err = resolveConfigSecrets(&c)
+	panicOnError(err, "Error resolving config secrets")
+	err = server.ValidateICEServers(c.ICEServers)
+	panicOnError(err, "Error configuring ICE servers")
+	err = server.ValidateSFUUDPConfig(c.Network.SFU.UDP)
+	panicOnError(err, "Error configuring SFU UDP ports")
+	err = setLogLevel(loggerFactory, c.Log)
+	panicOnError(err, "Error setting log level")
+	if c.Log.Format != "" {
+		loggerFactory.SetFormat(logger.Format(c.Log.Format))
+	}
 	newAdapter := server.NewAdapterFactory(loggerFactory, c.Store)
 	rooms := server.NewAdapterRoomManager(newAdapter.NewAdapter)
-	tracks := server.NewMemoryTracksManager(loggerFactory)
-	mux := server.NewMux(loggerFactory, c.BaseURL, gitDescribe, c.Network, c.ICEServers, rooms, tracks)
-	l, err := net.Listen("tcp", net.JoinHostPort(c.BindHost, strconv.Itoa(c.BindPort)))
+	tracks := server.NewMemoryTracksManager(loggerFactory, c.Network.SFU)
+	tracks.SetAuditLog(newAuditLog(loggerFactory, c.Audit))
+	apiKeys := server.NewAPIKeys(loggerFactory, newAdapter.APIKeyStore)
+	webhooks := newWebhooks(loggerFactory, c.Webhook, apiKeys)
+	tracks.SetWebhooks(webhooks)
+	tracer := newTracer(loggerFactory, c.Tracing)
+	tracks.SetTracer(tracer)
+	var chatHistory *server.ChatHistory
+	if c.Chat.History > 0 {
+		chatHistory = server.NewChatHistory(loggerFactory, newAdapter.ChatStore, c.Chat.History)
+		tracks.SetChatHistory(chatHistory)
+	}
+	var eventLog *server.EventLog
+	if c.EventLog.MaxRoomEvents > 0 {
+		eventLog = server.NewEventLog(loggerFactory, newAdapter.EventLogStore, c.EventLog.MaxRoomEvents)
+		tracks.SetEventLog(eventLog)
+	}
+	tenants, err := server.NewTenants(c.Tenants)
+	panicOnError(err, "Error configuring tenants")
+	tracks.SetTenants(tenants)
+	var metering *server.Metering
+	if c.Metering.Interval > 0 {
+		metering = server.NewMetering(loggerFactory, newWebhooks(loggerFactory, c.Metering.Webhook, apiKeys))
+		tracks.Use(metering)
+		metering.Start(c.Metering.Interval)
+	}
+	if c.Transcription.Endpoint != "" && c.Network.Type == server.NetworkTypeSFU {
+		conn, err := server.DialGRPC(c.Transcription.Endpoint, c.Transcription.CA, c.Transcription.Secret, c.Transcription.Insecure)
+		panicOnError(err, "Error connecting to transcription service")
+		tracks.SetAudioSink(server.NewGRPCTranscriber(loggerFactory, conn, c.Transcription.Secret), c.Transcription)
+	}
+	mux := server.NewMux(loggerFactory, c.BaseURL, gitDescribe, c.Network, c.ICEServers, c.Admin, c.Inactivity, c.Client, rooms, tracks)
+	mux.SetHosts(c.Hosts)
+	mux.SetSocketIO(c.SocketIO)
+	mux.WSS.SetWebhooks(webhooks)
+	mux.WSS.SetEventLog(eventLog)
+	mux.WSS.SetMetering(metering)
+	mux.WSS.SetTenants(tenants)
+	mux.WSS.SetAPIKeys(apiKeys)
+	mux.WSS.SetTracer(tracer)
+	mux.WSS.SetRoomLifecycle(c.Lifecycle)
+	mux.WSS.SetCapacity(c.Capacity)
+	loadMonitor := server.NewLoadMonitor(loggerFactory, tracks.TrackStats)
+	loadMonitor.Start()
+	mux.WSS.SetLoadMonitor(loadMonitor)
+	tunables := server.NewRuntimeTunables(loggerFactory, tracks)
+	err = tunables.Set(c.Runtime)
+	panicOnError(err, "Error configuring runtime")
+	mux.WSS.SetRuntimeTunables(tunables)
+	mux.WSS.SetSFUStats(tracks.SFUStats)
+	if c.Network.SFU.DTLS.CertFile != "" && c.Network.Type == server.NetworkTypeSFU {
+		dtlsCertificates, err := server.NewDTLSCertificates(loggerFactory, c.Network.SFU.DTLS)
+		panicOnError(err, "Error configuring DTLS certificate")
+		dtlsCertificates.Start()
+		mux.WSS.SetDTLSCertificates(dtlsCertificates)
+	}
+	mux.WSS.SetRoomRegistry(server.NewRoomRegistry(loggerFactory, newAdapter.RoomStore, c.Registry))
+	mux.WSS.SetRoomFeatures(c.RoomFeatures)
+	tracks.SetChatAllowed(func(room string) bool {
+		return mux.WSS.RoomFeatures(room).Chat
+	})
+	mux.WSS.SetReactions(c.Reactions)
+	mux.WSS.SetSpatial(c.Spatial)
+	mux.WSS.SetRateLimit(c.RateLimit)
+	policy, err := server.NewConnectionPolicy(c.ConnectionPolicy)
+	panicOnError(err, "Error configuring connection policy")
+	mux.WSS.SetConnectionPolicy(policy)
+	mux.WSS.SetKeepalive(c.Keepalive, tracks)
+	tracks.SetBandwidthDisconnect(mux.WSS.Disconnect)
+	tracks.SetFloorChanged(mux.WSS.SendFloor)
+	tracks.SetNetworkQuality(mux.WSS.RTT, mux.WSS.SendNetworkQuality)
+	recordingDir := ""
+	if c.Network.Type == server.NetworkTypeSFU {
+		recordingDir = c.Recording.Dir
+	}
+	recorder := server.NewRoomRecorder(loggerFactory, recordingDir)
+	recorder.SetWebhooks(webhooks)
+	recorder.SetEventLog(eventLog)
+	recorder.SetMetering(metering)
+	uploads, err := newUploads(loggerFactory, c.Storage, webhooks)
+	panicOnError(err, "Error configuring storage")
+	recordingJobs, err := server.NewRecordingJobs(loggerFactory, c.Recording, uploads, webhooks)
+	panicOnError(err, "Error configuring recording post-processing")
+	if recordingJobs != nil {
+		recorder.SetRecordingJobs(recordingJobs)
+	} else {
+		recorder.SetUploads(uploads)
+	}
+	mux.WSS.SetRecordingJobs(recordingJobs)
+	if recordingDir != "" {
+		tracks.Use(recorder)
+	}
+	mux.Digests.SetRecorder(recorder)
+	mux.WSS.SetModeration(tracks, recorder)
+	if c.Egress.Endpoint != "" {
+		egress, err := newEgress(c.Egress)
+		panicOnError(err, "Error connecting to egress service")
+		mux.Egress.SetService(egress, c.Egress.NodeURL)
+	}
+	var cluster *server.Cluster
+	if c.Cluster.NodeURL != "" {
+		cluster = server.NewCluster(loggerFactory, newAdapter.ClusterStore, c.Cluster, mux.WSS)
+		mux.WSS.SetCluster(cluster)
+		cluster.Start()
+	}
+	mux.WSS.SetRoomClosed(func(room string, closed server.RoomClosed) {
+		// recordings must not continue into the next meeting in the same room
+		recorder.StopStartedBefore(room, closed.ClosedAt)
+		mux.Egress.StopRoom(context.Background(), room)
+		uploads.UploadChat(room, closed.MeetingID, chatHistory.Messages(room))
+		chatHistory.Delete(room)
+		tracks.ReturnToMainRoom(room)
+		cluster.Release(room)
+	})
+	if c.Digest.Interval > 0 {
+		mux.Digests.Start(c.Digest.Interval)
+	}
+	if c.Admin.Token != "" && c.Admin.GRPCListenAddr != "" {
+		startAdminRPC(loggerFactory, c, mux.WSS, tracks, recorder)
+	}
+	startSignaling(loggerFactory, c.Signaling, mux.Signaling)
+	if c.SIP.ListenAddr != "" && c.Network.Type == server.NetworkTypeSFU {
+		startSIPGateway(loggerFactory, c.SIP, tracks, mux.Signaling)
+	}
+	reloader := server.NewConfigReloader(loggerFactory, c, func() (server.Config, error) {
+		return readConfig(configFiles)
+	})
+	reloader.OnReload(func(c server.Config) {
+		mux.SetICEServers(c.ICEServers)
+		mux.SetClientConfig(c.Client)
+		mux.WSS.SetCapacity(c.Capacity)
+		if err := tunables.Set(c.Runtime); err != nil {
+			log.Errorf("Error configuring runtime: %s", err)
+		}
+		if policy, err := server.NewConnectionPolicy(c.ConnectionPolicy); err != nil {
+			log.Errorf("Error configuring connection policy: %s", err)
+		} else {
+			mux.WSS.SetConnectionPolicy(policy)
+		}
+		if err := setLogLevel(loggerFactory, c.Log); err != nil {
+			log.Errorf("Error setting log level: %s", err)
+		}
+	})
+	reloadOnSignal(log, reloader)
+	handover := server.NewHandover(loggerFactory, c.Upgrade)
+	l, err := handover.Listen(net.JoinHostPort(c.BindHost, strconv.Itoa(c.BindPort)))
 	panicOnError(err, "Error starting server listener")
 	addr := l.Addr().(*net.TCPAddr)
 	log.Printf("Listening on: %s", addr.String())
-	server := server.NewStartStopper(server.ServerParams{
+	httpServer := server.NewStartStopper(server.ServerParams{
 		TLSCertFile: c.TLS.Cert,
 		TLSKeyFile:  c.TLS.Key,
+		Hosts:       c.Hosts,
 	}, mux)
-	err = server.Start(l)
-	panicOnError(err, "Error starting server")
+	drained := make(chan struct{})
+	var drainOnce sync.Once
+	startDrain := func() {
+		drainOnce.Do(func() {
+			go func() {
+				drain(log, c.Upgrade, httpServer, mux.WSS)
+				close(drained)
+			}()
+		})
+	}
+	if c.Upgrade.SocketPath != "" {
+		go func() {
+			if err := handover.Serve(l); err != nil {
+				log.Errorf("Error accepting handovers: %s", err)
+				return
+			}
+			startDrain()
+		}()
+	}
+	if c.Upgrade.SocketPath != "" || c.Upgrade.ReusePort {
+		drainOnSignal(log, startDrain)
+	}
+	err = httpServer.Start(l)
+	if err != http.ErrServerClosed {
+		panicOnError(err, "Error starting server")
+	}
+	<-drained
 }
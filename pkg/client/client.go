@@ -0,0 +1,336 @@
+// Package client implements the signaling protocol of Peer Calls and the
+// setup of a peer connection to the SFU, so that Go programs can join rooms
+// as bots, for example to inject media, to record or to run load tests.
+//
+// A client is connected over any signaling transport: websockets with
+// DialWebSocket, or the gRPC and TCP transports of the server package with
+// server.DialGRPCSignaling and server.DialTCPSignaling.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/peer-calls/peer-calls/server/logger"
+	"github.com/pion/webrtc/v2"
+)
+
+// serverPeerID is the ID of the SFU in signal messages.
+const serverPeerID = "__SERVER__"
+
+// ErrMeshRoom is returned by Join when the server does not run in SFU mode,
+// in which clients connect to each other instead of to the server.
+var ErrMeshRoom = errors.New("client: only rooms of an SFU can be joined")
+
+// Params configure a Client.
+type Params struct {
+	Room     string
+	ClientID string
+	// Nickname is shown to other participants. Defaults to ClientID.
+	Nickname string
+	// ICEServers are used by the peer connection to the SFU.
+	ICEServers []webrtc.ICEServer
+	// LoggerFactory creates the loggers of the client. Logs are discarded
+	// when it is not set.
+	LoggerFactory server.LoggerFactory
+
+	// OnTrack is called on a goroutine of its own for every track of other
+	// participants forwarded by the SFU. RTP packets must be read from the
+	// track until it returns an error.
+	OnTrack func(track *webrtc.Track, receiver *webrtc.RTPReceiver)
+	// OnMessage is called with all messages except signals, like chat
+	// messages and room events. It must not block.
+	OnMessage func(message server.Message)
+}
+
+// Client is a participant of a room connected to the SFU.
+type Client struct {
+	log    server.Logger
+	params Params
+
+	conn           server.SignalingConn
+	client         *server.Client
+	peerConnection *webrtc.PeerConnection
+	signaller      *server.Signaller
+	// calls are run by processMessages, which is the only goroutine
+	// changing the peer connection
+	calls chan func()
+
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Join joins the room over conn and waits until the peer connection to the
+// SFU is connected or ctx is done. conn is closed when joining fails.
+func Join(ctx context.Context, conn server.SignalingConn, params Params) (*Client, error) {
+	if params.Nickname == "" {
+		params.Nickname = params.ClientID
+	}
+	if params.LoggerFactory == nil {
+		params.LoggerFactory = logger.NewFactory(ioutil.Discard, nil)
+	}
+
+	subscribeCtx, cancel := context.WithCancel(context.Background())
+
+	c := &Client{
+		log: params.LoggerFactory.GetLogger("client").WithCtx(server.LogCtx{
+			"room":     params.Room,
+			"clientID": params.ClientID,
+		}),
+		params: params,
+		conn:   conn,
+		client: server.NewClientWithID(conn, params.ClientID),
+		calls:  make(chan func()),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	msgChan := c.client.Subscribe(subscribeCtx)
+
+	if err := c.join(ctx, msgChan); err != nil {
+		cancel()
+		conn.Close(nil)
+		if c.signaller != nil {
+			// also closes the peer connection
+			c.signaller.Close()
+		} else if c.peerConnection != nil {
+			c.peerConnection.Close()
+		}
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Client) join(ctx context.Context, msgChan <-chan server.Message) error {
+	err := c.client.Write(server.NewMessage("ready", c.params.Room, map[string]interface{}{
+		"nickname": c.params.Nickname,
+	}))
+	if err != nil {
+		return fmt.Errorf("client: send ready: %w", err)
+	}
+
+	if err := c.waitUsers(ctx, msgChan); err != nil {
+		return err
+	}
+
+	mediaEngine := webrtc.MediaEngine{}
+	mediaEngine.RegisterDefaultCodecs()
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine))
+
+	c.peerConnection, err = api.NewPeerConnection(webrtc.Configuration{
+		ICEServers: c.params.ICEServers,
+	})
+	if err != nil {
+		return fmt.Errorf("client: create peer connection: %w", err)
+	}
+
+	connected := make(chan struct{})
+	var connectedOnce sync.Once
+	c.peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		c.log.Debugf("Peer connection state: %s", state)
+		if state == webrtc.PeerConnectionStateConnected {
+			connectedOnce.Do(func() { close(connected) })
+		}
+	})
+
+	if onTrack := c.params.OnTrack; onTrack != nil {
+		c.peerConnection.OnTrack(onTrack)
+	}
+
+	c.signaller, err = server.NewSignaller(
+		c.params.LoggerFactory,
+		false,
+		c.peerConnection,
+		&mediaEngine,
+		c.params.ClientID,
+		serverPeerID,
+	)
+	if err != nil {
+		return fmt.Errorf("client: create signaller: %w", err)
+	}
+
+	go c.processMessages(msgChan)
+
+	select {
+	case <-connected:
+		return nil
+	case <-c.done:
+		return fmt.Errorf("client: signaling ended before connecting: %w", c.Err())
+	case <-ctx.Done():
+		return fmt.Errorf("client: connect: %w", ctx.Err())
+	}
+}
+
+// waitUsers waits for the users message the server sends after ready, which
+// tells whether the room is served by the SFU.
+func (c *Client) waitUsers(ctx context.Context, msgChan <-chan server.Message) error {
+	for {
+		select {
+		case msg, ok := <-msgChan:
+			if !ok {
+				return fmt.Errorf("client: join: %w", c.client.Err())
+			}
+
+			switch msg.Type {
+			case server.MessageTypeJoinError:
+				payload, _ := msg.Payload.(map[string]interface{})
+				code, _ := payload["code"].(string)
+				message, _ := payload["message"].(string)
+				return &server.JoinError{Code: code, Message: message}
+			case "users":
+				payload, _ := msg.Payload.(map[string]interface{})
+				if initiator, _ := payload["initiator"].(string); initiator != serverPeerID {
+					return ErrMeshRoom
+				}
+				return nil
+			default:
+				c.onMessage(msg)
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("client: join: %w", ctx.Err())
+		}
+	}
+}
+
+// processMessages passes signals between the server and the signaller until
+// the signaling connection ends. Remote descriptions are set on this
+// goroutine, so tracks are added and removed on it, too, see call.
+func (c *Client) processMessages(msgChan <-chan server.Message) {
+	defer close(c.done)
+
+	signalChan := c.signaller.SignalChannel()
+
+	for {
+		select {
+		case msg, ok := <-msgChan:
+			if !ok {
+				return
+			}
+
+			if msg.Type != "signal" {
+				c.onMessage(msg)
+				continue
+			}
+
+			payload, _ := msg.Payload.(map[string]interface{})
+			if err := c.addReceivers(payload); err != nil {
+				c.log.Errorf("Error adding receivers: %s", err)
+			}
+			if err := c.signaller.Signal(payload); err != nil {
+				c.log.Errorf("Error processing signal: %s", err)
+			}
+		case signal, ok := <-signalChan:
+			if !ok {
+				signalChan = nil
+				continue
+			}
+
+			if err := c.Send("signal", signal); err != nil {
+				c.log.Errorf("Error sending signal: %s", err)
+			}
+		case fn := <-c.calls:
+			fn()
+		}
+	}
+}
+
+// call runs fn on the goroutine of processMessages and returns its error, or
+// an error when the signaling connection has ended.
+func (c *Client) call(fn func() error) error {
+	errChan := make(chan error, 1)
+	select {
+	case c.calls <- func() { errChan <- fn() }:
+		return <-errChan
+	case <-c.done:
+		return fmt.Errorf("client: signaling ended: %v", c.Err())
+	}
+}
+
+func (c *Client) onMessage(msg server.Message) {
+	if onMessage := c.params.OnMessage; onMessage != nil {
+		onMessage(msg)
+	}
+}
+
+// ID returns the ID of the client.
+func (c *Client) ID() string {
+	return c.params.ClientID
+}
+
+// PeerConnection returns the peer connection to the SFU. It can be used to
+// create tracks with the codecs registered in its media engine, for example
+// with NewTrack(webrtc.DefaultPayloadTypeOpus, ssrc, id, label).
+func (c *Client) PeerConnection() *webrtc.PeerConnection {
+	return c.peerConnection
+}
+
+// Publish sends track to the SFU, which forwards it to the other
+// participants of the room. Samples or RTP packets written to track are sent
+// once the peer connection has been renegotiated.
+func (c *Client) Publish(track *webrtc.Track) (*webrtc.RTPSender, error) {
+	var sender *webrtc.RTPSender
+	err := c.call(func() error {
+		// a sendonly transceiver of its own, AddTrack would reuse one
+		// receiving tracks of other participants, and change its sender
+		// while pion starts the senders of the last negotiation
+		transceiver, err := c.peerConnection.AddTransceiverFromTrack(track, webrtc.RtpTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionSendonly,
+		})
+		if err != nil {
+			return fmt.Errorf("client: add track: %w", err)
+		}
+		sender = transceiver.Sender()
+
+		// the SFU is the initiator, it adds a recvonly transceiver on its
+		// side and sends an offer. The SFU receives tracks by SSRC, so it
+		// does not matter which media section the track is negotiated in.
+		c.signaller.SendTransceiverRequest(track.Kind(), webrtc.RTPTransceiverDirectionSendonly)
+		return nil
+	})
+	return sender, err
+}
+
+// Unpublish stops sending the track of sender.
+func (c *Client) Unpublish(sender *webrtc.RTPSender) error {
+	return c.call(func() error {
+		if err := c.peerConnection.RemoveTrack(sender); err != nil {
+			return fmt.Errorf("client: remove track: %w", err)
+		}
+
+		c.signaller.Negotiate()
+		return nil
+	})
+}
+
+// Send sends a message to the room, for example a chat message.
+func (c *Client) Send(typ string, payload interface{}) error {
+	return c.client.Write(server.NewMessage(typ, c.params.Room, payload))
+}
+
+// Done is closed when the signaling connection has ended.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error which ended the signaling connection.
+func (c *Client) Err() error {
+	return c.client.Err()
+}
+
+// Close leaves the room and closes the peer connection.
+func (c *Client) Close() (err error) {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		err = c.conn.Close(nil)
+		if closeErr := c.signaller.Close(); err == nil {
+			err = closeErr
+		}
+	})
+	return err
+}
@@ -0,0 +1,248 @@
+package client_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/pkg/client"
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/peer-calls/peer-calls/server/logger"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	roomName = "test-room"
+	timeout  = 10 * time.Second
+)
+
+var loggerFactory = logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout)
+
+func newSFUServer() *httptest.Server {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	return httptest.NewServer(server.NewSFUHandler(
+		loggerFactory,
+		server.NewWSS(loggerFactory, rooms),
+		func() []server.ICEServer { return nil },
+		server.NetworkConfigSFU{},
+		server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{}),
+		nil,
+	))
+}
+
+func join(t *testing.T, ctx context.Context, baseURL string, params client.Params) *client.Client {
+	t.Helper()
+
+	conn, err := client.DialWebSocket(ctx, baseURL, server.SignalingHello{
+		Room:     params.Room,
+		ClientID: params.ClientID,
+	})
+	require.NoError(t, err)
+
+	params.LoggerFactory = loggerFactory
+	c, err := client.Join(ctx, conn, params)
+	require.NoError(t, err)
+	return c
+}
+
+func TestClient_publish(t *testing.T) {
+	srv := newSFUServer()
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	received := make(chan *rtp.Packet, 16)
+	subscriber := join(t, ctx, srv.URL, client.Params{
+		Room:     roomName,
+		ClientID: "subscriber",
+		OnTrack: func(track *webrtc.Track, receiver *webrtc.RTPReceiver) {
+			for {
+				packet, err := track.ReadRTP()
+				if err != nil {
+					return
+				}
+				select {
+				case received <- packet:
+				default:
+				}
+			}
+		},
+	})
+	defer subscriber.Close()
+
+	publisher1 := join(t, ctx, srv.URL, client.Params{Room: roomName, ClientID: "publisher1"})
+	defer publisher1.Close()
+	publisher2 := join(t, ctx, srv.URL, client.Params{Room: roomName, ClientID: "publisher2"})
+	defer publisher2.Close()
+
+	// the second publisher publishes two tracks of the same kind
+	var tracks []*webrtc.Track
+	for i, publisher := range []*client.Client{publisher1, publisher2, publisher2} {
+		track, err := publisher.PeerConnection().NewTrack(webrtc.DefaultPayloadTypeOpus, uint32(1000+i), fmt.Sprintf("audio-%d", i), publisher.ID())
+		require.NoError(t, err)
+		_, err = publisher.Publish(track)
+		require.NoError(t, err)
+		tracks = append(tracks, track)
+	}
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	var sequenceNumber uint16
+	// the second byte of payloads identifies the track
+	receivedTracks := map[byte]struct{}{}
+
+	for len(receivedTracks) < len(tracks) {
+		select {
+		case packet := <-received:
+			receivedTracks[packet.Payload[1]] = struct{}{}
+		case <-ticker.C:
+			sequenceNumber++
+			for i, track := range tracks {
+				_ = track.WriteRTP(&rtp.Packet{
+					Header: rtp.Header{
+						Version:        2,
+						PayloadType:    webrtc.DefaultPayloadTypeOpus,
+						SequenceNumber: sequenceNumber,
+						Timestamp:      uint32(sequenceNumber) * 960,
+						SSRC:           track.SSRC(),
+					},
+					Payload: []byte{0xfc, byte(i)},
+				})
+			}
+		case <-ctx.Done():
+			t.Fatalf("received %d of %d published tracks: %s", len(receivedTracks), len(tracks), ctx.Err())
+		}
+	}
+}
+
+func TestClient_publishToRoomWithTracks(t *testing.T) {
+	srv := newSFUServer()
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	received := make(chan *rtp.Packet, 16)
+	subscriber := join(t, ctx, srv.URL, client.Params{
+		Room:     roomName,
+		ClientID: "subscriber",
+		OnTrack: func(track *webrtc.Track, receiver *webrtc.RTPReceiver) {
+			for {
+				packet, err := track.ReadRTP()
+				if err != nil {
+					return
+				}
+				select {
+				case received <- packet:
+				default:
+				}
+			}
+		},
+	})
+	defer subscriber.Close()
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	var sequenceNumber uint16
+	var tracks []*webrtc.Track
+	receivedTracks := map[byte]struct{}{}
+
+	// the second publisher joins when the track of the first one is
+	// forwarded already, and publishes in the same renegotiation
+	for i, clientID := range []string{"publisher1", "publisher2"} {
+		publisher := join(t, ctx, srv.URL, client.Params{Room: roomName, ClientID: clientID})
+		defer publisher.Close()
+
+		track, err := publisher.PeerConnection().NewTrack(webrtc.DefaultPayloadTypeOpus, uint32(1000+i), "audio", clientID)
+		require.NoError(t, err)
+		_, err = publisher.Publish(track)
+		require.NoError(t, err)
+		tracks = append(tracks, track)
+
+		for len(receivedTracks) < len(tracks) {
+			select {
+			case packet := <-received:
+				receivedTracks[packet.Payload[1]] = struct{}{}
+			case <-ticker.C:
+				sequenceNumber++
+				for i, track := range tracks {
+					_ = track.WriteRTP(&rtp.Packet{
+						Header: rtp.Header{
+							Version:        2,
+							PayloadType:    webrtc.DefaultPayloadTypeOpus,
+							SequenceNumber: sequenceNumber,
+							Timestamp:      uint32(sequenceNumber) * 960,
+							SSRC:           track.SSRC(),
+						},
+						Payload: []byte{0xfc, byte(i)},
+					})
+				}
+			case <-ctx.Done():
+				t.Fatalf("received %d of %d published tracks: %s", len(receivedTracks), len(tracks), ctx.Err())
+			}
+		}
+	}
+}
+
+func TestClient_messages(t *testing.T) {
+	srv := newSFUServer()
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	messages := make(chan server.Message, 16)
+	c1 := join(t, ctx, srv.URL, client.Params{
+		Room:     roomName,
+		ClientID: "bot1",
+		OnMessage: func(message server.Message) {
+			messages <- message
+		},
+	})
+	defer c1.Close()
+
+	c2 := join(t, ctx, srv.URL, client.Params{Room: roomName, ClientID: "bot2"})
+	defer c2.Close()
+
+	require.NoError(t, c2.Send(server.MessageTypeReaction, map[string]string{"reaction": "👍"}))
+
+	for {
+		select {
+		case msg := <-messages:
+			if msg.Type == server.MessageTypeReaction {
+				assert.Equal(t, map[string]interface{}{"userId": "bot2", "reaction": "👍"}, msg.Payload)
+				return
+			}
+		case <-ctx.Done():
+			t.Fatalf("did not receive message: %s", ctx.Err())
+		}
+	}
+}
+
+func TestClient_meshRoom(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	srv := httptest.NewServer(server.NewMeshHandler(loggerFactory, server.NewWSS(loggerFactory, rooms)))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := client.DialWebSocket(ctx, srv.URL, server.SignalingHello{Room: roomName, ClientID: "bot"})
+	require.NoError(t, err)
+
+	_, err = client.Join(ctx, conn, client.Params{Room: roomName, ClientID: "bot"})
+	assert.Equal(t, client.ErrMeshRoom, err)
+}
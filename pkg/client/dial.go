@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/peer-calls/peer-calls/server"
+	"nhooyr.io/websocket"
+)
+
+// DialWebSocket connects to the websocket signaling endpoint of the server
+// at baseURL, for example https://example.com or ws://localhost:3000/calls.
+// The room and client ID of hello select the endpoint, the other fields are
+// sent as query parameters.
+func DialWebSocket(ctx context.Context, baseURL string, hello server.SignalingHello) (server.SignalingConn, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("client: parse URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/ws/" + url.PathEscape(hello.Room) + "/" + url.PathEscape(hello.ClientID)
+
+	query := url.Values{}
+	if hello.Password != "" {
+		query.Set("password", hello.Password)
+	}
+	if hello.EgressToken != "" {
+		query.Set("egressToken", hello.EgressToken)
+	}
+	if hello.Token != "" {
+		query.Set("token", hello.Token)
+	}
+	if hello.TraceParent != "" {
+		query.Set("traceparent", hello.TraceParent)
+	}
+	u.RawQuery = query.Encode()
+
+	conn, _, err := websocket.Dial(ctx, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("client: dial %s: %w", u, err)
+	}
+
+	return server.NewWebSocketSignalingConn(conn), nil
+}
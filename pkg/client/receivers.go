@@ -0,0 +1,58 @@
+package client
+
+import (
+	"github.com/pion/sdp/v2"
+	"github.com/pion/webrtc/v2"
+)
+
+// addReceivers adds receiving transceivers before an offer of the SFU is
+// handled, so that all tracks offered can be received.
+//
+// Incoming SSRCs are assigned to any transceiver of the same kind which can
+// receive and has not received yet, regardless of the media section they are
+// offered in. Transceivers created for media sections the SFU offered to
+// receive in cannot receive, so the SFU reusing them for tracks of other
+// participants would leave tracks without a receiver.
+func (c *Client) addReceivers(payload map[string]interface{}) error {
+	signal, _ := payload["signal"].(map[string]interface{})
+	if typ, _ := signal["type"].(string); typ != webrtc.SDPTypeOffer.String() {
+		return nil
+	}
+	offer, _ := signal["sdp"].(string)
+
+	parsed := sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(offer)); err != nil {
+		return err
+	}
+
+	// missing is the number of receivers missing per kind
+	missing := map[webrtc.RTPCodecType]int{}
+	for _, media := range parsed.MediaDescriptions {
+		kind := webrtc.NewRTPCodecType(media.MediaName.Media)
+		if _, ok := media.Attribute("ssrc"); kind != 0 && ok {
+			missing[kind]++
+		}
+	}
+
+	for _, transceiver := range c.peerConnection.GetTransceivers() {
+		switch transceiver.Direction() {
+		case webrtc.RTPTransceiverDirectionRecvonly, webrtc.RTPTransceiverDirectionSendrecv:
+			if transceiver.Receiver() != nil {
+				missing[transceiver.Kind()]--
+			}
+		}
+	}
+
+	for kind, count := range missing {
+		for i := 0; i < count; i++ {
+			_, err := c.peerConnection.AddTransceiverFromKind(kind, webrtc.RtpTransceiverInit{
+				Direction: webrtc.RTPTransceiverDirectionRecvonly,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -12,6 +12,16 @@ type AdapterFactory struct {
 	subClient *redis.Client
 
 	NewAdapter func(room string) Adapter
+	// ChatStore keeps chat messages in the same store as the adapters.
+	ChatStore ChatStore
+	// RoomStore keeps registered rooms in the same store as the adapters.
+	RoomStore RoomStore
+	// EventLogStore keeps room events in the same store as the adapters.
+	EventLogStore EventLogStore
+	// APIKeyStore keeps API keys in the same store as the adapters.
+	APIKeyStore APIKeyStore
+	// ClusterStore keeps cluster nodes in the same store as the adapters.
+	ClusterStore ClusterStore
 }
 
 func NewAdapterFactory(
@@ -36,11 +46,21 @@ func NewAdapterFactory(
 		f.NewAdapter = func(room string) Adapter {
 			return NewRedisAdapter(loggerFactory, f.pubClient, f.subClient, prefix, room)
 		}
+		f.ChatStore = NewRedisChatStore(f.pubClient, prefix)
+		f.RoomStore = NewRedisRoomStore(f.pubClient, prefix)
+		f.EventLogStore = NewRedisEventLogStore(f.pubClient, prefix)
+		f.APIKeyStore = NewRedisAPIKeyStore(f.pubClient, prefix)
+		f.ClusterStore = NewRedisClusterStore(f.pubClient, prefix)
 	default:
 		log.Printf("Using MemoryAdapter")
 		f.NewAdapter = func(room string) Adapter {
 			return NewMemoryAdapter(room)
 		}
+		f.ChatStore = NewMemoryChatStore()
+		f.RoomStore = NewMemoryRoomStore()
+		f.EventLogStore = NewMemoryEventLogStore()
+		f.APIKeyStore = NewMemoryAPIKeyStore()
+		f.ClusterStore = NewMemoryClusterStore()
 	}
 
 	return &f
@@ -0,0 +1,1343 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/pprof"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	AdminOperationCloseRoom     = "closeRoom"
+	AdminOperationKickClient    = "kickClient"
+	AdminOperationExpireClient  = "expireClient"
+	AdminOperationStopBreakouts = "stopBreakouts"
+
+	AdminOperationDeleteRegisteredRoom = "deleteRegisteredRoom"
+)
+
+// AdminOperationResult describes the effects of a destructive admin
+// operation. When DryRun is set, the operation has not been performed and
+// the result lists what would have been affected.
+type AdminOperationResult struct {
+	Operation string   `json:"operation"`
+	DryRun    bool     `json:"dryRun"`
+	Room      string   `json:"room"`
+	ClientIDs []string `json:"clientIds"`
+}
+
+type AdminError struct {
+	Error string `json:"error"`
+}
+
+// AdminMaxBitrate is the request and response body of the max-bitrate
+// endpoint. MaxBitrate is in bits per second, zero restores the maximum
+// bitrate of the room.
+type AdminMaxBitrate struct {
+	Room       string `json:"room"`
+	ClientID   string `json:"clientId"`
+	MaxBitrate int    `json:"maxBitrate"`
+}
+
+// AdminBreakouts is the request body of the breakouts endpoint. Key of Rooms
+// is the name of a breakout room, value are clientIDs moved to it.
+type AdminBreakouts struct {
+	Room  string              `json:"room"`
+	Rooms map[string][]string `json:"rooms"`
+}
+
+// AdminRoomNode is the response body of the cluster room endpoint.
+type AdminRoomNode struct {
+	Room string `json:"room"`
+	Node Node   `json:"node"`
+}
+
+// AdminRuntime is the request and response body of the runtime endpoint.
+// Intervals are in milliseconds. Fields missing from requests keep their
+// current values, and zero values restore the defaults.
+type AdminRuntime struct {
+	GOMAXPROCS           int   `json:"gomaxprocs"`
+	SendQueueSize        int   `json:"sendQueueSize"`
+	NACKBufferSize       int   `json:"nackBufferSize"`
+	PLIInterval          int64 `json:"pliInterval"`
+	PLIMinInterval       int64 `json:"pliMinInterval"`
+	PLIMinIntervalScreen int64 `json:"pliMinIntervalScreen"`
+}
+
+func newAdminRuntime(config RuntimeConfig) AdminRuntime {
+	return AdminRuntime{
+		GOMAXPROCS:           config.GOMAXPROCS,
+		SendQueueSize:        config.SendQueueSize,
+		NACKBufferSize:       config.NACKBufferSize,
+		PLIInterval:          int64(config.PLIInterval / time.Millisecond),
+		PLIMinInterval:       int64(config.PLIMinInterval / time.Millisecond),
+		PLIMinIntervalScreen: int64(config.PLIMinIntervalScreen / time.Millisecond),
+	}
+}
+
+func (r AdminRuntime) config() RuntimeConfig {
+	return RuntimeConfig{
+		GOMAXPROCS:           r.GOMAXPROCS,
+		SendQueueSize:        r.SendQueueSize,
+		NACKBufferSize:       r.NACKBufferSize,
+		PLIInterval:          time.Duration(r.PLIInterval) * time.Millisecond,
+		PLIMinInterval:       time.Duration(r.PLIMinInterval) * time.Millisecond,
+		PLIMinIntervalScreen: time.Duration(r.PLIMinIntervalScreen) * time.Millisecond,
+	}
+}
+
+// AdminRole is the request and response body of the role endpoint.
+type AdminRole struct {
+	Room     string `json:"room"`
+	ClientID string `json:"clientId"`
+	Role     Role   `json:"role"`
+}
+
+// AdminAPIKey is the response body of the endpoints which issue, rotate and
+// revoke API keys. Key and WebhookSecret are only returned once, and are
+// empty when DryRun is set.
+type AdminAPIKey struct {
+	APIKey
+	Key           string `json:"key"`
+	WebhookSecret string `json:"webhookSecret,omitempty"`
+	DryRun        bool   `json:"dryRun,omitempty"`
+}
+
+// AdminRoomLock is the response body of the lock endpoint.
+type AdminRoomLock struct {
+	Room   string `json:"room"`
+	Locked bool   `json:"locked"`
+}
+
+// Public returns the settings of r without the password hash.
+func (r RegisteredRoom) Public() AdminRegisteredRoom {
+	return AdminRegisteredRoom{
+		Name:            r.Name,
+		HasPassword:     r.PasswordHash != "",
+		MaxParticipants: r.MaxParticipants,
+		StartsAt:        r.StartsAt,
+		EndsAt:          r.EndsAt,
+		Disabled:        r.Disabled,
+		Features:        r.Features,
+	}
+}
+
+// AdminRegisteredRoom is the response body of the registry endpoints.
+type AdminRegisteredRoom struct {
+	Name            string        `json:"name"`
+	HasPassword     bool          `json:"hasPassword"`
+	MaxParticipants int           `json:"maxParticipants"`
+	StartsAt        *time.Time    `json:"startsAt,omitempty"`
+	EndsAt          *time.Time    `json:"endsAt,omitempty"`
+	Disabled        bool          `json:"disabled"`
+	Features        *RoomFeatures `json:"features,omitempty"`
+}
+
+type AdminAudioInjection struct {
+	ID   string `json:"id"`
+	Room string `json:"room"`
+}
+
+// maxInjectedAudioSize limits the size of uploaded audio files.
+const maxInjectedAudioSize = 16 << 20
+
+type AudioInjector interface {
+	InjectAudio(room string, reader io.Reader) (string, error)
+	InjectDTMF(room string, digits string) (string, error)
+	StopAudio(room string, id string) bool
+}
+
+type MediaIngester interface {
+	Ingest(room string, req IngestRequest) (IngestSource, error)
+	StopIngest(room string, id string) bool
+}
+
+type PeerRemover interface {
+	HasPeer(clientID string) bool
+	RemovePeer(clientID string) bool
+}
+
+type PeerLocator interface {
+	HasPeerInRoom(room string, clientID string) bool
+}
+
+type BitrateLimiter interface {
+	SetMaxBitrate(clientID string, maxBitrate int) error
+}
+
+type BreakoutMover interface {
+	MoveToBreakoutRooms(room string, clientIDsByName map[string][]string) error
+	ReturnToMainRoom(room string) []string
+}
+
+type PacketCapturer interface {
+	StartCapture(room string, clientID string, req CaptureRequest) (PacketCapture, error)
+	StopCapture(clientID string, id string) bool
+	OpenCapture(name string) (*os.File, error)
+}
+
+// AdminTracksManager is the part of TracksManager used by the admin API.
+type AdminTracksManager interface {
+	AudioInjector
+	MediaIngester
+	PeerRemover
+	PeerLocator
+	BitrateLimiter
+	BreakoutMover
+	PacketCapturer
+}
+
+type adminAPI struct {
+	log    Logger
+	wss    *WSS
+	tracks AdminTracksManager
+	egress *Egresses
+}
+
+// NewAdminHandler creates a handler for the admin REST API. All requests
+// must have the Authorization header set to "Bearer <token>", to the API key
+// of a tenant, in which case room names are within the namespace of the
+// tenant, or to an issued API key. When API keys are required, the admin
+// token can only manage API keys. Destructive operations accept a dryRun
+// query parameter.
+func NewAdminHandler(loggerFactory LoggerFactory, admin AdminConfig, wss *WSS, tracks AdminTracksManager, egress *Egresses) http.Handler {
+	api := &adminAPI{
+		log:    loggerFactory.GetLogger("admin"),
+		wss:    wss,
+		tracks: tracks,
+		egress: egress,
+	}
+
+	router := chi.NewRouter()
+	router.Group(func(router chi.Router) {
+		router.Use(adminAuth(admin.Token, wss), adminTokenScope)
+		router.Get("/keys", api.listAPIKeys)
+		router.Post("/keys", api.issueAPIKey)
+		router.Post("/keys/{id}/rotate", api.rotateAPIKey)
+		router.Delete("/keys/{id}", api.revokeAPIKey)
+	})
+	router.Group(func(router chi.Router) {
+		router.Use(adminAuth(admin.APIToken(), wss))
+		router.Delete("/rooms/{room}", api.closeRoom)
+		router.With(api.tenantClient).Delete("/rooms/{room}/clients/{clientID}", api.kickClient)
+		router.With(api.tenantClient).Post("/rooms/{room}/clients/{clientID}/expire", api.expireClient)
+		router.With(api.tenantClient).Put("/rooms/{room}/clients/{clientID}/max-bitrate", api.setMaxBitrate)
+		router.With(api.tenantClient).Put("/rooms/{room}/clients/{clientID}/role", api.setRole)
+		router.Get("/rooms/{room}/events", api.listEvents)
+		router.Get("/usage", api.listUsage)
+		router.Get("/rooms/{room}/usage", api.getUsage)
+		router.Get("/registry/rooms", api.listRegisteredRooms)
+		router.Get("/registry/rooms/{room}", api.getRegisteredRoom)
+		router.Put("/registry/rooms/{room}", api.saveRegisteredRoom)
+		router.Delete("/registry/rooms/{room}", api.deleteRegisteredRoom)
+		router.Post("/rooms/{room}/lock", api.lockRoom)
+		router.Delete("/rooms/{room}/lock", api.unlockRoom)
+		router.Post("/rooms/{room}/breakouts", api.startBreakouts)
+		router.Delete("/rooms/{room}/breakouts", api.stopBreakouts)
+		router.Post("/rooms/{room}/audio", api.injectAudio)
+		router.Delete("/rooms/{room}/audio/{id}", api.stopAudio)
+		router.Post("/rooms/{room}/dtmf", api.injectDTMF)
+		router.Post("/rooms/{room}/ingest", api.startIngest)
+		router.Delete("/rooms/{room}/ingest/{id}", api.stopIngest)
+		router.Get("/rooms/{room}/egress", api.listEgress)
+		router.Post("/rooms/{room}/egress", api.startEgress)
+		router.Delete("/rooms/{room}/egress/{id}", api.stopEgress)
+		router.With(api.tenantClient).Post("/rooms/{room}/clients/{clientID}/captures", api.startCapture)
+		router.With(api.tenantClient).Delete("/rooms/{room}/clients/{clientID}/captures/{id}", api.stopCapture)
+		router.With(adminScope).Get("/captures/{name}", api.downloadCapture)
+		router.Get("/recordings/jobs", api.listRecordingJobs)
+		router.Get("/recordings/{id}/job", api.getRecordingJob)
+		router.With(adminScope).Get("/load", api.getLoad)
+		router.With(adminScope).Get("/runtime", api.getRuntime)
+		router.With(adminScope).Put("/runtime", api.setRuntime)
+		router.With(adminScope).Get("/dtls", api.getDTLS)
+		router.With(adminScope).Get("/sfu/stats", api.getSFUStats)
+		if admin.Pprof {
+			router.With(adminScope).Get("/debug/pprof/", pprof.Index)
+			router.With(adminScope).Get("/debug/pprof/cmdline", pprof.Cmdline)
+			router.With(adminScope).Get("/debug/pprof/profile", pprof.Profile)
+			router.With(adminScope).Get("/debug/pprof/symbol", pprof.Symbol)
+			router.With(adminScope).Get("/debug/pprof/trace", pprof.Trace)
+			router.With(adminScope).Get("/debug/pprof/{name}", servePprofProfile)
+		}
+		router.With(adminScope).Get("/cluster/nodes", api.listNodes)
+		router.Get("/cluster/rooms/{room}", api.getRoomNode)
+		router.Post("/cluster/rooms/{room}", api.assignRoomNode)
+	})
+
+	return router
+}
+
+// adminAuth accepts requests with the admin token, the API key of a tenant
+// or an issued API key, see authenticateAdmin. Requests of a tenant only
+// have access to the rooms of the tenant, and keys with the read scope can
+// only read.
+func adminAuth(token string, wss *WSS) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			credentials, ok := authenticateAdmin(wss, token, r.Header.Get("Authorization"))
+			if !ok {
+				writeJSON(w, http.StatusUnauthorized, AdminError{"Unauthorized"})
+				return
+			}
+			if credentials.scope == APIKeyScopeRead && r.Method != http.MethodGet && r.Method != http.MethodHead {
+				writeJSON(w, http.StatusForbidden, AdminError{"Forbidden"})
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(withAdminCredentials(r.Context(), credentials)))
+		})
+	}
+}
+
+// adminScope only allows requests authenticated with full control over all
+// rooms, for endpoints which are not limited to a room.
+func adminScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credentials := adminCredentialsFromContext(r.Context())
+		if credentials.scope != APIKeyScopeAdmin || credentials.tenant != nil {
+			writeJSON(w, http.StatusForbidden, AdminError{"Forbidden"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminTokenScope only allows requests authenticated with the admin token,
+// so that API keys cannot issue other keys.
+func adminTokenScope(next http.Handler) http.Handler {
+	return adminScope(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminCredentialsFromContext(r.Context()).keyID != "" {
+			writeJSON(w, http.StatusForbidden, AdminError{"Forbidden"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// tenantClient rejects requests of tenants for clients which are not members
+// of the room, because clientIDs are not namespaced.
+func (a *adminAPI) tenantClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tenantFromContext(r.Context()) == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		room := roomParam(r)
+		adapter := a.wss.rooms.Enter(room)
+		clients, err := adapter.Clients()
+		a.wss.rooms.Exit(room)
+		if err != nil {
+			a.log.Printf("Error retrieving clients of room: %s: %s", room, err)
+			writeJSON(w, http.StatusInternalServerError, AdminError{"Error retrieving clients"})
+			return
+		}
+		if _, ok := clients[urlParam(r, "clientID")]; !ok {
+			writeJSON(w, http.StatusNotFound, AdminError{"Client not found"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(value)
+}
+
+func urlParam(r *http.Request, key string) string {
+	value := chi.URLParam(r, key)
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		return unescaped
+	}
+	return value
+}
+
+// roomParam returns the room URL parameter within the namespace of the
+// tenant of r.
+func roomParam(r *http.Request) string {
+	return tenantFromContext(r.Context()).Room(urlParam(r, "room"))
+}
+
+func parseDryRun(r *http.Request) (bool, error) {
+	value := r.URL.Query().Get("dryRun")
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+func (a *adminAPI) closeRoom(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := parseDryRun(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Invalid dryRun parameter"})
+		return
+	}
+
+	room := roomParam(r)
+	result := AdminOperationResult{
+		Operation: AdminOperationCloseRoom,
+		DryRun:    dryRun,
+		Room:      room,
+		ClientIDs: a.wss.LocalClientIDs(room),
+	}
+
+	a.log.Printf("Close room: %s, clients: %v, dryRun: %t", room, result.ClientIDs, dryRun)
+	if !dryRun {
+		for _, clientID := range result.ClientIDs {
+			a.wss.Disconnect(room, clientID)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (a *adminAPI) kickClient(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := parseDryRun(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Invalid dryRun parameter"})
+		return
+	}
+
+	room := roomParam(r)
+	clientID := urlParam(r, "clientID")
+
+	found := false
+	for _, localClientID := range a.wss.LocalClientIDs(room) {
+		if localClientID == clientID {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		writeJSON(w, http.StatusNotFound, AdminError{"Client not found"})
+		return
+	}
+
+	a.log.Printf("Kick client: %s from room: %s, dryRun: %t", clientID, room, dryRun)
+	if !dryRun {
+		a.wss.kick(room, clientID, "")
+	}
+
+	writeJSON(w, http.StatusOK, AdminOperationResult{
+		Operation: AdminOperationKickClient,
+		DryRun:    dryRun,
+		Room:      room,
+		ClientIDs: []string{clientID},
+	})
+}
+
+// listEvents returns a page of recorded events of a room. The after query
+// parameter is the sequence number of the last event already read, and
+// limit the maximum number of events returned.
+func (a *adminAPI) listEvents(w http.ResponseWriter, r *http.Request) {
+	eventLog := a.wss.EventLog()
+	if eventLog == nil {
+		writeJSON(w, http.StatusNotFound, AdminError{"Event log is disabled"})
+		return
+	}
+
+	var after int64
+	if value := r.URL.Query().Get("after"); value != "" {
+		var err error
+		if after, err = strconv.ParseInt(value, 10, 64); err != nil || after < 0 {
+			writeJSON(w, http.StatusBadRequest, AdminError{"Invalid after parameter"})
+			return
+		}
+	}
+
+	var limit int
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 {
+			writeJSON(w, http.StatusBadRequest, AdminError{"Invalid limit parameter"})
+			return
+		}
+	}
+
+	room := roomParam(r)
+	page, err := eventLog.Events(room, after, limit)
+	if err != nil {
+		a.log.Printf("Error reading events of room: %s: %s", room, err)
+		writeJSON(w, http.StatusInternalServerError, AdminError{"Error reading events"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (a *adminAPI) listUsage(w http.ResponseWriter, r *http.Request) {
+	metering := a.wss.Metering()
+	if metering == nil {
+		writeJSON(w, http.StatusNotFound, AdminError{"Metering is disabled"})
+		return
+	}
+
+	tenant := tenantFromContext(r.Context())
+	usages := []RoomUsage{}
+	for _, usage := range metering.Rooms() {
+		if tenant.Owns(usage.Room) {
+			usages = append(usages, usage)
+		}
+	}
+	writeJSON(w, http.StatusOK, usages)
+}
+
+func (a *adminAPI) getUsage(w http.ResponseWriter, r *http.Request) {
+	metering := a.wss.Metering()
+	if metering == nil {
+		writeJSON(w, http.StatusNotFound, AdminError{"Metering is disabled"})
+		return
+	}
+
+	usage, ok := metering.Usage(roomParam(r))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, AdminError{"Room not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, usage)
+}
+
+// expireClient simulates the departure of a client whose session is stuck,
+// as if its websocket connection was closed. Unlike kickClient it also
+// cleans up clients which are no longer connected, but are still members of
+// the room or still have a peer connection.
+func (a *adminAPI) expireClient(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := parseDryRun(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Invalid dryRun parameter"})
+		return
+	}
+
+	room := roomParam(r)
+	clientID := urlParam(r, "clientID")
+
+	connected := false
+	for _, localClientID := range a.wss.LocalClientIDs(room) {
+		if localClientID == clientID {
+			connected = true
+			break
+		}
+	}
+
+	adapter := a.wss.rooms.Enter(room)
+	defer a.wss.rooms.Exit(room)
+
+	clients, err := adapter.Clients()
+	if err != nil {
+		a.log.Printf("Error retrieving clients of room: %s: %s", room, err)
+		writeJSON(w, http.StatusInternalServerError, AdminError{"Error retrieving clients"})
+		return
+	}
+	_, member := clients[clientID]
+	hasPeer := a.tracks.HasPeerInRoom(room, clientID)
+
+	if !connected && !member && !hasPeer {
+		writeJSON(w, http.StatusNotFound, AdminError{"Client not found"})
+		return
+	}
+
+	a.log.Printf(
+		"Expire client: %s in room: %s, connected: %t, member: %t, peer: %t, dryRun: %t",
+		clientID, room, connected, member, hasPeer, dryRun,
+	)
+
+	if !dryRun {
+		if connected {
+			// runs the regular cleanup when the websocket handler returns
+			a.wss.Disconnect(room, clientID)
+		} else if member {
+			if err := adapter.Remove(clientID); err != nil {
+				a.log.Printf("Error removing client: %s from room: %s: %s", clientID, room, err)
+			}
+			err := adapter.Broadcast(NewMessage("hangUp", room, map[string]string{
+				"userId": clientID,
+			}))
+			if err != nil {
+				a.log.Printf("Error broadcasting hangUp of client: %s: %s", clientID, err)
+			}
+		}
+		if hasPeer {
+			a.tracks.RemovePeer(clientID)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, AdminOperationResult{
+		Operation: AdminOperationExpireClient,
+		DryRun:    dryRun,
+		Room:      room,
+		ClientIDs: []string{clientID},
+	})
+}
+
+// setMaxBitrate overrides the maximum bitrate of the room for a publisher
+// until its peer connection is closed.
+func (a *adminAPI) setMaxBitrate(w http.ResponseWriter, r *http.Request) {
+	room := roomParam(r)
+	clientID := urlParam(r, "clientID")
+
+	var req AdminMaxBitrate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MaxBitrate < 0 {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Invalid max bitrate"})
+		return
+	}
+
+	if !a.tracks.HasPeerInRoom(room, clientID) {
+		writeJSON(w, http.StatusNotFound, AdminError{"Client not found"})
+		return
+	}
+
+	if err := a.tracks.SetMaxBitrate(clientID, req.MaxBitrate); err != nil {
+		writeJSON(w, http.StatusNotFound, AdminError{"Client not found"})
+		return
+	}
+
+	a.log.Printf("Set max bitrate of client: %s in room: %s to: %d", clientID, room, req.MaxBitrate)
+	writeJSON(w, http.StatusOK, AdminMaxBitrate{
+		Room:       room,
+		ClientID:   clientID,
+		MaxBitrate: req.MaxBitrate,
+	})
+}
+
+// setRole changes the role of a client connected to this instance, for
+// example to make a client the host of a meeting scheduled by another
+// service.
+func (a *adminAPI) setRole(w http.ResponseWriter, r *http.Request) {
+	room := roomParam(r)
+	clientID := urlParam(r, "clientID")
+
+	var req AdminRole
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !req.Role.Valid() {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Invalid role"})
+		return
+	}
+
+	if err := a.wss.SetRole(room, clientID, req.Role); err != nil {
+		writeJSON(w, http.StatusNotFound, AdminError{"Client not found"})
+		return
+	}
+
+	a.log.Printf("Set role of client: %s in room: %s to: %s", clientID, room, req.Role)
+	writeJSON(w, http.StatusOK, AdminRole{
+		Room:     room,
+		ClientID: clientID,
+		Role:     req.Role,
+	})
+}
+
+func (a *adminAPI) listRegisteredRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := a.wss.RoomRegistry().Rooms()
+	if err != nil {
+		a.log.Printf("Error listing registered rooms: %s", err)
+		writeJSON(w, http.StatusInternalServerError, AdminError{"Error listing rooms"})
+		return
+	}
+
+	tenant := tenantFromContext(r.Context())
+	result := make([]AdminRegisteredRoom, 0, len(rooms))
+	for _, room := range rooms {
+		if tenant.Owns(room.Name) {
+			result = append(result, room.Public())
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (a *adminAPI) getRegisteredRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok, err := a.wss.RoomRegistry().Room(roomParam(r))
+	if err != nil {
+		a.log.Printf("Error reading registered room: %s", err)
+		writeJSON(w, http.StatusInternalServerError, AdminError{"Error reading room"})
+		return
+	}
+	if !ok {
+		writeJSON(w, http.StatusNotFound, AdminError{"Room not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, room.Public())
+}
+
+// saveRegisteredRoom registers a room, or replaces all settings of a
+// registered room.
+func (a *adminAPI) saveRegisteredRoom(w http.ResponseWriter, r *http.Request) {
+	var req RegisteredRoom
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Invalid room"})
+		return
+	}
+	req.Name = roomParam(r)
+
+	room, err := a.wss.RoomRegistry().Save(req)
+	if err != nil {
+		if errors.Is(err, ErrInvalidRegisteredRoom) {
+			writeJSON(w, http.StatusBadRequest, AdminError{err.Error()})
+			return
+		}
+		a.log.Printf("Error saving registered room: %s", err)
+		writeJSON(w, http.StatusInternalServerError, AdminError{"Error saving room"})
+		return
+	}
+	writeJSON(w, http.StatusOK, room.Public())
+}
+
+func (a *adminAPI) deleteRegisteredRoom(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := parseDryRun(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Invalid dryRun parameter"})
+		return
+	}
+
+	room := roomParam(r)
+
+	if dryRun {
+		_, ok, err := a.wss.RoomRegistry().Room(room)
+		if err != nil {
+			a.log.Printf("Error reading registered room: %s", err)
+			writeJSON(w, http.StatusInternalServerError, AdminError{"Error reading room"})
+			return
+		}
+		if !ok {
+			writeJSON(w, http.StatusNotFound, AdminError{"Room not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, AdminOperationResult{
+			Operation: AdminOperationDeleteRegisteredRoom,
+			DryRun:    true,
+			Room:      room,
+			ClientIDs: []string{},
+		})
+		return
+	}
+
+	deleted, err := a.wss.RoomRegistry().Delete(room)
+	if err != nil {
+		a.log.Printf("Error deleting registered room: %s", err)
+		writeJSON(w, http.StatusInternalServerError, AdminError{"Error deleting room"})
+		return
+	}
+	if !deleted {
+		writeJSON(w, http.StatusNotFound, AdminError{"Room not found"})
+		return
+	}
+
+	a.log.Printf("Deleted registered room: %s", room)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lockRoom prevents new clients from joining the current meeting in a room.
+func (a *adminAPI) lockRoom(w http.ResponseWriter, r *http.Request) {
+	a.setRoomLocked(w, roomParam(r), true)
+}
+
+// unlockRoom allows new clients to join a room again.
+func (a *adminAPI) unlockRoom(w http.ResponseWriter, r *http.Request) {
+	a.setRoomLocked(w, roomParam(r), false)
+}
+
+func (a *adminAPI) setRoomLocked(w http.ResponseWriter, room string, locked bool) {
+	setLocked := a.wss.UnlockRoom
+	if locked {
+		setLocked = a.wss.LockRoom
+	}
+
+	if err := setLocked(room); err != nil {
+		writeJSON(w, http.StatusNotFound, AdminError{"Room not found"})
+		return
+	}
+
+	a.log.Printf("Set room: %s locked: %t", room, locked)
+	writeJSON(w, http.StatusOK, AdminRoomLock{
+		Room:   room,
+		Locked: locked,
+	})
+}
+
+// startBreakouts moves clients of a room to breakout rooms and notifies
+// them with a breakout message.
+func (a *adminAPI) startBreakouts(w http.ResponseWriter, r *http.Request) {
+	room := roomParam(r)
+
+	var req AdminBreakouts
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Rooms) == 0 {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Invalid breakout rooms"})
+		return
+	}
+
+	if err := a.tracks.MoveToBreakoutRooms(room, req.Rooms); err != nil {
+		switch {
+		case errors.Is(err, ErrPeerNotInRoom):
+			writeJSON(w, http.StatusNotFound, AdminError{"Client not found"})
+		default:
+			writeJSON(w, http.StatusBadRequest, AdminError{err.Error()})
+		}
+		return
+	}
+
+	a.log.Printf("Started breakout rooms of room: %s: %v", room, req.Rooms)
+	for name, clientIDs := range req.Rooms {
+		a.notifyBreakout(room, clientIDs, name)
+	}
+
+	writeJSON(w, http.StatusOK, AdminBreakouts{
+		Room:  room,
+		Rooms: req.Rooms,
+	})
+}
+
+// stopBreakouts returns all clients in breakout rooms to the main room.
+func (a *adminAPI) stopBreakouts(w http.ResponseWriter, r *http.Request) {
+	room := roomParam(r)
+
+	clientIDs := a.tracks.ReturnToMainRoom(room)
+	a.log.Printf("Returned clients: %v to room: %s", clientIDs, room)
+	a.notifyBreakout(room, clientIDs, "")
+
+	writeJSON(w, http.StatusOK, AdminOperationResult{
+		Operation: AdminOperationStopBreakouts,
+		Room:      room,
+		ClientIDs: clientIDs,
+	})
+}
+
+// notifyBreakout sends the breakout room clients are in, or an empty name
+// when they are in the main room.
+func (a *adminAPI) notifyBreakout(room string, clientIDs []string, name string) {
+	adapter := a.wss.rooms.Enter(room)
+	defer a.wss.rooms.Exit(room)
+
+	msg := NewMessage(MessageTypeBreakout, room, map[string]interface{}{
+		"name": name,
+	})
+	for _, clientID := range clientIDs {
+		if err := adapter.Emit(clientID, msg); err != nil {
+			a.log.Printf("Error notifying client: %s about breakout room: %s", clientID, err)
+		}
+	}
+}
+
+// injectAudio plays an Ogg Opus file from the request body into a room.
+func (a *adminAPI) injectAudio(w http.ResponseWriter, r *http.Request) {
+	room := roomParam(r)
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxInjectedAudioSize))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, AdminError{"Audio file too large"})
+		return
+	}
+
+	id, err := a.tracks.InjectAudio(room, bytes.NewReader(data))
+	if err != nil {
+		if errors.Is(err, ErrRoomNotFound) {
+			writeJSON(w, http.StatusNotFound, AdminError{"Room not found"})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, AdminError{err.Error()})
+		return
+	}
+
+	a.log.Printf("Inject audio: %s into room: %s", id, room)
+	writeJSON(w, http.StatusAccepted, AdminAudioInjection{
+		ID:   id,
+		Room: room,
+	})
+}
+
+// AdminDTMFRequest is the request body of DTMF injections.
+type AdminDTMFRequest struct {
+	Digits string `json:"digits"`
+}
+
+// injectDTMF sends DTMF digits as telephone events into a room. The
+// injection can be stopped like an audio injection.
+func (a *adminAPI) injectDTMF(w http.ResponseWriter, r *http.Request) {
+	room := roomParam(r)
+
+	var req AdminDTMFRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Invalid DTMF digits"})
+		return
+	}
+
+	id, err := a.tracks.InjectDTMF(room, req.Digits)
+	if err != nil {
+		if errors.Is(err, ErrRoomNotFound) {
+			writeJSON(w, http.StatusNotFound, AdminError{"Room not found"})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, AdminError{err.Error()})
+		return
+	}
+
+	a.log.Printf("Inject DTMF: %s into room: %s", id, room)
+	writeJSON(w, http.StatusAccepted, AdminAudioInjection{
+		ID:   id,
+		Room: room,
+	})
+}
+
+func (a *adminAPI) stopAudio(w http.ResponseWriter, r *http.Request) {
+	room := roomParam(r)
+	id := urlParam(r, "id")
+
+	if !a.tracks.StopAudio(room, id) {
+		writeJSON(w, http.StatusNotFound, AdminError{"Audio injection not found"})
+		return
+	}
+
+	a.log.Printf("Stop audio: %s in room: %s", id, room)
+	writeJSON(w, http.StatusOK, AdminAudioInjection{
+		ID:   id,
+		Room: room,
+	})
+}
+
+// startIngest registers an external RTP or RTSP source as a participant of
+// a room.
+func (a *adminAPI) startIngest(w http.ResponseWriter, r *http.Request) {
+	room := roomParam(r)
+
+	var req IngestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Invalid ingest request"})
+		return
+	}
+
+	source, err := a.tracks.Ingest(room, req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, AdminError{err.Error()})
+		return
+	}
+
+	a.log.Printf("Ingest: %s into room: %s", source.ID, room)
+	writeJSON(w, http.StatusCreated, source)
+}
+
+func (a *adminAPI) stopIngest(w http.ResponseWriter, r *http.Request) {
+	room := roomParam(r)
+	id := urlParam(r, "id")
+
+	if !a.tracks.StopIngest(room, id) {
+		writeJSON(w, http.StatusNotFound, AdminError{"Ingest source not found"})
+		return
+	}
+
+	a.log.Printf("Stop ingest: %s in room: %s", id, room)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *adminAPI) listEgress(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.egress.List(roomParam(r)))
+}
+
+// startEgress asks the egress service to record or stream a room.
+func (a *adminAPI) startEgress(w http.ResponseWriter, r *http.Request) {
+	room := roomParam(r)
+
+	var params EgressParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Invalid egress request"})
+		return
+	}
+
+	info, err := a.egress.Start(r.Context(), room, params)
+	if err != nil {
+		a.log.Errorf("Error starting egress in room: %s: %s", room, err)
+		switch {
+		case errors.Is(err, ErrEgressInvalid):
+			writeJSON(w, http.StatusBadRequest, AdminError{err.Error()})
+		case errors.Is(err, ErrEgressDisabled):
+			writeJSON(w, http.StatusServiceUnavailable, AdminError{err.Error()})
+		default:
+			writeJSON(w, http.StatusBadGateway, AdminError{err.Error()})
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, info)
+}
+
+func (a *adminAPI) stopEgress(w http.ResponseWriter, r *http.Request) {
+	room := roomParam(r)
+	id := urlParam(r, "id")
+
+	found := false
+	for _, info := range a.egress.List(room) {
+		if info.ID == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		writeJSON(w, http.StatusNotFound, AdminError{ErrEgressNotFound.Error()})
+		return
+	}
+
+	if _, err := a.egress.Stop(r.Context(), id); err != nil {
+		a.log.Errorf("%s", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *adminAPI) listRecordingJobs(w http.ResponseWriter, r *http.Request) {
+	recordingJobs := a.wss.RecordingJobs()
+	if recordingJobs == nil {
+		writeJSON(w, http.StatusNotFound, AdminError{"Recording post-processing is disabled"})
+		return
+	}
+
+	tenant := tenantFromContext(r.Context())
+	jobs := []RecordingJob{}
+	for _, job := range recordingJobs.Jobs() {
+		if tenant.Owns(job.Room) {
+			jobs = append(jobs, job)
+		}
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (a *adminAPI) getRecordingJob(w http.ResponseWriter, r *http.Request) {
+	recordingJobs := a.wss.RecordingJobs()
+	if recordingJobs == nil {
+		writeJSON(w, http.StatusNotFound, AdminError{"Recording post-processing is disabled"})
+		return
+	}
+
+	job, ok := recordingJobs.Job(urlParam(r, "id"))
+	if !ok || !tenantFromContext(r.Context()).Owns(job.Room) {
+		writeJSON(w, http.StatusNotFound, AdminError{"Recording job not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// getLoad returns the load of this instance, scored against its node
+// capacity.
+func (a *adminAPI) getLoad(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.wss.NodeLoad())
+}
+
+// getDTLS returns the fingerprints of the DTLS certificate presented to
+// peers.
+func (a *adminAPI) getDTLS(w http.ResponseWriter, r *http.Request) {
+	certificates := a.wss.DTLSCertificates()
+	if certificates == nil {
+		writeJSON(w, http.StatusNotFound, AdminError{"DTLS certificate is generated for each peer connection"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, certificates.Info())
+}
+
+// getSFUStats returns the state of the workers and queues of the SFU.
+func (a *adminAPI) getSFUStats(w http.ResponseWriter, r *http.Request) {
+	stats, ok := a.wss.SFUStats()
+	if !ok {
+		writeJSON(w, http.StatusNotFound, AdminError{"SFU stats are not available"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// getRuntime returns the runtime tunables in use.
+func (a *adminAPI) getRuntime(w http.ResponseWriter, r *http.Request) {
+	tunables := a.wss.RuntimeTunables()
+	if tunables == nil {
+		writeJSON(w, http.StatusNotFound, AdminError{"Runtime tunables are not available"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAdminRuntime(tunables.Config()))
+}
+
+// setRuntime changes the runtime tunables without a restart.
+func (a *adminAPI) setRuntime(w http.ResponseWriter, r *http.Request) {
+	tunables := a.wss.RuntimeTunables()
+	if tunables == nil {
+		writeJSON(w, http.StatusNotFound, AdminError{"Runtime tunables are not available"})
+		return
+	}
+
+	req := newAdminRuntime(tunables.Config())
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Invalid runtime tunables"})
+		return
+	}
+
+	if err := tunables.Set(req.config()); err != nil {
+		writeJSON(w, http.StatusBadRequest, AdminError{err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newAdminRuntime(tunables.Config()))
+}
+
+// servePprofProfile serves a runtime/pprof profile, like heap or goroutine.
+// pprof.Index cannot serve profiles because it expects to be mounted at
+// /debug/pprof/.
+func servePprofProfile(w http.ResponseWriter, r *http.Request) {
+	pprof.Handler(urlParam(r, "name")).ServeHTTP(w, r)
+}
+
+func (a *adminAPI) listNodes(w http.ResponseWriter, r *http.Request) {
+	cluster := a.wss.Cluster()
+	if cluster == nil {
+		writeJSON(w, http.StatusNotFound, AdminError{"Clustering is disabled"})
+		return
+	}
+
+	nodes, err := cluster.Nodes()
+	if err != nil {
+		a.log.Errorf("Error reading cluster nodes: %s", err)
+		writeJSON(w, http.StatusInternalServerError, AdminError{"Error reading nodes"})
+		return
+	}
+	writeJSON(w, http.StatusOK, nodes)
+}
+
+// getRoomNode returns the node hosting a room, or 404 when the room is not
+// assigned to an available node.
+func (a *adminAPI) getRoomNode(w http.ResponseWriter, r *http.Request) {
+	cluster := a.wss.Cluster()
+	if cluster == nil {
+		writeJSON(w, http.StatusNotFound, AdminError{"Clustering is disabled"})
+		return
+	}
+
+	room := roomParam(r)
+	node, ok, err := cluster.Lookup(room)
+	if err != nil {
+		a.log.Errorf("Error looking up room: %s: %s", room, err)
+		writeJSON(w, http.StatusInternalServerError, AdminError{"Error looking up room"})
+		return
+	}
+	if !ok {
+		writeJSON(w, http.StatusNotFound, AdminError{"Room not assigned"})
+		return
+	}
+	writeJSON(w, http.StatusOK, AdminRoomNode{Room: room, Node: node})
+}
+
+// assignRoomNode returns the node hosting a room, assigning the room to the
+// least loaded node when it is not hosted yet, so that a proxy can route
+// signaling to it.
+func (a *adminAPI) assignRoomNode(w http.ResponseWriter, r *http.Request) {
+	cluster := a.wss.Cluster()
+	if cluster == nil {
+		writeJSON(w, http.StatusNotFound, AdminError{"Clustering is disabled"})
+		return
+	}
+
+	room := roomParam(r)
+	node, err := cluster.Assign(room)
+	if err != nil {
+		if errors.Is(err, ErrNoNodeAvailable) {
+			writeJSON(w, http.StatusServiceUnavailable, AdminError{err.Error()})
+			return
+		}
+		a.log.Errorf("Error assigning room: %s: %s", room, err)
+		writeJSON(w, http.StatusInternalServerError, AdminError{"Error assigning room"})
+		return
+	}
+	writeJSON(w, http.StatusOK, AdminRoomNode{Room: room, Node: node})
+}
+
+// startCapture writes the RTP packets received from and sent to a client to
+// files in the capture directory, for a limited duration.
+func (a *adminAPI) startCapture(w http.ResponseWriter, r *http.Request) {
+	room := roomParam(r)
+	clientID := urlParam(r, "clientID")
+
+	var req CaptureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Invalid capture request"})
+		return
+	}
+
+	capture, err := a.tracks.StartCapture(room, clientID, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrPeerNotInRoom):
+			writeJSON(w, http.StatusNotFound, AdminError{"Client not found"})
+		case errors.Is(err, ErrInvalidCaptureFormat):
+			writeJSON(w, http.StatusBadRequest, AdminError{err.Error()})
+		case errors.Is(err, ErrCaptureInProgress):
+			writeJSON(w, http.StatusConflict, AdminError{err.Error()})
+		case errors.Is(err, ErrCaptureDisabled):
+			writeJSON(w, http.StatusServiceUnavailable, AdminError{err.Error()})
+		default:
+			a.log.Errorf("Error starting capture of client: %s in room: %s: %s", clientID, room, err)
+			writeJSON(w, http.StatusInternalServerError, AdminError{"Error starting capture"})
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, capture)
+}
+
+func (a *adminAPI) stopCapture(w http.ResponseWriter, r *http.Request) {
+	clientID := urlParam(r, "clientID")
+	id := urlParam(r, "id")
+
+	if !a.tracks.StopCapture(clientID, id) {
+		writeJSON(w, http.StatusNotFound, AdminError{"Capture not found"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// downloadCapture serves a capture file. Files can be downloaded while the
+// capture is in progress, but are only complete once it has stopped.
+func (a *adminAPI) downloadCapture(w http.ResponseWriter, r *http.Request) {
+	name := urlParam(r, "name")
+
+	f, err := a.tracks.OpenCapture(name)
+	if err != nil {
+		if errors.Is(err, ErrCaptureNotFound) || errors.Is(err, ErrCaptureDisabled) {
+			writeJSON(w, http.StatusNotFound, AdminError{"Capture not found"})
+			return
+		}
+		a.log.Errorf("Error opening capture: %s: %s", name, err)
+		writeJSON(w, http.StatusInternalServerError, AdminError{"Error opening capture"})
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, AdminError{"Error opening capture"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename="+name)
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+func (a *adminAPI) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := a.wss.APIKeys().Keys()
+	if err != nil {
+		a.log.Printf("Error listing API keys: %s", err)
+		writeJSON(w, http.StatusInternalServerError, AdminError{"Error listing API keys"})
+		return
+	}
+
+	result := make([]APIKey, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, key.Public())
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (a *adminAPI) issueAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req APIKey
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Invalid API key"})
+		return
+	}
+	if _, ok := a.wss.Tenants().Tenant(req.Tenant); req.Tenant != "" && !ok {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Tenant not found"})
+		return
+	}
+
+	key, secret, err := a.wss.APIKeys().Issue(req)
+	if err != nil {
+		if errors.Is(err, ErrInvalidAPIKey) {
+			writeJSON(w, http.StatusBadRequest, AdminError{err.Error()})
+			return
+		}
+		a.log.Printf("Error issuing API key: %s", err)
+		writeJSON(w, http.StatusInternalServerError, AdminError{"Error issuing API key"})
+		return
+	}
+	writeJSON(w, http.StatusCreated, AdminAPIKey{
+		APIKey:        key.Public(),
+		Key:           secret,
+		WebhookSecret: key.WebhookSecret,
+	})
+}
+
+func (a *adminAPI) rotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := parseDryRun(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Invalid dryRun parameter"})
+		return
+	}
+
+	var (
+		key    APIKey
+		secret string
+	)
+	if dryRun {
+		key, err = a.wss.APIKeys().Key(urlParam(r, "id"))
+	} else {
+		key, secret, err = a.wss.APIKeys().Rotate(urlParam(r, "id"))
+	}
+	if err != nil {
+		if errors.Is(err, ErrAPIKeyNotFound) {
+			writeJSON(w, http.StatusNotFound, AdminError{"API key not found"})
+			return
+		}
+		a.log.Printf("Error rotating API key: %s", err)
+		writeJSON(w, http.StatusInternalServerError, AdminError{"Error rotating API key"})
+		return
+	}
+
+	result := AdminAPIKey{
+		APIKey: key.Public(),
+		Key:    secret,
+		DryRun: dryRun,
+	}
+	if !dryRun {
+		result.WebhookSecret = key.WebhookSecret
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (a *adminAPI) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	dryRun, err := parseDryRun(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, AdminError{"Invalid dryRun parameter"})
+		return
+	}
+
+	if dryRun {
+		key, err := a.wss.APIKeys().Key(urlParam(r, "id"))
+		if err != nil {
+			if errors.Is(err, ErrAPIKeyNotFound) {
+				writeJSON(w, http.StatusNotFound, AdminError{"API key not found"})
+				return
+			}
+			a.log.Printf("Error reading API key: %s", err)
+			writeJSON(w, http.StatusInternalServerError, AdminError{"Error reading API key"})
+			return
+		}
+		writeJSON(w, http.StatusOK, AdminAPIKey{APIKey: key.Public(), DryRun: true})
+		return
+	}
+
+	revoked, err := a.wss.APIKeys().Revoke(urlParam(r, "id"))
+	if err != nil {
+		a.log.Printf("Error revoking API key: %s", err)
+		writeJSON(w, http.StatusInternalServerError, AdminError{"Error revoking API key"})
+		return
+	}
+	if !revoked {
+		writeJSON(w, http.StatusNotFound, AdminError{"API key not found"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
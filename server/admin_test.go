@@ -0,0 +1,542 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+const adminToken = "admin-secret"
+
+func setupAdminServer(t *testing.T) (s *httptest.Server, wsURL string) {
+	t.Helper()
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	admin := server.AdminConfig{Token: adminToken}
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, admin, server.InactivityConfig{}, server.ClientConfig{}, rooms, newMockTracksManager())
+	s = httptest.NewServer(mux)
+	wsURL = "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + roomName + "/" + clientID
+	return
+}
+
+func adminRequest(t *testing.T, method string, url string, token string) (int, server.AdminOperationResult) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	var result server.AdminOperationResult
+	json.NewDecoder(res.Body).Decode(&result)
+	return res.StatusCode, result
+}
+
+func mustReadUntilClosed(t *testing.T, ctx context.Context, ws *websocket.Conn) {
+	t.Helper()
+	for {
+		if _, _, err := ws.Read(ctx); err != nil {
+			require.NoError(t, ctx.Err(), "websocket should be closed before timeout")
+			return
+		}
+	}
+}
+
+func TestAdmin_unauthorized(t *testing.T) {
+	s, _ := setupAdminServer(t)
+	defer s.Close()
+
+	statusCode, _ := adminRequest(t, "DELETE", s.URL+"/api/admin/rooms/"+roomName, "invalid")
+	assert.Equal(t, http.StatusUnauthorized, statusCode)
+}
+
+func TestAdmin_closeRoom_dryRun(t *testing.T) {
+	s, wsURL := setupAdminServer(t)
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ws := mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+
+	url := s.URL + "/api/admin/rooms/" + roomName
+	require.Eventually(t, func() bool {
+		_, result := adminRequest(t, "DELETE", url+"?dryRun=true", adminToken)
+		return len(result.ClientIDs) == 1
+	}, timeout, 10*time.Millisecond)
+
+	statusCode, result := adminRequest(t, "DELETE", url+"?dryRun=true", adminToken)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, server.AdminOperationResult{
+		Operation: server.AdminOperationCloseRoom,
+		DryRun:    true,
+		Room:      roomName,
+		ClientIDs: []string{clientID},
+	}, result)
+
+	statusCode, result = adminRequest(t, "DELETE", url, adminToken)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.False(t, result.DryRun)
+	assert.Equal(t, []string{clientID}, result.ClientIDs)
+
+	mustReadUntilClosed(t, ctx, ws)
+}
+
+func TestAdmin_kickClient(t *testing.T) {
+	s, wsURL := setupAdminServer(t)
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ws := mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+
+	url := s.URL + "/api/admin/rooms/" + roomName + "/clients/" + clientID
+	require.Eventually(t, func() bool {
+		statusCode, _ := adminRequest(t, "DELETE", url+"?dryRun=1", adminToken)
+		return statusCode == http.StatusOK
+	}, timeout, 10*time.Millisecond)
+
+	statusCode, _ := adminRequest(t, "DELETE", url+"?dryRun=invalid", adminToken)
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+
+	statusCode, result := adminRequest(t, "DELETE", url, adminToken)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, server.AdminOperationKickClient, result.Operation)
+	assert.False(t, result.DryRun)
+
+	mustReadUntilClosed(t, ctx, ws)
+
+	statusCode, _ = adminRequest(t, "DELETE", s.URL+"/api/admin/rooms/"+roomName+"/clients/missing", adminToken)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+}
+
+func TestAdmin_injectAudio(t *testing.T) {
+	s, _ := setupAdminServer(t)
+	defer s.Close()
+
+	url := s.URL + "/api/admin/rooms/" + roomName + "/audio"
+	req, err := http.NewRequest("POST", url, strings.NewReader("ogg-data"))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+	var injection server.AdminAudioInjection
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&injection))
+	assert.Equal(t, server.AdminAudioInjection{ID: "audio-id", Room: roomName}, injection)
+
+	statusCode, _ := adminRequest(t, "DELETE", url+"/audio-id", adminToken)
+	assert.Equal(t, http.StatusOK, statusCode)
+	statusCode, _ = adminRequest(t, "DELETE", url+"/missing", adminToken)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+	statusCode, _ = adminRequest(t, "POST", s.URL+"/api/admin/rooms/missing/audio", adminToken)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+}
+
+func TestAdmin_injectDTMF(t *testing.T) {
+	s, _ := setupAdminServer(t)
+	defer s.Close()
+
+	inject := func(room string, body string) (int, server.AdminAudioInjection) {
+		req, err := http.NewRequest("POST", s.URL+"/api/admin/rooms/"+room+"/dtmf", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		var injection server.AdminAudioInjection
+		json.NewDecoder(res.Body).Decode(&injection)
+		return res.StatusCode, injection
+	}
+
+	statusCode, injection := inject(roomName, `{"digits":"123#"}`)
+	assert.Equal(t, http.StatusAccepted, statusCode)
+	assert.Equal(t, server.AdminAudioInjection{ID: "audio-id", Room: roomName}, injection)
+
+	statusCode, _ = inject(roomName, `{"digits":""}`)
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _ = inject(roomName, `{`)
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _ = inject("missing", `{"digits":"1"}`)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+}
+
+func TestAdmin_ingest(t *testing.T) {
+	s, _ := setupAdminServer(t)
+	defer s.Close()
+
+	url := s.URL + "/api/admin/rooms/" + roomName + "/ingest"
+	req, err := http.NewRequest("POST", url, strings.NewReader(`{"type":"rtp","codec":"vp8"}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+	var source server.IngestSource
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&source))
+	assert.Equal(t, "ingest-id", source.ID)
+	assert.Equal(t, 5004, source.Port)
+
+	statusCode, _ := adminRequest(t, "POST", url, adminToken)
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _ = adminRequest(t, "DELETE", url+"/ingest-id", adminToken)
+	assert.Equal(t, http.StatusNoContent, statusCode)
+	statusCode, _ = adminRequest(t, "DELETE", url+"/missing", adminToken)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+}
+
+func TestAdmin_capture(t *testing.T) {
+	s, _ := setupAdminServer(t)
+	defer s.Close()
+
+	startCapture := func(clientID string, body string) (int, server.PacketCapture) {
+		url := s.URL + "/api/admin/rooms/" + roomName + "/clients/" + clientID + "/captures"
+		req, err := http.NewRequest("POST", url, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		var capture server.PacketCapture
+		json.NewDecoder(res.Body).Decode(&capture)
+		return res.StatusCode, capture
+	}
+
+	statusCode, capture := startCapture("zombie", `{"format":"rtpdump","duration":5}`)
+	assert.Equal(t, http.StatusCreated, statusCode)
+	assert.Equal(t, "capture-id", capture.ID)
+	assert.Equal(t, []string{"capture-id_inbound.rtpdump", "capture-id_outbound.rtpdump"}, capture.Files)
+
+	statusCode, _ = startCapture("zombie", `{"format":"wav"}`)
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _ = startCapture("missing", `{"format":"rtpdump"}`)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+
+	url := s.URL + "/api/admin/rooms/" + roomName + "/clients/zombie/captures"
+	statusCode, _ = adminRequest(t, "DELETE", url+"/capture-id", adminToken)
+	assert.Equal(t, http.StatusNoContent, statusCode)
+	statusCode, _ = adminRequest(t, "DELETE", url+"/missing", adminToken)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+	statusCode, _ = adminRequest(t, "GET", s.URL+"/api/admin/captures/capture-id_inbound.rtpdump", adminToken)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+}
+
+func TestAdmin_setMaxBitrate(t *testing.T) {
+	s, _ := setupAdminServer(t)
+	defer s.Close()
+
+	setMaxBitrate := func(clientID string, body string) (int, server.AdminMaxBitrate) {
+		url := s.URL + "/api/admin/rooms/" + roomName + "/clients/" + clientID + "/max-bitrate"
+		req, err := http.NewRequest("PUT", url, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		var result server.AdminMaxBitrate
+		json.NewDecoder(res.Body).Decode(&result)
+		return res.StatusCode, result
+	}
+
+	statusCode, result := setMaxBitrate("zombie", `{"maxBitrate":300000}`)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, server.AdminMaxBitrate{Room: roomName, ClientID: "zombie", MaxBitrate: 300000}, result)
+
+	statusCode, _ = setMaxBitrate("zombie", `{"maxBitrate":-1}`)
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _ = setMaxBitrate("missing", `{"maxBitrate":300000}`)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+
+	req, err := http.NewRequest("PUT", s.URL+"/api/admin/rooms/other/clients/zombie/max-bitrate", strings.NewReader(`{"maxBitrate":300000}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode, "client in another room")
+}
+
+func TestAdmin_expireClient(t *testing.T) {
+	rooms := NewMockRoomManager()
+	tracks := newMockTracksManager()
+	admin := server.AdminConfig{Token: adminToken}
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, admin, server.InactivityConfig{}, server.ClientConfig{}, rooms, tracks)
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	url := s.URL + "/api/admin/rooms/" + roomName + "/clients/"
+
+	// client1 is a member of the room, but not connected to this instance
+	statusCode, result := adminRequest(t, "POST", url+"client1/expire?dryRun=true", adminToken)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, server.AdminOperationExpireClient, result.Operation)
+	assert.True(t, result.DryRun)
+	assert.Equal(t, 0, len(rooms.broadcast))
+
+	statusCode, result = adminRequest(t, "POST", url+"client1/expire", adminToken)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.False(t, result.DryRun)
+	hangUp := <-rooms.broadcast
+	assert.Equal(t, "hangUp", hangUp.Type)
+	assert.Equal(t, map[string]string{"userId": "client1"}, hangUp.Payload)
+
+	// zombie only has a peer connection left
+	statusCode, _ = adminRequest(t, "POST", url+"zombie/expire", adminToken)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "zombie", <-tracks.removed)
+
+	statusCode, _ = adminRequest(t, "POST", url+"missing/expire", adminToken)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+
+	otherURL := s.URL + "/api/admin/rooms/other/clients/"
+	statusCode, _ = adminRequest(t, "POST", otherURL+"zombie/expire", adminToken)
+	assert.Equal(t, http.StatusNotFound, statusCode, "client in another room")
+	assert.Equal(t, 0, len(tracks.removed))
+}
+
+func TestAdmin_breakouts(t *testing.T) {
+	s, wsURL := setupAdminServer(t)
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ws := mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	mustWriteWS(t, ctx, ws, server.NewMessage("ready", roomName, map[string]interface{}{
+		"nickname": "a",
+	}))
+	mustReadWSType(t, ctx, ws, "users")
+
+	url := s.URL + "/api/admin/rooms/" + roomName + "/breakouts"
+	startBreakouts := func(body string) (int, server.AdminBreakouts) {
+		req, err := http.NewRequest("POST", url, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		var result server.AdminBreakouts
+		json.NewDecoder(res.Body).Decode(&result)
+		return res.StatusCode, result
+	}
+
+	statusCode, result := startBreakouts(`{"rooms":{"x":["` + clientID + `"]}}`)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, server.AdminBreakouts{
+		Room:  roomName,
+		Rooms: map[string][]string{"x": {clientID}},
+	}, result)
+	msg := mustReadWSType(t, ctx, ws, server.MessageTypeBreakout)
+	assert.Equal(t, map[string]interface{}{"name": "x"}, msg.Payload)
+
+	statusCode, _ = startBreakouts(`{"rooms":{"x":["missing"]}}`)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+	statusCode, _ = startBreakouts(`{}`)
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+
+	statusCode, stopResult := adminRequest(t, "DELETE", url, adminToken)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, server.AdminOperationResult{
+		Operation: server.AdminOperationStopBreakouts,
+		Room:      roomName,
+		ClientIDs: []string{clientID},
+	}, stopResult)
+	msg = mustReadWSType(t, ctx, ws, server.MessageTypeBreakout)
+	assert.Equal(t, map[string]interface{}{"name": ""}, msg.Payload)
+}
+
+func TestAdmin_lockRoom(t *testing.T) {
+	s, wsURL := setupAdminServer(t)
+	defer s.Close()
+	url := s.URL + "/api/admin/rooms/" + roomName + "/lock"
+
+	statusCode, _ := adminRequest(t, "POST", url, adminToken)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ws := mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	message, _ := readJoinResult(t, ctx, ws)
+	assert.Equal(t, server.MessageTypeJoinAck, message.Type)
+
+	statusCode, _ = adminRequest(t, "POST", url, adminToken)
+	assert.Equal(t, http.StatusOK, statusCode)
+
+	other := mustDialWS(t, ctx, strings.TrimSuffix(wsURL, clientID)+"other")
+	defer other.Close(websocket.StatusNormalClosure, "")
+	message, _ = readJoinResult(t, ctx, other)
+	assert.Equal(t, server.MessageTypeJoinError, message.Type)
+
+	statusCode, _ = adminRequest(t, "DELETE", url, adminToken)
+	assert.Equal(t, http.StatusOK, statusCode)
+}
+
+func TestAdmin_registry(t *testing.T) {
+	s, _ := setupAdminServer(t)
+	defer s.Close()
+	url := s.URL + "/api/admin/registry/rooms"
+
+	request := func(method string, url string, body string) (int, string) {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, strings.TrimSpace(string(data))
+	}
+
+	statusCode, body := request("PUT", url+"/standup", `{"password":"secret","maxParticipants":10,"startsAt":"2020-05-01T10:00:00Z"}`)
+	assert.Equal(t, http.StatusOK, statusCode)
+	expected := `{"name":"standup","hasPassword":true,"maxParticipants":10,"startsAt":"2020-05-01T10:00:00Z","disabled":false}`
+	assert.Equal(t, expected, body)
+
+	statusCode, body = request("GET", url+"/standup", "")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, expected, body)
+
+	statusCode, body = request("GET", url, "")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "["+expected+"]", body)
+
+	statusCode, _ = request("PUT", url+"/standup", `{"maxParticipants":-1}`)
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+
+	statusCode, body = request("DELETE", url+"/standup?dryRun=true", "")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, `{"operation":"deleteRegisteredRoom","dryRun":true,"room":"standup","clientIds":[]}`, body)
+	statusCode, _ = request("GET", url+"/standup", "")
+	assert.Equal(t, http.StatusOK, statusCode, "not deleted on dry run")
+	statusCode, _ = request("DELETE", url+"/missing?dryRun=true", "")
+	assert.Equal(t, http.StatusNotFound, statusCode)
+
+	statusCode, _ = request("DELETE", url+"/standup", "")
+	assert.Equal(t, http.StatusNoContent, statusCode)
+	statusCode, _ = request("GET", url+"/standup", "")
+	assert.Equal(t, http.StatusNotFound, statusCode)
+}
+
+func TestAdmin_events(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	admin := server.AdminConfig{Token: adminToken}
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, admin, server.InactivityConfig{}, server.ClientConfig{}, rooms, newMockTracksManager())
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + roomName + "/" + clientID
+	url := s.URL + "/api/admin/rooms/" + roomName + "/events"
+
+	readEvents := func(query string) (int, server.EventLogPage) {
+		req, err := http.NewRequest("GET", url+query, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		var page server.EventLogPage
+		json.NewDecoder(res.Body).Decode(&page)
+		return res.StatusCode, page
+	}
+
+	statusCode, _ := readEvents("")
+	assert.Equal(t, http.StatusNotFound, statusCode)
+
+	eventLog := server.NewEventLog(loggerFactory, server.NewMemoryEventLogStore(), 100)
+	defer eventLog.Close()
+	mux.WSS.SetEventLog(eventLog)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ws := mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+
+	kickURL := s.URL + "/api/admin/rooms/" + roomName + "/clients/" + clientID
+	require.Eventually(t, func() bool {
+		statusCode, _ := adminRequest(t, "DELETE", kickURL, adminToken)
+		return statusCode == http.StatusOK
+	}, timeout, 10*time.Millisecond)
+	mustReadUntilClosed(t, ctx, ws)
+
+	var page server.EventLogPage
+	require.Eventually(t, func() bool {
+		statusCode, page = readEvents("?limit=2")
+		return statusCode == http.StatusOK && len(page.Events) == 2
+	}, timeout, 10*time.Millisecond)
+	assert.Equal(t, server.EventLogJoin, page.Events[0].Type)
+	assert.Equal(t, server.EventLogKick, page.Events[1].Type)
+	assert.Equal(t, clientID, page.Events[1].ClientID)
+	assert.Equal(t, int64(2), page.Next)
+
+	require.Eventually(t, func() bool {
+		statusCode, page = readEvents("?after=2")
+		return statusCode == http.StatusOK && len(page.Events) == 1
+	}, timeout, 10*time.Millisecond)
+	assert.Equal(t, server.EventLogLeave, page.Events[0].Type)
+	assert.Equal(t, int64(3), page.Next)
+
+	statusCode, _ = readEvents("?after=invalid")
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _ = readEvents("?limit=0")
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+}
+
+func TestAdmin_usage(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	admin := server.AdminConfig{Token: adminToken}
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, admin, server.InactivityConfig{}, server.ClientConfig{}, rooms, newMockTracksManager())
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + roomName + "/" + clientID
+
+	request := func(url string, value interface{}) int {
+		req, err := http.NewRequest("GET", s.URL+"/api/admin"+url, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		json.NewDecoder(res.Body).Decode(value)
+		return res.StatusCode
+	}
+
+	var usage server.RoomUsage
+	assert.Equal(t, http.StatusNotFound, request("/usage", &[]server.RoomUsage{}))
+	assert.Equal(t, http.StatusNotFound, request("/rooms/"+roomName+"/usage", &usage))
+
+	mux.WSS.SetMetering(server.NewMetering(loggerFactory, nil))
+	assert.Equal(t, http.StatusNotFound, request("/rooms/"+roomName+"/usage", &usage))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ws := mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+
+	require.Eventually(t, func() bool {
+		return request("/rooms/"+roomName+"/usage", &usage) == http.StatusOK
+	}, timeout, 10*time.Millisecond)
+	assert.Equal(t, roomName, usage.Room)
+	require.Len(t, usage.Participants, 1)
+	assert.Equal(t, clientID, usage.Participants[0].ClientID)
+
+	var all []server.RoomUsage
+	assert.Equal(t, http.StatusOK, request("/usage", &all))
+	require.Len(t, all, 1)
+	assert.Equal(t, roomName, all[0].Room)
+}
@@ -0,0 +1,499 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Messages of the admin gRPC API defined in adminrpc.proto.
+
+type AdminCreateRoomRequest struct {
+	Room string `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`
+}
+
+type AdminCreateRoomResponse struct {
+	Room string `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`
+	URL  string `protobuf:"bytes,2,opt,name=url,proto3" json:"url,omitempty"`
+}
+
+type AdminCloseRoomRequest struct {
+	Room   string `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`
+	DryRun bool   `protobuf:"varint,2,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+}
+
+type AdminKickPeerRequest struct {
+	Room     string `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`
+	ClientID string `protobuf:"bytes,2,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	DryRun   bool   `protobuf:"varint,3,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+}
+
+type AdminOperationResponse struct {
+	Operation string   `protobuf:"bytes,1,opt,name=operation,proto3" json:"operation,omitempty"`
+	DryRun    bool     `protobuf:"varint,2,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+	Room      string   `protobuf:"bytes,3,opt,name=room,proto3" json:"room,omitempty"`
+	ClientIDs []string `protobuf:"bytes,4,rep,name=client_ids,json=clientIds,proto3" json:"client_ids,omitempty"`
+}
+
+type AdminMutePeerRequest struct {
+	Room     string `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`
+	ClientID string `protobuf:"bytes,2,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	Muted    bool   `protobuf:"varint,3,opt,name=muted,proto3" json:"muted,omitempty"`
+}
+
+type AdminMutePeerResponse struct{}
+
+type AdminStartRecordingRequest struct {
+	Room string `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`
+}
+
+type AdminStopRecordingRequest struct {
+	Room string `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`
+}
+
+type AdminRecording struct {
+	ID   string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Room string `protobuf:"bytes,2,opt,name=room,proto3" json:"room,omitempty"`
+	Dir  string `protobuf:"bytes,3,opt,name=dir,proto3" json:"dir,omitempty"`
+	// Unix time in milliseconds
+	StartedAt int64 `protobuf:"varint,4,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	// Path of the manifest, set once the recording has been stopped
+	Manifest string `protobuf:"bytes,5,opt,name=manifest,proto3" json:"manifest,omitempty"`
+}
+
+type AdminGetStatsRequest struct {
+	Room string `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`
+}
+
+type AdminGetStatsResponse struct {
+	Rooms []*AdminRoomStats `protobuf:"bytes,1,rep,name=rooms,proto3" json:"rooms,omitempty"`
+}
+
+type AdminRoomStats struct {
+	Room      string             `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`
+	Clients   uint32             `protobuf:"varint,2,opt,name=clients,proto3" json:"clients,omitempty"`
+	Peers     uint32             `protobuf:"varint,3,opt,name=peers,proto3" json:"peers,omitempty"`
+	Recording bool               `protobuf:"varint,4,opt,name=recording,proto3" json:"recording,omitempty"`
+	Tracks    []*AdminTrackStats `protobuf:"bytes,5,rep,name=tracks,proto3" json:"tracks,omitempty"`
+}
+
+type AdminTrackStats struct {
+	ClientID    string `protobuf:"bytes,1,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	TrackID     string `protobuf:"bytes,2,opt,name=track_id,json=trackId,proto3" json:"track_id,omitempty"`
+	Kind        string `protobuf:"bytes,3,opt,name=kind,proto3" json:"kind,omitempty"`
+	SSRC        uint32 `protobuf:"varint,4,opt,name=ssrc,proto3" json:"ssrc,omitempty"`
+	Bitrate     uint64 `protobuf:"varint,5,opt,name=bitrate,proto3" json:"bitrate,omitempty"`
+	Subscribers uint32 `protobuf:"varint,6,opt,name=subscribers,proto3" json:"subscribers,omitempty"`
+}
+
+func (m *AdminCreateRoomRequest) Reset()         { *m = AdminCreateRoomRequest{} }
+func (m *AdminCreateRoomRequest) String() string { return proto.CompactTextString(m) }
+func (*AdminCreateRoomRequest) ProtoMessage()    {}
+
+func (m *AdminCreateRoomResponse) Reset()         { *m = AdminCreateRoomResponse{} }
+func (m *AdminCreateRoomResponse) String() string { return proto.CompactTextString(m) }
+func (*AdminCreateRoomResponse) ProtoMessage()    {}
+
+func (m *AdminCloseRoomRequest) Reset()         { *m = AdminCloseRoomRequest{} }
+func (m *AdminCloseRoomRequest) String() string { return proto.CompactTextString(m) }
+func (*AdminCloseRoomRequest) ProtoMessage()    {}
+
+func (m *AdminKickPeerRequest) Reset()         { *m = AdminKickPeerRequest{} }
+func (m *AdminKickPeerRequest) String() string { return proto.CompactTextString(m) }
+func (*AdminKickPeerRequest) ProtoMessage()    {}
+
+func (m *AdminOperationResponse) Reset()         { *m = AdminOperationResponse{} }
+func (m *AdminOperationResponse) String() string { return proto.CompactTextString(m) }
+func (*AdminOperationResponse) ProtoMessage()    {}
+
+func (m *AdminMutePeerRequest) Reset()         { *m = AdminMutePeerRequest{} }
+func (m *AdminMutePeerRequest) String() string { return proto.CompactTextString(m) }
+func (*AdminMutePeerRequest) ProtoMessage()    {}
+
+func (m *AdminMutePeerResponse) Reset()         { *m = AdminMutePeerResponse{} }
+func (m *AdminMutePeerResponse) String() string { return proto.CompactTextString(m) }
+func (*AdminMutePeerResponse) ProtoMessage()    {}
+
+func (m *AdminStartRecordingRequest) Reset()         { *m = AdminStartRecordingRequest{} }
+func (m *AdminStartRecordingRequest) String() string { return proto.CompactTextString(m) }
+func (*AdminStartRecordingRequest) ProtoMessage()    {}
+
+func (m *AdminStopRecordingRequest) Reset()         { *m = AdminStopRecordingRequest{} }
+func (m *AdminStopRecordingRequest) String() string { return proto.CompactTextString(m) }
+func (*AdminStopRecordingRequest) ProtoMessage()    {}
+
+func (m *AdminRecording) Reset()         { *m = AdminRecording{} }
+func (m *AdminRecording) String() string { return proto.CompactTextString(m) }
+func (*AdminRecording) ProtoMessage()    {}
+
+func (m *AdminGetStatsRequest) Reset()         { *m = AdminGetStatsRequest{} }
+func (m *AdminGetStatsRequest) String() string { return proto.CompactTextString(m) }
+func (*AdminGetStatsRequest) ProtoMessage()    {}
+
+func (m *AdminGetStatsResponse) Reset()         { *m = AdminGetStatsResponse{} }
+func (m *AdminGetStatsResponse) String() string { return proto.CompactTextString(m) }
+func (*AdminGetStatsResponse) ProtoMessage()    {}
+
+func (m *AdminRoomStats) Reset()         { *m = AdminRoomStats{} }
+func (m *AdminRoomStats) String() string { return proto.CompactTextString(m) }
+func (*AdminRoomStats) ProtoMessage()    {}
+
+func (m *AdminTrackStats) Reset()         { *m = AdminTrackStats{} }
+func (m *AdminTrackStats) String() string { return proto.CompactTextString(m) }
+func (*AdminTrackStats) ProtoMessage()    {}
+
+// AdminServiceClient is a client of the admin gRPC API.
+type AdminServiceClient interface {
+	CreateRoom(ctx context.Context, in *AdminCreateRoomRequest, opts ...grpc.CallOption) (*AdminCreateRoomResponse, error)
+	CloseRoom(ctx context.Context, in *AdminCloseRoomRequest, opts ...grpc.CallOption) (*AdminOperationResponse, error)
+	KickPeer(ctx context.Context, in *AdminKickPeerRequest, opts ...grpc.CallOption) (*AdminOperationResponse, error)
+	MutePeer(ctx context.Context, in *AdminMutePeerRequest, opts ...grpc.CallOption) (*AdminMutePeerResponse, error)
+	StartRecording(ctx context.Context, in *AdminStartRecordingRequest, opts ...grpc.CallOption) (*AdminRecording, error)
+	StopRecording(ctx context.Context, in *AdminStopRecordingRequest, opts ...grpc.CallOption) (*AdminRecording, error)
+	GetStats(ctx context.Context, in *AdminGetStatsRequest, opts ...grpc.CallOption) (*AdminGetStatsResponse, error)
+}
+
+const adminServiceName = "peercalls.AdminService"
+
+type adminServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewAdminServiceClient(cc *grpc.ClientConn) AdminServiceClient {
+	return &adminServiceClient{cc}
+}
+
+func (c *adminServiceClient) invoke(ctx context.Context, method string, in, out interface{}, opts []grpc.CallOption) error {
+	return c.cc.Invoke(ctx, "/"+adminServiceName+"/"+method, in, out, opts...)
+}
+
+func (c *adminServiceClient) CreateRoom(ctx context.Context, in *AdminCreateRoomRequest, opts ...grpc.CallOption) (*AdminCreateRoomResponse, error) {
+	out := new(AdminCreateRoomResponse)
+	return out, c.invoke(ctx, "CreateRoom", in, out, opts)
+}
+
+func (c *adminServiceClient) CloseRoom(ctx context.Context, in *AdminCloseRoomRequest, opts ...grpc.CallOption) (*AdminOperationResponse, error) {
+	out := new(AdminOperationResponse)
+	return out, c.invoke(ctx, "CloseRoom", in, out, opts)
+}
+
+func (c *adminServiceClient) KickPeer(ctx context.Context, in *AdminKickPeerRequest, opts ...grpc.CallOption) (*AdminOperationResponse, error) {
+	out := new(AdminOperationResponse)
+	return out, c.invoke(ctx, "KickPeer", in, out, opts)
+}
+
+func (c *adminServiceClient) MutePeer(ctx context.Context, in *AdminMutePeerRequest, opts ...grpc.CallOption) (*AdminMutePeerResponse, error) {
+	out := new(AdminMutePeerResponse)
+	return out, c.invoke(ctx, "MutePeer", in, out, opts)
+}
+
+func (c *adminServiceClient) StartRecording(ctx context.Context, in *AdminStartRecordingRequest, opts ...grpc.CallOption) (*AdminRecording, error) {
+	out := new(AdminRecording)
+	return out, c.invoke(ctx, "StartRecording", in, out, opts)
+}
+
+func (c *adminServiceClient) StopRecording(ctx context.Context, in *AdminStopRecordingRequest, opts ...grpc.CallOption) (*AdminRecording, error) {
+	out := new(AdminRecording)
+	return out, c.invoke(ctx, "StopRecording", in, out, opts)
+}
+
+func (c *adminServiceClient) GetStats(ctx context.Context, in *AdminGetStatsRequest, opts ...grpc.CallOption) (*AdminGetStatsResponse, error) {
+	out := new(AdminGetStatsResponse)
+	return out, c.invoke(ctx, "GetStats", in, out, opts)
+}
+
+// AdminRPCTracksManager is the part of TracksManager used by the admin gRPC
+// API.
+type AdminRPCTracksManager interface {
+	RoomStateProvider
+	SetMuted(clientID string, muted bool) error
+}
+
+type adminRPCServer struct {
+	log      Logger
+	baseURL  string
+	wss      *WSS
+	tracks   AdminRPCTracksManager
+	recorder *RoomRecorder
+}
+
+// NewAdminRPCServer creates a gRPC server with the admin service
+// registered. It offers the same control over rooms as the admin REST API,
+// for orchestration systems. Calls must carry the admin token in the
+// authorization metadata, the API key of a tenant, in which case room names
+// are within the namespace of the tenant, or an issued API key. The token is
+// not accepted when empty. Options like the TLS credentials returned by
+// GRPCServerOptions are passed to the gRPC server.
+func NewAdminRPCServer(
+	loggerFactory LoggerFactory,
+	baseURL string,
+	token string,
+	wss *WSS,
+	tracks AdminRPCTracksManager,
+	recorder *RoomRecorder,
+	opts ...grpc.ServerOption,
+) *grpc.Server {
+	s := grpc.NewServer(append(opts, grpc.UnaryInterceptor(adminRPCAuth(token, wss)))...)
+	s.RegisterService(&adminServiceDesc, &adminRPCServer{
+		log:      loggerFactory.GetLogger("adminrpc"),
+		baseURL:  baseURL,
+		wss:      wss,
+		tracks:   tracks,
+		recorder: recorder,
+	})
+	return s
+}
+
+// adminRPCAuth accepts the same credentials as the admin REST API. Keys
+// with the read scope can only get stats.
+func adminRPCAuth(token string, wss *WSS) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		var authorization string
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+		credentials, ok := authenticateAdmin(wss, token, authorization)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "Unauthorized")
+		}
+		if credentials.scope == APIKeyScopeRead && info.FullMethod != "/"+adminServiceName+"/GetStats" {
+			return nil, status.Error(codes.PermissionDenied, "Forbidden")
+		}
+		return handler(withAdminCredentials(ctx, credentials), req)
+	}
+}
+
+func (a *adminRPCServer) CreateRoom(ctx context.Context, req *AdminCreateRoomRequest) (*AdminCreateRoomResponse, error) {
+	room := req.Room
+	if room == "" {
+		room = NewUUIDBase62()
+	}
+
+	return &AdminCreateRoomResponse{
+		Room: room,
+		URL:  a.baseURL + "/call/" + url.PathEscape(room),
+	}, nil
+}
+
+func (a *adminRPCServer) CloseRoom(ctx context.Context, req *AdminCloseRoomRequest) (*AdminOperationResponse, error) {
+	room := tenantFromContext(ctx).Room(req.Room)
+	clientIDs := a.wss.LocalClientIDs(room)
+
+	a.log.Printf("Close room: %s, clients: %v, dryRun: %t", room, clientIDs, req.DryRun)
+	if !req.DryRun {
+		for _, clientID := range clientIDs {
+			a.wss.Disconnect(room, clientID)
+		}
+	}
+
+	return &AdminOperationResponse{
+		Operation: AdminOperationCloseRoom,
+		DryRun:    req.DryRun,
+		Room:      room,
+		ClientIDs: clientIDs,
+	}, nil
+}
+
+func (a *adminRPCServer) KickPeer(ctx context.Context, req *AdminKickPeerRequest) (*AdminOperationResponse, error) {
+	room := tenantFromContext(ctx).Room(req.Room)
+	if _, ok := a.wss.LocalClients(room)[req.ClientID]; !ok {
+		return nil, status.Error(codes.NotFound, "Client not found")
+	}
+
+	a.log.Printf("Kick client: %s from room: %s, dryRun: %t", req.ClientID, room, req.DryRun)
+	if !req.DryRun {
+		a.wss.kick(room, req.ClientID, "")
+	}
+
+	return &AdminOperationResponse{
+		Operation: AdminOperationKickClient,
+		DryRun:    req.DryRun,
+		Room:      room,
+		ClientIDs: []string{req.ClientID},
+	}, nil
+}
+
+func (a *adminRPCServer) MutePeer(ctx context.Context, req *AdminMutePeerRequest) (*AdminMutePeerResponse, error) {
+	room := tenantFromContext(ctx).Room(req.Room)
+	found := false
+	for _, p := range a.tracks.RoomPeers(room) {
+		if p.ClientID == req.ClientID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, status.Error(codes.NotFound, "Peer not found")
+	}
+
+	a.log.Printf("Mute client: %s in room: %s, muted: %t", req.ClientID, room, req.Muted)
+	if err := a.tracks.SetMuted(req.ClientID, req.Muted); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	a.wss.eventLog.emitMuted(room, req.ClientID, "", req.Muted)
+
+	return &AdminMutePeerResponse{}, nil
+}
+
+func (a *adminRPCServer) StartRecording(ctx context.Context, req *AdminStartRecordingRequest) (*AdminRecording, error) {
+	room := tenantFromContext(ctx).Room(req.Room)
+	if !a.wss.RoomFeatures(room).Recording {
+		return nil, status.Error(codes.FailedPrecondition, ErrRecordingNotAllowed.Error())
+	}
+
+	recording, err := a.recorder.Start(room)
+	switch {
+	case errors.Is(err, ErrRecordingDisabled):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ErrRecordingStarted):
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return newAdminRecording(recording), nil
+}
+
+func (a *adminRPCServer) StopRecording(ctx context.Context, req *AdminStopRecordingRequest) (*AdminRecording, error) {
+	room := tenantFromContext(ctx).Room(req.Room)
+	recording, ok := a.recorder.Stop(room)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "Recording not found")
+	}
+	return newAdminRecording(recording), nil
+}
+
+func newAdminRecording(recording Recording) *AdminRecording {
+	return &AdminRecording{
+		ID:        recording.ID,
+		Room:      recording.Room,
+		Dir:       recording.Dir,
+		StartedAt: recording.StartedAt.UnixNano() / 1e6,
+		Manifest:  recording.Manifest,
+	}
+}
+
+func (a *adminRPCServer) GetStats(ctx context.Context, req *AdminGetStatsRequest) (*AdminGetStatsResponse, error) {
+	tenant := tenantFromContext(ctx)
+	res := &AdminGetStatsResponse{}
+
+	for _, info := range roomInfos(a.wss, a.tracks) {
+		if !tenant.Owns(info.Room) || (req.Room != "" && info.Room != tenant.Room(req.Room)) {
+			continue
+		}
+
+		_, recording := a.recorder.Recording(info.Room)
+		roomStats := &AdminRoomStats{
+			Room:      info.Room,
+			Clients:   uint32(info.Clients),
+			Peers:     uint32(info.Peers),
+			Recording: recording,
+		}
+		for _, track := range a.tracks.RoomTracks(info.Room) {
+			roomStats.Tracks = append(roomStats.Tracks, &AdminTrackStats{
+				ClientID:    track.ClientID,
+				TrackID:     track.TrackID,
+				Kind:        track.Kind,
+				SSRC:        track.SSRC,
+				Bitrate:     track.Bitrate,
+				Subscribers: uint32(track.Subscribers),
+			})
+		}
+		res.Rooms = append(res.Rooms, roomStats)
+	}
+
+	if req.Room != "" && len(res.Rooms) == 0 {
+		return nil, status.Error(codes.NotFound, "Room not found")
+	}
+
+	return res, nil
+}
+
+// adminServiceServer is the interface checked by grpc.Server.RegisterService.
+type adminServiceServer interface {
+	CreateRoom(context.Context, *AdminCreateRoomRequest) (*AdminCreateRoomResponse, error)
+	CloseRoom(context.Context, *AdminCloseRoomRequest) (*AdminOperationResponse, error)
+	KickPeer(context.Context, *AdminKickPeerRequest) (*AdminOperationResponse, error)
+	MutePeer(context.Context, *AdminMutePeerRequest) (*AdminMutePeerResponse, error)
+	StartRecording(context.Context, *AdminStartRecordingRequest) (*AdminRecording, error)
+	StopRecording(context.Context, *AdminStopRecordingRequest) (*AdminRecording, error)
+	GetStats(context.Context, *AdminGetStatsRequest) (*AdminGetStatsResponse, error)
+}
+
+// adminMethodHandler adapts a typed method of adminServiceServer to
+// grpc.MethodDesc.
+func adminMethodHandler(
+	name string,
+	newRequest func() interface{},
+	call func(srv adminServiceServer, ctx context.Context, req interface{}) (interface{}, error),
+) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newRequest()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(adminServiceServer), ctx, req)
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + adminServiceName + "/" + name,
+			}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+var adminServiceDesc = grpc.ServiceDesc{
+	ServiceName: adminServiceName,
+	HandlerType: (*adminServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		adminMethodHandler("CreateRoom",
+			func() interface{} { return new(AdminCreateRoomRequest) },
+			func(srv adminServiceServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.CreateRoom(ctx, req.(*AdminCreateRoomRequest))
+			}),
+		adminMethodHandler("CloseRoom",
+			func() interface{} { return new(AdminCloseRoomRequest) },
+			func(srv adminServiceServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.CloseRoom(ctx, req.(*AdminCloseRoomRequest))
+			}),
+		adminMethodHandler("KickPeer",
+			func() interface{} { return new(AdminKickPeerRequest) },
+			func(srv adminServiceServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.KickPeer(ctx, req.(*AdminKickPeerRequest))
+			}),
+		adminMethodHandler("MutePeer",
+			func() interface{} { return new(AdminMutePeerRequest) },
+			func(srv adminServiceServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.MutePeer(ctx, req.(*AdminMutePeerRequest))
+			}),
+		adminMethodHandler("StartRecording",
+			func() interface{} { return new(AdminStartRecordingRequest) },
+			func(srv adminServiceServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.StartRecording(ctx, req.(*AdminStartRecordingRequest))
+			}),
+		adminMethodHandler("StopRecording",
+			func() interface{} { return new(AdminStopRecordingRequest) },
+			func(srv adminServiceServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.StopRecording(ctx, req.(*AdminStopRecordingRequest))
+			}),
+		adminMethodHandler("GetStats",
+			func() interface{} { return new(AdminGetStatsRequest) },
+			func(srv adminServiceServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.GetStats(ctx, req.(*AdminGetStatsRequest))
+			}),
+	},
+	Metadata: "adminrpc.proto",
+}
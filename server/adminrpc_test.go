@@ -0,0 +1,161 @@
+package server_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"nhooyr.io/websocket"
+)
+
+func setupAdminRPC(t *testing.T, recordingDir string) (client server.AdminServiceClient, wsURL string, cleanup func()) {
+	t.Helper()
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	tracks := newMockTracksManager()
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, server.AdminConfig{}, server.InactivityConfig{}, server.ClientConfig{}, rooms, tracks)
+	s := httptest.NewServer(mux)
+	wsURL = "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + roomName + "/" + clientID
+
+	recorder := server.NewRoomRecorder(loggerFactory, recordingDir)
+	rpc := server.NewAdminRPCServer(loggerFactory, "/peer-calls", adminToken, mux.WSS, tracks, recorder)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go rpc.Serve(l)
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+
+	cleanup = func() {
+		conn.Close()
+		rpc.Stop()
+		s.Close()
+	}
+	return server.NewAdminServiceClient(conn), wsURL, cleanup
+}
+
+func adminRPCContext(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+func TestAdminRPC_unauthenticated(t *testing.T) {
+	client, _, cleanup := setupAdminRPC(t, "")
+	defer cleanup()
+
+	ctx := adminRPCContext(context.Background(), "invalid")
+	_, err := client.CreateRoom(ctx, &server.AdminCreateRoomRequest{Room: roomName})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestAdminRPC_CreateRoom(t *testing.T) {
+	client, _, cleanup := setupAdminRPC(t, "")
+	defer cleanup()
+	ctx := adminRPCContext(context.Background(), adminToken)
+
+	res, err := client.CreateRoom(ctx, &server.AdminCreateRoomRequest{Room: "my room"})
+	require.NoError(t, err)
+	assert.Equal(t, "my room", res.Room)
+	assert.Equal(t, "/peer-calls/call/my%20room", res.URL)
+
+	res, err = client.CreateRoom(ctx, &server.AdminCreateRoomRequest{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, res.Room)
+}
+
+func TestAdminRPC_KickPeer_CloseRoom(t *testing.T) {
+	client, wsURL, cleanup := setupAdminRPC(t, "")
+	defer cleanup()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx = adminRPCContext(ctx, adminToken)
+
+	ws := mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+
+	require.Eventually(t, func() bool {
+		_, err := client.KickPeer(ctx, &server.AdminKickPeerRequest{Room: roomName, ClientID: clientID, DryRun: true})
+		return err == nil
+	}, timeout, 10*time.Millisecond)
+
+	_, err := client.KickPeer(ctx, &server.AdminKickPeerRequest{Room: roomName, ClientID: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	res, err := client.CloseRoom(ctx, &server.AdminCloseRoomRequest{Room: roomName})
+	require.NoError(t, err)
+	assert.Equal(t, server.AdminOperationCloseRoom, res.Operation)
+	assert.Equal(t, []string{clientID}, res.ClientIDs)
+
+	mustReadUntilClosed(t, ctx, ws)
+}
+
+func TestAdminRPC_MutePeer(t *testing.T) {
+	client, _, cleanup := setupAdminRPC(t, "")
+	defer cleanup()
+	ctx := adminRPCContext(context.Background(), adminToken)
+
+	_, err := client.MutePeer(ctx, &server.AdminMutePeerRequest{Room: roomName, ClientID: "zombie", Muted: true})
+	assert.NoError(t, err)
+
+	_, err = client.MutePeer(ctx, &server.AdminMutePeerRequest{Room: roomName, ClientID: "missing", Muted: true})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestAdminRPC_recording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	client, _, cleanup := setupAdminRPC(t, dir)
+	defer cleanup()
+	ctx := adminRPCContext(context.Background(), adminToken)
+
+	recording, err := client.StartRecording(ctx, &server.AdminStartRecordingRequest{Room: roomName})
+	require.NoError(t, err)
+	assert.Equal(t, roomName, recording.Room)
+	assert.DirExists(t, recording.Dir)
+
+	_, err = client.StartRecording(ctx, &server.AdminStartRecordingRequest{Room: roomName})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	stats, err := client.GetStats(ctx, &server.AdminGetStatsRequest{Room: roomName})
+	require.NoError(t, err)
+	require.Len(t, stats.Rooms, 1)
+	assert.True(t, stats.Rooms[0].Recording)
+	assert.Equal(t, uint32(1), stats.Rooms[0].Peers)
+	require.Len(t, stats.Rooms[0].Tracks, 1)
+	assert.Equal(t, uint64(32000), stats.Rooms[0].Tracks[0].Bitrate)
+
+	stopped, err := client.StopRecording(ctx, &server.AdminStopRecordingRequest{Room: roomName})
+	require.NoError(t, err)
+	assert.Equal(t, recording.ID, stopped.ID)
+	assert.Equal(t, filepath.Join(recording.Dir, "manifest.json"), stopped.Manifest)
+
+	_, err = client.StopRecording(ctx, &server.AdminStopRecordingRequest{Room: roomName})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.GetStats(ctx, &server.AdminGetStatsRequest{Room: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestAdminRPC_recordingDisabled(t *testing.T) {
+	client, _, cleanup := setupAdminRPC(t, "")
+	defer cleanup()
+	ctx := adminRPCContext(context.Background(), adminToken)
+
+	_, err := client.StartRecording(ctx, &server.AdminStartRecordingRequest{Room: roomName})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
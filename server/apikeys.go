@@ -0,0 +1,430 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// APIKeyScope decides what an API key can be used for.
+type APIKeyScope string
+
+const (
+	// APIKeyScopeRead only allows reading stats and state via the admin APIs.
+	APIKeyScopeRead APIKeyScope = "read"
+	// APIKeyScopeAdmin allows full control via the admin APIs.
+	APIKeyScopeAdmin APIKeyScope = "admin"
+	// APIKeyScopeWebhook keys sign webhook requests and are not accepted by
+	// the admin APIs.
+	APIKeyScopeWebhook APIKeyScope = "webhook"
+)
+
+// Valid returns true when s is a known scope.
+func (s APIKeyScope) Valid() bool {
+	switch s {
+	case APIKeyScopeRead, APIKeyScopeAdmin, APIKeyScopeWebhook:
+		return true
+	default:
+		return false
+	}
+}
+
+// apiKeyPrefix starts every API key, so that leaked keys are easy to find.
+const apiKeyPrefix = "pck_"
+
+var (
+	ErrInvalidAPIKey  = errors.New("invalid API key")
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+// APIKey is an issued API key. The key itself is only returned when it is
+// issued or rotated, only its hash is stored.
+type APIKey struct {
+	ID    string      `json:"id"`
+	Name  string      `json:"name"`
+	Scope APIKeyScope `json:"scope"`
+	// Tenant limits the key to the rooms of a tenant, when set.
+	Tenant string `json:"tenant,omitempty"`
+	// Hash is the hex encoded SHA-256 of the key.
+	Hash string `json:"hash,omitempty"`
+	// WebhookSecret is the secret webhook requests are signed with, only set
+	// for keys with the webhook scope. It is independent of the key, so that
+	// the hash of the key cannot be used to sign requests.
+	WebhookSecret string     `json:"webhookSecret,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	RotatedAt     *time.Time `json:"rotatedAt,omitempty"`
+	// ExpiresAt is when the key stops being accepted, when set.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Public returns k without its hash and webhook secret.
+func (k APIKey) Public() APIKey {
+	k.Hash = ""
+	k.WebhookSecret = ""
+	return k
+}
+
+func (k APIKey) expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// APIKeyStore keeps issued API keys.
+type APIKeyStore interface {
+	SaveAPIKey(key APIKey) error
+	// APIKey returns false when no key with id exists.
+	APIKey(id string) (APIKey, bool, error)
+	APIKeys() ([]APIKey, error)
+	// DeleteAPIKey returns false when no key with id existed.
+	DeleteAPIKey(id string) (bool, error)
+}
+
+type MemoryAPIKeyStore struct {
+	mu sync.Mutex
+	// key is ID of the API key
+	keys map[string]APIKey
+}
+
+var _ APIKeyStore = &MemoryAPIKeyStore{}
+
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{
+		keys: map[string]APIKey{},
+	}
+}
+
+func (s *MemoryAPIKeyStore) SaveAPIKey(key APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[key.ID] = key
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) APIKey(id string) (APIKey, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	return key, ok, nil
+}
+
+func (s *MemoryAPIKeyStore) APIKeys() ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *MemoryAPIKeyStore) DeleteAPIKey(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.keys[id]
+	delete(s.keys, id)
+	return ok, nil
+}
+
+// RedisAPIKeyStore keeps API keys in a redis hash, so that keys issued on
+// one instance are accepted by all instances sharing the store.
+type RedisAPIKeyStore struct {
+	client *redis.Client
+	key    string
+}
+
+var _ APIKeyStore = &RedisAPIKeyStore{}
+
+func NewRedisAPIKeyStore(client *redis.Client, prefix string) *RedisAPIKeyStore {
+	return &RedisAPIKeyStore{
+		client: client,
+		key:    prefix + ":apikeys",
+	}
+}
+
+func (s *RedisAPIKeyStore) SaveAPIKey(key APIKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(s.key, key.ID, data).Err()
+}
+
+func (s *RedisAPIKeyStore) APIKey(id string) (APIKey, bool, error) {
+	var key APIKey
+
+	value, err := s.client.HGet(s.key, id).Result()
+	if err == redis.Nil {
+		return key, false, nil
+	}
+	if err != nil {
+		return key, false, err
+	}
+
+	err = json.Unmarshal([]byte(value), &key)
+	return key, err == nil, err
+}
+
+func (s *RedisAPIKeyStore) APIKeys() ([]APIKey, error) {
+	values, err := s.client.HGetAll(s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]APIKey, 0, len(values))
+	for _, value := range values {
+		var key APIKey
+		if err := json.Unmarshal([]byte(value), &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *RedisAPIKeyStore) DeleteAPIKey(id string) (bool, error) {
+	deleted, err := s.client.HDel(s.key, id).Result()
+	return deleted > 0, err
+}
+
+// APIKeys issues, rotates and revokes API keys, and authenticates requests
+// made with them. Keys have the form pck_<id>_<secret>. A nil APIKeys
+// accepts no keys.
+type APIKeys struct {
+	log   Logger
+	store APIKeyStore
+}
+
+func NewAPIKeys(loggerFactory LoggerFactory, store APIKeyStore) *APIKeys {
+	return &APIKeys{
+		log:   loggerFactory.GetLogger("apikeys"),
+		store: store,
+	}
+}
+
+func newAPIKeySecret(id string) string {
+	return apiKeyPrefix + id + "_" + NewUUIDBase62() + NewUUIDBase62()
+}
+
+// newWebhookSecret returns a new webhook secret for key, or an empty string
+// when key does not have the webhook scope.
+func newWebhookSecret(key APIKey) string {
+	if key.Scope != APIKeyScopeWebhook {
+		return ""
+	}
+	return NewUUIDBase62() + NewUUIDBase62()
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue creates a key with the name, scope, tenant and expiration time of
+// req. Returns the stored key and the key itself, which cannot be retrieved
+// again. Keys with the webhook scope also get a new webhook secret.
+func (k *APIKeys) Issue(req APIKey) (APIKey, string, error) {
+	if !req.Scope.Valid() {
+		return req, "", fmt.Errorf("%w scope: %q", ErrInvalidAPIKey, req.Scope)
+	}
+
+	key := APIKey{
+		ID:        NewUUIDBase62(),
+		Name:      req.Name,
+		Scope:     req.Scope,
+		Tenant:    req.Tenant,
+		CreatedAt: time.Now(),
+		ExpiresAt: req.ExpiresAt,
+	}
+	secret := newAPIKeySecret(key.ID)
+	key.Hash = hashAPIKey(secret)
+	key.WebhookSecret = newWebhookSecret(key)
+
+	if err := k.store.SaveAPIKey(key); err != nil {
+		return key, "", err
+	}
+	k.log.Printf("Issued API key: %s (%s) with scope: %s", key.ID, key.Name, key.Scope)
+	return key, secret, nil
+}
+
+// Key returns the stored key with id, or ErrAPIKeyNotFound.
+func (k *APIKeys) Key(id string) (APIKey, error) {
+	key, ok, err := k.store.APIKey(id)
+	if err != nil {
+		return key, err
+	}
+	if !ok {
+		return key, ErrAPIKeyNotFound
+	}
+	return key, nil
+}
+
+// Rotate replaces the key with id with a new one, keeping its settings. The
+// previous key and webhook secret are no longer accepted.
+func (k *APIKeys) Rotate(id string) (APIKey, string, error) {
+	key, err := k.Key(id)
+	if err != nil {
+		return key, "", err
+	}
+
+	now := time.Now()
+	secret := newAPIKeySecret(key.ID)
+	key.Hash = hashAPIKey(secret)
+	key.WebhookSecret = newWebhookSecret(key)
+	key.RotatedAt = &now
+
+	if err := k.store.SaveAPIKey(key); err != nil {
+		return key, "", err
+	}
+	k.log.Printf("Rotated API key: %s (%s)", key.ID, key.Name)
+	return key, secret, nil
+}
+
+// Revoke deletes the key with id. Returns false when it did not exist.
+func (k *APIKeys) Revoke(id string) (bool, error) {
+	revoked, err := k.store.DeleteAPIKey(id)
+	if revoked {
+		k.log.Printf("Revoked API key: %s", id)
+	}
+	return revoked, err
+}
+
+// Keys returns all keys sorted by creation time.
+func (k *APIKeys) Keys() ([]APIKey, error) {
+	keys, err := k.store.APIKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, err
+}
+
+// Authenticate returns the stored key of secret when it is a valid key at
+// now.
+func (k *APIKeys) Authenticate(secret string, now time.Time) (APIKey, bool) {
+	if k == nil || !strings.HasPrefix(secret, apiKeyPrefix) {
+		return APIKey{}, false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(secret, apiKeyPrefix), "_", 2)
+	if len(parts) != 2 {
+		return APIKey{}, false
+	}
+
+	key, ok, err := k.store.APIKey(parts[0])
+	if err != nil {
+		k.log.Printf("Error reading API key: %s: %s", parts[0], err)
+		return key, false
+	}
+	if !ok || key.expired(now) {
+		return key, false
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(secret)), []byte(key.Hash)) != 1 {
+		return key, false
+	}
+	return key, true
+}
+
+// webhookSecret returns the secret webhook requests are signed with when
+// they are signed with the webhook key with id.
+func (k *APIKeys) webhookSecret(id string, now time.Time) (string, error) {
+	if k == nil {
+		return "", ErrAPIKeyNotFound
+	}
+
+	key, ok, err := k.store.APIKey(id)
+	if err != nil {
+		return "", err
+	}
+	if !ok || key.expired(now) || key.Scope != APIKeyScopeWebhook {
+		return "", ErrAPIKeyNotFound
+	}
+	if key.WebhookSecret == "" {
+		// keys issued before webhook secrets were introduced
+		return "", fmt.Errorf("%w: no webhook secret, the key must be rotated", ErrInvalidAPIKey)
+	}
+	return key.WebhookSecret, nil
+}
+
+// SetAPIKeys replaces the default API keys accepted by the admin APIs, which
+// are kept in memory. It must be called before any requests are handled.
+func (wss *WSS) SetAPIKeys(keys *APIKeys) {
+	wss.apiKeys = keys
+}
+
+// APIKeys returns the API keys set with SetAPIKeys.
+func (wss *WSS) APIKeys() *APIKeys {
+	return wss.apiKeys
+}
+
+// adminCredentials describe what an admin API request was authenticated
+// with.
+type adminCredentials struct {
+	scope APIKeyScope
+	// tenant is nil unless the credentials are limited to a tenant
+	tenant *Tenant
+	// keyID is the ID of the issued API key, empty for the admin token and
+	// API keys of tenants
+	keyID string
+}
+
+// authenticateAdmin authenticates the authorization header of an admin API
+// request. It accepts the admin token unless token is empty, the API keys of
+// tenants and issued API keys.
+func authenticateAdmin(wss *WSS, token string, authorization string) (adminCredentials, bool) {
+	if token != "" && subtle.ConstantTimeCompare([]byte("Bearer "+token), []byte(authorization)) == 1 {
+		return adminCredentials{scope: APIKeyScopeAdmin}, true
+	}
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return adminCredentials{}, false
+	}
+	secret := strings.TrimPrefix(authorization, "Bearer ")
+
+	if tenant, ok := wss.Tenants().ByAPIKey(secret); ok {
+		return adminCredentials{scope: APIKeyScopeAdmin, tenant: tenant}, true
+	}
+
+	key, ok := wss.APIKeys().Authenticate(secret, time.Now())
+	if !ok || key.Scope == APIKeyScopeWebhook {
+		return adminCredentials{}, false
+	}
+
+	credentials := adminCredentials{scope: key.Scope, keyID: key.ID}
+	if key.Tenant != "" {
+		if credentials.tenant, ok = wss.Tenants().Tenant(key.Tenant); !ok {
+			return adminCredentials{}, false
+		}
+	}
+	return credentials, true
+}
+
+type adminCredentialsContextKey struct{}
+
+func withAdminCredentials(ctx context.Context, credentials adminCredentials) context.Context {
+	return context.WithValue(ctx, adminCredentialsContextKey{}, credentials)
+}
+
+func adminCredentialsFromContext(ctx context.Context) adminCredentials {
+	credentials, _ := ctx.Value(adminCredentialsContextKey{}).(adminCredentials)
+	return credentials
+}
+
+// tenantFromContext returns the tenant an admin request was authenticated
+// as, nil when it has access to all rooms.
+func tenantFromContext(ctx context.Context) *Tenant {
+	return adminCredentialsFromContext(ctx).tenant
+}
@@ -0,0 +1,222 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testAPIKeyStore(t *testing.T, store server.APIKeyStore) {
+	t.Helper()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	key := server.APIKey{ID: "a", Name: "dashboard", Scope: server.APIKeyScopeRead, Hash: "hash", CreatedAt: now}
+	require.NoError(t, store.SaveAPIKey(key))
+	require.NoError(t, store.SaveAPIKey(server.APIKey{ID: "b", Scope: server.APIKeyScopeAdmin, CreatedAt: now}))
+
+	result, ok, err := store.APIKey("a")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, key, result)
+
+	_, ok, err = store.APIKey("c")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	keys, err := store.APIKeys()
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	deleted, err := store.DeleteAPIKey("a")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	deleted, err = store.DeleteAPIKey("a")
+	require.NoError(t, err)
+	assert.False(t, deleted)
+}
+
+func TestMemoryAPIKeyStore(t *testing.T) {
+	testAPIKeyStore(t, server.NewMemoryAPIKeyStore())
+}
+
+func TestRedisAPIKeyStore(t *testing.T) {
+	pub, _, stop := configureRedis(t)
+	defer stop()
+	prefix := "peercalls-test-" + server.NewUUIDBase62()
+	defer func() {
+		keys, _ := pub.Keys(prefix + ":*").Result()
+		if len(keys) > 0 {
+			pub.Del(keys...)
+		}
+	}()
+	testAPIKeyStore(t, server.NewRedisAPIKeyStore(pub, prefix))
+}
+
+func TestAPIKeys(t *testing.T) {
+	apiKeys := server.NewAPIKeys(loggerFactory, server.NewMemoryAPIKeyStore())
+	now := time.Now()
+
+	_, _, err := apiKeys.Issue(server.APIKey{Scope: "invalid"})
+	assert.True(t, errors.Is(err, server.ErrInvalidAPIKey))
+
+	expiresAt := now.Add(time.Hour)
+	key, secret, err := apiKeys.Issue(server.APIKey{Name: "dashboard", Scope: server.APIKeyScopeRead, ExpiresAt: &expiresAt})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(secret, "pck_"+key.ID+"_"))
+	assert.NotContains(t, key.Hash, secret, "only the hash is stored")
+
+	result, ok := apiKeys.Authenticate(secret, now)
+	assert.True(t, ok)
+	assert.Equal(t, key.ID, result.ID)
+	assert.Equal(t, server.APIKeyScopeRead, result.Scope)
+
+	_, ok = apiKeys.Authenticate(secret, expiresAt)
+	assert.False(t, ok, "expired")
+	_, ok = apiKeys.Authenticate(secret+"x", now)
+	assert.False(t, ok)
+	_, ok = apiKeys.Authenticate("invalid", now)
+	assert.False(t, ok)
+
+	rotated, rotatedSecret, err := apiKeys.Rotate(key.ID)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, rotated.ID)
+	assert.NotNil(t, rotated.RotatedAt)
+	_, ok = apiKeys.Authenticate(secret, now)
+	assert.False(t, ok, "previous key")
+	_, ok = apiKeys.Authenticate(rotatedSecret, now)
+	assert.True(t, ok)
+
+	_, _, err = apiKeys.Rotate("missing")
+	assert.Equal(t, server.ErrAPIKeyNotFound, err)
+
+	revoked, err := apiKeys.Revoke(key.ID)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+	_, ok = apiKeys.Authenticate(rotatedSecret, now)
+	assert.False(t, ok, "revoked")
+
+	var nilKeys *server.APIKeys
+	_, ok = nilKeys.Authenticate(rotatedSecret, now)
+	assert.False(t, ok)
+}
+
+func issueAPIKeyRequest(t *testing.T, url string, token string, req server.APIKey) (int, server.AdminAPIKey) {
+	t.Helper()
+	body, err := json.Marshal(req)
+	require.NoError(t, err)
+	httpReq, err := http.NewRequest("POST", url+"/api/admin/keys", bytes.NewReader(body))
+	require.NoError(t, err)
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(httpReq)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	var result server.AdminAPIKey
+	json.NewDecoder(res.Body).Decode(&result)
+	return res.StatusCode, result
+}
+
+func apiKeyRequest(t *testing.T, method string, url string, token string) (int, server.AdminAPIKey) {
+	t.Helper()
+	httpReq, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err)
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(httpReq)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	var result server.AdminAPIKey
+	json.NewDecoder(res.Body).Decode(&result)
+	return res.StatusCode, result
+}
+
+func TestAPIKeys_admin(t *testing.T) {
+	s, _ := setupAdminServer(t)
+	defer s.Close()
+
+	statusCode, read := issueAPIKeyRequest(t, s.URL, adminToken, server.APIKey{Name: "stats", Scope: server.APIKeyScopeRead})
+	require.Equal(t, http.StatusCreated, statusCode)
+	assert.NotEmpty(t, read.Key)
+	assert.Empty(t, read.Hash)
+
+	statusCode, admin := issueAPIKeyRequest(t, s.URL, adminToken, server.APIKey{Scope: server.APIKeyScopeAdmin})
+	require.Equal(t, http.StatusCreated, statusCode)
+	statusCode, webhook := issueAPIKeyRequest(t, s.URL, adminToken, server.APIKey{Scope: server.APIKeyScopeWebhook})
+	require.Equal(t, http.StatusCreated, statusCode)
+	assert.NotEmpty(t, webhook.WebhookSecret)
+	assert.Empty(t, read.WebhookSecret)
+
+	statusCode, _ = issueAPIKeyRequest(t, s.URL, adminToken, server.APIKey{Scope: server.APIKeyScopeRead, Tenant: "acme"})
+	assert.Equal(t, http.StatusBadRequest, statusCode, "unknown tenant")
+
+	url := s.URL + "/api/admin/rooms/" + roomName
+	statusCode, _ = adminRequest(t, "GET", url+"/egress", read.Key)
+	assert.Equal(t, http.StatusOK, statusCode)
+	statusCode, _ = adminRequest(t, "DELETE", url+"?dryRun=true", read.Key)
+	assert.Equal(t, http.StatusForbidden, statusCode)
+	statusCode, _ = adminRequest(t, "DELETE", url+"?dryRun=true", admin.Key)
+	assert.Equal(t, http.StatusOK, statusCode)
+	statusCode, _ = adminRequest(t, "GET", url+"/egress", webhook.Key)
+	assert.Equal(t, http.StatusUnauthorized, statusCode)
+
+	// only the admin token manages keys
+	statusCode, _ = issueAPIKeyRequest(t, s.URL, admin.Key, server.APIKey{Scope: server.APIKeyScopeAdmin})
+	assert.Equal(t, http.StatusForbidden, statusCode)
+
+	keysURL := s.URL + "/api/admin/keys/"
+	statusCode, rotated := apiKeyRequest(t, "POST", keysURL+webhook.ID+"/rotate?dryRun=true", adminToken)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.True(t, rotated.DryRun)
+	assert.Equal(t, webhook.ID, rotated.ID)
+	assert.Empty(t, rotated.Key)
+	assert.Empty(t, rotated.WebhookSecret)
+	statusCode, _ = apiKeyRequest(t, "POST", keysURL+"missing/rotate?dryRun=true", adminToken)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+
+	statusCode, rotated = apiKeyRequest(t, "POST", keysURL+webhook.ID+"/rotate", adminToken)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.False(t, rotated.DryRun)
+	assert.NotEmpty(t, rotated.Key)
+	assert.NotEmpty(t, rotated.WebhookSecret)
+	assert.NotEqual(t, webhook.WebhookSecret, rotated.WebhookSecret)
+
+	statusCode, revoked := apiKeyRequest(t, "DELETE", keysURL+admin.ID+"?dryRun=true", adminToken)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.True(t, revoked.DryRun)
+	assert.Equal(t, admin.ID, revoked.ID)
+	statusCode, _ = adminRequest(t, "DELETE", url+"?dryRun=true", admin.Key)
+	assert.Equal(t, http.StatusOK, statusCode, "not revoked on dry run")
+
+	statusCode, _ = adminRequest(t, "DELETE", keysURL+admin.ID, adminToken)
+	assert.Equal(t, http.StatusNoContent, statusCode)
+	statusCode, _ = adminRequest(t, "DELETE", url+"?dryRun=true", admin.Key)
+	assert.Equal(t, http.StatusUnauthorized, statusCode)
+	statusCode, _ = adminRequest(t, "DELETE", s.URL+"/api/admin/keys/"+admin.ID, adminToken)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+}
+
+func TestAPIKeys_requireAPIKeys(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	admin := server.AdminConfig{Token: adminToken, RequireAPIKeys: true}
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, admin, server.InactivityConfig{}, server.ClientConfig{}, rooms, newMockTracksManager())
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	statusCode, _ := adminRequest(t, "GET", s.URL+"/api/admin/registry/rooms", adminToken)
+	assert.Equal(t, http.StatusUnauthorized, statusCode)
+
+	statusCode, key := issueAPIKeyRequest(t, s.URL, adminToken, server.APIKey{Scope: server.APIKeyScopeRead})
+	require.Equal(t, http.StatusCreated, statusCode)
+
+	statusCode, _ = adminRequest(t, "GET", s.URL+"/api/admin/registry/rooms", key.Key)
+	assert.Equal(t, http.StatusOK, statusCode)
+}
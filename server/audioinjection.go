@@ -0,0 +1,144 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v2"
+	"github.com/pion/webrtc/v2/pkg/media"
+)
+
+// audioInjectionDelay gives subscribers time to negotiate the injected track
+// before playback starts, so that the beginning is not cut off.
+const audioInjectionDelay = time.Second
+
+var ErrRoomNotFound = errors.New("room not found")
+
+type audioInjection struct {
+	id    string
+	room  string
+	track *webrtc.Track
+
+	stopChannel chan struct{}
+	stopOnce    sync.Once
+}
+
+func (a *audioInjection) stop() {
+	a.stopOnce.Do(func() {
+		close(a.stopChannel)
+	})
+}
+
+// InjectAudio plays an Ogg Opus stream into room on a server-originated
+// track, which is forwarded to all peers in the room regardless of their
+// subscriptions. Returns the ID of the injection, which can be used to stop
+// it early.
+func (t *MemoryTracksManager) InjectAudio(room string, reader io.Reader) (string, error) {
+	opusReader, err := NewOggOpusReader(reader)
+	if err != nil {
+		return "", err
+	}
+
+	injection, err := t.newAudioInjection(room)
+	if err != nil {
+		return "", err
+	}
+
+	go t.playAudio(injection, opusReader)
+
+	return injection.id, nil
+}
+
+// newAudioInjection creates a server-originated audio track and forwards it
+// to all peers in room.
+func (t *MemoryTracksManager) newAudioInjection(room string) (*audioInjection, error) {
+	id := NewUUIDBase62()
+	codec := webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, opusSampleRate)
+	track, err := webrtc.NewTrack(
+		webrtc.DefaultPayloadTypeOpus,
+		rand.Uint32(),
+		getLocalTrackID(id),
+		"sfu_"+localPeerID+"_"+id,
+		codec,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating injected audio track: %w", err)
+	}
+
+	injection := &audioInjection{
+		id:          id,
+		room:        room,
+		track:       track,
+		stopChannel: make(chan struct{}),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.peerIDsByRoom[room]; !ok {
+		return nil, ErrRoomNotFound
+	}
+	t.log.Printf("InjectAudio: %s into room: %s", id, room)
+	t.injections[id] = injection
+	t.addServerTrack(room, track)
+
+	return injection, nil
+}
+
+// StopAudio stops an audio or DTMF injection in room. Returns false when the
+// injection was not found.
+func (t *MemoryTracksManager) StopAudio(room string, id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	injection, ok := t.injections[id]
+	if !ok || injection.room != room {
+		return false
+	}
+
+	injection.stop()
+	return true
+}
+
+func (t *MemoryTracksManager) playAudio(injection *audioInjection, reader *OggOpusReader) {
+	defer t.removeInjection(injection)
+
+	next := time.Now().Add(audioInjectionDelay)
+	for {
+		select {
+		case <-time.After(time.Until(next)):
+		case <-injection.stopChannel:
+			return
+		}
+
+		packet, err := reader.ReadPacket()
+		if err != nil {
+			if err != io.EOF {
+				t.log.Printf("InjectAudio: Error reading audio: %s: %s", injection.id, err)
+			}
+			return
+		}
+
+		samples := OpusPacketSamples(packet)
+		err = injection.track.WriteSample(media.Sample{Data: packet, Samples: samples})
+		if err != nil && err != io.ErrClosedPipe {
+			t.log.Printf("InjectAudio: Error writing audio: %s: %s", injection.id, err)
+			return
+		}
+
+		next = next.Add(time.Duration(samples) * time.Second / opusSampleRate)
+	}
+}
+
+func (t *MemoryTracksManager) removeInjection(injection *audioInjection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.log.Printf("InjectAudio: %s finished in room: %s", injection.id, injection.room)
+	delete(t.injections, injection.id)
+	t.removeServerTrack(injection.room, injection.track)
+}
@@ -0,0 +1,415 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/sdp/v2"
+	"github.com/pion/webrtc/v2"
+)
+
+const (
+	// AuditEventDTLSFingerprint is emitted when a peer connection is
+	// established, with the fingerprint of the peer's DTLS certificate.
+	AuditEventDTLSFingerprint = "dtls_fingerprint"
+	// AuditEventSRTPProfile is emitted with the SRTP protection profile of an
+	// established peer connection.
+	AuditEventSRTPProfile = "srtp_profile"
+	// AuditEventTURNRelay is emitted when the selected candidate pair of a
+	// peer connection uses a TURN relay.
+	AuditEventTURNRelay = "turn_relay"
+	// AuditEventE2EEEnabled and AuditEventE2EEDisabled are emitted when the
+	// end-to-end encryption setting of a room changes. A room is first
+	// observed when a peer joins it.
+	AuditEventE2EEEnabled  = "e2ee_enabled"
+	AuditEventE2EEDisabled = "e2ee_disabled"
+)
+
+// auditSRTPProfile is the only SRTP protection profile offered by pion, so
+// it is always the negotiated one.
+const auditSRTPProfile = "SRTP_AES128_CM_HMAC_SHA1_80"
+
+// auditQueueSize is the number of events buffered for slow sinks before new
+// events are dropped.
+const auditQueueSize = 256
+
+// AuditEvent is a security-relevant event of a room or a peer connection.
+type AuditEvent struct {
+	Time     time.Time         `json:"time"`
+	Type     string            `json:"type"`
+	Room     string            `json:"room"`
+	ClientID string            `json:"clientId,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+}
+
+// AuditSink receives audit events, for example to forward them to a SIEM.
+type AuditSink interface {
+	WriteAuditEvent(event AuditEvent) error
+}
+
+// AuditLog writes audit events to the audit logger and to sinks. Events are
+// written asynchronously so that slow sinks do not delay signalling. A nil
+// AuditLog discards all events.
+type AuditLog struct {
+	log   Logger
+	sinks []AuditSink
+
+	// eventsMu guards closing events
+	eventsMu sync.RWMutex
+	closed   bool
+	events   chan AuditEvent
+	done     chan struct{}
+
+	mu sync.Mutex
+	// key is room, value is true when media is encrypted end-to-end
+	e2eeByRoom map[string]bool
+}
+
+func NewAuditLog(loggerFactory LoggerFactory, sinks ...AuditSink) *AuditLog {
+	a := &AuditLog{
+		log:        loggerFactory.GetLogger("audit"),
+		sinks:      sinks,
+		events:     make(chan AuditEvent, auditQueueSize),
+		done:       make(chan struct{}),
+		e2eeByRoom: map[string]bool{},
+	}
+	go a.run()
+	return a
+}
+
+func (a *AuditLog) run() {
+	defer close(a.done)
+
+	for event := range a.events {
+		log := a.log.WithCtx(LogCtx{"room": event.Room, "clientID": event.ClientID})
+		log.Printf("%s %s", event.Type, formatAuditDetails(event.Details))
+
+		for _, sink := range a.sinks {
+			if err := sink.WriteAuditEvent(event); err != nil {
+				log.Printf("Error writing audit event: %s: %s", event.Type, err)
+			}
+		}
+	}
+}
+
+func formatAuditDetails(details map[string]string) string {
+	keys := make([]string, 0, len(details))
+	for key := range details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = key + "=" + details[key]
+	}
+	return strings.Join(values, " ")
+}
+
+// Emit queues event for writing. The event is dropped when the queue is
+// full or the AuditLog is closed.
+func (a *AuditLog) Emit(event AuditEvent) {
+	if a == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	a.eventsMu.RLock()
+	defer a.eventsMu.RUnlock()
+
+	if a.closed {
+		return
+	}
+
+	select {
+	case a.events <- event:
+	default:
+		a.log.Printf("Audit queue full, dropping event: %s in room: %s", event.Type, event.Room)
+	}
+}
+
+// Close writes all queued events and stops the AuditLog.
+func (a *AuditLog) Close() {
+	if a == nil {
+		return
+	}
+
+	a.eventsMu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.events)
+	}
+	a.eventsMu.Unlock()
+
+	<-a.done
+}
+
+// observeRoomE2EE emits an event when the end-to-end encryption setting of
+// room differs from the last observed one.
+func (a *AuditLog) observeRoomE2EE(room string, clientID string, e2ee bool) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	previous, ok := a.e2eeByRoom[room]
+	a.e2eeByRoom[room] = e2ee
+	a.mu.Unlock()
+
+	if ok && previous == e2ee || !ok && !e2ee {
+		return
+	}
+
+	eventType := AuditEventE2EEDisabled
+	if e2ee {
+		eventType = AuditEventE2EEEnabled
+	}
+	a.Emit(AuditEvent{Type: eventType, Room: room, ClientID: clientID})
+}
+
+// forgetRoom is called when the last peer leaves room.
+func (a *AuditLog) forgetRoom(room string) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.e2eeByRoom, room)
+}
+
+// observePeerConnection emits connection security events once the peer
+// connection of clientID is established.
+func (a *AuditLog) observePeerConnection(room string, clientID string, pc *webrtc.PeerConnection) {
+	if a == nil {
+		return
+	}
+
+	var once sync.Once
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			once.Do(func() {
+				go a.peerConnected(room, clientID, pc)
+			})
+		}
+	})
+}
+
+func (a *AuditLog) peerConnected(room string, clientID string, pc *webrtc.PeerConnection) {
+	if remoteDescription := pc.RemoteDescription(); remoteDescription != nil {
+		if fingerprint, ok := sdpFingerprint(remoteDescription.SDP); ok {
+			a.Emit(AuditEvent{
+				Type:     AuditEventDTLSFingerprint,
+				Room:     room,
+				ClientID: clientID,
+				Details:  map[string]string{"fingerprint": fingerprint},
+			})
+		}
+	}
+
+	a.Emit(AuditEvent{
+		Type:     AuditEventSRTPProfile,
+		Room:     room,
+		ClientID: clientID,
+		Details:  map[string]string{"profile": auditSRTPProfile},
+	})
+
+	if details, ok := relayedCandidatePair(pc.GetStats()); ok {
+		a.Emit(AuditEvent{
+			Type:     AuditEventTURNRelay,
+			Room:     room,
+			ClientID: clientID,
+			Details:  details,
+		})
+	}
+}
+
+// sdpFingerprint returns the value of the first fingerprint attribute in
+// the session or in any media section.
+func sdpFingerprint(rawSDP string) (string, bool) {
+	var desc sdp.SessionDescription
+	if err := desc.Unmarshal([]byte(rawSDP)); err != nil {
+		return "", false
+	}
+
+	if fingerprint, ok := desc.Attribute("fingerprint"); ok {
+		return fingerprint, true
+	}
+	for _, media := range desc.MediaDescriptions {
+		if fingerprint, ok := media.Attribute("fingerprint"); ok {
+			return fingerprint, true
+		}
+	}
+	return "", false
+}
+
+// relayedCandidatePair returns details of the nominated candidate pair when
+// either of its candidates is a TURN relay.
+func relayedCandidatePair(report webrtc.StatsReport) (map[string]string, bool) {
+	candidate := func(id string) (webrtc.ICECandidateStats, bool) {
+		stats, ok := report[id].(webrtc.ICECandidateStats)
+		return stats, ok
+	}
+
+	for _, stats := range report {
+		pair, ok := stats.(webrtc.ICECandidatePairStats)
+		if !ok || !pair.Nominated {
+			continue
+		}
+
+		local, localOK := candidate(pair.LocalCandidateID)
+		remote, remoteOK := candidate(pair.RemoteCandidateID)
+		if !localOK || !remoteOK {
+			continue
+		}
+
+		if local.CandidateType != webrtc.ICECandidateTypeRelay && remote.CandidateType != webrtc.ICECandidateTypeRelay {
+			return nil, false
+		}
+
+		return map[string]string{
+			"local":  fmt.Sprintf("%s %s", local.CandidateType, net.JoinHostPort(local.IP, fmt.Sprint(local.Port))),
+			"remote": fmt.Sprintf("%s %s", remote.CandidateType, net.JoinHostPort(remote.IP, fmt.Sprint(remote.Port))),
+		}, true
+	}
+
+	return nil, false
+}
+
+// HTTPAuditSink posts each event as JSON to a URL.
+type HTTPAuditSink struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewHTTPAuditSink creates a sink which posts events to url. When token is
+// set, it is sent as a bearer token.
+func NewHTTPAuditSink(url string, token string, client *http.Client) *HTTPAuditSink {
+	return &HTTPAuditSink{
+		url:    url,
+		token:  token,
+		client: client,
+	}
+}
+
+func (s *HTTPAuditSink) WriteAuditEvent(event AuditEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("Audit sink responded with: %s", res.Status)
+	}
+	return nil
+}
+
+const (
+	// syslogPriority is facility authpriv (10) with severity notice (5).
+	syslogPriority = 10*8 + 5
+	syslogAppName  = "peer-calls"
+)
+
+// SyslogAuditSink writes events as RFC 5424 syslog messages with a JSON
+// body. Over TCP, messages are framed with octet counting (RFC 6587).
+type SyslogAuditSink struct {
+	network  string
+	addr     string
+	hostname string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogAuditSink creates a sink which sends events to a syslog server
+// at addr. Network is udp or tcp. The connection is established on first
+// use and re-established after errors.
+func NewSyslogAuditSink(network string, addr string) *SyslogAuditSink {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return &SyslogAuditSink{
+		network:  network,
+		addr:     addr,
+		hostname: hostname,
+	}
+}
+
+func (s *SyslogAuditSink) WriteAuditEvent(event AuditEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		syslogPriority,
+		event.Time.UTC().Format(time.RFC3339Nano),
+		s.hostname,
+		syslogAppName,
+		os.Getpid(),
+		event.Type,
+		body,
+	)
+	if s.network != "udp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.addr, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	if _, err := s.conn.Write([]byte(msg)); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Close closes the connection to the syslog server.
+func (s *SyslogAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
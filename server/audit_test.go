@@ -0,0 +1,122 @@
+package server_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type auditSinkFunc func(event server.AuditEvent) error
+
+func (f auditSinkFunc) WriteAuditEvent(event server.AuditEvent) error {
+	return f(event)
+}
+
+func TestAuditLog_e2ee(t *testing.T) {
+	events := make(chan server.AuditEvent, 10)
+	audit := server.NewAuditLog(loggerFactory, auditSinkFunc(func(event server.AuditEvent) error {
+		events <- event
+		return nil
+	}))
+
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{
+		E2EERooms: []string{"secret"},
+	})
+	tracks.SetAuditLog(audit)
+
+	a := addTestPeer(t, tracks, "secret", "a")
+	addTestPeer(t, tracks, "secret", "b").Close()
+	addTestPeer(t, tracks, "plain", "c").Close()
+	require.NoError(t, a.Close())
+	time.Sleep(50 * time.Millisecond)
+	// room is observed again after all peers left
+	addTestPeer(t, tracks, "secret", "d").Close()
+	audit.Close()
+	close(events)
+
+	var received []server.AuditEvent
+	for event := range events {
+		received = append(received, event)
+	}
+	require.Len(t, received, 2)
+	for i, clientID := range []string{"a", "d"} {
+		assert.Equal(t, server.AuditEventE2EEEnabled, received[i].Type)
+		assert.Equal(t, "secret", received[i].Room)
+		assert.Equal(t, clientID, received[i].ClientID)
+		assert.False(t, received[i].Time.IsZero())
+	}
+}
+
+func TestHTTPAuditSink(t *testing.T) {
+	events := make(chan server.AuditEvent, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer audit-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var event server.AuditEvent
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events <- event
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer s.Close()
+
+	sink := server.NewHTTPAuditSink(s.URL, "audit-token", http.DefaultClient)
+	err := sink.WriteAuditEvent(server.AuditEvent{
+		Type:    server.AuditEventTURNRelay,
+		Room:    "room",
+		Details: map[string]string{"local": "relay 10.0.0.1:3478"},
+	})
+	require.NoError(t, err)
+	event := <-events
+	assert.Equal(t, server.AuditEventTURNRelay, event.Type)
+	assert.Equal(t, "relay 10.0.0.1:3478", event.Details["local"])
+
+	err = server.NewHTTPAuditSink(s.URL, "invalid", http.DefaultClient).WriteAuditEvent(event)
+	assert.Error(t, err)
+}
+
+func TestSyslogAuditSink_tcp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		length, _ := reader.ReadString(' ')
+		n, _ := strconv.Atoi(strings.TrimSpace(length))
+		msg := make([]byte, n)
+		reader.Read(msg)
+		messages <- string(msg)
+	}()
+
+	sink := server.NewSyslogAuditSink("tcp", listener.Addr().String())
+	defer sink.Close()
+	err = sink.WriteAuditEvent(server.AuditEvent{
+		Time:     time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC),
+		Type:     server.AuditEventSRTPProfile,
+		Room:     "room",
+		ClientID: "a",
+	})
+	require.NoError(t, err)
+
+	msg := <-messages
+	assert.Regexp(t, `^<85>1 2020-05-01T12:00:00Z \S+ peer-calls \d+ srtp_profile - \{`, msg)
+	assert.Contains(t, msg, `"clientId":"a"`)
+}
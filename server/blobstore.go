@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidBlobStore = errors.New("invalid blob store")
+	ErrInvalidBlobKey   = errors.New("invalid blob key")
+)
+
+// BlobStore stores files under slash separated keys.
+type BlobStore interface {
+	// Put stores the contents of body under key, replacing any previous
+	// contents. body may be read more than once.
+	Put(ctx context.Context, key string, body io.ReadSeeker, contentType string) error
+}
+
+// NewBlobStore creates the BlobStore of config. Returns nil when no type is
+// configured.
+func NewBlobStore(config StorageConfig, client *http.Client) (BlobStore, error) {
+	switch config.Type {
+	case "":
+		return nil, nil
+	case BlobStoreTypeLocal:
+		if config.Dir == "" {
+			return nil, fmt.Errorf("%w: dir is required", ErrInvalidBlobStore)
+		}
+		return NewLocalBlobStore(config.Dir), nil
+	case BlobStoreTypeS3:
+		if config.S3.Endpoint == "" || config.S3.Bucket == "" {
+			return nil, fmt.Errorf("%w: s3 endpoint and bucket are required", ErrInvalidBlobStore)
+		}
+		return NewS3BlobStore(config.S3, client), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown type: %s", ErrInvalidBlobStore, config.Type)
+	}
+}
+
+// cleanBlobKey returns key without leading slashes. Keys with . or ..
+// elements are rejected so that they cannot escape the directory of a
+// LocalBlobStore.
+func cleanBlobKey(key string) (string, error) {
+	key = strings.TrimLeft(key, "/")
+	if key == "" || path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidBlobKey, key)
+	}
+	return key, nil
+}
+
+// LocalBlobStore stores files in a directory, for example a mounted network
+// file system.
+type LocalBlobStore struct {
+	dir string
+}
+
+var _ BlobStore = &LocalBlobStore{}
+
+func NewLocalBlobStore(dir string) *LocalBlobStore {
+	return &LocalBlobStore{dir: dir}
+}
+
+// Put writes body to a temporary file which is renamed once complete, so
+// that partial files are never visible under key.
+func (s *LocalBlobStore) Put(ctx context.Context, key string, body io.ReadSeeker, contentType string) error {
+	key, err := cleanBlobKey(key)
+	if err != nil {
+		return err
+	}
+
+	fileName := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(fileName), "."+filepath.Base(fileName)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fileName)
+}
+
+// S3BlobStore uploads files to a bucket of Amazon S3 or a compatible
+// service, like MinIO or Google Cloud Storage with HMAC keys. Requests are
+// signed with AWS Signature Version 4 and use path style URLs.
+type S3BlobStore struct {
+	config S3Config
+	client *http.Client
+}
+
+var _ BlobStore = &S3BlobStore{}
+
+func NewS3BlobStore(config S3Config, client *http.Client) *S3BlobStore {
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	return &S3BlobStore{
+		config: config,
+		client: client,
+	}
+}
+
+// Put uploads body with a single PUT request.
+func (s *S3BlobStore) Put(ctx context.Context, key string, body io.ReadSeeker, contentType string) error {
+	key, err := cleanBlobKey(key)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(s.config.Endpoint)
+	if err != nil {
+		return fmt.Errorf("%w: endpoint: %s", ErrInvalidBlobStore, err)
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/" + s.config.Bucket + "/" + key
+	u.RawPath = s3URIEscape(u.Path)
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, body)
+	if err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", u.String(), ioutil.NopCloser(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, hex.EncodeToString(hash.Sum(nil)), time.Now().UTC())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("unexpected status code: %d: %s", res.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// sign adds the AWS Signature Version 4 authorization header to req.
+func (s *S3BlobStore) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.config.Region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		headers["content-type"] = contentType
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + s.config.SecretAccessKey)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3URIEscape percent-encodes all bytes of a path except slashes and the
+// unreserved characters, as required by Signature Version 4.
+func s3URIEscape(value string) string {
+	const hexDigits = "0123456789ABCDEF"
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
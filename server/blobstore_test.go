@@ -0,0 +1,97 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBlobStore(t *testing.T) {
+	store, err := server.NewBlobStore(server.StorageConfig{}, http.DefaultClient)
+	assert.NoError(t, err)
+	assert.Nil(t, store)
+
+	_, err = server.NewBlobStore(server.StorageConfig{Type: server.BlobStoreTypeLocal}, http.DefaultClient)
+	assert.True(t, errors.Is(err, server.ErrInvalidBlobStore))
+	_, err = server.NewBlobStore(server.StorageConfig{Type: server.BlobStoreTypeS3}, http.DefaultClient)
+	assert.True(t, errors.Is(err, server.ErrInvalidBlobStore))
+	_, err = server.NewBlobStore(server.StorageConfig{Type: "ftp"}, http.DefaultClient)
+	assert.True(t, errors.Is(err, server.ErrInvalidBlobStore))
+}
+
+func TestLocalBlobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobstore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := server.NewLocalBlobStore(dir)
+	ctx := context.Background()
+
+	require.NoError(t, store.Put(ctx, "recordings/a/manifest.json", strings.NewReader("{}"), "application/json"))
+	require.NoError(t, store.Put(ctx, "/recordings/a/manifest.json", strings.NewReader("[]"), "application/json"))
+	data, err := ioutil.ReadFile(filepath.Join(dir, "recordings", "a", "manifest.json"))
+	require.NoError(t, err)
+	assert.Equal(t, "[]", string(data))
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "recordings", "a"))
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "temporary files are removed")
+
+	for _, key := range []string{"", "../a", "a/../../b", "a//b"} {
+		err := store.Put(ctx, key, strings.NewReader("x"), "")
+		assert.True(t, errors.Is(err, server.ErrInvalidBlobKey), key)
+	}
+}
+
+func TestS3BlobStore(t *testing.T) {
+	var requests []*http.Request
+	var bodies []string
+	s3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, string(body))
+		if strings.HasSuffix(r.URL.Path, "/denied") {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("<Error><Code>AccessDenied</Code></Error>"))
+		}
+	}))
+	defer s3.Close()
+
+	store := server.NewS3BlobStore(server.S3Config{
+		Endpoint:        s3.URL,
+		Region:          "eu-west-1",
+		Bucket:          "peer-calls",
+		AccessKeyID:     "access_key",
+		SecretAccessKey: "secret_key",
+	}, http.DefaultClient)
+	ctx := context.Background()
+
+	require.NoError(t, store.Put(ctx, "chat/my room!.json", strings.NewReader("{}"), "application/json"))
+	require.Len(t, requests, 1)
+	req := requests[0]
+	assert.Equal(t, "PUT", req.Method)
+	assert.Equal(t, "/peer-calls/chat/my%20room%21.json", req.URL.EscapedPath())
+	assert.Equal(t, "{}", bodies[0])
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(t, "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a", req.Header.Get("X-Amz-Content-Sha256"))
+	assert.NotEmpty(t, req.Header.Get("X-Amz-Date"))
+
+	authorization := req.Header.Get("Authorization")
+	assert.True(t, strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=access_key/"), authorization)
+	assert.Contains(t, authorization, "/eu-west-1/s3/aws4_request, SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date, Signature=")
+
+	err := store.Put(ctx, "denied", strings.NewReader("x"), "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "403")
+	assert.Contains(t, err.Error(), "AccessDenied")
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pion/webrtc/v2"
+)
+
+const MessageTypeBreakout = "breakout"
+
+var ErrInvalidBreakoutRoom = errors.New("invalid breakout room name")
+
+// ErrPeerNotInRoom is returned when a client has no peer connection in the
+// room of an operation.
+var ErrPeerNotInRoom = errors.New("peer not found in room")
+
+// BreakoutRoomName returns the name of breakout room name of room.
+// Clients cannot join rooms with a slash in their name, so breakout rooms
+// never collide with other rooms.
+func BreakoutRoomName(room string, name string) string {
+	return room + "/" + name
+}
+
+// mainRoom returns the room a breakout room belongs to, or room when it is
+// not a breakout room.
+func mainRoom(room string) string {
+	return strings.SplitN(room, "/", 2)[0]
+}
+
+// MoveToBreakoutRooms moves peers in room to breakout rooms. Key of
+// clientIDsByName is the name of the breakout room. Moved peers only receive
+// tracks and data channel messages of peers in the same breakout room, while
+// their websockets stay connected to room. Peers reconnecting are added to
+// their breakout room until they are returned to the main room. No peers are
+// moved when any of them is not in room.
+func (t *MemoryTracksManager) MoveToBreakoutRooms(room string, clientIDsByName map[string][]string) error {
+	for name := range clientIDsByName {
+		if name == "" || strings.Contains(name, "/") {
+			return fmt.Errorf("%w: %q", ErrInvalidBreakoutRoom, name)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, clientIDs := range clientIDsByName {
+		for _, clientID := range clientIDs {
+			if p, ok := t.peers[clientID]; !ok || mainRoom(p.room) != room {
+				return fmt.Errorf("[%s] MoveToBreakoutRooms: %w: %s", clientID, ErrPeerNotInRoom, room)
+			}
+		}
+	}
+
+	for name, clientIDs := range clientIDsByName {
+		breakoutRoom := BreakoutRoomName(room, name)
+		for _, clientID := range clientIDs {
+			t.breakoutRooms[clientID] = breakoutRoom
+			t.movePeer(clientID, t.peers[clientID], breakoutRoom)
+		}
+	}
+
+	return nil
+}
+
+// ReturnToMainRoom moves all peers in breakout rooms of room back to room
+// and returns their clientIDs.
+func (t *MemoryTracksManager) ReturnToMainRoom(room string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var clientIDs []string
+	for clientID, breakoutRoom := range t.breakoutRooms {
+		if mainRoom(breakoutRoom) != room {
+			continue
+		}
+		delete(t.breakoutRooms, clientID)
+		clientIDs = append(clientIDs, clientID)
+
+		if p, ok := t.peers[clientID]; ok {
+			t.movePeer(clientID, p, room)
+		}
+	}
+
+	sort.Strings(clientIDs)
+	return clientIDs
+}
+
+// movePeer stops forwarding tracks between p and peers in its current room
+// and starts forwarding tracks between p and peers in room. It must be
+// called with t.mu held.
+func (t *MemoryTracksManager) movePeer(clientID string, p *peer, room string) {
+	previousRoom := p.room
+	if previousRoom == room {
+		return
+	}
+
+	t.log.Printf("[%s] movePeer from room: %s to room: %s", clientID, previousRoom, room)
+
+	t.removePeerTracks(p)
+
+	removed := make([]*webrtc.Track, 0, len(p.forwarded))
+	for track := range p.forwarded {
+		delete(p.forwarded, track)
+		removed = append(removed, track)
+	}
+	t.updateForwardedTracks(p, nil, removed)
+
+	if p.pin != nil {
+		p.pin.timer.Stop()
+		p.pin = nil
+	}
+
+	if peerIDs, ok := t.peerIDsByRoom[previousRoom]; ok {
+		delete(peerIDs, clientID)
+		if len(peerIDs) == 0 {
+			delete(t.peerIDsByRoom, previousRoom)
+		} else {
+			t.reconcile(previousRoom)
+		}
+	}
+
+	p.room = room
+	t.floor.SetRoom(clientID, room)
+	peerIDs, ok := t.peerIDsByRoom[room]
+	if !ok {
+		peerIDs = map[string]struct{}{}
+		t.peerIDsByRoom[room] = peerIDs
+	}
+	peerIDs[clientID] = struct{}{}
+
+	t.reconcile(room)
+}
@@ -0,0 +1,75 @@
+package server
+
+const MessageTypeJoinError = "ws_join_error"
+
+// JoinError is sent to a client in a MessageTypeJoinError message when it
+// cannot join a room, before its connection is closed.
+type JoinError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// RetryAfter is the number of milliseconds the client must wait before
+	// trying again, when set.
+	RetryAfter int64 `json:"retryAfter,omitempty"`
+	// Redirect is the URL the client should connect to instead, when set.
+	Redirect string `json:"redirect,omitempty"`
+}
+
+func (e *JoinError) Error() string {
+	return e.Message
+}
+
+var (
+	ErrRoomFull   = &JoinError{Code: "roomFull", Message: "Room is full"}
+	ErrServerFull = &JoinError{Code: "serverFull", Message: "Server is at capacity"}
+)
+
+func NewMessageJoinError(room string, err *JoinError) Message {
+	return NewMessage(MessageTypeJoinError, room, err)
+}
+
+// SetCapacity sets the maximum number of participants per room and per
+// instance. Changed limits only apply to clients joining afterwards.
+func (wss *WSS) SetCapacity(config CapacityConfig) {
+	wss.connectionsMu.Lock()
+	defer wss.connectionsMu.Unlock()
+	wss.capacity = config
+}
+
+// checkRoomCapacity returns ErrRoomFull when room has reached its maximum
+// number of participants, which is overridden by maxParticipants when set. Clients are counted across all instances sharing
+// the adapter's store, and a client which is already in the room is never
+// rejected. Concurrent joins may exceed the limit slightly.
+func (wss *WSS) checkRoomCapacity(adapter Adapter, room string, clientID string, maxParticipants int) *JoinError {
+	wss.connectionsMu.Lock()
+	max := wss.capacity.RoomLimit(room)
+	wss.connectionsMu.Unlock()
+	if maxParticipants > 0 {
+		max = maxParticipants
+	}
+	if max <= 0 {
+		return nil
+	}
+
+	clients, err := adapter.Clients()
+	if err != nil {
+		wss.log.Printf("Error retrieving clients in room: %s, not enforcing capacity: %s", room, err)
+		return nil
+	}
+
+	if _, ok := clients[clientID]; ok {
+		return nil
+	}
+	if len(clients) >= max {
+		return ErrRoomFull
+	}
+	return nil
+}
+
+func (wss *WSS) rejectJoin(c SignalingConn, client *Client, room string, joinErr *JoinError) {
+	wss.log.Printf("Rejecting clientID: %s in room: %s: %s", client.ID(), room, joinErr.Code)
+
+	if err := client.Write(NewMessageJoinError(room, joinErr)); err != nil {
+		wss.log.Printf("Error sending join error to clientID: %s: %s", client.ID(), err)
+	}
+	c.Close(joinErr)
+}
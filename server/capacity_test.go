@@ -0,0 +1,94 @@
+package server_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func newCapacityServer(rooms server.RoomManager, capacity server.CapacityConfig) *httptest.Server {
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, server.AdminConfig{}, server.InactivityConfig{}, server.ClientConfig{}, rooms, newMockTracksManager())
+	mux.WSS.SetCapacity(capacity)
+	return httptest.NewServer(mux)
+}
+
+// readJoinResult returns the type of the first message and, when the
+// connection is closed afterwards, the close status.
+func readJoinResult(t *testing.T, ctx context.Context, ws *websocket.Conn) (server.Message, websocket.StatusCode) {
+	t.Helper()
+	_, data, err := ws.Read(ctx)
+	require.NoError(t, err)
+	message, err := serializer.Deserialize(data)
+	require.NoError(t, err)
+
+	if message.Type != server.MessageTypeJoinError {
+		return message, -1
+	}
+	_, _, err = ws.Read(ctx)
+	return message, websocket.CloseStatus(err)
+}
+
+func TestCapacity_roomFull(t *testing.T) {
+	// MockAdapter always reports client1 in the room
+	rooms := NewMockRoomManager()
+	s := newCapacityServer(rooms, server.CapacityConfig{
+		MaxRoomParticipants: 10,
+		Rooms:               map[string]int{roomName: 1},
+	})
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + roomName + "/"
+
+	ws := mustDialWS(t, ctx, wsURL+clientID)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	message, status := readJoinResult(t, ctx, ws)
+	assert.Equal(t, server.MessageTypeJoinError, message.Type)
+	assert.Equal(t, map[string]interface{}{
+		"code":    "roomFull",
+		"message": "Room is full",
+	}, message.Payload)
+	assert.Equal(t, websocket.StatusTryAgainLater, status)
+
+	// a client which is already in the room can reconnect
+	ws = mustDialWS(t, ctx, wsURL+"client1")
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	message, _ = readJoinResult(t, ctx, ws)
+	assert.Equal(t, server.MessageTypeJoinAck, message.Type)
+}
+
+func TestCapacity_serverFull(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	s := newCapacityServer(rooms, server.CapacityConfig{MaxParticipants: 1})
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/"
+
+	first := mustDialWS(t, ctx, wsURL+"room-a/a")
+	message, _ := readJoinResult(t, ctx, first)
+	assert.Equal(t, server.MessageTypeJoinAck, message.Type)
+
+	second := mustDialWS(t, ctx, wsURL+"room-b/b")
+	defer second.Close(websocket.StatusNormalClosure, "")
+	message, status := readJoinResult(t, ctx, second)
+	assert.Equal(t, server.MessageTypeJoinError, message.Type)
+	assert.Equal(t, websocket.StatusTryAgainLater, status)
+
+	// reconnecting with the same client ID replaces the connection
+	reconnected := mustDialWS(t, ctx, wsURL+"room-a/a")
+	defer reconnected.Close(websocket.StatusNormalClosure, "")
+	message, _ = readJoinResult(t, ctx, reconnected)
+	assert.Equal(t, server.MessageTypeJoinAck, message.Type)
+	first.Close(websocket.StatusNormalClosure, "")
+}
@@ -0,0 +1,490 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	CaptureFormatPcap    = "pcap"
+	CaptureFormatRTPDump = "rtpdump"
+
+	CaptureDirectionInbound  = "inbound"
+	CaptureDirectionOutbound = "outbound"
+)
+
+const (
+	defaultCaptureDuration    = 10 * time.Second
+	defaultCaptureMaxDuration = time.Minute
+)
+
+var (
+	ErrCaptureDisabled      = errors.New("packet capture is disabled")
+	ErrCaptureInProgress    = errors.New("packet capture of client is already in progress")
+	ErrInvalidCaptureFormat = errors.New("invalid capture format")
+	ErrCaptureNotFound      = errors.New("capture file not found")
+)
+
+// captureFileName matches the names of files written by PacketCaptures.
+var captureFileName = regexp.MustCompile(`^[0-9A-Za-z]+_(inbound|outbound)\.(pcap|rtpdump)$`)
+
+// CaptureRequest is the request body of the capture endpoint.
+type CaptureRequest struct {
+	// Format is either pcap or rtpdump. Defaults to pcap.
+	Format string `json:"format"`
+	// Duration of the capture in seconds. Defaults to 10, and is limited to
+	// the maximum duration of the SFU config.
+	Duration float64 `json:"duration"`
+}
+
+// PacketCapture describes a capture of the RTP packets of a peer.
+type PacketCapture struct {
+	ID       string `json:"id"`
+	Room     string `json:"room"`
+	ClientID string `json:"clientId"`
+	Format   string `json:"format"`
+	// Files are the names of the files packets received from the peer and
+	// sent to it are written to.
+	Files     []string  `json:"files"`
+	StartedAt time.Time `json:"startedAt"`
+	EndsAt    time.Time `json:"endsAt"`
+}
+
+// PacketCaptures writes the RTP packets of peers to files, to diagnose codec
+// and header extension issues without access to the host. Packets received
+// from a peer are captured before any interceptor processes them, and
+// packets sent to it as they are written to its connection.
+type PacketCaptures struct {
+	log    Logger
+	config CaptureConfig
+
+	// active is the number of captures in progress, checked before taking
+	// the lock for every packet
+	active int32
+
+	mu sync.Mutex
+	// key is clientID
+	captures map[string]*packetCapture
+}
+
+type packetCapture struct {
+	PacketCapture
+
+	mu       sync.Mutex
+	inbound  captureWriter
+	outbound captureWriter
+	timer    *time.Timer
+}
+
+// captureWriter writes packets to a capture file.
+type captureWriter interface {
+	WritePacket(ts time.Time, packet []byte) error
+	Close() error
+}
+
+func NewPacketCaptures(loggerFactory LoggerFactory, config CaptureConfig) *PacketCaptures {
+	return &PacketCaptures{
+		log:      loggerFactory.GetLogger("capture"),
+		config:   config,
+		captures: map[string]*packetCapture{},
+	}
+}
+
+// Enabled returns true when a capture directory is configured.
+func (c *PacketCaptures) Enabled() bool {
+	return c.config.Dir != ""
+}
+
+// Start captures the packets of clientID until the duration of req has
+// passed. Only one capture of a client can be in progress.
+func (c *PacketCaptures) Start(room string, clientID string, req CaptureRequest) (PacketCapture, error) {
+	if !c.Enabled() {
+		return PacketCapture{}, ErrCaptureDisabled
+	}
+
+	var newWriter func(name string, inbound bool) (captureWriter, error)
+	switch req.Format {
+	case CaptureFormatPcap, "":
+		req.Format = CaptureFormatPcap
+		newWriter = newPcapWriter
+	case CaptureFormatRTPDump:
+		newWriter = newRTPDumpWriter
+	default:
+		return PacketCapture{}, ErrInvalidCaptureFormat
+	}
+
+	duration := time.Duration(req.Duration * float64(time.Second))
+	if duration <= 0 {
+		duration = defaultCaptureDuration
+	}
+	maxDuration := c.config.MaxDuration
+	if maxDuration <= 0 {
+		maxDuration = defaultCaptureMaxDuration
+	}
+	if duration > maxDuration {
+		duration = maxDuration
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.captures[clientID]; ok {
+		return PacketCapture{}, ErrCaptureInProgress
+	}
+
+	id := NewUUIDBase62()
+	now := time.Now()
+	capture := &packetCapture{
+		PacketCapture: PacketCapture{
+			ID:        id,
+			Room:      room,
+			ClientID:  clientID,
+			Format:    req.Format,
+			StartedAt: now,
+			EndsAt:    now.Add(duration),
+		},
+	}
+
+	for _, direction := range []string{CaptureDirectionInbound, CaptureDirectionOutbound} {
+		name := id + "_" + direction + "." + req.Format
+		w, err := newWriter(filepath.Join(c.config.Dir, name), direction == CaptureDirectionInbound)
+		if err != nil {
+			capture.close()
+			return PacketCapture{}, fmt.Errorf("create capture file: %w", err)
+		}
+		if direction == CaptureDirectionInbound {
+			capture.inbound = w
+		} else {
+			capture.outbound = w
+		}
+		capture.Files = append(capture.Files, name)
+	}
+
+	c.captures[clientID] = capture
+	atomic.AddInt32(&c.active, 1)
+
+	capture.timer = time.AfterFunc(duration, func() {
+		c.Stop(clientID, id)
+	})
+
+	c.log.Printf("[%s] Started %s capture: %s in room: %s for %s", clientID, req.Format, id, room, duration)
+	return capture.PacketCapture, nil
+}
+
+// Stop stops the capture with id of clientID. Returns false when it is not
+// in progress.
+func (c *PacketCaptures) Stop(clientID string, id string) bool {
+	c.mu.Lock()
+	capture, ok := c.captures[clientID]
+	if !ok || capture.ID != id {
+		c.mu.Unlock()
+		return false
+	}
+	delete(c.captures, clientID)
+	atomic.AddInt32(&c.active, -1)
+	c.mu.Unlock()
+
+	capture.timer.Stop()
+	capture.close()
+
+	c.log.Printf("[%s] Stopped capture: %s", clientID, id)
+	return true
+}
+
+// Open opens a capture file by its name.
+func (c *PacketCaptures) Open(name string) (*os.File, error) {
+	if !c.Enabled() {
+		return nil, ErrCaptureDisabled
+	}
+	if !captureFileName.MatchString(name) {
+		return nil, ErrCaptureNotFound
+	}
+
+	f, err := os.Open(filepath.Join(c.config.Dir, name))
+	if os.IsNotExist(err) {
+		return nil, ErrCaptureNotFound
+	}
+	return f, err
+}
+
+func (c *PacketCaptures) capture(clientID string) (*packetCapture, bool) {
+	if atomic.LoadInt32(&c.active) == 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	capture, ok := c.captures[clientID]
+	return capture, ok
+}
+
+// writeOutbound captures a packet sent to clientID.
+func (c *PacketCaptures) writeOutbound(clientID string, header *rtp.Header, payload []byte) {
+	capture, ok := c.capture(clientID)
+	if !ok {
+		return
+	}
+
+	packet := &rtp.Packet{Header: *header, Payload: payload}
+	capture.write(c.log, false, packet)
+}
+
+// StartCapture captures the packets of the peer of clientID, which must be
+// in room.
+func (t *MemoryTracksManager) StartCapture(room string, clientID string, req CaptureRequest) (PacketCapture, error) {
+	t.mu.RLock()
+	p, ok := t.peers[clientID]
+	t.mu.RUnlock()
+
+	if !ok || mainRoom(p.room) != room {
+		return PacketCapture{}, ErrPeerNotInRoom
+	}
+
+	return t.captures.Start(room, clientID, req)
+}
+
+// StopCapture stops the capture with id of clientID before its duration has
+// passed.
+func (t *MemoryTracksManager) StopCapture(clientID string, id string) bool {
+	return t.captures.Stop(clientID, id)
+}
+
+// OpenCapture opens a capture file by its name.
+func (t *MemoryTracksManager) OpenCapture(name string) (*os.File, error) {
+	return t.captures.Open(name)
+}
+
+var _ InterceptorFactory = &PacketCaptures{}
+
+// NewInterceptor captures packets received from the publisher of a track.
+func (c *PacketCaptures) NewInterceptor(params InterceptorParams) (Interceptor, error) {
+	return &captureTap{captures: c, clientID: params.ClientID}, nil
+}
+
+type captureTap struct {
+	NoOpInterceptor
+
+	captures *PacketCaptures
+	clientID string
+}
+
+func (t *captureTap) BindRTP(next RTPWriter) RTPWriter {
+	return RTPWriterFunc(func(packet *rtp.Packet) error {
+		if capture, ok := t.captures.capture(t.clientID); ok {
+			capture.write(t.captures.log, true, packet)
+		}
+		return next.WriteRTP(packet)
+	})
+}
+
+func (c *packetCapture) write(log Logger, inbound bool, packet *rtp.Packet) {
+	data, err := packet.Marshal()
+	if err != nil {
+		log.Debugf("[%s] Error marshaling captured packet: %s", c.ClientID, err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := c.outbound
+	if inbound {
+		w = c.inbound
+	}
+	if w == nil {
+		// closed
+		return
+	}
+
+	if err := w.WritePacket(time.Now(), data); err != nil {
+		log.Debugf("[%s] Error writing captured packet: %s", c.ClientID, err)
+	}
+}
+
+func (c *packetCapture) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, w := range []captureWriter{c.inbound, c.outbound} {
+		if w != nil {
+			_ = w.Close()
+		}
+	}
+	c.inbound = nil
+	c.outbound = nil
+}
+
+// captureFile is a buffered file written by capture writers.
+type captureFile struct {
+	file *os.File
+	*bufio.Writer
+}
+
+func createCaptureFile(name string) (*captureFile, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &captureFile{file: file, Writer: bufio.NewWriter(file)}, nil
+}
+
+func (f *captureFile) Close() error {
+	err := f.Flush()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+const (
+	pcapLinkTypeRaw  = 101
+	pcapSnapLen      = 65535
+	pcapHeaderLength = 20 + 8
+	// capturePort is the UDP port of both ends of captured packets. It is in
+	// the default RTP port range of Wireshark.
+	capturePort = 5004
+)
+
+var (
+	// capturePeerAddr and captureServerAddr are the IPv4 addresses of the
+	// peer and the server in pcap files. Actual addresses are not captured.
+	capturePeerAddr   = [4]byte{10, 0, 0, 1}
+	captureServerAddr = [4]byte{10, 0, 0, 2}
+)
+
+// pcapWriter writes packets in the pcap format, as UDP datagrams in raw IPv4
+// packets.
+type pcapWriter struct {
+	*captureFile
+	src [4]byte
+	dst [4]byte
+}
+
+func newPcapWriter(name string, inbound bool) (captureWriter, error) {
+	f, err := createCaptureFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &pcapWriter{captureFile: f, src: captureServerAddr, dst: capturePeerAddr}
+	if inbound {
+		w.src, w.dst = capturePeerAddr, captureServerAddr
+	}
+
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkTypeRaw)
+	if _, err := w.Write(header); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *pcapWriter) WritePacket(ts time.Time, packet []byte) error {
+	length := pcapHeaderLength + len(packet)
+	record := make([]byte, 16+pcapHeaderLength)
+
+	binary.LittleEndian.PutUint32(record[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(length))
+	binary.LittleEndian.PutUint32(record[12:], uint32(length))
+
+	ip := record[16:36]
+	ip[0] = 0x45 // IPv4, header length of 20 bytes
+	binary.BigEndian.PutUint16(ip[2:], uint16(length))
+	ip[8] = 64 // TTL
+	ip[9] = 17 // UDP
+	copy(ip[12:16], w.src[:])
+	copy(ip[16:20], w.dst[:])
+	binary.BigEndian.PutUint16(ip[10:], ipv4Checksum(ip))
+
+	udp := record[36:44]
+	binary.BigEndian.PutUint16(udp[0:], capturePort)
+	binary.BigEndian.PutUint16(udp[2:], capturePort)
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(packet)))
+	// the UDP checksum is optional in IPv4
+
+	if _, err := w.Write(record); err != nil {
+		return err
+	}
+	_, err := w.Write(packet)
+	return err
+}
+
+func ipv4Checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// rtpDumpWriter writes packets in the rtpdump format of rtptools, which can
+// be replayed with rtpplay.
+type rtpDumpWriter struct {
+	*captureFile
+	start time.Time
+}
+
+func newRTPDumpWriter(name string, inbound bool) (captureWriter, error) {
+	f, err := createCaptureFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	src := captureServerAddr
+	if inbound {
+		src = capturePeerAddr
+	}
+
+	w := &rtpDumpWriter{captureFile: f, start: time.Now()}
+
+	header := make([]byte, 16)
+	binary.BigEndian.PutUint32(header[0:], uint32(w.start.Unix()))
+	binary.BigEndian.PutUint32(header[4:], uint32(w.start.Nanosecond()/1000))
+	copy(header[8:12], src[:])
+	binary.BigEndian.PutUint16(header[12:], capturePort)
+
+	_, err = fmt.Fprintf(w, "#!rtpplay1.0 %d.%d.%d.%d/%d\n", src[0], src[1], src[2], src[3], capturePort)
+	if err == nil {
+		_, err = w.Write(header)
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *rtpDumpWriter) WritePacket(ts time.Time, packet []byte) error {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint16(header[0:], uint16(8+len(packet)))
+	binary.BigEndian.PutUint16(header[2:], uint16(len(packet)))
+	binary.BigEndian.PutUint32(header[4:], uint32(ts.Sub(w.start)/time.Millisecond))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(packet)
+	return err
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server/logger"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPacketCaptures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "captures")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	loggerFactory := logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout)
+	captures := NewPacketCaptures(loggerFactory, CaptureConfig{Dir: dir})
+
+	interceptor, err := captures.NewInterceptor(InterceptorParams{ClientID: "a"})
+	require.NoError(t, err)
+	writer := interceptor.BindRTP(RTPWriterFunc(func(packet *rtp.Packet) error {
+		return nil
+	}))
+
+	packet := &rtp.Packet{
+		Header:  rtp.Header{Version: 2, PayloadType: 111, SequenceNumber: 1, SSRC: 1234},
+		Payload: []byte{1, 2, 3},
+	}
+	data, err := packet.Marshal()
+	require.NoError(t, err)
+
+	// not captured
+	require.NoError(t, writer.WriteRTP(packet))
+
+	_, err = captures.Start("room", "a", CaptureRequest{Format: "wav"})
+	assert.Equal(t, ErrInvalidCaptureFormat, err)
+
+	capture, err := captures.Start("room", "a", CaptureRequest{Duration: 5})
+	require.NoError(t, err)
+	assert.Equal(t, CaptureFormatPcap, capture.Format)
+	assert.Equal(t, 5*time.Second, capture.EndsAt.Sub(capture.StartedAt))
+	require.Len(t, capture.Files, 2)
+
+	_, err = captures.Start("room", "a", CaptureRequest{})
+	assert.Equal(t, ErrCaptureInProgress, err)
+
+	require.NoError(t, writer.WriteRTP(packet))
+	captures.writeOutbound("a", &packet.Header, packet.Payload)
+	captures.writeOutbound("b", &packet.Header, packet.Payload)
+
+	assert.False(t, captures.Stop("a", "missing"))
+	assert.True(t, captures.Stop("a", capture.ID))
+	assert.False(t, captures.Stop("a", capture.ID))
+
+	for _, name := range capture.Files {
+		f, err := captures.Open(name)
+		require.NoError(t, err)
+		pcap, err := ioutil.ReadAll(f)
+		f.Close()
+		require.NoError(t, err)
+
+		require.Len(t, pcap, 24+16+20+8+len(data), "one packet in %s", name)
+		assert.Equal(t, uint32(0xa1b2c3d4), binary.LittleEndian.Uint32(pcap[0:]))
+		assert.Equal(t, uint32(pcapLinkTypeRaw), binary.LittleEndian.Uint32(pcap[20:]))
+
+		record := pcap[24:]
+		assert.Equal(t, uint32(20+8+len(data)), binary.LittleEndian.Uint32(record[8:]))
+		ip := record[16:36]
+		assert.Equal(t, uint16(0), ipv4Checksum(ip), "valid IPv4 checksum")
+		assert.Equal(t, data, record[16+28:])
+	}
+
+	_, err = captures.Open("../" + capture.Files[0])
+	assert.Equal(t, ErrCaptureNotFound, err)
+}
+
+func TestRTPDumpWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "captures")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "test.rtpdump")
+	w, err := newRTPDumpWriter(name, true)
+	require.NoError(t, err)
+
+	packet := []byte{0x80, 111, 0, 1, 0, 0, 0, 0, 0, 0, 0, 1, 5}
+	require.NoError(t, w.WritePacket(time.Now(), packet))
+	require.NoError(t, w.Close())
+
+	data, err := ioutil.ReadFile(name)
+	require.NoError(t, err)
+
+	line := "#!rtpplay1.0 10.0.0.1/5004\n"
+	require.Equal(t, line, string(data[:len(line)]))
+	data = data[len(line):]
+
+	require.Len(t, data, 16+8+len(packet))
+	assert.Equal(t, []byte{10, 0, 0, 1}, data[8:12])
+	assert.Equal(t, uint16(capturePort), binary.BigEndian.Uint16(data[12:]))
+
+	record := data[16:]
+	assert.Equal(t, uint16(8+len(packet)), binary.BigEndian.Uint16(record[0:]))
+	assert.Equal(t, uint16(len(packet)), binary.BigEndian.Uint16(record[2:]))
+	assert.Equal(t, packet, record[8:])
+}
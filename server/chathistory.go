@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// ChatMessage is a text message sent to a room over the data channel.
+type ChatMessage struct {
+	UserID    string    `json:"userId"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ChatStore keeps chat messages of rooms.
+type ChatStore interface {
+	// AddChatMessage appends msg to the messages of room and discards all
+	// but the last limit messages.
+	AddChatMessage(room string, msg ChatMessage, limit int) error
+	// ChatMessages returns the messages of room, oldest first.
+	ChatMessages(room string) ([]ChatMessage, error)
+	// DeleteChatMessages removes all messages of room.
+	DeleteChatMessages(room string) error
+}
+
+type MemoryChatStore struct {
+	mu sync.Mutex
+	// key is room
+	messages map[string][]ChatMessage
+}
+
+var _ ChatStore = &MemoryChatStore{}
+
+func NewMemoryChatStore() *MemoryChatStore {
+	return &MemoryChatStore{
+		messages: map[string][]ChatMessage{},
+	}
+}
+
+func (s *MemoryChatStore) AddChatMessage(room string, msg ChatMessage, limit int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := append(s.messages[room], msg)
+	if len(messages) > limit {
+		// copy so that the discarded messages can be garbage collected
+		messages = append([]ChatMessage(nil), messages[len(messages)-limit:]...)
+	}
+	s.messages[room] = messages
+	return nil
+}
+
+func (s *MemoryChatStore) ChatMessages(room string) ([]ChatMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]ChatMessage(nil), s.messages[room]...), nil
+}
+
+func (s *MemoryChatStore) DeleteChatMessages(room string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.messages, room)
+	return nil
+}
+
+// RedisChatStore keeps chat messages in a redis list per room, so that
+// clients joining a room via another instance receive the same history.
+type RedisChatStore struct {
+	client *redis.Client
+	prefix string
+}
+
+var _ ChatStore = &RedisChatStore{}
+
+func NewRedisChatStore(client *redis.Client, prefix string) *RedisChatStore {
+	return &RedisChatStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func getRoomChatName(prefix string, room string) string {
+	return prefix + ":room:" + room + ":chat"
+}
+
+func (s *RedisChatStore) AddChatMessage(room string, msg ChatMessage, limit int) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	key := getRoomChatName(s.prefix, room)
+	_, err = s.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.RPush(key, data)
+		pipe.LTrim(key, int64(-limit), -1)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisChatStore) ChatMessages(room string) ([]ChatMessage, error) {
+	values, err := s.client.LRange(getRoomChatName(s.prefix, room), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]ChatMessage, 0, len(values))
+	for _, value := range values {
+		var msg ChatMessage
+		if err := json.Unmarshal([]byte(value), &msg); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func (s *RedisChatStore) DeleteChatMessages(room string) error {
+	return s.client.Del(getRoomChatName(s.prefix, room)).Err()
+}
+
+// ChatHistory records the last messages of each room so they can be
+// replayed to clients joining later. Files are not recorded.
+type ChatHistory struct {
+	log   Logger
+	store ChatStore
+	limit int
+}
+
+// NewChatHistory creates a ChatHistory which keeps the last limit messages
+// of each room in store.
+func NewChatHistory(loggerFactory LoggerFactory, store ChatStore, limit int) *ChatHistory {
+	return &ChatHistory{
+		log:   loggerFactory.GetLogger("chathistory"),
+		store: store,
+		limit: limit,
+	}
+}
+
+// add records a message sent over the data channel. Only text messages are
+// recorded.
+func (h *ChatHistory) add(room string, clientID string, data []byte) {
+	if h == nil {
+		return
+	}
+
+	var msg struct {
+		Type    string `json:"type"`
+		Payload string `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Type != "text" {
+		return
+	}
+
+	err := h.store.AddChatMessage(room, ChatMessage{
+		UserID:    clientID,
+		Message:   msg.Payload,
+		Timestamp: time.Now(),
+	}, h.limit)
+	if err != nil {
+		h.log.Printf("Error adding chat message to room: %s: %s", room, err)
+	}
+}
+
+// Messages returns the recorded messages of room, oldest first.
+func (h *ChatHistory) Messages(room string) []ChatMessage {
+	if h == nil {
+		return nil
+	}
+
+	messages, err := h.store.ChatMessages(room)
+	if err != nil {
+		h.log.Printf("Error reading chat messages of room: %s: %s", room, err)
+	}
+	return messages
+}
+
+// Delete removes the recorded messages of room.
+func (h *ChatHistory) Delete(room string) {
+	if h == nil {
+		return
+	}
+
+	if err := h.store.DeleteChatMessages(room); err != nil {
+		h.log.Printf("Error deleting chat messages of room: %s: %s", room, err)
+	}
+}
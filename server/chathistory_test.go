@@ -0,0 +1,58 @@
+package server_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testChatStore(t *testing.T, store server.ChatStore) {
+	t.Helper()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, message := range []string{"one", "two", "three"} {
+		err := store.AddChatMessage(room, server.ChatMessage{
+			UserID:    "a",
+			Message:   message,
+			Timestamp: now.Add(time.Duration(i) * time.Second),
+		}, 2)
+		require.NoError(t, err)
+	}
+
+	messages, err := store.ChatMessages(room)
+	require.NoError(t, err)
+	assert.Equal(t, []server.ChatMessage{
+		{UserID: "a", Message: "two", Timestamp: now.Add(time.Second)},
+		{UserID: "a", Message: "three", Timestamp: now.Add(2 * time.Second)},
+	}, messages)
+
+	messages, err = store.ChatMessages("other-room")
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+
+	require.NoError(t, store.DeleteChatMessages(room))
+	messages, err = store.ChatMessages(room)
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestMemoryChatStore(t *testing.T) {
+	testChatStore(t, server.NewMemoryChatStore())
+}
+
+func TestRedisChatStore(t *testing.T) {
+	pub, _, stop := configureRedis(t)
+	defer stop()
+	store := server.NewRedisChatStore(pub, "peercalls-test")
+	defer store.DeleteChatMessages(room)
+	testChatStore(t, store)
+}
+
+func TestChatHistory_nil(t *testing.T) {
+	var history *server.ChatHistory
+	assert.Nil(t, history.Messages(room))
+	history.Delete(room)
+}
@@ -0,0 +1,57 @@
+package server
+
+// ClientConfigDocument is the configuration delivered to clients when they
+// join a room, and served at /api/config.
+type ClientConfigDocument struct {
+	Features   map[string]bool   `json:"features"`
+	Branding   map[string]string `json:"branding"`
+	Limits     map[string]int    `json:"limits"`
+	ICEServers []ICEAuthServer   `json:"iceServers"`
+	Network    NetworkType       `json:"network"`
+}
+
+// NewClientConfigDocument creates a client configuration document. ICE
+// server credentials are generated with every call, so documents should not
+// be cached.
+func NewClientConfigDocument(c ClientConfig, network NetworkType, iceServers []ICEServer) ClientConfigDocument {
+	doc := ClientConfigDocument{
+		Features:   c.Features,
+		Branding:   c.Branding,
+		Limits:     c.Limits,
+		ICEServers: GetICEAuthServers(iceServers),
+		Network:    network,
+	}
+
+	// Clients should not have to check for nulls
+	if doc.Features == nil {
+		doc.Features = map[string]bool{}
+	}
+	if doc.Branding == nil {
+		doc.Branding = map[string]string{}
+	}
+	if doc.Limits == nil {
+		doc.Limits = map[string]int{}
+	}
+	if doc.ICEServers == nil {
+		doc.ICEServers = []ICEAuthServer{}
+	}
+
+	return doc
+}
+
+// SetClientConfig enables sending of a join acknowledgement containing the
+// document returned by newDocument for the requested host to every client
+// that connects. It must be called before any connections are handled.
+func (wss *WSS) SetClientConfig(newDocument func(host string) ClientConfigDocument) {
+	wss.clientConfig = newDocument
+}
+
+// NewMessageJoinAck creates the join acknowledgement. Clients should discard
+// state like chat messages kept from a meeting with a different meetingID.
+func NewMessageJoinAck(room string, clientID string, meetingID string, config ClientConfigDocument) Message {
+	return NewMessage(MessageTypeJoinAck, room, map[string]interface{}{
+		"clientID":  clientID,
+		"meetingID": meetingID,
+		"config":    config,
+	})
+}
@@ -0,0 +1,501 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+const (
+	defaultClusterHeartbeatInterval = 5 * time.Second
+	// clusterAssignAttempts is the number of times assigning a room is
+	// attempted when other nodes assign it concurrently.
+	clusterAssignAttempts = 3
+)
+
+var ErrNoNodeAvailable = errors.New("no node available")
+
+// Node is an instance of a cluster.
+type Node struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Clients is the number of clients connected to the node
+	Clients int `json:"clients"`
+	// MaxClients is the maximum number of clients of the node, zero means
+	// unlimited.
+	MaxClients int `json:"maxClients"`
+	// Load is the media load of the node, scored against its node capacity
+	Load        NodeLoad  `json:"load"`
+	HeartbeatAt time.Time `json:"heartbeatAt"`
+}
+
+func (n Node) full() bool {
+	return (n.MaxClients > 0 && n.Clients >= n.MaxClients) || n.Load.Score >= 1
+}
+
+// ClusterStore keeps the nodes of a cluster and the rooms assigned to them.
+type ClusterStore interface {
+	SaveNode(node Node) error
+	DeleteNode(id string) error
+	Nodes() ([]Node, error)
+	// RoomNode returns false when room is not assigned.
+	RoomNode(room string) (nodeID string, ok bool, err error)
+	// AssignRoom assigns room to nodeID only if it is currently assigned to
+	// previousNodeID, or not assigned when previousNodeID is empty. Returns
+	// the node room is assigned to afterwards.
+	AssignRoom(room string, nodeID string, previousNodeID string) (string, error)
+	// UnassignRoom removes the assignment of room if it is assigned to
+	// nodeID.
+	UnassignRoom(room string, nodeID string) error
+}
+
+type MemoryClusterStore struct {
+	mu sync.Mutex
+	// key is node ID
+	nodes map[string]Node
+	// key is room, value is node ID
+	rooms map[string]string
+}
+
+var _ ClusterStore = &MemoryClusterStore{}
+
+func NewMemoryClusterStore() *MemoryClusterStore {
+	return &MemoryClusterStore{
+		nodes: map[string]Node{},
+		rooms: map[string]string{},
+	}
+}
+
+func (s *MemoryClusterStore) SaveNode(node Node) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes[node.ID] = node
+	return nil
+}
+
+func (s *MemoryClusterStore) DeleteNode(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.nodes, id)
+	return nil
+}
+
+func (s *MemoryClusterStore) Nodes() ([]Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nodes := make([]Node, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (s *MemoryClusterStore) RoomNode(room string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nodeID, ok := s.rooms[room]
+	return nodeID, ok, nil
+}
+
+func (s *MemoryClusterStore) AssignRoom(room string, nodeID string, previousNodeID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current := s.rooms[room]; current != previousNodeID {
+		return current, nil
+	}
+	s.rooms[room] = nodeID
+	return nodeID, nil
+}
+
+func (s *MemoryClusterStore) UnassignRoom(room string, nodeID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.rooms[room] == nodeID {
+		delete(s.rooms, room)
+	}
+	return nil
+}
+
+// RedisClusterStore keeps nodes and room assignments in redis hashes, so
+// that all nodes sharing the store see the same cluster.
+type RedisClusterStore struct {
+	client   *redis.Client
+	nodesKey string
+	roomsKey string
+}
+
+var _ ClusterStore = &RedisClusterStore{}
+
+func NewRedisClusterStore(client *redis.Client, prefix string) *RedisClusterStore {
+	return &RedisClusterStore{
+		client:   client,
+		nodesKey: prefix + ":cluster:nodes",
+		roomsKey: prefix + ":cluster:rooms",
+	}
+}
+
+// assignRoomScript sets field ARGV[1] of hash KEYS[1] to ARGV[2] when its
+// current value is ARGV[3], or when it does not exist and ARGV[3] is empty.
+var assignRoomScript = redis.NewScript(`
+local current = redis.call("HGET", KEYS[1], ARGV[1]) or ""
+if current == ARGV[3] then
+	redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+	return ARGV[2]
+end
+return current
+`)
+
+// unassignRoomScript deletes field ARGV[1] of hash KEYS[1] when its value is
+// ARGV[2].
+var unassignRoomScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], ARGV[1]) == ARGV[2] then
+	redis.call("HDEL", KEYS[1], ARGV[1])
+end
+return 0
+`)
+
+func (s *RedisClusterStore) SaveNode(node Node) error {
+	data, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(s.nodesKey, node.ID, data).Err()
+}
+
+func (s *RedisClusterStore) DeleteNode(id string) error {
+	return s.client.HDel(s.nodesKey, id).Err()
+}
+
+func (s *RedisClusterStore) Nodes() ([]Node, error) {
+	values, err := s.client.HGetAll(s.nodesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]Node, 0, len(values))
+	for _, value := range values {
+		var node Node
+		if err := json.Unmarshal([]byte(value), &node); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (s *RedisClusterStore) RoomNode(room string) (string, bool, error) {
+	nodeID, err := s.client.HGet(s.roomsKey, room).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	return nodeID, err == nil, err
+}
+
+func (s *RedisClusterStore) AssignRoom(room string, nodeID string, previousNodeID string) (string, error) {
+	return assignRoomScript.Run(s.client, []string{s.roomsKey}, room, nodeID, previousNodeID).Text()
+}
+
+func (s *RedisClusterStore) UnassignRoom(room string, nodeID string) error {
+	return unassignRoomScript.Run(s.client, []string{s.roomsKey}, room, nodeID).Err()
+}
+
+// Cluster assigns rooms to nodes sharing a ClusterStore, so that a proxy in
+// front of the nodes can route the signaling of each room to the node
+// hosting it. A room is assigned to the least loaded node when it is first
+// looked up, and nodes claim the rooms their clients have joined directly.
+// Breakout rooms are hosted by the node of their main room.
+//
+// Each node publishes its load with a heartbeat. A node which has missed its
+// heartbeats for longer than the node timeout is considered failed, and its
+// rooms are reassigned when they are next looked up or claimed by another
+// node. A nil Cluster has no nodes.
+type Cluster struct {
+	log    Logger
+	store  ClusterStore
+	config ClusterConfig
+	wss    *WSS
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewCluster creates a Cluster in which this instance is the node
+// config.NodeURL. The load of the node is read from wss.
+func NewCluster(loggerFactory LoggerFactory, store ClusterStore, config ClusterConfig, wss *WSS) *Cluster {
+	if config.NodeID == "" {
+		config.NodeID, _ = os.Hostname()
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = defaultClusterHeartbeatInterval
+	}
+	if config.NodeTimeout <= 0 {
+		config.NodeTimeout = 3 * config.HeartbeatInterval
+	}
+
+	return &Cluster{
+		log:    loggerFactory.GetLogger("cluster"),
+		store:  store,
+		config: config,
+		wss:    wss,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// NodeID returns the ID of this node.
+func (c *Cluster) NodeID() string {
+	return c.config.NodeID
+}
+
+// Start publishes a heartbeat right away and then at every heartbeat
+// interval until Stop is called.
+func (c *Cluster) Start() {
+	c.Heartbeat()
+
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(c.config.HeartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				c.Heartbeat()
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the heartbeats and removes this node from the cluster, so that
+// its rooms are reassigned right away. It must only be called after Start.
+func (c *Cluster) Stop() {
+	if c == nil {
+		return
+	}
+
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		<-c.done
+		if err := c.store.DeleteNode(c.config.NodeID); err != nil {
+			c.log.Printf("Error removing node: %s: %s", c.config.NodeID, err)
+		}
+	})
+}
+
+// Heartbeat publishes the load of this node and claims the rooms its
+// clients are in which are not assigned to an available node.
+func (c *Cluster) Heartbeat() {
+	if c == nil {
+		return
+	}
+
+	node := c.node()
+	if err := c.store.SaveNode(node); err != nil {
+		c.log.Printf("Error saving node: %s: %s", node.ID, err)
+		return
+	}
+
+	nodes, err := c.nodes()
+	if err != nil {
+		c.log.Printf("Error reading nodes: %s", err)
+		return
+	}
+
+	claimed := map[string]struct{}{}
+	for _, room := range c.wss.LocalRooms() {
+		room = mainRoom(room)
+		if _, ok := claimed[room]; ok {
+			continue
+		}
+		claimed[room] = struct{}{}
+
+		if _, err := c.assign(room, nodes, node); err != nil {
+			c.log.Printf("Error claiming room: %s: %s", room, err)
+		}
+	}
+}
+
+func (c *Cluster) node() Node {
+	c.wss.connectionsMu.Lock()
+	clients := c.wss.connectionCount
+	maxClients := c.wss.capacity.MaxParticipants
+	c.wss.connectionsMu.Unlock()
+
+	return Node{
+		ID:          c.config.NodeID,
+		URL:         c.config.NodeURL,
+		Clients:     clients,
+		MaxClients:  maxClients,
+		Load:        c.wss.NodeLoad(),
+		HeartbeatAt: time.Now(),
+	}
+}
+
+// nodes returns the available nodes keyed by ID.
+func (c *Cluster) nodes() (map[string]Node, error) {
+	nodes, err := c.store.Nodes()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	available := make(map[string]Node, len(nodes))
+	for _, node := range nodes {
+		if now.Sub(node.HeartbeatAt) < c.config.NodeTimeout {
+			available[node.ID] = node
+		}
+	}
+	return available, nil
+}
+
+// Nodes returns the available nodes sorted by ID.
+func (c *Cluster) Nodes() ([]Node, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	available, err := c.nodes()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]Node, 0, len(available))
+	for _, node := range available {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+	return nodes, nil
+}
+
+// Lookup returns the node hosting room, without assigning it. Returns false
+// when room is not assigned to an available node.
+func (c *Cluster) Lookup(room string) (Node, bool, error) {
+	if c == nil {
+		return Node{}, false, nil
+	}
+
+	nodeID, ok, err := c.store.RoomNode(mainRoom(room))
+	if err != nil || !ok {
+		return Node{}, false, err
+	}
+
+	nodes, err := c.nodes()
+	if err != nil {
+		return Node{}, false, err
+	}
+	node, ok := nodes[nodeID]
+	return node, ok, nil
+}
+
+// Assign returns the node hosting room. Rooms which are not assigned, or
+// whose node has failed, are assigned to the available node with the fewest
+// clients which is not full. Nodes are full when they have reached their
+// maximum number of clients or one of their node capacity thresholds. Loads
+// are those of the last heartbeats, so rooms assigned in quick succession may
+// go to the same node. Returns ErrNoNodeAvailable when all nodes are full.
+func (c *Cluster) Assign(room string) (Node, error) {
+	if c == nil {
+		return Node{}, ErrNoNodeAvailable
+	}
+
+	nodes, err := c.nodes()
+	if err != nil {
+		return Node{}, err
+	}
+
+	candidate, ok := leastLoadedNode(nodes)
+	if !ok {
+		return Node{}, ErrNoNodeAvailable
+	}
+	return c.assign(mainRoom(room), nodes, candidate)
+}
+
+// assign assigns room to candidate unless it is assigned to one of nodes
+// already.
+func (c *Cluster) assign(room string, nodes map[string]Node, candidate Node) (Node, error) {
+	for attempt := 0; attempt < clusterAssignAttempts; attempt++ {
+		current, _, err := c.store.RoomNode(room)
+		if err != nil {
+			return Node{}, err
+		}
+		if node, ok := nodes[current]; ok {
+			return node, nil
+		}
+		if current != "" {
+			c.log.Printf("Reassigning room: %s of failed node: %s to node: %s", room, current, candidate.ID)
+		}
+
+		assigned, err := c.store.AssignRoom(room, candidate.ID, current)
+		if err != nil {
+			return Node{}, err
+		}
+		if assigned == candidate.ID {
+			return candidate, nil
+		}
+		if node, ok := nodes[assigned]; ok {
+			return node, nil
+		}
+		// another node reassigned the room to a node it has just seen
+		// starting, retry with a fresh view of the cluster
+		if nodes, err = c.nodes(); err != nil {
+			return Node{}, err
+		}
+	}
+	return Node{}, ErrNoNodeAvailable
+}
+
+// Release removes the assignment of room when it is hosted by this node, so
+// that the next meeting in the room can be assigned to another node.
+func (c *Cluster) Release(room string) {
+	if c == nil {
+		return
+	}
+
+	if room != mainRoom(room) {
+		return
+	}
+	if err := c.store.UnassignRoom(room, c.config.NodeID); err != nil {
+		c.log.Printf("Error releasing room: %s: %s", room, err)
+	}
+}
+
+func leastLoadedNode(nodes map[string]Node) (Node, bool) {
+	var result Node
+	found := false
+	for _, node := range nodes {
+		if node.full() {
+			continue
+		}
+		if !found || node.Clients < result.Clients ||
+			(node.Clients == result.Clients && node.ID < result.ID) {
+			result = node
+			found = true
+		}
+	}
+	return result, found
+}
+
+// SetCluster sets the Cluster room assignments are served from by the admin
+// API.
+func (wss *WSS) SetCluster(cluster *Cluster) {
+	wss.cluster = cluster
+}
+
+// Cluster returns the Cluster set with SetCluster.
+func (wss *WSS) Cluster() *Cluster {
+	return wss.cluster
+}
@@ -0,0 +1,195 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func testClusterStore(t *testing.T, store server.ClusterStore) {
+	t.Helper()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	node := server.Node{ID: "a", URL: "https://a.example.com", Clients: 2, HeartbeatAt: now}
+	require.NoError(t, store.SaveNode(node))
+	nodes, err := store.Nodes()
+	require.NoError(t, err)
+	assert.Equal(t, []server.Node{node}, nodes)
+
+	_, ok, err := store.RoomNode("room")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	assigned, err := store.AssignRoom("room", "a", "")
+	require.NoError(t, err)
+	assert.Equal(t, "a", assigned)
+	assigned, err = store.AssignRoom("room", "b", "")
+	require.NoError(t, err)
+	assert.Equal(t, "a", assigned, "already assigned")
+	assigned, err = store.AssignRoom("room", "b", "a")
+	require.NoError(t, err)
+	assert.Equal(t, "b", assigned, "reassigned")
+
+	nodeID, ok, err := store.RoomNode("room")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "b", nodeID)
+
+	require.NoError(t, store.UnassignRoom("room", "a"))
+	_, ok, _ = store.RoomNode("room")
+	assert.True(t, ok, "assigned to another node")
+	require.NoError(t, store.UnassignRoom("room", "b"))
+	_, ok, _ = store.RoomNode("room")
+	assert.False(t, ok)
+
+	require.NoError(t, store.DeleteNode("a"))
+	nodes, err = store.Nodes()
+	require.NoError(t, err)
+	assert.Empty(t, nodes)
+}
+
+func TestMemoryClusterStore(t *testing.T) {
+	testClusterStore(t, server.NewMemoryClusterStore())
+}
+
+func TestRedisClusterStore(t *testing.T) {
+	pub, _, stop := configureRedis(t)
+	defer stop()
+	prefix := "peercalls-test-" + server.NewUUIDBase62()
+	defer func() {
+		keys, _ := pub.Keys(prefix + ":*").Result()
+		if len(keys) > 0 {
+			pub.Del(keys...)
+		}
+	}()
+	testClusterStore(t, server.NewRedisClusterStore(pub, prefix))
+}
+
+func newTestCluster(store server.ClusterStore, id string) *server.Cluster {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	wss := server.NewWSS(loggerFactory, rooms)
+	return server.NewCluster(loggerFactory, store, server.ClusterConfig{
+		NodeID:  id,
+		NodeURL: "https://" + id + ".example.com",
+	}, wss)
+}
+
+func TestCluster(t *testing.T) {
+	store := server.NewMemoryClusterStore()
+	a := newTestCluster(store, "a")
+	b := newTestCluster(store, "b")
+
+	var nilCluster *server.Cluster
+	_, err := nilCluster.Assign("room")
+	assert.Equal(t, server.ErrNoNodeAvailable, err)
+
+	_, err = a.Assign("room")
+	assert.Equal(t, server.ErrNoNodeAvailable, err, "no heartbeats yet")
+
+	require.NoError(t, store.SaveNode(server.Node{ID: "b", Clients: 10, HeartbeatAt: time.Now()}))
+	a.Heartbeat()
+
+	node, err := b.Assign("room")
+	require.NoError(t, err)
+	assert.Equal(t, "a", node.ID, "least loaded")
+	assert.Equal(t, "https://a.example.com", node.URL)
+
+	require.NoError(t, store.SaveNode(server.Node{ID: "c", Load: server.NodeLoad{Score: 1}, HeartbeatAt: time.Now()}))
+	node, err = b.Assign("other")
+	require.NoError(t, err)
+	assert.Equal(t, "a", node.ID, "nodes at their node capacity are full")
+	require.NoError(t, store.DeleteNode("c"))
+
+	node, ok, err := b.Lookup("room/group-1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "a", node.ID, "breakout rooms are hosted by the main room's node")
+
+	// a fails
+	require.NoError(t, store.SaveNode(server.Node{ID: "a", HeartbeatAt: time.Now().Add(-time.Minute)}))
+	_, ok, err = b.Lookup("room")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	b.Heartbeat()
+	node, err = b.Assign("room")
+	require.NoError(t, err)
+	assert.Equal(t, "b", node.ID, "reassigned")
+
+	a.Release("room")
+	_, ok, _ = b.Lookup("room")
+	assert.True(t, ok, "only the hosting node releases a room")
+	b.Release("room")
+	_, ok, _ = b.Lookup("room")
+	assert.False(t, ok)
+
+	nodes, err := b.Nodes()
+	require.NoError(t, err)
+	require.Len(t, nodes, 1)
+	assert.Equal(t, "b", nodes[0].ID)
+
+	b.Start()
+	b.Stop()
+	nodes, err = a.Nodes()
+	require.NoError(t, err)
+	assert.Empty(t, nodes, "stopped node is removed")
+}
+
+func TestCluster_admin(t *testing.T) {
+	s, wsURL := setupAdminServer(t)
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	statusCode, _ := adminRequest(t, "GET", s.URL+"/api/admin/cluster/rooms/"+roomName, adminToken)
+	assert.Equal(t, http.StatusNotFound, statusCode, "disabled")
+
+	store := server.NewMemoryClusterStore()
+	mux := s.Config.Handler.(*server.Mux)
+	cluster := server.NewCluster(loggerFactory, store, server.ClusterConfig{
+		NodeID:  "a",
+		NodeURL: "https://a.example.com",
+	}, mux.WSS)
+	mux.WSS.SetCluster(cluster)
+	require.NoError(t, store.SaveNode(server.Node{ID: "b", URL: "https://b.example.com", HeartbeatAt: time.Now()}))
+
+	// rooms joined directly are claimed by the node
+	ws := mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	message, _ := readJoinResult(t, ctx, ws)
+	require.Equal(t, server.MessageTypeJoinAck, message.Type)
+	cluster.Heartbeat()
+
+	var roomNode server.AdminRoomNode
+	statusCode = adminGetJSON(t, s.URL+"/api/admin/cluster/rooms/"+roomName, &roomNode)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, server.AdminRoomNode{Room: roomName, Node: server.Node{
+		ID:          "a",
+		URL:         "https://a.example.com",
+		Clients:     1,
+		HeartbeatAt: roomNode.Node.HeartbeatAt,
+	}}, roomNode)
+
+	statusCode, _ = adminRequest(t, "GET", s.URL+"/api/admin/cluster/rooms/other", adminToken)
+	assert.Equal(t, http.StatusNotFound, statusCode, "not assigned by reads")
+
+	statusCode = adminPostJSON(t, s.URL+"/api/admin/cluster/rooms/other", &roomNode)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "b", roomNode.Node.ID, "least loaded")
+
+	statusCode = adminGetJSON(t, s.URL+"/api/admin/cluster/rooms/other", &roomNode)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "b", roomNode.Node.ID)
+
+	var nodes []server.Node
+	statusCode = adminGetJSON(t, s.URL+"/api/admin/cluster/nodes", &nodes)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Len(t, nodes, 2)
+}
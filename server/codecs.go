@@ -0,0 +1,390 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v2"
+	"github.com/pion/webrtc/v2"
+)
+
+// Names of video codecs which can be negotiated with peers.
+const (
+	CodecVP8  = "VP8"
+	CodecVP9  = "VP9"
+	CodecH264 = "H264"
+	CodecAV1  = "AV1"
+)
+
+// codecAV1X is the name of AV1 used by older browsers.
+const codecAV1X = "AV1X"
+
+// DefaultPayloadTypeAV1 is the payload type of AV1 in offers sent by the
+// server. pion has no default for it.
+const DefaultPayloadTypeAV1 = 104
+
+// DefaultVideoCodecs are the video codecs offered to peers in order of
+// preference, unless configured otherwise.
+var DefaultVideoCodecs = []string{CodecVP8, CodecVP9, CodecH264, CodecAV1}
+
+// NewRTPAV1Codec creates an AV1 codec. The payloader does not split OBUs, it
+// is only meant for tracks which forward packets of a remote track.
+func NewRTPAV1Codec(payloadType uint8, clockrate uint32) *webrtc.RTPCodec {
+	return webrtc.NewRTPCodec(webrtc.RTPCodecTypeVideo,
+		CodecAV1,
+		clockrate,
+		0,
+		"",
+		payloadType,
+		&av1Payloader{})
+}
+
+// av1Payloader packetizes a payload as a single OBU element, fragmented
+// across packets as described in the AV1 RTP payload format.
+type av1Payloader struct{}
+
+func (p *av1Payloader) Payload(mtu int, payload []byte) [][]byte {
+	const (
+		continuation = 0x80
+		continues    = 0x40
+		oneElement   = 0x10
+		newSequence  = 0x08
+	)
+
+	var packets [][]byte
+	if mtu <= 1 {
+		return packets
+	}
+	for offset := 0; offset < len(payload); offset += mtu - 1 {
+		end := offset + mtu - 1
+		if end > len(payload) {
+			end = len(payload)
+		}
+		header := byte(oneElement)
+		if offset > 0 {
+			header |= continuation
+		} else {
+			header |= newSequence
+		}
+		if end < len(payload) {
+			header |= continues
+		}
+		packets = append(packets, append([]byte{header}, payload[offset:end]...))
+	}
+	return packets
+}
+
+// normalizeVideoCodec returns the canonical name of a video codec, or false
+// when it is not supported.
+func normalizeVideoCodec(name string) (string, bool) {
+	switch strings.ToUpper(name) {
+	case CodecVP8:
+		return CodecVP8, true
+	case CodecVP9:
+		return CodecVP9, true
+	case CodecH264:
+		return CodecH264, true
+	case CodecAV1, codecAV1X:
+		return CodecAV1, true
+	}
+	return "", false
+}
+
+// RegisterCodecs registers the default audio codecs, telephone events and
+// videoCodecs in order of preference. Unsupported video codecs are ignored, and
+// DefaultVideoCodecs are registered when none is supported.
+func RegisterCodecs(mediaEngine *webrtc.MediaEngine, videoCodecs []string) {
+	mediaEngine.RegisterCodec(webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000))
+	mediaEngine.RegisterCodec(webrtc.NewRTPPCMUCodec(webrtc.DefaultPayloadTypePCMU, 8000))
+	mediaEngine.RegisterCodec(webrtc.NewRTPPCMACodec(webrtc.DefaultPayloadTypePCMA, 8000))
+	mediaEngine.RegisterCodec(webrtc.NewRTPG722Codec(webrtc.DefaultPayloadTypeG722, 8000))
+	mediaEngine.RegisterCodec(NewRTPTelephoneEventCodec(DefaultPayloadTypeTelephoneEvent, 48000))
+
+	if registerVideoCodecs(mediaEngine, videoCodecs) == 0 {
+		registerVideoCodecs(mediaEngine, DefaultVideoCodecs)
+	}
+}
+
+func registerVideoCodecs(mediaEngine *webrtc.MediaEngine, videoCodecs []string) (registered int) {
+	seen := map[string]struct{}{}
+	for _, name := range videoCodecs {
+		name, ok := normalizeVideoCodec(name)
+		if !ok {
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		switch name {
+		case CodecVP8:
+			mediaEngine.RegisterCodec(webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000))
+		case CodecVP9:
+			mediaEngine.RegisterCodec(webrtc.NewRTPVP9Codec(webrtc.DefaultPayloadTypeVP9, 90000))
+		case CodecH264:
+			mediaEngine.RegisterCodec(webrtc.NewRTPH264Codec(webrtc.DefaultPayloadTypeH264, 90000))
+		case CodecAV1:
+			mediaEngine.RegisterCodec(NewRTPAV1Codec(DefaultPayloadTypeAV1, 90000))
+		}
+		registered++
+	}
+	return registered
+}
+
+// populateFromSDP registers codecs of sessionDescription in mediaEngine,
+// including AV1 and FEC codecs which pion does not recognize.
+func populateFromSDP(mediaEngine *webrtc.MediaEngine, sessionDescription webrtc.SessionDescription) error {
+	if err := mediaEngine.PopulateFromSDP(sessionDescription); err != nil {
+		return err
+	}
+
+	parsed := sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(sessionDescription.SDP)); err != nil {
+		return err
+	}
+	for _, md := range parsed.MediaDescriptions {
+		if md.MediaName.Media != "video" {
+			continue
+		}
+		for _, format := range md.MediaName.Formats {
+			pt, err := strconv.Atoi(format)
+			if err != nil {
+				continue
+			}
+			codec, err := parsed.GetCodecForPayloadType(uint8(pt))
+			if err != nil {
+				continue
+			}
+			if name, _ := normalizeVideoCodec(codec.Name); name == CodecAV1 {
+				mediaEngine.RegisterCodec(NewRTPAV1Codec(uint8(pt), codec.ClockRate))
+			} else if isFECCodec(codec.Name) {
+				mediaEngine.RegisterCodec(NewRTPFECCodec(codec.Name, uint8(pt), codec.ClockRate))
+			}
+		}
+	}
+	return nil
+}
+
+// FilterSDPVideoCodecs removes video codecs which are not in videoCodecs
+// from sessionDescription, together with their retransmission payload
+// types. Other payload types, like red and ulpfec, are kept. Returns an
+// error when a video section would be left without a codec.
+func FilterSDPVideoCodecs(sessionDescription string, videoCodecs []string) (string, error) {
+	allowed := map[string]struct{}{}
+	for _, name := range videoCodecs {
+		if name, ok := normalizeVideoCodec(name); ok {
+			allowed[name] = struct{}{}
+		}
+	}
+	if len(allowed) == 0 {
+		return sessionDescription, nil
+	}
+
+	parsed := sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(sessionDescription)); err != nil {
+		return "", fmt.Errorf("Error parsing SDP: %w", err)
+	}
+
+	for _, md := range parsed.MediaDescriptions {
+		if md.MediaName.Media != "video" {
+			continue
+		}
+
+		removed := map[string]struct{}{}
+		codecCount := 0
+		// retransmission payload types reference the payload type they
+		// repeat, which may be listed after them
+		for _, format := range md.MediaName.Formats {
+			pt, err := strconv.Atoi(format)
+			if err != nil {
+				continue
+			}
+			codec, err := parsed.GetCodecForPayloadType(uint8(pt))
+			if err != nil {
+				continue
+			}
+			if name, ok := normalizeVideoCodec(codec.Name); ok {
+				if _, ok := allowed[name]; !ok {
+					removed[format] = struct{}{}
+					continue
+				}
+				codecCount++
+			}
+		}
+		for _, format := range md.MediaName.Formats {
+			pt, err := strconv.Atoi(format)
+			if err != nil {
+				continue
+			}
+			codec, err := parsed.GetCodecForPayloadType(uint8(pt))
+			if err != nil || !strings.EqualFold(codec.Name, "rtx") {
+				continue
+			}
+			if _, ok := removed[strings.TrimPrefix(codec.Fmtp, "apt=")]; ok {
+				removed[format] = struct{}{}
+			}
+		}
+
+		if len(removed) == 0 {
+			continue
+		}
+		if codecCount == 0 {
+			return "", fmt.Errorf("No allowed video codec in SDP, allowed: %s", strings.Join(videoCodecs, ", "))
+		}
+
+		formats := md.MediaName.Formats[:0]
+		for _, format := range md.MediaName.Formats {
+			if _, ok := removed[format]; !ok {
+				formats = append(formats, format)
+			}
+		}
+		md.MediaName.Formats = formats
+
+		attributes := md.Attributes[:0]
+		for _, attr := range md.Attributes {
+			switch attr.Key {
+			case "rtpmap", "fmtp", "rtcp-fb":
+				pt := strings.SplitN(attr.Value, " ", 2)[0]
+				if _, ok := removed[pt]; ok {
+					continue
+				}
+			}
+			attributes = append(attributes, attr)
+		}
+		md.Attributes = attributes
+	}
+
+	data, err := parsed.Marshal()
+	if err != nil {
+		return "", fmt.Errorf("Error serializing SDP: %w", err)
+	}
+	return string(data), nil
+}
+
+// SetOpusParameters sets params in the format parameters of all Opus
+// payload types of sessionDescription, keeping other parameters.
+func SetOpusParameters(sessionDescription string, params map[string]string) (string, error) {
+	if len(params) == 0 {
+		return sessionDescription, nil
+	}
+
+	parsed := sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(sessionDescription)); err != nil {
+		return "", fmt.Errorf("Error parsing SDP: %w", err)
+	}
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, md := range parsed.MediaDescriptions {
+		if md.MediaName.Media != "audio" {
+			continue
+		}
+
+		for _, format := range md.MediaName.Formats {
+			pt, err := strconv.Atoi(format)
+			if err != nil {
+				continue
+			}
+			codec, err := parsed.GetCodecForPayloadType(uint8(pt))
+			if err != nil || !strings.EqualFold(codec.Name, webrtc.Opus) {
+				continue
+			}
+
+			fmtp := map[string]string{}
+			var order []string
+			for _, param := range strings.Split(codec.Fmtp, ";") {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if kv[0] == "" {
+					continue
+				}
+				if _, ok := fmtp[kv[0]]; !ok {
+					order = append(order, kv[0])
+				}
+				fmtp[kv[0]] = ""
+				if len(kv) == 2 {
+					fmtp[kv[0]] = kv[1]
+				}
+			}
+			for _, key := range keys {
+				if _, ok := fmtp[key]; !ok {
+					order = append(order, key)
+				}
+				fmtp[key] = params[key]
+			}
+
+			values := make([]string, len(order))
+			for i, key := range order {
+				values[i] = key + "=" + fmtp[key]
+			}
+			value := format + " " + strings.Join(values, ";")
+
+			found := false
+			for i, attr := range md.Attributes {
+				if attr.Key == "fmtp" && strings.SplitN(attr.Value, " ", 2)[0] == format {
+					md.Attributes[i].Value = value
+					found = true
+				}
+			}
+			if !found {
+				md.WithValueAttribute("fmtp", value)
+			}
+		}
+	}
+
+	data, err := parsed.Marshal()
+	if err != nil {
+		return "", fmt.Errorf("Error serializing SDP: %w", err)
+	}
+	return string(data), nil
+}
+
+// SetSDPMaxBitrate sets the b=AS and b=TIAS lines of all video media
+// descriptions of sessionDescription to maxBitrate in bits per second,
+// replacing existing ones. The bitrate of audio is small enough to be left
+// out. sessionDescription is returned unchanged when maxBitrate is zero.
+//
+// The SDP is modified as text, because the SDP parser does not support
+// TIAS. For the same reason, the result must not be set as the local
+// description, it is only meant to be sent to the remote peer.
+func SetSDPMaxBitrate(sessionDescription string, maxBitrate int) string {
+	if maxBitrate <= 0 {
+		return sessionDescription
+	}
+
+	bandwidth := []string{
+		"b=AS:" + strconv.Itoa(maxBitrate/1000),
+		"b=TIAS:" + strconv.Itoa(maxBitrate),
+	}
+
+	lines := strings.Split(strings.ReplaceAll(sessionDescription, "\r\n", "\n"), "\n")
+	result := make([]string, 0, len(lines)+2)
+	// pending is true in a video media description until its b= lines have
+	// been written, which follow the m=, i= and c= lines
+	video, pending := false, false
+	for _, line := range lines {
+		if pending && !strings.HasPrefix(line, "i=") && !strings.HasPrefix(line, "c=") {
+			result = append(result, bandwidth...)
+			pending = false
+		}
+		if strings.HasPrefix(line, "m=") {
+			video = strings.HasPrefix(line, "m=video ")
+			pending = video
+		}
+		if video && (strings.HasPrefix(line, "b=AS:") || strings.HasPrefix(line, "b=TIAS:")) {
+			continue
+		}
+		result = append(result, line)
+	}
+	if pending {
+		result = append(result, bandwidth...)
+	}
+
+	return strings.Join(result, "\r\n")
+}
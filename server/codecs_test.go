@@ -0,0 +1,127 @@
+package server_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const offerSDP = `v=0
+o=- 4215775240449105457 2 IN IP4 127.0.0.1
+s=-
+t=0 0
+a=group:BUNDLE 0 1
+m=audio 9 UDP/TLS/RTP/SAVPF 111
+c=IN IP4 0.0.0.0
+a=mid:0
+a=rtpmap:111 opus/48000/2
+a=fmtp:111 minptime=10;useinbandfec=1
+m=video 9 UDP/TLS/RTP/SAVPF 96 97 102 103 35
+c=IN IP4 0.0.0.0
+a=mid:1
+a=rtpmap:96 VP8/90000
+a=rtcp-fb:96 nack
+a=rtpmap:97 rtx/90000
+a=fmtp:97 apt=96
+a=rtpmap:102 H264/90000
+a=rtcp-fb:102 nack
+a=fmtp:102 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f
+a=rtpmap:103 rtx/90000
+a=fmtp:103 apt=102
+a=rtpmap:35 AV1X/90000
+`
+
+func videoCodecNames(mediaEngine *webrtc.MediaEngine) []string {
+	names := []string{}
+	for _, codec := range mediaEngine.GetCodecsByKind(webrtc.RTPCodecTypeVideo) {
+		names = append(names, codec.Name)
+	}
+	return names
+}
+
+func TestRegisterCodecs(t *testing.T) {
+	mediaEngine := &webrtc.MediaEngine{}
+	server.RegisterCodecs(mediaEngine, []string{"h264", "av1", "theora", "H264"})
+	assert.Equal(t, []string{"H264", "AV1"}, videoCodecNames(mediaEngine))
+	assert.Len(t, mediaEngine.GetCodecsByKind(webrtc.RTPCodecTypeAudio), 5)
+
+	mediaEngine = &webrtc.MediaEngine{}
+	server.RegisterCodecs(mediaEngine, []string{"theora"})
+	assert.Equal(t, server.DefaultVideoCodecs, videoCodecNames(mediaEngine))
+}
+
+func TestNewRTPAV1Codec(t *testing.T) {
+	codec := server.NewRTPAV1Codec(server.DefaultPayloadTypeAV1, 90000)
+	assert.Equal(t, "AV1", codec.Name)
+
+	packets := codec.Payloader.Payload(4, []byte{1, 2, 3, 4, 5, 6, 7})
+	assert.Equal(t, [][]byte{
+		{0x58, 1, 2, 3},
+		{0xd0, 4, 5, 6},
+		{0x90, 7},
+	}, packets)
+}
+
+func TestFilterSDPVideoCodecs(t *testing.T) {
+	sdp, err := server.FilterSDPVideoCodecs(offerSDP, []string{"H264"})
+	require.NoError(t, err)
+
+	assert.Contains(t, sdp, "m=video 9 UDP/TLS/RTP/SAVPF 102 103\r\n")
+	assert.Contains(t, sdp, "a=rtpmap:102 H264/90000")
+	assert.Contains(t, sdp, "a=fmtp:103 apt=102")
+	assert.Contains(t, sdp, "a=rtpmap:111 opus/48000/2")
+	for _, removed := range []string{"VP8", "apt=96", "rtcp-fb:96", "AV1X"} {
+		assert.NotContains(t, sdp, removed)
+	}
+
+	sdp, err = server.FilterSDPVideoCodecs(offerSDP, []string{"av1"})
+	require.NoError(t, err)
+	assert.Contains(t, sdp, "m=video 9 UDP/TLS/RTP/SAVPF 35\r\n")
+
+	sdp, err = server.FilterSDPVideoCodecs(offerSDP, nil)
+	require.NoError(t, err)
+	assert.Equal(t, offerSDP, sdp)
+
+	_, err = server.FilterSDPVideoCodecs(offerSDP, []string{"VP9"})
+	assert.Error(t, err)
+}
+
+func TestFilterSDPVideoCodecs_lineEndings(t *testing.T) {
+	sdp, err := server.FilterSDPVideoCodecs(strings.ReplaceAll(offerSDP, "\n", "\r\n"), []string{"VP8"})
+	require.NoError(t, err)
+	assert.Contains(t, sdp, "m=video 9 UDP/TLS/RTP/SAVPF 96 97\r\n")
+}
+
+func TestSetOpusParameters(t *testing.T) {
+	params := server.OpusConfig{FEC: true, DTX: true}.Parameters()
+	sdp, err := server.SetOpusParameters(offerSDP, params)
+	require.NoError(t, err)
+	assert.Contains(t, sdp, "a=fmtp:111 minptime=10;useinbandfec=1;usedtx=1\r\n")
+	assert.Contains(t, sdp, "a=fmtp:97 apt=96\r\n")
+
+	noFmtp := strings.Replace(offerSDP, "a=fmtp:111 minptime=10;useinbandfec=1\n", "", 1)
+	sdp, err = server.SetOpusParameters(noFmtp, map[string]string{"usedtx": "1"})
+	require.NoError(t, err)
+	assert.Contains(t, sdp, "a=fmtp:111 usedtx=1\r\n")
+
+	sdp, err = server.SetOpusParameters(offerSDP, server.OpusConfig{}.Parameters())
+	require.NoError(t, err)
+	assert.Equal(t, offerSDP, sdp)
+}
+
+func TestSetSDPMaxBitrate(t *testing.T) {
+	sdp := server.SetSDPMaxBitrate(offerSDP, 500000)
+	assert.Contains(t, sdp, "m=video 9 UDP/TLS/RTP/SAVPF 96 97 102 103 35\r\nc=IN IP4 0.0.0.0\r\nb=AS:500\r\nb=TIAS:500000\r\na=mid:1\r\n")
+	assert.Equal(t, 1, strings.Count(sdp, "b=AS:"), "audio is not limited")
+
+	sdp = server.SetSDPMaxBitrate(sdp, 250000)
+	assert.Equal(t, 1, strings.Count(sdp, "b=AS:"))
+	assert.Equal(t, 1, strings.Count(sdp, "b=TIAS:"))
+	assert.Contains(t, sdp, "c=IN IP4 0.0.0.0\r\nb=AS:250\r\nb=TIAS:250000\r\n")
+
+	assert.Equal(t, offerSDP, server.SetSDPMaxBitrate(offerSDP, 0))
+}
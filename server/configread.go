@@ -4,18 +4,31 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
+// ReadConfigFile reads a TOML config when filename has the .toml extension,
+// and a YAML config otherwise.
 func ReadConfigFile(filename string, c *Config) (err error) {
+	isTOML := strings.EqualFold(filepath.Ext(filename), ".toml")
+
 	f, err := os.Open(filename)
 	if err != nil {
+		if isTOML {
+			return fmt.Errorf("Error opening TOML file: %w", err)
+		}
 		return fmt.Errorf("Error opening YAML file: %w", err)
 	}
-	err = ReadConfigYAML(f, c)
+	if isTOML {
+		err = ReadConfigTOML(f, c)
+	} else {
+		err = ReadConfigYAML(f, c)
+	}
 	f.Close()
 	return err
 }
@@ -70,6 +83,188 @@ func ReadConfigFromEnv(prefix string, c *Config) {
 
 	setEnvNetworkType(&c.Network.Type, prefix+"NETWORK_TYPE")
 	setEnvStringArray(&c.Network.SFU.Interfaces, prefix+"NETWORK_SFU_INTERFACES")
+	setEnvStringArray(&c.Network.SFU.ExcludeInterfaces, prefix+"NETWORK_SFU_EXCLUDE_INTERFACES")
+	setEnvStringArray(&c.Network.SFU.NetworkTypes, prefix+"NETWORK_SFU_NETWORK_TYPES")
+	setEnvInt(&c.Network.SFU.UDP.PortMin, prefix+"NETWORK_SFU_UDP_PORT_MIN")
+	setEnvInt(&c.Network.SFU.UDP.PortMax, prefix+"NETWORK_SFU_UDP_PORT_MAX")
+	setEnvString(&c.Network.SFU.Ingest.ListenIP, prefix+"NETWORK_SFU_INGEST_LISTEN_IP")
+	setEnvInt(&c.Network.SFU.Ingest.UDP.PortMin, prefix+"NETWORK_SFU_INGEST_UDP_PORT_MIN")
+	setEnvInt(&c.Network.SFU.Ingest.UDP.PortMax, prefix+"NETWORK_SFU_INGEST_UDP_PORT_MAX")
+	setEnvStringArray(&c.Network.SFU.NAT1To1IPs, prefix+"NETWORK_SFU_NAT_1TO1_IPS")
+	setEnvString(&c.Network.SFU.PeerConnection.BundlePolicy, prefix+"NETWORK_SFU_BUNDLE_POLICY")
+	setEnvBool(&c.Network.SFU.PeerConnection.RTCPMuxRequired, prefix+"NETWORK_SFU_RTCP_MUX_REQUIRED")
+	setEnvString(&c.Network.SFU.PeerConnection.DTLSRole, prefix+"NETWORK_SFU_DTLS_ROLE")
+	setEnvString(&c.Network.SFU.DTLS.CertFile, prefix+"NETWORK_SFU_DTLS_CERT_FILE")
+	setEnvDuration(&c.Network.SFU.DTLS.RotationInterval, prefix+"NETWORK_SFU_DTLS_ROTATION_INTERVAL")
+	setEnvInt(&c.Network.SFU.LastN, prefix+"NETWORK_SFU_LAST_N")
+	setEnvStringArray(&c.Network.SFU.AudioOnlyRooms, prefix+"NETWORK_SFU_AUDIO_ONLY_ROOMS")
+	setEnvStringArray(&c.Network.SFU.E2EERooms, prefix+"NETWORK_SFU_E2EE_ROOMS")
+	setEnvDuration(&c.Network.SFU.Rewind.Duration, prefix+"NETWORK_SFU_REWIND_DURATION")
+	setEnvInt(&c.Network.SFU.Rewind.MaxRoomBytes, prefix+"NETWORK_SFU_REWIND_MAX_ROOM_BYTES")
+	setEnvDuration(&c.Network.SFU.Reorder.Delay, prefix+"NETWORK_SFU_REORDER_DELAY")
+	setEnvStringArray(&c.Network.SFU.PushToTalk.Rooms, prefix+"NETWORK_SFU_PUSH_TO_TALK_ROOMS")
+	setEnvInt(&c.Network.SFU.PushToTalk.Speakers, prefix+"NETWORK_SFU_PUSH_TO_TALK_SPEAKERS")
+	setEnvDuration(&c.Network.SFU.PushToTalk.MaxHoldTime, prefix+"NETWORK_SFU_PUSH_TO_TALK_MAX_HOLD_TIME")
+	setEnvDuration(&c.Network.SFU.NetworkQuality.Interval, prefix+"NETWORK_SFU_NETWORK_QUALITY_INTERVAL")
+	setEnvString(&c.Network.SFU.Capture.Dir, prefix+"NETWORK_SFU_CAPTURE_DIR")
+	setEnvDuration(&c.Network.SFU.Capture.MaxDuration, prefix+"NETWORK_SFU_CAPTURE_MAX_DURATION")
+	setEnvInt(&c.Network.SFU.FanOutWorkers, prefix+"NETWORK_SFU_FAN_OUT_WORKERS")
+	setEnvInt(&c.Network.SFU.ForwardingWorkers, prefix+"NETWORK_SFU_FORWARDING_WORKERS")
+	setEnvInt(&c.Network.SFU.Bandwidth.MaxBitrate, prefix+"NETWORK_SFU_BANDWIDTH_MAX_BITRATE")
+	setEnvDuration(&c.Network.SFU.Bandwidth.GracePeriod, prefix+"NETWORK_SFU_BANDWIDTH_GRACE_PERIOD")
+	setEnvBandwidthAction(&c.Network.SFU.Bandwidth.Action, prefix+"NETWORK_SFU_BANDWIDTH_ACTION")
+	setEnvBool(&c.Network.SFU.Bandwidth.Advertise, prefix+"NETWORK_SFU_BANDWIDTH_ADVERTISE")
+	setEnvBool(&c.Network.SFU.DirectTwoParty, prefix+"NETWORK_SFU_DIRECT_TWO_PARTY")
+	setEnvStringArray(&c.Network.SFU.Codecs.Video, prefix+"NETWORK_SFU_CODECS_VIDEO")
+	setEnvBool(&c.Network.SFU.Opus.FEC, prefix+"NETWORK_SFU_OPUS_FEC")
+	setEnvBool(&c.Network.SFU.Opus.DTX, prefix+"NETWORK_SFU_OPUS_DTX")
+	setEnvBool(&c.Network.SFU.FEC, prefix+"NETWORK_SFU_FEC")
+	setEnvBool(&c.Network.SFU.HeaderExtensions, prefix+"NETWORK_SFU_HEADER_EXTENSIONS")
+	setEnvDuration(&c.Network.SFU.NegotiationDebounce, prefix+"NETWORK_SFU_NEGOTIATION_DEBOUNCE")
+	setEnvBool(&c.Network.SFU.DataChannelSignaling, prefix+"NETWORK_SFU_DATACHANNEL_SIGNALING")
+	setEnvDuration(&c.Network.SFU.DisconnectGracePeriod, prefix+"NETWORK_SFU_DISCONNECT_GRACE_PERIOD")
+
+	setEnvString(&c.Admin.Token, prefix+"ADMIN_TOKEN")
+	setEnvString(&c.Admin.GRPCListenAddr, prefix+"ADMIN_GRPC_LISTEN_ADDR")
+	setEnvString(&c.Admin.GRPCTLS.Cert, prefix+"ADMIN_GRPC_TLS_CERT")
+	setEnvString(&c.Admin.GRPCTLS.Key, prefix+"ADMIN_GRPC_TLS_KEY")
+	setEnvBool(&c.Admin.GRPCInsecure, prefix+"ADMIN_GRPC_INSECURE")
+	setEnvBool(&c.Admin.RequireAPIKeys, prefix+"ADMIN_REQUIRE_API_KEYS")
+	setEnvBool(&c.Admin.Pprof, prefix+"ADMIN_PPROF")
+
+	setEnvDuration(&c.Inactivity.Timeout, prefix+"INACTIVITY_TIMEOUT")
+	setEnvDuration(&c.Inactivity.Warning, prefix+"INACTIVITY_WARNING")
+	setEnvBool(&c.Inactivity.ExemptViewOnly, prefix+"INACTIVITY_EXEMPT_VIEW_ONLY")
+	setEnvDuration(&c.Keepalive.Interval, prefix+"KEEPALIVE_INTERVAL")
+	setEnvDuration(&c.Keepalive.Timeout, prefix+"KEEPALIVE_TIMEOUT")
+
+	setEnvDuration(&c.Lifecycle.IdleTimeout, prefix+"LIFECYCLE_IDLE_TIMEOUT")
+	setEnvDuration(&c.Lifecycle.MaxDuration, prefix+"LIFECYCLE_MAX_DURATION")
+	setEnvDuration(&c.Lifecycle.Warning, prefix+"LIFECYCLE_WARNING")
+	setEnvRoomReusePolicy(&c.Lifecycle.Reuse, prefix+"LIFECYCLE_REUSE")
+
+	setEnvInt(&c.Capacity.MaxParticipants, prefix+"CAPACITY_MAX_PARTICIPANTS")
+	setEnvInt(&c.Capacity.MaxRoomParticipants, prefix+"CAPACITY_MAX_ROOM_PARTICIPANTS")
+	setEnvFloat(&c.Capacity.Node.MaxCPU, prefix+"CAPACITY_NODE_MAX_CPU")
+	setEnvInt(&c.Capacity.Node.MaxBitrate, prefix+"CAPACITY_NODE_MAX_BITRATE")
+	setEnvInt(&c.Capacity.Node.MaxTracks, prefix+"CAPACITY_NODE_MAX_TRACKS")
+	setEnvString(&c.Capacity.Node.RedirectURL, prefix+"CAPACITY_NODE_REDIRECT_URL")
+
+	setEnvString(&c.Cluster.NodeURL, prefix+"CLUSTER_NODE_URL")
+	setEnvString(&c.Cluster.NodeID, prefix+"CLUSTER_NODE_ID")
+	setEnvDuration(&c.Cluster.HeartbeatInterval, prefix+"CLUSTER_HEARTBEAT_INTERVAL")
+	setEnvDuration(&c.Cluster.NodeTimeout, prefix+"CLUSTER_NODE_TIMEOUT")
+	setEnvInt(&c.Runtime.GOMAXPROCS, prefix+"RUNTIME_GOMAXPROCS")
+	setEnvInt(&c.Runtime.SendQueueSize, prefix+"RUNTIME_SEND_QUEUE_SIZE")
+	setEnvInt(&c.Runtime.NACKBufferSize, prefix+"RUNTIME_NACK_BUFFER_SIZE")
+	setEnvDuration(&c.Runtime.PLIInterval, prefix+"RUNTIME_PLI_INTERVAL")
+	setEnvDuration(&c.Runtime.PLIMinInterval, prefix+"RUNTIME_PLI_MIN_INTERVAL")
+	setEnvDuration(&c.Runtime.PLIMinIntervalScreen, prefix+"RUNTIME_PLI_MIN_INTERVAL_SCREEN")
+	setEnvString(&c.Upgrade.SocketPath, prefix+"UPGRADE_SOCKET_PATH")
+	setEnvBool(&c.Upgrade.ReusePort, prefix+"UPGRADE_REUSE_PORT")
+	setEnvDuration(&c.Upgrade.DrainTimeout, prefix+"UPGRADE_DRAIN_TIMEOUT")
+
+	setEnvDuration(&c.Digest.Interval, prefix+"DIGEST_INTERVAL")
+
+	setEnvInt(&c.Chat.History, prefix+"CHAT_HISTORY")
+	setEnvInt(&c.EventLog.MaxRoomEvents, prefix+"EVENT_LOG_MAX_ROOM_EVENTS")
+	setEnvDuration(&c.RateLimit.MessageInterval, prefix+"RATE_LIMIT_MESSAGE_INTERVAL")
+	setEnvInt(&c.RateLimit.MessageBurst, prefix+"RATE_LIMIT_MESSAGE_BURST")
+	setEnvDuration(&c.RateLimit.IPMessageInterval, prefix+"RATE_LIMIT_IP_MESSAGE_INTERVAL")
+	setEnvInt(&c.RateLimit.IPMessageBurst, prefix+"RATE_LIMIT_IP_MESSAGE_BURST")
+	setEnvDuration(&c.RateLimit.JoinInterval, prefix+"RATE_LIMIT_JOIN_INTERVAL")
+	setEnvInt(&c.RateLimit.JoinBurst, prefix+"RATE_LIMIT_JOIN_BURST")
+	setEnvBool(&c.RateLimit.TrustProxy, prefix+"RATE_LIMIT_TRUST_PROXY")
+	setEnvInt(&c.RateLimit.MaxIPConnections, prefix+"RATE_LIMIT_MAX_IP_CONNECTIONS")
+	setEnvInt(&c.RateLimit.MaxIPPeerConnections, prefix+"RATE_LIMIT_MAX_IP_PEER_CONNECTIONS")
+	setEnvDuration(&c.RateLimit.RoomCreationInterval, prefix+"RATE_LIMIT_ROOM_CREATION_INTERVAL")
+	setEnvInt(&c.RateLimit.RoomCreationBurst, prefix+"RATE_LIMIT_ROOM_CREATION_BURST")
+	setEnvDuration(&c.RateLimit.Backoff, prefix+"RATE_LIMIT_BACKOFF")
+	setEnvDuration(&c.RateLimit.MaxBackoff, prefix+"RATE_LIMIT_MAX_BACKOFF")
+	setEnvStringArray(&c.ConnectionPolicy.Allow, prefix+"CONNECTION_POLICY_ALLOW")
+	setEnvStringArray(&c.ConnectionPolicy.Deny, prefix+"CONNECTION_POLICY_DENY")
+	setEnvString(&c.ConnectionPolicy.GeoIPDatabase, prefix+"CONNECTION_POLICY_GEOIP_DATABASE")
+	setEnvStringArray(&c.ConnectionPolicy.AllowCountries, prefix+"CONNECTION_POLICY_ALLOW_COUNTRIES")
+	setEnvStringArray(&c.ConnectionPolicy.DenyCountries, prefix+"CONNECTION_POLICY_DENY_COUNTRIES")
+	setEnvBool(&c.Registry.DisableAdHoc, prefix+"REGISTRY_DISABLE_AD_HOC")
+	setEnvBool(&c.RoomFeatures.DisableChat, prefix+"ROOM_FEATURES_DISABLE_CHAT")
+	setEnvBool(&c.RoomFeatures.DisableRecording, prefix+"ROOM_FEATURES_DISABLE_RECORDING")
+	setEnvBool(&c.RoomFeatures.RequireE2EE, prefix+"ROOM_FEATURES_REQUIRE_E2EE")
+	setEnvInt(&c.RoomFeatures.MaxVideoWidth, prefix+"ROOM_FEATURES_MAX_VIDEO_WIDTH")
+	setEnvInt(&c.RoomFeatures.MaxVideoHeight, prefix+"ROOM_FEATURES_MAX_VIDEO_HEIGHT")
+
+	setEnvDuration(&c.Reactions.Interval, prefix+"REACTIONS_INTERVAL")
+	setEnvInt(&c.Reactions.Burst, prefix+"REACTIONS_BURST")
+	setEnvDuration(&c.Spatial.Interval, prefix+"SPATIAL_INTERVAL")
+	setEnvFloat(&c.Spatial.MaxCoordinate, prefix+"SPATIAL_MAX_COORDINATE")
+
+	setEnvString(&c.Secrets.Vault.Addr, prefix+"SECRETS_VAULT_ADDR")
+	setEnvString(&c.Secrets.Vault.Token, prefix+"SECRETS_VAULT_TOKEN")
+
+	setEnvString(&c.SIP.ListenAddr, prefix+"SIP_LISTEN_ADDR")
+	setEnvString(&c.SIP.PublicIP, prefix+"SIP_PUBLIC_IP")
+	setEnvString(&c.SIP.Username, prefix+"SIP_USERNAME")
+	setEnvString(&c.SIP.Password, prefix+"SIP_PASSWORD")
+	setEnvStringArray(&c.SIP.AllowedNetworks, prefix+"SIP_ALLOWED_NETWORKS")
+	setEnvInt(&c.SIP.MaxCalls, prefix+"SIP_MAX_CALLS")
+
+	setEnvString(&c.Recording.Dir, prefix+"RECORDING_DIR")
+	setEnvSlice(&c.Recording.PostProcess, prefix+"RECORDING_POST_PROCESS")
+	setEnvString(&c.Recording.FFmpeg, prefix+"RECORDING_FFMPEG")
+	setEnvInt(&c.Recording.MaxAttempts, prefix+"RECORDING_MAX_ATTEMPTS")
+	setEnvDuration(&c.Recording.RetryInterval, prefix+"RECORDING_RETRY_INTERVAL")
+
+	setEnvBlobStoreType(&c.Storage.Type, prefix+"STORAGE_TYPE")
+	setEnvString(&c.Storage.Dir, prefix+"STORAGE_DIR")
+	setEnvString(&c.Storage.S3.Endpoint, prefix+"STORAGE_S3_ENDPOINT")
+	setEnvString(&c.Storage.S3.Region, prefix+"STORAGE_S3_REGION")
+	setEnvString(&c.Storage.S3.Bucket, prefix+"STORAGE_S3_BUCKET")
+	setEnvString(&c.Storage.S3.AccessKeyID, prefix+"STORAGE_S3_ACCESS_KEY_ID")
+	setEnvString(&c.Storage.S3.SecretAccessKey, prefix+"STORAGE_S3_SECRET_ACCESS_KEY")
+	setEnvString(&c.Storage.Prefix, prefix+"STORAGE_PREFIX")
+	setEnvInt(&c.Storage.MaxAttempts, prefix+"STORAGE_MAX_ATTEMPTS")
+	setEnvDuration(&c.Storage.RetryInterval, prefix+"STORAGE_RETRY_INTERVAL")
+	setEnvBool(&c.Storage.DeleteAfterUpload, prefix+"STORAGE_DELETE_AFTER_UPLOAD")
+
+	setEnvString(&c.Audit.Syslog.Network, prefix+"AUDIT_SYSLOG_NETWORK")
+	setEnvString(&c.Audit.Syslog.Addr, prefix+"AUDIT_SYSLOG_ADDR")
+	setEnvString(&c.Audit.HTTP.URL, prefix+"AUDIT_HTTP_URL")
+	setEnvString(&c.Audit.HTTP.Token, prefix+"AUDIT_HTTP_TOKEN")
+
+	setEnvString(&c.Webhook.URL, prefix+"WEBHOOK_URL")
+	setEnvString(&c.Webhook.Secret, prefix+"WEBHOOK_SECRET")
+	setEnvString(&c.Webhook.KeyID, prefix+"WEBHOOK_KEY_ID")
+	setEnvInt(&c.Webhook.MaxAttempts, prefix+"WEBHOOK_MAX_ATTEMPTS")
+	setEnvDuration(&c.Webhook.RetryInterval, prefix+"WEBHOOK_RETRY_INTERVAL")
+
+	setEnvDuration(&c.Metering.Interval, prefix+"METERING_INTERVAL")
+	setEnvString(&c.Metering.Webhook.URL, prefix+"METERING_WEBHOOK_URL")
+	setEnvString(&c.Metering.Webhook.Secret, prefix+"METERING_WEBHOOK_SECRET")
+	setEnvString(&c.Metering.Webhook.KeyID, prefix+"METERING_WEBHOOK_KEY_ID")
+
+	setEnvString(&c.Log.Level, prefix+"LOG_LEVEL")
+	setEnvString(&c.Log.Format, prefix+"LOG_FORMAT")
+
+	setEnvString(&c.Tracing.Endpoint, prefix+"TRACING_ENDPOINT")
+	setEnvString(&c.Tracing.ServiceName, prefix+"TRACING_SERVICE_NAME")
+	setEnvString(&c.Egress.Endpoint, prefix+"EGRESS_ENDPOINT")
+	setEnvString(&c.Egress.Secret, prefix+"EGRESS_SECRET")
+	setEnvString(&c.Egress.NodeURL, prefix+"EGRESS_NODE_URL")
+	setEnvString(&c.Egress.CA, prefix+"EGRESS_CA")
+	setEnvBool(&c.Egress.Insecure, prefix+"EGRESS_INSECURE")
+	setEnvString(&c.Transcription.Endpoint, prefix+"TRANSCRIPTION_ENDPOINT")
+	setEnvString(&c.Transcription.Secret, prefix+"TRANSCRIPTION_SECRET")
+	setEnvStringArray(&c.Transcription.Rooms, prefix+"TRANSCRIPTION_ROOMS")
+	setEnvString(&c.Transcription.CA, prefix+"TRANSCRIPTION_CA")
+	setEnvBool(&c.Transcription.Insecure, prefix+"TRANSCRIPTION_INSECURE")
+	setEnvString(&c.Signaling.GRPCListenAddr, prefix+"SIGNALING_GRPC_LISTEN_ADDR")
+	setEnvString(&c.Signaling.GRPCTLS.Cert, prefix+"SIGNALING_GRPC_TLS_CERT")
+	setEnvString(&c.Signaling.GRPCTLS.Key, prefix+"SIGNALING_GRPC_TLS_KEY")
+	setEnvBool(&c.Signaling.GRPCInsecure, prefix+"SIGNALING_GRPC_INSECURE")
+	setEnvString(&c.Signaling.TCPListenAddr, prefix+"SIGNALING_TCP_LISTEN_ADDR")
+	setEnvBool(&c.SocketIO.Enabled, prefix+"SOCKET_IO_ENABLED")
+	setEnvDuration(&c.SocketIO.PingInterval, prefix+"SOCKET_IO_PING_INTERVAL")
+	setEnvDuration(&c.SocketIO.PingTimeout, prefix+"SOCKET_IO_PING_TIMEOUT")
 
 	var ice ICEServer
 	setEnvSlice(&ice.URLs, prefix+"ICE_SERVER_URLS")
@@ -104,6 +299,27 @@ func setEnvInt(dest *int, name string) {
 	}
 }
 
+func setEnvFloat(dest *float64, name string) {
+	value, err := strconv.ParseFloat(os.Getenv(name), 64)
+	if err == nil {
+		*dest = value
+	}
+}
+
+func setEnvDuration(dest *time.Duration, name string) {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err == nil {
+		*dest = value
+	}
+}
+
+func setEnvBool(dest *bool, name string) {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	if err == nil {
+		*dest = value
+	}
+}
+
 func setEnvAuthType(authType *AuthType, name string) {
 	value := os.Getenv(name)
 	switch AuthType(value) {
@@ -124,6 +340,22 @@ func setEnvNetworkType(networkType *NetworkType, name string) {
 	}
 }
 
+func setEnvBandwidthAction(action *BandwidthAction, name string) {
+	value := BandwidthAction(os.Getenv(name))
+	switch value {
+	case BandwidthActionWarn, BandwidthActionThrottle, BandwidthActionDrop, BandwidthActionDisconnect:
+		*action = value
+	}
+}
+
+func setEnvRoomReusePolicy(policy *RoomReusePolicy, name string) {
+	value := RoomReusePolicy(os.Getenv(name))
+	switch value {
+	case RoomReuseResume, RoomReuseFresh:
+		*policy = value
+	}
+}
+
 func setEnvStoreType(storeType *StoreType, name string) {
 	value := os.Getenv(name)
 	switch StoreType(value) {
@@ -134,6 +366,16 @@ func setEnvStoreType(storeType *StoreType, name string) {
 	}
 }
 
+func setEnvBlobStoreType(blobStoreType *BlobStoreType, name string) {
+	value := os.Getenv(name)
+	switch BlobStoreType(value) {
+	case BlobStoreTypeLocal:
+		*blobStoreType = BlobStoreTypeLocal
+	case BlobStoreTypeS3:
+		*blobStoreType = BlobStoreTypeS3
+	}
+}
+
 func setEnvStringArray(interfaces *[]string, name string) {
 	value := os.Getenv(name)
 	if value != "" {
@@ -4,6 +4,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/peer-calls/peer-calls/server"
 	"github.com/stretchr/testify/assert"
@@ -27,6 +28,17 @@ func TestReadConfigFiles(t *testing.T) {
 	assert.Equal(t, "/test", c.BaseURL)
 	assert.Equal(t, "test.pem", c.TLS.Cert)
 	assert.Equal(t, "test.key", c.TLS.Key)
+	require.Len(t, c.Hosts, 1)
+	assert.Equal(t, "meet.acme.com", c.Hosts[0].Name)
+	assert.Equal(t, "acme.pem", c.Hosts[0].TLS.Cert)
+	assert.Equal(t, "acme-{id}", c.Hosts[0].RoomTemplate)
+	assert.Equal(t, "Acme Meet", c.Hosts[0].Client.Branding["name"])
+	assert.Equal(t, []server.TenantConfig{{
+		Name:       "acme",
+		APIKey:     "acme-key",
+		JoinSecret: "acme-secret",
+		MaxRooms:   10,
+	}}, c.Tenants)
 	assert.Equal(t, server.StoreTypeRedis, c.Store.Type)
 	assert.Equal(t, "localhost", c.Store.Redis.Host)
 	assert.Equal(t, 6379, c.Store.Redis.Port)
@@ -40,6 +52,85 @@ func TestReadConfigFiles(t *testing.T) {
 	assert.Equal(t, []string(nil), c.Network.SFU.Interfaces)
 }
 
+func TestReadConfigFiles_TOML(t *testing.T) {
+	var c server.Config
+	err := server.ReadConfigFiles([]string{"config_example.toml"}, &c)
+	require.NoError(t, err)
+	assert.Equal(t, "/test", c.BaseURL)
+	assert.Equal(t, "test.pem", c.TLS.Cert)
+	assert.Equal(t, "test.key", c.TLS.Key)
+	require.Len(t, c.Hosts, 1)
+	assert.Equal(t, "meet.acme.com", c.Hosts[0].Name)
+	assert.Equal(t, "acme.pem", c.Hosts[0].TLS.Cert)
+	assert.Equal(t, "acme-{id}", c.Hosts[0].RoomTemplate)
+	assert.Equal(t, "Acme Meet", c.Hosts[0].Client.Branding["name"])
+	assert.Equal(t, []server.TenantConfig{{
+		Name:       "acme",
+		APIKey:     "acme-key",
+		JoinSecret: "acme-secret",
+		MaxRooms:   10,
+	}}, c.Tenants)
+	assert.Equal(t, server.StoreTypeRedis, c.Store.Type)
+	assert.Equal(t, "localhost", c.Store.Redis.Host)
+	assert.Equal(t, 6379, c.Store.Redis.Port)
+	assert.Equal(t, "peercalls", c.Store.Redis.Prefix)
+	require.Len(t, c.ICEServers, 1)
+	ice := c.ICEServers[0]
+	assert.Equal(t, []string{"stun:stun.l.google.com:19302"}, ice.URLs)
+	assert.Equal(t, server.AuthTypeSecret, ice.AuthType)
+	assert.Equal(t, "test_user", ice.AuthSecret.Username)
+	assert.Equal(t, "test_secret", ice.AuthSecret.Secret)
+}
+
+func TestReadConfigTOML(t *testing.T) {
+	toml := `
+# comment
+bind_port = 3_000 # trailing comment
+network.sfu.audio_only_rooms = [
+  "radio", # comment
+  'conference',
+]
+network.sfu.bandwidth = { max_bitrate = 1_500_000, tolerance = 1.5, grace_period = "10s" }
+
+[capacity]
+max_participants = 100
+rooms."all hands" = 500
+
+[admin]
+token = "a\"bé"
+`
+	var c server.Config
+	err := server.ReadConfigTOML(strings.NewReader(toml), &c)
+	require.NoError(t, err)
+	assert.Equal(t, 3000, c.BindPort)
+	assert.Equal(t, []string{"radio", "conference"}, c.Network.SFU.AudioOnlyRooms)
+	assert.Equal(t, 1500000, c.Network.SFU.Bandwidth.MaxBitrate)
+	assert.Equal(t, 1.5, c.Network.SFU.Bandwidth.Tolerance)
+	assert.Equal(t, 10*time.Second, c.Network.SFU.Bandwidth.GracePeriod)
+	assert.Equal(t, 100, c.Capacity.MaxParticipants)
+	assert.Equal(t, map[string]int{"all hands": 500}, c.Capacity.Rooms)
+	assert.Equal(t, "a\"bé", c.Admin.Token)
+}
+
+func TestReadConfigTOML_error(t *testing.T) {
+	for _, toml := range []string{
+		"a = ",
+		"a = [1, 2",
+		"a = \"unterminated",
+		"a = 1\na = 2",
+		"a = 1\n[a]",
+		"[a\nb = 1",
+		"a = 1 b",
+		"a = '''multi-line'''",
+		"a = 1979-05-27",
+	} {
+		var c server.Config
+		err := server.ReadConfigTOML(strings.NewReader(toml), &c)
+		require.Error(t, err, "toml: %s", toml)
+		assert.Regexp(t, "Error parsing TOML", err.Error())
+	}
+}
+
 func TestReadConfigFiles_Error(t *testing.T) {
 	var c server.Config
 	err := server.ReadConfigFiles([]string{"config_missing.yml"}, &c)
@@ -72,6 +163,166 @@ func TestReadFromEnv(t *testing.T) {
 	os.Setenv(prefix+"ICE_SERVER_SECRET", "test_secret")
 	os.Setenv(prefix+"NETWORK_TYPE", "sfu")
 	os.Setenv(prefix+"NETWORK_SFU_INTERFACES", "a,b")
+	os.Setenv(prefix+"NETWORK_SFU_EXCLUDE_INTERFACES", "docker*,veth*")
+	os.Setenv(prefix+"NETWORK_SFU_NETWORK_TYPES", "udp6")
+	os.Setenv(prefix+"NETWORK_SFU_UDP_PORT_MIN", "50000")
+	os.Setenv(prefix+"NETWORK_SFU_UDP_PORT_MAX", "50100")
+	os.Setenv(prefix+"NETWORK_SFU_INGEST_LISTEN_IP", "10.0.0.1")
+	os.Setenv(prefix+"NETWORK_SFU_INGEST_UDP_PORT_MIN", "51000")
+	os.Setenv(prefix+"NETWORK_SFU_INGEST_UDP_PORT_MAX", "51010")
+	os.Setenv(prefix+"NETWORK_SFU_NAT_1TO1_IPS", "203.0.113.1,203.0.113.2/10.0.0.2")
+	os.Setenv(prefix+"NETWORK_SFU_BUNDLE_POLICY", "max-bundle")
+	os.Setenv(prefix+"NETWORK_SFU_RTCP_MUX_REQUIRED", "true")
+	os.Setenv(prefix+"NETWORK_SFU_DTLS_ROLE", "server")
+	os.Setenv(prefix+"NETWORK_SFU_DTLS_CERT_FILE", "/var/lib/peer-calls/dtls.pem")
+	os.Setenv(prefix+"NETWORK_SFU_DTLS_ROTATION_INTERVAL", "168h")
+	os.Setenv(prefix+"NETWORK_SFU_LAST_N", "4")
+	os.Setenv(prefix+"NETWORK_SFU_AUDIO_ONLY_ROOMS", "conference,radio")
+	os.Setenv(prefix+"NETWORK_SFU_FAN_OUT_WORKERS", "8")
+	os.Setenv(prefix+"NETWORK_SFU_FORWARDING_WORKERS", "6")
+	os.Setenv(prefix+"NETWORK_SFU_E2EE_ROOMS", "secret")
+	os.Setenv(prefix+"NETWORK_SFU_REWIND_DURATION", "4s")
+	os.Setenv(prefix+"NETWORK_SFU_REWIND_MAX_ROOM_BYTES", "1048576")
+	os.Setenv(prefix+"NETWORK_SFU_REORDER_DELAY", "30ms")
+	os.Setenv(prefix+"NETWORK_SFU_PUSH_TO_TALK_ROOMS", "radio")
+	os.Setenv(prefix+"NETWORK_SFU_PUSH_TO_TALK_SPEAKERS", "2")
+	os.Setenv(prefix+"NETWORK_SFU_PUSH_TO_TALK_MAX_HOLD_TIME", "30s")
+	os.Setenv(prefix+"NETWORK_SFU_NETWORK_QUALITY_INTERVAL", "2s")
+	os.Setenv(prefix+"NETWORK_SFU_CAPTURE_DIR", "/var/lib/peer-calls/captures")
+	os.Setenv(prefix+"NETWORK_SFU_CAPTURE_MAX_DURATION", "2m")
+	os.Setenv(prefix+"NETWORK_SFU_BANDWIDTH_MAX_BITRATE", "2500000")
+	os.Setenv(prefix+"NETWORK_SFU_BANDWIDTH_GRACE_PERIOD", "10s")
+	os.Setenv(prefix+"NETWORK_SFU_BANDWIDTH_ACTION", "throttle")
+	os.Setenv(prefix+"NETWORK_SFU_BANDWIDTH_ADVERTISE", "true")
+	os.Setenv(prefix+"NETWORK_SFU_DIRECT_TWO_PARTY", "true")
+	os.Setenv(prefix+"NETWORK_SFU_CODECS_VIDEO", "H264,VP8")
+	os.Setenv(prefix+"NETWORK_SFU_OPUS_FEC", "true")
+	os.Setenv(prefix+"NETWORK_SFU_OPUS_DTX", "true")
+	os.Setenv(prefix+"NETWORK_SFU_FEC", "true")
+	os.Setenv(prefix+"NETWORK_SFU_NEGOTIATION_DEBOUNCE", "50ms")
+	os.Setenv(prefix+"NETWORK_SFU_DATACHANNEL_SIGNALING", "true")
+	os.Setenv(prefix+"NETWORK_SFU_DISCONNECT_GRACE_PERIOD", "15s")
+	os.Setenv(prefix+"NETWORK_SFU_HEADER_EXTENSIONS", "true")
+	os.Setenv(prefix+"ADMIN_TOKEN", "admin_token")
+	os.Setenv(prefix+"ADMIN_GRPC_LISTEN_ADDR", "127.0.0.1:3001")
+	os.Setenv(prefix+"ADMIN_GRPC_TLS_CERT", "admin.pem")
+	os.Setenv(prefix+"ADMIN_GRPC_TLS_KEY", "admin.key")
+	os.Setenv(prefix+"ADMIN_GRPC_INSECURE", "true")
+	os.Setenv(prefix+"ADMIN_REQUIRE_API_KEYS", "true")
+	os.Setenv(prefix+"ADMIN_PPROF", "true")
+	os.Setenv(prefix+"INACTIVITY_TIMEOUT", "10m")
+	os.Setenv(prefix+"INACTIVITY_WARNING", "1m")
+	os.Setenv(prefix+"INACTIVITY_EXEMPT_VIEW_ONLY", "true")
+	os.Setenv(prefix+"KEEPALIVE_INTERVAL", "15s")
+	os.Setenv(prefix+"KEEPALIVE_TIMEOUT", "10s")
+	os.Setenv(prefix+"LIFECYCLE_IDLE_TIMEOUT", "5m")
+	os.Setenv(prefix+"LIFECYCLE_MAX_DURATION", "2h")
+	os.Setenv(prefix+"LIFECYCLE_WARNING", "10m")
+	os.Setenv(prefix+"LIFECYCLE_REUSE", "fresh")
+	os.Setenv(prefix+"CAPACITY_MAX_PARTICIPANTS", "500")
+	os.Setenv(prefix+"CAPACITY_MAX_ROOM_PARTICIPANTS", "50")
+	os.Setenv(prefix+"CAPACITY_NODE_MAX_CPU", "0.8")
+	os.Setenv(prefix+"CAPACITY_NODE_MAX_BITRATE", "500000000")
+	os.Setenv(prefix+"CAPACITY_NODE_MAX_TRACKS", "1000")
+	os.Setenv(prefix+"CAPACITY_NODE_REDIRECT_URL", "https://overflow.example.com")
+	os.Setenv(prefix+"CLUSTER_NODE_URL", "https://node1.example.com")
+	os.Setenv(prefix+"CLUSTER_NODE_ID", "node1")
+	os.Setenv(prefix+"CLUSTER_HEARTBEAT_INTERVAL", "2s")
+	os.Setenv(prefix+"CLUSTER_NODE_TIMEOUT", "10s")
+	os.Setenv(prefix+"RUNTIME_GOMAXPROCS", "4")
+	os.Setenv(prefix+"RUNTIME_SEND_QUEUE_SIZE", "512")
+	os.Setenv(prefix+"RUNTIME_NACK_BUFFER_SIZE", "1024")
+	os.Setenv(prefix+"RUNTIME_PLI_INTERVAL", "2s")
+	os.Setenv(prefix+"RUNTIME_PLI_MIN_INTERVAL", "250ms")
+	os.Setenv(prefix+"RUNTIME_PLI_MIN_INTERVAL_SCREEN", "50ms")
+	os.Setenv(prefix+"UPGRADE_SOCKET_PATH", "/run/peer-calls/upgrade.sock")
+	os.Setenv(prefix+"UPGRADE_REUSE_PORT", "true")
+	os.Setenv(prefix+"UPGRADE_DRAIN_TIMEOUT", "1h")
+	os.Setenv(prefix+"DIGEST_INTERVAL", "5s")
+	os.Setenv(prefix+"CHAT_HISTORY", "100")
+	os.Setenv(prefix+"EVENT_LOG_MAX_ROOM_EVENTS", "10000")
+	os.Setenv(prefix+"REACTIONS_INTERVAL", "1s")
+	os.Setenv(prefix+"REACTIONS_BURST", "3")
+	os.Setenv(prefix+"SPATIAL_INTERVAL", "50ms")
+	os.Setenv(prefix+"SPATIAL_MAX_COORDINATE", "500.5")
+	os.Setenv(prefix+"RATE_LIMIT_MESSAGE_INTERVAL", "100ms")
+	os.Setenv(prefix+"RATE_LIMIT_MESSAGE_BURST", "20")
+	os.Setenv(prefix+"RATE_LIMIT_JOIN_INTERVAL", "10s")
+	os.Setenv(prefix+"RATE_LIMIT_JOIN_BURST", "5")
+	os.Setenv(prefix+"RATE_LIMIT_TRUST_PROXY", "true")
+	os.Setenv(prefix+"RATE_LIMIT_MAX_IP_CONNECTIONS", "20")
+	os.Setenv(prefix+"RATE_LIMIT_MAX_IP_PEER_CONNECTIONS", "10")
+	os.Setenv(prefix+"RATE_LIMIT_ROOM_CREATION_INTERVAL", "1m")
+	os.Setenv(prefix+"RATE_LIMIT_ROOM_CREATION_BURST", "3")
+	os.Setenv(prefix+"RATE_LIMIT_BACKOFF", "2s")
+	os.Setenv(prefix+"RATE_LIMIT_MAX_BACKOFF", "10m")
+	os.Setenv(prefix+"CONNECTION_POLICY_ALLOW", "10.0.0.0/8,192.168.0.0/16")
+	os.Setenv(prefix+"CONNECTION_POLICY_DENY", "10.0.0.1")
+	os.Setenv(prefix+"CONNECTION_POLICY_GEOIP_DATABASE", "/geoip.csv")
+	os.Setenv(prefix+"CONNECTION_POLICY_ALLOW_COUNTRIES", "DE,FR")
+	os.Setenv(prefix+"CONNECTION_POLICY_DENY_COUNTRIES", "KP")
+	os.Setenv(prefix+"REGISTRY_DISABLE_AD_HOC", "true")
+	os.Setenv(prefix+"ROOM_FEATURES_DISABLE_CHAT", "true")
+	os.Setenv(prefix+"ROOM_FEATURES_MAX_VIDEO_HEIGHT", "720")
+	os.Setenv(prefix+"SECRETS_VAULT_ADDR", "https://vault:8200")
+	os.Setenv(prefix+"SECRETS_VAULT_TOKEN", "${file:/run/secrets/vault-token}")
+	os.Setenv(prefix+"SIP_LISTEN_ADDR", "0.0.0.0:5060")
+	os.Setenv(prefix+"SIP_PUBLIC_IP", "203.0.113.10")
+	os.Setenv(prefix+"SIP_USERNAME", "phone")
+	os.Setenv(prefix+"SIP_PASSWORD", "sip-secret")
+	os.Setenv(prefix+"SIP_ALLOWED_NETWORKS", "192.0.2.0/24,198.51.100.0/24")
+	os.Setenv(prefix+"SIP_MAX_CALLS", "5")
+	os.Setenv(prefix+"RECORDING_DIR", "/var/lib/peer-calls/recordings")
+	os.Setenv(prefix+"RECORDING_POST_PROCESS", "remux,upload")
+	os.Setenv(prefix+"RECORDING_FFMPEG", "/usr/local/bin/ffmpeg")
+	os.Setenv(prefix+"RECORDING_MAX_ATTEMPTS", "2")
+	os.Setenv(prefix+"RECORDING_RETRY_INTERVAL", "30s")
+	os.Setenv(prefix+"STORAGE_TYPE", "s3")
+	os.Setenv(prefix+"STORAGE_DIR", "/mnt/recordings")
+	os.Setenv(prefix+"STORAGE_S3_ENDPOINT", "https://s3.eu-west-1.amazonaws.com")
+	os.Setenv(prefix+"STORAGE_S3_REGION", "eu-west-1")
+	os.Setenv(prefix+"STORAGE_S3_BUCKET", "peer-calls")
+	os.Setenv(prefix+"STORAGE_S3_ACCESS_KEY_ID", "access_key")
+	os.Setenv(prefix+"STORAGE_S3_SECRET_ACCESS_KEY", "secret_key")
+	os.Setenv(prefix+"STORAGE_PREFIX", "meet/")
+	os.Setenv(prefix+"STORAGE_MAX_ATTEMPTS", "3")
+	os.Setenv(prefix+"STORAGE_RETRY_INTERVAL", "10s")
+	os.Setenv(prefix+"STORAGE_DELETE_AFTER_UPLOAD", "true")
+	os.Setenv(prefix+"AUDIT_SYSLOG_NETWORK", "tcp")
+	os.Setenv(prefix+"AUDIT_SYSLOG_ADDR", "siem:514")
+	os.Setenv(prefix+"AUDIT_HTTP_URL", "https://siem/events")
+	os.Setenv(prefix+"AUDIT_HTTP_TOKEN", "audit_token")
+	os.Setenv(prefix+"WEBHOOK_URL", "https://hooks/peer-calls")
+	os.Setenv(prefix+"WEBHOOK_SECRET", "webhook_secret")
+	os.Setenv(prefix+"WEBHOOK_KEY_ID", "webhook_key")
+	os.Setenv(prefix+"WEBHOOK_MAX_ATTEMPTS", "3")
+	os.Setenv(prefix+"WEBHOOK_RETRY_INTERVAL", "2s")
+	os.Setenv(prefix+"METERING_INTERVAL", "1m")
+	os.Setenv(prefix+"METERING_WEBHOOK_URL", "https://billing/usage")
+	os.Setenv(prefix+"METERING_WEBHOOK_SECRET", "metering_secret")
+	os.Setenv(prefix+"METERING_WEBHOOK_KEY_ID", "metering_key")
+	os.Setenv(prefix+"LOG_LEVEL", "debug")
+	os.Setenv(prefix+"LOG_FORMAT", "json")
+	os.Setenv(prefix+"TRACING_ENDPOINT", "http://collector:4318/v1/traces")
+	os.Setenv(prefix+"TRACING_SERVICE_NAME", "peer-calls-eu")
+	os.Setenv(prefix+"EGRESS_ENDPOINT", "grpc://egress:9000")
+	os.Setenv(prefix+"EGRESS_SECRET", "egress_secret")
+	os.Setenv(prefix+"EGRESS_NODE_URL", "https://node1.example.com")
+	os.Setenv(prefix+"EGRESS_CA", "egress_ca.pem")
+	os.Setenv(prefix+"EGRESS_INSECURE", "true")
+	os.Setenv(prefix+"TRANSCRIPTION_ENDPOINT", "stt:9000")
+	os.Setenv(prefix+"TRANSCRIPTION_SECRET", "transcription_secret")
+	os.Setenv(prefix+"TRANSCRIPTION_ROOMS", "lobby,talks")
+	os.Setenv(prefix+"TRANSCRIPTION_CA", "stt_ca.pem")
+	os.Setenv(prefix+"TRANSCRIPTION_INSECURE", "true")
+	os.Setenv(prefix+"SIGNALING_GRPC_LISTEN_ADDR", "127.0.0.1:3002")
+	os.Setenv(prefix+"SIGNALING_GRPC_TLS_CERT", "signaling.pem")
+	os.Setenv(prefix+"SIGNALING_GRPC_TLS_KEY", "signaling.key")
+	os.Setenv(prefix+"SIGNALING_GRPC_INSECURE", "true")
+	os.Setenv(prefix+"SIGNALING_TCP_LISTEN_ADDR", "127.0.0.1:3003")
+	os.Setenv(prefix+"SOCKET_IO_ENABLED", "true")
+	os.Setenv(prefix+"SOCKET_IO_PING_INTERVAL", "10s")
+	os.Setenv(prefix+"SOCKET_IO_PING_TIMEOUT", "5s")
 	var c server.Config
 	server.ReadConfigFromEnv(prefix, &c)
 	assert.Equal(t, "/test", c.BaseURL)
@@ -92,4 +343,211 @@ func TestReadFromEnv(t *testing.T) {
 	assert.Equal(t, "test_secret", ice.AuthSecret.Secret)
 	assert.Equal(t, server.NetworkType("sfu"), c.Network.Type)
 	assert.Equal(t, []string{"a", "b"}, c.Network.SFU.Interfaces)
+	assert.Equal(t, []string{"docker*", "veth*"}, c.Network.SFU.ExcludeInterfaces)
+	assert.Equal(t, []string{"udp6"}, c.Network.SFU.NetworkTypes)
+	assert.Equal(t, server.UDPConfig{PortMin: 50000, PortMax: 50100}, c.Network.SFU.UDP)
+	assert.Equal(t, server.IngestConfig{
+		ListenIP: "10.0.0.1",
+		UDP:      server.UDPConfig{PortMin: 51000, PortMax: 51010},
+	}, c.Network.SFU.Ingest)
+	assert.Equal(t, []string{"203.0.113.1", "203.0.113.2/10.0.0.2"}, c.Network.SFU.NAT1To1IPs)
+	assert.Equal(t, server.PeerConnectionConfig{
+		BundlePolicy:    "max-bundle",
+		RTCPMuxRequired: true,
+		DTLSRole:        "server",
+	}, c.Network.SFU.PeerConnection)
+	assert.Equal(t, server.DTLSConfig{
+		CertFile:         "/var/lib/peer-calls/dtls.pem",
+		RotationInterval: 7 * 24 * time.Hour,
+	}, c.Network.SFU.DTLS)
+	assert.Equal(t, 4, c.Network.SFU.LastN)
+	assert.Equal(t, []string{"conference", "radio"}, c.Network.SFU.AudioOnlyRooms)
+	assert.Equal(t, 8, c.Network.SFU.FanOutWorkers)
+	assert.Equal(t, 6, c.Network.SFU.ForwardingWorkers)
+	assert.Equal(t, []string{"secret"}, c.Network.SFU.E2EERooms)
+	assert.True(t, c.Network.SFU.IsE2EERoom("secret"))
+	assert.Equal(t, 4*time.Second, c.Network.SFU.Rewind.Duration)
+	assert.Equal(t, 1048576, c.Network.SFU.Rewind.MaxRoomBytes)
+	assert.Equal(t, 30*time.Millisecond, c.Network.SFU.Reorder.Delay)
+	assert.Equal(t, server.PushToTalkConfig{Rooms: []string{"radio"}, Speakers: 2, MaxHoldTime: 30 * time.Second}, c.Network.SFU.PushToTalk)
+	assert.Equal(t, server.NetworkQualityConfig{Interval: 2 * time.Second}, c.Network.SFU.NetworkQuality)
+	assert.Equal(t, server.CaptureConfig{Dir: "/var/lib/peer-calls/captures", MaxDuration: 2 * time.Minute}, c.Network.SFU.Capture)
+	assert.Equal(t, 2500000, c.Network.SFU.Bandwidth.MaxBitrate)
+	assert.Equal(t, 10*time.Second, c.Network.SFU.Bandwidth.GracePeriod)
+	assert.Equal(t, server.BandwidthActionThrottle, c.Network.SFU.Bandwidth.Action)
+	assert.True(t, c.Network.SFU.Bandwidth.Advertise)
+	assert.True(t, c.Network.SFU.DirectTwoParty)
+	assert.Equal(t, []string{"H264", "VP8"}, c.Network.SFU.Codecs.Video)
+	assert.Equal(t, server.OpusConfig{FEC: true, DTX: true}, c.Network.SFU.Opus)
+	assert.True(t, c.Network.SFU.FEC)
+	assert.Equal(t, 50*time.Millisecond, c.Network.SFU.NegotiationDebounce)
+	assert.True(t, c.Network.SFU.DataChannelSignaling)
+	assert.Equal(t, 15*time.Second, c.Network.SFU.DisconnectGracePeriod)
+	assert.True(t, c.Network.SFU.HeaderExtensions)
+	assert.Equal(t, 2500000, c.Network.SFU.Bandwidth.RoomMaxBitrate("room"))
+	assert.Equal(t, "admin_token", c.Admin.Token)
+	assert.Equal(t, "127.0.0.1:3001", c.Admin.GRPCListenAddr)
+	assert.Equal(t, server.TLSConfig{Cert: "admin.pem", Key: "admin.key"}, c.Admin.GRPCTLS)
+	assert.True(t, c.Admin.GRPCInsecure)
+	assert.True(t, c.Admin.RequireAPIKeys)
+	assert.True(t, c.Admin.Pprof)
+	assert.Equal(t, 10*time.Minute, c.Inactivity.Timeout)
+	assert.Equal(t, time.Minute, c.Inactivity.Warning)
+	assert.Equal(t, true, c.Inactivity.ExemptViewOnly)
+	assert.Equal(t, server.KeepaliveConfig{Interval: 15 * time.Second, Timeout: 10 * time.Second}, c.Keepalive)
+	assert.Equal(t, 5*time.Minute, c.Lifecycle.IdleTimeout)
+	assert.Equal(t, 2*time.Hour, c.Lifecycle.MaxDuration)
+	assert.Equal(t, 10*time.Minute, c.Lifecycle.Warning)
+	assert.Equal(t, server.RoomReuseFresh, c.Lifecycle.Reuse)
+	assert.Equal(t, 500, c.Capacity.MaxParticipants)
+	assert.Equal(t, 50, c.Capacity.MaxRoomParticipants)
+	assert.Equal(t, server.NodeCapacityConfig{
+		MaxCPU:      0.8,
+		MaxBitrate:  500000000,
+		MaxTracks:   1000,
+		RedirectURL: "https://overflow.example.com",
+	}, c.Capacity.Node)
+	assert.Equal(t, server.ClusterConfig{
+		NodeURL:           "https://node1.example.com",
+		NodeID:            "node1",
+		HeartbeatInterval: 2 * time.Second,
+		NodeTimeout:       10 * time.Second,
+	}, c.Cluster)
+	assert.Equal(t, server.RuntimeConfig{
+		GOMAXPROCS:           4,
+		SendQueueSize:        512,
+		NACKBufferSize:       1024,
+		PLIInterval:          2 * time.Second,
+		PLIMinInterval:       250 * time.Millisecond,
+		PLIMinIntervalScreen: 50 * time.Millisecond,
+	}, c.Runtime)
+	assert.Equal(t, server.UpgradeConfig{
+		SocketPath:   "/run/peer-calls/upgrade.sock",
+		ReusePort:    true,
+		DrainTimeout: time.Hour,
+	}, c.Upgrade)
+	assert.Equal(t, 5*time.Second, c.Digest.Interval)
+	assert.Equal(t, 100, c.Chat.History)
+	assert.Equal(t, 10000, c.EventLog.MaxRoomEvents)
+	assert.Equal(t, server.ReactionsConfig{Interval: time.Second, Burst: 3}, c.Reactions)
+	assert.Equal(t, server.SpatialConfig{Interval: 50 * time.Millisecond, MaxCoordinate: 500.5}, c.Spatial)
+	assert.Equal(t, server.RateLimitConfig{
+		MessageInterval: 100 * time.Millisecond,
+		MessageBurst:    20,
+		JoinInterval:    10 * time.Second,
+		JoinBurst:       5,
+		TrustProxy:      true,
+
+		MaxIPConnections:     20,
+		MaxIPPeerConnections: 10,
+		RoomCreationInterval: time.Minute,
+		RoomCreationBurst:    3,
+		Backoff:              2 * time.Second,
+		MaxBackoff:           10 * time.Minute,
+	}, c.RateLimit)
+	assert.Equal(t, server.ConnectionPolicyConfig{
+		IPPolicyConfig: server.IPPolicyConfig{
+			Allow:          []string{"10.0.0.0/8", "192.168.0.0/16"},
+			Deny:           []string{"10.0.0.1"},
+			AllowCountries: []string{"DE", "FR"},
+			DenyCountries:  []string{"KP"},
+		},
+		GeoIPDatabase: "/geoip.csv",
+	}, c.ConnectionPolicy)
+	assert.True(t, c.Registry.DisableAdHoc)
+	assert.Equal(t, server.RoomFeaturesConfig{DisableChat: true, MaxVideoHeight: 720}, c.RoomFeatures)
+	assert.Equal(t, "https://vault:8200", c.Secrets.Vault.Addr)
+	assert.Equal(t, "${file:/run/secrets/vault-token}", c.Secrets.Vault.Token)
+	assert.Equal(t, "0.0.0.0:5060", c.SIP.ListenAddr)
+	assert.Equal(t, "203.0.113.10", c.SIP.PublicIP)
+	assert.Equal(t, "phone", c.SIP.Username)
+	assert.Equal(t, "sip-secret", c.SIP.Password)
+	assert.Equal(t, []string{"192.0.2.0/24", "198.51.100.0/24"}, c.SIP.AllowedNetworks)
+	assert.Equal(t, 5, c.SIP.MaxCalls)
+	assert.Equal(t, "/var/lib/peer-calls/recordings", c.Recording.Dir)
+	assert.Equal(t, []string{"remux", "upload"}, c.Recording.PostProcess)
+	assert.Equal(t, "/usr/local/bin/ffmpeg", c.Recording.FFmpeg)
+	assert.Equal(t, 2, c.Recording.MaxAttempts)
+	assert.Equal(t, 30*time.Second, c.Recording.RetryInterval)
+	assert.Equal(t, server.StorageConfig{
+		Type: server.BlobStoreTypeS3,
+		Dir:  "/mnt/recordings",
+		S3: server.S3Config{
+			Endpoint:        "https://s3.eu-west-1.amazonaws.com",
+			Region:          "eu-west-1",
+			Bucket:          "peer-calls",
+			AccessKeyID:     "access_key",
+			SecretAccessKey: "secret_key",
+		},
+		Prefix:            "meet/",
+		MaxAttempts:       3,
+		RetryInterval:     10 * time.Second,
+		DeleteAfterUpload: true,
+	}, c.Storage)
+	assert.Equal(t, "tcp", c.Audit.Syslog.Network)
+	assert.Equal(t, "siem:514", c.Audit.Syslog.Addr)
+	assert.Equal(t, "https://siem/events", c.Audit.HTTP.URL)
+	assert.Equal(t, "audit_token", c.Audit.HTTP.Token)
+	assert.Equal(t, "https://hooks/peer-calls", c.Webhook.URL)
+	assert.Equal(t, "webhook_secret", c.Webhook.Secret)
+	assert.Equal(t, "webhook_key", c.Webhook.KeyID)
+	assert.Equal(t, 3, c.Webhook.MaxAttempts)
+	assert.Equal(t, 2*time.Second, c.Webhook.RetryInterval)
+	assert.Equal(t, server.MeteringConfig{
+		Interval: time.Minute,
+		Webhook: server.WebhookConfig{
+			URL:    "https://billing/usage",
+			Secret: "metering_secret",
+			KeyID:  "metering_key",
+		},
+	}, c.Metering)
+	assert.Equal(t, server.LogConfig{Level: "debug", Format: "json"}, c.Log)
+	assert.Equal(t, "http://collector:4318/v1/traces", c.Tracing.Endpoint)
+	assert.Equal(t, "peer-calls-eu", c.Tracing.ServiceName)
+	assert.Equal(t, "grpc://egress:9000", c.Egress.Endpoint)
+	assert.Equal(t, "egress_secret", c.Egress.Secret)
+	assert.Equal(t, "https://node1.example.com", c.Egress.NodeURL)
+	assert.Equal(t, "egress_ca.pem", c.Egress.CA)
+	assert.True(t, c.Egress.Insecure)
+	assert.Equal(t, server.TranscriptionConfig{
+		Endpoint: "stt:9000",
+		Secret:   "transcription_secret",
+		CA:       "stt_ca.pem",
+		Insecure: true,
+		Rooms:    []string{"lobby", "talks"},
+	}, c.Transcription)
+	assert.Equal(t, server.SignalingConfig{
+		GRPCListenAddr: "127.0.0.1:3002",
+		GRPCTLS:        server.TLSConfig{Cert: "signaling.pem", Key: "signaling.key"},
+		GRPCInsecure:   true,
+		TCPListenAddr:  "127.0.0.1:3003",
+	}, c.Signaling)
+	assert.Equal(t, server.SocketIOConfig{
+		Enabled:      true,
+		PingInterval: 10 * time.Second,
+		PingTimeout:  5 * time.Second,
+	}, c.SocketIO)
+}
+
+func TestReadConfigYAML_inactivity(t *testing.T) {
+	yaml := `
+inactivity:
+  timeout: 30m
+  warning: 1m
+  rooms:
+    webinar:
+      timeout: 2h
+      exempt_view_only: true
+`
+	var c server.Config
+	err := server.ReadConfigYAML(strings.NewReader(yaml), &c)
+	require.NoError(t, err)
+	assert.Equal(t, server.InactivityPolicy{
+		Timeout: 30 * time.Minute,
+		Warning: time.Minute,
+	}, c.Inactivity.Policy("other"))
+	assert.Equal(t, server.InactivityPolicy{
+		Timeout:        2 * time.Hour,
+		ExemptViewOnly: true,
+	}, c.Inactivity.Policy("webinar"))
 }
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// reloadableConfig contains the yaml keys of Config sections which can be
+// changed without a restart.
+var reloadableConfig = map[string]struct{}{
+	"ice_servers":       {},
+	"client":            {},
+	"capacity":          {},
+	"connection_policy": {},
+	"log":               {},
+	"runtime":           {},
+}
+
+// ConfigReloader rereads the config at runtime, for example on SIGHUP, and
+// passes it to handlers which apply the values that can change without a
+// restart: ICE servers including the TURN secret, client config, capacity
+// limits, the connection policy, the log level and the runtime tunables.
+type ConfigReloader struct {
+	log  Logger
+	read func() (Config, error)
+
+	mu       sync.Mutex
+	current  Config
+	handlers []func(c Config)
+}
+
+// NewConfigReloader creates a reloader for the config currently in use. read
+// must return the config with secrets resolved.
+func NewConfigReloader(loggerFactory LoggerFactory, current Config, read func() (Config, error)) *ConfigReloader {
+	return &ConfigReloader{
+		log:     loggerFactory.GetLogger("config"),
+		read:    read,
+		current: current,
+	}
+}
+
+// OnReload adds a handler called with the new config after each successful
+// reload. It must be called before Reload.
+func (r *ConfigReloader) OnReload(handler func(c Config)) {
+	r.handlers = append(r.handlers, handler)
+}
+
+// Reload reads the config and calls the handlers. The config in use is kept
+// when reading fails. Changes which require a restart are logged, but not
+// applied.
+func (r *ConfigReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c, err := r.read()
+	if err != nil {
+		return fmt.Errorf("Error reloading config: %w", err)
+	}
+
+	if changed := restartRequiredChanges(r.current, c); len(changed) > 0 {
+		r.log.Warnf("Changes of %s require a restart", strings.Join(changed, ", "))
+	}
+
+	r.current = c
+	for _, handler := range r.handlers {
+		handler(c)
+	}
+
+	r.log.Printf("Config reloaded")
+	return nil
+}
+
+// restartRequiredChanges returns the yaml keys of changed config sections
+// which cannot be applied at runtime.
+func restartRequiredChanges(old Config, new Config) []string {
+	var changed []string
+
+	oldValue := reflect.ValueOf(old)
+	newValue := reflect.ValueOf(new)
+	for i := 0; i < oldValue.NumField(); i++ {
+		key := strings.Split(oldValue.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if _, ok := reloadableConfig[key]; ok {
+			continue
+		}
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			changed = append(changed, key)
+		}
+	}
+
+	if old.Log.Format != new.Log.Format {
+		changed = append(changed, "log.format")
+	}
+
+	return changed
+}
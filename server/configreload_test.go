@@ -0,0 +1,66 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigReloader(t *testing.T) {
+	var current server.Config
+	server.InitConfig(&current)
+
+	next := current
+	var readErr error
+	reloader := server.NewConfigReloader(loggerFactory, current, func() (server.Config, error) {
+		return next, readErr
+	})
+
+	var reloaded []server.Config
+	reloader.OnReload(func(c server.Config) {
+		reloaded = append(reloaded, c)
+	})
+
+	ice := server.ICEServer{
+		URLs:     []string{"turn:turn.example.com"},
+		AuthType: server.AuthTypeSecret,
+	}
+	ice.AuthSecret.Username = "user"
+	ice.AuthSecret.Secret = "rotated"
+	next.ICEServers = []server.ICEServer{ice}
+	// requires a restart, but does not prevent other changes from being
+	// applied
+	next.BindPort = 3001
+	require.NoError(t, reloader.Reload())
+	require.Len(t, reloaded, 1)
+	assert.Equal(t, "rotated", reloaded[0].ICEServers[0].AuthSecret.Secret)
+
+	readErr = errors.New("test")
+	err := reloader.Reload()
+	require.Error(t, err)
+	assert.Regexp(t, "Error reloading config", err.Error())
+	assert.Len(t, reloaded, 1)
+}
+
+func TestMux_SetICEServers(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, server.AdminConfig{}, server.InactivityConfig{}, server.ClientConfig{}, mrm, newMockTracksManager())
+
+	mux.SetICEServers([]server.ICEServer{{URLs: []string{"stun:reloaded"}}})
+	mux.SetClientConfig(server.ClientConfig{Features: map[string]bool{"chat": true}})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/config", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	var doc server.ClientConfigDocument
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, []server.ICEAuthServer{{URLs: []string{"stun:reloaded"}}}, doc.ICEServers)
+	assert.Equal(t, map[string]bool{"chat": true}, doc.Features)
+}
@@ -0,0 +1,483 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ReadConfigTOML reads a TOML config. Keys are the same as in YAML configs.
+//
+// Only the subset of TOML needed for configs is supported: tables, arrays of
+// tables, dotted keys, basic and literal strings, integers, floats, booleans,
+// arrays and inline tables. Multi-line strings and dates are not supported.
+func ReadConfigTOML(reader io.Reader, c *Config) error {
+	values, err := parseTOML(reader)
+	if err != nil {
+		return fmt.Errorf("Error parsing TOML: %w", err)
+	}
+
+	// Decoding the values as YAML reuses the yaml struct tags and the
+	// handling of custom types like durations.
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("Error parsing TOML: %w", err)
+	}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("Error parsing TOML: %w", err)
+	}
+	return nil
+}
+
+type tomlTable = map[string]interface{}
+
+func parseTOML(reader io.Reader) (tomlTable, error) {
+	root := tomlTable{}
+	current := root
+
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	var pending string
+
+	for scanner.Scan() {
+		lineNumber++
+		line := pending + scanner.Text()
+		pending = ""
+
+		p := &tomlParser{input: line}
+		p.skipSpace()
+		if p.done() {
+			continue
+		}
+
+		if strings.HasPrefix(p.rest(), "[") {
+			table, err := p.parseHeader(root)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			current = table
+			continue
+		}
+
+		err := p.parseKeyValue(current)
+		if err == errTOMLIncomplete {
+			// arrays may span multiple lines
+			pending = line + "\n"
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if pending != "" {
+		return nil, fmt.Errorf("line %d: %w", lineNumber, errTOMLIncomplete)
+	}
+	return root, nil
+}
+
+var errTOMLIncomplete = fmt.Errorf("unexpected end of input")
+
+type tomlParser struct {
+	input string
+	pos   int
+}
+
+func (p *tomlParser) done() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *tomlParser) rest() string {
+	return p.input[p.pos:]
+}
+
+// skipSpace skips whitespace and comments, including newlines of values
+// spanning multiple lines.
+func (p *tomlParser) skipSpace() {
+	for !p.done() {
+		switch p.input[p.pos] {
+		case ' ', '\t', '\r', '\n':
+			p.pos++
+		case '#':
+			for !p.done() && p.input[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) expect(c byte) error {
+	p.skipSpace()
+	if p.done() {
+		return errTOMLIncomplete
+	}
+	if p.input[p.pos] != c {
+		return fmt.Errorf("expected %q, got %q", c, p.input[p.pos])
+	}
+	p.pos++
+	return nil
+}
+
+func (p *tomlParser) expectEnd() error {
+	p.skipSpace()
+	if !p.done() {
+		return fmt.Errorf("unexpected characters: %q", p.rest())
+	}
+	return nil
+}
+
+// parseHeader parses a [table] or [[array.of.tables]] header and returns
+// the table following keys are set in.
+func (p *tomlParser) parseHeader(root tomlTable) (tomlTable, error) {
+	isArray := strings.HasPrefix(p.rest(), "[[")
+	p.pos++
+	if isArray {
+		p.pos++
+	}
+
+	keys, err := p.parseKeys()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(']'); err != nil {
+		return nil, err
+	}
+	if isArray {
+		if err := p.expect(']'); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectEnd(); err != nil {
+		return nil, err
+	}
+
+	parent, err := tomlSubTable(root, keys[:len(keys)-1])
+	if err != nil {
+		return nil, err
+	}
+	key := keys[len(keys)-1]
+
+	if !isArray {
+		return tomlSubTable(parent, []string{key})
+	}
+
+	table := tomlTable{}
+	switch existing := parent[key].(type) {
+	case nil:
+		parent[key] = []interface{}{table}
+	case []interface{}:
+		parent[key] = append(existing, table)
+	default:
+		return nil, fmt.Errorf("key %q is not an array of tables", key)
+	}
+	return table, nil
+}
+
+// tomlSubTable returns the table at keys, creating missing tables. The last
+// element of an array of tables is used for keys referring to the array.
+func tomlSubTable(table tomlTable, keys []string) (tomlTable, error) {
+	for _, key := range keys {
+		switch value := table[key].(type) {
+		case nil:
+			sub := tomlTable{}
+			table[key] = sub
+			table = sub
+		case tomlTable:
+			table = value
+		case []interface{}:
+			last, ok := value[len(value)-1].(tomlTable)
+			if !ok {
+				return nil, fmt.Errorf("key %q is not a table", key)
+			}
+			table = last
+		default:
+			return nil, fmt.Errorf("key %q is not a table", key)
+		}
+	}
+	return table, nil
+}
+
+func (p *tomlParser) parseKeyValue(table tomlTable) error {
+	keys, err := p.parseKeys()
+	if err != nil {
+		return err
+	}
+	if err := p.expect('='); err != nil {
+		return err
+	}
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+	if err := p.expectEnd(); err != nil {
+		return err
+	}
+	return tomlSet(table, keys, value)
+}
+
+func tomlSet(table tomlTable, keys []string, value interface{}) error {
+	parent, err := tomlSubTable(table, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+	key := keys[len(keys)-1]
+	if _, ok := parent[key]; ok {
+		return fmt.Errorf("duplicate key: %q", key)
+	}
+	parent[key] = value
+	return nil
+}
+
+// parseKeys parses a dotted key.
+func (p *tomlParser) parseKeys() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace()
+		if p.done() {
+			return nil, errTOMLIncomplete
+		}
+
+		var key string
+		var err error
+		switch p.input[p.pos] {
+		case '"':
+			key, err = p.parseBasicString()
+		case '\'':
+			key, err = p.parseLiteralString()
+		default:
+			start := p.pos
+			for !p.done() && isTOMLBareKeyChar(p.input[p.pos]) {
+				p.pos++
+			}
+			key = p.input[start:p.pos]
+			if key == "" {
+				err = fmt.Errorf("expected key, got %q", p.input[p.pos])
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+
+		p.skipSpace()
+		if p.done() || p.input[p.pos] != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func isTOMLBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	p.skipSpace()
+	if p.done() {
+		return nil, errTOMLIncomplete
+	}
+
+	switch c := p.input[p.pos]; c {
+	case '"':
+		if strings.HasPrefix(p.rest(), `"""`) {
+			return nil, fmt.Errorf("multi-line strings are not supported")
+		}
+		return p.parseBasicString()
+	case '\'':
+		if strings.HasPrefix(p.rest(), "'''") {
+			return nil, fmt.Errorf("multi-line strings are not supported")
+		}
+		return p.parseLiteralString()
+	case '[':
+		return p.parseArray()
+	case '{':
+		return p.parseInlineTable()
+	default:
+		return p.parseScalar()
+	}
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	// skip opening quote
+	p.pos++
+
+	var b strings.Builder
+	for !p.done() {
+		c := p.input[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\n':
+			return "", fmt.Errorf("unterminated string")
+		case '\\':
+			if p.done() {
+				return "", errTOMLIncomplete
+			}
+			escape := p.input[p.pos]
+			p.pos++
+			switch escape {
+			case 'b':
+				b.WriteByte('\b')
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'f':
+				b.WriteByte('\f')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(escape)
+			case 'u', 'U':
+				size := 4
+				if escape == 'U' {
+					size = 8
+				}
+				if p.pos+size > len(p.input) {
+					return "", fmt.Errorf("invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(p.input[p.pos:p.pos+size], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(code)) {
+					return "", fmt.Errorf("invalid unicode escape")
+				}
+				p.pos += size
+				b.WriteRune(rune(code))
+			default:
+				return "", fmt.Errorf("invalid escape: \\%c", escape)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated string")
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	// skip opening quote
+	p.pos++
+	end := strings.IndexAny(p.rest(), "'\n")
+	if end < 0 || p.input[p.pos+end] != '\'' {
+		return "", fmt.Errorf("unterminated string")
+	}
+	value := p.input[p.pos : p.pos+end]
+	p.pos += end + 1
+	return value, nil
+}
+
+func (p *tomlParser) parseArray() ([]interface{}, error) {
+	// skip [
+	p.pos++
+
+	values := []interface{}{}
+	for {
+		p.skipSpace()
+		if p.done() {
+			return nil, errTOMLIncomplete
+		}
+		if p.input[p.pos] == ']' {
+			p.pos++
+			return values, nil
+		}
+
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		p.skipSpace()
+		if p.done() {
+			return nil, errTOMLIncomplete
+		}
+		switch p.input[p.pos] {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, fmt.Errorf("expected ',' or ']' in array, got %q", p.input[p.pos])
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (tomlTable, error) {
+	// skip {
+	p.pos++
+
+	table := tomlTable{}
+	p.skipSpace()
+	if !p.done() && p.input[p.pos] == '}' {
+		p.pos++
+		return table, nil
+	}
+
+	for {
+		keys, err := p.parseKeys()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect('='); err != nil {
+			return nil, err
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := tomlSet(table, keys, value); err != nil {
+			return nil, err
+		}
+
+		p.skipSpace()
+		if p.done() {
+			return nil, errTOMLIncomplete
+		}
+		switch p.input[p.pos] {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return table, nil
+		default:
+			return nil, fmt.Errorf("expected ',' or '}' in inline table, got %q", p.input[p.pos])
+		}
+	}
+}
+
+func (p *tomlParser) parseScalar() (interface{}, error) {
+	start := p.pos
+	for !p.done() && !strings.ContainsRune(" \t\r\n,]}#", rune(p.input[p.pos])) {
+		p.pos++
+	}
+	token := p.input[start:p.pos]
+
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "":
+		return nil, fmt.Errorf("expected value, got %q", p.input[p.pos])
+	}
+
+	number := strings.Replace(token, "_", "", -1)
+	if value, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return value, nil
+	}
+	switch number {
+	case "inf", "+inf", "-inf", "nan", "+nan", "-nan":
+		return nil, fmt.Errorf("unsupported float: %s", token)
+	}
+	if value, err := strconv.ParseFloat(number, 64); err == nil {
+		return value, nil
+	}
+	return nil, fmt.Errorf("invalid value: %s", token)
+}
@@ -1,5 +1,7 @@
 package server
 
+import "time"
+
 type AuthType string
 
 const (
@@ -53,14 +55,965 @@ type NetworkConfig struct {
 
 type NetworkConfigSFU struct {
 	Interfaces []string `yaml:"interfaces"`
+	// ExcludeInterfaces are patterns of interfaces which are never used for
+	// ICE candidates, for example docker* or veth*.
+	ExcludeInterfaces []string `yaml:"exclude_interfaces"`
+	// NetworkTypes restricts candidates to udp4 or udp6. Both are used when
+	// empty.
+	NetworkTypes []string  `yaml:"network_types"`
+	UDP          UDPConfig `yaml:"udp"`
+	// Ingest configures the ports raw RTP ingest sources send to.
+	Ingest IngestConfig `yaml:"ingest"`
+	// NAT1To1IPs are advertised in host candidates instead of local IPs, for
+	// example the public IP of a server behind NAT. An entry can also map a
+	// public IP to a local IP in the form public/local.
+	NAT1To1IPs []string `yaml:"nat_1to1_ips"`
+	// PeerConnection configures the transports of peer connections.
+	PeerConnection PeerConnectionConfig `yaml:"peer_connection"`
+	// DTLS configures the persistence of the DTLS certificate.
+	DTLS DTLSConfig `yaml:"dtls"`
+	// LastN limits the number of video tracks forwarded to each peer in rooms
+	// with more than LastN peers. Only the video of the most recently active
+	// speakers is forwarded. Zero disables the limit.
+	LastN int `yaml:"last_n"`
+	// AudioOnlyRooms lists rooms in which video tracks are never forwarded.
+	AudioOnlyRooms []string `yaml:"audio_only_rooms"`
+	// E2EERooms lists rooms in which peers encrypt media end-to-end. The SFU
+	// forwards the encrypted payloads untouched and relays key distribution
+	// messages between peers.
+	E2EERooms []string      `yaml:"e2ee_rooms"`
+	Rewind    RewindConfig  `yaml:"rewind"`
+	Reorder   ReorderConfig `yaml:"reorder"`
+	// PushToTalk forwards the audio of only the peers holding the floor.
+	PushToTalk     PushToTalkConfig     `yaml:"push_to_talk"`
+	NetworkQuality NetworkQualityConfig `yaml:"network_quality"`
+	// Capture lets admins write RTP packets of peers to files.
+	Capture CaptureConfig `yaml:"capture"`
+	// FanOutWorkers is the number of goroutines which add and remove tracks
+	// to subscribers. Defaults to the number of CPUs.
+	FanOutWorkers int `yaml:"fan_out_workers"`
+	// ForwardingWorkers is the number of goroutines which forward packets of
+	// published tracks to subscribers. Defaults to the number of CPUs.
+	ForwardingWorkers int                   `yaml:"forwarding_workers"`
+	Bandwidth         BandwidthPolicyConfig `yaml:"bandwidth"`
+	// DirectTwoParty lets the two peers of a room connect directly, with the
+	// server only relaying signals. Peers are moved to the SFU when a third
+	// peer joins. Rooms which are recorded, have egresses or are end-to-end
+	// encrypted are never connected directly.
+	DirectTwoParty bool        `yaml:"direct_two_party"`
+	Codecs         CodecConfig `yaml:"codecs"`
+	Opus           OpusConfig  `yaml:"opus"`
+	// FEC offers RED, ULPFEC and FlexFEC to peers, so that publishers which
+	// support it protect their video with FEC packets. FEC packets are
+	// forwarded to subscribers with the video.
+	FEC bool `yaml:"fec"`
+	// HeaderExtensions offers the abs-send-time, transport-cc and mid RTP
+	// header extensions to peers. Extensions which describe the transport of
+	// the publisher are removed from packets forwarded to subscribers, and
+	// abs-send-time is set to the time packets are forwarded.
+	HeaderExtensions bool `yaml:"header_extensions"`
+	// NegotiationDebounce is how long renegotiation is delayed after tracks
+	// are added or removed, so that a burst of changes results in a single
+	// offer. Defaults to 20ms.
+	NegotiationDebounce time.Duration `yaml:"negotiation_debounce"`
+	// DataChannelSignaling lets clients move signaling and other messages
+	// to a data channel once they are connected, and close their websocket.
+	DataChannelSignaling bool `yaml:"datachannel_signaling"`
+	// DisconnectGracePeriod is how long a disconnected peer connection is
+	// given to reconnect before it is closed. Defaults to 10s.
+	DisconnectGracePeriod time.Duration `yaml:"disconnect_grace_period"`
+}
+
+// UDPConfig restricts the local ports of ICE UDP candidates, so that only
+// PortMin to PortMax need to be open in firewalls. Any port is used when
+// both are zero.
+type UDPConfig struct {
+	PortMin int `yaml:"port_min"`
+	PortMax int `yaml:"port_max"`
+}
+
+// IngestConfig configures the UDP ports of raw RTP ingest sources.
+type IngestConfig struct {
+	// ListenIP is the IP ingest ports are bound to. All interfaces are used
+	// when empty.
+	ListenIP string `yaml:"listen_ip"`
+	// UDP restricts the ingest ports. The UDP port range of the SFU is used
+	// when both are zero.
+	UDP UDPConfig `yaml:"udp"`
+}
+
+// PeerConnectionConfig configures the transports of peer connections to the
+// SFU, for interoperability with clients which are strict about them. The
+// SFU always bundles all media on a single transport and multiplexes RTCP
+// with RTP, so these settings reject clients which cannot do the same
+// instead of changing the transports.
+type PeerConnectionConfig struct {
+	// BundlePolicy is balanced or max-bundle. With max-bundle, remote
+	// descriptions which do not bundle all media sections are rejected.
+	// Defaults to balanced.
+	BundlePolicy string `yaml:"bundle_policy"`
+	// RTCPMuxRequired rejects remote descriptions with media sections which
+	// do not multiplex RTCP with RTP.
+	RTCPMuxRequired bool `yaml:"rtcp_mux_required"`
+	// DTLSRole is the DTLS role of the SFU when it answers an offer: auto,
+	// client or server. With auto, the SFU is the DTLS server unless the
+	// offer asks otherwise. Defaults to auto.
+	DTLSRole string `yaml:"dtls_role"`
+}
+
+// DTLSConfig configures the DTLS certificate of peer connections to the
+// SFU, see DTLSCertificates.
+type DTLSConfig struct {
+	// CertFile is the PEM file the certificate and its private key are kept
+	// in. It is generated when it does not exist. Instances sharing the file
+	// present the same certificate. A certificate is generated for each peer
+	// connection when empty.
+	CertFile string `yaml:"cert_file"`
+	// RotationInterval is the time after which the certificate is replaced.
+	// Defaults to 30 days.
+	RotationInterval time.Duration `yaml:"rotation_interval"`
+}
+
+// OpusConfig sets Opus parameters in the SDP sent to peers, asking them to
+// encode audio sent to the server accordingly.
+type OpusConfig struct {
+	// FEC asks peers to add in-band forward error correction, so that audio
+	// of lost packets can be recovered from the following packet.
+	FEC bool `yaml:"fec"`
+	// DTX asks peers to use discontinuous transmission, which stops sending
+	// audio during silence.
+	DTX bool `yaml:"dtx"`
+}
+
+// Parameters returns the Opus format parameters enabled by c.
+func (c OpusConfig) Parameters() map[string]string {
+	params := map[string]string{}
+	if c.FEC {
+		params["useinbandfec"] = "1"
+	}
+	if c.DTX {
+		params["usedtx"] = "1"
+	}
+	return params
+}
+
+// CodecConfig sets the video codecs negotiated with peers, so that all
+// publishers of a room use the same codec. Supported codecs are VP8, VP9,
+// H264 and AV1.
+type CodecConfig struct {
+	// Video lists video codecs in order of preference. Defaults to all
+	// supported codecs.
+	Video []string `yaml:"video"`
+	// Rooms overrides Video for specific rooms.
+	Rooms map[string][]string `yaml:"rooms"`
+}
+
+// RoomVideoCodecs returns the video codecs allowed in room.
+func (c CodecConfig) RoomVideoCodecs(room string) []string {
+	if videoCodecs, ok := c.Rooms[room]; ok {
+		return videoCodecs
+	}
+	return c.Video
+}
+
+type BandwidthAction string
+
+const (
+	// BandwidthActionWarn only logs publishers exceeding the maximum bitrate.
+	BandwidthActionWarn BandwidthAction = "warn"
+	// BandwidthActionThrottle asks publishers to lower their bitrate by
+	// sending REMB feedback with the maximum bitrate.
+	BandwidthActionThrottle BandwidthAction = "throttle"
+	// BandwidthActionDrop drops video packets exceeding the maximum bitrate.
+	// Subscribers see frozen video until the next keyframe.
+	BandwidthActionDrop BandwidthAction = "drop"
+	// BandwidthActionDisconnect disconnects publishers.
+	BandwidthActionDisconnect BandwidthAction = "disconnect"
+)
+
+type BandwidthPolicyConfig struct {
+	// MaxBitrate is the maximum total bitrate of all tracks of a publisher,
+	// in bits per second. Zero disables enforcement.
+	MaxBitrate int `yaml:"max_bitrate"`
+	// Rooms contains maximum bitrates which override MaxBitrate for specific
+	// rooms. Zero disables enforcement in the room.
+	Rooms map[string]int `yaml:"rooms"`
+	// Tolerance is the factor by which publishers may exceed MaxBitrate, to
+	// allow for bursts like keyframes. Defaults to 1.5.
+	Tolerance float64 `yaml:"tolerance"`
+	// GracePeriod is how long publishers may exceed the tolerated bitrate
+	// before Action is taken. Defaults to 5s.
+	GracePeriod time.Duration `yaml:"grace_period"`
+	// Action taken when a publisher exceeds the tolerated bitrate for longer
+	// than GracePeriod. Defaults to warn.
+	Action BandwidthAction `yaml:"action"`
+	// Advertise tells publishers their maximum bitrate, so that they do not
+	// exceed it in the first place: b=AS and b=TIAS lines are set in SDP sent
+	// to peers, and REMB feedback with the maximum bitrate is sent to
+	// publishers. Action is still taken against publishers exceeding it.
+	Advertise bool `yaml:"advertise"`
+}
+
+// RoomMaxBitrate returns the maximum bitrate of publishers in room.
+func (c BandwidthPolicyConfig) RoomMaxBitrate(room string) int {
+	if maxBitrate, ok := c.Rooms[room]; ok {
+		return maxBitrate
+	}
+	return c.MaxBitrate
+}
+
+type RewindConfig struct {
+	// Duration of video buffered since the last keyframe of each track, so
+	// that new subscribers do not have to wait for the next keyframe. Zero
+	// disables buffering.
+	Duration time.Duration `yaml:"duration"`
+	// MaxRoomBytes limits the memory used by buffers of all tracks in a
+	// room. Zero means no limit.
+	MaxRoomBytes int `yaml:"max_room_bytes"`
+}
+
+// ReorderConfig holds video packets which arrive out of order back until the
+// missing packets arrive, so that they are forwarded to subscribers in order.
+type ReorderConfig struct {
+	// Delay is the longest a packet is held back. Zero disables reordering.
+	Delay time.Duration `yaml:"delay"`
+	// Rooms overrides Delay for specific rooms.
+	Rooms map[string]time.Duration `yaml:"rooms"`
+}
+
+// RoomDelay returns how long video packets in room are held back.
+func (c ReorderConfig) RoomDelay(room string) time.Duration {
+	if delay, ok := c.Rooms[room]; ok {
+		return delay
+	}
+	return c.Delay
+}
+
+// Enabled returns true when packets are reordered in any room.
+func (c ReorderConfig) Enabled() bool {
+	if c.Delay > 0 {
+		return true
+	}
+	for _, delay := range c.Rooms {
+		if delay > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// PushToTalkConfig lets only peers holding the floor of a room speak. Peers
+// request and release the floor over signaling.
+type PushToTalkConfig struct {
+	// Rooms in which peers must hold the floor to be heard.
+	Rooms []string `yaml:"rooms"`
+	// Speakers is the number of peers which can hold the floor of a room at
+	// the same time. Defaults to 1.
+	Speakers int `yaml:"speakers"`
+	// MaxHoldTime releases the floor after it was held this long, so that a
+	// stuck client cannot block a room. Zero disables the limit.
+	MaxHoldTime time.Duration `yaml:"max_hold_time"`
+}
+
+// IsPushToTalkRoom returns true when peers in room must hold the floor to be
+// heard.
+func (c PushToTalkConfig) IsPushToTalkRoom(room string) bool {
+	for _, pushToTalkRoom := range c.Rooms {
+		if pushToTalkRoom == room {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxSpeakers returns the number of peers which can hold the floor of a room
+// at the same time.
+func (c PushToTalkConfig) MaxSpeakers() int {
+	if c.Speakers <= 0 {
+		return 1
+	}
+	return c.Speakers
+}
+
+// NetworkQualityConfig sends the network quality of peers to the clients in
+// their room, computed from the loss and jitter observed by the SFU and the
+// round trip time of keepalive pings.
+type NetworkQualityConfig struct {
+	// Interval at which network quality is computed. Disabled when zero.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// CaptureConfig configures packet captures started through the admin API.
+type CaptureConfig struct {
+	// Dir is the directory capture files are written to. Captures are
+	// disabled when empty.
+	Dir string `yaml:"dir"`
+	// MaxDuration limits the duration of a capture. Defaults to one minute.
+	MaxDuration time.Duration `yaml:"max_duration"`
+}
+
+// IsE2EERoom returns true when media in room is encrypted end-to-end.
+func (c NetworkConfigSFU) IsE2EERoom(room string) bool {
+	for _, e2eeRoom := range c.E2EERooms {
+		if e2eeRoom == room {
+			return true
+		}
+	}
+	return false
+}
+
+type AdminConfig struct {
+	// Token is required in the Authorization header of admin API requests.
+	// The admin API is disabled when no token is set.
+	Token string `yaml:"token"`
+	// GRPCListenAddr is the TCP address of the admin gRPC API, for example
+	// 127.0.0.1:3001. The gRPC API is disabled when empty or when no token is
+	// set.
+	GRPCListenAddr string `yaml:"grpc_listen_addr"`
+	// GRPCTLS is the certificate and key the admin gRPC API is served with.
+	// The gRPC API does not start without one, unless GRPCInsecure is set,
+	// because calls carry the token.
+	GRPCTLS TLSConfig `yaml:"grpc_tls"`
+	// GRPCInsecure allows serving the admin gRPC API without TLS, for example
+	// on a loopback address or behind a proxy terminating TLS.
+	GRPCInsecure bool `yaml:"grpc_insecure"`
+	// RequireAPIKeys limits the token to managing API keys, so that all
+	// other requests must use an issued API key.
+	RequireAPIKeys bool `yaml:"require_api_keys"`
+	// Pprof serves the profiles of net/http/pprof below /debug/pprof/ of the
+	// admin API.
+	Pprof bool `yaml:"pprof"`
+}
+
+// APIToken returns the token accepted by the admin APIs other than the API
+// key endpoints, empty when API keys are required.
+func (c AdminConfig) APIToken() string {
+	if c.RequireAPIKeys {
+		return ""
+	}
+	return c.Token
+}
+
+type InactivityPolicy struct {
+	// Timeout after which peers connected to the SFU that have sent no
+	// signaling messages and no media are disconnected. Zero disables the policy.
+	Timeout time.Duration `yaml:"timeout"`
+	// Warning is how long before being disconnected the peer is warned.
+	Warning time.Duration `yaml:"warning"`
+	// ExemptViewOnly exempts peers with the viewer role.
+	ExemptViewOnly bool `yaml:"exempt_view_only"`
+}
+
+type KeepaliveConfig struct {
+	// Interval at which websocket connections are pinged. Zero disables
+	// pinging.
+	Interval time.Duration `yaml:"interval"`
+	// Timeout after which clients which have not responded to a ping and
+	// have sent no media are disconnected. Defaults to Interval.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type InactivityConfig struct {
+	InactivityPolicy `yaml:",inline"`
+	// Rooms contains policies which override the default policy for
+	// specific rooms.
+	Rooms map[string]InactivityPolicy `yaml:"rooms"`
+}
+
+// Policy returns the inactivity policy for room.
+func (c InactivityConfig) Policy(room string) InactivityPolicy {
+	if policy, ok := c.Rooms[room]; ok {
+		return policy
+	}
+	return c.InactivityPolicy
+}
+
+// RoomReusePolicy decides whether clients joining an empty room continue
+// the previous meeting.
+type RoomReusePolicy string
+
+const (
+	// RoomReuseResume continues the previous meeting when a client joins
+	// within the idle timeout.
+	RoomReuseResume RoomReusePolicy = "resume"
+	// RoomReuseFresh closes a meeting as soon as the room is empty, so the
+	// next client always starts a new meeting.
+	RoomReuseFresh RoomReusePolicy = "fresh"
+)
+
+type RoomLifecycleConfig struct {
+	// IdleTimeout is how long a room stays open after the last client left.
+	// Clients joining in this period continue the same meeting. Zero closes
+	// rooms as soon as they are empty.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	// Reuse is the policy for rooms which became empty. Defaults to resume.
+	// IdleTimeout is ignored with the fresh policy.
+	Reuse RoomReusePolicy `yaml:"reuse"`
+	// MaxDuration of a meeting, after which all clients are disconnected.
+	// Zero disables the limit.
+	MaxDuration time.Duration `yaml:"max_duration"`
+	// Warning is how long before reaching MaxDuration clients are warned.
+	Warning time.Duration `yaml:"warning"`
+}
+
+type DigestConfig struct {
+	// Interval at which room digests are broadcast to clients in the room.
+	// Digests are not broadcast when zero, but are still available via the
+	// room state API.
+	Interval time.Duration `yaml:"interval"`
+}
+
+type ChatConfig struct {
+	// History is the number of last chat messages of each room which are
+	// replayed to clients joining the room. Chat messages are not stored
+	// when zero. Only supported by the sfu network type.
+	History int `yaml:"history"`
+}
+
+type RegistryConfig struct {
+	// DisableAdHoc only allows clients to join rooms registered via the admin
+	// API. Rooms which are not registered are created on demand by default.
+	DisableAdHoc bool `yaml:"disable_ad_hoc"`
+}
+
+// RoomFeaturesConfig sets the features of rooms which are not registered
+// with features of their own.
+type RoomFeaturesConfig struct {
+	DisableChat      bool `yaml:"disable_chat"`
+	DisableRecording bool `yaml:"disable_recording"`
+	RequireE2EE      bool `yaml:"require_e2ee"`
+	MaxVideoWidth    int  `yaml:"max_video_width"`
+	MaxVideoHeight   int  `yaml:"max_video_height"`
+}
+
+// RateLimitConfig limits join attempts and signaling messages of clients.
+// Limits are disabled when their interval or burst is zero.
+type RateLimitConfig struct {
+	// MessageInterval is the average time between messages a single
+	// connection can send, and MessageBurst the number of messages it can
+	// send at once. Exceeding messages are dropped.
+	MessageInterval time.Duration `yaml:"message_interval"`
+	MessageBurst    int           `yaml:"message_burst"`
+	// IPMessageInterval and IPMessageBurst limit messages of all connections
+	// from the same IP address.
+	IPMessageInterval time.Duration `yaml:"ip_message_interval"`
+	IPMessageBurst    int           `yaml:"ip_message_burst"`
+	// JoinInterval and JoinBurst limit join attempts from the same IP address.
+	JoinInterval time.Duration `yaml:"join_interval"`
+	JoinBurst    int           `yaml:"join_burst"`
+	// TrustProxy uses the rightmost address of the X-Forwarded-For header
+	// as the IP address of clients. It must only be set when the server is
+	// directly behind a single proxy which appends to the header.
+	TrustProxy bool `yaml:"trust_proxy"`
+	// MaxIPConnections is the maximum number of concurrent signaling
+	// connections from the same IP address. Zero means unlimited.
+	MaxIPConnections int `yaml:"max_ip_connections"`
+	// MaxIPPeerConnections is the maximum number of concurrent peer
+	// connections of the SFU with clients from the same IP address. Zero
+	// means unlimited.
+	MaxIPPeerConnections int `yaml:"max_ip_peer_connections"`
+	// RoomCreationInterval and RoomCreationBurst limit the rooms created by
+	// clients from the same IP address.
+	RoomCreationInterval time.Duration `yaml:"room_creation_interval"`
+	RoomCreationBurst    int           `yaml:"room_creation_burst"`
+	// Backoff is the time clients from an IP address exceeding one of the
+	// quotas above must wait, doubled with each repeated violation up to
+	// MaxBackoff. Defaults to 1s and 5m.
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+}
+
+// ConnectionPolicyConfig restricts the addresses clients can join rooms
+// from. The policy of a room applies in addition to the global policy.
+type ConnectionPolicyConfig struct {
+	IPPolicyConfig `yaml:",inline"`
+	// GeoIPDatabase is a CSV file with the countries of networks, required
+	// for country restrictions, see CSVGeoIP.
+	GeoIPDatabase string `yaml:"geoip_database"`
+	// Rooms contains policies of specific rooms, which also apply to their
+	// breakout rooms.
+	Rooms map[string]IPPolicyConfig `yaml:"rooms"`
+}
+
+type IPPolicyConfig struct {
+	// Allow are the networks in CIDR notation clients must connect from.
+	// All addresses are allowed when empty.
+	Allow []string `yaml:"allow"`
+	// Deny are networks clients cannot connect from, even when allowed.
+	Deny []string `yaml:"deny"`
+	// AllowCountries are the ISO 3166-1 alpha-2 codes of countries clients
+	// must connect from. All countries are allowed when empty.
+	AllowCountries []string `yaml:"allow_countries"`
+	// DenyCountries are countries clients cannot connect from.
+	DenyCountries []string `yaml:"deny_countries"`
+}
+
+type ReactionsConfig struct {
+	// Interval is the average time between reactions, hand raises and hand
+	// lowers a client can send. Events exceeding the rate are dropped.
+	// Defaults to 500ms.
+	Interval time.Duration `yaml:"interval"`
+	// Burst is the number of events a client can send at once. Defaults to
+	// 5.
+	Burst int `yaml:"burst"`
+}
+
+// SpatialConfig sets how positions clients publish for spatial audio are
+// validated and broadcast.
+type SpatialConfig struct {
+	// Interval is the minimum time between broadcasts of the position of a
+	// client. Positions sent in between are coalesced, and only the last one
+	// is broadcast. Defaults to 100ms.
+	Interval time.Duration `yaml:"interval"`
+	// MaxCoordinate is the maximum absolute value of each coordinate.
+	// Positions outside are dropped. Defaults to 10000.
+	MaxCoordinate float64 `yaml:"max_coordinate"`
+}
+
+type CapacityConfig struct {
+	// MaxParticipants is the maximum number of clients connected to this
+	// instance. Zero means unlimited.
+	MaxParticipants int `yaml:"max_participants"`
+	// MaxRoomParticipants is the maximum number of clients in a room, counted
+	// across all instances sharing the store. Zero means unlimited.
+	MaxRoomParticipants int `yaml:"max_room_participants"`
+	// Rooms overrides MaxRoomParticipants for specific rooms.
+	Rooms map[string]int `yaml:"rooms"`
+	// Node limits the load of this instance with the sfu network type.
+	Node NodeCapacityConfig `yaml:"node"`
+}
+
+// RoomLimit returns the maximum number of participants in room.
+func (c CapacityConfig) RoomLimit(room string) int {
+	if max, ok := c.Rooms[room]; ok {
+		return max
+	}
+	return c.MaxRoomParticipants
+}
+
+// NodeCapacityConfig contains the thresholds above which an instance with the
+// sfu network type refuses new publishers. Zero thresholds are not enforced.
+type NodeCapacityConfig struct {
+	// MaxCPU is the fraction of the CPU time of the host that may be in use,
+	// between 0 and 1.
+	MaxCPU float64 `yaml:"max_cpu"`
+	// MaxBitrate is the maximum total bitrate of the published tracks received
+	// by this instance, in bits per second.
+	MaxBitrate int `yaml:"max_bitrate"`
+	// MaxTracks is the maximum number of published tracks.
+	MaxTracks int `yaml:"max_tracks"`
+	// RedirectURL is sent to refused publishers when set, for example the URL
+	// of another deployment.
+	RedirectURL string `yaml:"redirect_url"`
+}
+
+type VaultConfig struct {
+	Addr  string `yaml:"addr"`
+	Token string `yaml:"token"`
+}
+
+type SecretsConfig struct {
+	Vault VaultConfig `yaml:"vault"`
+}
+
+type SIPConfig struct {
+	// ListenAddr is the UDP address SIP requests are received on, for example
+	// 0.0.0.0:5060. The SIP gateway is disabled when empty. Requires the SFU
+	// network type.
+	ListenAddr string `yaml:"listen_addr"`
+	// PublicIP is advertised to phones for RTP. Defaults to the IP of
+	// ListenAddr. RTP is always received on the IP of ListenAddr.
+	PublicIP string `yaml:"public_ip"`
+	// Username and Password are required from phones with digest
+	// authentication when Username is set.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// AllowedNetworks are the CIDRs calls are accepted from. Calls are
+	// accepted from any address when empty. Either a username or allowed
+	// networks must be configured.
+	AllowedNetworks []string `yaml:"allowed_networks"`
+	// MaxCalls limits the number of concurrent calls. Defaults to 20.
+	MaxCalls int `yaml:"max_calls"`
+}
+
+type AuditSyslogConfig struct {
+	// Network is udp or tcp. Defaults to udp.
+	Network string `yaml:"network"`
+	// Addr of the syslog server. Syslog is disabled when empty.
+	Addr string `yaml:"addr"`
+}
+
+type AuditHTTPConfig struct {
+	// URL audit events are posted to as JSON. Disabled when empty.
+	URL string `yaml:"url"`
+	// Token is sent as a bearer token when set.
+	Token string `yaml:"token"`
+}
+
+// AuditConfig configures sinks security events are sent to, in addition to
+// the audit logger.
+type AuditConfig struct {
+	Syslog AuditSyslogConfig `yaml:"syslog"`
+	HTTP   AuditHTTPConfig   `yaml:"http"`
+}
+
+type WebhookConfig struct {
+	// URL lifecycle events are posted to as JSON. Webhooks are disabled when
+	// empty.
+	URL string `yaml:"url"`
+	// Secret is used to sign requests with HMAC-SHA256. Requests are not
+	// signed when empty.
+	Secret string `yaml:"secret"`
+	// KeyID is the ID of an issued API key with the webhook scope, which
+	// signs requests instead of Secret when set.
+	KeyID string `yaml:"key_id"`
+	// MaxAttempts is the number of times delivery of an event is attempted.
+	// Defaults to 5.
+	MaxAttempts int `yaml:"max_attempts"`
+	// RetryInterval is the delay before the first retry. It doubles after
+	// each failed retry. Defaults to 1s.
+	RetryInterval time.Duration `yaml:"retry_interval"`
+}
+
+type LogConfig struct {
+	// Level is the minimum level of log lines: debug, info, warn or error.
+	// Defaults to info.
+	Level string `yaml:"level"`
+	// Format is text or json. Defaults to text.
+	Format string `yaml:"format"`
+}
+
+type TracingConfig struct {
+	// Endpoint is the URL of the OTLP/HTTP traces endpoint of an OpenTelemetry
+	// collector, for example http://collector:4318/v1/traces. Tracing is
+	// disabled when empty.
+	Endpoint string `yaml:"endpoint"`
+	// ServiceName is reported as the service.name resource attribute.
+	// Defaults to peer-calls.
+	ServiceName string `yaml:"service_name"`
+}
+
+// ClusterConfig registers this instance as a node of a cluster sharing the
+// store, so that rooms are assigned to the least loaded node.
+type ClusterConfig struct {
+	// NodeURL is the URL signaling of rooms hosted by this node is routed to,
+	// for example https://node1.example.com. Clustering is disabled when
+	// empty.
+	NodeURL string `yaml:"node_url"`
+	// NodeID identifies this node. Defaults to the host name.
+	NodeID string `yaml:"node_id"`
+	// HeartbeatInterval is the interval at which the load of this node is
+	// published. Defaults to 5s.
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"`
+	// NodeTimeout is the time after the last heartbeat after which a node is
+	// considered failed and its rooms are reassigned. Defaults to three
+	// heartbeat intervals.
+	NodeTimeout time.Duration `yaml:"node_timeout"`
+}
+
+// RuntimeConfig contains settings of the packet forwarding hot path which
+// can also be changed through the admin API while the server is running.
+// Zero values keep the defaults.
+type RuntimeConfig struct {
+	// GOMAXPROCS is the number of CPUs executing Go code simultaneously.
+	// Defaults to the value at startup.
+	GOMAXPROCS int `yaml:"gomaxprocs"`
+	// SendQueueSize is the number of packets queued for each subscriber
+	// before video packets are dropped. Applies to peers connecting
+	// afterwards. Defaults to 256.
+	SendQueueSize int `yaml:"send_queue_size"`
+	// NACKBufferSize is the number of packets of each track kept for
+	// retransmission, a power of two between 16 and 32768. Applies to tracks
+	// published afterwards. Defaults to 512.
+	NACKBufferSize int `yaml:"nack_buffer_size"`
+	// PLIInterval is the interval at which keyframes are requested from
+	// publishers. Defaults to 3s.
+	PLIInterval time.Duration `yaml:"pli_interval"`
+	// PLIMinInterval is the minimum interval between keyframe requests of
+	// camera tracks. Defaults to 500ms.
+	PLIMinInterval time.Duration `yaml:"pli_min_interval"`
+	// PLIMinIntervalScreen is the minimum interval between keyframe requests
+	// of screen shares. Defaults to 100ms.
+	PLIMinIntervalScreen time.Duration `yaml:"pli_min_interval_screen"`
+}
+
+// UpgradeConfig configures zero-downtime upgrades of a single instance, see
+// Handover.
+type UpgradeConfig struct {
+	// SocketPath is the path of a unix socket on which this instance passes
+	// its listening socket to a new instance started with the same path, and
+	// then drains. Handover is disabled when empty.
+	SocketPath string `yaml:"socket_path"`
+	// ReusePort sets SO_REUSEPORT on the listening socket, so that a new
+	// instance can bind to the same port while this one drains after
+	// receiving SIGTERM.
+	ReusePort bool `yaml:"reuse_port"`
+	// DrainTimeout is the maximum time to wait for clients to leave after
+	// the instance stopped accepting connections. Zero waits until all
+	// clients have left.
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+}
+
+type EgressConfig struct {
+	// Endpoint of the egress service. URLs starting with grpcs:// use the
+	// egress gRPC API over TLS, grpc:// without TLS, other URLs the HTTP
+	// API. Egress is disabled when empty.
+	Endpoint string `yaml:"endpoint"`
+	// Secret is sent to the egress service as a bearer token.
+	Secret string `yaml:"secret"`
+	// CA is the PEM file of the CA certificates the certificate of a grpcs://
+	// endpoint is verified with, instead of the system roots.
+	CA string `yaml:"ca"`
+	// Insecure allows sending the secret to a grpc:// endpoint.
+	Insecure bool `yaml:"insecure"`
+	// NodeURL is the URL the egress service uses to join rooms on this
+	// instance, for example https://node1.example.com.
+	NodeURL string `yaml:"node_url"`
+}
+
+// TranscriptionConfig streams the audio of speakers to a transcription
+// service. Transcripts are sent to the room over the data channel.
+type TranscriptionConfig struct {
+	// Endpoint is the grpcs://host:port of the transcription gRPC service,
+	// or grpc://host:port or host:port without TLS. Transcription is
+	// disabled when empty. Requires the SFU network type.
+	Endpoint string `yaml:"endpoint"`
+	// Secret is sent to the transcription service as a bearer token.
+	Secret string `yaml:"secret"`
+	// CA is the PEM file of the CA certificates the certificate of a grpcs://
+	// endpoint is verified with, instead of the system roots.
+	CA string `yaml:"ca"`
+	// Insecure allows sending the secret to an endpoint without TLS.
+	Insecure bool `yaml:"insecure"`
+	// Rooms in which audio is transcribed. Audio of all rooms is transcribed
+	// when empty.
+	Rooms []string `yaml:"rooms"`
+}
+
+// SignalingConfig enables signaling transports besides websockets, for
+// server-side clients like bots and ingest agents.
+type SignalingConfig struct {
+	// GRPCListenAddr is the TCP address of the signaling gRPC service.
+	// Disabled when empty.
+	GRPCListenAddr string `yaml:"grpc_listen_addr"`
+	// GRPCTLS is the certificate and key the signaling gRPC service is
+	// served with. The service does not start without one, unless
+	// GRPCInsecure is set, because hellos carry room passwords and tokens.
+	GRPCTLS TLSConfig `yaml:"grpc_tls"`
+	// GRPCInsecure allows serving the signaling gRPC service without TLS.
+	GRPCInsecure bool `yaml:"grpc_insecure"`
+	// TCPListenAddr is the TCP address of the length-prefixed signaling
+	// protocol. Disabled when empty.
+	TCPListenAddr string `yaml:"tcp_listen_addr"`
+}
+
+// SocketIOConfig configures the signaling endpoint for Socket.IO clients.
+type SocketIOConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// PingInterval is how often engine.io 4 clients are pinged. Defaults to
+	// 25s.
+	PingInterval time.Duration `yaml:"ping_interval"`
+	// PingTimeout is how long clients wait for a ping after the ping
+	// interval, and the server waits for a pong. Defaults to 20s.
+	PingTimeout time.Duration `yaml:"ping_timeout"`
+}
+
+// IsTranscriptionRoom returns true when audio of room is transcribed.
+func (c TranscriptionConfig) IsTranscriptionRoom(room string) bool {
+	if len(c.Rooms) == 0 {
+		return true
+	}
+	for _, transcriptionRoom := range c.Rooms {
+		if transcriptionRoom == room {
+			return true
+		}
+	}
+	return false
+}
+
+type RecordingConfig struct {
+	// Dir is the directory recordings are written to. Recording is disabled
+	// when empty. Requires the SFU network type.
+	Dir string `yaml:"dir"`
+	// PostProcess are the steps run after a recording has stopped: remux,
+	// thumbnail, upload and notify. Steps always run in this order.
+	PostProcess []string `yaml:"post_process"`
+	// FFmpeg is the ffmpeg executable used by the remux and thumbnail steps.
+	// Defaults to ffmpeg.
+	FFmpeg string `yaml:"ffmpeg"`
+	// MaxAttempts is the number of times a post-processing step is
+	// attempted. Defaults to 3.
+	MaxAttempts int `yaml:"max_attempts"`
+	// RetryInterval is the delay before the first retry of a step. It doubles
+	// after each failed retry. Defaults to 5s.
+	RetryInterval time.Duration `yaml:"retry_interval"`
+}
+
+type BlobStoreType string
+
+const (
+	BlobStoreTypeLocal BlobStoreType = "local"
+	BlobStoreTypeS3    BlobStoreType = "s3"
+)
+
+type S3Config struct {
+	// Endpoint is the URL of the S3 compatible service, for example
+	// https://s3.eu-west-1.amazonaws.com or https://storage.googleapis.com.
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	Bucket          string `yaml:"bucket"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+}
+
+type StorageConfig struct {
+	// Type is local or s3. Finished recordings and chat logs are not
+	// uploaded when empty.
+	Type BlobStoreType `yaml:"type"`
+	// Dir is the directory files are copied to by the local store.
+	Dir string   `yaml:"dir"`
+	S3  S3Config `yaml:"s3"`
+	// Prefix is prepended to the keys of all uploaded files.
+	Prefix string `yaml:"prefix"`
+	// MaxAttempts is the number of times an upload of a file is attempted.
+	// Defaults to 5.
+	MaxAttempts int `yaml:"max_attempts"`
+	// RetryInterval is the delay before the first retry. It doubles after
+	// each failed retry. Defaults to 5s.
+	RetryInterval time.Duration `yaml:"retry_interval"`
+	// DeleteAfterUpload removes the directory of a recording once all its
+	// files have been uploaded.
+	DeleteAfterUpload bool `yaml:"delete_after_upload"`
+}
+
+type ClientConfig struct {
+	// Features contains feature flags for clients
+	Features map[string]bool `yaml:"features"`
+	// Branding contains values such as the application name, logo URL and
+	// colors
+	Branding map[string]string `yaml:"branding"`
+	// Limits contains numeric limits such as the maximum video resolution
+	Limits map[string]int `yaml:"limits"`
+}
+
+// Merge returns a copy of c with values set in override replacing values of
+// c.
+func (c ClientConfig) Merge(override ClientConfig) ClientConfig {
+	merged := ClientConfig{
+		Features: map[string]bool{},
+		Branding: map[string]string{},
+		Limits:   map[string]int{},
+	}
+	for _, config := range []ClientConfig{c, override} {
+		for key, value := range config.Features {
+			merged.Features[key] = value
+		}
+		for key, value := range config.Branding {
+			merged.Branding[key] = value
+		}
+		for key, value := range config.Limits {
+			merged.Limits[key] = value
+		}
+	}
+	return merged
+}
+
+type HostConfig struct {
+	// Name is the host name clients connect to, for example
+	// meet.example.com. Names starting with "*." match all subdomains.
+	Name string `yaml:"name"`
+	// TLS is the certificate served to clients requesting Name via SNI. The
+	// default certificate is served when empty.
+	TLS TLSConfig `yaml:"tls"`
+	// RoomTemplate is the name of rooms started from the index page without
+	// a name. The first {id} is replaced with a random ID, for example
+	// acme-{id}. Defaults to a random ID.
+	RoomTemplate string `yaml:"room_template"`
+	// Client contains features, branding and limits which override the
+	// client config for this host.
+	Client ClientConfig `yaml:"client"`
+}
+
+// TenantConfig configures a tenant, see Tenant.
+type TenantConfig struct {
+	// Name is the namespace of the rooms of the tenant. It must not contain
+	// ":" or "/".
+	Name string `yaml:"name"`
+	// APIKey gives access to the rooms of the tenant via the admin API. The
+	// tenant cannot use the admin API when empty.
+	APIKey string `yaml:"api_key"`
+	// JoinSecret signs the join tokens of clients of the tenant.
+	JoinSecret string `yaml:"join_secret"`
+	// MaxRooms is the maximum number of rooms of the tenant with clients on
+	// this instance. Zero means unlimited.
+	MaxRooms int `yaml:"max_rooms"`
+	// MaxParticipants is the maximum number of clients in all rooms of the
+	// tenant on this instance. Zero means unlimited.
+	MaxParticipants int `yaml:"max_participants"`
+	// MaxBitrate overrides network.sfu.bandwidth.max_bitrate for rooms of the
+	// tenant, unless a room has a maximum bitrate of its own.
+	MaxBitrate int `yaml:"max_bitrate"`
+}
+
+// EventLogConfig configures the recording of room events, see EventLog.
+type EventLogConfig struct {
+	// MaxRoomEvents is the number of last events of each room which are
+	// kept in the configured store. Events are not recorded when zero.
+	MaxRoomEvents int `yaml:"max_room_events"`
+}
+
+// MeteringConfig configures usage metering, see Metering.
+type MeteringConfig struct {
+	// Interval at which the usage of rooms is aggregated and reported.
+	// Metering is disabled when zero.
+	Interval time.Duration `yaml:"interval"`
+	// Webhook the usage of each room is posted to every interval. Usage is
+	// not exported when its URL is empty.
+	Webhook WebhookConfig `yaml:"webhook"`
 }
 
 type Config struct {
-	BaseURL    string        `yaml:"base_url"`
-	BindHost   string        `yaml:"bind_host"`
-	BindPort   int           `yaml:"bind_port"`
-	ICEServers []ICEServer   `yaml:"ice_servers"`
-	TLS        TLSConfig     `yaml:"tls"`
-	Store      StoreConfig   `yaml:"store"`
-	Network    NetworkConfig `yaml:"network"`
+	BaseURL    string              `yaml:"base_url"`
+	BindHost   string              `yaml:"bind_host"`
+	BindPort   int                 `yaml:"bind_port"`
+	ICEServers []ICEServer         `yaml:"ice_servers"`
+	TLS        TLSConfig           `yaml:"tls"`
+	Hosts      []HostConfig        `yaml:"hosts"`
+	Tenants    []TenantConfig      `yaml:"tenants"`
+	Store      StoreConfig         `yaml:"store"`
+	Network    NetworkConfig       `yaml:"network"`
+	Admin      AdminConfig         `yaml:"admin"`
+	Inactivity InactivityConfig    `yaml:"inactivity"`
+	Keepalive  KeepaliveConfig     `yaml:"keepalive"`
+	Lifecycle  RoomLifecycleConfig `yaml:"lifecycle"`
+	Capacity   CapacityConfig      `yaml:"capacity"`
+	Cluster    ClusterConfig       `yaml:"cluster"`
+	Runtime    RuntimeConfig       `yaml:"runtime"`
+	Upgrade    UpgradeConfig       `yaml:"upgrade"`
+	Secrets    SecretsConfig       `yaml:"secrets"`
+	Client     ClientConfig        `yaml:"client"`
+	SIP        SIPConfig           `yaml:"sip"`
+	Recording  RecordingConfig     `yaml:"recording"`
+	Storage    StorageConfig       `yaml:"storage"`
+	Audit      AuditConfig         `yaml:"audit"`
+	Webhook    WebhookConfig       `yaml:"webhook"`
+	Tracing    TracingConfig       `yaml:"tracing"`
+	Log        LogConfig           `yaml:"log"`
+	Digest     DigestConfig        `yaml:"digest"`
+	Chat       ChatConfig          `yaml:"chat"`
+	EventLog   EventLogConfig      `yaml:"event_log"`
+	Metering   MeteringConfig      `yaml:"metering"`
+	Reactions  ReactionsConfig     `yaml:"reactions"`
+	Spatial    SpatialConfig       `yaml:"spatial"`
+	RateLimit  RateLimitConfig     `yaml:"rate_limit"`
+	// ConnectionPolicy restricts the addresses clients join from.
+	ConnectionPolicy ConnectionPolicyConfig `yaml:"connection_policy"`
+	Registry         RegistryConfig         `yaml:"registry"`
+	// RoomFeatures are the default features of rooms.
+	RoomFeatures RoomFeaturesConfig `yaml:"room_features"`
+	Egress       EgressConfig       `yaml:"egress"`
+	// Transcription of speech, see TranscriptionConfig.
+	Transcription TranscriptionConfig `yaml:"transcription"`
+	Signaling     SignalingConfig     `yaml:"signaling"`
+	SocketIO      SocketIOConfig      `yaml:"socket_io"`
 }
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var ErrJoinForbidden = &JoinError{Code: "forbidden", Message: "Joining from this address is not allowed"}
+
+var ErrInvalidConnectionPolicy = errors.New("invalid connection policy")
+
+// ConnectionPolicy restricts the addresses clients can join rooms and send
+// media from. A nil ConnectionPolicy allows all addresses.
+type ConnectionPolicy struct {
+	global ipPolicy
+	// key is room
+	rooms map[string]ipPolicy
+	geoIP GeoIP
+}
+
+type ipPolicy struct {
+	allow          []*net.IPNet
+	deny           []*net.IPNet
+	allowCountries map[string]struct{}
+	denyCountries  map[string]struct{}
+}
+
+// NewConnectionPolicy parses config and reads its GeoIP database. Returns nil
+// when config has no restrictions.
+func NewConnectionPolicy(config ConnectionPolicyConfig) (*ConnectionPolicy, error) {
+	policy := &ConnectionPolicy{
+		rooms: make(map[string]ipPolicy, len(config.Rooms)),
+	}
+
+	var err error
+	if policy.global, err = newIPPolicy(config.IPPolicyConfig); err != nil {
+		return nil, err
+	}
+	restricted := !policy.global.empty()
+	countries := policy.global.hasCountries()
+
+	for room, roomConfig := range config.Rooms {
+		roomPolicy, err := newIPPolicy(roomConfig)
+		if err != nil {
+			return nil, fmt.Errorf("room: %s: %w", room, err)
+		}
+		policy.rooms[room] = roomPolicy
+		restricted = restricted || !roomPolicy.empty()
+		countries = countries || roomPolicy.hasCountries()
+	}
+
+	if !restricted {
+		return nil, nil
+	}
+
+	if config.GeoIPDatabase != "" {
+		if policy.geoIP, err = OpenGeoIPCSV(config.GeoIPDatabase); err != nil {
+			return nil, fmt.Errorf("Error reading geoip database: %w", err)
+		}
+	} else if countries {
+		return nil, fmt.Errorf("%w: a geoip database is required to restrict countries", ErrInvalidConnectionPolicy)
+	}
+
+	return policy, nil
+}
+
+func newIPPolicy(config IPPolicyConfig) (p ipPolicy, err error) {
+	if p.allow, err = parseNetworks(config.Allow); err != nil {
+		return p, err
+	}
+	if p.deny, err = parseNetworks(config.Deny); err != nil {
+		return p, err
+	}
+	p.allowCountries = countrySet(config.AllowCountries)
+	p.denyCountries = countrySet(config.DenyCountries)
+	return p, nil
+}
+
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			// a single address
+			if ip := net.ParseIP(cidr); ip != nil {
+				networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(8*len(ip), 8*len(ip))})
+				continue
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%w network: %q", ErrInvalidConnectionPolicy, cidr)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func countrySet(countries []string) map[string]struct{} {
+	if len(countries) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(countries))
+	for _, country := range countries {
+		set[strings.ToUpper(strings.TrimSpace(country))] = struct{}{}
+	}
+	return set
+}
+
+func (p ipPolicy) empty() bool {
+	return len(p.allow) == 0 && len(p.deny) == 0 && !p.hasCountries()
+}
+
+func (p ipPolicy) hasCountries() bool {
+	return len(p.allowCountries) > 0 || len(p.denyCountries) > 0
+}
+
+func (p ipPolicy) allows(ip net.IP, geoIP GeoIP) bool {
+	if containsIP(p.deny, ip) {
+		return false
+	}
+	if len(p.allow) > 0 && !containsIP(p.allow, ip) {
+		return false
+	}
+	if !p.hasCountries() {
+		return true
+	}
+
+	country, ok := "", false
+	if ip != nil {
+		country, ok = geoIP.Country(ip)
+	}
+	if _, denied := p.denyCountries[country]; ok && denied {
+		return false
+	}
+	if _, allowed := p.allowCountries[country]; len(p.allowCountries) > 0 && !(ok && allowed) {
+		return false
+	}
+	return true
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow returns ErrJoinForbidden when a client connecting from ip cannot
+// join room. Invalid addresses are only allowed when nothing is allowed
+// explicitly.
+func (p *ConnectionPolicy) Allow(room string, ip string) *JoinError {
+	if !p.AllowIP(room, net.ParseIP(ip)) {
+		return ErrJoinForbidden
+	}
+	return nil
+}
+
+// AllowIP returns true when ip is allowed by the global policy and the
+// policy of room.
+func (p *ConnectionPolicy) AllowIP(room string, ip net.IP) bool {
+	if p == nil {
+		return true
+	}
+	if !p.global.allows(ip, p.geoIP) {
+		return false
+	}
+	if roomPolicy, ok := p.rooms[mainRoom(room)]; ok && !roomPolicy.allows(ip, p.geoIP) {
+		return false
+	}
+	return true
+}
+
+// AllowCandidate returns false when the address of the remote ICE candidate
+// is not allowed to send media to room. Relay candidates, which have the
+// address of the TURN server, and candidates with mDNS host names are not
+// checked.
+func (p *ConnectionPolicy) AllowCandidate(room string, candidate string) bool {
+	if p == nil {
+		return true
+	}
+
+	// candidate:<foundation> <component> <protocol> <priority> <address> <port> typ <type> ...
+	fields := strings.Fields(strings.TrimPrefix(candidate, "a="))
+	if len(fields) < 8 || fields[7] == "relay" {
+		return true
+	}
+	ip := net.ParseIP(fields[4])
+	if ip == nil {
+		return true
+	}
+	return p.AllowIP(room, ip)
+}
+
+// FilterSDPCandidates removes the candidate lines of sessionDescription for
+// which allow returns false.
+func FilterSDPCandidates(sessionDescription string, allow func(candidate string) bool) string {
+	lines := strings.Split(sessionDescription, "\n")
+	result := lines[:0]
+	for _, line := range lines {
+		if strings.HasPrefix(line, "a=candidate:") && !allow(line) {
+			continue
+		}
+		result = append(result, line)
+	}
+	return strings.Join(result, "\n")
+}
+
+// SetConnectionPolicy restricts the addresses clients can join rooms from.
+// Changed policies only apply to clients joining afterwards.
+func (wss *WSS) SetConnectionPolicy(policy *ConnectionPolicy) {
+	wss.connectionsMu.Lock()
+	defer wss.connectionsMu.Unlock()
+	wss.policy = policy
+}
+
+// ConnectionPolicy returns the policy set with SetConnectionPolicy.
+func (wss *WSS) ConnectionPolicy() *ConnectionPolicy {
+	wss.connectionsMu.Lock()
+	defer wss.connectionsMu.Unlock()
+	return wss.policy
+}
@@ -0,0 +1,164 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+const testGeoIPCSV = `network,country
+# comment
+2.16.0.0/13,FR
+1.0.0.0,1.0.0.255,AU
+2001:db8::/32,de
+`
+
+func TestReadGeoIPCSV(t *testing.T) {
+	geoIP, err := server.ReadGeoIPCSV(strings.NewReader(testGeoIPCSV))
+	require.NoError(t, err)
+
+	for ip, expected := range map[string]string{
+		"2.16.0.0":        "FR",
+		"2.23.255.255":    "FR",
+		"2.24.0.0":        "",
+		"1.0.0.128":       "AU",
+		"0.255.255.255":   "",
+		"2001:db8::1":     "DE",
+		"2001:db9::1":     "",
+		"255.255.255.255": "",
+	} {
+		country, ok := geoIP.Country(net.ParseIP(ip))
+		assert.Equal(t, expected, country, ip)
+		assert.Equal(t, expected != "", ok, ip)
+	}
+
+	_, err = server.ReadGeoIPCSV(strings.NewReader("2.16.0.0/13,FR\ninvalid,FR\n"))
+	assert.True(t, errors.Is(err, server.ErrInvalidGeoIP))
+}
+
+func newTestConnectionPolicy(t *testing.T, config server.ConnectionPolicyConfig) *server.ConnectionPolicy {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "connpolicy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config.GeoIPDatabase = filepath.Join(dir, "geoip.csv")
+	require.NoError(t, ioutil.WriteFile(config.GeoIPDatabase, []byte(testGeoIPCSV), 0644))
+
+	policy, err := server.NewConnectionPolicy(config)
+	require.NoError(t, err)
+	return policy
+}
+
+func TestNewConnectionPolicy(t *testing.T) {
+	policy, err := server.NewConnectionPolicy(server.ConnectionPolicyConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, policy)
+	assert.Nil(t, policy.Allow("room", "10.0.0.1"))
+
+	_, err = server.NewConnectionPolicy(server.ConnectionPolicyConfig{
+		IPPolicyConfig: server.IPPolicyConfig{Allow: []string{"10.0.0.0/33"}},
+	})
+	assert.True(t, errors.Is(err, server.ErrInvalidConnectionPolicy))
+
+	_, err = server.NewConnectionPolicy(server.ConnectionPolicyConfig{
+		Rooms: map[string]server.IPPolicyConfig{
+			"room": {AllowCountries: []string{"FR"}},
+		},
+	})
+	assert.True(t, errors.Is(err, server.ErrInvalidConnectionPolicy), "geoip database is required")
+}
+
+func TestConnectionPolicy_Allow(t *testing.T) {
+	policy := newTestConnectionPolicy(t, server.ConnectionPolicyConfig{
+		IPPolicyConfig: server.IPPolicyConfig{
+			Deny:          []string{"2.16.0.1"},
+			DenyCountries: []string{"AU"},
+		},
+		Rooms: map[string]server.IPPolicyConfig{
+			"board": {
+				Allow:          []string{"10.0.0.0/8"},
+				AllowCountries: []string{"fr"},
+			},
+		},
+	})
+
+	assert.Nil(t, policy.Allow("standup", "2.16.0.2"))
+	assert.Nil(t, policy.Allow("standup", "10.0.0.1"), "unknown country")
+	assert.Equal(t, server.ErrJoinForbidden, policy.Allow("standup", "2.16.0.1"))
+	assert.Equal(t, server.ErrJoinForbidden, policy.Allow("standup", "1.0.0.1"))
+
+	assert.Equal(t, server.ErrJoinForbidden, policy.Allow("board", "2.16.0.2"), "not in allowed network")
+	assert.Equal(t, server.ErrJoinForbidden, policy.Allow("board", "10.0.0.1"), "not in allowed country")
+	assert.Equal(t, server.ErrJoinForbidden, policy.Allow("board/group-1", "10.0.0.1"), "breakout room")
+	assert.Equal(t, server.ErrJoinForbidden, policy.Allow("board", "invalid"))
+	assert.Nil(t, policy.Allow("standup", "invalid"))
+}
+
+func TestConnectionPolicy_AllowCandidate(t *testing.T) {
+	policy := newTestConnectionPolicy(t, server.ConnectionPolicyConfig{
+		IPPolicyConfig: server.IPPolicyConfig{
+			Deny: []string{"203.0.113.0/24"},
+		},
+	})
+
+	assert.True(t, policy.AllowCandidate("room", "candidate:1 1 udp 2130706431 192.168.1.2 50000 typ host"))
+	assert.False(t, policy.AllowCandidate("room", "candidate:2 1 udp 1694498815 203.0.113.5 50000 typ srflx raddr 192.168.1.2 rport 50000"))
+	assert.True(t, policy.AllowCandidate("room", "candidate:3 1 udp 16777215 203.0.113.6 3478 typ relay raddr 198.51.100.1 rport 50000"))
+	assert.True(t, policy.AllowCandidate("room", "candidate:4 1 udp 2130706431 4b1b0d3e.local 50000 typ host"))
+
+	sdp := strings.Join([]string{
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"a=candidate:1 1 udp 2130706431 192.168.1.2 50000 typ host",
+		"a=candidate:2 1 udp 1694498815 203.0.113.5 50000 typ srflx raddr 192.168.1.2 rport 50000",
+		"a=mid:0",
+		"",
+	}, "\r\n")
+	filtered := server.FilterSDPCandidates(sdp, func(candidate string) bool {
+		return policy.AllowCandidate("room", candidate)
+	})
+	assert.Equal(t, strings.Join([]string{
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"a=candidate:1 1 udp 2130706431 192.168.1.2 50000 typ host",
+		"a=mid:0",
+		"",
+	}, "\r\n"), filtered)
+}
+
+func TestConnectionPolicy_join(t *testing.T) {
+	s, wsURL := setupAdminServer(t)
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	policy, err := server.NewConnectionPolicy(server.ConnectionPolicyConfig{
+		Rooms: map[string]server.IPPolicyConfig{
+			roomName: {Deny: []string{"127.0.0.0/8", "::1"}},
+		},
+	})
+	require.NoError(t, err)
+	mux := s.Config.Handler.(*server.Mux)
+	mux.WSS.SetConnectionPolicy(policy)
+
+	ws := mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	message, _ := readJoinResult(t, ctx, ws)
+	assert.Equal(t, server.MessageTypeJoinError, message.Type)
+	assert.Equal(t, "forbidden", message.Payload.(map[string]interface{})["code"])
+
+	mux.WSS.SetConnectionPolicy(nil)
+	ws = mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	message, _ = readJoinResult(t, ctx, ws)
+	assert.Equal(t, server.MessageTypeJoinAck, message.Type)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/pion/webrtc/v2"
+	"nhooyr.io/websocket"
+)
+
+// SignalingDataChannelName is the label of the data channel clients can move
+// their websocket connection to once the peer connection is established.
+const SignalingDataChannelName = "signaling"
+
+// SignalingDataChannelID is the stream ID of the signaling data channel. The
+// channel is negotiated out of band by both peers using this ID, so that the
+// data channel simple-peer expects is the only one announced in-band.
+const SignalingDataChannelID uint16 = 100
+
+var errDataChannelClosed = errors.New("data channel closed")
+
+// DataChannelConn reads and writes websocket messages over a data channel.
+type DataChannelConn struct {
+	dataChannel *webrtc.DataChannel
+	messages    chan []byte
+	closeOnce   sync.Once
+	closed      chan struct{}
+}
+
+var _ WSReadWriter = &DataChannelConn{}
+
+// NewSignalingDataChannel creates the negotiated signaling data channel on
+// peerConnection.
+func NewSignalingDataChannel(peerConnection *webrtc.PeerConnection) (*DataChannelConn, error) {
+	negotiated := true
+	id := SignalingDataChannelID
+	dataChannel, err := peerConnection.CreateDataChannel(SignalingDataChannelName, &webrtc.DataChannelInit{
+		Negotiated: &negotiated,
+		ID:         &id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewDataChannelConn(dataChannel), nil
+}
+
+func NewDataChannelConn(dataChannel *webrtc.DataChannel) *DataChannelConn {
+	c := &DataChannelConn{
+		dataChannel: dataChannel,
+		// messages received before the websocket has been handed over are
+		// buffered, the client stops sending them until it is acknowledged
+		messages: make(chan []byte, 16),
+		closed:   make(chan struct{}),
+	}
+	dataChannel.OnMessage(c.handleMessage)
+	dataChannel.OnClose(c.Close)
+	return c
+}
+
+func (c *DataChannelConn) handleMessage(msg webrtc.DataChannelMessage) {
+	select {
+	case c.messages <- msg.Data:
+	case <-c.closed:
+	}
+}
+
+// Ready returns true when messages can be sent.
+func (c *DataChannelConn) Ready() bool {
+	return c.dataChannel.ReadyState() == webrtc.DataChannelStateOpen
+}
+
+func (c *DataChannelConn) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	select {
+	case data := <-c.messages:
+		return websocket.MessageText, data, nil
+	case <-c.closed:
+		return 0, nil, errDataChannelClosed
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	}
+}
+
+func (c *DataChannelConn) Write(ctx context.Context, typ websocket.MessageType, msg []byte) error {
+	if typ == websocket.MessageText {
+		return c.dataChannel.SendText(string(msg))
+	}
+	return c.dataChannel.Send(msg)
+}
+
+// Close makes pending and future reads fail. It must be called when the
+// peer connection is closed, since it does not always close its data
+// channels.
+func (c *DataChannelConn) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+}
@@ -0,0 +1,131 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+// chanConn reads messages from in until it is closed, and writes messages
+// to out.
+type chanConn struct {
+	in  chan []byte
+	out chan []byte
+}
+
+func newChanConn() *chanConn {
+	return &chanConn{
+		in:  make(chan []byte, 16),
+		out: make(chan []byte, 16),
+	}
+}
+
+func (c *chanConn) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	select {
+	case msg, ok := <-c.in:
+		if !ok {
+			return 0, nil, errors.New("closed")
+		}
+		return websocket.MessageText, msg, nil
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	}
+}
+
+func (c *chanConn) Write(ctx context.Context, typ websocket.MessageType, msg []byte) error {
+	c.out <- msg
+	return nil
+}
+
+func mustSerialize(t *testing.T, msg server.Message) []byte {
+	t.Helper()
+	data, err := serializer.Serialize(msg)
+	require.NoError(t, err)
+	return data
+}
+
+func TestClient_Handover(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ws := newChanConn()
+	client := server.NewClientWithID(ws, "a")
+	messages := client.Subscribe(ctx)
+
+	ws.in <- mustSerialize(t, server.NewMessage("ping", room, nil))
+	assert.Equal(t, "ping", (<-messages).Type)
+
+	dc := newChanConn()
+	client.Handover(dc)
+	require.NoError(t, client.Write(server.NewMessage("handover", room, nil)))
+	assert.Len(t, ws.out, 0, "message written to websocket after handover")
+	assert.Len(t, dc.out, 1)
+
+	// messages sent before the websocket is closed are still received
+	dc.in <- mustSerialize(t, server.NewMessage("signal", room, nil))
+	ws.in <- mustSerialize(t, server.NewMessage("ready", room, nil))
+	close(ws.in)
+	assert.Equal(t, "ready", (<-messages).Type)
+	assert.Equal(t, "signal", (<-messages).Type)
+
+	close(dc.in)
+	_, ok := <-messages
+	assert.False(t, ok, "subscription should end when the data channel is closed")
+	assert.Error(t, client.Err())
+}
+
+func TestDataChannelConn(t *testing.T) {
+	offerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	defer offerer.Close()
+	answerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	defer answerer.Close()
+
+	offererConn, err := server.NewSignalingDataChannel(offerer)
+	require.NoError(t, err)
+	answererConn, err := server.NewSignalingDataChannel(answerer)
+	require.NoError(t, err)
+
+	offer, err := offerer.CreateOffer(nil)
+	require.NoError(t, err)
+	require.NoError(t, offerer.SetLocalDescription(offer))
+	require.NoError(t, answerer.SetRemoteDescription(offer))
+	answer, err := answerer.CreateAnswer(nil)
+	require.NoError(t, err)
+	require.NoError(t, answerer.SetLocalDescription(answer))
+	require.NoError(t, offerer.SetRemoteDescription(answer))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for !offererConn.Ready() || !answererConn.Ready() {
+		select {
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for data channels to open")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	require.NoError(t, offererConn.Write(ctx, websocket.MessageText, []byte("hello")))
+	typ, data, err := answererConn.Read(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, websocket.MessageText, typ)
+	assert.Equal(t, "hello", string(data))
+
+	require.NoError(t, answererConn.Write(ctx, websocket.MessageText, []byte("world")))
+	_, data, err = offererConn.Read(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+
+	offererConn.Close()
+	_, _, err = offererConn.Read(ctx)
+	assert.Error(t, err)
+}
@@ -28,7 +28,7 @@ func newDataTransceiver(
 	peerConnection *webrtc.PeerConnection,
 ) *DataTransceiver {
 	d := &DataTransceiver{
-		log:            loggerFactory.GetLogger("datatransceiver"),
+		log:            loggerFactory.GetLogger("datatransceiver").WithCtx(LogCtx{"clientID": clientID}),
 		clientID:       clientID,
 		peerConnection: peerConnection,
 		messagesChan:   make(chan webrtc.DataChannelMessage),
@@ -42,7 +42,7 @@ func newDataTransceiver(
 }
 
 func (d *DataTransceiver) handleDataChannel(dataChannel *webrtc.DataChannel) {
-	d.log.Printf("[%s] DataTransceiver.handleDataChannel: %s", d.clientID, dataChannel.Label())
+	d.log.Debugf("DataTransceiver.handleDataChannel: %s", dataChannel.Label())
 	if dataChannel.Label() == DataChannelName {
 		// only want a single data channel for messages and sending files
 		d.mu.Lock()
@@ -57,7 +57,7 @@ func (d *DataTransceiver) MessagesChannel() <-chan webrtc.DataChannelMessage {
 }
 
 func (d *DataTransceiver) Close() {
-	d.log.Printf("[%s] DataTransceiver.Close", d.clientID)
+	d.log.Debugf("DataTransceiver.Close")
 	d.dataChanOnce.Do(func() {
 		close(d.closeChannel)
 
@@ -70,7 +70,7 @@ func (d *DataTransceiver) Close() {
 }
 
 func (d *DataTransceiver) handleMessage(msg webrtc.DataChannelMessage) {
-	d.log.Printf("[%s] DataTransceiver.handleMessage", d.clientID)
+	d.log.Debugf("DataTransceiver.handleMessage")
 	d.mu.RLock()
 	defer d.mu.RUnlock()
 
@@ -86,7 +86,7 @@ func (d *DataTransceiver) handleMessage(msg webrtc.DataChannelMessage) {
 }
 
 func (d *DataTransceiver) SendText(message string) (err error) {
-	d.log.Printf("[%s] DataTransceiver.SendText", d.clientID)
+	d.log.Debugf("DataTransceiver.SendText")
 	d.mu.RLock()
 	if d.dataChannel != nil {
 		err = d.dataChannel.SendText(message)
@@ -98,7 +98,7 @@ func (d *DataTransceiver) SendText(message string) (err error) {
 }
 
 func (d *DataTransceiver) Send(message []byte) (err error) {
-	d.log.Printf("[%s] DataTransceiver.Send", d.clientID)
+	d.log.Debugf("DataTransceiver.Send")
 	d.mu.RLock()
 	if d.dataChannel != nil {
 		err = d.dataChannel.Send(message)
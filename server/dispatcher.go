@@ -0,0 +1,133 @@
+package server
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// ShardStats contains the state of a single dispatcher shard.
+type ShardStats struct {
+	Shard         int `json:"shard"`
+	QueueDepth    int `json:"queueDepth"`
+	MaxQueueDepth int `json:"maxQueueDepth"`
+	// Processed is the number of jobs taken off the queue.
+	Processed uint64 `json:"processed"`
+}
+
+// ShardedDispatcher runs jobs on a fixed number of worker goroutines. Jobs
+// with the same key always run on the same worker, in the order they were
+// dispatched, so a slow job only delays jobs of keys sharing its shard.
+type ShardedDispatcher struct {
+	shards []*dispatcherShard
+
+	closeChannel chan struct{}
+	closeOnce    sync.Once
+}
+
+type dispatcherShard struct {
+	notify chan struct{}
+
+	mu            sync.Mutex
+	jobs          []func()
+	maxQueueDepth int
+	processed     uint64
+}
+
+func NewShardedDispatcher(shards int) *ShardedDispatcher {
+	if shards < 1 {
+		shards = 1
+	}
+
+	d := &ShardedDispatcher{
+		shards:       make([]*dispatcherShard, shards),
+		closeChannel: make(chan struct{}),
+	}
+
+	for i := range d.shards {
+		d.shards[i] = &dispatcherShard{
+			notify: make(chan struct{}, 1),
+		}
+		go d.work(d.shards[i])
+	}
+
+	return d
+}
+
+// Shard returns the index of the shard jobs with key are dispatched to.
+func (d *ShardedDispatcher) Shard(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(d.shards)))
+}
+
+// Dispatch queues job to run on the shard of key. It never blocks, the
+// queues are unbounded.
+func (d *ShardedDispatcher) Dispatch(key string, job func()) {
+	shard := d.shards[d.Shard(key)]
+
+	shard.mu.Lock()
+	shard.jobs = append(shard.jobs, job)
+	if depth := len(shard.jobs); depth > shard.maxQueueDepth {
+		shard.maxQueueDepth = depth
+	}
+	shard.mu.Unlock()
+
+	select {
+	case shard.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Stats returns a snapshot of the state of all shards.
+func (d *ShardedDispatcher) Stats() []ShardStats {
+	stats := make([]ShardStats, len(d.shards))
+	for i, shard := range d.shards {
+		shard.mu.Lock()
+		stats[i] = ShardStats{
+			Shard:         i,
+			QueueDepth:    len(shard.jobs),
+			MaxQueueDepth: shard.maxQueueDepth,
+			Processed:     shard.processed,
+		}
+		shard.mu.Unlock()
+	}
+	return stats
+}
+
+// Close stops all workers. Queued jobs are not run.
+func (d *ShardedDispatcher) Close() {
+	d.closeOnce.Do(func() {
+		close(d.closeChannel)
+	})
+}
+
+func (d *ShardedDispatcher) work(shard *dispatcherShard) {
+	for {
+		select {
+		case <-shard.notify:
+		case <-d.closeChannel:
+			return
+		}
+
+		for job := shard.next(); job != nil; job = shard.next() {
+			job()
+		}
+	}
+}
+
+// next removes the first job from the queue. Returns nil when the queue is
+// empty.
+func (s *dispatcherShard) next() func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.jobs) == 0 {
+		return nil
+	}
+
+	job := s.jobs[0]
+	s.jobs[0] = nil
+	s.jobs = s.jobs[1:]
+	s.processed++
+	return job
+}
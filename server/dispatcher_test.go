@@ -0,0 +1,65 @@
+package server_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedDispatcher_order(t *testing.T) {
+	d := server.NewShardedDispatcher(4)
+	defer d.Close()
+
+	results := make(chan int, 100)
+	for i := 0; i < 100; i++ {
+		i := i
+		d.Dispatch("a", func() {
+			results <- i
+		})
+	}
+
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, i, <-results)
+	}
+}
+
+func TestShardedDispatcher_slowShard(t *testing.T) {
+	d := server.NewShardedDispatcher(2)
+	defer d.Close()
+
+	otherKey := ""
+	for i := 0; otherKey == ""; i++ {
+		if key := "peer" + strconv.Itoa(i); d.Shard(key) != d.Shard("slow") {
+			otherKey = key
+		}
+	}
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+	d.Dispatch("slow", func() {
+		<-unblock
+	})
+	d.Dispatch("slow", func() {})
+
+	done := make(chan struct{})
+	d.Dispatch(otherKey, func() {
+		close(done)
+	})
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatal("job was blocked by a slow job in another shard")
+	}
+
+	require.Eventually(t, func() bool {
+		return d.Stats()[d.Shard("slow")].QueueDepth == 1
+	}, timeout, 10*time.Millisecond)
+	stats := d.Stats()[d.Shard("slow")]
+	assert.GreaterOrEqual(t, stats.MaxQueueDepth, 1)
+	assert.Equal(t, uint64(1), stats.Processed)
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v2"
+)
+
+const (
+	defaultDTLSRotationInterval = 30 * 24 * time.Hour
+	// dtlsCheckInterval is the interval at which the certificate file is
+	// checked for certificates rotated by other instances.
+	dtlsCheckInterval = time.Minute
+)
+
+// DTLSCertificateInfo describes the DTLS certificate presented to peers.
+type DTLSCertificateInfo struct {
+	Fingerprints []webrtc.DTLSFingerprint `json:"fingerprints"`
+	NotBefore    time.Time                `json:"notBefore"`
+	NotAfter     time.Time                `json:"notAfter"`
+}
+
+// DTLSCertificates keeps the DTLS certificate of peer connections to the SFU
+// in a file, so that it stays the same across restarts and can be shared by
+// instances. The certificate is replaced after the rotation interval, and is
+// valid for twice as long so that peers which connected before keep a valid
+// one. A nil DTLSCertificates has no certificates, so pion generates one for
+// each peer connection.
+type DTLSCertificates struct {
+	log    Logger
+	config DTLSConfig
+
+	mu          sync.Mutex
+	certificate webrtc.Certificate
+	info        DTLSCertificateInfo
+	modTime     time.Time
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewDTLSCertificates loads the certificate from the configured file, or
+// generates and writes one when the file does not exist or its certificate
+// is due for rotation.
+func NewDTLSCertificates(loggerFactory LoggerFactory, config DTLSConfig) (*DTLSCertificates, error) {
+	if config.RotationInterval == 0 {
+		config.RotationInterval = defaultDTLSRotationInterval
+	}
+	if config.RotationInterval < 0 {
+		return nil, fmt.Errorf("invalid DTLS certificate rotation interval: %s", config.RotationInterval)
+	}
+
+	d := &DTLSCertificates{
+		log:    loggerFactory.GetLogger("dtls"),
+		config: config,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if err := d.Check(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Start checks the certificate every minute until Stop is called.
+func (d *DTLSCertificates) Start() {
+	go func() {
+		defer close(d.done)
+
+		ticker := time.NewTicker(dtlsCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := d.Check(); err != nil {
+					d.log.Errorf("Error checking DTLS certificate: %s", err)
+				}
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops checking the certificate. It must only be called after Start.
+func (d *DTLSCertificates) Stop() {
+	if d == nil {
+		return
+	}
+
+	d.stopOnce.Do(func() {
+		close(d.stop)
+		<-d.done
+	})
+}
+
+// Check reloads the certificate when the file was changed by another
+// instance, and rotates it when it is due. Peer connections created before
+// keep the previous certificate.
+func (d *DTLSCertificates) Check() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stat, err := os.Stat(d.config.CertFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err == nil && !stat.ModTime().Equal(d.modTime) {
+		if err := d.load(); err != nil {
+			d.log.Errorf("Error loading DTLS certificate, generating a new one: %s", err)
+			return d.rotate()
+		}
+		d.modTime = stat.ModTime()
+	}
+
+	if d.modTime.IsZero() || time.Now().After(d.info.NotBefore.Add(d.config.RotationInterval)) {
+		return d.rotate()
+	}
+	return nil
+}
+
+func (d *DTLSCertificates) load() error {
+	data, err := ioutil.ReadFile(d.config.CertFile)
+	if err != nil {
+		return err
+	}
+
+	var cert *x509.Certificate
+	var key *ecdsa.PrivateKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err = x509.ParseCertificate(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if cert == nil || key == nil {
+		return fmt.Errorf("%s does not contain a certificate and an EC private key", d.config.CertFile)
+	}
+	if time.Now().After(cert.NotAfter) {
+		return fmt.Errorf("certificate expired at %s", cert.NotAfter)
+	}
+
+	return d.set(key, cert)
+}
+
+// rotate generates a new certificate and writes it to the file.
+func (d *DTLSCertificates) rotate() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generate DTLS key: %w", err)
+	}
+
+	// Certificates store times in seconds.
+	notBefore := time.Now().Truncate(time.Second)
+	cert, certDER, err := newDTLSCertificate(key, notBefore, 2*d.config.RotationInterval)
+	if err != nil {
+		return fmt.Errorf("generate DTLS certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("encode DTLS key: %w", err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	data = append(data, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})...)
+
+	// Other instances must never read a partially written file.
+	tmpFile := filepath.Join(filepath.Dir(d.config.CertFile), "."+filepath.Base(d.config.CertFile)+".tmp")
+	if err := ioutil.WriteFile(tmpFile, data, 0600); err != nil {
+		return fmt.Errorf("write DTLS certificate: %w", err)
+	}
+	if err := os.Rename(tmpFile, d.config.CertFile); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("write DTLS certificate: %w", err)
+	}
+
+	stat, err := os.Stat(d.config.CertFile)
+	if err != nil {
+		return err
+	}
+	d.modTime = stat.ModTime()
+
+	if err := d.set(key, cert); err != nil {
+		return err
+	}
+
+	d.log.Printf("Generated DTLS certificate, fingerprints: %v", d.info.Fingerprints)
+	return nil
+}
+
+func (d *DTLSCertificates) set(key *ecdsa.PrivateKey, cert *x509.Certificate) error {
+	certificate := webrtc.CertificateFromX509(key, cert)
+	fingerprints, err := certificate.GetFingerprints()
+	if err != nil {
+		return err
+	}
+
+	d.certificate = certificate
+	d.info = DTLSCertificateInfo{
+		Fingerprints: fingerprints,
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+	}
+	return nil
+}
+
+// newDTLSCertificate creates a self-signed certificate like the ones pion
+// generates, valid for validity from notBefore.
+func newDTLSCertificate(key *ecdsa.PrivateKey, notBefore time.Time, validity time.Duration) (*x509.Certificate, []byte, error) {
+	origin := make([]byte, 16)
+	if _, err := rand.Read(origin); err != nil {
+		return nil, nil, err
+	}
+
+	// 2^130 - 1
+	maxSerialNumber := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 130), big.NewInt(1))
+	serialNumber, err := rand.Int(rand.Reader, maxSerialNumber)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageClientAuth,
+			x509.ExtKeyUsageServerAuth,
+		},
+		BasicConstraintsValid: true,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validity),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		SerialNumber:          serialNumber,
+		Version:               2,
+		Subject:               pkix.Name{CommonName: hex.EncodeToString(origin)},
+		IsCA:                  true,
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	return cert, certDER, err
+}
+
+// Certificates returns the certificates of new peer connections.
+func (d *DTLSCertificates) Certificates() []webrtc.Certificate {
+	if d == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return []webrtc.Certificate{d.certificate}
+}
+
+// Info returns the fingerprints and validity of the certificate of new peer
+// connections.
+func (d *DTLSCertificates) Info() DTLSCertificateInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.info
+}
+
+// SetDTLSCertificates sets the DTLSCertificates of peer connections to the
+// SFU.
+func (wss *WSS) SetDTLSCertificates(certificates *DTLSCertificates) {
+	wss.dtlsCertificates = certificates
+}
+
+// DTLSCertificates returns the DTLSCertificates set with
+// SetDTLSCertificates.
+func (wss *WSS) DTLSCertificates() *DTLSCertificates {
+	return wss.dtlsCertificates
+}
@@ -0,0 +1,109 @@
+package server_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDTLSCertificates(t *testing.T) {
+	var nilCertificates *server.DTLSCertificates
+	assert.Nil(t, nilCertificates.Certificates())
+	nilCertificates.Stop()
+
+	dir, err := ioutil.TempDir("", "dtls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config := server.DTLSConfig{
+		CertFile:         filepath.Join(dir, "dtls.pem"),
+		RotationInterval: 2 * time.Second,
+	}
+
+	_, err = server.NewDTLSCertificates(loggerFactory, server.DTLSConfig{CertFile: config.CertFile, RotationInterval: -time.Second})
+	assert.Error(t, err, "invalid rotation interval")
+
+	node1, err := server.NewDTLSCertificates(loggerFactory, config)
+	require.NoError(t, err)
+	info := node1.Info()
+	require.Len(t, info.Fingerprints, 1)
+	assert.Equal(t, "sha-256", info.Fingerprints[0].Algorithm)
+	assert.Equal(t, 4*time.Second, info.NotAfter.Sub(info.NotBefore))
+
+	stat, err := os.Stat(config.CertFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+
+	node2, err := server.NewDTLSCertificates(loggerFactory, config)
+	require.NoError(t, err)
+	assert.Equal(t, info, node2.Info(), "same certificate from file")
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{Certificates: node2.Certificates()})
+	require.NoError(t, err)
+	defer pc.Close()
+	_, err = pc.CreateDataChannel("data", nil)
+	require.NoError(t, err)
+	offer, err := pc.CreateOffer(nil)
+	require.NoError(t, err)
+	assert.Contains(t, offer.SDP, "a=fingerprint:sha-256 "+strings.ToUpper(info.Fingerprints[0].Value))
+
+	time.Sleep(config.RotationInterval)
+	require.NoError(t, node1.Check())
+	rotated := node1.Info()
+	assert.NotEqual(t, info.Fingerprints, rotated.Fingerprints, "rotated")
+	require.NoError(t, node2.Check())
+	assert.Equal(t, rotated, node2.Info(), "rotated certificate loaded from file")
+}
+
+func TestDTLSCertificates_invalidFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dtls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config := server.DTLSConfig{CertFile: filepath.Join(dir, "dtls.pem")}
+	require.NoError(t, ioutil.WriteFile(config.CertFile, []byte("invalid"), 0600))
+
+	certificates, err := server.NewDTLSCertificates(loggerFactory, config)
+	require.NoError(t, err)
+	info := certificates.Info()
+	require.Len(t, info.Fingerprints, 1)
+	assert.Equal(t, 60*24*time.Hour, info.NotAfter.Sub(info.NotBefore), "default rotation interval")
+
+	data, err := ioutil.ReadFile(config.CertFile)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "-----BEGIN CERTIFICATE-----"), "replaced")
+}
+
+func TestAdmin_dtls(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	admin := server.AdminConfig{Token: adminToken}
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, admin, server.InactivityConfig{}, server.ClientConfig{}, rooms, newMockTracksManager())
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	var info server.DTLSCertificateInfo
+	statusCode := adminGetJSON(t, s.URL+"/api/admin/dtls", &info)
+	assert.Equal(t, http.StatusNotFound, statusCode, "not persisted")
+
+	dir, err := ioutil.TempDir("", "dtls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certificates, err := server.NewDTLSCertificates(loggerFactory, server.DTLSConfig{CertFile: filepath.Join(dir, "dtls.pem")})
+	require.NoError(t, err)
+	mux.WSS.SetDTLSCertificates(certificates)
+
+	statusCode = adminGetJSON(t, s.URL+"/api/admin/dtls", &info)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, certificates.Info().Fingerprints, info.Fingerprints)
+	assert.True(t, certificates.Info().NotAfter.Equal(info.NotAfter))
+}
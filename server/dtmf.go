@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+)
+
+const (
+	CodecTelephoneEvent = "telephone-event"
+	// DefaultPayloadTypeTelephoneEvent is the payload type of DTMF events
+	// sent on injected audio tracks. It has the clock rate of Opus, so that
+	// events can be sent on the same stream.
+	DefaultPayloadTypeTelephoneEvent = 126
+)
+
+const (
+	// dtmfToneDuration is how long each digit is played, and dtmfPause the
+	// silence between digits.
+	dtmfToneDuration = 100 * time.Millisecond
+	dtmfPause        = 100 * time.Millisecond
+	// dtmfPacketInterval is the interval of event updates.
+	dtmfPacketInterval = 20 * time.Millisecond
+	// dtmfEndPackets is how many times the final packet of an event is
+	// sent, RFC 4733 section 2.5.1.4.
+	dtmfEndPackets = 3
+	// dtmfVolume is the power level of tones in -dBm0.
+	dtmfVolume = 10
+	// maxDTMFDigits limits the number of digits of a single injection.
+	maxDTMFDigits = 64
+)
+
+// NewRTPTelephoneEventCodec creates a codec for the telephone-event payload
+// format of RFC 4733.
+func NewRTPTelephoneEventCodec(payloadType uint8, clockrate uint32) *webrtc.RTPCodec {
+	return webrtc.NewRTPCodec(webrtc.RTPCodecTypeAudio,
+		CodecTelephoneEvent,
+		clockrate,
+		0,
+		"0-15",
+		payloadType,
+		&fecPayloader{},
+	)
+}
+
+// dtmfEvent returns the RFC 4733 event code of a DTMF digit.
+func dtmfEvent(digit rune) (uint8, bool) {
+	switch {
+	case digit >= '0' && digit <= '9':
+		return uint8(digit - '0'), true
+	case digit == '*':
+		return 10, true
+	case digit == '#':
+		return 11, true
+	case digit >= 'A' && digit <= 'D':
+		return uint8(digit-'A') + 12, true
+	case digit >= 'a' && digit <= 'd':
+		return uint8(digit-'a') + 12, true
+	}
+	return 0, false
+}
+
+// dtmfPacket is a telephone-event packet which is sent at offset from the
+// start of an injection.
+type dtmfPacket struct {
+	offset time.Duration
+	marker bool
+	// timestamp is relative to the timestamp of the first event
+	timestamp uint32
+	payload   []byte
+}
+
+// telephoneEventPayload encodes a telephone-event payload, RFC 4733 section
+// 2.3. duration is in timestamp units.
+func telephoneEventPayload(event uint8, end bool, duration uint16) []byte {
+	payload := make([]byte, 4)
+	payload[0] = event
+	payload[1] = dtmfVolume
+	if end {
+		payload[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(payload[2:], duration)
+	return payload
+}
+
+// dtmfPackets returns the telephone-event packets which play digits at
+// clockRate. Each event starts with a marked packet, is updated every
+// dtmfPacketInterval with its duration so far, and ends with dtmfEndPackets
+// packets with the end bit set.
+func dtmfPackets(digits string, clockRate uint32) ([]dtmfPacket, error) {
+	if len(digits) == 0 || len(digits) > maxDTMFDigits {
+		return nil, fmt.Errorf("Expected 1 to %d DTMF digits, got: %d", maxDTMFDigits, len(digits))
+	}
+
+	samples := func(d time.Duration) uint32 {
+		return uint32(int64(d) * int64(clockRate) / int64(time.Second))
+	}
+
+	var packets []dtmfPacket
+	var offset time.Duration
+	for _, digit := range digits {
+		event, ok := dtmfEvent(digit)
+		if !ok {
+			return nil, fmt.Errorf("Invalid DTMF digit: %q", digit)
+		}
+
+		timestamp := samples(offset)
+		for elapsed := dtmfPacketInterval; elapsed < dtmfToneDuration; elapsed += dtmfPacketInterval {
+			packets = append(packets, dtmfPacket{
+				offset:    offset + elapsed - dtmfPacketInterval,
+				marker:    elapsed == dtmfPacketInterval,
+				timestamp: timestamp,
+				payload:   telephoneEventPayload(event, false, uint16(samples(elapsed))),
+			})
+		}
+		for i := 0; i < dtmfEndPackets; i++ {
+			packets = append(packets, dtmfPacket{
+				offset:    offset + dtmfToneDuration - dtmfPacketInterval + time.Duration(i)*dtmfPacketInterval,
+				timestamp: timestamp,
+				payload:   telephoneEventPayload(event, true, uint16(samples(dtmfToneDuration))),
+			})
+		}
+
+		offset += dtmfToneDuration + dtmfPause
+	}
+
+	return packets, nil
+}
+
+// InjectDTMF sends digits as RFC 4733 telephone events into room on a
+// server-originated audio track, like InjectAudio does. Digits are 0-9, *,
+// # and A-D. Returns the ID of the injection, which can be used to stop it
+// early.
+func (t *MemoryTracksManager) InjectDTMF(room string, digits string) (string, error) {
+	packets, err := dtmfPackets(digits, opusSampleRate)
+	if err != nil {
+		return "", err
+	}
+
+	injection, err := t.newAudioInjection(room)
+	if err != nil {
+		return "", err
+	}
+
+	go t.playDTMF(injection, packets)
+
+	return injection.id, nil
+}
+
+func (t *MemoryTracksManager) playDTMF(injection *audioInjection, packets []dtmfPacket) {
+	defer t.removeInjection(injection)
+
+	start := time.Now().Add(audioInjectionDelay)
+	sequenceNumber := uint16(rand.Uint32())
+	timestamp := rand.Uint32()
+
+	for _, p := range packets {
+		select {
+		case <-time.After(time.Until(start.Add(p.offset))):
+		case <-injection.stopChannel:
+			return
+		}
+
+		err := injection.track.WriteRTP(&rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				Marker:         p.marker,
+				PayloadType:    DefaultPayloadTypeTelephoneEvent,
+				SequenceNumber: sequenceNumber,
+				Timestamp:      timestamp + p.timestamp,
+				SSRC:           injection.track.SSRC(),
+			},
+			Payload: p.payload,
+		})
+		if err != nil && err != io.ErrClosedPipe {
+			t.log.Printf("InjectDTMF: Error writing event: %s: %s", injection.id, err)
+			return
+		}
+		sequenceNumber++
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDTMFEvent(t *testing.T) {
+	for digit, event := range map[rune]uint8{'0': 0, '9': 9, '*': 10, '#': 11, 'A': 12, 'd': 15} {
+		e, ok := dtmfEvent(digit)
+		assert.True(t, ok)
+		assert.Equal(t, event, e, "digit: %c", digit)
+	}
+	_, ok := dtmfEvent('E')
+	assert.False(t, ok)
+}
+
+func TestDTMFPackets(t *testing.T) {
+	packets, err := dtmfPackets("1#", 48000)
+	require.NoError(t, err)
+	require.Equal(t, 14, len(packets))
+
+	// the first event starts with a marked packet and updates its duration
+	assert.Equal(t, dtmfPacket{
+		offset:    0,
+		marker:    true,
+		timestamp: 0,
+		payload:   []byte{1, dtmfVolume, 0x03, 0xC0},
+	}, packets[0])
+	assert.Equal(t, dtmfPacket{
+		offset:    60 * time.Millisecond,
+		timestamp: 0,
+		payload:   []byte{1, dtmfVolume, 0x0F, 0x00},
+	}, packets[3])
+	// and ends with three packets with the end bit set
+	for i, p := range packets[4:7] {
+		assert.Equal(t, dtmfPacket{
+			offset:    time.Duration(80+i*20) * time.Millisecond,
+			timestamp: 0,
+			payload:   []byte{1, 0x80 | dtmfVolume, 0x12, 0xC0},
+		}, p)
+	}
+
+	// the second event starts after the pause
+	assert.Equal(t, dtmfPacket{
+		offset:    200 * time.Millisecond,
+		marker:    true,
+		timestamp: 9600,
+		payload:   []byte{11, dtmfVolume, 0x03, 0xC0},
+	}, packets[7])
+
+	_, err = dtmfPackets("", 48000)
+	assert.Error(t, err)
+	_, err = dtmfPackets("12x", 48000)
+	assert.Error(t, err)
+}
@@ -0,0 +1,334 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type EgressKind string
+
+const (
+	// EgressKindRecording records each track of the room separately.
+	EgressKindRecording EgressKind = "recording"
+	// EgressKindComposite records a single mixed audio and video file of the
+	// room.
+	EgressKindComposite EgressKind = "composite"
+	// EgressKindStream streams the composite of the room to an RTMP URL.
+	EgressKindStream EgressKind = "stream"
+)
+
+var (
+	ErrEgressDisabled = errors.New("Egress is disabled")
+	ErrEgressNotFound = errors.New("Egress not found")
+	ErrEgressInvalid  = errors.New("Invalid egress")
+)
+
+// egressClientIDPrefix is the prefix of client IDs of egress peers.
+const egressClientIDPrefix = "egress_"
+
+// EgressRequest is sent to an egress service to start processing a room.
+// The service connects to JoinURL as a hidden peer of the room: it receives
+// all tracks, but is not visible to other clients and does not count
+// towards room capacity.
+type EgressRequest struct {
+	ID        string     `json:"id"`
+	Kind      EgressKind `json:"kind"`
+	Room      string     `json:"room"`
+	MeetingID string     `json:"meetingId,omitempty"`
+	// StreamURL is the RTMP URL of stream egresses
+	StreamURL string `json:"streamUrl,omitempty"`
+	// ClientID is the client ID the service must join with
+	ClientID string `json:"clientId"`
+	// JoinURL is the websocket URL of the room, including the client ID and
+	// the token authorizing the service to join as a hidden peer
+	JoinURL    string          `json:"joinUrl"`
+	ICEServers []ICEAuthServer `json:"iceServers"`
+}
+
+// Egress delegates recording, composition and streaming of rooms to an
+// external service, so that heavy media processing runs off the SFU node.
+type Egress interface {
+	StartEgress(ctx context.Context, req EgressRequest) error
+	StopEgress(ctx context.Context, id string) error
+}
+
+// EgressParams are the parameters of an egress started through the admin
+// API.
+type EgressParams struct {
+	Kind      EgressKind `json:"kind"`
+	StreamURL string     `json:"streamUrl"`
+}
+
+// EgressInfo describes a running egress.
+type EgressInfo struct {
+	ID        string     `json:"id"`
+	Kind      EgressKind `json:"kind"`
+	Room      string     `json:"room"`
+	MeetingID string     `json:"meetingId,omitempty"`
+	StreamURL string     `json:"streamUrl,omitempty"`
+	ClientID  string     `json:"clientId"`
+	StartedAt time.Time  `json:"startedAt"`
+}
+
+// Egresses keeps track of egresses started on this instance and authorizes
+// egress services to join rooms as hidden peers.
+type Egresses struct {
+	log        Logger
+	wss        *WSS
+	baseURL    string
+	iceServers func() []ICEServer
+	// key signs join tokens. Tokens are only valid for the lifetime of this
+	// instance, as is the egress.
+	key []byte
+
+	mu      sync.Mutex
+	service Egress
+	nodeURL string
+	// key is egress ID
+	active map[string]EgressInfo
+}
+
+func NewEgresses(loggerFactory LoggerFactory, wss *WSS, baseURL string, iceServers func() []ICEServer) *Egresses {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Errorf("Error generating egress key: %w", err))
+	}
+
+	return &Egresses{
+		log:        loggerFactory.GetLogger("egress"),
+		wss:        wss,
+		baseURL:    baseURL,
+		iceServers: iceServers,
+		key:        key,
+		active:     map[string]EgressInfo{},
+	}
+}
+
+// SetService sets the egress service and the URL of this instance it uses
+// to join rooms, for example https://node1.example.com. Egress is disabled
+// until it is set.
+func (e *Egresses) SetService(service Egress, nodeURL string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.service = service
+	e.nodeURL = strings.TrimSuffix(nodeURL, "/")
+}
+
+func (e *Egresses) token(room string, clientID string) string {
+	mac := hmac.New(sha256.New, e.key)
+	mac.Write([]byte(room + "/" + clientID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Authorize returns true when clientID is a running egress of room and token
+// is its join token. A nil Egresses authorizes nothing.
+func (e *Egresses) Authorize(room string, clientID string, token string) bool {
+	if e == nil || token == "" || !strings.HasPrefix(clientID, egressClientIDPrefix) {
+		return false
+	}
+
+	e.mu.Lock()
+	info, ok := e.active[strings.TrimPrefix(clientID, egressClientIDPrefix)]
+	e.mu.Unlock()
+
+	return ok && info.Room == room &&
+		hmac.Equal([]byte(e.token(room, clientID)), []byte(token))
+}
+
+func (e *Egresses) joinURL(nodeURL string, room string, clientID string) string {
+	u := nodeURL
+	switch {
+	case strings.HasPrefix(u, "https://"):
+		u = "wss://" + strings.TrimPrefix(u, "https://")
+	case strings.HasPrefix(u, "http://"):
+		u = "ws://" + strings.TrimPrefix(u, "http://")
+	}
+
+	return u + e.baseURL + "/ws/" + url.PathEscape(room) + "/" + clientID +
+		"?egressToken=" + e.token(room, clientID)
+}
+
+// Start asks the egress service to start processing room.
+func (e *Egresses) Start(ctx context.Context, room string, params EgressParams) (EgressInfo, error) {
+	switch params.Kind {
+	case EgressKindRecording, EgressKindComposite:
+	case EgressKindStream:
+		if params.StreamURL == "" {
+			return EgressInfo{}, fmt.Errorf("%w: stream egress requires a stream URL", ErrEgressInvalid)
+		}
+	default:
+		return EgressInfo{}, fmt.Errorf("%w kind: %q", ErrEgressInvalid, params.Kind)
+	}
+
+	e.mu.Lock()
+	service := e.service
+	nodeURL := e.nodeURL
+	e.mu.Unlock()
+	if service == nil {
+		return EgressInfo{}, ErrEgressDisabled
+	}
+
+	id := NewUUIDBase62()
+	meetingID, _ := e.wss.MeetingID(room)
+	info := EgressInfo{
+		ID:        id,
+		Kind:      params.Kind,
+		Room:      room,
+		MeetingID: meetingID,
+		StreamURL: params.StreamURL,
+		ClientID:  egressClientIDPrefix + id,
+		StartedAt: time.Now(),
+	}
+
+	// registered before the request, so that the service can join right away
+	e.mu.Lock()
+	e.active[id] = info
+	e.mu.Unlock()
+
+	err := service.StartEgress(ctx, EgressRequest{
+		ID:         id,
+		Kind:       info.Kind,
+		Room:       room,
+		MeetingID:  meetingID,
+		StreamURL:  info.StreamURL,
+		ClientID:   info.ClientID,
+		JoinURL:    e.joinURL(nodeURL, room, info.ClientID),
+		ICEServers: GetICEAuthServers(e.iceServers()),
+	})
+	if err != nil {
+		e.remove(id)
+		return EgressInfo{}, fmt.Errorf("Error starting egress: %w", err)
+	}
+
+	e.log.Printf("Started egress: %s, kind: %s, room: %s", id, info.Kind, room)
+	return info, nil
+}
+
+func (e *Egresses) remove(id string) (EgressInfo, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	info, ok := e.active[id]
+	delete(e.active, id)
+	return info, ok
+}
+
+// Stop asks the egress service to stop egress id and disconnects its peer.
+func (e *Egresses) Stop(ctx context.Context, id string) (EgressInfo, error) {
+	info, ok := e.remove(id)
+	if !ok {
+		return EgressInfo{}, ErrEgressNotFound
+	}
+
+	e.wss.Disconnect(info.Room, info.ClientID)
+
+	e.mu.Lock()
+	service := e.service
+	e.mu.Unlock()
+
+	e.log.Printf("Stopping egress: %s, room: %s", id, info.Room)
+	if err := service.StopEgress(ctx, id); err != nil {
+		return info, fmt.Errorf("Error stopping egress: %w", err)
+	}
+	return info, nil
+}
+
+// StopRoom stops all egresses of room, for example after its meeting has
+// ended.
+func (e *Egresses) StopRoom(ctx context.Context, room string) {
+	for _, info := range e.List(room) {
+		if _, err := e.Stop(ctx, info.ID); err != nil {
+			e.log.Errorf("%s", err)
+		}
+	}
+}
+
+// List returns running egresses of room, oldest first. A nil Egresses has
+// none.
+func (e *Egresses) List(room string) []EgressInfo {
+	list := []EgressInfo{}
+	if e == nil {
+		return list
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, info := range e.active {
+		if info.Room == room {
+			list = append(list, info)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.Before(list[j].StartedAt)
+	})
+	return list
+}
+
+// HTTPEgress is an Egress which posts requests as JSON to an egress service.
+type HTTPEgress struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+var _ Egress = &HTTPEgress{}
+
+// NewHTTPEgress creates an Egress which starts egresses with a POST request
+// to url, and stops them with a DELETE request to url/{id}. When secret is
+// set, it is sent in the Authorization header as a bearer token.
+func NewHTTPEgress(url string, secret string, client *http.Client) *HTTPEgress {
+	return &HTTPEgress{
+		url:    strings.TrimSuffix(url, "/"),
+		secret: secret,
+		client: client,
+	}
+}
+
+func (h *HTTPEgress) do(ctx context.Context, method string, url string, body []byte) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if h.secret != "" {
+		req.Header.Set("Authorization", "Bearer "+h.secret)
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Egress service responded with status: %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (h *HTTPEgress) StartEgress(ctx context.Context, req EgressRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return h.do(ctx, http.MethodPost, h.url, body)
+}
+
+func (h *HTTPEgress) StopEgress(ctx context.Context, id string) error {
+	return h.do(ctx, http.MethodDelete, h.url+"/"+url.PathEscape(id), nil)
+}
@@ -0,0 +1,233 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"nhooyr.io/websocket"
+)
+
+type mockEgress struct {
+	started chan server.EgressRequest
+	stopped chan string
+}
+
+func newMockEgress() *mockEgress {
+	return &mockEgress{
+		started: make(chan server.EgressRequest, 1),
+		stopped: make(chan string, 1),
+	}
+}
+
+func (m *mockEgress) StartEgress(ctx context.Context, req server.EgressRequest) error {
+	m.started <- req
+	return nil
+}
+
+func (m *mockEgress) StopEgress(ctx context.Context, id string) error {
+	m.stopped <- id
+	return nil
+}
+
+func egressRequest(t *testing.T, method string, url string, params interface{}) *http.Response {
+	t.Helper()
+	body, err := json.Marshal(params)
+	require.NoError(t, err)
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return res
+}
+
+func TestEgress(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	admin := server.AdminConfig{Token: adminToken}
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, admin, server.InactivityConfig{}, server.ClientConfig{}, rooms, newMockTracksManager())
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	egressURL := s.URL + "/api/admin/rooms/" + roomName + "/egress"
+
+	res := egressRequest(t, "POST", egressURL, server.EgressParams{Kind: server.EgressKindRecording})
+	res.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+	egress := newMockEgress()
+	mux.Egress.SetService(egress, s.URL)
+	mux.SetICEServers([]server.ICEServer{{URLs: []string{"stun:stun.example.com"}}})
+
+	res = egressRequest(t, "POST", egressURL, server.EgressParams{Kind: server.EgressKindStream})
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode, "stream URL is required")
+
+	res = egressRequest(t, "POST", egressURL, server.EgressParams{
+		Kind:      server.EgressKindStream,
+		StreamURL: "rtmp://live.example.com/app/key",
+	})
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	var info server.EgressInfo
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&info))
+	res.Body.Close()
+
+	req := <-egress.started
+	assert.Equal(t, info.ID, req.ID)
+	assert.Equal(t, server.EgressKindStream, req.Kind)
+	assert.Equal(t, roomName, req.Room)
+	assert.Equal(t, "rtmp://live.example.com/app/key", req.StreamURL)
+	assert.Equal(t, info.ClientID, req.ClientID)
+	assert.Equal(t, []server.ICEAuthServer{{URLs: []string{"stun:stun.example.com"}}}, req.ICEServers)
+	wsPrefix := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + roomName + "/" + info.ClientID + "?egressToken="
+	require.True(t, strings.HasPrefix(req.JoinURL, wsPrefix), "unexpected join URL: %s", req.JoinURL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// the egress peer is hidden
+	ws := mustDialWS(t, ctx, req.JoinURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	mustReadWS(t, ctx, ws)
+	assert.Equal(t, []string{}, mux.WSS.LocalClientIDs(roomName))
+
+	// a forged token does not hide a client
+	forged := mustDialWS(t, ctx, "ws"+strings.TrimPrefix(s.URL, "http")+"/ws/"+roomName+"/egress_forged?egressToken=forged")
+	defer forged.Close(websocket.StatusNormalClosure, "")
+	mustReadWS(t, ctx, forged)
+	assert.Equal(t, []string{"egress_forged"}, mux.WSS.LocalClientIDs(roomName))
+
+	var list []server.EgressInfo
+	require.Equal(t, http.StatusOK, roomStateRequest(t, egressURL, adminToken, &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, info.ID, list[0].ID)
+
+	res = egressRequest(t, "DELETE", egressURL+"/"+info.ID, nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Equal(t, info.ID, <-egress.stopped)
+	mustReadUntilClosed(t, ctx, ws)
+
+	res = egressRequest(t, "DELETE", egressURL+"/"+info.ID, nil)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestHTTPEgress(t *testing.T) {
+	type request struct {
+		method string
+		path   string
+		auth   string
+		body   server.EgressRequest
+	}
+	requests := make(chan request, 2)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := request{r.Method, r.URL.Path, r.Header.Get("Authorization"), server.EgressRequest{}}
+		if r.Method == "POST" {
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req.body))
+		}
+		requests <- req
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer s.Close()
+
+	egress := server.NewHTTPEgress(s.URL+"/egress", "secret", http.DefaultClient)
+	ctx := context.Background()
+
+	require.NoError(t, egress.StartEgress(ctx, server.EgressRequest{ID: "a", Kind: server.EgressKindComposite, Room: roomName}))
+	req := <-requests
+	assert.Equal(t, "POST", req.method)
+	assert.Equal(t, "/egress", req.path)
+	assert.Equal(t, "Bearer secret", req.auth)
+	assert.Equal(t, server.EgressKindComposite, req.body.Kind)
+	assert.Equal(t, roomName, req.body.Room)
+
+	require.NoError(t, egress.StopEgress(ctx, "a"))
+	req = <-requests
+	assert.Equal(t, "DELETE", req.method)
+	assert.Equal(t, "/egress/a", req.path)
+
+	failing := server.NewHTTPEgress(s.URL+"/missing", "", &http.Client{
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusInternalServerError, Body: http.NoBody}, nil
+		}),
+	})
+	assert.Error(t, failing.StopEgress(ctx, "a"))
+}
+
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+type mockEgressServiceServer struct {
+	started chan *server.EgressStartRequest
+	auth    chan string
+}
+
+func (m *mockEgressServiceServer) StartEgress(ctx context.Context, req *server.EgressStartRequest) (*server.EgressResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	m.auth <- strings.Join(md.Get("authorization"), ",")
+	m.started <- req
+	return &server.EgressResponse{}, nil
+}
+
+func (m *mockEgressServiceServer) StopEgress(ctx context.Context, req *server.EgressStopRequest) (*server.EgressResponse, error) {
+	return &server.EgressResponse{}, nil
+}
+
+func TestGRPCEgress(t *testing.T) {
+	srv := &mockEgressServiceServer{
+		started: make(chan *server.EgressStartRequest, 1),
+		auth:    make(chan string, 1),
+	}
+	rpc := grpc.NewServer()
+	server.RegisterEgressServiceServer(rpc, srv)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go rpc.Serve(l)
+	defer rpc.Stop()
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	egress := server.NewGRPCEgress(conn, "secret")
+	require.NoError(t, egress.StartEgress(ctx, server.EgressRequest{
+		ID:       "a",
+		Kind:     server.EgressKindRecording,
+		Room:     roomName,
+		ClientID: "egress_a",
+		JoinURL:  "wss://node1.example.com/ws/room/egress_a?egressToken=t",
+		ICEServers: []server.ICEAuthServer{{
+			URLs:       []string{"turn:turn.example.com"},
+			Username:   "user",
+			Credential: "pass",
+		}},
+	}))
+	assert.Equal(t, "Bearer secret", <-srv.auth)
+	req := <-srv.started
+	assert.Equal(t, "a", req.ID)
+	assert.Equal(t, "recording", req.Kind)
+	assert.Equal(t, "egress_a", req.ClientID)
+	assert.Equal(t, "wss://node1.example.com/ws/room/egress_a?egressToken=t", req.JoinURL)
+	require.Len(t, req.ICEServers, 1)
+	assert.Equal(t, []string{"turn:turn.example.com"}, req.ICEServers[0].URLs)
+	assert.Equal(t, "pass", req.ICEServers[0].Credential)
+
+	require.NoError(t, egress.StopEgress(ctx, "a"))
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Messages of the egress gRPC API defined in egress.proto.
+
+type EgressStartRequest struct {
+	ID         string             `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Kind       string             `protobuf:"bytes,2,opt,name=kind,proto3" json:"kind,omitempty"`
+	Room       string             `protobuf:"bytes,3,opt,name=room,proto3" json:"room,omitempty"`
+	MeetingID  string             `protobuf:"bytes,4,opt,name=meeting_id,json=meetingId,proto3" json:"meeting_id,omitempty"`
+	StreamURL  string             `protobuf:"bytes,5,opt,name=stream_url,json=streamUrl,proto3" json:"stream_url,omitempty"`
+	ClientID   string             `protobuf:"bytes,6,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	JoinURL    string             `protobuf:"bytes,7,opt,name=join_url,json=joinUrl,proto3" json:"join_url,omitempty"`
+	ICEServers []*EgressICEServer `protobuf:"bytes,8,rep,name=ice_servers,json=iceServers,proto3" json:"ice_servers,omitempty"`
+}
+
+type EgressICEServer struct {
+	URLs       []string `protobuf:"bytes,1,rep,name=urls,proto3" json:"urls,omitempty"`
+	Username   string   `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	Credential string   `protobuf:"bytes,3,opt,name=credential,proto3" json:"credential,omitempty"`
+}
+
+type EgressStopRequest struct {
+	ID string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+}
+
+type EgressResponse struct{}
+
+func (m *EgressStartRequest) Reset()         { *m = EgressStartRequest{} }
+func (m *EgressStartRequest) String() string { return proto.CompactTextString(m) }
+func (*EgressStartRequest) ProtoMessage()    {}
+
+func (m *EgressICEServer) Reset()         { *m = EgressICEServer{} }
+func (m *EgressICEServer) String() string { return proto.CompactTextString(m) }
+func (*EgressICEServer) ProtoMessage()    {}
+
+func (m *EgressStopRequest) Reset()         { *m = EgressStopRequest{} }
+func (m *EgressStopRequest) String() string { return proto.CompactTextString(m) }
+func (*EgressStopRequest) ProtoMessage()    {}
+
+func (m *EgressResponse) Reset()         { *m = EgressResponse{} }
+func (m *EgressResponse) String() string { return proto.CompactTextString(m) }
+func (*EgressResponse) ProtoMessage()    {}
+
+const egressServiceName = "peercalls.EgressService"
+
+// EgressServiceServer is implemented by egress services written in Go.
+type EgressServiceServer interface {
+	StartEgress(context.Context, *EgressStartRequest) (*EgressResponse, error)
+	StopEgress(context.Context, *EgressStopRequest) (*EgressResponse, error)
+}
+
+// RegisterEgressServiceServer registers srv as the egress service of s.
+func RegisterEgressServiceServer(s *grpc.Server, srv EgressServiceServer) {
+	s.RegisterService(&egressServiceDesc, srv)
+}
+
+var egressServiceDesc = grpc.ServiceDesc{
+	ServiceName: egressServiceName,
+	HandlerType: (*EgressServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		egressMethodHandler("StartEgress",
+			func() interface{} { return new(EgressStartRequest) },
+			func(srv EgressServiceServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.StartEgress(ctx, req.(*EgressStartRequest))
+			}),
+		egressMethodHandler("StopEgress",
+			func() interface{} { return new(EgressStopRequest) },
+			func(srv EgressServiceServer, ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.StopEgress(ctx, req.(*EgressStopRequest))
+			}),
+	},
+	Metadata: "egress.proto",
+}
+
+// egressMethodHandler adapts a typed method of EgressServiceServer to
+// grpc.MethodDesc.
+func egressMethodHandler(
+	name string,
+	newRequest func() interface{},
+	call func(srv EgressServiceServer, ctx context.Context, req interface{}) (interface{}, error),
+) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := newRequest()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(EgressServiceServer), ctx, req)
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + egressServiceName + "/" + name,
+			}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+// GRPCEgress is an Egress which calls the egress gRPC API of an egress
+// service.
+type GRPCEgress struct {
+	cc     *grpc.ClientConn
+	secret string
+}
+
+var _ Egress = &GRPCEgress{}
+
+// NewGRPCEgress creates an Egress using cc. When secret is set, it is sent
+// in the authorization metadata as a bearer token.
+func NewGRPCEgress(cc *grpc.ClientConn, secret string) *GRPCEgress {
+	return &GRPCEgress{
+		cc:     cc,
+		secret: secret,
+	}
+}
+
+func (g *GRPCEgress) invoke(ctx context.Context, method string, in interface{}) error {
+	if g.secret != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+g.secret)
+	}
+	return g.cc.Invoke(ctx, "/"+egressServiceName+"/"+method, in, new(EgressResponse))
+}
+
+func (g *GRPCEgress) StartEgress(ctx context.Context, req EgressRequest) error {
+	in := &EgressStartRequest{
+		ID:        req.ID,
+		Kind:      string(req.Kind),
+		Room:      req.Room,
+		MeetingID: req.MeetingID,
+		StreamURL: req.StreamURL,
+		ClientID:  req.ClientID,
+		JoinURL:   req.JoinURL,
+	}
+	for _, iceServer := range req.ICEServers {
+		in.ICEServers = append(in.ICEServers, &EgressICEServer{
+			URLs:       iceServer.URLs,
+			Username:   iceServer.Username,
+			Credential: iceServer.Credential,
+		})
+	}
+	return g.invoke(ctx, "StartEgress", in)
+}
+
+func (g *GRPCEgress) StopEgress(ctx context.Context, id string) error {
+	return g.invoke(ctx, "StopEgress", &EgressStopRequest{ID: id})
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/pion/webrtc/v2"
+)
+
+const (
+	// EventLogJoin and EventLogLeave are recorded when a client joins or
+	// leaves a room.
+	EventLogJoin  = "join"
+	EventLogLeave = "leave"
+	// EventLogTrackAdd and EventLogTrackRemove are recorded when a client
+	// starts or stops publishing a track.
+	EventLogTrackAdd    = "track_add"
+	EventLogTrackRemove = "track_remove"
+	// EventLogMute and EventLogUnmute are recorded when a moderator or the
+	// admin API mutes or unmutes a client.
+	EventLogMute   = "mute"
+	EventLogUnmute = "unmute"
+	// EventLogKick is recorded when a moderator or the admin API removes a
+	// client from a room.
+	EventLogKick = "kick"
+	// EventLogRecordingStart and EventLogRecordingStop are recorded when a
+	// recording of a room starts or stops.
+	EventLogRecordingStart = "recording_start"
+	EventLogRecordingStop  = "recording_stop"
+)
+
+// eventLogQueueSize is the number of events buffered for a slow store before
+// new events are dropped.
+const eventLogQueueSize = 1024
+
+const (
+	// DefaultEventLogPageSize is the number of events returned when no limit
+	// is given.
+	DefaultEventLogPageSize = 100
+	// MaxEventLogPageSize is the maximum number of events returned at once.
+	MaxEventLogPageSize = 1000
+)
+
+// EventLogEntry is a significant event of a room.
+type EventLogEntry struct {
+	// Seq is assigned by the store and increases with each event of a room.
+	Seq      int64     `json:"seq"`
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Room     string    `json:"room"`
+	ClientID string    `json:"clientId,omitempty"`
+	// ActorID is the client which caused the event, for example the
+	// moderator kicking ClientID. It is empty for events caused by the
+	// client itself, the server or the admin API.
+	ActorID string            `json:"actorId,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// EventLogPage is a page of events of a room, oldest first.
+type EventLogPage struct {
+	Events []EventLogEntry `json:"events"`
+	// Next is the sequence number to read the following page after. It is
+	// the sequence number of the last event, or the requested one when there
+	// are no more events.
+	Next int64 `json:"next"`
+}
+
+// EventLogStore keeps the events of rooms.
+type EventLogStore interface {
+	// AddEvent assigns the next sequence number of the room of event to it,
+	// appends it and discards all but the last limit events of the room.
+	AddEvent(event EventLogEntry, limit int) (EventLogEntry, error)
+	// Events returns at most limit events of room with a sequence number
+	// greater than after, oldest first.
+	Events(room string, after int64, limit int) ([]EventLogEntry, error)
+}
+
+type MemoryEventLogStore struct {
+	mu sync.Mutex
+	// key is room
+	events map[string][]EventLogEntry
+	// key is room, value is the last assigned sequence number. It is kept
+	// when events are discarded, so that sequence numbers are not reused.
+	seqs map[string]int64
+}
+
+var _ EventLogStore = &MemoryEventLogStore{}
+
+func NewMemoryEventLogStore() *MemoryEventLogStore {
+	return &MemoryEventLogStore{
+		events: map[string][]EventLogEntry{},
+		seqs:   map[string]int64{},
+	}
+}
+
+func (s *MemoryEventLogStore) AddEvent(event EventLogEntry, limit int) (EventLogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seqs[event.Room]++
+	event.Seq = s.seqs[event.Room]
+
+	events := append(s.events[event.Room], event)
+	if len(events) > limit {
+		// copy so that the discarded events can be garbage collected
+		events = append([]EventLogEntry(nil), events[len(events)-limit:]...)
+	}
+	s.events[event.Room] = events
+	return event, nil
+}
+
+func (s *MemoryEventLogStore) Events(room string, after int64, limit int) ([]EventLogEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := s.events[room]
+	i := sort.Search(len(events), func(i int) bool {
+		return events[i].Seq > after
+	})
+	events = events[i:]
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return append([]EventLogEntry(nil), events...), nil
+}
+
+// RedisEventLogStore keeps the events of each room in a redis sorted set
+// scored by sequence number, so that events of all instances are kept
+// together.
+type RedisEventLogStore struct {
+	client *redis.Client
+	prefix string
+}
+
+var _ EventLogStore = &RedisEventLogStore{}
+
+func NewRedisEventLogStore(client *redis.Client, prefix string) *RedisEventLogStore {
+	return &RedisEventLogStore{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+func getRoomEventsName(prefix string, room string) string {
+	return prefix + ":room:" + room + ":events"
+}
+
+func getRoomEventsSeqName(prefix string, room string) string {
+	return prefix + ":room:" + room + ":events:seq"
+}
+
+func (s *RedisEventLogStore) AddEvent(event EventLogEntry, limit int) (EventLogEntry, error) {
+	seq, err := s.client.Incr(getRoomEventsSeqName(s.prefix, event.Room)).Result()
+	if err != nil {
+		return event, err
+	}
+	event.Seq = seq
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return event, err
+	}
+
+	key := getRoomEventsName(s.prefix, event.Room)
+	_, err = s.client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(key, &redis.Z{Score: float64(seq), Member: data})
+		pipe.ZRemRangeByRank(key, 0, int64(-limit-1))
+		return nil
+	})
+	return event, err
+}
+
+func (s *RedisEventLogStore) Events(room string, after int64, limit int) ([]EventLogEntry, error) {
+	values, err := s.client.ZRangeByScore(getRoomEventsName(s.prefix, room), &redis.ZRangeBy{
+		Min:   "(" + strconv.FormatInt(after, 10),
+		Max:   "+inf",
+		Count: int64(limit),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]EventLogEntry, 0, len(values))
+	for _, value := range values {
+		var event EventLogEntry
+		if err := json.Unmarshal([]byte(value), &event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// EventLog records room events to a store, keeping the last limit events of
+// each room. Events are written asynchronously so that a slow store does not
+// delay signalling. A nil EventLog discards all events.
+type EventLog struct {
+	log   Logger
+	store EventLogStore
+	limit int
+
+	// eventsMu guards closing events
+	eventsMu sync.RWMutex
+	closed   bool
+	events   chan EventLogEntry
+	done     chan struct{}
+}
+
+func NewEventLog(loggerFactory LoggerFactory, store EventLogStore, limit int) *EventLog {
+	l := &EventLog{
+		log:    loggerFactory.GetLogger("eventlog"),
+		store:  store,
+		limit:  limit,
+		events: make(chan EventLogEntry, eventLogQueueSize),
+		done:   make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+func (l *EventLog) run() {
+	defer close(l.done)
+
+	for event := range l.events {
+		if _, err := l.store.AddEvent(event, l.limit); err != nil {
+			l.log.Printf("Error recording event: %s in room: %s: %s", event.Type, event.Room, err)
+		}
+	}
+}
+
+// Emit queues event for recording. The event is dropped when the queue is
+// full or the EventLog is closed.
+func (l *EventLog) Emit(event EventLogEntry) {
+	if l == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	l.eventsMu.RLock()
+	defer l.eventsMu.RUnlock()
+
+	if l.closed {
+		return
+	}
+
+	select {
+	case l.events <- event:
+	default:
+		l.log.Printf("Event log queue full, dropping event: %s in room: %s", event.Type, event.Room)
+	}
+}
+
+// Close records all queued events and stops the EventLog.
+func (l *EventLog) Close() {
+	if l == nil {
+		return
+	}
+
+	l.eventsMu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.events)
+	}
+	l.eventsMu.Unlock()
+
+	<-l.done
+}
+
+// Events returns a page of at most limit events of room recorded after the
+// event with sequence number after. Limit is DefaultEventLogPageSize when
+// not positive, and at most MaxEventLogPageSize.
+func (l *EventLog) Events(room string, after int64, limit int) (EventLogPage, error) {
+	if limit <= 0 {
+		limit = DefaultEventLogPageSize
+	}
+	if limit > MaxEventLogPageSize {
+		limit = MaxEventLogPageSize
+	}
+
+	events, err := l.store.Events(room, after, limit)
+	if err != nil {
+		return EventLogPage{}, err
+	}
+
+	page := EventLogPage{Events: events, Next: after}
+	if len(events) > 0 {
+		page.Next = events[len(events)-1].Seq
+	}
+	return page, nil
+}
+
+func eventLogTrackDetails(track *webrtc.Track) map[string]string {
+	return map[string]string{
+		"trackId": track.ID(),
+		"kind":    track.Kind().String(),
+	}
+}
+
+// emitMuted records a mute or unmute of clientID by actorID.
+func (l *EventLog) emitMuted(room string, clientID string, actorID string, muted bool) {
+	eventType := EventLogUnmute
+	if muted {
+		eventType = EventLogMute
+	}
+	l.Emit(EventLogEntry{Type: eventType, Room: room, ClientID: clientID, ActorID: actorID})
+}
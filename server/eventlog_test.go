@@ -0,0 +1,99 @@
+package server_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEventLogStore(t *testing.T, store server.EventLogStore) {
+	t.Helper()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, clientID := range []string{"a", "b", "c", "d"} {
+		event, err := store.AddEvent(server.EventLogEntry{
+			Time:     now.Add(time.Duration(i) * time.Second),
+			Type:     server.EventLogJoin,
+			Room:     room,
+			ClientID: clientID,
+		}, 3)
+		require.NoError(t, err)
+		assert.Equal(t, int64(i+1), event.Seq)
+	}
+
+	events, err := store.Events(room, 0, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []server.EventLogEntry{
+		{Seq: 2, Time: now.Add(time.Second), Type: server.EventLogJoin, Room: room, ClientID: "b"},
+		{Seq: 3, Time: now.Add(2 * time.Second), Type: server.EventLogJoin, Room: room, ClientID: "c"},
+	}, events)
+
+	events, err = store.Events(room, 3, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []server.EventLogEntry{
+		{Seq: 4, Time: now.Add(3 * time.Second), Type: server.EventLogJoin, Room: room, ClientID: "d"},
+	}, events)
+
+	events, err = store.Events(room, 4, 2)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	// sequence numbers are per room
+	event, err := store.AddEvent(server.EventLogEntry{Type: server.EventLogJoin, Room: "other-room"}, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), event.Seq)
+}
+
+func TestMemoryEventLogStore(t *testing.T) {
+	testEventLogStore(t, server.NewMemoryEventLogStore())
+}
+
+func TestRedisEventLogStore(t *testing.T) {
+	pub, _, stop := configureRedis(t)
+	defer stop()
+	prefix := "peercalls-test-" + server.NewUUIDBase62()
+	defer func() {
+		keys, _ := pub.Keys(prefix + ":*").Result()
+		if len(keys) > 0 {
+			pub.Del(keys...)
+		}
+	}()
+	testEventLogStore(t, server.NewRedisEventLogStore(pub, prefix))
+}
+
+func TestEventLog(t *testing.T) {
+	eventLog := server.NewEventLog(loggerFactory, server.NewMemoryEventLogStore(), 10)
+	for _, eventType := range []string{server.EventLogJoin, server.EventLogLeave} {
+		eventLog.Emit(server.EventLogEntry{Type: eventType, Room: room, ClientID: "a"})
+	}
+	eventLog.Close()
+	// dropped after close
+	eventLog.Emit(server.EventLogEntry{Type: server.EventLogJoin, Room: room, ClientID: "b"})
+
+	page, err := eventLog.Events(room, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, page.Events, 1)
+	assert.Equal(t, server.EventLogJoin, page.Events[0].Type)
+	assert.False(t, page.Events[0].Time.IsZero())
+	assert.Equal(t, int64(1), page.Next)
+
+	page, err = eventLog.Events(room, page.Next, 0)
+	require.NoError(t, err)
+	require.Len(t, page.Events, 1)
+	assert.Equal(t, server.EventLogLeave, page.Events[0].Type)
+	assert.Equal(t, int64(2), page.Next)
+
+	page, err = eventLog.Events(room, page.Next, 0)
+	require.NoError(t, err)
+	assert.Empty(t, page.Events)
+	assert.Equal(t, int64(2), page.Next)
+}
+
+func TestEventLog_nil(t *testing.T) {
+	var eventLog *server.EventLog
+	eventLog.Emit(server.EventLogEntry{Type: server.EventLogJoin, Room: room})
+	eventLog.Close()
+}
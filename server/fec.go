@@ -0,0 +1,112 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+)
+
+// Names of codecs used for forward error correction of video. ULPFEC packets
+// are sent in RED packets on the SSRC of the video, FlexFEC packets are sent
+// on a separate SSRC.
+const (
+	CodecRED     = "red"
+	CodecULPFEC  = "ulpfec"
+	CodecFlexFEC = "flexfec-03"
+)
+
+// Payload types of FEC codecs in offers sent by the server.
+const (
+	DefaultPayloadTypeRED     = 116
+	DefaultPayloadTypeULPFEC  = 117
+	DefaultPayloadTypeFlexFEC = 118
+)
+
+// isFECCodec returns true when name is the name of a FEC codec.
+func isFECCodec(name string) bool {
+	switch strings.ToLower(name) {
+	case CodecRED, CodecULPFEC, CodecFlexFEC:
+		return true
+	}
+	return false
+}
+
+// NewRTPFECCodec creates a video FEC codec. Its payloader does not packetize,
+// it is only meant for tracks which forward packets of a remote track.
+func NewRTPFECCodec(name string, payloadType uint8, clockrate uint32) *webrtc.RTPCodec {
+	fmtp := ""
+	if strings.EqualFold(name, CodecFlexFEC) {
+		fmtp = "repair-window=10000000"
+	}
+	return webrtc.NewRTPCodec(webrtc.RTPCodecTypeVideo,
+		name,
+		clockrate,
+		0,
+		fmtp,
+		payloadType,
+		&fecPayloader{})
+}
+
+type fecPayloader struct{}
+
+func (p *fecPayloader) Payload(mtu int, payload []byte) [][]byte {
+	return [][]byte{payload}
+}
+
+// RegisterFECCodecs registers RED, ULPFEC and FlexFEC, so that peers
+// protect the video they send to the server with FEC packets when they
+// support it. Must be called after video codecs are registered, so that
+// peers prefer sending video without FEC to peers which do not support it.
+func RegisterFECCodecs(mediaEngine *webrtc.MediaEngine) {
+	mediaEngine.RegisterCodec(NewRTPFECCodec(CodecRED, DefaultPayloadTypeRED, 90000))
+	mediaEngine.RegisterCodec(NewRTPFECCodec(CodecULPFEC, DefaultPayloadTypeULPFEC, 90000))
+	mediaEngine.RegisterCodec(NewRTPFECCodec(CodecFlexFEC, DefaultPayloadTypeFlexFEC, 90000))
+}
+
+// decodeRED returns the payload type and the data of the primary block of a
+// RED payload as described in RFC 2198. Redundant blocks precede the primary
+// block.
+func decodeRED(payload []byte) (payloadType uint8, data []byte, ok bool) {
+	offset := 0
+	redundantSize := 0
+
+	for offset < len(payload) && payload[offset]&0x80 != 0 {
+		if offset+4 > len(payload) {
+			return 0, nil, false
+		}
+		redundantSize += int(payload[offset+2]&0x03)<<8 | int(payload[offset+3])
+		offset += 4
+	}
+
+	if offset >= len(payload) {
+		return 0, nil, false
+	}
+	payloadType = payload[offset] & 0x7f
+	offset++
+
+	if offset+redundantSize > len(payload) {
+		return 0, nil, false
+	}
+	return payloadType, payload[offset+redundantSize:], true
+}
+
+// mediaPacket returns packet unwrapped from RED, or nil when packet only
+// carries FEC. Other packets are returned unchanged.
+func mediaPacket(packet *rtp.Packet) *rtp.Packet {
+	switch packet.PayloadType {
+	case DefaultPayloadTypeULPFEC, DefaultPayloadTypeFlexFEC:
+		return nil
+	case DefaultPayloadTypeRED:
+		payloadType, data, ok := decodeRED(packet.Payload)
+		if !ok || payloadType == DefaultPayloadTypeULPFEC {
+			return nil
+		}
+		unwrapped := *packet
+		unwrapped.PayloadType = payloadType
+		unwrapped.Payload = data
+		return &unwrapped
+	default:
+		return packet
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMediaPacket(t *testing.T) {
+	vp8 := &rtp.Packet{
+		Header:  rtp.Header{PayloadType: webrtc.DefaultPayloadTypeVP8, SequenceNumber: 3},
+		Payload: []byte{0x10, 1, 2},
+	}
+	assert.Same(t, vp8, mediaPacket(vp8))
+
+	// a redundant block of 2 bytes followed by the primary VP8 block
+	red := &rtp.Packet{
+		Header: rtp.Header{PayloadType: DefaultPayloadTypeRED, SequenceNumber: 3},
+		Payload: []byte{
+			0x80 | webrtc.DefaultPayloadTypeVP8, 0, 0, 2,
+			webrtc.DefaultPayloadTypeVP8,
+			9, 9,
+			0x10, 1, 2,
+		},
+	}
+	unwrapped := mediaPacket(red)
+	assert.Equal(t, uint8(webrtc.DefaultPayloadTypeVP8), unwrapped.PayloadType)
+	assert.Equal(t, uint16(3), unwrapped.SequenceNumber)
+	assert.Equal(t, []byte{0x10, 1, 2}, unwrapped.Payload)
+	assert.Equal(t, uint8(DefaultPayloadTypeRED), red.PayloadType, "original packet is unchanged")
+
+	ulpfec := &rtp.Packet{
+		Header:  rtp.Header{PayloadType: DefaultPayloadTypeRED},
+		Payload: []byte{DefaultPayloadTypeULPFEC, 1, 2},
+	}
+	assert.Nil(t, mediaPacket(ulpfec))
+
+	flexfec := &rtp.Packet{Header: rtp.Header{PayloadType: DefaultPayloadTypeFlexFEC}}
+	assert.Nil(t, mediaPacket(flexfec))
+
+	truncated := &rtp.Packet{
+		Header:  rtp.Header{PayloadType: DefaultPayloadTypeRED},
+		Payload: []byte{0x80 | webrtc.DefaultPayloadTypeVP8, 0, 0, 9, webrtc.DefaultPayloadTypeVP8},
+	}
+	assert.Nil(t, mediaPacket(truncated))
+}
+
+func TestRegisterFECCodecs(t *testing.T) {
+	mediaEngine := &webrtc.MediaEngine{}
+	RegisterCodecs(mediaEngine, []string{CodecVP8})
+	RegisterFECCodecs(mediaEngine)
+
+	var names []string
+	for _, codec := range mediaEngine.GetCodecsByKind(webrtc.RTPCodecTypeVideo) {
+		names = append(names, codec.Name)
+	}
+	assert.Equal(t, []string{CodecVP8, CodecRED, CodecULPFEC, CodecFlexFEC}, names)
+
+	sd := webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP: "v=0\r\no=- 1 2 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n" +
+			"m=video 9 UDP/TLS/RTP/SAVPF 96 120\r\nc=IN IP4 0.0.0.0\r\n" +
+			"a=rtpmap:96 VP8/90000\r\na=rtpmap:120 red/90000\r\n",
+	}
+	populated := &webrtc.MediaEngine{}
+	assert.NoError(t, populateFromSDP(populated, sd))
+	names = nil
+	for _, codec := range populated.GetCodecsByKind(webrtc.RTPCodecTypeVideo) {
+		names = append(names, codec.Name)
+	}
+	assert.Equal(t, []string{CodecVP8, CodecRED}, names)
+}
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+)
+
+const (
+	MessageTypeRequestFloor = "requestFloor"
+	MessageTypeReleaseFloor = "releaseFloor"
+	// MessageTypeFloor is sent to all clients of a push-to-talk room when the
+	// floor changes, and to clients joining it.
+	MessageTypeFloor = "floor"
+	// MessageTypeFloorDenied is sent to a client which requested the floor
+	// while it was held by as many peers as can speak at the same time.
+	MessageTypeFloorDenied = "floorDenied"
+)
+
+// ErrNotPushToTalkRoom is returned when the floor is requested in a room
+// which is not a push-to-talk room.
+var ErrNotPushToTalkRoom = errors.New("not a push-to-talk room")
+
+// floorNotificationsSize is the number of floor changes queued for the
+// changed callback.
+const floorNotificationsSize = 64
+
+// floorControl arbitrates the floor of push-to-talk rooms. Only the audio of
+// peers holding the floor of their room is forwarded, see floorGate.
+type floorControl struct {
+	log    Logger
+	config PushToTalkConfig
+
+	mu sync.Mutex
+	// key is clientID, value is room
+	rooms map[string]string
+	// key is room
+	floors map[string]*floor
+
+	notifications chan floorNotification
+}
+
+// floor contains the peers holding the floor of a room, in the order they
+// were granted it.
+type floor struct {
+	speakers []*floorSpeaker
+}
+
+type floorSpeaker struct {
+	clientID string
+	// timer releases the floor after the maximum hold time
+	timer *time.Timer
+}
+
+type floorNotification struct {
+	room     string
+	speakers []string
+}
+
+func newFloorControl(log Logger, config PushToTalkConfig) *floorControl {
+	return &floorControl{
+		log:    log,
+		config: config,
+		rooms:  map[string]string{},
+		floors: map[string]*floor{},
+	}
+}
+
+// Enabled returns true when push-to-talk rooms are configured.
+func (f *floorControl) Enabled() bool {
+	return len(f.config.Rooms) > 0
+}
+
+// OnChanged calls changed with the peers holding the floor of a push-to-talk
+// room whenever it changes, and when a peer joins the room. Calls are made
+// from a single goroutine, in the order of the changes. It must be called
+// before any peers are added.
+func (f *floorControl) OnChanged(changed func(room string, speakers []string)) {
+	f.notifications = make(chan floorNotification, floorNotificationsSize)
+
+	go func() {
+		for n := range f.notifications {
+			changed(n.room, n.speakers)
+		}
+	}()
+}
+
+// Request grants clientID the floor of its room. Returns false when it is
+// held by as many peers as can speak at the same time.
+func (f *floorControl) Request(clientID string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	room, ok := f.rooms[clientID]
+	if !ok {
+		return false, ErrClientNotFound
+	}
+	if !f.config.IsPushToTalkRoom(room) {
+		return false, ErrNotPushToTalkRoom
+	}
+
+	fl, ok := f.floors[room]
+	if !ok {
+		fl = &floor{}
+		f.floors[room] = fl
+	}
+
+	if fl.index(clientID) >= 0 {
+		return true, nil
+	}
+
+	if len(fl.speakers) >= f.config.MaxSpeakers() {
+		return false, nil
+	}
+
+	speaker := &floorSpeaker{clientID: clientID}
+	if f.config.MaxHoldTime > 0 {
+		speaker.timer = time.AfterFunc(f.config.MaxHoldTime, func() {
+			f.expire(room, speaker)
+		})
+	}
+	fl.speakers = append(fl.speakers, speaker)
+
+	f.log.Printf("[%s] Granted floor of room: %s", clientID, room)
+	f.notify(room)
+	return true, nil
+}
+
+// Release releases the floor held by clientID. It is not an error when
+// clientID does not hold it.
+func (f *floorControl) Release(clientID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	room, ok := f.rooms[clientID]
+	if !ok {
+		return ErrClientNotFound
+	}
+
+	f.release(room, clientID)
+	return nil
+}
+
+// Allowed returns true when the audio of clientID is forwarded.
+func (f *floorControl) Allowed(clientID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	room := f.rooms[clientID]
+	if !f.config.IsPushToTalkRoom(room) {
+		return true
+	}
+
+	fl, ok := f.floors[room]
+	return ok && fl.index(clientID) >= 0
+}
+
+// Speakers returns the peers holding the floor of room.
+func (f *floorControl) Speakers(room string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.speakers(room)
+}
+
+// SetRoom sets the room of clientID. The floor of its previous room is
+// released.
+func (f *floorControl) SetRoom(clientID string, room string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if previousRoom, ok := f.rooms[clientID]; ok {
+		if previousRoom == room {
+			return
+		}
+		f.release(previousRoom, clientID)
+	}
+
+	f.rooms[clientID] = room
+
+	if f.config.IsPushToTalkRoom(room) {
+		// tells the joining peer who holds the floor
+		f.notify(room)
+	}
+}
+
+// Remove releases the floor held by clientID and forgets its room.
+func (f *floorControl) Remove(clientID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if room, ok := f.rooms[clientID]; ok {
+		f.release(room, clientID)
+		delete(f.rooms, clientID)
+	}
+}
+
+func (f *floorControl) expire(room string, speaker *floorSpeaker) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fl, ok := f.floors[room]
+	if !ok {
+		return
+	}
+
+	// the floor might have been released and granted again in the meantime
+	for _, s := range fl.speakers {
+		if s == speaker {
+			f.log.Printf("[%s] Floor of room: %s held for too long", speaker.clientID, room)
+			f.release(room, speaker.clientID)
+			return
+		}
+	}
+}
+
+// release must be called with f.mu held.
+func (f *floorControl) release(room string, clientID string) {
+	fl, ok := f.floors[room]
+	if !ok {
+		return
+	}
+
+	i := fl.index(clientID)
+	if i < 0 {
+		return
+	}
+
+	if timer := fl.speakers[i].timer; timer != nil {
+		timer.Stop()
+	}
+	fl.speakers = append(fl.speakers[:i], fl.speakers[i+1:]...)
+	if len(fl.speakers) == 0 {
+		delete(f.floors, room)
+	}
+
+	f.log.Printf("[%s] Released floor of room: %s", clientID, room)
+	f.notify(room)
+}
+
+// speakers must be called with f.mu held.
+func (f *floorControl) speakers(room string) []string {
+	fl, ok := f.floors[room]
+	if !ok {
+		return []string{}
+	}
+
+	speakers := make([]string, len(fl.speakers))
+	for i, s := range fl.speakers {
+		speakers[i] = s.clientID
+	}
+	return speakers
+}
+
+// notify must be called with f.mu held.
+func (f *floorControl) notify(room string) {
+	if f.notifications == nil {
+		return
+	}
+
+	f.notifications <- floorNotification{
+		room:     room,
+		speakers: f.speakers(room),
+	}
+}
+
+func (fl *floor) index(clientID string) int {
+	for i, s := range fl.speakers {
+		if s.clientID == clientID {
+			return i
+		}
+	}
+	return -1
+}
+
+var _ InterceptorFactory = &floorControl{}
+
+// NewInterceptor gates audio tracks by the floor of their publisher's room.
+// The room is looked up for every packet, since peers can be moved to other
+// rooms while publishing.
+func (f *floorControl) NewInterceptor(params InterceptorParams) (Interceptor, error) {
+	if params.LocalTrack.Kind() != webrtc.RTPCodecTypeAudio {
+		return NoOpInterceptor{}, nil
+	}
+	return &floorGate{floor: f, clientID: params.ClientID}, nil
+}
+
+// floorGate drops the audio packets of peers which do not hold the floor.
+type floorGate struct {
+	NoOpInterceptor
+
+	floor    *floorControl
+	clientID string
+}
+
+func (g *floorGate) BindRTP(next RTPWriter) RTPWriter {
+	return RTPWriterFunc(func(packet *rtp.Packet) error {
+		if !g.floor.Allowed(g.clientID) {
+			return nil
+		}
+		return next.WriteRTP(packet)
+	})
+}
+
+// SendFloor sends the peers holding the floor of room to all clients in the
+// room on this instance.
+func (wss *WSS) SendFloor(room string, speakers []string) {
+	wss.sendLocal(NewMessage(MessageTypeFloor, room, map[string]interface{}{
+		"speakers": speakers,
+	}))
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server/logger"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFloorControl(t *testing.T) {
+	log := logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout).GetLogger("floor")
+	f := newFloorControl(log, PushToTalkConfig{
+		Rooms:    []string{"radio"},
+		Speakers: 1,
+	})
+
+	type change struct {
+		room     string
+		speakers []string
+	}
+	changes := make(chan change, 10)
+	f.OnChanged(func(room string, speakers []string) {
+		changes <- change{room, speakers}
+	})
+	nextChange := func() change {
+		t.Helper()
+		select {
+		case c := <-changes:
+			return c
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for floor change")
+			return change{}
+		}
+	}
+
+	_, err := f.Request("a")
+	assert.Equal(t, ErrClientNotFound, err)
+
+	f.SetRoom("a", "radio")
+	assert.Equal(t, change{"radio", []string{}}, nextChange())
+	f.SetRoom("b", "radio")
+	assert.Equal(t, change{"radio", []string{}}, nextChange())
+	f.SetRoom("c", "other")
+
+	assert.False(t, f.Allowed("a"))
+	assert.True(t, f.Allowed("c"), "not a push-to-talk room")
+
+	_, err = f.Request("c")
+	assert.Equal(t, ErrNotPushToTalkRoom, err)
+
+	granted, err := f.Request("a")
+	require.NoError(t, err)
+	assert.True(t, granted)
+	assert.Equal(t, change{"radio", []string{"a"}}, nextChange())
+	assert.True(t, f.Allowed("a"))
+
+	granted, err = f.Request("a")
+	require.NoError(t, err)
+	assert.True(t, granted, "already holding the floor")
+
+	granted, err = f.Request("b")
+	require.NoError(t, err)
+	assert.False(t, granted)
+	assert.False(t, f.Allowed("b"))
+
+	require.NoError(t, f.Release("a"))
+	assert.Equal(t, change{"radio", []string{}}, nextChange())
+	assert.False(t, f.Allowed("a"))
+
+	granted, err = f.Request("b")
+	require.NoError(t, err)
+	assert.True(t, granted)
+	assert.Equal(t, change{"radio", []string{"b"}}, nextChange())
+
+	f.SetRoom("b", "other")
+	assert.Equal(t, change{"radio", []string{}}, nextChange(), "moving releases the floor")
+	assert.True(t, f.Allowed("b"))
+
+	granted, err = f.Request("a")
+	require.NoError(t, err)
+	assert.True(t, granted)
+	assert.Equal(t, change{"radio", []string{"a"}}, nextChange())
+	f.Remove("a")
+	assert.Equal(t, change{"radio", []string{}}, nextChange())
+	assert.Empty(t, f.floors)
+}
+
+func TestFloorControl_MaxHoldTime(t *testing.T) {
+	log := logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout).GetLogger("floor")
+	f := newFloorControl(log, PushToTalkConfig{
+		Rooms:       []string{"radio"},
+		Speakers:    2,
+		MaxHoldTime: 20 * time.Millisecond,
+	})
+	f.SetRoom("a", "radio")
+	f.SetRoom("b", "radio")
+
+	for _, clientID := range []string{"a", "b"} {
+		granted, err := f.Request(clientID)
+		require.NoError(t, err)
+		assert.True(t, granted)
+	}
+	assert.Equal(t, []string{"a", "b"}, f.Speakers("radio"))
+
+	assert.Eventually(t, func() bool {
+		return len(f.Speakers("radio")) == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestFloorGate(t *testing.T) {
+	log := logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout).GetLogger("floor")
+	f := newFloorControl(log, PushToTalkConfig{
+		Rooms: []string{"radio"},
+	})
+	f.SetRoom("a", "radio")
+
+	video, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeVP8, 1, "video", "stream", webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000))
+	require.NoError(t, err)
+	interceptor, err := f.NewInterceptor(InterceptorParams{ClientID: "a", Room: "radio", LocalTrack: video})
+	require.NoError(t, err)
+	assert.Equal(t, NoOpInterceptor{}, interceptor)
+
+	audio, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeOpus, 2, "audio", "stream", webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000))
+	require.NoError(t, err)
+	interceptor, err = f.NewInterceptor(InterceptorParams{ClientID: "a", Room: "radio", LocalTrack: audio})
+	require.NoError(t, err)
+
+	var written int
+	writer := interceptor.BindRTP(RTPWriterFunc(func(packet *rtp.Packet) error {
+		written++
+		return nil
+	}))
+
+	require.NoError(t, writer.WriteRTP(&rtp.Packet{}))
+	assert.Equal(t, 0, written)
+
+	_, err = f.Request("a")
+	require.NoError(t, err)
+	require.NoError(t, writer.WriteRTP(&rtp.Packet{}))
+	assert.Equal(t, 1, written)
+}
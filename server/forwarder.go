@@ -0,0 +1,202 @@
+package server
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/rtp"
+)
+
+// forwardingQueueSize is the number of packets queued on each worker of a
+// ForwardingPool. Readers block when the queue of their worker is full.
+const forwardingQueueSize = 256
+
+var ErrForwardingPoolClosed = errors.New("forwarding pool closed")
+
+// ForwardingPool writes packets of published tracks to their interceptor
+// chains on a fixed number of worker goroutines, instead of on the goroutine
+// of each track which reads them.
+//
+// Reading from a remote track blocks, so each track still has a goroutine
+// reading its packets, but it is parked while waiting for them. The work of
+// forwarding, running interceptors and encrypting packets for every
+// subscriber, is done by at most as many goroutines as there are workers,
+// which keeps scheduling overhead predictable with hundreds of tracks.
+// Packets of a track are always written by the same worker, in order.
+type ForwardingPool struct {
+	shards []*forwardingShard
+
+	closeChannel chan struct{}
+	closeOnce    sync.Once
+}
+
+type forwardingShard struct {
+	jobs          chan forwardingJob
+	maxQueueDepth int64
+	processed     uint64
+}
+
+// forwardingJob writes packet to the chain of track. A job without a packet
+// marks the end of the track.
+type forwardingJob struct {
+	track  *ForwardedTrack
+	packet *rtp.Packet
+}
+
+func NewForwardingPool(workers int) *ForwardingPool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	f := &ForwardingPool{
+		shards:       make([]*forwardingShard, workers),
+		closeChannel: make(chan struct{}),
+	}
+
+	for i := range f.shards {
+		f.shards[i] = &forwardingShard{
+			jobs: make(chan forwardingJob, forwardingQueueSize),
+		}
+		go f.work(f.shards[i])
+	}
+
+	return f
+}
+
+// Track returns the writer of packets of the track with trackID to writer.
+// The returned ForwardedTrack must be closed when the track ends.
+func (f *ForwardingPool) Track(trackID string, writer RTPWriter) *ForwardedTrack {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(trackID))
+
+	return &ForwardedTrack{
+		pool:   f,
+		shard:  f.shards[h.Sum32()%uint32(len(f.shards))],
+		writer: writer,
+		failed: make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+}
+
+// Stats returns a snapshot of the state of all workers.
+func (f *ForwardingPool) Stats() []ShardStats {
+	stats := make([]ShardStats, len(f.shards))
+	for i, shard := range f.shards {
+		stats[i] = ShardStats{
+			Shard:         i,
+			QueueDepth:    len(shard.jobs),
+			MaxQueueDepth: int(atomic.LoadInt64(&shard.maxQueueDepth)),
+			Processed:     atomic.LoadUint64(&shard.processed),
+		}
+	}
+	return stats
+}
+
+// Close stops all workers. Queued packets are not written.
+func (f *ForwardingPool) Close() {
+	f.closeOnce.Do(func() {
+		close(f.closeChannel)
+	})
+}
+
+func (f *ForwardingPool) work(shard *forwardingShard) {
+	for {
+		select {
+		case job := <-shard.jobs:
+			atomic.AddUint64(&shard.processed, 1)
+			job.track.write(job.packet)
+		case <-f.closeChannel:
+			return
+		}
+	}
+}
+
+// ForwardedTrack writes packets of a single track to its worker.
+type ForwardedTrack struct {
+	pool   *ForwardingPool
+	shard  *forwardingShard
+	writer RTPWriter
+
+	// failed is closed when writing a packet failed, err is the error
+	failed     chan struct{}
+	failedOnce sync.Once
+	err        error
+	// closed is closed when the worker processed all packets of the track
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var _ RTPWriter = &ForwardedTrack{}
+
+// WriteRTP queues packet to be written by the worker of the track. It blocks
+// while the queue of the worker is full. Returns the error of a previous
+// write which failed, after which no more packets are written.
+func (t *ForwardedTrack) WriteRTP(packet *rtp.Packet) error {
+	if err := t.send(forwardingJob{track: t, packet: packet}); err != nil {
+		return err
+	}
+
+	depth := int64(len(t.shard.jobs))
+	if depth > atomic.LoadInt64(&t.shard.maxQueueDepth) {
+		atomic.StoreInt64(&t.shard.maxQueueDepth, depth)
+	}
+	return nil
+}
+
+func (t *ForwardedTrack) send(job forwardingJob) error {
+	select {
+	case <-t.failed:
+		return t.err
+	case <-t.pool.closeChannel:
+		return ErrForwardingPoolClosed
+	default:
+	}
+
+	select {
+	case t.shard.jobs <- job:
+		return nil
+	case <-t.failed:
+		return t.err
+	case <-t.pool.closeChannel:
+		return ErrForwardingPoolClosed
+	}
+}
+
+// Close waits until the worker has processed all queued packets of the
+// track, so that its writer can be closed safely.
+func (t *ForwardedTrack) Close() {
+	t.closeOnce.Do(func() {
+		if err := t.send(forwardingJob{track: t}); err != nil {
+			// nothing is written after a failure or after the pool was closed
+			close(t.closed)
+			return
+		}
+
+		select {
+		case <-t.closed:
+		case <-t.pool.closeChannel:
+		}
+	})
+}
+
+func (t *ForwardedTrack) write(packet *rtp.Packet) {
+	if packet == nil {
+		close(t.closed)
+		return
+	}
+
+	select {
+	case <-t.failed:
+		return
+	default:
+	}
+
+	if err := t.writer.WriteRTP(packet); err != nil {
+		t.failedOnce.Do(func() {
+			t.err = err
+			close(t.failed)
+		})
+	}
+}
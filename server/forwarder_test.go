@@ -0,0 +1,107 @@
+package server_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingRTPWriter struct {
+	mu      sync.Mutex
+	seqs    []uint16
+	failSeq uint16
+	delay   time.Duration
+}
+
+func (w *recordingRTPWriter) WriteRTP(packet *rtp.Packet) error {
+	time.Sleep(w.delay)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failSeq != 0 && packet.SequenceNumber == w.failSeq {
+		return errors.New("write failed")
+	}
+	w.seqs = append(w.seqs, packet.SequenceNumber)
+	return nil
+}
+
+func (w *recordingRTPWriter) written() []uint16 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]uint16{}, w.seqs...)
+}
+
+func TestForwardingPool_order(t *testing.T) {
+	pool := server.NewForwardingPool(4)
+	defer pool.Close()
+
+	writers := []*recordingRTPWriter{{}, {}, {}}
+	var tracks []*server.ForwardedTrack
+	for i, writer := range writers {
+		tracks = append(tracks, pool.Track(string(rune('a'+i)), writer))
+	}
+
+	var expected []uint16
+	for seq := uint16(1); seq <= 500; seq++ {
+		expected = append(expected, seq)
+		for _, track := range tracks {
+			require.NoError(t, track.WriteRTP(&rtp.Packet{Header: rtp.Header{SequenceNumber: seq}}))
+		}
+	}
+
+	for i, track := range tracks {
+		track.Close()
+		assert.Equal(t, expected, writers[i].written(), "all packets are written in order before Close returns")
+	}
+
+	var processed uint64
+	for _, stats := range pool.Stats() {
+		processed += stats.Processed
+	}
+	assert.Equal(t, uint64(3*500+3), processed)
+}
+
+func TestForwardingPool_writeError(t *testing.T) {
+	pool := server.NewForwardingPool(1)
+	defer pool.Close()
+
+	writer := &recordingRTPWriter{failSeq: 2}
+	track := pool.Track("a", writer)
+
+	require.NoError(t, track.WriteRTP(&rtp.Packet{Header: rtp.Header{SequenceNumber: 1}}))
+	require.NoError(t, track.WriteRTP(&rtp.Packet{Header: rtp.Header{SequenceNumber: 2}}))
+
+	var err error
+	timeout := time.After(time.Second)
+	for seq := uint16(3); err == nil; seq++ {
+		select {
+		case <-timeout:
+			t.Fatal("timed out waiting for write error")
+		default:
+		}
+		err = track.WriteRTP(&rtp.Packet{Header: rtp.Header{SequenceNumber: seq}})
+	}
+	assert.EqualError(t, err, "write failed")
+
+	track.Close()
+	assert.Equal(t, []uint16{1}, writer.written(), "nothing is written after a failed write")
+}
+
+func TestForwardingPool_close(t *testing.T) {
+	pool := server.NewForwardingPool(1)
+
+	writer := &recordingRTPWriter{delay: 10 * time.Millisecond}
+	track := pool.Track("a", writer)
+	require.NoError(t, track.WriteRTP(&rtp.Packet{Header: rtp.Header{SequenceNumber: 1}}))
+
+	pool.Close()
+	track.Close()
+
+	assert.Equal(t, server.ErrForwardingPoolClosed, track.WriteRTP(&rtp.Packet{}))
+}
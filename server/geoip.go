@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidGeoIP = errors.New("invalid geoip database")
+
+// GeoIP looks up the country of IP addresses.
+type GeoIP interface {
+	// Country returns the ISO 3166-1 alpha-2 code of the country ip is
+	// located in.
+	Country(ip net.IP) (string, bool)
+}
+
+// CSVGeoIP is a GeoIP database read from CSV, with either a network and a
+// country per line, for example:
+//
+//	2.16.0.0/13,FR
+//
+// or the first and last address of a range and a country, like the DB-IP
+// Lite country database:
+//
+//	1.0.0.0,1.0.0.255,AU
+//
+// Networks must not overlap.
+type CSVGeoIP struct {
+	// ranges are sorted by start
+	ranges []geoIPRange
+}
+
+var _ GeoIP = &CSVGeoIP{}
+
+type geoIPRange struct {
+	start   net.IP
+	end     net.IP
+	country string
+}
+
+// OpenGeoIPCSV reads a CSVGeoIP from filename.
+func OpenGeoIPCSV(filename string) (*CSVGeoIP, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadGeoIPCSV(f)
+}
+
+// ReadGeoIPCSV reads a CSVGeoIP from r. A header line and lines starting
+// with # are skipped.
+func ReadGeoIPCSV(r io.Reader) (*CSVGeoIP, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	geoIP := &CSVGeoIP{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidGeoIP, err)
+		}
+
+		ipRange, err := parseGeoIPRange(record)
+		if err != nil {
+			if line == 1 {
+				// header
+				continue
+			}
+			return nil, fmt.Errorf("%w: line %d: %s", ErrInvalidGeoIP, line, err)
+		}
+		geoIP.ranges = append(geoIP.ranges, ipRange)
+	}
+
+	sort.Slice(geoIP.ranges, func(i, j int) bool {
+		return bytes.Compare(geoIP.ranges[i].start, geoIP.ranges[j].start) < 0
+	})
+	return geoIP, nil
+}
+
+func parseGeoIPRange(record []string) (r geoIPRange, err error) {
+	switch len(record) {
+	case 2:
+		_, network, err := net.ParseCIDR(strings.TrimSpace(record[0]))
+		if err != nil {
+			return r, err
+		}
+		r.start = network.IP.To16()
+		r.end = make(net.IP, net.IPv6len)
+		// IPv4 addresses are mapped into IPv6
+		ones, bits := network.Mask.Size()
+		mask := net.CIDRMask(ones+8*net.IPv6len-bits, 8*net.IPv6len)
+		for i := range r.end {
+			r.end[i] = r.start[i] | ^mask[i]
+		}
+	case 3:
+		r.start = net.ParseIP(strings.TrimSpace(record[0])).To16()
+		r.end = net.ParseIP(strings.TrimSpace(record[1])).To16()
+		if r.start == nil || r.end == nil {
+			return r, fmt.Errorf("invalid range: %s - %s", record[0], record[1])
+		}
+	default:
+		return r, fmt.Errorf("expected 2 or 3 fields, got %d", len(record))
+	}
+
+	r.country = strings.ToUpper(strings.TrimSpace(record[len(record)-1]))
+	if len(r.country) != 2 {
+		return r, fmt.Errorf("invalid country: %q", r.country)
+	}
+	return r, nil
+}
+
+// Country returns the country of the range containing ip.
+func (g *CSVGeoIP) Country(ip net.IP) (string, bool) {
+	ip = ip.To16()
+	if ip == nil {
+		return "", false
+	}
+
+	// index of the first range starting after ip
+	i := sort.Search(len(g.ranges), func(i int) bool {
+		return bytes.Compare(g.ranges[i].start, ip) > 0
+	})
+	if i == 0 {
+		return "", false
+	}
+
+	r := g.ranges[i-1]
+	if bytes.Compare(ip, r.end) > 0 {
+		return "", false
+	}
+	return r.country, true
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+var errGRPCInsecure = errors.New("refusing to send credentials without TLS")
+
+// GRPCServerOptions returns the options of a gRPC server serving TLS with
+// the certificate and key of config. Calls to the gRPC services carry
+// credentials, so an error is returned when no certificate is set, unless
+// insecure is true.
+func GRPCServerOptions(config TLSConfig, insecure bool) ([]grpc.ServerOption, error) {
+	if config.Cert == "" && config.Key == "" {
+		if !insecure {
+			return nil, fmt.Errorf("[grpc] %w: set a TLS certificate or allow insecure connections", errGRPCInsecure)
+		}
+		return nil, nil
+	}
+
+	creds, err := credentials.NewServerTLSFromFile(config.Cert, config.Key)
+	if err != nil {
+		return nil, fmt.Errorf("[grpc] Error loading TLS certificate: %w", err)
+	}
+	return []grpc.ServerOption{grpc.Creds(creds)}, nil
+}
+
+// DialGRPC connects to a gRPC service. Endpoints starting with grpcs:// are
+// connected to with TLS, verifying the certificate of the service with the
+// CA certificates in the PEM file ca, or the system roots when ca is empty.
+// Endpoints starting with grpc://, or without a scheme, are connected to
+// without TLS, which is refused when secret is set, unless insecure is true.
+func DialGRPC(endpoint string, ca string, secret string, insecure bool) (*grpc.ClientConn, error) {
+	if target := strings.TrimPrefix(endpoint, "grpcs://"); target != endpoint {
+		config := &tls.Config{}
+		if ca != "" {
+			pem, err := ioutil.ReadFile(ca)
+			if err != nil {
+				return nil, fmt.Errorf("[grpc] Error reading CA certificates: %w", err)
+			}
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("[grpc] No CA certificates found in %s", ca)
+			}
+		}
+		return grpc.Dial(target, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	}
+
+	if ca != "" {
+		return nil, fmt.Errorf("[grpc] CA certificates are only used with grpcs:// endpoints: %s", endpoint)
+	}
+	if secret != "" && !insecure {
+		return nil, fmt.Errorf("[grpc] %w: use a grpcs:// endpoint or allow insecure connections", errGRPCInsecure)
+	}
+	return grpc.Dial(strings.TrimPrefix(endpoint, "grpc://"), grpc.WithInsecure())
+}
+
+// IsGRPCEndpoint returns true when endpoint is the URL of a gRPC service.
+func IsGRPCEndpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "grpc://") || strings.HasPrefix(endpoint, "grpcs://")
+}
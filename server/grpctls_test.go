@@ -0,0 +1,114 @@
+package server_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 and
+// its key to dir.
+func writeTestCertificate(t *testing.T, dir string) server.TLSConfig {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "peer-calls"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	config := server.TLSConfig{
+		Cert: filepath.Join(dir, "cert.pem"),
+		Key:  filepath.Join(dir, "key.pem"),
+	}
+	err = ioutil.WriteFile(config.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(config.Key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	require.NoError(t, err)
+	return config
+}
+
+func TestGRPCServerOptions_insecure(t *testing.T) {
+	_, err := server.GRPCServerOptions(server.TLSConfig{}, false)
+	assert.Error(t, err)
+
+	opts, err := server.GRPCServerOptions(server.TLSConfig{}, true)
+	assert.NoError(t, err)
+	assert.Empty(t, opts)
+}
+
+func TestDialGRPC_insecure(t *testing.T) {
+	_, err := server.DialGRPC("grpc://127.0.0.1:1", "", "secret", false)
+	assert.Error(t, err)
+	_, err = server.DialGRPC("127.0.0.1:1", "", "secret", false)
+	assert.Error(t, err)
+	_, err = server.DialGRPC("grpc://127.0.0.1:1", "ca.pem", "", false)
+	assert.Error(t, err)
+
+	for _, endpoint := range []string{"127.0.0.1:1", "grpc://127.0.0.1:1"} {
+		conn, err := server.DialGRPC(endpoint, "", "", false)
+		require.NoError(t, err)
+		conn.Close()
+		conn, err = server.DialGRPC(endpoint, "", "secret", true)
+		require.NoError(t, err)
+		conn.Close()
+	}
+}
+
+func TestDialGRPC_TLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grpctls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config := writeTestCertificate(t, dir)
+
+	opts, err := server.GRPCServerOptions(config, false)
+	require.NoError(t, err)
+	rpc := grpc.NewServer(opts...)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go rpc.Serve(l)
+	defer rpc.Stop()
+
+	invoke := func(ca string) codes.Code {
+		conn, err := server.DialGRPC("grpcs://"+l.Addr().String(), ca, "secret", false)
+		require.NoError(t, err)
+		defer conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		err = conn.Invoke(ctx, "/peercalls.Test/Call", &server.AdminMutePeerResponse{}, &server.AdminMutePeerResponse{})
+		return status.Code(err)
+	}
+
+	// the server has no services, so calls reaching it are unimplemented
+	assert.Equal(t, codes.Unimplemented, invoke(config.Cert))
+	// the certificate is not trusted by the system roots
+	assert.Equal(t, codes.Unavailable, invoke(""))
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// handoverTimeout is the time to wait for the new instance to confirm
+	// that it is accepting connections on the passed listener.
+	handoverTimeout = 10 * time.Second
+	// drainPollInterval is the interval at which Drain checks whether all
+	// clients have left.
+	drainPollInterval = 100 * time.Millisecond
+)
+
+// handoverAck is written by the new instance once it has taken over the
+// listener.
+const handoverAck byte = 1
+
+var errHandoverUnsupported = errors.New("listener handover is not supported on this platform")
+
+// Handover passes the listening socket of a running instance to a new one
+// over a unix socket, so that a single node can be upgraded without
+// refusing connections. The old instance drains afterwards: it stops
+// accepting connections and waits for the clients in its rooms to leave.
+type Handover struct {
+	log    Logger
+	config UpgradeConfig
+
+	mu       sync.Mutex
+	listener *net.UnixListener
+	closed   bool
+}
+
+// NewHandover creates a Handover using the socket path of config.
+func NewHandover(loggerFactory LoggerFactory, config UpgradeConfig) *Handover {
+	return &Handover{
+		log:    loggerFactory.GetLogger("handover"),
+		config: config,
+	}
+}
+
+// Listen returns a listener for addr. When a previous instance accepts
+// handovers on the socket path, its listener is taken over. Otherwise a new
+// one is created, with SO_REUSEPORT when configured.
+func (h *Handover) Listen(addr string) (net.Listener, error) {
+	if h.config.SocketPath != "" {
+		conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: h.config.SocketPath, Net: "unix"})
+		if err == nil {
+			defer conn.Close()
+			return h.takeOver(conn)
+		}
+		h.log.Printf("No previous instance on %s, listening on %s", h.config.SocketPath, addr)
+	}
+
+	return listen(addr, h.config.ReusePort)
+}
+
+func (h *Handover) takeOver(conn *net.UnixConn) (net.Listener, error) {
+	if err := conn.SetDeadline(time.Now().Add(handoverTimeout)); err != nil {
+		return nil, fmt.Errorf("handover: %w", err)
+	}
+
+	l, err := receiveListener(conn)
+	if err != nil {
+		return nil, fmt.Errorf("handover: receive listener: %w", err)
+	}
+
+	if _, err := conn.Write([]byte{handoverAck}); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("handover: confirm: %w", err)
+	}
+
+	h.log.Printf("Took over listener on %s from previous instance", l.Addr())
+	return l, nil
+}
+
+// Serve accepts new instances on the socket path and passes l to the first
+// one confirming that it has taken it over. It returns nil after the
+// handover, after which this instance should drain, and an error when the
+// socket could not be created or Close was called.
+func (h *Handover) Serve(l net.Listener) error {
+	// A previous instance would have been taken over by Listen, so the
+	// socket is stale or being closed.
+	if err := os.Remove(h.config.SocketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("handover: remove stale socket: %w", err)
+	}
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: h.config.SocketPath, Net: "unix"})
+	if err != nil {
+		return fmt.Errorf("handover: %w", err)
+	}
+	// The next instance creates the socket again before this one closes it.
+	listener.SetUnlinkOnClose(false)
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		listener.Close()
+		return fmt.Errorf("handover: closed")
+	}
+	h.listener = listener
+	h.mu.Unlock()
+
+	defer listener.Close()
+
+	h.log.Printf("Accepting handovers on %s", h.config.SocketPath)
+
+	for {
+		conn, err := listener.AcceptUnix()
+		if err != nil {
+			return fmt.Errorf("handover: %w", err)
+		}
+
+		err = h.handOver(conn, l)
+		conn.Close()
+		if err != nil {
+			h.log.Errorf("Handover failed, still accepting connections: %s", err)
+			continue
+		}
+
+		h.log.Printf("Handed over listener on %s", l.Addr())
+		return nil
+	}
+}
+
+func (h *Handover) handOver(conn *net.UnixConn, l net.Listener) error {
+	if err := conn.SetDeadline(time.Now().Add(handoverTimeout)); err != nil {
+		return err
+	}
+
+	if err := sendListener(conn, l); err != nil {
+		return fmt.Errorf("send listener: %w", err)
+	}
+
+	ack := make([]byte, 1)
+	if _, err := conn.Read(ack); err != nil {
+		return fmt.Errorf("read confirmation: %w", err)
+	}
+	if ack[0] != handoverAck {
+		return fmt.Errorf("unexpected confirmation: %d", ack[0])
+	}
+	return nil
+}
+
+// Close stops accepting handovers.
+func (h *Handover) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	if h.listener == nil {
+		return nil
+	}
+	return h.listener.Close()
+}
+
+// Drain waits until all clients have left or ctx is done. It returns the
+// number of clients still connected.
+func (wss *WSS) Drain(ctx context.Context) int {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		wss.connectionsMu.Lock()
+		count := 0
+		for _, clients := range wss.connections {
+			count += len(clients)
+		}
+		wss.connectionsMu.Unlock()
+
+		if count == 0 {
+			return 0
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return count
+		}
+	}
+}
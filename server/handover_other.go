@@ -0,0 +1,25 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package server
+
+import (
+	"fmt"
+	"net"
+)
+
+// listen listens on addr. SO_REUSEPORT is not supported.
+func listen(addr string, reusePort bool) (net.Listener, error) {
+	if reusePort {
+		return nil, fmt.Errorf("listen: SO_REUSEPORT is not supported on this platform")
+	}
+	return net.Listen("tcp", addr)
+}
+
+func sendListener(conn *net.UnixConn, l net.Listener) error {
+	return errHandoverUnsupported
+}
+
+func receiveListener(conn *net.UnixConn) (net.Listener, error) {
+	return nil, errHandoverUnsupported
+}
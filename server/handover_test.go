@@ -0,0 +1,114 @@
+package server_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestHandover(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("listener handover is not supported on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "handover")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config := server.UpgradeConfig{SocketPath: filepath.Join(dir, "upgrade.sock")}
+
+	oldInstance := server.NewHandover(loggerFactory, config)
+	defer oldInstance.Close()
+	l1, err := oldInstance.Listen("127.0.0.1:0")
+	require.NoError(t, err, "no previous instance")
+	defer l1.Close()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- oldInstance.Serve(l1)
+	}()
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(config.SocketPath)
+		return err == nil
+	}, timeout, 10*time.Millisecond)
+
+	newInstance := server.NewHandover(loggerFactory, config)
+	defer newInstance.Close()
+	l2, err := newInstance.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer l2.Close()
+	assert.Equal(t, l1.Addr().String(), l2.Addr().String())
+	require.NoError(t, <-served)
+
+	// the old instance stops accepting, the new one keeps the port open
+	l1.Close()
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := l2.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+	conn, err := net.Dial("tcp", l2.Addr().String())
+	require.NoError(t, err)
+	conn.Close()
+	require.NoError(t, <-accepted)
+}
+
+func TestHandover_ReusePort(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SO_REUSEPORT is not supported on windows")
+	}
+
+	handover := server.NewHandover(loggerFactory, server.UpgradeConfig{ReusePort: true})
+	l1, err := handover.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer l1.Close()
+	l2, err := handover.Listen(l1.Addr().String())
+	require.NoError(t, err, "second instance binds to the same port")
+	defer l2.Close()
+
+	_, err = server.NewHandover(loggerFactory, server.UpgradeConfig{}).Listen(l1.Addr().String())
+	assert.Error(t, err, "port in use without SO_REUSEPORT")
+}
+
+func TestWSS_Drain(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	wss := server.NewWSS(loggerFactory, rooms)
+	s := httptest.NewServer(server.NewMeshHandler(loggerFactory, wss))
+	defer s.Close()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + room + "/"
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	assert.Equal(t, 0, wss.Drain(ctx), "no clients")
+
+	ws := mustDialWS(t, ctx, wsURL+"a")
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	require.Eventually(t, func() bool {
+		_, ok := wss.Role(room, "a")
+		return ok
+	}, timeout, 10*time.Millisecond)
+
+	drainCtx, drainCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer drainCancel()
+	assert.Equal(t, 1, wss.Drain(drainCtx), "timeout")
+
+	ws.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, 0, wss.Drain(ctx), "client left")
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// URIs of RTP header extensions negotiated with peers.
+const (
+	HeaderExtensionAbsSendTime = "http://www.webrtc.org/experiments/rtp-hdrext/abs-send-time"
+	HeaderExtensionTransportCC = "http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01"
+	HeaderExtensionMID         = "urn:ietf:params:rtp-hdrext:sdes:mid"
+	HeaderExtensionAudioLevel  = "urn:ietf:params:rtp-hdrext:ssrc-audio-level"
+)
+
+// IDs of header extensions in offers sent by the server. The server is
+// always the initiator, so peers answer with the same IDs and packets
+// received from publishers use them too. The transport-cc ID is the one pion
+// uses for codecs with transport-cc feedback.
+const (
+	headerExtensionIDAudioLevel  = 1
+	headerExtensionIDAbsSendTime = 2
+	headerExtensionIDTransportCC = 3
+	headerExtensionIDMID         = 4
+)
+
+var headerExtensions = []struct {
+	id  int
+	uri string
+	// audioOnly extensions are only offered in audio media descriptions
+	audioOnly bool
+}{
+	{headerExtensionIDAudioLevel, HeaderExtensionAudioLevel, true},
+	{headerExtensionIDAbsSendTime, HeaderExtensionAbsSendTime, false},
+	{headerExtensionIDTransportCC, HeaderExtensionTransportCC, false},
+	{headerExtensionIDMID, HeaderExtensionMID, false},
+}
+
+// Profiles of one-byte and two-byte header extensions, RFC 8285.
+const (
+	headerExtensionProfileOneByte = 0xBEDE
+	headerExtensionProfileTwoByte = 0x1000
+	// the lower 4 bits of the two-byte profile are application bits
+	headerExtensionProfileTwoByteMask = 0xFFF0
+)
+
+// SetSDPHeaderExtensions adds the extmap attributes of the negotiated header
+// extensions to audio and video media descriptions of sessionDescription
+// which are not rejected. Extensions which are already present are kept.
+func SetSDPHeaderExtensions(sessionDescription string) string {
+	lines := strings.Split(strings.ReplaceAll(sessionDescription, "\r\n", "\n"), "\n")
+	result := make([]string, 0, len(lines)+len(headerExtensions)*4)
+
+	// media is true in an audio or video media description which accepts
+	// media, present are the URIs of its extmap attributes
+	media := false
+	audio := false
+	present := map[string]struct{}{}
+	flush := func() {
+		if !media {
+			return
+		}
+		for _, ext := range headerExtensions {
+			if ext.audioOnly && !audio {
+				continue
+			}
+			if _, ok := present[ext.uri]; !ok {
+				result = append(result, "a=extmap:"+strconv.Itoa(ext.id)+" "+ext.uri)
+			}
+		}
+	}
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "m=") || line == "" {
+			flush()
+			fields := strings.Fields(line)
+			media = len(fields) > 1 &&
+				(fields[0] == "m=audio" || fields[0] == "m=video") &&
+				fields[1] != "0"
+			audio = media && fields[0] == "m=audio"
+			present = map[string]struct{}{}
+		}
+		if strings.HasPrefix(line, "a=extmap:") {
+			if fields := strings.Fields(line); len(fields) > 1 {
+				present[fields[1]] = struct{}{}
+			}
+		}
+		result = append(result, line)
+	}
+	flush()
+
+	return strings.Join(result, "\r\n")
+}
+
+// absSendTime returns t in the 6.18 fixed point format of the
+// abs-send-time header extension.
+func absSendTime(t time.Time) uint32 {
+	seconds := uint64(t.Unix()) & 0x3F
+	fraction := (uint64(t.Nanosecond()) << 18) / uint64(time.Second)
+	return uint32(seconds<<18 | fraction)
+}
+
+// rewriteHeaderExtensions returns the header extension payload of a packet
+// forwarded to subscribers. The mid and transport-cc extensions describe
+// the transport of the publisher, so they are removed. abs-send-time is set
+// to now so that subscribers estimate the bandwidth of their own path from
+// the server. Other extensions are kept. Returns false when the payload
+// cannot be parsed.
+func rewriteHeaderExtensions(profile uint16, payload []byte, now time.Time) ([]byte, bool) {
+	twoByte := profile&headerExtensionProfileTwoByteMask == headerExtensionProfileTwoByte
+	if profile != headerExtensionProfileOneByte && !twoByte {
+		return payload, true
+	}
+
+	result := make([]byte, 0, len(payload))
+	for i := 0; i < len(payload); {
+		// padding
+		if payload[i] == 0 {
+			i++
+			continue
+		}
+
+		var id, length, headerLength int
+		if twoByte {
+			if i+1 >= len(payload) {
+				return nil, false
+			}
+			id, length, headerLength = int(payload[i]), int(payload[i+1]), 2
+		} else {
+			id, length, headerLength = int(payload[i]>>4), int(payload[i]&0x0F)+1, 1
+			if id == 15 {
+				// reserved, processing stops
+				break
+			}
+		}
+
+		end := i + headerLength + length
+		if end > len(payload) {
+			return nil, false
+		}
+
+		switch id {
+		case headerExtensionIDMID, headerExtensionIDTransportCC:
+		case headerExtensionIDAbsSendTime:
+			element := append([]byte{}, payload[i:end]...)
+			if length == 3 {
+				var buf [4]byte
+				binary.BigEndian.PutUint32(buf[:], absSendTime(now))
+				copy(element[headerLength:], buf[1:])
+			}
+			result = append(result, element...)
+		default:
+			result = append(result, payload[i:end]...)
+		}
+		i = end
+	}
+
+	for len(result)%4 != 0 {
+		result = append(result, 0)
+	}
+	return result, true
+}
+
+// headerExtensionAudioLevel returns the level of the audio level header
+// extension, RFC 6464, in -dBov: 0 is the loudest and 127 is silence.
+// Returns false when the payload does not contain the extension or cannot be
+// parsed.
+func headerExtensionAudioLevel(profile uint16, payload []byte) (uint8, bool) {
+	twoByte := profile&headerExtensionProfileTwoByteMask == headerExtensionProfileTwoByte
+	if profile != headerExtensionProfileOneByte && !twoByte {
+		return 0, false
+	}
+
+	for i := 0; i < len(payload); {
+		if payload[i] == 0 {
+			i++
+			continue
+		}
+
+		var id, length, headerLength int
+		if twoByte {
+			if i+1 >= len(payload) {
+				return 0, false
+			}
+			id, length, headerLength = int(payload[i]), int(payload[i+1]), 2
+		} else {
+			id, length, headerLength = int(payload[i]>>4), int(payload[i]&0x0F)+1, 1
+			if id == 15 {
+				return 0, false
+			}
+		}
+
+		end := i + headerLength + length
+		if end > len(payload) {
+			return 0, false
+		}
+		if id == headerExtensionIDAudioLevel && length >= 1 {
+			// the most significant bit is the voice activity flag, which is
+			// not always set by senders
+			return payload[i+headerLength] & 0x7F, true
+		}
+		i = end
+	}
+
+	return 0, false
+}
+
+// HeaderExtensionRewriter rewrites the header extensions of packets
+// forwarded to subscribers.
+type HeaderExtensionRewriter struct {
+	// now is replaced in tests
+	now func() time.Time
+}
+
+var _ Interceptor = &HeaderExtensionRewriter{}
+
+func NewHeaderExtensionRewriterFactory() InterceptorFactory {
+	return InterceptorFactoryFunc(func(params InterceptorParams) (Interceptor, error) {
+		return &HeaderExtensionRewriter{now: time.Now}, nil
+	})
+}
+
+func (h *HeaderExtensionRewriter) BindRTP(next RTPWriter) RTPWriter {
+	return RTPWriterFunc(func(packet *rtp.Packet) error {
+		if !packet.Extension {
+			return next.WriteRTP(packet)
+		}
+
+		payload, ok := rewriteHeaderExtensions(packet.ExtensionProfile, packet.ExtensionPayload, h.now())
+		if !ok || len(payload) == 0 {
+			packet.Extension = false
+			packet.ExtensionProfile = 0
+			packet.ExtensionPayload = nil
+			return next.WriteRTP(packet)
+		}
+
+		packet.ExtensionPayload = payload
+		return next.WriteRTP(packet)
+	})
+}
+
+func (h *HeaderExtensionRewriter) BindRTCP(next RTCPWriter) RTCPWriter {
+	return next
+}
+
+func (h *HeaderExtensionRewriter) Close() error {
+	return nil
+}
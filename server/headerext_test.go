@@ -0,0 +1,136 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetSDPHeaderExtensions(t *testing.T) {
+	sdp := strings.Join([]string{
+		"v=0",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"a=mid:0",
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=mid:1",
+		"a=extmap:3 " + HeaderExtensionTransportCC,
+		"m=video 0 UDP/TLS/RTP/SAVPF 0",
+		"m=application 9 DTLS/SCTP 5000",
+		"a=mid:3",
+		"",
+	}, "\r\n")
+
+	assert.Equal(t, strings.Join([]string{
+		"v=0",
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111",
+		"a=mid:0",
+		"a=extmap:1 " + HeaderExtensionAudioLevel,
+		"a=extmap:2 " + HeaderExtensionAbsSendTime,
+		"a=extmap:3 " + HeaderExtensionTransportCC,
+		"a=extmap:4 " + HeaderExtensionMID,
+		"m=video 9 UDP/TLS/RTP/SAVPF 96",
+		"a=mid:1",
+		"a=extmap:3 " + HeaderExtensionTransportCC,
+		"a=extmap:2 " + HeaderExtensionAbsSendTime,
+		"a=extmap:4 " + HeaderExtensionMID,
+		"m=video 0 UDP/TLS/RTP/SAVPF 0",
+		"m=application 9 DTLS/SCTP 5000",
+		"a=mid:3",
+		"",
+	}, "\r\n"), SetSDPHeaderExtensions(sdp))
+}
+
+func TestHeaderExtensionAudioLevel(t *testing.T) {
+	level, ok := headerExtensionAudioLevel(headerExtensionProfileOneByte, []byte{
+		0x40, '1', // mid
+		0x10, 0x80 | 0x1E, // audio level with voice activity
+		0x00, 0x00, // padding
+	})
+	assert.True(t, ok)
+	assert.Equal(t, uint8(30), level)
+
+	level, ok = headerExtensionAudioLevel(headerExtensionProfileTwoByte, []byte{
+		0x01, 0x01, 0x7F, // audio level
+		0x00, // padding
+	})
+	assert.True(t, ok)
+	assert.Equal(t, uint8(127), level)
+
+	_, ok = headerExtensionAudioLevel(headerExtensionProfileOneByte, []byte{0x40, '1', 0x00, 0x00})
+	assert.False(t, ok)
+	_, ok = headerExtensionAudioLevel(headerExtensionProfileOneByte, []byte{0x11, 0x7F})
+	assert.False(t, ok)
+}
+
+func TestAbsSendTime(t *testing.T) {
+	assert.Equal(t, uint32(1<<18|1<<17), absSendTime(time.Unix(65, 500*int64(time.Millisecond))))
+}
+
+func TestRewriteHeaderExtensions_oneByte(t *testing.T) {
+	now := time.Unix(1, 0)
+	payload := []byte{
+		0x22, 0xAA, 0xBB, 0xCC, // abs-send-time
+		0x31, 0x00, 0x01, // transport-cc
+		0x40, '1', // mid
+		0x10, 0x7F, // audio level
+		0x00, // padding
+	}
+
+	result, ok := rewriteHeaderExtensions(headerExtensionProfileOneByte, payload, now)
+	require.True(t, ok)
+	assert.Equal(t, []byte{
+		0x22, 0x04, 0x00, 0x00,
+		0x10, 0x7F, 0x00, 0x00,
+	}, result)
+
+	_, ok = rewriteHeaderExtensions(headerExtensionProfileOneByte, []byte{0x22, 0xAA}, now)
+	assert.False(t, ok, "truncated element")
+}
+
+func TestRewriteHeaderExtensions_twoByte(t *testing.T) {
+	payload := []byte{
+		0x04, 0x01, '1', // mid
+		0x05, 0x00, // empty element
+		0x00, 0x00, 0x00, // padding
+	}
+
+	result, ok := rewriteHeaderExtensions(headerExtensionProfileTwoByte, payload, time.Now())
+	require.True(t, ok)
+	assert.Equal(t, []byte{0x05, 0x00, 0x00, 0x00}, result)
+}
+
+func TestHeaderExtensionRewriter(t *testing.T) {
+	h := &HeaderExtensionRewriter{now: func() time.Time {
+		return time.Unix(1, 0)
+	}}
+
+	var written []*rtp.Packet
+	writer := h.BindRTP(RTPWriterFunc(func(packet *rtp.Packet) error {
+		written = append(written, packet)
+		return nil
+	}))
+
+	packet := &rtp.Packet{Header: rtp.Header{
+		Extension:        true,
+		ExtensionProfile: headerExtensionProfileOneByte,
+		ExtensionPayload: []byte{0x31, 0x00, 0x01, 0x00},
+	}}
+	require.NoError(t, writer.WriteRTP(packet))
+	require.Len(t, written, 1)
+	assert.False(t, written[0].Extension, "only transport-cc was present")
+	assert.Nil(t, written[0].ExtensionPayload)
+
+	packet = &rtp.Packet{Header: rtp.Header{
+		Extension:        true,
+		ExtensionProfile: headerExtensionProfileOneByte,
+		ExtensionPayload: []byte{0x22, 0xAA, 0xBB, 0xCC},
+	}}
+	require.NoError(t, writer.WriteRTP(packet))
+	require.Len(t, written, 2)
+	assert.True(t, written[1].Extension)
+	assert.Equal(t, []byte{0x22, 0x04, 0x00, 0x00}, written[1].ExtensionPayload)
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// roomTemplateID is replaced with a random ID in HostConfig.RoomTemplate.
+const roomTemplateID = "{id}"
+
+// MatchHost returns the config of the first of hosts matching host, which
+// may contain a port. Exact names take precedence over wildcards.
+func MatchHost(hosts []HostConfig, host string) (HostConfig, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, h := range hosts {
+		if strings.ToLower(h.Name) == host {
+			return h, true
+		}
+	}
+
+	if i := strings.Index(host, "."); i >= 0 {
+		wildcard := "*" + host[i:]
+		for _, h := range hosts {
+			if strings.ToLower(h.Name) == wildcard {
+				return h, true
+			}
+		}
+	}
+
+	return HostConfig{}, false
+}
+
+// NewRoomID returns the name of a room created without a name, based on
+// RoomTemplate.
+func (h HostConfig) NewRoomID() string {
+	id := NewUUIDBase62()
+	if !strings.Contains(h.RoomTemplate, roomTemplateID) {
+		return id
+	}
+	return strings.Replace(h.RoomTemplate, roomTemplateID, id, 1)
+}
+
+// NewSNIConfig creates a TLS config which serves the certificate of the host
+// requested via SNI, and the default certificate to clients requesting
+// other hosts or not using SNI. When there is no default certificate, the
+// certificate of the first host is the default. Returns nil when no
+// certificates are configured.
+func NewSNIConfig(defaultTLS TLSConfig, hosts []HostConfig) (*tls.Config, error) {
+	var defaultCert *tls.Certificate
+	if defaultTLS.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(defaultTLS.Cert, defaultTLS.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading default TLS certificate: %w", err)
+		}
+		defaultCert = &cert
+	}
+
+	var certHosts []HostConfig
+	// key is the host name
+	certs := map[string]*tls.Certificate{}
+	for _, h := range hosts {
+		if h.TLS.Cert == "" {
+			continue
+		}
+		cert, err := tls.LoadX509KeyPair(h.TLS.Cert, h.TLS.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Error loading TLS certificate of host: %s: %w", h.Name, err)
+		}
+		certs[h.Name] = &cert
+		certHosts = append(certHosts, h)
+		if defaultCert == nil {
+			defaultCert = &cert
+		}
+	}
+
+	if defaultCert == nil {
+		return nil, nil
+	}
+
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if h, ok := MatchHost(certHosts, hello.ServerName); ok {
+				return certs[h.Name], nil
+			}
+			return defaultCert, nil
+		},
+	}, nil
+}
+
+// SetHosts sets per-host room templates and client config overrides. It must
+// be called before any requests are handled.
+func (mux *Mux) SetHosts(hosts []HostConfig) {
+	mux.hosts = hosts
+}
+
+func (mux *Mux) host(r *http.Request) HostConfig {
+	host, _ := MatchHost(mux.hosts, r.Host)
+	return host
+}
@@ -0,0 +1,181 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchHost(t *testing.T) {
+	hosts := []server.HostConfig{
+		{Name: "*.example.com", RoomTemplate: "wildcard"},
+		{Name: "meet.example.com", RoomTemplate: "exact"},
+		{Name: "Other.com", RoomTemplate: "other"},
+	}
+
+	for host, template := range map[string]string{
+		"meet.example.com":      "exact",
+		"meet.example.com:3000": "exact",
+		"a.example.com":         "wildcard",
+		"other.com.":            "other",
+		"OTHER.com":             "other",
+	} {
+		h, ok := server.MatchHost(hosts, host)
+		assert.True(t, ok, "host: %s", host)
+		assert.Equal(t, template, h.RoomTemplate, "host: %s", host)
+	}
+
+	for _, host := range []string{"example.com", "a.b.example.com", "", "127.0.0.1:3000"} {
+		_, ok := server.MatchHost(hosts, host)
+		assert.False(t, ok, "host: %s", host)
+	}
+}
+
+func TestHostConfig_NewRoomID(t *testing.T) {
+	assert.Regexp(t, "^acme-[0-9a-zA-Z]+$", server.HostConfig{RoomTemplate: "acme-{id}"}.NewRoomID())
+	assert.Regexp(t, "^[0-9a-zA-Z]+$", server.HostConfig{RoomTemplate: "acme"}.NewRoomID())
+	assert.Regexp(t, "^[0-9a-zA-Z]+$", server.HostConfig{}.NewRoomID())
+}
+
+// writeTestCert writes a self-signed certificate for name and returns the TLS
+// config pointing to it.
+func writeTestCert(t *testing.T, dir string, name string) server.TLSConfig {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	fileName := strings.Replace(name, "*", "wildcard", 1)
+	config := server.TLSConfig{
+		Cert: filepath.Join(dir, fileName+".pem"),
+		Key:  filepath.Join(dir, fileName+".key"),
+	}
+	require.NoError(t, ioutil.WriteFile(config.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(config.Key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return config
+}
+
+func TestServerStarter_SNI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := server.NewStartStopper(server.ServerParams{
+		Hosts: []server.HostConfig{
+			{Name: "a.example.com", TLS: writeTestCert(t, dir, "a.example.com")},
+			{Name: "*.b.example.com", TLS: writeTestCert(t, dir, "*.b.example.com")},
+			{Name: "c.example.com"},
+		},
+	}, handler)
+	go s.Start(l)
+	defer s.Stop()
+
+	for serverName, certName := range map[string]string{
+		"a.example.com":   "a.example.com",
+		"x.b.example.com": "*.b.example.com",
+		// the first host is the default without a default certificate
+		"c.example.com": "a.example.com",
+		"":              "a.example.com",
+	} {
+		conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+		})
+		require.NoError(t, err)
+		certs := conn.ConnectionState().PeerCertificates
+		conn.Close()
+		require.Len(t, certs, 1)
+		assert.Equal(t, []string{certName}, certs[0].DNSNames, "server name: %s", serverName)
+	}
+}
+
+func TestNewSNIConfig(t *testing.T) {
+	config, err := server.NewSNIConfig(server.TLSConfig{}, []server.HostConfig{{Name: "a.example.com"}})
+	require.NoError(t, err)
+	assert.Nil(t, config)
+
+	_, err = server.NewSNIConfig(server.TLSConfig{}, []server.HostConfig{{
+		Name: "a.example.com",
+		TLS:  server.TLSConfig{Cert: "missing.pem", Key: "missing.key"},
+	}})
+	assert.Error(t, err)
+}
+
+func TestMux_hosts(t *testing.T) {
+	mrm := NewMockRoomManager()
+	defer mrm.close()
+	clientConfig := server.ClientConfig{
+		Features: map[string]bool{"chat": true},
+		Branding: map[string]string{"name": "Peer Calls", "color": "blue"},
+	}
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, server.AdminConfig{}, server.InactivityConfig{}, clientConfig, mrm, newMockTracksManager())
+	mux.SetHosts([]server.HostConfig{{
+		Name:         "meet.acme.com",
+		RoomTemplate: "acme-{id}",
+		Client: server.ClientConfig{
+			Branding: map[string]string{"name": "Acme Meet"},
+		},
+	}})
+
+	getConfig := func(host string) server.ClientConfigDocument {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/api/config", nil)
+		r.Host = host
+		mux.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		var doc server.ClientConfigDocument
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+		return doc
+	}
+
+	doc := getConfig("meet.acme.com")
+	assert.Equal(t, map[string]string{"name": "Acme Meet", "color": "blue"}, doc.Branding)
+	assert.Equal(t, map[string]bool{"chat": true}, doc.Features)
+	doc = getConfig("example.com")
+	assert.Equal(t, map[string]string{"name": "Peer Calls", "color": "blue"}, doc.Branding)
+
+	newCall := func(host string) string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/call", strings.NewReader("call="))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Host = host
+		mux.ServeHTTP(w, r)
+		require.Equal(t, http.StatusFound, w.Code)
+		return w.Header().Get("Location")
+	}
+
+	assert.Regexp(t, "^/call/acme-[0-9a-zA-Z]+$", newCall("meet.acme.com"))
+	assert.Regexp(t, "^/call/[0-9a-zA-Z]+$", newCall("example.com"))
+}
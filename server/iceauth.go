@@ -5,6 +5,7 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"fmt"
+	"net/url"
 	"time"
 )
 
@@ -43,3 +44,55 @@ func getICEStaticAuthSecretCredentials(server ICEServer) ICEAuthServer {
 		Credential: credential,
 	}
 }
+
+// ValidateICEServers returns an error when one of the URLs of servers is not
+// a STUN or TURN URL. TURN servers, which clients behind firewalls blocking
+// UDP connect to over TCP or TLS, require credentials, so their auth_type
+// must be secret. TURN over TLS must use TCP.
+func ValidateICEServers(servers []ICEServer) error {
+	for _, server := range servers {
+		for _, rawURL := range server.URLs {
+			if err := validateICEServerURL(rawURL, server.AuthType); err != nil {
+				return fmt.Errorf("invalid ICE server URL: %q: %w", rawURL, err)
+			}
+		}
+	}
+	return nil
+}
+
+func validateICEServerURL(rawURL string, authType AuthType) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Opaque == "" {
+		return fmt.Errorf("missing host")
+	}
+
+	switch u.Scheme {
+	case "stun", "stuns":
+		return nil
+	case "turn", "turns":
+	default:
+		return fmt.Errorf("unsupported scheme: %q", u.Scheme)
+	}
+
+	if authType != AuthTypeSecret {
+		return fmt.Errorf("TURN servers require auth_type: %s", AuthTypeSecret)
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return err
+	}
+	switch transport := query.Get("transport"); transport {
+	case "", "tcp":
+	case "udp":
+		if u.Scheme == "turns" {
+			return fmt.Errorf("TURN over TLS requires transport: tcp")
+		}
+	default:
+		return fmt.Errorf("unsupported transport: %q", transport)
+	}
+	return nil
+}
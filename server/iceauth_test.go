@@ -32,3 +32,34 @@ func TestGetICEAuthServers(t *testing.T) {
 	assert.Regexp(t, "^[0-9]+:test$", r2.Username)
 	assert.NotEmpty(t, r2.Credential)
 }
+
+func TestValidateICEServers(t *testing.T) {
+	turn := server.ICEServer{
+		URLs: []string{
+			"turn:rtc.example.com",
+			"turn:rtc.example.com:3478?transport=tcp",
+			"turns:rtc.example.com:5349?transport=tcp",
+			"turns:rtc.example.com",
+		},
+		AuthType: server.AuthTypeSecret,
+	}
+	stun := server.ICEServer{
+		URLs: []string{"stun:global.stun.twilio.com:3478?transport=udp"},
+	}
+	assert.NoError(t, server.ValidateICEServers([]server.ICEServer{stun, turn}))
+
+	for _, rawURL := range []string{
+		"http://rtc.example.com",
+		"turn:",
+		"turn:rtc.example.com?transport=sctp",
+		"turns:rtc.example.com?transport=udp",
+	} {
+		invalid := turn
+		invalid.URLs = []string{rawURL}
+		assert.Error(t, server.ValidateICEServers([]server.ICEServer{invalid}), rawURL)
+	}
+
+	noAuth := turn
+	noAuth.AuthType = server.AuthTypeNone
+	assert.Error(t, server.ValidateICEServers([]server.ICEServer{noAuth}), "TURN without credentials")
+}
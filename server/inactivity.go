@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+const MessageTypeInactivityWarning = "inactivityWarning"
+
+// MediaActivity tracks media received from peers connected to the SFU.
+type MediaActivity interface {
+	HasPeer(clientID string) bool
+	// LastMediaActivity returns the last time media was received from
+	// clientID.
+	LastMediaActivity(clientID string) time.Time
+}
+
+// SetInactivityPolicy enables disconnecting of clients connected to the SFU
+// which have sent no signaling messages and no media for the configured
+// period. It must be called before any connections are handled.
+func (wss *WSS) SetInactivityPolicy(config InactivityConfig, mediaActivity MediaActivity) {
+	wss.inactivity = config
+	wss.mediaActivity = mediaActivity
+}
+
+// touch records signaling activity of a connection.
+func (c *wsConnection) touch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastActivity = time.Now()
+}
+
+func (c *wsConnection) activity() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastActivity
+}
+
+// monitorInactivity warns and then disconnects the client when it has been
+// inactive for longer than the room's policy allows. It blocks until ctx is
+// done or the client is disconnected.
+func (wss *WSS) monitorInactivity(ctx context.Context, room string, client *Client, conn *wsConnection) {
+	policy := wss.inactivity.Policy(room)
+	if policy.Timeout <= 0 {
+		return
+	}
+
+	clientID := client.ID()
+	ticker := time.NewTicker(policy.Timeout / 10)
+	defer ticker.Stop()
+
+	warned := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			// Mesh peers and peers of a direct two party call send no media
+			// to the server and no signaling messages once connected.
+			if wss.mediaActivity == nil || !wss.mediaActivity.HasPeer(clientID) {
+				warned = false
+				continue
+			}
+			if role, _ := wss.Role(room, clientID); role == RoleViewer && policy.ExemptViewOnly {
+				continue
+			}
+
+			lastActivity := conn.activity()
+			if lastMedia := wss.mediaActivity.LastMediaActivity(clientID); lastMedia.After(lastActivity) {
+				lastActivity = lastMedia
+			}
+
+			idle := now.Sub(lastActivity)
+
+			switch {
+			case idle >= policy.Timeout:
+				wss.log.Printf("Disconnecting inactive client room: %s, clientID: %s, idle: %s", room, clientID, idle)
+				conn.cancel()
+				return
+			case policy.Warning > 0 && idle >= policy.Timeout-policy.Warning:
+				if warned {
+					continue
+				}
+				warned = true
+				wss.log.Printf("Warning inactive client room: %s, clientID: %s, idle: %s", room, clientID, idle)
+				err := client.Write(NewMessage(MessageTypeInactivityWarning, room, map[string]interface{}{
+					"disconnectIn": (policy.Timeout - idle).Seconds(),
+				}))
+				if err != nil {
+					wss.log.Printf("Error sending inactivity warning to clientID: %s: %s", clientID, err)
+				}
+			default:
+				warned = false
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package server_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func newInactivityServer(exemptViewOnly bool) (*server.Mux, *httptest.Server) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	inactivity := server.InactivityConfig{
+		Rooms: map[string]server.InactivityPolicy{
+			roomName: {
+				Timeout:        500 * time.Millisecond,
+				Warning:        300 * time.Millisecond,
+				ExemptViewOnly: exemptViewOnly,
+			},
+		},
+	}
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, server.AdminConfig{}, inactivity, server.ClientConfig{}, rooms, newMockTracksManager())
+	return mux, httptest.NewServer(mux)
+}
+
+// readUntilClosed reads messages until the websocket is closed or ctx is
+// done, and returns true when an inactivity warning was received.
+func readUntilClosed(ctx context.Context, t *testing.T, ws *websocket.Conn) (warned bool) {
+	for {
+		_, data, err := ws.Read(ctx)
+		if err != nil {
+			return warned
+		}
+		message, err := serializer.Deserialize(data)
+		require.NoError(t, err)
+		if message.Type == server.MessageTypeInactivityWarning {
+			warned = true
+		}
+	}
+}
+
+func TestInactivity_warnAndDisconnect(t *testing.T) {
+	_, s := newInactivityServer(false)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// zombie has a peer connection to the SFU
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + roomName + "/zombie"
+	ws := mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+
+	warned := readUntilClosed(ctx, t, ws)
+	require.NoError(t, ctx.Err(), "websocket should be closed before timeout")
+	assert.True(t, warned, "expected an inactivity warning before disconnect")
+}
+
+func TestInactivity_withoutSFUPeer(t *testing.T) {
+	_, s := newInactivityServer(false)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + roomName + "/" + clientID
+	ws := mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+
+	warned := readUntilClosed(ctx, t, ws)
+	assert.Equal(t, context.DeadlineExceeded, ctx.Err(), "websocket should stay connected")
+	assert.False(t, warned)
+}
+
+func TestInactivity_exemptViewer(t *testing.T) {
+	mux, s := newInactivityServer(true)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + roomName + "/zombie"
+	ws := mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+
+	// the role is assigned after the client has been added to the room
+	mustReadWSType(t, ctx, ws, server.MessageTypeRoles)
+	require.NoError(t, mux.WSS.SetRole(roomName, "zombie", server.RoleViewer))
+
+	warned := readUntilClosed(ctx, t, ws)
+	assert.Equal(t, context.DeadlineExceeded, ctx.Err(), "websocket should stay connected")
+	assert.False(t, warned)
+}
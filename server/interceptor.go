@@ -0,0 +1,164 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+)
+
+// RTPWriter writes RTP packets to the next element in the interceptor chain.
+type RTPWriter interface {
+	WriteRTP(packet *rtp.Packet) error
+}
+
+// RTCPWriter writes RTCP packets to the next element in the interceptor
+// chain.
+type RTCPWriter interface {
+	WriteRTCP(packets []rtcp.Packet) error
+}
+
+type RTPWriterFunc func(packet *rtp.Packet) error
+
+func (f RTPWriterFunc) WriteRTP(packet *rtp.Packet) error {
+	return f(packet)
+}
+
+type RTCPWriterFunc func(packets []rtcp.Packet) error
+
+func (f RTCPWriterFunc) WriteRTCP(packets []rtcp.Packet) error {
+	return f(packets)
+}
+
+// InterceptorParams describes the track an Interceptor is created for.
+type InterceptorParams struct {
+	// ClientID of the publisher
+	ClientID string
+	// Room the publisher is in
+	Room string
+	// RemoteTrack is the track received from the publisher
+	RemoteTrack *webrtc.Track
+	// LocalTrack is the track the subscribers are fed from
+	LocalTrack *webrtc.Track
+	// Source of the track, when signaled before the track was received.
+	// Later changes are passed to interceptors implementing SourceSetter.
+	Source TrackSource
+	// Encrypted is true when the payload is encrypted end-to-end and cannot
+	// be decoded by the server.
+	Encrypted bool
+	// Subscribers returns the number of subscribers packets are currently
+	// written to. It is nil when the track is not forwarded by a trackWriter.
+	Subscribers func() int
+}
+
+// Interceptor processes the media of a single published track. RTP packets
+// flow from the publisher to the subscribers, and RTCP feedback flows from
+// the subscribers back to the publisher.
+type Interceptor interface {
+	// BindRTP wraps the writer which forwards RTP packets to subscribers.
+	BindRTP(next RTPWriter) RTPWriter
+	// BindRTCP wraps the writer which forwards RTCP feedback to the publisher.
+	BindRTCP(next RTCPWriter) RTCPWriter
+	// Close is called after the publisher's track has ended.
+	Close() error
+}
+
+// SourceSetter is implemented by interceptors which depend on the source of
+// the track. The source changes when the publisher replaces the track of a
+// sender without renegotiation, for example to switch from its camera to a
+// screen share, so the same received track keeps being forwarded.
+type SourceSetter interface {
+	SetSource(source TrackSource)
+}
+
+type InterceptorFactory interface {
+	NewInterceptor(params InterceptorParams) (Interceptor, error)
+}
+
+type InterceptorFactoryFunc func(params InterceptorParams) (Interceptor, error)
+
+func (f InterceptorFactoryFunc) NewInterceptor(params InterceptorParams) (Interceptor, error) {
+	return f(params)
+}
+
+// NoOpInterceptor passes all packets through unchanged. It can be embedded
+// by interceptors which only need to implement some of the methods.
+type NoOpInterceptor struct{}
+
+func (NoOpInterceptor) BindRTP(next RTPWriter) RTPWriter {
+	return next
+}
+
+func (NoOpInterceptor) BindRTCP(next RTCPWriter) RTCPWriter {
+	return next
+}
+
+func (NoOpInterceptor) Close() error {
+	return nil
+}
+
+// interceptorChain contains all interceptors bound to a single track. The
+// first interceptor is the first one to receive RTP packets from the
+// publisher, as well as the first one to receive RTCP packets from
+// subscribers.
+type interceptorChain struct {
+	interceptors []Interceptor
+	rtpWriter    RTPWriter
+	rtcpWriter   RTCPWriter
+}
+
+func newInterceptorChain(
+	factories []InterceptorFactory,
+	params InterceptorParams,
+	rtpWriter RTPWriter,
+	rtcpWriter RTCPWriter,
+) (*interceptorChain, error) {
+	c := &interceptorChain{}
+
+	for _, factory := range factories {
+		interceptor, err := factory.NewInterceptor(params)
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("Error creating interceptor for track: %s: %w", params.LocalTrack.ID(), err)
+		}
+		c.interceptors = append(c.interceptors, interceptor)
+	}
+
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		rtpWriter = c.interceptors[i].BindRTP(rtpWriter)
+		rtcpWriter = c.interceptors[i].BindRTCP(rtcpWriter)
+	}
+
+	c.rtpWriter = rtpWriter
+	c.rtcpWriter = rtcpWriter
+
+	return c, nil
+}
+
+func (c *interceptorChain) WriteRTP(packet *rtp.Packet) error {
+	return c.rtpWriter.WriteRTP(packet)
+}
+
+func (c *interceptorChain) WriteRTCP(packets []rtcp.Packet) error {
+	return c.rtcpWriter.WriteRTCP(packets)
+}
+
+// SetSource passes a new source of the track to interceptors implementing
+// SourceSetter.
+func (c *interceptorChain) SetSource(source TrackSource) {
+	for _, interceptor := range c.interceptors {
+		if setter, ok := interceptor.(SourceSetter); ok {
+			setter.SetSource(source)
+		}
+	}
+}
+
+func (c *interceptorChain) Close() (err error) {
+	for _, interceptor := range c.interceptors {
+		if closeErr := interceptor.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
@@ -0,0 +1,518 @@
+package server_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTrack(t *testing.T, ssrc uint32) *webrtc.Track {
+	t.Helper()
+	codec := webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000)
+	track, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeVP8, ssrc, "track-id", "track-label", codec)
+	require.NoError(t, err)
+	return track
+}
+
+type rtpRecorder struct {
+	packets []*rtp.Packet
+}
+
+func (r *rtpRecorder) WriteRTP(packet *rtp.Packet) error {
+	r.packets = append(r.packets, packet)
+	return nil
+}
+
+func (r *rtpRecorder) Close() error {
+	return nil
+}
+
+type rtcpRecorder struct {
+	packets []rtcp.Packet
+}
+
+func (r *rtcpRecorder) WriteRTCP(packets []rtcp.Packet) error {
+	r.packets = append(r.packets, packets...)
+	return nil
+}
+
+func TestNACKResponder(t *testing.T) {
+	track := newTestTrack(t, 1234)
+	interceptor, err := server.NewNACKResponderFactory(loggerFactory).NewInterceptor(server.InterceptorParams{
+		ClientID:   "a",
+		LocalTrack: track,
+	})
+	require.NoError(t, err)
+
+	var rtpOut rtpRecorder
+	var rtcpOut rtcpRecorder
+	rtpWriter := interceptor.BindRTP(&rtpOut)
+	rtcpWriter := interceptor.BindRTCP(&rtcpOut)
+
+	for _, seq := range []uint16{1, 2, 4} {
+		require.NoError(t, rtpWriter.WriteRTP(&rtp.Packet{
+			Header: rtp.Header{SequenceNumber: seq, SSRC: 1234},
+		}))
+	}
+	require.Equal(t, 3, len(rtpOut.packets))
+
+	err = rtcpWriter.WriteRTCP([]rtcp.Packet{
+		&rtcp.TransportLayerNack{
+			MediaSSRC: 1234,
+			// 2, 3, 4
+			Nacks: []rtcp.NackPair{{PacketID: 2, LostPackets: 0b11}},
+		},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, 5, len(rtpOut.packets))
+	assert.Equal(t, uint16(2), rtpOut.packets[3].SequenceNumber)
+	assert.Equal(t, uint16(4), rtpOut.packets[4].SequenceNumber)
+
+	require.Equal(t, 1, len(rtcpOut.packets))
+	nack := rtcpOut.packets[0].(*rtcp.TransportLayerNack)
+	assert.Equal(t, []rtcp.NackPair{{PacketID: 3}}, nack.Nacks)
+}
+
+func TestPLIThrottler(t *testing.T) {
+	track := newTestTrack(t, 1234)
+	interceptor, err := server.NewPLIThrottlerFactory(loggerFactory, time.Hour, time.Hour, time.Hour).NewInterceptor(server.InterceptorParams{
+		ClientID:   "a",
+		LocalTrack: track,
+	})
+	require.NoError(t, err)
+	defer interceptor.Close()
+
+	rtcpOut := make(chan []rtcp.Packet, 10)
+	rtcpWriter := interceptor.BindRTCP(server.RTCPWriterFunc(func(packets []rtcp.Packet) error {
+		rtcpOut <- packets
+		return nil
+	}))
+
+	// initial PLI
+	packets := <-rtcpOut
+	assert.Equal(t, []rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: 1234}}, packets)
+
+	err = rtcpWriter.WriteRTCP([]rtcp.Packet{
+		&rtcp.PictureLossIndication{MediaSSRC: 1234},
+		&rtcp.PictureLossIndication{MediaSSRC: 5678},
+	})
+	require.NoError(t, err)
+
+	packets = <-rtcpOut
+	assert.Equal(t, []rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: 5678}}, packets)
+}
+
+func TestPLIThrottler_screen(t *testing.T) {
+	track := newTestTrack(t, 1234)
+	interceptor, err := server.NewPLIThrottlerFactory(loggerFactory, time.Hour, time.Hour, 0).NewInterceptor(server.InterceptorParams{
+		ClientID:   "a",
+		LocalTrack: track,
+		Source:     server.TrackSourceScreen,
+	})
+	require.NoError(t, err)
+	defer interceptor.Close()
+
+	rtcpOut := make(chan []rtcp.Packet, 10)
+	rtcpWriter := interceptor.BindRTCP(server.RTCPWriterFunc(func(packets []rtcp.Packet) error {
+		rtcpOut <- packets
+		return nil
+	}))
+
+	// initial PLI
+	<-rtcpOut
+
+	pli := []rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: 1234}}
+	require.NoError(t, rtcpWriter.WriteRTCP(pli))
+	assert.Equal(t, pli, <-rtcpOut)
+}
+
+func TestPLIThrottler_SetSource(t *testing.T) {
+	track := newTestTrack(t, 1234)
+	interceptor, err := server.NewPLIThrottlerFactory(loggerFactory, time.Hour, time.Hour, 0).NewInterceptor(server.InterceptorParams{
+		ClientID:   "a",
+		LocalTrack: track,
+	})
+	require.NoError(t, err)
+	defer interceptor.Close()
+
+	rtcpOut := make(chan []rtcp.Packet, 10)
+	rtcpWriter := interceptor.BindRTCP(server.RTCPWriterFunc(func(packets []rtcp.Packet) error {
+		rtcpOut <- packets
+		return nil
+	}))
+
+	// initial PLI
+	<-rtcpOut
+
+	pli := []rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: 1234}}
+	require.NoError(t, rtcpWriter.WriteRTCP(pli))
+	assert.Empty(t, rtcpOut, "throttled as camera")
+
+	// the publisher replaced its camera with a screen share
+	interceptor.(server.SourceSetter).SetSource(server.TrackSourceScreen)
+	require.NoError(t, rtcpWriter.WriteRTCP(pli))
+	assert.Equal(t, pli, <-rtcpOut)
+}
+
+func TestPLIThrottler_SetIntervals(t *testing.T) {
+	track := newTestTrack(t, 1234)
+	requester := server.NewPLIThrottlerFactory(loggerFactory, time.Hour, time.Hour, 0)
+	interceptor, err := requester.NewInterceptor(server.InterceptorParams{
+		ClientID:   "a",
+		LocalTrack: track,
+	})
+	require.NoError(t, err)
+	defer interceptor.Close()
+
+	rtcpOut := make(chan []rtcp.Packet, 10)
+	rtcpWriter := interceptor.BindRTCP(server.RTCPWriterFunc(func(packets []rtcp.Packet) error {
+		rtcpOut <- packets
+		return nil
+	}))
+
+	// initial PLI
+	<-rtcpOut
+
+	pli := []rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: 1234}}
+	require.NoError(t, rtcpWriter.WriteRTCP(pli))
+	assert.Empty(t, rtcpOut, "throttled")
+
+	// the pending request is sent with the new minimum interval
+	requester.SetIntervals(time.Hour, 0, 0)
+	select {
+	case packets := <-rtcpOut:
+		assert.Equal(t, pli, packets)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for PLI")
+	}
+}
+
+func TestPLIThrottler_coalesce(t *testing.T) {
+	track := newTestTrack(t, 1234)
+	interceptor, err := server.NewPLIThrottlerFactory(loggerFactory, time.Hour, 50*time.Millisecond, 0).NewInterceptor(server.InterceptorParams{
+		ClientID:   "a",
+		LocalTrack: track,
+	})
+	require.NoError(t, err)
+	defer interceptor.Close()
+
+	rtcpOut := make(chan []rtcp.Packet, 10)
+	rtcpWriter := interceptor.BindRTCP(server.RTCPWriterFunc(func(packets []rtcp.Packet) error {
+		rtcpOut <- packets
+		return nil
+	}))
+
+	// initial PLI
+	<-rtcpOut
+
+	// three subscribers request a keyframe
+	pli := []rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: 1234}}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rtcpWriter.WriteRTCP(pli))
+	}
+	assert.Empty(t, rtcpOut, "throttled")
+
+	select {
+	case packets := <-rtcpOut:
+		assert.Equal(t, pli, packets, "requests are sent once the minimum interval passed")
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for coalesced PLI")
+	}
+
+	select {
+	case <-rtcpOut:
+		t.Fatal("requests should be coalesced into a single PLI")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRewindBuffer(t *testing.T) {
+	interframe := []byte{0x10, 0x01, 0x00}
+	keyframe := []byte{0x10, 0x00, 0x00}
+	pli := []rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: 1234}}
+
+	test := func(t *testing.T, payloads [][]byte) (rtpOut rtpRecorder) {
+		track := newTestTrack(t, 1234)
+		// room budget fits three packets
+		interceptor, err := server.NewRewindBufferFactory(loggerFactory, time.Hour, 10).NewInterceptor(server.InterceptorParams{
+			ClientID:   "a",
+			Room:       "room",
+			LocalTrack: track,
+		})
+		require.NoError(t, err)
+		defer interceptor.Close()
+
+		var rtcpOut rtcpRecorder
+		rtpWriter := interceptor.BindRTP(&rtpOut)
+		rtcpWriter := interceptor.BindRTCP(&rtcpOut)
+
+		for i, payload := range payloads {
+			require.NoError(t, rtpWriter.WriteRTP(&rtp.Packet{
+				Header:  rtp.Header{SequenceNumber: uint16(i)},
+				Payload: payload,
+			}))
+		}
+		require.NoError(t, rtcpWriter.WriteRTCP(pli))
+		assert.Equal(t, pli, rtcpOut.packets)
+		return
+	}
+
+	t.Run("replay since keyframe", func(t *testing.T) {
+		rtpOut := test(t, [][]byte{interframe, keyframe, interframe})
+		require.Equal(t, 5, len(rtpOut.packets))
+		assert.Equal(t, uint16(1), rtpOut.packets[3].SequenceNumber)
+		assert.Equal(t, uint16(2), rtpOut.packets[4].SequenceNumber)
+	})
+
+	t.Run("room budget exceeded", func(t *testing.T) {
+		rtpOut := test(t, [][]byte{keyframe, interframe, interframe, interframe})
+		assert.Equal(t, 4, len(rtpOut.packets))
+	})
+}
+
+func TestStatsInterceptor(t *testing.T) {
+	track := newTestTrack(t, 1234)
+	factory := server.NewStatsInterceptorFactory()
+	interceptor, err := factory.NewInterceptor(server.InterceptorParams{
+		ClientID:   "a",
+		LocalTrack: track,
+	})
+	require.NoError(t, err)
+
+	rtpWriter := interceptor.BindRTP(&rtpRecorder{})
+	rtcpWriter := interceptor.BindRTCP(&rtcpRecorder{})
+
+	for _, seq := range []uint16{65534, 65535, 2, 1} {
+		require.NoError(t, rtpWriter.WriteRTP(&rtp.Packet{
+			Header:  rtp.Header{SequenceNumber: seq, SSRC: 1234},
+			Payload: []byte{1, 2},
+		}))
+	}
+	require.NoError(t, rtcpWriter.WriteRTCP([]rtcp.Packet{
+		&rtcp.PictureLossIndication{MediaSSRC: 1234},
+	}))
+
+	stats := factory.Stats()
+	require.Equal(t, 1, len(stats))
+	assert.False(t, stats[0].LastPacketTime.IsZero())
+	assert.Equal(t, stats[0].LastPacketTime, factory.LastPacketTime("a"))
+	stats[0].LastPacketTime = time.Time{}
+	// packets with the same timestamp were received at about the same time
+	assert.InDelta(t, 0, stats[0].Jitter, 0.001)
+	stats[0].Jitter = 0
+	assert.Equal(t, []server.TrackStats{{
+		ClientID:        "a",
+		TrackID:         "track-id",
+		Kind:            "video",
+		SSRC:            1234,
+		PacketsReceived: 4,
+		BytesReceived:   8,
+		PacketsLost:     2,
+		PLIsReceived:    1,
+	}}, stats)
+
+	require.NoError(t, interceptor.Close())
+	assert.Equal(t, []server.TrackStats{}, factory.Stats())
+}
+
+func TestRecorderTap(t *testing.T) {
+	track := newTestTrack(t, 1234)
+	var recorded rtpRecorder
+	factory := server.NewRecorderTapFactory(loggerFactory, func(params server.InterceptorParams) (server.RTPWriteCloser, error) {
+		return &recorded, nil
+	})
+	interceptor, err := factory.NewInterceptor(server.InterceptorParams{
+		ClientID:   "a",
+		LocalTrack: track,
+	})
+	require.NoError(t, err)
+
+	var forwarded rtpRecorder
+	rtpWriter := interceptor.BindRTP(&forwarded)
+	require.NoError(t, rtpWriter.WriteRTP(&rtp.Packet{}))
+
+	assert.Equal(t, 1, len(recorded.packets))
+	assert.Equal(t, 1, len(forwarded.packets))
+}
+
+func TestRecorderTap_encrypted(t *testing.T) {
+	track := newTestTrack(t, 1234)
+	factory := server.NewRecorderTapFactory(loggerFactory, func(params server.InterceptorParams) (server.RTPWriteCloser, error) {
+		t.Fatal("encrypted track should not be recorded")
+		return nil, nil
+	})
+	interceptor, err := factory.NewInterceptor(server.InterceptorParams{
+		ClientID:   "a",
+		LocalTrack: track,
+		Encrypted:  true,
+	})
+	require.NoError(t, err)
+
+	var forwarded rtpRecorder
+	rtpWriter := interceptor.BindRTP(&forwarded)
+	require.NoError(t, rtpWriter.WriteRTP(&rtp.Packet{}))
+	assert.Equal(t, 1, len(forwarded.packets))
+}
+
+func TestRecordingManifest(t *testing.T) {
+	codec := webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000)
+	audioTrack, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeOpus, 1234, "audio-id", "audio-label", codec)
+	require.NoError(t, err)
+
+	factory := server.NewRecordingManifestFactory()
+	audio, err := factory.NewInterceptor(server.InterceptorParams{
+		ClientID:   "a",
+		Room:       "room",
+		LocalTrack: audioTrack,
+	})
+	require.NoError(t, err)
+	_, err = factory.NewInterceptor(server.InterceptorParams{
+		ClientID:   "a",
+		Room:       "room",
+		LocalTrack: newTestTrack(t, 5678),
+	})
+	require.NoError(t, err)
+
+	var forwarded rtpRecorder
+	rtpWriter := audio.BindRTP(&forwarded)
+	silence := &rtp.Packet{Payload: make([]byte, 3)}
+	speech := &rtp.Packet{Payload: make([]byte, 100)}
+	for _, packet := range []*rtp.Packet{silence, speech, speech, silence} {
+		require.NoError(t, rtpWriter.WriteRTP(packet))
+	}
+	assert.Equal(t, 4, len(forwarded.packets))
+
+	manifest, ok := factory.Finish("room")
+	require.True(t, ok)
+	assert.Equal(t, "room", manifest.Room)
+	require.Equal(t, 2, len(manifest.Tracks))
+	assert.Equal(t, "audio", manifest.Tracks[0].Kind)
+	assert.Equal(t, "video", manifest.Tracks[1].Kind)
+	require.Equal(t, 1, len(manifest.Speakers))
+	assert.Equal(t, "a", manifest.Speakers[0].ClientID)
+	assert.Equal(t, "audio-id", manifest.Speakers[0].TrackID)
+	assert.LessOrEqual(t, manifest.Speakers[0].StartMs, manifest.Speakers[0].EndMs)
+
+	_, ok = factory.Manifest("room")
+	assert.False(t, ok)
+}
+
+func TestActivityDetector(t *testing.T) {
+	codec := webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000)
+	track, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeOpus, 1234, "audio-id", "audio-label", codec)
+	require.NoError(t, err)
+
+	active := make(chan string, 10)
+	factory := server.NewActivityDetectorFactory(func(clientID string) {
+		active <- clientID
+	})
+	interceptor, err := factory.NewInterceptor(server.InterceptorParams{
+		ClientID:   "a",
+		LocalTrack: track,
+	})
+	require.NoError(t, err)
+	rtpWriter := interceptor.BindRTP(&rtpRecorder{})
+
+	require.NoError(t, rtpWriter.WriteRTP(&rtp.Packet{Payload: make([]byte, 3)}))
+	assert.True(t, factory.LastActive("a").IsZero())
+
+	require.NoError(t, rtpWriter.WriteRTP(&rtp.Packet{Payload: make([]byte, 100)}))
+	require.NoError(t, rtpWriter.WriteRTP(&rtp.Packet{Payload: make([]byte, 100)}))
+	assert.Equal(t, "a", <-active)
+	assert.False(t, factory.LastActive("a").IsZero())
+	assert.Equal(t, 0, len(active))
+
+	factory.Remove("a")
+	assert.True(t, factory.LastActive("a").IsZero())
+
+	// the audio level header extension takes precedence over payload sizes
+	withAudioLevel := func(level byte, payloadSize int) *rtp.Packet {
+		return &rtp.Packet{
+			Header: rtp.Header{
+				Extension:        true,
+				ExtensionProfile: 0xBEDE,
+				ExtensionPayload: []byte{0x10, level, 0x00, 0x00},
+			},
+			Payload: make([]byte, payloadSize),
+		}
+	}
+	require.NoError(t, rtpWriter.WriteRTP(withAudioLevel(127, 100)))
+	assert.True(t, factory.LastActive("a").IsZero())
+	require.NoError(t, rtpWriter.WriteRTP(withAudioLevel(20, 3)))
+	assert.Equal(t, "a", <-active)
+	assert.False(t, factory.LastActive("a").IsZero())
+}
+
+func TestReorderBuffer(t *testing.T) {
+	interceptor, err := server.NewReorderBufferFactory(loggerFactory, server.ReorderConfig{
+		Delay: 50 * time.Millisecond,
+	}).NewInterceptor(server.InterceptorParams{
+		ClientID:   "a",
+		Room:       "room",
+		LocalTrack: newTestTrack(t, 1234),
+	})
+	require.NoError(t, err)
+	defer interceptor.Close()
+
+	written := make(chan uint16, 100)
+	rtpWriter := interceptor.BindRTP(server.RTPWriterFunc(func(packet *rtp.Packet) error {
+		written <- packet.SequenceNumber
+		return nil
+	}))
+
+	write := func(seqs ...uint16) {
+		for _, seq := range seqs {
+			require.NoError(t, rtpWriter.WriteRTP(&rtp.Packet{Header: rtp.Header{SequenceNumber: seq}}))
+		}
+	}
+	read := func(count int) (seqs []uint16) {
+		for i := 0; i < count; i++ {
+			select {
+			case seq := <-written:
+				seqs = append(seqs, seq)
+			case <-time.After(time.Second):
+				t.Fatalf("timed out waiting for packet %d", i)
+			}
+		}
+		return seqs
+	}
+
+	write(65534, 0, 65535, 1)
+	assert.Equal(t, []uint16{65534, 65535, 0, 1}, read(4), "packets are reordered across wraparound")
+
+	// 2 is lost
+	write(3, 4)
+	assert.Empty(t, written, "packets are held back")
+	assert.Equal(t, []uint16{3, 4}, read(2), "missing packets are given up on after the delay")
+
+	write(2)
+	assert.Equal(t, []uint16{2}, read(1), "late packets are written right away")
+
+	write(5, 1000)
+	assert.Equal(t, []uint16{5, 1000}, read(2), "jumps are not waited for")
+}
+
+func TestReorderBuffer_disabled(t *testing.T) {
+	config := server.ReorderConfig{
+		Delay: 50 * time.Millisecond,
+		Rooms: map[string]time.Duration{"live": 0},
+	}
+	assert.True(t, config.Enabled())
+
+	interceptor, err := server.NewReorderBufferFactory(loggerFactory, config).NewInterceptor(server.InterceptorParams{
+		ClientID:   "a",
+		Room:       "live",
+		LocalTrack: newTestTrack(t, 1234),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, server.NoOpInterceptor{}, interceptor, "disabled in room")
+
+	assert.False(t, server.ReorderConfig{}.Enabled())
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+)
+
+const (
+	// Audio levels, in -dBov, of packets carrying speech are at most this
+	// value. Levels of background noise are usually lower than -60 dBov.
+	speakingMaxAudioLevel = 50
+	// Opus packets carrying speech are considerably larger than packets
+	// carrying silence or comfort noise. Packets without the audio level
+	// header extension are considered speech when their payload is at least
+	// this large. This does not work with constant bitrate Opus.
+	speakingMinPayloadSize = 40
+	// A peer that has not spoken for this long will trigger onActive again
+	// when it starts speaking.
+	speakingTimeout = time.Second
+)
+
+// ActivityDetectorFactory creates interceptors which keep track of when a
+// peer last spoke. The onActive callback is called in a separate goroutine
+// whenever a peer starts speaking after being quiet.
+type ActivityDetectorFactory struct {
+	onActive func(clientID string)
+
+	mu         sync.Mutex
+	lastActive map[string]time.Time
+}
+
+func NewActivityDetectorFactory(onActive func(clientID string)) *ActivityDetectorFactory {
+	return &ActivityDetectorFactory{
+		onActive:   onActive,
+		lastActive: map[string]time.Time{},
+	}
+}
+
+func (f *ActivityDetectorFactory) NewInterceptor(params InterceptorParams) (Interceptor, error) {
+	if params.LocalTrack.Kind() != webrtc.RTPCodecTypeAudio {
+		return NoOpInterceptor{}, nil
+	}
+
+	return &activityDetector{
+		factory:  f,
+		clientID: params.ClientID,
+	}, nil
+}
+
+// LastActive returns the time clientID last spoke. Zero time is returned for
+// peers that have not spoken yet.
+func (f *ActivityDetectorFactory) LastActive(clientID string) time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastActive[clientID]
+}
+
+// Remove forgets the activity of clientID.
+func (f *ActivityDetectorFactory) Remove(clientID string) {
+	f.mu.Lock()
+	delete(f.lastActive, clientID)
+	f.mu.Unlock()
+}
+
+func (f *ActivityDetectorFactory) markActive(clientID string, now time.Time) {
+	f.mu.Lock()
+	prev := f.lastActive[clientID]
+	f.lastActive[clientID] = now
+	f.mu.Unlock()
+
+	if now.Sub(prev) > speakingTimeout && f.onActive != nil {
+		go f.onActive(clientID)
+	}
+}
+
+type activityDetector struct {
+	NoOpInterceptor
+
+	factory  *ActivityDetectorFactory
+	clientID string
+}
+
+func (a *activityDetector) BindRTP(next RTPWriter) RTPWriter {
+	return RTPWriterFunc(func(packet *rtp.Packet) error {
+		if isSpeech(packet) {
+			a.factory.markActive(a.clientID, time.Now())
+		}
+		return next.WriteRTP(packet)
+	})
+}
+
+// isSpeech uses the audio level header extension of packet when it is
+// present, and its payload size otherwise.
+func isSpeech(packet *rtp.Packet) bool {
+	if packet.Extension {
+		if level, ok := headerExtensionAudioLevel(packet.ExtensionProfile, packet.ExtensionPayload); ok {
+			return level <= speakingMaxAudioLevel
+		}
+	}
+	return len(packet.Payload) >= speakingMinPayloadSize
+}
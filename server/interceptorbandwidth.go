@@ -0,0 +1,455 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+)
+
+const (
+	defaultBandwidthTolerance   = 1.5
+	defaultBandwidthGracePeriod = 5 * time.Second
+)
+
+// BandwidthState describes how a publisher's bitrate compares to the
+// configured maximum bitrate of its room.
+type BandwidthState string
+
+const (
+	// BandwidthStateOK means the publisher is within the tolerated bitrate.
+	BandwidthStateOK BandwidthState = "ok"
+	// BandwidthStateExceeding means the publisher is sending more than the
+	// tolerated bitrate, but not for longer than the grace period yet.
+	BandwidthStateExceeding BandwidthState = "exceeding"
+	// BandwidthStateWarned means a warning was logged.
+	BandwidthStateWarned BandwidthState = "warned"
+	// BandwidthStateThrottled means the publisher is asked to lower its
+	// bitrate via REMB.
+	BandwidthStateThrottled BandwidthState = "throttled"
+	// BandwidthStateDropping means video packets exceeding the maximum
+	// bitrate are dropped.
+	BandwidthStateDropping BandwidthState = "dropping"
+	// BandwidthStateDisconnected means the publisher was disconnected.
+	BandwidthStateDisconnected BandwidthState = "disconnected"
+)
+
+// PublisherBandwidth is the bandwidth enforcement state of a publisher.
+type PublisherBandwidth struct {
+	// Bitrate of all tracks in bits per second, measured over the last
+	// bitrateWindow
+	Bitrate uint64 `json:"bitrate"`
+	// MaxBitrate in bits per second
+	MaxBitrate uint64         `json:"maxBitrate"`
+	State      BandwidthState `json:"state"`
+	// Since is the time the publisher entered State
+	Since time.Time `json:"since"`
+}
+
+// BandwidthEnforcer creates interceptors which measure the total bitrate of
+// all tracks of each publisher and apply the configured action once a
+// publisher persistently exceeds the maximum bitrate of its room, or its own
+// maximum bitrate set with SetPeerMaxBitrate. A publisher is considered to
+// exceed the maximum once its bitrate is above MaxBitrate * Tolerance for
+// longer than GracePeriod, and it is back to normal once its bitrate stayed
+// at or below MaxBitrate for GracePeriod.
+type BandwidthEnforcer struct {
+	log    Logger
+	config BandwidthPolicyConfig
+	window time.Duration
+
+	mu sync.Mutex
+	// key is clientID
+	publishers map[string]*publisherBandwidth
+	// key is clientID, value overrides the maximum bitrate of the room
+	peerMaxBitrates map[string]int
+	tenants         *Tenants
+	disconnect      func(room string, clientID string) bool
+}
+
+type publisherBandwidth struct {
+	room         string
+	maxBitrate   uint64
+	interceptors map[*bandwidthInterceptor]struct{}
+
+	windowStart    time.Time
+	windowBytes    uint64
+	forwardedBytes uint64
+	bitrate        uint64
+
+	state BandwidthState
+	since time.Time
+	// exceededSince is the start of the period during which the bitrate
+	// stayed above the tolerated bitrate
+	exceededSince time.Time
+	// compliantSince is the start of the period during which the bitrate
+	// stayed at or below the maximum bitrate
+	compliantSince time.Time
+}
+
+func NewBandwidthEnforcer(loggerFactory LoggerFactory, config BandwidthPolicyConfig) *BandwidthEnforcer {
+	if config.Tolerance < 1 {
+		config.Tolerance = defaultBandwidthTolerance
+	}
+	if config.GracePeriod <= 0 {
+		config.GracePeriod = defaultBandwidthGracePeriod
+	}
+	if config.Action == "" {
+		config.Action = BandwidthActionWarn
+	}
+
+	return &BandwidthEnforcer{
+		log:             loggerFactory.GetLogger("bandwidth"),
+		config:          config,
+		window:          bitrateWindow,
+		publishers:      map[string]*publisherBandwidth{},
+		peerMaxBitrates: map[string]int{},
+	}
+}
+
+// SetDisconnect sets the function which disconnects publishers when the
+// action is BandwidthActionDisconnect. It must be called before any tracks
+// are published.
+func (e *BandwidthEnforcer) SetDisconnect(disconnect func(room string, clientID string) bool) {
+	e.disconnect = disconnect
+}
+
+// SetTenants sets the tenants whose maximum bitrate applies to their rooms.
+// It must be called before any tracks are published.
+func (e *BandwidthEnforcer) SetTenants(tenants *Tenants) {
+	e.tenants = tenants
+}
+
+// MaxBitrate returns the maximum bitrate of clientID in room.
+func (e *BandwidthEnforcer) MaxBitrate(room string, clientID string) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.maxBitrate(room, clientID)
+}
+
+// maxBitrate must be called with mu held.
+func (e *BandwidthEnforcer) maxBitrate(room string, clientID string) int {
+	if maxBitrate, ok := e.peerMaxBitrates[clientID]; ok {
+		return maxBitrate
+	}
+	if _, ok := e.config.Rooms[room]; !ok {
+		if maxBitrate := e.tenants.RoomMaxBitrate(room); maxBitrate > 0 {
+			return maxBitrate
+		}
+	}
+	return e.config.RoomMaxBitrate(room)
+}
+
+// SetPeerMaxBitrate overrides the maximum bitrate of the room for clientID.
+// Zero restores the maximum bitrate of the room.
+func (e *BandwidthEnforcer) SetPeerMaxBitrate(clientID string, maxBitrate int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if maxBitrate > 0 {
+		e.peerMaxBitrates[clientID] = maxBitrate
+	} else {
+		delete(e.peerMaxBitrates, clientID)
+	}
+
+	p, ok := e.publishers[clientID]
+	if !ok {
+		return
+	}
+	p.maxBitrate = uint64(e.maxBitrate(p.room, clientID))
+	if p.maxBitrate == 0 {
+		e.setState(p, BandwidthStateOK, time.Now())
+		p.exceededSince = time.Time{}
+		p.compliantSince = time.Time{}
+	}
+}
+
+// PublisherBandwidth returns the enforcement state of clientID. Returns
+// false when clientID publishes no tracks or has no maximum bitrate.
+func (e *BandwidthEnforcer) PublisherBandwidth(clientID string) (PublisherBandwidth, bool) {
+	if e == nil {
+		return PublisherBandwidth{}, false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	p, ok := e.publishers[clientID]
+	if !ok || p.maxBitrate == 0 {
+		return PublisherBandwidth{}, false
+	}
+	return PublisherBandwidth{
+		Bitrate:    p.bitrate,
+		MaxBitrate: p.maxBitrate,
+		State:      p.state,
+		Since:      p.since,
+	}, true
+}
+
+// NewInterceptor also returns interceptors for publishers without a maximum
+// bitrate, since one can be set with SetPeerMaxBitrate at any time.
+func (e *BandwidthEnforcer) NewInterceptor(params InterceptorParams) (Interceptor, error) {
+	i := &bandwidthInterceptor{
+		enforcer: e,
+		clientID: params.ClientID,
+		ssrc:     params.LocalTrack.SSRC(),
+		video:    params.LocalTrack.Kind() == webrtc.RTPCodecTypeVideo,
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	p, ok := e.publishers[params.ClientID]
+	if !ok {
+		p = &publisherBandwidth{
+			room:         params.Room,
+			maxBitrate:   uint64(e.maxBitrate(params.Room, params.ClientID)),
+			interceptors: map[*bandwidthInterceptor]struct{}{},
+			state:        BandwidthStateOK,
+			since:        time.Now(),
+		}
+		e.publishers[params.ClientID] = p
+	}
+	p.interceptors[i] = struct{}{}
+
+	return i, nil
+}
+
+func (e *BandwidthEnforcer) remove(i *bandwidthInterceptor) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	p, ok := e.publishers[i.clientID]
+	if !ok {
+		return
+	}
+	delete(p.interceptors, i)
+	if len(p.interceptors) == 0 {
+		delete(e.publishers, i.clientID)
+	}
+}
+
+// bandwidthEffect is the outcome of a received packet, applied after mu has
+// been released.
+type bandwidthEffect struct {
+	forward    bool
+	remb       *rtcp.ReceiverEstimatedMaximumBitrate
+	disconnect bool
+	room       string
+}
+
+func (e *BandwidthEnforcer) receive(i *bandwidthInterceptor, size int, now time.Time) (effect bandwidthEffect) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	effect.forward = true
+
+	p, ok := e.publishers[i.clientID]
+	if !ok {
+		return
+	}
+
+	if p.windowStart.IsZero() {
+		p.windowStart = now
+	} else if elapsed := now.Sub(p.windowStart); elapsed >= e.window {
+		p.bitrate = p.windowBytes * 8 * uint64(time.Second) / uint64(elapsed)
+		p.windowStart = now
+		p.windowBytes = 0
+		p.forwardedBytes = 0
+
+		effect = e.update(i.clientID, p, now)
+		effect.forward = true
+	}
+
+	p.windowBytes += uint64(size)
+
+	// Audio is never dropped, it uses a small fraction of the bandwidth and
+	// the meeting is unusable without it.
+	if p.state == BandwidthStateDropping && i.video {
+		budget := p.maxBitrate / 8 * uint64(e.window) / uint64(time.Second)
+		if p.forwardedBytes+uint64(size) > budget {
+			effect.forward = false
+			return
+		}
+	}
+	p.forwardedBytes += uint64(size)
+
+	return
+}
+
+// update is called with mu held after the bitrate of a publisher has been
+// measured.
+func (e *BandwidthEnforcer) update(clientID string, p *publisherBandwidth, now time.Time) (effect bandwidthEffect) {
+	if p.maxBitrate == 0 {
+		return effect
+	}
+
+	tolerated := uint64(float64(p.maxBitrate) * e.config.Tolerance)
+
+	if p.bitrate > p.maxBitrate {
+		p.compliantSince = time.Time{}
+	} else if p.compliantSince.IsZero() {
+		p.compliantSince = now
+	}
+
+	if p.bitrate > tolerated {
+		if p.exceededSince.IsZero() {
+			p.exceededSince = now
+		}
+	} else {
+		p.exceededSince = time.Time{}
+	}
+
+	switch p.state {
+	case BandwidthStateOK:
+		if !p.exceededSince.IsZero() {
+			e.setState(p, BandwidthStateExceeding, now)
+		}
+	case BandwidthStateExceeding:
+		if p.exceededSince.IsZero() {
+			e.setState(p, BandwidthStateOK, now)
+		} else if now.Sub(p.exceededSince) >= e.config.GracePeriod {
+			effect = e.enforce(clientID, p, now)
+		}
+	default:
+		if !p.compliantSince.IsZero() && now.Sub(p.compliantSince) >= e.config.GracePeriod {
+			e.log.Printf("[%s] Publisher in room: %s back within max bitrate: %d, was: %s",
+				clientID, p.room, p.maxBitrate, p.state)
+			e.setState(p, BandwidthStateOK, now)
+		}
+	}
+
+	if e.limitsREMB(p) {
+		// REMB has to be repeated, otherwise the publisher's congestion
+		// controller ramps the bitrate up again.
+		effect.remb = e.newREMB(p)
+	}
+
+	return effect
+}
+
+func (e *BandwidthEnforcer) enforce(clientID string, p *publisherBandwidth, now time.Time) (effect bandwidthEffect) {
+	e.log.Printf("[%s] Publisher in room: %s exceeds max bitrate: %d, bitrate: %d, action: %s",
+		clientID, p.room, p.maxBitrate, p.bitrate, e.config.Action)
+
+	switch e.config.Action {
+	case BandwidthActionThrottle:
+		e.setState(p, BandwidthStateThrottled, now)
+	case BandwidthActionDrop:
+		e.setState(p, BandwidthStateDropping, now)
+	case BandwidthActionDisconnect:
+		e.setState(p, BandwidthStateDisconnected, now)
+		effect.disconnect = true
+		effect.room = p.room
+	default:
+		e.setState(p, BandwidthStateWarned, now)
+	}
+
+	return effect
+}
+
+func (e *BandwidthEnforcer) setState(p *publisherBandwidth, state BandwidthState, now time.Time) {
+	p.state = state
+	p.since = now
+}
+
+func (e *BandwidthEnforcer) newREMB(p *publisherBandwidth) *rtcp.ReceiverEstimatedMaximumBitrate {
+	ssrcs := make([]uint32, 0, len(p.interceptors))
+	for i := range p.interceptors {
+		ssrcs = append(ssrcs, i.ssrc)
+	}
+	return &rtcp.ReceiverEstimatedMaximumBitrate{
+		Bitrate: p.maxBitrate,
+		SSRCs:   ssrcs,
+	}
+}
+
+// limitsREMB returns true when REMB feedback sent to p must not exceed its
+// maximum bitrate. It must be called with mu held.
+func (e *BandwidthEnforcer) limitsREMB(p *publisherBandwidth) bool {
+	return p.maxBitrate > 0 && (e.config.Advertise || p.state == BandwidthStateThrottled)
+}
+
+// capREMB lowers REMB feedback from subscribers to the maximum bitrate of
+// throttled publishers, or of all publishers when the maximum bitrate is
+// advertised, so that it does not override the REMB sent by the enforcer.
+func (e *BandwidthEnforcer) capREMB(clientID string, packets []rtcp.Packet) []rtcp.Packet {
+	e.mu.Lock()
+	p, ok := e.publishers[clientID]
+	limited := ok && e.limitsREMB(p)
+	var maxBitrate uint64
+	if ok {
+		maxBitrate = p.maxBitrate
+	}
+	e.mu.Unlock()
+
+	if !limited {
+		return packets
+	}
+
+	capped := make([]rtcp.Packet, len(packets))
+	for idx, packet := range packets {
+		if remb, ok := packet.(*rtcp.ReceiverEstimatedMaximumBitrate); ok && remb.Bitrate > maxBitrate {
+			lowered := *remb
+			lowered.Bitrate = maxBitrate
+			packet = &lowered
+		}
+		capped[idx] = packet
+	}
+	return capped
+}
+
+type bandwidthInterceptor struct {
+	enforcer *BandwidthEnforcer
+	clientID string
+	ssrc     uint32
+	video    bool
+
+	mu   sync.Mutex
+	rtcp RTCPWriter
+}
+
+func (i *bandwidthInterceptor) BindRTP(next RTPWriter) RTPWriter {
+	return RTPWriterFunc(func(packet *rtp.Packet) error {
+		effect := i.enforcer.receive(i, len(packet.Payload), time.Now())
+
+		if effect.remb != nil {
+			i.mu.Lock()
+			feedback := i.rtcp
+			i.mu.Unlock()
+
+			if feedback != nil {
+				if err := feedback.WriteRTCP([]rtcp.Packet{effect.remb}); err != nil {
+					i.enforcer.log.Printf("[%s] Error sending REMB: %s", i.clientID, err)
+				}
+			}
+		}
+
+		if effect.disconnect && i.enforcer.disconnect != nil {
+			go i.enforcer.disconnect(effect.room, i.clientID)
+		}
+
+		if !effect.forward {
+			return nil
+		}
+		return next.WriteRTP(packet)
+	})
+}
+
+func (i *bandwidthInterceptor) BindRTCP(next RTCPWriter) RTCPWriter {
+	i.mu.Lock()
+	i.rtcp = next
+	i.mu.Unlock()
+
+	return RTCPWriterFunc(func(packets []rtcp.Packet) error {
+		return next.WriteRTCP(i.enforcer.capREMB(i.clientID, packets))
+	})
+}
+
+func (i *bandwidthInterceptor) Close() error {
+	i.enforcer.remove(i)
+	return nil
+}
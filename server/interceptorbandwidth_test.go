@@ -0,0 +1,208 @@
+package server
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server/logger"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBandwidthInterceptor(t *testing.T, e *BandwidthEnforcer, room string) *bandwidthInterceptor {
+	t.Helper()
+	codec := webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000)
+	track, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeVP8, 1234, "track-id", "track-label", codec)
+	require.NoError(t, err)
+
+	interceptor, err := e.NewInterceptor(InterceptorParams{
+		ClientID:   "a",
+		Room:       room,
+		LocalTrack: track,
+	})
+	require.NoError(t, err)
+	return interceptor.(*bandwidthInterceptor)
+}
+
+// sendBandwidth sends ten packets per second for the given number of
+// seconds and returns the time after the last packet and the number of
+// forwarded bytes.
+func sendBandwidth(e *BandwidthEnforcer, i *bandwidthInterceptor, now time.Time, seconds int, bytesPerSecond int, onEffect func(bandwidthEffect)) (time.Time, int) {
+	forwarded := 0
+	for n := 0; n < seconds*10; n++ {
+		effect := e.receive(i, bytesPerSecond/10, now)
+		if effect.forward {
+			forwarded += bytesPerSecond / 10
+		}
+		if onEffect != nil {
+			onEffect(effect)
+		}
+		now = now.Add(100 * time.Millisecond)
+	}
+	return now, forwarded
+}
+
+func newTestBandwidthEnforcer(action BandwidthAction) *BandwidthEnforcer {
+	return NewBandwidthEnforcer(logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout), BandwidthPolicyConfig{
+		MaxBitrate:  8000,
+		Rooms:       map[string]int{"unlimited": 0},
+		GracePeriod: 2 * time.Second,
+		Action:      action,
+	})
+}
+
+func TestBandwidthEnforcer_throttle(t *testing.T) {
+	e := newTestBandwidthEnforcer(BandwidthActionThrottle)
+	i := newTestBandwidthInterceptor(t, e, "room")
+
+	var rembs int
+	countREMB := func(effect bandwidthEffect) {
+		if effect.remb != nil {
+			assert.Equal(t, uint64(8000), effect.remb.Bitrate)
+			assert.Equal(t, []uint32{1234}, effect.remb.SSRCs)
+			rembs++
+		}
+	}
+
+	now, _ := sendBandwidth(e, i, time.Unix(0, 0), 2, 2000, countREMB)
+	bandwidth, ok := e.PublisherBandwidth("a")
+	require.True(t, ok)
+	assert.Equal(t, BandwidthStateExceeding, bandwidth.State)
+	assert.Equal(t, uint64(8000), bandwidth.MaxBitrate)
+	assert.Equal(t, 0, rembs)
+
+	now, _ = sendBandwidth(e, i, now, 3, 2000, countREMB)
+	bandwidth, _ = e.PublisherBandwidth("a")
+	assert.Equal(t, BandwidthStateThrottled, bandwidth.State)
+	assert.InDelta(t, 16000, bandwidth.Bitrate, 1000)
+	assert.Greater(t, rembs, 0)
+
+	// within max bitrate, but not for longer than the grace period yet
+	now, _ = sendBandwidth(e, i, now, 1, 500, countREMB)
+	bandwidth, _ = e.PublisherBandwidth("a")
+	assert.Equal(t, BandwidthStateThrottled, bandwidth.State)
+
+	sendBandwidth(e, i, now, 3, 500, nil)
+	bandwidth, _ = e.PublisherBandwidth("a")
+	assert.Equal(t, BandwidthStateOK, bandwidth.State)
+
+	require.NoError(t, i.Close())
+	_, ok = e.PublisherBandwidth("a")
+	assert.False(t, ok)
+}
+
+func TestBandwidthEnforcer_burst(t *testing.T) {
+	e := newTestBandwidthEnforcer(BandwidthActionDisconnect)
+	i := newTestBandwidthInterceptor(t, e, "room")
+
+	now := time.Unix(0, 0)
+	for n := 0; n < 5; n++ {
+		now, _ = sendBandwidth(e, i, now, 1, 2000, func(effect bandwidthEffect) {
+			assert.False(t, effect.disconnect)
+		})
+		now, _ = sendBandwidth(e, i, now, 1, 500, nil)
+	}
+	sendBandwidth(e, i, now, 1, 500, nil)
+
+	bandwidth, _ := e.PublisherBandwidth("a")
+	assert.Equal(t, BandwidthStateOK, bandwidth.State)
+}
+
+func TestBandwidthEnforcer_drop(t *testing.T) {
+	e := newTestBandwidthEnforcer(BandwidthActionDrop)
+	i := newTestBandwidthInterceptor(t, e, "room")
+
+	now, forwarded := sendBandwidth(e, i, time.Unix(0, 0), 4, 2000, nil)
+	// dropping starts after the grace period following the first window
+	assert.Equal(t, 7000, forwarded)
+	bandwidth, _ := e.PublisherBandwidth("a")
+	assert.Equal(t, BandwidthStateDropping, bandwidth.State)
+
+	_, forwarded = sendBandwidth(e, i, now, 2, 2000, nil)
+	assert.Equal(t, 2000, forwarded)
+}
+
+func TestBandwidthEnforcer_disconnect(t *testing.T) {
+	e := newTestBandwidthEnforcer(BandwidthActionDisconnect)
+	i := newTestBandwidthInterceptor(t, e, "room")
+
+	var disconnects int
+	sendBandwidth(e, i, time.Unix(0, 0), 5, 2000, func(effect bandwidthEffect) {
+		if effect.disconnect {
+			assert.Equal(t, "room", effect.room)
+			disconnects++
+		}
+	})
+
+	assert.Equal(t, 1, disconnects)
+	bandwidth, _ := e.PublisherBandwidth("a")
+	assert.Equal(t, BandwidthStateDisconnected, bandwidth.State)
+}
+
+func TestBandwidthEnforcer_roomOverride(t *testing.T) {
+	e := newTestBandwidthEnforcer(BandwidthActionDisconnect)
+	i := newTestBandwidthInterceptor(t, e, "unlimited")
+
+	sendBandwidth(e, i, time.Unix(0, 0), 5, 2000, func(effect bandwidthEffect) {
+		assert.False(t, effect.disconnect)
+	})
+	_, ok := e.PublisherBandwidth("a")
+	assert.False(t, ok)
+}
+
+func TestBandwidthEnforcer_peerOverride(t *testing.T) {
+	e := newTestBandwidthEnforcer(BandwidthActionThrottle)
+	i := newTestBandwidthInterceptor(t, e, "unlimited")
+
+	e.SetPeerMaxBitrate("a", 4000)
+	assert.Equal(t, 4000, e.MaxBitrate("unlimited", "a"))
+	assert.Equal(t, 8000, e.MaxBitrate("room", "b"))
+
+	sendBandwidth(e, i, time.Unix(0, 0), 5, 1000, nil)
+	bandwidth, ok := e.PublisherBandwidth("a")
+	require.True(t, ok)
+	assert.Equal(t, uint64(4000), bandwidth.MaxBitrate)
+	assert.Equal(t, BandwidthStateThrottled, bandwidth.State)
+
+	e.SetPeerMaxBitrate("a", 0)
+	assert.Equal(t, 0, e.MaxBitrate("unlimited", "a"))
+	_, ok = e.PublisherBandwidth("a")
+	assert.False(t, ok)
+}
+
+func TestBandwidthEnforcer_tenant(t *testing.T) {
+	e := newTestBandwidthEnforcer(BandwidthActionThrottle)
+	tenants, err := NewTenants([]TenantConfig{{Name: "acme", JoinSecret: "secret", MaxBitrate: 4000}})
+	require.NoError(t, err)
+	e.SetTenants(tenants)
+
+	assert.Equal(t, 4000, e.MaxBitrate("acme:room", "a"))
+	assert.Equal(t, 4000, e.MaxBitrate("acme:room/breakout", "a"))
+	assert.Equal(t, 8000, e.MaxBitrate("room", "a"))
+	assert.Equal(t, 0, e.MaxBitrate("unlimited", "a"))
+}
+
+func TestBandwidthEnforcer_advertise(t *testing.T) {
+	e := NewBandwidthEnforcer(logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout), BandwidthPolicyConfig{
+		MaxBitrate: 8000,
+		Advertise:  true,
+	})
+	i := newTestBandwidthInterceptor(t, e, "room")
+
+	var rembs int
+	sendBandwidth(e, i, time.Unix(0, 0), 3, 500, func(effect bandwidthEffect) {
+		if effect.remb != nil {
+			assert.Equal(t, uint64(8000), effect.remb.Bitrate)
+			rembs++
+		}
+	})
+	assert.Equal(t, 2, rembs, "REMB is sent after every window")
+
+	packets := e.capREMB("a", []rtcp.Packet{
+		&rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: 100000, SSRCs: []uint32{1234}},
+	})
+	assert.Equal(t, uint64(8000), packets[0].(*rtcp.ReceiverEstimatedMaximumBitrate).Bitrate)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// defaultNACKBufferSize must be a power of two so that uint16 sequence
+// numbers wrap around cleanly.
+const defaultNACKBufferSize = 512
+
+// NewNACKResponderFactory creates interceptors which keep a buffer of recently
+// forwarded packets and retransmit them when a subscriber sends a NACK. Only
+// NACKs for packets which are no longer in the buffer are forwarded to the
+// publisher.
+func NewNACKResponderFactory(loggerFactory LoggerFactory) InterceptorFactory {
+	return newNACKResponderFactory(loggerFactory)
+}
+
+type nackResponderFactory struct {
+	log Logger
+	// bufferSize is the number of packets buffered for tracks published
+	// afterwards, a power of two
+	bufferSize int32
+}
+
+func newNACKResponderFactory(loggerFactory LoggerFactory) *nackResponderFactory {
+	return &nackResponderFactory{
+		log:        loggerFactory.GetLogger("nack"),
+		bufferSize: defaultNACKBufferSize,
+	}
+}
+
+// setBufferSize changes the buffer size of tracks published afterwards.
+// size must be a power of two.
+func (f *nackResponderFactory) setBufferSize(size int) {
+	atomic.StoreInt32(&f.bufferSize, int32(size))
+}
+
+func (f *nackResponderFactory) NewInterceptor(params InterceptorParams) (Interceptor, error) {
+	size := atomic.LoadInt32(&f.bufferSize)
+
+	return &nackResponder{
+		log:      f.log,
+		clientID: params.ClientID,
+		ssrc:     params.LocalTrack.SSRC(),
+		packets:  make([]*rtp.Packet, size),
+		mask:     uint16(size - 1),
+	}, nil
+}
+
+type nackResponder struct {
+	NoOpInterceptor
+
+	log      Logger
+	clientID string
+	ssrc     uint32
+
+	mu        sync.Mutex
+	packets   []*rtp.Packet
+	mask      uint16
+	rtpWriter RTPWriter
+}
+
+func (n *nackResponder) BindRTP(next RTPWriter) RTPWriter {
+	n.rtpWriter = next
+
+	return RTPWriterFunc(func(packet *rtp.Packet) error {
+		n.mu.Lock()
+		n.packets[packet.SequenceNumber&n.mask] = packet
+		n.mu.Unlock()
+
+		return next.WriteRTP(packet)
+	})
+}
+
+func (n *nackResponder) BindRTCP(next RTCPWriter) RTCPWriter {
+	return RTCPWriterFunc(func(packets []rtcp.Packet) error {
+		forward := make([]rtcp.Packet, 0, len(packets))
+
+		for _, packet := range packets {
+			nack, ok := packet.(*rtcp.TransportLayerNack)
+			if !ok || nack.MediaSSRC != n.ssrc {
+				forward = append(forward, packet)
+				continue
+			}
+
+			if missing := n.retransmit(nack); len(missing) > 0 {
+				forward = append(forward, &rtcp.TransportLayerNack{
+					SenderSSRC: nack.SenderSSRC,
+					MediaSSRC:  nack.MediaSSRC,
+					Nacks:      nackPairsFromSequenceNumbers(missing),
+				})
+			}
+		}
+
+		if len(forward) == 0 {
+			return nil
+		}
+
+		return next.WriteRTCP(forward)
+	})
+}
+
+// retransmit writes all requested packets found in the buffer and returns
+// sequence numbers of the packets that were not found. Since the local track
+// is shared, retransmitted packets are sent to all subscribers.
+func (n *nackResponder) retransmit(nack *rtcp.TransportLayerNack) (missing []uint16) {
+	for _, pair := range nack.Nacks {
+		for _, seq := range pair.PacketList() {
+			n.mu.Lock()
+			packet := n.packets[seq&n.mask]
+			n.mu.Unlock()
+
+			if packet == nil || packet.SequenceNumber != seq {
+				missing = append(missing, seq)
+				continue
+			}
+
+			if err := n.rtpWriter.WriteRTP(packet); err != nil {
+				n.log.Printf("[%s] Error retransmitting packet ssrc: %d, seq: %d: %s", n.clientID, n.ssrc, seq, err)
+			}
+		}
+	}
+	return
+}
+
+func nackPairsFromSequenceNumbers(seqs []uint16) (pairs []rtcp.NackPair) {
+	for _, seq := range seqs {
+		if len(pairs) > 0 {
+			last := &pairs[len(pairs)-1]
+			if diff := seq - last.PacketID; diff > 0 && diff <= 16 {
+				last.LostPackets |= rtcp.PacketBitmap(1 << (diff - 1))
+				continue
+			}
+		}
+		pairs = append(pairs, rtcp.NackPair{PacketID: seq})
+	}
+	return
+}
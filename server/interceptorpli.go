@@ -0,0 +1,273 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+const (
+	rtcpPLIInterval    = time.Second * 3
+	rtcpPLIMinInterval = time.Millisecond * 500
+	// Screen shares are unreadable until the next keyframe, so keyframe
+	// requests from their subscribers are throttled less.
+	rtcpPLIMinIntervalScreen = time.Millisecond * 100
+)
+
+// KeyframeRequester requests keyframes from publishers on behalf of all
+// subscribers of their tracks. It creates an interceptor for every track,
+// which takes keyframe requests of subscribers.
+//
+// A keyframe is requested every interval, and whenever a subscriber asks for
+// one, but no more often than once per minInterval for each track, or
+// screenMinInterval for screen shares. Requests within the minimum interval
+// are coalesced into a single request sent when the interval has passed, so
+// that no subscriber is left waiting for the next periodic keyframe.
+//
+// Periodic and delayed requests of all tracks are sent by a single goroutine,
+// which runs only while there are tracks.
+type KeyframeRequester struct {
+	log               Logger
+	interval          time.Duration
+	cameraMinInterval time.Duration
+	screenMinInterval time.Duration
+
+	mu      sync.Mutex
+	tracks  map[*keyframeTrack]struct{}
+	running bool
+	wake    chan struct{}
+}
+
+// NewPLIThrottlerFactory creates a KeyframeRequester.
+func NewPLIThrottlerFactory(loggerFactory LoggerFactory, interval time.Duration, minInterval time.Duration, screenMinInterval time.Duration) *KeyframeRequester {
+	return &KeyframeRequester{
+		log:               loggerFactory.GetLogger("pli"),
+		interval:          interval,
+		cameraMinInterval: minInterval,
+		screenMinInterval: screenMinInterval,
+		tracks:            map[*keyframeTrack]struct{}{},
+		wake:              make(chan struct{}, 1),
+	}
+}
+
+var _ InterceptorFactory = &KeyframeRequester{}
+
+func (r *KeyframeRequester) NewInterceptor(params InterceptorParams) (Interceptor, error) {
+	t := &keyframeTrack{
+		requester: r,
+		clientID:  params.ClientID,
+		ssrc:      params.LocalTrack.SSRC(),
+	}
+	t.SetSource(params.Source)
+	return t, nil
+}
+
+// keyframeTrack is the interceptor of a single track. All fields except the
+// constant ones are guarded by the mutex of the requester.
+type keyframeTrack struct {
+	NoOpInterceptor
+
+	requester *KeyframeRequester
+	clientID  string
+	ssrc      uint32
+
+	writer      RTCPWriter
+	screen      bool
+	minInterval time.Duration
+	lastPLI     time.Time
+	// pending is true when a subscriber requested a keyframe within the
+	// minimum interval
+	pending bool
+	closed  bool
+}
+
+func (t *keyframeTrack) BindRTCP(next RTCPWriter) RTCPWriter {
+	t.requester.add(t, next)
+
+	return RTCPWriterFunc(func(packets []rtcp.Packet) error {
+		forward := make([]rtcp.Packet, 0, len(packets))
+
+		for _, packet := range packets {
+			if pli, ok := packet.(*rtcp.PictureLossIndication); ok && pli.MediaSSRC == t.ssrc {
+				t.requester.request(t)
+				continue
+			}
+			forward = append(forward, packet)
+		}
+
+		if len(forward) == 0 {
+			return nil
+		}
+
+		return next.WriteRTCP(forward)
+	})
+}
+
+func (t *keyframeTrack) SetSource(source TrackSource) {
+	r := t.requester
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t.screen = source == TrackSourceScreen
+	t.minInterval = r.minInterval(t)
+}
+
+func (t *keyframeTrack) Close() error {
+	t.requester.remove(t)
+	return nil
+}
+
+// SetIntervals changes the intervals of all tracks, including those already
+// published.
+func (r *KeyframeRequester) SetIntervals(interval time.Duration, minInterval time.Duration, screenMinInterval time.Duration) {
+	r.mu.Lock()
+	r.interval = interval
+	r.cameraMinInterval = minInterval
+	r.screenMinInterval = screenMinInterval
+	for t := range r.tracks {
+		t.minInterval = r.minInterval(t)
+	}
+	r.mu.Unlock()
+
+	r.notify()
+}
+
+// minInterval returns the minimum interval of t. It must be called with mu
+// held.
+func (r *KeyframeRequester) minInterval(t *keyframeTrack) time.Duration {
+	if t.screen {
+		return r.screenMinInterval
+	}
+	return r.cameraMinInterval
+}
+
+// add starts requesting keyframes of t, the first one right away.
+func (r *KeyframeRequester) add(t *keyframeTrack, writer RTCPWriter) {
+	r.mu.Lock()
+	if t.closed {
+		r.mu.Unlock()
+		return
+	}
+	t.writer = writer
+	r.tracks[t] = struct{}{}
+	if !r.running {
+		r.running = true
+		go r.run()
+	}
+	r.mu.Unlock()
+
+	r.notify()
+}
+
+func (r *KeyframeRequester) remove(t *keyframeTrack) {
+	r.mu.Lock()
+	t.closed = true
+	delete(r.tracks, t)
+	r.mu.Unlock()
+
+	r.notify()
+}
+
+// request sends a keyframe request for t, or delays it until the minimum
+// interval has passed.
+func (r *KeyframeRequester) request(t *keyframeTrack) {
+	r.mu.Lock()
+	if t.closed || t.writer == nil {
+		r.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(t.lastPLI) < t.minInterval {
+		if !t.pending {
+			t.pending = true
+			r.mu.Unlock()
+			r.notify()
+			return
+		}
+		r.mu.Unlock()
+		return
+	}
+
+	t.lastPLI = now
+	t.pending = false
+	writer := t.writer
+	r.mu.Unlock()
+
+	r.write(t, writer)
+}
+
+func (r *KeyframeRequester) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *KeyframeRequester) run() {
+	for {
+		due, wait, ok := r.due(time.Now())
+		if !ok {
+			return
+		}
+
+		for t, writer := range due {
+			r.write(t, writer)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-r.wake:
+		}
+		timer.Stop()
+	}
+}
+
+// due returns the tracks whose keyframe requests are due at now, with their
+// writers, and how long to wait until the next request is. Returns false
+// when there are no tracks left, after which run must return.
+func (r *KeyframeRequester) due(now time.Time) (map[*keyframeTrack]RTCPWriter, time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.tracks) == 0 {
+		r.running = false
+		return nil, 0, false
+	}
+
+	due := map[*keyframeTrack]RTCPWriter{}
+	wait := r.interval
+
+	for t := range r.tracks {
+		deadline := t.lastPLI.Add(r.interval)
+		if pending := t.lastPLI.Add(t.minInterval); t.pending && pending.Before(deadline) {
+			deadline = pending
+		}
+
+		if !deadline.After(now) {
+			t.lastPLI = now
+			t.pending = false
+			due[t] = t.writer
+			continue
+		}
+
+		if d := deadline.Sub(now); d < wait {
+			wait = d
+		}
+	}
+
+	return due, wait, true
+}
+
+func (r *KeyframeRequester) write(t *keyframeTrack, writer RTCPWriter) {
+	err := writer.WriteRTCP([]rtcp.Packet{
+		&rtcp.PictureLossIndication{
+			MediaSSRC: t.ssrc,
+		},
+	})
+	if err != nil {
+		r.log.Printf("[%s] Error sending rtcp PLI for ssrc: %d: %s", t.clientID, t.ssrc, err)
+	}
+}
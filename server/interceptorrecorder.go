@@ -0,0 +1,63 @@
+package server
+
+import (
+	"io"
+
+	"github.com/pion/rtp"
+)
+
+type RTPWriteCloser interface {
+	RTPWriter
+	io.Closer
+}
+
+// NewRecorderTapFactory creates interceptors which copy every RTP packet of
+// a track to a writer created by newWriter. Errors returned by the writer
+// are logged, but do not affect forwarding to subscribers. End-to-end
+// encrypted tracks are not recorded.
+func NewRecorderTapFactory(
+	loggerFactory LoggerFactory,
+	newWriter func(params InterceptorParams) (RTPWriteCloser, error),
+) InterceptorFactory {
+	log := loggerFactory.GetLogger("recorder")
+
+	return InterceptorFactoryFunc(func(params InterceptorParams) (Interceptor, error) {
+		if params.Encrypted {
+			log.Printf("[%s] Not recording encrypted track: %s", params.ClientID, params.LocalTrack.ID())
+			return NoOpInterceptor{}, nil
+		}
+
+		writer, err := newWriter(params)
+		if err != nil {
+			return nil, err
+		}
+
+		return &recorderTap{
+			log:    log,
+			params: params,
+			writer: writer,
+		}, nil
+	})
+}
+
+type recorderTap struct {
+	NoOpInterceptor
+
+	log    Logger
+	params InterceptorParams
+	writer RTPWriteCloser
+}
+
+func (r *recorderTap) BindRTP(next RTPWriter) RTPWriter {
+	return RTPWriterFunc(func(packet *rtp.Packet) error {
+		if err := r.writer.WriteRTP(packet); err != nil {
+			r.log.Printf("[%s] Error recording packet of track: %s: %s", r.params.ClientID, r.params.LocalTrack.ID(), err)
+		}
+
+		return next.WriteRTP(packet)
+	})
+}
+
+func (r *recorderTap) Close() error {
+	return r.writer.Close()
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+)
+
+// reorderBufferSize is the maximum number of packets held back by a reorder
+// buffer. When it is full, the oldest packets are written without waiting
+// for missing ones.
+const reorderBufferSize = 128
+
+// NewReorderBufferFactory creates interceptors which put video packets that
+// arrive out of order back in order. A packet which arrives after a missing
+// one is held back until the missing one arrives, or for at most the delay
+// of the room. Packets arriving after they were given up on are written
+// right away, like retransmissions.
+func NewReorderBufferFactory(loggerFactory LoggerFactory, config ReorderConfig) InterceptorFactory {
+	log := loggerFactory.GetLogger("reorder")
+
+	return InterceptorFactoryFunc(func(params InterceptorParams) (Interceptor, error) {
+		delay := config.RoomDelay(params.Room)
+		if delay <= 0 || params.LocalTrack.Kind() != webrtc.RTPCodecTypeVideo {
+			return NoOpInterceptor{}, nil
+		}
+
+		return &reorderBuffer{
+			log:      log,
+			clientID: params.ClientID,
+			ssrc:     params.LocalTrack.SSRC(),
+			delay:    delay,
+			packets:  map[uint16]reorderedPacket{},
+		}, nil
+	})
+}
+
+type reorderBuffer struct {
+	NoOpInterceptor
+
+	log      Logger
+	clientID string
+	ssrc     uint32
+	delay    time.Duration
+
+	mu      sync.Mutex
+	next    RTPWriter
+	started bool
+	// nextSeq is the sequence number of the next packet to write
+	nextSeq uint16
+	// packets held back, key is sequence number
+	packets map[uint16]reorderedPacket
+	timer   *time.Timer
+	closed  bool
+}
+
+type reorderedPacket struct {
+	packet     *rtp.Packet
+	receivedAt time.Time
+}
+
+func (r *reorderBuffer) BindRTP(next RTPWriter) RTPWriter {
+	r.next = next
+
+	return RTPWriterFunc(func(packet *rtp.Packet) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		return r.push(packet, time.Now())
+	})
+}
+
+// push writes packet, or holds it back until the packets before it arrive.
+// Must be called with r.mu held.
+func (r *reorderBuffer) push(packet *rtp.Packet, now time.Time) error {
+	seq := packet.SequenceNumber
+
+	if !r.started {
+		r.started = true
+		r.nextSeq = seq
+	}
+
+	diff := int16(seq - r.nextSeq)
+	switch {
+	case diff < 0:
+		// the packet was given up on, or is a duplicate
+		return r.next.WriteRTP(packet)
+	case diff >= reorderBufferSize:
+		// the stream jumped ahead, for example after the publisher restarted
+		// it, so there is no point in waiting
+		if err := r.flush(); err != nil {
+			return err
+		}
+		r.nextSeq = seq
+	}
+
+	r.packets[seq] = reorderedPacket{packet: packet, receivedAt: now}
+
+	if err := r.writeInOrder(); err != nil {
+		return err
+	}
+	if err := r.writeExpired(now); err != nil {
+		return err
+	}
+	r.schedule(now)
+	return nil
+}
+
+// writeInOrder writes the packets which are next in sequence.
+func (r *reorderBuffer) writeInOrder() error {
+	for {
+		p, ok := r.packets[r.nextSeq]
+		if !ok {
+			return nil
+		}
+		delete(r.packets, r.nextSeq)
+		r.nextSeq++
+		if err := r.next.WriteRTP(p.packet); err != nil {
+			return err
+		}
+	}
+}
+
+// writeExpired skips missing packets while a packet held back has waited
+// for longer than the delay, or while the buffer is full.
+func (r *reorderBuffer) writeExpired(now time.Time) error {
+	for len(r.packets) > 0 {
+		oldest, ok := r.oldest()
+		if !ok {
+			return nil
+		}
+		if len(r.packets) < reorderBufferSize && now.Sub(r.firstReceivedAt()) < r.delay {
+			return nil
+		}
+
+		r.nextSeq = oldest
+		if err := r.writeInOrder(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush writes all held back packets in order, skipping missing ones.
+func (r *reorderBuffer) flush() error {
+	for len(r.packets) > 0 {
+		oldest, ok := r.oldest()
+		if !ok {
+			return nil
+		}
+		r.nextSeq = oldest
+		if err := r.writeInOrder(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// firstReceivedAt returns when the packet which has been held back for the
+// longest was received.
+func (r *reorderBuffer) firstReceivedAt() time.Time {
+	var first time.Time
+	for _, p := range r.packets {
+		if first.IsZero() || p.receivedAt.Before(first) {
+			first = p.receivedAt
+		}
+	}
+	return first
+}
+
+// oldest returns the lowest sequence number of packets held back.
+func (r *reorderBuffer) oldest() (uint16, bool) {
+	for i := uint16(0); i < reorderBufferSize; i++ {
+		if _, ok := r.packets[r.nextSeq+i]; ok {
+			return r.nextSeq + i, true
+		}
+	}
+	return 0, false
+}
+
+// schedule writes held back packets once the first one expires, in case no
+// more packets arrive until then.
+func (r *reorderBuffer) schedule(now time.Time) {
+	if r.timer != nil || r.closed || len(r.packets) == 0 {
+		return
+	}
+
+	wait := r.delay - now.Sub(r.firstReceivedAt())
+	r.timer = time.AfterFunc(wait, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.timer = nil
+		if r.closed {
+			return
+		}
+
+		now := time.Now()
+		if err := r.writeExpired(now); err != nil {
+			r.log.Printf("[%s] Error writing reordered packets of ssrc: %d: %s", r.clientID, r.ssrc, err)
+		}
+		r.schedule(now)
+	})
+}
+
+func (r *reorderBuffer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	r.packets = map[uint16]reorderedPacket{}
+	return nil
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+)
+
+// rewindMinInterval limits how often the buffer of a single track is
+// replayed.
+const rewindMinInterval = time.Second
+
+// NewRewindBufferFactory creates interceptors which buffer the packets of a
+// VP8 video track since its last keyframe, for up to duration. When a
+// subscriber requests a keyframe, the buffer is replayed so that a newly
+// joined subscriber can render video before the next keyframe arrives.
+// Subscribers which already received the packets discard them as
+// duplicates.
+//
+// The memory used by all buffers of tracks in a single room is limited to
+// maxRoomBytes. A buffer which does not fit is dropped until the track's
+// next keyframe.
+func NewRewindBufferFactory(loggerFactory LoggerFactory, duration time.Duration, maxRoomBytes int) InterceptorFactory {
+	log := loggerFactory.GetLogger("rewind")
+	budget := &rewindBudget{
+		maxRoomBytes: maxRoomBytes,
+		roomBytes:    map[string]int{},
+	}
+
+	return InterceptorFactoryFunc(func(params InterceptorParams) (Interceptor, error) {
+		codec := params.LocalTrack.Codec()
+		// Keyframes cannot be detected in encrypted payloads
+		if params.Encrypted || codec == nil || codec.Name != webrtc.VP8 {
+			return NoOpInterceptor{}, nil
+		}
+
+		return &rewindBuffer{
+			log:      log,
+			budget:   budget,
+			room:     params.Room,
+			clientID: params.ClientID,
+			ssrc:     params.LocalTrack.SSRC(),
+			duration: duration,
+		}, nil
+	})
+}
+
+type rewindBudget struct {
+	maxRoomBytes int
+
+	mu        sync.Mutex
+	roomBytes map[string]int
+}
+
+func (b *rewindBudget) reserve(room string, size int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.maxRoomBytes > 0 && b.roomBytes[room]+size > b.maxRoomBytes {
+		return false
+	}
+	b.roomBytes[room] += size
+	return true
+}
+
+func (b *rewindBudget) release(room string, size int) {
+	if size == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.roomBytes[room] -= size
+	if b.roomBytes[room] <= 0 {
+		delete(b.roomBytes, room)
+	}
+}
+
+type rewindBuffer struct {
+	log      Logger
+	budget   *rewindBudget
+	room     string
+	clientID string
+	ssrc     uint32
+	duration time.Duration
+
+	mu         sync.Mutex
+	packets    []*rtp.Packet
+	size       int
+	startedAt  time.Time
+	lastReplay time.Time
+	rtpWriter  RTPWriter
+}
+
+func (r *rewindBuffer) BindRTP(next RTPWriter) RTPWriter {
+	r.rtpWriter = next
+
+	return RTPWriterFunc(func(packet *rtp.Packet) error {
+		r.buffer(packet, time.Now())
+		return next.WriteRTP(packet)
+	})
+}
+
+func (r *rewindBuffer) buffer(packet *rtp.Packet, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if media := mediaPacket(packet); media != nil && isVP8KeyframeStart(media.Payload) {
+		r.reset()
+		r.startedAt = now
+	} else if r.startedAt.IsZero() {
+		// waiting for the next keyframe
+		return
+	}
+
+	if now.Sub(r.startedAt) > r.duration {
+		r.reset()
+		return
+	}
+
+	size := len(packet.Payload)
+	if !r.budget.reserve(r.room, size) {
+		r.log.Printf("[%s] Rewind buffer of room: %s is full, dropping buffer of ssrc: %d", r.clientID, r.room, r.ssrc)
+		r.reset()
+		return
+	}
+
+	r.packets = append(r.packets, packet)
+	r.size += size
+}
+
+// reset clears the buffer until the next keyframe. Must be called with r.mu
+// held.
+func (r *rewindBuffer) reset() {
+	r.budget.release(r.room, r.size)
+	r.packets = nil
+	r.size = 0
+	r.startedAt = time.Time{}
+}
+
+func (r *rewindBuffer) BindRTCP(next RTCPWriter) RTCPWriter {
+	return RTCPWriterFunc(func(packets []rtcp.Packet) error {
+		for _, packet := range packets {
+			if pli, ok := packet.(*rtcp.PictureLossIndication); ok && pli.MediaSSRC == r.ssrc {
+				r.replay()
+				break
+			}
+		}
+
+		// The keyframe request is still forwarded so that subscribers get a
+		// live keyframe as soon as possible.
+		return next.WriteRTCP(packets)
+	})
+}
+
+func (r *rewindBuffer) replay() {
+	r.mu.Lock()
+	now := time.Now()
+	if len(r.packets) == 0 || now.Sub(r.lastReplay) < rewindMinInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.lastReplay = now
+	packets := make([]*rtp.Packet, len(r.packets))
+	copy(packets, r.packets)
+	r.mu.Unlock()
+
+	for _, packet := range packets {
+		if err := r.rtpWriter.WriteRTP(packet); err != nil {
+			r.log.Printf("[%s] Error replaying rewind buffer of ssrc: %d: %s", r.clientID, r.ssrc, err)
+			return
+		}
+	}
+}
+
+func (r *rewindBuffer) Close() error {
+	r.mu.Lock()
+	r.reset()
+	r.mu.Unlock()
+	return nil
+}
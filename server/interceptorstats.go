@@ -0,0 +1,207 @@
+package server
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+)
+
+type TrackStats struct {
+	ClientID        string `json:"clientId"`
+	TrackID         string `json:"trackId"`
+	Kind            string `json:"kind"`
+	SSRC            uint32 `json:"ssrc"`
+	PacketsReceived uint64 `json:"packetsReceived"`
+	BytesReceived   uint64 `json:"bytesReceived"`
+	PacketsLost     uint64 `json:"packetsLost"`
+	NACKsReceived   uint64 `json:"nacksReceived"`
+	PLIsReceived    uint64 `json:"plisReceived"`
+	// LastPacketTime is the time the last RTP packet was received
+	LastPacketTime time.Time `json:"lastPacketTime"`
+	// Bitrate in bits per second, measured over the last bitrateWindow
+	Bitrate uint64 `json:"bitrate"`
+	// Jitter is the interarrival jitter in seconds, as defined in RFC 3550.
+	Jitter float64 `json:"jitter"`
+}
+
+// bitrateWindow is the interval over which TrackStats.Bitrate is measured.
+const bitrateWindow = time.Second
+
+// StatsInterceptorFactory creates interceptors which collect packet
+// statistics of published tracks. Statistics of all active tracks can be
+// retrieved by calling Stats.
+type StatsInterceptorFactory struct {
+	mu           sync.RWMutex
+	interceptors map[*webrtc.Track]*statsInterceptor
+}
+
+func NewStatsInterceptorFactory() *StatsInterceptorFactory {
+	return &StatsInterceptorFactory{
+		interceptors: map[*webrtc.Track]*statsInterceptor{},
+	}
+}
+
+func (f *StatsInterceptorFactory) NewInterceptor(params InterceptorParams) (Interceptor, error) {
+	i := &statsInterceptor{
+		factory:   f,
+		track:     params.LocalTrack,
+		clockRate: params.LocalTrack.Codec().ClockRate,
+		stats: TrackStats{
+			ClientID: params.ClientID,
+			TrackID:  params.LocalTrack.ID(),
+			Kind:     params.LocalTrack.Kind().String(),
+			SSRC:     params.LocalTrack.SSRC(),
+		},
+	}
+
+	f.mu.Lock()
+	f.interceptors[params.LocalTrack] = i
+	f.mu.Unlock()
+
+	return i, nil
+}
+
+func (f *StatsInterceptorFactory) remove(track *webrtc.Track) {
+	f.mu.Lock()
+	delete(f.interceptors, track)
+	f.mu.Unlock()
+}
+
+// Stats returns a snapshot of statistics for all active tracks.
+func (f *StatsInterceptorFactory) Stats() []TrackStats {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	stats := make([]TrackStats, 0, len(f.interceptors))
+	for _, i := range f.interceptors {
+		stats = append(stats, i.Stats())
+	}
+	return stats
+}
+
+// TrackStats returns statistics of a single track.
+func (f *StatsInterceptorFactory) TrackStats(track *webrtc.Track) (TrackStats, bool) {
+	f.mu.RLock()
+	i, ok := f.interceptors[track]
+	f.mu.RUnlock()
+
+	if !ok {
+		return TrackStats{}, false
+	}
+	return i.Stats(), true
+}
+
+// LastPacketTime returns the time the last RTP packet was received on any
+// of the tracks published by clientID.
+func (f *StatsInterceptorFactory) LastPacketTime(clientID string) (last time.Time) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, i := range f.interceptors {
+		stats := i.Stats()
+		if stats.ClientID == clientID && stats.LastPacketTime.After(last) {
+			last = stats.LastPacketTime
+		}
+	}
+	return
+}
+
+type statsInterceptor struct {
+	factory *StatsInterceptorFactory
+	track   *webrtc.Track
+
+	mu             sync.Mutex
+	stats          TrackStats
+	lastSeq        uint16
+	hasFirstPacket bool
+	windowStart    time.Time
+	windowBytes    uint64
+
+	clockRate uint32
+	// lastArrival and lastTimestamp are of the last packet received in order
+	lastArrival   time.Time
+	lastTimestamp uint32
+	// jitter in RTP timestamp units
+	jitter float64
+}
+
+func (i *statsInterceptor) Stats() TrackStats {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.stats
+}
+
+func (i *statsInterceptor) BindRTP(next RTPWriter) RTPWriter {
+	return RTPWriterFunc(func(packet *rtp.Packet) error {
+		i.mu.Lock()
+		now := time.Now()
+		i.stats.LastPacketTime = now
+		i.stats.PacketsReceived++
+		i.stats.BytesReceived += uint64(len(packet.Payload))
+		i.windowBytes += uint64(len(packet.Payload))
+		if i.windowStart.IsZero() {
+			i.windowStart = now
+		} else if elapsed := now.Sub(i.windowStart); elapsed >= bitrateWindow {
+			i.stats.Bitrate = i.windowBytes * 8 * uint64(time.Second) / uint64(elapsed)
+			i.windowStart = now
+			i.windowBytes = 0
+		}
+		if i.hasFirstPacket {
+			// Only count gaps of reasonable size, everything else is a reordered
+			// or duplicated packet.
+			if diff := packet.SequenceNumber - i.lastSeq; diff > 1 && diff < 1<<15 {
+				i.stats.PacketsLost += uint64(diff - 1)
+			}
+		}
+		if !i.hasFirstPacket || packet.SequenceNumber-i.lastSeq < 1<<15 {
+			i.lastSeq = packet.SequenceNumber
+			i.updateJitter(now, packet.Timestamp)
+		}
+		i.hasFirstPacket = true
+		i.mu.Unlock()
+
+		return next.WriteRTP(packet)
+	})
+}
+
+// updateJitter updates the interarrival jitter with a packet received in
+// order. Must be called with i.mu held.
+func (i *statsInterceptor) updateJitter(arrival time.Time, timestamp uint32) {
+	if i.clockRate == 0 {
+		return
+	}
+	if !i.lastArrival.IsZero() {
+		arrivalDiff := arrival.Sub(i.lastArrival).Seconds() * float64(i.clockRate)
+		d := math.Abs(arrivalDiff - float64(int32(timestamp-i.lastTimestamp)))
+		i.jitter += (d - i.jitter) / 16
+		i.stats.Jitter = i.jitter / float64(i.clockRate)
+	}
+	i.lastArrival = arrival
+	i.lastTimestamp = timestamp
+}
+
+func (i *statsInterceptor) BindRTCP(next RTCPWriter) RTCPWriter {
+	return RTCPWriterFunc(func(packets []rtcp.Packet) error {
+		i.mu.Lock()
+		for _, packet := range packets {
+			switch packet.(type) {
+			case *rtcp.TransportLayerNack:
+				i.stats.NACKsReceived++
+			case *rtcp.PictureLossIndication:
+				i.stats.PLIsReceived++
+			}
+		}
+		i.mu.Unlock()
+
+		return next.WriteRTCP(packets)
+	})
+}
+
+func (i *statsInterceptor) Close() error {
+	i.factory.remove(i.track)
+	return nil
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// SetKeepalive enables pinging of websocket connections. Connections of
+// clients which do not respond to a ping within the timeout are closed. When
+// the client has not sent any media for as long either, it is considered
+// dead and its peer is removed through remover, so that its tracks are
+// removed from other peers right away instead of after ICE times out. It
+// must be called before any connections are handled.
+func (wss *WSS) SetKeepalive(config KeepaliveConfig, remover PeerRemover) {
+	wss.keepalive = config
+	wss.peerRemover = remover
+}
+
+// monitorKeepalive pings ws until ctx is done, the connection has been
+// handed over to a data channel, or a ping times out.
+func (wss *WSS) monitorKeepalive(ctx context.Context, room string, ws websocketSignalingConn, conn *wsConnection) {
+	interval := wss.keepalive.Interval
+	if interval <= 0 {
+		return
+	}
+	timeout := wss.keepalive.Timeout
+	if timeout <= 0 {
+		timeout = interval
+	}
+
+	clientID := conn.client.ID()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if conn.client.getConn() != ws {
+			// liveness of the data channel is up to ICE
+			return
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		pingStart := time.Now()
+		err := ws.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			conn.setRTT(time.Since(pingStart))
+			continue
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			// the connection has been closed
+			return
+		}
+
+		// the websocket library closes the connection after a ping timeout
+		conn.cancel()
+
+		if wss.mediaActivity != nil {
+			if silent := time.Since(wss.mediaActivity.LastMediaActivity(clientID)); silent < timeout {
+				wss.log.Printf("[%s] Ping in room: %s timed out, but media was received %s ago", clientID, room, silent)
+				return
+			}
+		}
+
+		wss.log.Printf("[%s] Removing dead peer in room: %s, ping timed out", clientID, room)
+		if wss.peerRemover != nil {
+			wss.peerRemover.RemovePeer(clientID)
+		}
+		return
+	}
+}
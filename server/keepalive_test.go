@@ -0,0 +1,78 @@
+package server_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+type mockPeerRemover struct {
+	removed chan string
+}
+
+func (m *mockPeerRemover) HasPeer(clientID string) bool {
+	return true
+}
+
+func (m *mockPeerRemover) RemovePeer(clientID string) bool {
+	m.removed <- clientID
+	return true
+}
+
+func TestWSS_keepalive(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	wss := server.NewWSS(loggerFactory, rooms)
+	remover := &mockPeerRemover{removed: make(chan string, 1)}
+	wss.SetKeepalive(server.KeepaliveConfig{
+		Interval: 20 * time.Millisecond,
+		Timeout:  50 * time.Millisecond,
+	}, remover)
+	s := httptest.NewServer(server.NewMeshHandler(loggerFactory, wss))
+	defer s.Close()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + room + "/"
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// pongs are only sent while the connection is read from
+	alive := mustDialWS(t, ctx, wsURL+"alive")
+	defer alive.Close(websocket.StatusNormalClosure, "")
+	aliveErr := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := alive.Read(ctx); err != nil {
+				aliveErr <- err
+				return
+			}
+		}
+	}()
+
+	dead := mustDialWS(t, ctx, wsURL+"dead")
+	defer dead.Close(websocket.StatusNormalClosure, "")
+
+	select {
+	case clientID := <-remover.removed:
+		assert.Equal(t, "dead", clientID)
+	case <-ctx.Done():
+		require.NoError(t, ctx.Err(), "dead peer should be removed")
+	}
+
+	assert.Eventually(t, func() bool {
+		return len(wss.LocalClientIDs(room)) == 1
+	}, timeout, 10*time.Millisecond)
+	assert.Equal(t, []string{"alive"}, wss.LocalClientIDs(room))
+	select {
+	case err := <-aliveErr:
+		assert.NoError(t, err, "alive peer should stay connected")
+	default:
+	}
+}
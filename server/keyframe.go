@@ -0,0 +1,123 @@
+package server
+
+import (
+	"github.com/pion/webrtc/v2"
+)
+
+// canDetectKeyframes returns true when keyframes of codec can be detected
+// with isKeyframeStart.
+func canDetectKeyframes(codec string) bool {
+	switch codec {
+	case webrtc.VP8, webrtc.H264:
+		return true
+	}
+	return false
+}
+
+// isKeyframeStart returns true when payload is the first packet of a
+// keyframe of codec, after which a decoder can start decoding. Returns false
+// for codecs which are not supported by canDetectKeyframes.
+func isKeyframeStart(codec string, payload []byte) bool {
+	switch codec {
+	case webrtc.VP8:
+		return isVP8KeyframeStart(payload)
+	case webrtc.H264:
+		return isH264KeyframeStart(payload)
+	}
+	return false
+}
+
+// isVP8KeyframeStart returns true when payload contains the first partition
+// of a VP8 keyframe.
+func isVP8KeyframeStart(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+
+	descriptor := payload[0]
+	extended := descriptor&0x80 != 0
+	start := descriptor&0x10 != 0
+	partitionID := descriptor & 0x0f
+	if !start || partitionID != 0 {
+		return false
+	}
+
+	offset := 1
+	if extended {
+		if len(payload) < 2 {
+			return false
+		}
+		ext := payload[1]
+		offset++
+		if ext&0x80 != 0 { // I: picture ID present
+			if len(payload) <= offset {
+				return false
+			}
+			if payload[offset]&0x80 != 0 { // M: 15 bit picture ID
+				offset += 2
+			} else {
+				offset++
+			}
+		}
+		if ext&0x40 != 0 { // L: TL0PICIDX present
+			offset++
+		}
+		if ext&0x20 != 0 || ext&0x10 != 0 { // T or K: TID/KEYIDX present
+			offset++
+		}
+	}
+
+	if len(payload) <= offset {
+		return false
+	}
+
+	// P bit of the VP8 payload header is zero for keyframes
+	return payload[offset]&0x01 == 0
+}
+
+// NAL unit types of H.264, RFC 6184.
+const (
+	h264NALUTypeIDR   = 5
+	h264NALUTypeSPS   = 7
+	h264NALUTypeSTAPA = 24
+	h264NALUTypeFUA   = 28
+)
+
+// isH264KeyframeStart returns true when payload starts an IDR picture or the
+// sequence parameter set sent before it. Browsers send the parameter sets
+// right before IDR pictures, so decoding can start at either.
+func isH264KeyframeStart(payload []byte) bool {
+	if len(payload) < 1 {
+		return false
+	}
+
+	isStart := func(naluType byte) bool {
+		return naluType == h264NALUTypeIDR || naluType == h264NALUTypeSPS
+	}
+
+	switch naluType := payload[0] & 0x1F; naluType {
+	case h264NALUTypeSTAPA:
+		// aggregated NAL units, each prefixed with its 16 bit size
+		for offset := 1; offset+2 < len(payload); {
+			size := int(payload[offset])<<8 | int(payload[offset+1])
+			offset += 2
+			if size == 0 || offset+size > len(payload) {
+				return false
+			}
+			if isStart(payload[offset] & 0x1F) {
+				return true
+			}
+			offset += size
+		}
+		return false
+	case h264NALUTypeFUA:
+		// fragmented NAL unit, only its first fragment starts it
+		if len(payload) < 2 {
+			return false
+		}
+		fuHeader := payload[1]
+		return fuHeader&0x80 != 0 && isStart(fuHeader&0x1F)
+	default:
+		return isStart(naluType)
+	}
+}
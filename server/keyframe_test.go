@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsKeyframeStart_VP8(t *testing.T) {
+	assert.True(t, isKeyframeStart(webrtc.VP8, []byte{0x10, 0x00, 0x00}))
+	assert.False(t, isKeyframeStart(webrtc.VP8, []byte{0x10, 0x01, 0x00}), "interframe")
+	assert.False(t, isKeyframeStart(webrtc.VP8, []byte{0x00, 0x00, 0x00}), "not the start of a partition")
+	// extended descriptor with a 15 bit picture ID
+	assert.True(t, isKeyframeStart(webrtc.VP8, []byte{0x90, 0x80, 0x80, 0x01, 0x00}))
+}
+
+func TestIsKeyframeStart_H264(t *testing.T) {
+	type testCase struct {
+		name     string
+		payload  []byte
+		keyframe bool
+	}
+
+	for _, tc := range []testCase{
+		{"IDR", []byte{0x65, 0x88}, true},
+		{"SPS", []byte{0x67, 0x42}, true},
+		{"non-IDR", []byte{0x41, 0x9a}, false},
+		{"PPS", []byte{0x68, 0xce}, false},
+		{"STAP-A with SPS and PPS", []byte{0x78, 0x00, 0x02, 0x67, 0x42, 0x00, 0x02, 0x68, 0xce}, true},
+		{"STAP-A without SPS", []byte{0x78, 0x00, 0x02, 0x68, 0xce, 0x00, 0x02, 0x06, 0x05}, false},
+		{"STAP-A truncated", []byte{0x78, 0x00, 0x09, 0x67}, false},
+		{"FU-A start of IDR", []byte{0x7c, 0x85, 0x88}, true},
+		{"FU-A continuation of IDR", []byte{0x7c, 0x05, 0x88}, false},
+		{"FU-A start of non-IDR", []byte{0x7c, 0x81, 0x9a}, false},
+		{"empty", nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.keyframe, isKeyframeStart(webrtc.H264, tc.payload))
+		})
+	}
+}
+
+func TestIsKeyframeStart_unsupported(t *testing.T) {
+	assert.False(t, canDetectKeyframes(webrtc.VP9))
+	assert.False(t, isKeyframeStart(webrtc.VP9, []byte{0x10, 0x00, 0x00}))
+	assert.False(t, canDetectKeyframes(webrtc.Opus))
+}
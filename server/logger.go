@@ -4,6 +4,10 @@ import "github.com/peer-calls/peer-calls/server/logger"
 
 type Logger = logger.Logger
 
+// LogCtx is structured context included in every line of a child logger
+// created with Logger.WithCtx.
+type LogCtx = logger.Ctx
+
 type LoggerFactory interface {
 	GetLogger(name string) Logger
 }
@@ -1,66 +1,264 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Level is the severity of a log line. Lines below the level of the Factory
+// are not written.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// ParseLevel parses one of debug, info, warn or error.
+func ParseLevel(value string) (Level, error) {
+	for _, level := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		if strings.EqualFold(value, level.String()) {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("Unknown log level: %q", value)
+}
+
+// Format is the output format of log lines.
+type Format string
+
+const (
+	// FormatText writes the time, logger name, level and context as
+	// key=value pairs followed by the message. The level is omitted for
+	// info lines.
+	FormatText Format = "text"
+	// FormatJSON writes each line as a JSON object with time, level, logger
+	// and msg keys, and a key for every context field.
+	FormatJSON Format = "json"
+)
+
 // WriterLogger is a logger that writes to io.Writer when it is enabled.
 type WriterLogger struct {
-	name    string
-	out     io.Writer
-	outMu   sync.Mutex
+	name  string
+	out   io.Writer
+	outMu sync.Mutex
+	// level is accessed atomically so that it can be changed at runtime
+	level   int32
+	format  Format
 	Enabled bool
 }
 
 // Logger is an interface for logger
 type Logger interface {
-	// Printf formats a message and writes to output. If logger is not enabled,
-	// the message will not be formatted.
+	// Printf formats a message and writes to output at info level. If logger
+	// is not enabled, the message will not be formatted.
 	Printf(message string, values ...interface{})
-	// Println writes all values similar to fmt.Println. If logger is not enable,d
-	// the message will not be formatted
+	// Println writes all values similar to fmt.Println at info level. If
+	// logger is not enabled the message will not be formatted
 	Println(values ...interface{})
+	// Debugf is like Printf, but at debug level.
+	Debugf(message string, values ...interface{})
+	// Warnf is like Printf, but at warn level.
+	Warnf(message string, values ...interface{})
+	// Errorf is like Printf, but at error level.
+	Errorf(message string, values ...interface{})
+	// WithCtx returns a child logger which writes ctx in every line, after
+	// the context of this logger. Child loggers are enabled when their parent
+	// is.
+	WithCtx(ctx Ctx) Logger
+}
+
+// Ctx is structured context of log lines, for example the room, clientID or
+// track ID a message is about.
+type Ctx map[string]interface{}
+
+// merge returns a copy of c with the fields of ctx added.
+func (c Ctx) merge(ctx Ctx) Ctx {
+	merged := make(Ctx, len(c)+len(ctx))
+	for key, value := range c {
+		merged[key] = value
+	}
+	for key, value := range ctx {
+		merged[key] = value
+	}
+	return merged
+}
+
+// format formats ctx as space-delimited key=value pairs sorted by key,
+// followed by a space.
+func (c Ctx) format() string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s=%v ", key, c[key])
+	}
+	return b.String()
 }
 
 // LoggerTimeFormat is the time format used by loggers in this package
 var LoggerTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
 
-// NewWriterLogger creates a new logger
+// NewWriterLogger creates a new logger which writes text at info level and
+// above.
 func NewWriterLogger(name string, out io.Writer, enabled bool) *WriterLogger {
-	return &WriterLogger{name: name, out: out, Enabled: enabled}
+	return &WriterLogger{name: name, out: out, level: int32(LevelInfo), format: FormatText, Enabled: enabled}
 }
 
 // Printf implements Logger#Printf func.
 func (l *WriterLogger) Printf(message string, values ...interface{}) {
-	if l.Enabled {
-		l.printf(message, values...)
-	}
+	l.printf(LevelInfo, nil, "", message, values...)
 }
 
 // Println implements Logger#Println func.
 func (l *WriterLogger) Println(values ...interface{}) {
-	if l.Enabled {
-		l.println(values...)
+	l.println(LevelInfo, nil, "", values...)
+}
+
+// Debugf implements Logger#Debugf func.
+func (l *WriterLogger) Debugf(message string, values ...interface{}) {
+	l.printf(LevelDebug, nil, "", message, values...)
+}
+
+// Warnf implements Logger#Warnf func.
+func (l *WriterLogger) Warnf(message string, values ...interface{}) {
+	l.printf(LevelWarn, nil, "", message, values...)
+}
+
+// Errorf implements Logger#Errorf func.
+func (l *WriterLogger) Errorf(message string, values ...interface{}) {
+	l.printf(LevelError, nil, "", message, values...)
+}
+
+// WithCtx implements Logger#WithCtx func.
+func (l *WriterLogger) WithCtx(ctx Ctx) Logger {
+	return &childLogger{root: l, ctx: Ctx{}.merge(ctx), text: ctx.format()}
+}
+
+func (l *WriterLogger) enabled(level Level) bool {
+	return l.Enabled && level >= Level(atomic.LoadInt32(&l.level))
+}
+
+func (l *WriterLogger) printf(level Level, ctx Ctx, text string, message string, values ...interface{}) {
+	if l.enabled(level) {
+		l.write(level, ctx, text, fmt.Sprintf(message, values...))
 	}
 }
 
-func (l *WriterLogger) printf(message string, values ...interface{}) {
-	l.outMu.Lock()
-	defer l.outMu.Unlock()
-	date := time.Now().Format(LoggerTimeFormat)
-	l.out.Write([]byte(date + fmt.Sprintf(" [%15s] ", l.name) + fmt.Sprintf(message+"\n", values...)))
+func (l *WriterLogger) println(level Level, ctx Ctx, text string, values ...interface{}) {
+	if l.enabled(level) {
+		l.write(level, ctx, text, strings.TrimSuffix(fmt.Sprintln(values...), "\n"))
+	}
 }
 
-func (l *WriterLogger) println(values ...interface{}) {
+// write writes a single line. ctx holds the context fields for JSON output
+// and text the same fields preformatted for text output.
+func (l *WriterLogger) write(level Level, ctx Ctx, text string, message string) {
+	now := time.Now()
+
+	var line []byte
+	if l.format == FormatJSON {
+		line = l.formatJSON(now, level, ctx, message)
+	} else {
+		if level != LevelInfo {
+			text = "level=" + level.String() + " " + text
+		}
+		line = []byte(now.Format(LoggerTimeFormat) + fmt.Sprintf(" [%15s] ", l.name) + text + message + "\n")
+	}
+
 	l.outMu.Lock()
 	defer l.outMu.Unlock()
-	date := time.Now().Format(LoggerTimeFormat)
-	l.out.Write([]byte(date + fmt.Sprintf(" [%15s] ", l.name) + fmt.Sprintln(values...)))
+	l.out.Write(line)
+}
+
+func (l *WriterLogger) formatJSON(now time.Time, level Level, ctx Ctx, message string) []byte {
+	fields := make(map[string]interface{}, len(ctx)+4)
+	for key, value := range ctx {
+		switch v := value.(type) {
+		case error:
+			fields[key] = v.Error()
+		case fmt.Stringer:
+			fields[key] = v.String()
+		default:
+			fields[key] = v
+		}
+	}
+	fields["time"] = now.Format(LoggerTimeFormat)
+	fields["level"] = level.String()
+	fields["logger"] = l.name
+	fields["msg"] = message
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		for key, value := range ctx {
+			fields[key] = fmt.Sprint(value)
+		}
+		data, _ = json.Marshal(fields)
+	}
+	return append(data, '\n')
+}
+
+// childLogger writes to its root WriterLogger with context.
+type childLogger struct {
+	root *WriterLogger
+	ctx  Ctx
+	text string
+}
+
+// Printf implements Logger#Printf func.
+func (l *childLogger) Printf(message string, values ...interface{}) {
+	l.root.printf(LevelInfo, l.ctx, l.text, message, values...)
+}
+
+// Println implements Logger#Println func.
+func (l *childLogger) Println(values ...interface{}) {
+	l.root.println(LevelInfo, l.ctx, l.text, values...)
+}
+
+// Debugf implements Logger#Debugf func.
+func (l *childLogger) Debugf(message string, values ...interface{}) {
+	l.root.printf(LevelDebug, l.ctx, l.text, message, values...)
+}
+
+// Warnf implements Logger#Warnf func.
+func (l *childLogger) Warnf(message string, values ...interface{}) {
+	l.root.printf(LevelWarn, l.ctx, l.text, message, values...)
+}
+
+// Errorf implements Logger#Errorf func.
+func (l *childLogger) Errorf(message string, values ...interface{}) {
+	l.root.printf(LevelError, l.ctx, l.text, message, values...)
+}
+
+// WithCtx implements Logger#WithCtx func.
+func (l *childLogger) WithCtx(ctx Ctx) Logger {
+	return &childLogger{root: l.root, ctx: l.ctx.merge(ctx), text: l.text + ctx.format()}
 }
 
 // Factory creates new loggers. Only one logger with a specific name
@@ -69,6 +267,8 @@ type Factory struct {
 	out            io.Writer
 	loggers        map[string]*WriterLogger
 	defaultEnabled []string
+	level          Level
+	format         Format
 	loggersMu      sync.Mutex
 }
 
@@ -84,18 +284,50 @@ func NewFactory(out io.Writer, enabled []string) *Factory {
 		out:            out,
 		loggers:        map[string]*WriterLogger{},
 		defaultEnabled: enabled,
+		level:          LevelInfo,
+		format:         FormatText,
 	}
 }
 
 // NewFactoryFromEnv creates a new Factory and reads the enabled
-// loggers from a comma-delimited environment variable.
+// loggers from a comma-delimited environment variable. The minimum level and
+// the output format are read from the LOG_LEVEL and LOG_FORMAT variables.
 func NewFactoryFromEnv(prefix string, out io.Writer) *Factory {
 	log := os.Getenv(prefix + "LOG")
 	var enabled []string
 	if len(log) > 0 {
 		enabled = strings.Split(log, ",")
 	}
-	return NewFactory(out, enabled)
+	factory := NewFactory(out, enabled)
+	if level, err := ParseLevel(os.Getenv(prefix + "LOG_LEVEL")); err == nil {
+		factory.level = level
+	}
+	if Format(os.Getenv(prefix+"LOG_FORMAT")) == FormatJSON {
+		factory.format = FormatJSON
+	}
+	return factory
+}
+
+// SetLevel sets the minimum level of lines written by all loggers. It can be
+// called while the loggers are in use.
+func (l *Factory) SetLevel(level Level) {
+	l.loggersMu.Lock()
+	defer l.loggersMu.Unlock()
+	l.level = level
+	for _, logger := range l.loggers {
+		atomic.StoreInt32(&logger.level, int32(level))
+	}
+}
+
+// SetFormat sets the output format of all loggers. It must be called before
+// the loggers are used.
+func (l *Factory) SetFormat(format Format) {
+	l.loggersMu.Lock()
+	defer l.loggersMu.Unlock()
+	l.format = format
+	for _, logger := range l.loggers {
+		logger.format = format
+	}
 }
 
 // SetDefaultEnabled sets enabled loggers if the Factory has been
@@ -173,6 +405,8 @@ func (l *Factory) GetLogger(name string) Logger {
 	if !ok {
 		enabled := l.isEnabled(name)
 		logger = NewWriterLogger(name, l.out, enabled)
+		logger.level = int32(l.level)
+		logger.format = l.format
 		l.loggers[name] = logger
 	}
 	return logger
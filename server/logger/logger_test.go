@@ -1,6 +1,8 @@
 package logger_test
 
 import (
+	"encoding/json"
+	"errors"
 	"os"
 	"strings"
 	"testing"
@@ -131,3 +133,75 @@ func TestGetLogger_Wildcard_Disabled(t *testing.T) {
 	require.Equal(t, 1, len(result))
 	assert.Regexp(t, " \\[     b:one:warn] b one warn", result[0])
 }
+
+func TestGetLogger_WithCtx(t *testing.T) {
+	defer os.Unsetenv("TESTLOG_")
+	os.Setenv("TESTLOG_LOG", "b")
+	var out strings.Builder
+	loggerFactory := logger.NewFactoryFromEnv("TESTLOG_", &out)
+	logB := loggerFactory.GetLogger("b")
+	logRoom := logB.WithCtx(logger.Ctx{"room": "r1"})
+	logPeer := logRoom.WithCtx(logger.Ctx{"clientID": "c1", "audio": true})
+	logC := loggerFactory.GetLogger("c").WithCtx(logger.Ctx{"room": "r1"})
+
+	logRoom.Printf("Room: %d", 1)
+	logPeer.Println("peer", 2)
+	logC.Printf("disabled")
+
+	result := strings.Split(strings.Trim(out.String(), "\n"), "\n")
+	require.Equal(t, 2, len(result))
+	assert.Regexp(t, " \\[              b] room=r1 Room: 1$", result[0])
+	assert.Regexp(t, " \\[              b] room=r1 audio=true clientID=c1 peer 2$", result[1])
+}
+
+func TestGetLogger_level(t *testing.T) {
+	defer os.Unsetenv("TESTLOG_LOG")
+	defer os.Unsetenv("TESTLOG_LOG_LEVEL")
+	os.Setenv("TESTLOG_LOG", "b")
+	os.Setenv("TESTLOG_LOG_LEVEL", "warn")
+	var out strings.Builder
+	loggerFactory := logger.NewFactoryFromEnv("TESTLOG_", &out)
+	logB := loggerFactory.GetLogger("b").WithCtx(logger.Ctx{"room": "r1"})
+
+	logB.Debugf("debug")
+	logB.Printf("info")
+	logB.Warnf("warn: %d", 1)
+	logB.Errorf("error: %d", 2)
+
+	result := strings.Split(strings.Trim(out.String(), "\n"), "\n")
+	require.Equal(t, 2, len(result))
+	assert.Regexp(t, " \\[              b] level=warn room=r1 warn: 1$", result[0])
+	assert.Regexp(t, " \\[              b] level=error room=r1 error: 2$", result[1])
+}
+
+func TestGetLogger_JSON(t *testing.T) {
+	defer os.Unsetenv("TESTLOG_LOG")
+	defer os.Unsetenv("TESTLOG_LOG_FORMAT")
+	os.Setenv("TESTLOG_LOG", "b")
+	os.Setenv("TESTLOG_LOG_FORMAT", "json")
+	var out strings.Builder
+	loggerFactory := logger.NewFactoryFromEnv("TESTLOG_", &out)
+	logB := loggerFactory.GetLogger("b").
+		WithCtx(logger.Ctx{"room": "r1"}).
+		WithCtx(logger.Ctx{"clientID": "c1", "err": errors.New("closed")})
+
+	logB.Errorf("Error reading: %s", "track")
+	logB.Println("peer", 2)
+
+	result := strings.Split(strings.Trim(out.String(), "\n"), "\n")
+	require.Equal(t, 2, len(result))
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(result[0]), &line))
+	assert.Equal(t, "error", line["level"])
+	assert.Equal(t, "b", line["logger"])
+	assert.Equal(t, "Error reading: track", line["msg"])
+	assert.Equal(t, "r1", line["room"])
+	assert.Equal(t, "c1", line["clientID"])
+	assert.Equal(t, "closed", line["err"])
+	assert.NotEmpty(t, line["time"])
+
+	require.NoError(t, json.Unmarshal([]byte(result[1]), &line))
+	assert.Equal(t, "info", line["level"])
+	assert.Equal(t, "peer 2", line["msg"])
+}
@@ -44,18 +44,23 @@ func (m *MemoryAdapter) Remove(clientID string) (err error) {
 }
 
 func (m *MemoryAdapter) Metadata(clientID string) (metadata string, ok bool) {
+	m.clientsMu.RLock()
 	client, ok := m.clients[clientID]
 	if ok {
 		metadata = client.Metadata()
 	}
+	m.clientsMu.RUnlock()
 	return
 }
 
 func (m *MemoryAdapter) SetMetadata(clientID string, metadata string) (ok bool) {
+	// client metadata is read by Clients and Add under the read lock
+	m.clientsMu.Lock()
 	client, ok := m.clients[clientID]
 	if ok {
 		client.SetMetadata(metadata)
 	}
+	m.clientsMu.Unlock()
 	return ok
 }
 
@@ -10,10 +10,16 @@ type ReadyMessage struct {
 }
 
 func NewMeshHandler(loggerFactory LoggerFactory, wss *WSS) http.Handler {
+	return wss.WebSocketHandler(NewMeshSignalingHandler(loggerFactory, wss))
+}
+
+// NewMeshSignalingHandler creates a handler which relays signals between
+// clients, and can be served over any signaling transport.
+func NewMeshSignalingHandler(loggerFactory LoggerFactory, wss *WSS) SignalingHandler {
 	log := loggerFactory.GetLogger("mesh")
 
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		wss.HandleRoom(w, r, func(event RoomEvent) {
+	fn := func(conn SignalingConn, req SignalingRequest) {
+		wss.ServeRoom(conn, req, func(event RoomEvent) {
 			msg := event.Message
 			adapter := event.Adapter
 			room := event.Room
@@ -43,23 +49,29 @@ func NewMeshHandler(loggerFactory LoggerFactory, wss *WSS) http.Handler {
 				)
 			case "signal":
 				payload, _ := msg.Payload.(map[string]interface{})
-				signal, _ := payload["signal"]
 				targetClientID, _ := payload["userId"].(string)
 
 				responseEventName = "signal"
 				log.Printf("Send signal from: %s to %s", clientID, targetClientID)
-				err = adapter.Emit(targetClientID, NewMessage(responseEventName, room, map[string]interface{}{
-					"userId": clientID,
-					"signal": signal,
-				}))
+				err = relaySignal(adapter, room, clientID, payload)
 			}
 
 			if err != nil {
 				log.Printf("Error sending event (event: %s, room: %s, source: %s)", responseEventName, room, clientID)
 			}
-		})
+		}, nil)
 	}
-	return http.HandlerFunc(fn)
+	return SignalingHandlerFunc(fn)
+}
+
+// relaySignal forwards a signal from clientID to the peer identified by the
+// userId field of the payload.
+func relaySignal(adapter Adapter, room string, clientID string, payload map[string]interface{}) error {
+	targetClientID, _ := payload["userId"].(string)
+	return adapter.Emit(targetClientID, NewMessage("signal", room, map[string]interface{}{
+		"userId": clientID,
+		"signal": payload["signal"],
+	}))
 }
 
 func getReadyClients(adapter Adapter) (map[string]string, error) {
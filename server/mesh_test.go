@@ -102,14 +102,14 @@ func (m *MockAdapter) Emit(clientID string, message server.Message) error {
 const roomName = "test-room"
 const clientID = "user1234"
 
-func mustDialWS(t *testing.T, ctx context.Context, url string) *websocket.Conn {
+func mustDialWS(t testing.TB, ctx context.Context, url string) *websocket.Conn {
 	t.Helper()
 	ws, _, err := websocket.Dial(ctx, url, nil)
 	require.Nil(t, err)
 	return ws
 }
 
-func mustWriteWS(t *testing.T, ctx context.Context, ws *websocket.Conn, msg server.Message) {
+func mustWriteWS(t testing.TB, ctx context.Context, ws *websocket.Conn, msg server.Message) {
 	t.Helper()
 	data, err := serializer.Serialize(msg)
 	require.Nil(t, err, "Error serializing message")
@@ -117,7 +117,7 @@ func mustWriteWS(t *testing.T, ctx context.Context, ws *websocket.Conn, msg serv
 	require.Nil(t, err, "Error writing message")
 }
 
-func mustReadWS(t *testing.T, ctx context.Context, ws *websocket.Conn) server.Message {
+func mustReadWS(t testing.TB, ctx context.Context, ws *websocket.Conn) server.Message {
 	t.Helper()
 	messageType, data, err := ws.Read(ctx)
 	require.NoError(t, err, "Error reading text message")
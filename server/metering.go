@@ -0,0 +1,407 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// WebhookUsageReported is sent to the metering webhook with the usage of a
+// room during the last metering interval.
+const WebhookUsageReported = "usage.reported"
+
+// ParticipantUsage is the usage of a single client of a room.
+type ParticipantUsage struct {
+	ClientID string  `json:"clientId"`
+	Minutes  float64 `json:"minutes"`
+	// ForwardedBytes is the number of bytes of tracks published by the
+	// client which were sent to subscribers.
+	ForwardedBytes uint64 `json:"forwardedBytes"`
+}
+
+// RoomUsage is the usage of a room between Start and End.
+type RoomUsage struct {
+	Room               string    `json:"room"`
+	Start              time.Time `json:"start"`
+	End                time.Time `json:"end"`
+	ParticipantMinutes float64   `json:"participantMinutes"`
+	RecordingMinutes   float64   `json:"recordingMinutes"`
+	// ForwardedBytes is the number of RTP bytes sent to subscribers.
+	ForwardedBytes uint64             `json:"forwardedBytes"`
+	Participants   []ParticipantUsage `json:"participants"`
+}
+
+type participantCounters struct {
+	time           time.Duration
+	forwardedBytes uint64
+}
+
+type usageCounters struct {
+	start          time.Time
+	participants   map[string]*participantCounters
+	participant    time.Duration
+	recording      time.Duration
+	forwardedBytes uint64
+}
+
+func newUsageCounters(start time.Time) usageCounters {
+	return usageCounters{
+		start:        start,
+		participants: map[string]*participantCounters{},
+	}
+}
+
+func (c *usageCounters) add(clientID string, participant time.Duration, forwardedBytes uint64) {
+	p, ok := c.participants[clientID]
+	if !ok {
+		p = &participantCounters{}
+		c.participants[clientID] = p
+	}
+	p.time += participant
+	p.forwardedBytes += forwardedBytes
+	c.participant += participant
+	c.forwardedBytes += forwardedBytes
+}
+
+func (c *usageCounters) empty() bool {
+	return c.participant == 0 && c.recording == 0 && c.forwardedBytes == 0
+}
+
+func (c *usageCounters) usage(room string, end time.Time) RoomUsage {
+	u := RoomUsage{
+		Room:               room,
+		Start:              c.start,
+		End:                end,
+		ParticipantMinutes: c.participant.Minutes(),
+		RecordingMinutes:   c.recording.Minutes(),
+		ForwardedBytes:     c.forwardedBytes,
+		Participants:       make([]ParticipantUsage, 0, len(c.participants)),
+	}
+	for clientID, p := range c.participants {
+		u.Participants = append(u.Participants, ParticipantUsage{
+			ClientID:       clientID,
+			Minutes:        p.time.Minutes(),
+			ForwardedBytes: p.forwardedBytes,
+		})
+	}
+	sort.Slice(u.Participants, func(i, j int) bool {
+		return u.Participants[i].ClientID < u.Participants[j].ClientID
+	})
+	return u
+}
+
+type meteredRoom struct {
+	// key is clientID, value is the time until which the client was counted
+	participants map[string]time.Time
+	// recordingUntil is the time until which the running recording was
+	// counted, zero when the room is not recorded
+	recordingUntil time.Time
+	// period is the usage since the last report, total since the room was
+	// first used
+	period usageCounters
+	total  usageCounters
+}
+
+func (r *meteredRoom) add(clientID string, participant time.Duration, forwardedBytes uint64) {
+	r.period.add(clientID, participant, forwardedBytes)
+	r.total.add(clientID, participant, forwardedBytes)
+}
+
+func (r *meteredRoom) addRecording(recording time.Duration) {
+	r.period.recording += recording
+	r.total.recording += recording
+}
+
+// Metering measures participant minutes, forwarded bytes and recording
+// minutes of each room on this instance. The usage of each room is reported
+// to webhooks every interval, and totals can be read until the room has been
+// unused for an interval. Forwarded bytes are measured by the interceptors
+// it creates. A nil Metering measures nothing.
+type Metering struct {
+	log      Logger
+	webhooks *Webhooks
+
+	mu sync.Mutex
+	// key is room
+	rooms        map[string]*meteredRoom
+	interceptors map[*meteringInterceptor]struct{}
+
+	closeChannel chan struct{}
+	closeOnce    sync.Once
+	done         chan struct{}
+}
+
+var _ InterceptorFactory = &Metering{}
+
+// NewMetering creates a Metering which reports usage to webhooks. Usage is
+// not reported when webhooks is nil.
+func NewMetering(loggerFactory LoggerFactory, webhooks *Webhooks) *Metering {
+	return &Metering{
+		log:          loggerFactory.GetLogger("metering"),
+		webhooks:     webhooks,
+		rooms:        map[string]*meteredRoom{},
+		interceptors: map[*meteringInterceptor]struct{}{},
+		closeChannel: make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+// room must be called with mu held.
+func (m *Metering) room(room string, now time.Time) *meteredRoom {
+	r, ok := m.rooms[room]
+	if !ok {
+		r = &meteredRoom{
+			participants: map[string]time.Time{},
+			period:       newUsageCounters(now),
+			total:        newUsageCounters(now),
+		}
+		m.rooms[room] = r
+	}
+	return r
+}
+
+// join starts counting the time clientID spends in room. A client which
+// reconnects keeps being counted from its first connection.
+func (m *Metering) join(room string, clientID string) {
+	if m == nil {
+		return
+	}
+
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.room(room, now)
+	if _, ok := r.participants[clientID]; !ok {
+		r.participants[clientID] = now
+	}
+}
+
+// leave stops counting the time clientID spends in room.
+func (m *Metering) leave(room string, clientID string) {
+	if m == nil {
+		return
+	}
+
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.room(room, now)
+	if until, ok := r.participants[clientID]; ok {
+		r.add(clientID, now.Sub(until), 0)
+		delete(r.participants, clientID)
+	}
+}
+
+// recordingStarted starts counting the recording time of room.
+func (m *Metering) recordingStarted(room string) {
+	if m == nil {
+		return
+	}
+
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.room(room, now)
+	if r.recordingUntil.IsZero() {
+		r.recordingUntil = now
+	}
+}
+
+// recordingStopped stops counting the recording time of room.
+func (m *Metering) recordingStopped(room string) {
+	if m == nil {
+		return
+	}
+
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.room(room, now)
+	if !r.recordingUntil.IsZero() {
+		r.addRecording(now.Sub(r.recordingUntil))
+		r.recordingUntil = time.Time{}
+	}
+}
+
+// accrue adds the usage of connected clients, running recordings and
+// forwarded packets until now to the counters. It must be called with mu
+// held.
+func (m *Metering) accrue(now time.Time) {
+	for i := range m.interceptors {
+		if n := atomic.SwapUint64(&i.forwardedBytes, 0); n > 0 {
+			m.room(i.room, now).add(i.clientID, 0, n)
+		}
+	}
+
+	for _, r := range m.rooms {
+		for clientID, until := range r.participants {
+			r.add(clientID, now.Sub(until), 0)
+			r.participants[clientID] = now
+		}
+		if !r.recordingUntil.IsZero() {
+			r.addRecording(now.Sub(r.recordingUntil))
+			r.recordingUntil = now
+		}
+	}
+}
+
+// Usage returns the total usage of room since it was first used. Returns
+// false when room has not been used recently.
+func (m *Metering) Usage(room string) (RoomUsage, bool) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.rooms[room]
+	if !ok {
+		return RoomUsage{}, false
+	}
+	m.accrue(now)
+	return r.total.usage(room, now), true
+}
+
+// Rooms returns the total usage of all rooms which have been used recently,
+// sorted by room.
+func (m *Metering) Rooms() []RoomUsage {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.accrue(now)
+	rooms := make([]RoomUsage, 0, len(m.rooms))
+	for room, r := range m.rooms {
+		rooms = append(rooms, r.total.usage(room, now))
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Room < rooms[j].Room
+	})
+	return rooms
+}
+
+// Report sends the usage of each room since the last report to webhooks,
+// and forgets rooms which have not been used since the last report.
+func (m *Metering) Report() {
+	now := time.Now()
+
+	m.mu.Lock()
+	m.accrue(now)
+	var reports []RoomUsage
+	for room, r := range m.rooms {
+		if r.period.empty() {
+			if len(r.participants) == 0 && r.recordingUntil.IsZero() {
+				delete(m.rooms, room)
+			}
+			continue
+		}
+		reports = append(reports, r.period.usage(room, now))
+		r.period = newUsageCounters(now)
+	}
+	m.mu.Unlock()
+
+	if len(reports) > 0 {
+		m.log.Printf("Reporting usage of rooms: %d", len(reports))
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Room < reports[j].Room
+	})
+	for _, usage := range reports {
+		m.webhooks.Emit(WebhookUsageReported, usage.Room, "", usage)
+	}
+}
+
+// Start reports usage every interval, until Close is called.
+func (m *Metering) Start(interval time.Duration) {
+	go m.run(interval)
+}
+
+// Close stops reporting usage. It must only be called after Start.
+func (m *Metering) Close() {
+	m.closeOnce.Do(func() {
+		close(m.closeChannel)
+	})
+	<-m.done
+}
+
+func (m *Metering) run(interval time.Duration) {
+	defer close(m.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.Report()
+		case <-m.closeChannel:
+			return
+		}
+	}
+}
+
+// NewInterceptor creates an interceptor which counts the bytes of the
+// packets of a published track sent to subscribers.
+func (m *Metering) NewInterceptor(params InterceptorParams) (Interceptor, error) {
+	i := &meteringInterceptor{
+		metering:    m,
+		room:        mainRoom(params.Room),
+		clientID:    params.ClientID,
+		subscribers: params.Subscribers,
+	}
+
+	m.mu.Lock()
+	m.interceptors[i] = struct{}{}
+	m.mu.Unlock()
+
+	return i, nil
+}
+
+type meteringInterceptor struct {
+	// forwardedBytes since the last accrual, accessed atomically. It is the
+	// first field so that it is 64-bit aligned on 32-bit platforms.
+	forwardedBytes uint64
+
+	NoOpInterceptor
+
+	metering *Metering
+	room     string
+	clientID string
+	// subscribers returns the number of subscribers of the track
+	subscribers func() int
+}
+
+func (i *meteringInterceptor) BindRTP(next RTPWriter) RTPWriter {
+	return RTPWriterFunc(func(packet *rtp.Packet) error {
+		if i.subscribers != nil {
+			if subscribers := i.subscribers(); subscribers > 0 {
+				atomic.AddUint64(&i.forwardedBytes, uint64(packet.MarshalSize()*subscribers))
+			}
+		}
+		return next.WriteRTP(packet)
+	})
+}
+
+func (i *meteringInterceptor) Close() error {
+	m := i.metering
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.interceptors, i)
+	if n := atomic.SwapUint64(&i.forwardedBytes, 0); n > 0 {
+		m.room(i.room, time.Now()).add(i.clientID, 0, n)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/pkg/client"
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestMetering_participants(t *testing.T) {
+	webhookServer, requests := newWebhookServer(t)
+	defer webhookServer.Close()
+	webhooks := newTestWebhooks(webhookServer.URL)
+	defer webhooks.Close()
+
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	wss := server.NewWSS(loggerFactory, rooms)
+	metering := server.NewMetering(loggerFactory, webhooks)
+	wss.SetMetering(metering)
+	s := httptest.NewServer(server.NewMeshHandler(loggerFactory, wss))
+	defer s.Close()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + room + "/"
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	wsA := mustDialWS(t, ctx, wsURL+"a")
+	defer wsA.Close(websocket.StatusNormalClosure, "")
+	wsB := mustDialWS(t, ctx, wsURL+"b")
+	defer wsB.Close(websocket.StatusNormalClosure, "")
+
+	require.Eventually(t, func() bool {
+		usage, ok := metering.Usage(room)
+		return ok && len(usage.Participants) == 2
+	}, timeout, 10*time.Millisecond)
+
+	wsB.Close(websocket.StatusNormalClosure, "")
+	time.Sleep(20 * time.Millisecond)
+
+	metering.Report()
+	req := receiveWebhook(t, requests)
+	assert.Equal(t, server.WebhookUsageReported, req.event.Type)
+	assert.Equal(t, room, req.event.Room)
+
+	var usage server.RoomUsage
+	data, err := json.Marshal(req.event.Data)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &usage))
+	require.Len(t, usage.Participants, 2)
+	assert.Equal(t, "a", usage.Participants[0].ClientID)
+	assert.Equal(t, "b", usage.Participants[1].ClientID)
+	assert.Greater(t, usage.Participants[0].Minutes, usage.Participants[1].Minutes)
+	assert.Greater(t, usage.Participants[1].Minutes, 0.0)
+	assert.InDelta(t, usage.Participants[0].Minutes+usage.Participants[1].Minutes, usage.ParticipantMinutes, 1e-9)
+	assert.False(t, usage.End.Before(usage.Start))
+
+	// totals include the previous report
+	total, ok := metering.Usage(room)
+	require.True(t, ok)
+	assert.GreaterOrEqual(t, total.ParticipantMinutes, usage.ParticipantMinutes)
+	usages := metering.Rooms()
+	require.Len(t, usages, 1)
+	assert.Equal(t, room, usages[0].Room)
+
+	// unused rooms are forgotten after an empty report
+	wsA.Close(websocket.StatusNormalClosure, "")
+	require.Eventually(t, func() bool {
+		metering.Report()
+		_, ok := metering.Usage(room)
+		return !ok
+	}, timeout, 10*time.Millisecond)
+}
+
+func TestMetering_recording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	metering := server.NewMetering(loggerFactory, nil)
+	recorder := server.NewRoomRecorder(loggerFactory, dir)
+	recorder.SetMetering(metering)
+
+	_, err = recorder.Start(room)
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	usage, ok := metering.Usage(room)
+	require.True(t, ok)
+	recorded := usage.RecordingMinutes
+	assert.Greater(t, recorded, 0.0)
+
+	_, ok = recorder.Stop(room)
+	require.True(t, ok)
+	usage, _ = metering.Usage(room)
+	assert.Greater(t, usage.RecordingMinutes, recorded)
+
+	time.Sleep(20 * time.Millisecond)
+	stopped, _ := metering.Usage(room)
+	assert.Equal(t, usage.RecordingMinutes, stopped.RecordingMinutes)
+}
+
+func TestMetering_forwardedBytes(t *testing.T) {
+	metering := server.NewMetering(loggerFactory, nil)
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{})
+	tracks.Use(metering)
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	wss := server.NewWSS(loggerFactory, rooms)
+	wss.SetMetering(metering)
+	srv := httptest.NewServer(server.NewSFUHandler(
+		loggerFactory,
+		wss,
+		func() []server.ICEServer { return nil },
+		server.NetworkConfigSFU{},
+		tracks,
+		nil,
+	))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	join := func(clientID string, onTrack func(*webrtc.Track, *webrtc.RTPReceiver)) *client.Client {
+		conn, err := client.DialWebSocket(ctx, srv.URL, server.SignalingHello{Room: roomName, ClientID: clientID})
+		require.NoError(t, err)
+		c, err := client.Join(ctx, conn, client.Params{
+			Room:          roomName,
+			ClientID:      clientID,
+			LoggerFactory: loggerFactory,
+			OnTrack:       onTrack,
+		})
+		require.NoError(t, err)
+		return c
+	}
+
+	received := make(chan int, 16)
+	subscriber := join("subscriber", func(track *webrtc.Track, receiver *webrtc.RTPReceiver) {
+		for {
+			packet, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+			select {
+			case received <- packet.MarshalSize():
+			default:
+			}
+		}
+	})
+	defer subscriber.Close()
+
+	publisher := join("publisher", nil)
+	defer publisher.Close()
+
+	audio := readTestAudio(t)
+	track, err := publisher.PeerConnection().NewTrack(webrtc.DefaultPayloadTypeOpus, 1000, "audio", publisher.ID())
+	require.NoError(t, err)
+	_, err = publisher.Publish(track)
+	require.NoError(t, err)
+	stop := publishTestMedia(audio, track)
+	defer stop()
+
+	select {
+	case <-received:
+	case <-ctx.Done():
+		t.Fatalf("did not receive published track: %s", ctx.Err())
+	}
+
+	require.Eventually(t, func() bool {
+		usage, ok := metering.Usage(roomName)
+		return ok && usage.ForwardedBytes > 0
+	}, timeout, 10*time.Millisecond)
+
+	usage, _ := metering.Usage(roomName)
+	for _, p := range usage.Participants {
+		if p.ClientID == "publisher" {
+			assert.Equal(t, usage.ForwardedBytes, p.ForwardedBytes)
+		} else {
+			assert.Equal(t, uint64(0), p.ForwardedBytes)
+		}
+	}
+}
@@ -3,10 +3,14 @@ package server
 import (
 	"encoding/json"
 	"html/template"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
+	"sync"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/gobuffalo/packr"
@@ -14,9 +18,27 @@ import (
 )
 
 type Mux struct {
-	BaseURL    string
-	handler    *chi.Mux
-	iceServers []ICEServer
+	BaseURL string
+	// WSS tracks websocket connections handled by this Mux
+	WSS *WSS
+	// Digests summarizes rooms of WSS
+	Digests *RoomDigests
+	// Egress delegates recording and streaming of rooms of WSS to an egress
+	// service
+	Egress *Egresses
+	// SDPHooks modify offers and answers sent to clients of the SFU
+	SDPHooks *SDPHooks
+	// Signaling handles clients of the network type, connected over any
+	// signaling transport
+	Signaling SignalingHandler
+	socketIO  SocketIOConfig
+	handler   *chi.Mux
+	hosts     []HostConfig
+
+	// configMu guards config which can be reloaded at runtime
+	configMu     sync.RWMutex
+	iceServers   []ICEServer
+	clientConfig ClientConfig
 }
 
 func (mux *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -25,6 +47,37 @@ func (mux *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 type TracksManager interface {
 	Add(room string, clientID string, pc *webrtc.PeerConnection, dc *webrtc.DataChannel, s *Signaller)
+	SetTrackLanguage(clientID string, trackID string, language string) error
+	SetLanguageFilter(clientID string, language string) error
+	SetTrackSource(clientID string, trackID string, source TrackSource) error
+	Subscribe(clientID string, publisherIDs []string) error
+	Unsubscribe(clientID string, publisherIDs []string) error
+	SubscribeAll(clientID string) error
+	SetAudioOnly(clientID string, audioOnly bool) error
+	RequestFloor(clientID string) (bool, error)
+	ReleaseFloor(clientID string) error
+	SetVideoPaused(clientID string, paused bool) error
+	SetPin(clientID string, publisherID string, trackID string, duration time.Duration) error
+	LastMediaActivity(clientID string) time.Time
+	LastActive(clientID string) time.Time
+	InjectAudio(room string, reader io.Reader) (string, error)
+	InjectDTMF(room string, digits string) (string, error)
+	StopAudio(room string, id string) bool
+	Ingest(room string, req IngestRequest) (IngestSource, error)
+	StopIngest(room string, id string) bool
+	HasPeer(clientID string) bool
+	HasPeerInRoom(room string, clientID string) bool
+	RemovePeer(clientID string) bool
+	SetMaxBitrate(clientID string, maxBitrate int) error
+	ChatHistory(room string) []ChatMessage
+	MoveToBreakoutRooms(room string, clientIDsByName map[string][]string) error
+	ReturnToMainRoom(room string) []string
+	StartCapture(room string, clientID string, req CaptureRequest) (PacketCapture, error)
+	StopCapture(clientID string, id string) bool
+	OpenCapture(name string) (*os.File, error)
+	RoomNames() []string
+	RoomPeers(room string) []PeerInfo
+	RoomTracks(room string) []TrackInfo
 }
 
 type RoomManager interface {
@@ -38,6 +91,9 @@ func NewMux(
 	version string,
 	network NetworkConfig,
 	iceServers []ICEServer,
+	admin AdminConfig,
+	inactivity InactivityConfig,
+	clientConfig ClientConfig,
 	rooms RoomManager,
 	tracks TracksManager,
 ) *Mux {
@@ -47,9 +103,10 @@ func NewMux(
 
 	handler := chi.NewRouter()
 	mux := &Mux{
-		BaseURL:    baseURL,
-		handler:    handler,
-		iceServers: iceServers,
+		BaseURL:      baseURL,
+		handler:      handler,
+		iceServers:   iceServers,
+		clientConfig: clientConfig,
 	}
 
 	var root string
@@ -59,13 +116,36 @@ func NewMux(
 		root = baseURL
 	}
 
-	wsHandler := newWebSocketHandler(
+	wss := NewWSS(loggerFactory, rooms)
+	mux.WSS = wss
+	wss.SetInactivityPolicy(inactivity, tracks)
+	mux.Digests = NewRoomDigests(loggerFactory, wss, tracks)
+	mux.Egress = NewEgresses(loggerFactory, wss, baseURL, mux.ICEServers)
+	wss.egress = mux.Egress
+	mux.SDPHooks = NewSDPHooks(loggerFactory)
+
+	newClientConfigDocument := func(host string) ClientConfigDocument {
+		mux.configMu.RLock()
+		config := mux.clientConfig
+		iceServers := mux.iceServers
+		mux.configMu.RUnlock()
+
+		if h, ok := MatchHost(mux.hosts, host); ok {
+			config = config.Merge(h.Client)
+		}
+		return NewClientConfigDocument(config, network.Type, iceServers)
+	}
+	wss.SetClientConfig(newClientConfigDocument)
+
+	mux.Signaling = wss.tenantSignaling(newSignalingHandler(
 		loggerFactory,
 		network,
-		NewWSS(loggerFactory, rooms),
-		iceServers,
+		wss,
+		mux.ICEServers,
 		tracks,
-	)
+		mux.SDPHooks,
+	))
+	wsHandler := wss.WebSocketHandler(mux.Signaling)
 
 	handler.Route(root, func(router chi.Router) {
 		router.Get("/", renderer.Render(mux.routeIndex))
@@ -73,27 +153,37 @@ func NewMux(
 		router.Handle("/res/*", static(baseURL+"/res", packr.NewBox("../res")))
 		router.Post("/call", mux.routeNewCall)
 		router.Get("/call/{callID}", renderer.Render(mux.routeCall))
+		router.Get("/api/config", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, newClientConfigDocument(r.Host))
+		})
 
 		router.Mount("/ws", wsHandler)
+		router.Handle("/socket.io/", http.HandlerFunc(mux.serveSocketIO))
+
+		if admin.Token != "" {
+			router.Mount("/api/admin", NewAdminHandler(loggerFactory, admin, wss, tracks, mux.Egress))
+			router.Mount("/api/rooms", NewRoomStateHandler(admin.APIToken(), wss, tracks, mux.Digests))
+		}
 	})
 
 	return mux
 }
 
-func newWebSocketHandler(
+func newSignalingHandler(
 	loggerFactory LoggerFactory,
 	network NetworkConfig,
 	wss *WSS,
-	iceServers []ICEServer,
+	iceServers func() []ICEServer,
 	tracks TracksManager,
-) http.Handler {
+	sdpHooks *SDPHooks,
+) SignalingHandler {
 	switch network.Type {
 	case NetworkTypeSFU:
 		log.Println("Using network type sfu")
-		return NewSFUHandler(loggerFactory, wss, iceServers, network.SFU, tracks)
+		return NewSFUSignalingHandler(loggerFactory, wss, iceServers, network.SFU, tracks, sdpHooks)
 	default:
 		log.Println("Using network type mesh")
-		return NewMeshHandler(loggerFactory, wss)
+		return NewMeshSignalingHandler(loggerFactory, wss)
 	}
 }
 
@@ -102,10 +192,32 @@ func static(prefix string, box packr.Box) http.Handler {
 	return http.StripPrefix(prefix, fileServer)
 }
 
+// ICEServers returns the ICE servers sent to clients.
+func (mux *Mux) ICEServers() []ICEServer {
+	mux.configMu.RLock()
+	defer mux.configMu.RUnlock()
+	return mux.iceServers
+}
+
+// SetICEServers replaces the ICE servers, for example when the TURN secret
+// has changed. Clients receive the new servers when they next connect.
+func (mux *Mux) SetICEServers(iceServers []ICEServer) {
+	mux.configMu.Lock()
+	defer mux.configMu.Unlock()
+	mux.iceServers = iceServers
+}
+
+// SetClientConfig replaces the config sent to clients when they connect.
+func (mux *Mux) SetClientConfig(clientConfig ClientConfig) {
+	mux.configMu.Lock()
+	defer mux.configMu.Unlock()
+	mux.clientConfig = clientConfig
+}
+
 func (mux *Mux) routeNewCall(w http.ResponseWriter, r *http.Request) {
 	callID := r.PostFormValue("call")
 	if callID == "" {
-		callID = NewUUIDBase62()
+		callID = mux.host(r).NewRoomID()
 	}
 	url := mux.BaseURL + "/call/" + url.PathEscape(callID)
 	http.Redirect(w, r, url, 302)
@@ -119,7 +231,7 @@ func (mux *Mux) routeCall(w http.ResponseWriter, r *http.Request) (string, inter
 	callID := url.PathEscape(path.Base(r.URL.Path))
 	userID := NewUUIDBase62()
 
-	iceServers := GetICEAuthServers(mux.iceServers)
+	iceServers := GetICEAuthServers(mux.ICEServers())
 	iceServersJSON, _ := json.Marshal(iceServers)
 
 	data := map[string]interface{}{
@@ -1,15 +1,24 @@
 package server_test
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/peer-calls/peer-calls/server"
 	"github.com/pion/webrtc/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
 )
 
 var iceServers = []server.ICEServer{}
@@ -21,12 +30,16 @@ type addedPeer struct {
 }
 
 type mockTracksManager struct {
-	added chan addedPeer
+	added    chan addedPeer
+	injected chan []byte
+	removed  chan string
 }
 
 func newMockTracksManager() *mockTracksManager {
 	return &mockTracksManager{
-		added: make(chan addedPeer, 10),
+		added:    make(chan addedPeer, 10),
+		injected: make(chan []byte, 10),
+		removed:  make(chan string, 10),
 	}
 }
 
@@ -38,6 +51,186 @@ func (m *mockTracksManager) Add(room string, clientID string, peerConnection *we
 	}
 }
 
+func (m *mockTracksManager) SetTrackLanguage(clientID string, trackID string, language string) error {
+	return nil
+}
+
+func (m *mockTracksManager) SetLanguageFilter(clientID string, language string) error {
+	return nil
+}
+
+func (m *mockTracksManager) SetTrackSource(clientID string, trackID string, source server.TrackSource) error {
+	return nil
+}
+
+func (m *mockTracksManager) Subscribe(clientID string, publisherIDs []string) error {
+	return nil
+}
+
+func (m *mockTracksManager) Unsubscribe(clientID string, publisherIDs []string) error {
+	return nil
+}
+
+func (m *mockTracksManager) SubscribeAll(clientID string) error {
+	return nil
+}
+
+func (m *mockTracksManager) SetAudioOnly(clientID string, audioOnly bool) error {
+	return nil
+}
+
+func (m *mockTracksManager) RequestFloor(clientID string) (bool, error) {
+	return false, nil
+}
+
+func (m *mockTracksManager) ReleaseFloor(clientID string) error {
+	return nil
+}
+
+func (m *mockTracksManager) SetVideoPaused(clientID string, paused bool) error {
+	return nil
+}
+
+func (m *mockTracksManager) SetPin(clientID string, publisherID string, trackID string, duration time.Duration) error {
+	return nil
+}
+
+func (m *mockTracksManager) InjectAudio(room string, reader io.Reader) (string, error) {
+	if room != roomName {
+		return "", server.ErrRoomNotFound
+	}
+	data, err := ioutil.ReadAll(reader)
+	m.injected <- data
+	return "audio-id", err
+}
+
+func (m *mockTracksManager) InjectDTMF(room string, digits string) (string, error) {
+	if room != roomName {
+		return "", server.ErrRoomNotFound
+	}
+	if digits == "" {
+		return "", errors.New("no digits")
+	}
+	return "audio-id", nil
+}
+
+func (m *mockTracksManager) StopAudio(room string, id string) bool {
+	return room == roomName && id == "audio-id"
+}
+
+func (m *mockTracksManager) Ingest(room string, req server.IngestRequest) (server.IngestSource, error) {
+	if req.Type != server.IngestTypeRTP {
+		return server.IngestSource{}, fmt.Errorf("Unsupported ingest type: %q", req.Type)
+	}
+	return server.IngestSource{
+		ID:       "ingest-id",
+		Room:     room,
+		ClientID: "ingest_ingest-id",
+		Type:     req.Type,
+		Port:     5004,
+	}, nil
+}
+
+func (m *mockTracksManager) StopIngest(room string, id string) bool {
+	return room == roomName && id == "ingest-id"
+}
+
+func (m *mockTracksManager) HasPeer(clientID string) bool {
+	return clientID == "zombie"
+}
+
+func (m *mockTracksManager) HasPeerInRoom(room string, clientID string) bool {
+	return room == roomName && m.HasPeer(clientID)
+}
+
+func (m *mockTracksManager) RemovePeer(clientID string) bool {
+	m.removed <- clientID
+	return clientID == "zombie"
+}
+
+func (m *mockTracksManager) SetMaxBitrate(clientID string, maxBitrate int) error {
+	if clientID != "zombie" {
+		return fmt.Errorf("peer not found")
+	}
+	return nil
+}
+
+func (m *mockTracksManager) ChatHistory(room string) []server.ChatMessage {
+	return nil
+}
+
+func (m *mockTracksManager) MoveToBreakoutRooms(room string, clientIDsByName map[string][]string) error {
+	for _, clientIDs := range clientIDsByName {
+		for _, id := range clientIDs {
+			if id != clientID {
+				return server.ErrPeerNotInRoom
+			}
+		}
+	}
+	return nil
+}
+
+func (m *mockTracksManager) ReturnToMainRoom(room string) []string {
+	return []string{clientID}
+}
+
+func (m *mockTracksManager) StartCapture(room string, clientID string, req server.CaptureRequest) (server.PacketCapture, error) {
+	if clientID != "zombie" {
+		return server.PacketCapture{}, server.ErrPeerNotInRoom
+	}
+	if req.Format != server.CaptureFormatRTPDump {
+		return server.PacketCapture{}, server.ErrInvalidCaptureFormat
+	}
+	return server.PacketCapture{
+		ID:       "capture-id",
+		Room:     room,
+		ClientID: clientID,
+		Format:   req.Format,
+		Files:    []string{"capture-id_inbound.rtpdump", "capture-id_outbound.rtpdump"},
+	}, nil
+}
+
+func (m *mockTracksManager) StopCapture(clientID string, id string) bool {
+	return clientID == "zombie" && id == "capture-id"
+}
+
+func (m *mockTracksManager) OpenCapture(name string) (*os.File, error) {
+	return nil, server.ErrCaptureNotFound
+}
+
+func (m *mockTracksManager) RoomNames() []string {
+	return []string{roomName}
+}
+
+func (m *mockTracksManager) RoomPeers(room string) []server.PeerInfo {
+	if room != roomName {
+		return nil
+	}
+	return []server.PeerInfo{{ClientID: "zombie", JoinedAt: time.Unix(1, 0), Tracks: 1}}
+}
+
+func (m *mockTracksManager) RoomTracks(room string) []server.TrackInfo {
+	if room != roomName {
+		return nil
+	}
+	return []server.TrackInfo{{ClientID: "zombie", TrackID: "track-id", Kind: "audio", SSRC: 123, Bitrate: 32000, Subscribers: 1}}
+}
+
+func (m *mockTracksManager) SetMuted(clientID string, muted bool) error {
+	return nil
+}
+
+func (m *mockTracksManager) LastMediaActivity(clientID string) time.Time {
+	return time.Time{}
+}
+
+func (m *mockTracksManager) LastActive(clientID string) time.Time {
+	if clientID == "zombie" {
+		return time.Now()
+	}
+	return time.Time{}
+}
+
 func mesh() (network server.NetworkConfig) {
 	network.Type = server.NetworkTypeMesh
 	return
@@ -47,7 +240,7 @@ func Test_routeIndex(t *testing.T) {
 	mrm := NewMockRoomManager()
 	trk := newMockTracksManager()
 	defer mrm.close()
-	mux := server.NewMux(loggerFactory, "/test", "v0.0.0", mesh(), iceServers, mrm, trk)
+	mux := server.NewMux(loggerFactory, "/test", "v0.0.0", mesh(), iceServers, server.AdminConfig{}, server.InactivityConfig{}, server.ClientConfig{}, mrm, trk)
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest("GET", "/test", nil)
 
@@ -61,7 +254,7 @@ func Test_routeIndex_noBaseURL(t *testing.T) {
 	mrm := NewMockRoomManager()
 	trk := newMockTracksManager()
 	defer mrm.close()
-	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, mrm, trk)
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, server.AdminConfig{}, server.InactivityConfig{}, server.ClientConfig{}, mrm, trk)
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest("GET", "/", nil)
 
@@ -75,7 +268,7 @@ func Test_routeNewCall_name(t *testing.T) {
 	mrm := NewMockRoomManager()
 	trk := newMockTracksManager()
 	defer mrm.close()
-	mux := server.NewMux(loggerFactory, "/test", "v0.0.0", mesh(), iceServers, mrm, trk)
+	mux := server.NewMux(loggerFactory, "/test", "v0.0.0", mesh(), iceServers, server.AdminConfig{}, server.InactivityConfig{}, server.ClientConfig{}, mrm, trk)
 	w := httptest.NewRecorder()
 	reader := strings.NewReader("call=my room")
 	r := httptest.NewRequest("POST", "/test/call", reader)
@@ -91,7 +284,7 @@ func Test_routeNewCall_random(t *testing.T) {
 	mrm := NewMockRoomManager()
 	trk := newMockTracksManager()
 	defer mrm.close()
-	mux := server.NewMux(loggerFactory, "/test", "v0.0.0", mesh(), iceServers, mrm, trk)
+	mux := server.NewMux(loggerFactory, "/test", "v0.0.0", mesh(), iceServers, server.AdminConfig{}, server.InactivityConfig{}, server.ClientConfig{}, mrm, trk)
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest("POST", "/test/call", nil)
 	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -110,7 +303,7 @@ func Test_routeCall(t *testing.T) {
 	iceServers := []server.ICEServer{{
 		URLs: []string{"stun:"},
 	}}
-	mux := server.NewMux(loggerFactory, "/test", "v0.0.0", mesh(), iceServers, mrm, trk)
+	mux := server.NewMux(loggerFactory, "/test", "v0.0.0", mesh(), iceServers, server.AdminConfig{}, server.InactivityConfig{}, server.ClientConfig{}, mrm, trk)
 	w := httptest.NewRecorder()
 	r := httptest.NewRequest("GET", "/test/call/abc", nil)
 	mux.ServeHTTP(w, r)
@@ -120,3 +313,55 @@ func Test_routeCall(t *testing.T) {
 	assert.Regexp(t, "id=\"iceServers\" value='.*stun:", w.Body.String())
 	assert.Regexp(t, "id=\"userId\" value=\"[^\"]", w.Body.String())
 }
+
+func Test_routeClientConfig(t *testing.T) {
+	mrm := NewMockRoomManager()
+	trk := newMockTracksManager()
+	defer mrm.close()
+	iceServers := []server.ICEServer{{
+		URLs: []string{"stun:"},
+	}}
+	clientConfig := server.ClientConfig{
+		Features: map[string]bool{"chat": true},
+		Branding: map[string]string{"name": "Acme Calls"},
+	}
+	mux := server.NewMux(loggerFactory, "/test", "v0.0.0", mesh(), iceServers, server.AdminConfig{}, server.InactivityConfig{}, clientConfig, mrm, trk)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/test/api/config", nil)
+	mux.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var doc server.ClientConfigDocument
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, server.ClientConfigDocument{
+		Features:   map[string]bool{"chat": true},
+		Branding:   map[string]string{"name": "Acme Calls"},
+		Limits:     map[string]int{},
+		ICEServers: []server.ICEAuthServer{{URLs: []string{"stun:"}}},
+		Network:    server.NetworkTypeMesh,
+	}, doc)
+}
+
+func Test_joinAck(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	clientConfig := server.ClientConfig{
+		Features: map[string]bool{"chat": true},
+	}
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, server.AdminConfig{}, server.InactivityConfig{}, clientConfig, rooms, newMockTracksManager())
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ws := mustDialWS(t, ctx, "ws"+strings.TrimPrefix(s.URL, "http")+"/ws/"+roomName+"/"+clientID)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+
+	msg := mustReadWS(t, ctx, ws)
+	assert.Equal(t, server.MessageTypeJoinAck, msg.Type)
+	payload := msg.Payload.(map[string]interface{})
+	assert.Equal(t, clientID, payload["clientID"])
+	config := payload["config"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"chat": true}, config["features"])
+}
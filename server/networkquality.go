@@ -0,0 +1,270 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// MessageTypeNetworkQuality is sent to all clients of a room with the
+// network quality of every peer in the room, when it changes.
+const MessageTypeNetworkQuality = "networkQuality"
+
+// NetworkQualityEvent contains the network quality of a peer, as observed by
+// the SFU.
+type NetworkQualityEvent struct {
+	ClientID string `json:"userId"`
+	// Score ranges from 1 (unusable) to 5 (excellent).
+	Score int `json:"score"`
+	// Loss is the fraction of packets lost, of packets received from the
+	// peer or of packets sent to it, whichever is worse.
+	Loss float64 `json:"loss"`
+	// Jitter in seconds, of packets received from the peer or of packets
+	// sent to it, whichever is worse.
+	Jitter float64 `json:"jitter"`
+	// RTT is the round trip time in seconds, zero when it is unknown.
+	RTT float64 `json:"rtt"`
+}
+
+// networkQualityScore estimates the mean opinion score of a call with the
+// simplified E-model, and maps it to a score from 1 to 5.
+func networkQualityScore(loss float64, jitter float64, rtt float64) int {
+	// effective latency in milliseconds
+	latency := rtt*1000/2 + jitter*1000*2 + 10
+
+	r := 93.2 - latency/40
+	if latency >= 160 {
+		r = 93.2 - (latency-120)/10
+	}
+	r -= loss * 100 * 2.5
+	r = math.Max(0, math.Min(100, r))
+
+	mos := 1 + 0.035*r + 0.000007*r*(r-60)*(100-r)
+
+	switch {
+	case mos >= 4.2:
+		return 5
+	case mos >= 4.0:
+		return 4
+	case mos >= 3.6:
+		return 3
+	case mos >= 3.1:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// downlinkQuality collects the worst loss and jitter subscribers reported in
+// receiver reports since it was last taken.
+type downlinkQuality struct {
+	mu           sync.Mutex
+	reported     bool
+	fractionLost float64
+	// jitter in seconds
+	jitter float64
+}
+
+// observe records the receiver reports in packets. clockRate is of the track
+// the reports are about.
+func (d *downlinkQuality) observe(clockRate uint32, packets []rtcp.Packet) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, packet := range packets {
+		rr, ok := packet.(*rtcp.ReceiverReport)
+		if !ok {
+			continue
+		}
+		for _, report := range rr.Reports {
+			d.reported = true
+			d.fractionLost = math.Max(d.fractionLost, float64(report.FractionLost)/256)
+			if clockRate > 0 {
+				d.jitter = math.Max(d.jitter, float64(report.Jitter)/float64(clockRate))
+			}
+		}
+	}
+}
+
+// take returns the worst loss and jitter reported since the last call.
+// Returns false when nothing was reported.
+func (d *downlinkQuality) take() (fractionLost float64, jitter float64, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fractionLost, jitter, ok = d.fractionLost, d.jitter, d.reported
+	d.reported = false
+	d.fractionLost = 0
+	d.jitter = 0
+	return
+}
+
+// uplinkCounters are the packet counters of all tracks published by a peer.
+type uplinkCounters struct {
+	received uint64
+	lost     uint64
+	// jitter is the largest jitter of the tracks in seconds
+	jitter float64
+}
+
+// networkQuality computes the network quality of peers periodically.
+type networkQuality struct {
+	rtt  func(room string, clientID string) time.Duration
+	send func(room string, events []NetworkQualityEvent)
+
+	// key is clientID
+	uplink map[string]uplinkCounters
+	// key is room, values are the scores last sent by clientID
+	sent map[string]map[string]int
+}
+
+// SetNetworkQuality computes the network quality of peers every
+// NetworkQuality.Interval, and calls send with the quality of all peers of a
+// room when a score in the room changes, or peers join or leave it. rtt
+// returns the round trip time to a peer, or zero when it is unknown. It does
+// nothing when the interval is not configured, and must be called at most
+// once.
+func (t *MemoryTracksManager) SetNetworkQuality(rtt func(room string, clientID string) time.Duration, send func(room string, events []NetworkQualityEvent)) {
+	interval := t.sfuConfig.NetworkQuality.Interval
+	if interval <= 0 {
+		return
+	}
+
+	q := &networkQuality{
+		rtt:    rtt,
+		send:   send,
+		uplink: map[string]uplinkCounters{},
+		sent:   map[string]map[string]int{},
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			t.updateNetworkQuality(q)
+		}
+	}()
+}
+
+func (t *MemoryTracksManager) updateNetworkQuality(q *networkQuality) {
+	type peerQuality struct {
+		clientID string
+		room     string
+		downlink *downlinkQuality
+	}
+
+	t.mu.RLock()
+	peers := make([]peerQuality, 0, len(t.peers))
+	for clientID, p := range t.peers {
+		peers = append(peers, peerQuality{clientID, p.room, &p.trackListener.downlink})
+	}
+	t.mu.RUnlock()
+
+	uplink := map[string]uplinkCounters{}
+	for _, stats := range t.stats.Stats() {
+		counters := uplink[stats.ClientID]
+		counters.received += stats.PacketsReceived
+		counters.lost += stats.PacketsLost
+		counters.jitter = math.Max(counters.jitter, stats.Jitter)
+		uplink[stats.ClientID] = counters
+	}
+
+	eventsByRoom := map[string][]NetworkQualityEvent{}
+	for _, p := range peers {
+		current := uplink[p.clientID]
+		previous := q.uplink[p.clientID]
+		if current.received < previous.received || current.lost < previous.lost {
+			// tracks were unpublished
+			previous = uplinkCounters{}
+		}
+
+		var loss float64
+		if lost := current.lost - previous.lost; lost > 0 {
+			loss = float64(lost) / float64(lost+current.received-previous.received)
+		}
+		jitter := current.jitter
+
+		if downLoss, downJitter, ok := p.downlink.take(); ok {
+			loss = math.Max(loss, downLoss)
+			jitter = math.Max(jitter, downJitter)
+		}
+
+		rtt := q.rtt(mainRoom(p.room), p.clientID).Seconds()
+
+		eventsByRoom[p.room] = append(eventsByRoom[p.room], NetworkQualityEvent{
+			ClientID: p.clientID,
+			Score:    networkQualityScore(loss, jitter, rtt),
+			Loss:     loss,
+			Jitter:   jitter,
+			RTT:      rtt,
+		})
+	}
+
+	q.uplink = uplink
+
+	sent := make(map[string]map[string]int, len(eventsByRoom))
+	for room, events := range eventsByRoom {
+		scores := make(map[string]int, len(events))
+		for _, event := range events {
+			scores[event.ClientID] = event.Score
+		}
+		sent[room] = scores
+
+		if scoresEqual(q.sent[room], scores) {
+			continue
+		}
+
+		sort.Slice(events, func(i, j int) bool {
+			return events[i].ClientID < events[j].ClientID
+		})
+		q.send(room, events)
+	}
+	q.sent = sent
+}
+
+func scoresEqual(a map[string]int, b map[string]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for clientID, score := range a {
+		if otherScore, ok := b[clientID]; !ok || otherScore != score {
+			return false
+		}
+	}
+	return true
+}
+
+// RTT returns the round trip time of the last websocket ping of clientID in
+// room, or zero when it is unknown. Pings are only sent when keepalive is
+// enabled.
+func (wss *WSS) RTT(room string, clientID string) time.Duration {
+	wss.connectionsMu.Lock()
+	conn, ok := wss.connections[room][clientID]
+	wss.connectionsMu.Unlock()
+
+	if !ok {
+		return 0
+	}
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.rtt
+}
+
+// SendNetworkQuality sends the network quality of peers in room to all
+// clients in the room on this instance.
+func (wss *WSS) SendNetworkQuality(room string, events []NetworkQualityEvent) {
+	wss.sendLocal(NewMessage(MessageTypeNetworkQuality, room, map[string]interface{}{
+		"qualities": events,
+	}))
+}
+
+func (c *wsConnection) setRTT(rtt time.Duration) {
+	c.mu.Lock()
+	c.rtt = rtt
+	c.mu.Unlock()
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server/logger"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkQualityScore(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		loss   float64
+		jitter float64
+		rtt    float64
+		score  int
+	}{
+		{"perfect", 0, 0, 0, 5},
+		{"typical", 0, 0.005, 0.05, 5},
+		{"some loss", 0.05, 0.005, 0.05, 4},
+		{"high latency", 0, 0.01, 0.5, 3},
+		{"heavy loss", 0.1, 0.005, 0.05, 2},
+		{"unusable", 0.3, 0.05, 1, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.score, networkQualityScore(tc.loss, tc.jitter, tc.rtt))
+		})
+	}
+}
+
+func TestDownlinkQuality(t *testing.T) {
+	var d downlinkQuality
+
+	_, _, ok := d.take()
+	assert.False(t, ok)
+
+	d.observe(48000, []rtcp.Packet{
+		&rtcp.PictureLossIndication{},
+		&rtcp.ReceiverReport{Reports: []rtcp.ReceptionReport{
+			{FractionLost: 64, Jitter: 480},
+			{FractionLost: 0, Jitter: 960},
+		}},
+	})
+
+	loss, jitter, ok := d.take()
+	assert.True(t, ok)
+	assert.Equal(t, 0.25, loss)
+	assert.Equal(t, 0.02, jitter)
+
+	_, _, ok = d.take()
+	assert.False(t, ok)
+}
+
+func TestMemoryTracksManager_updateNetworkQuality(t *testing.T) {
+	loggerFactory := logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout)
+	tm := NewMemoryTracksManager(loggerFactory, NetworkConfigSFU{})
+
+	tm.peers["a"] = &peer{room: "room", trackListener: &trackListener{}}
+	tm.peers["b"] = &peer{room: "room", trackListener: &trackListener{}}
+
+	track, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeOpus, 1, "audio", "stream", webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000))
+	require.NoError(t, err)
+	interceptor, err := tm.stats.NewInterceptor(InterceptorParams{ClientID: "a", LocalTrack: track})
+	require.NoError(t, err)
+	defer interceptor.Close()
+	writer := interceptor.BindRTP(RTPWriterFunc(func(packet *rtp.Packet) error {
+		return nil
+	}))
+
+	type sent struct {
+		room   string
+		events []NetworkQualityEvent
+	}
+	sentCh := make(chan sent, 10)
+	q := &networkQuality{
+		rtt: func(room string, clientID string) time.Duration {
+			assert.Equal(t, "room", room)
+			return 50 * time.Millisecond
+		},
+		send: func(room string, events []NetworkQualityEvent) {
+			sentCh <- sent{room, events}
+		},
+		uplink: map[string]uplinkCounters{},
+		sent:   map[string]map[string]int{},
+	}
+
+	tm.updateNetworkQuality(q)
+	s := <-sentCh
+	assert.Equal(t, "room", s.room)
+	assert.Equal(t, []NetworkQualityEvent{
+		{ClientID: "a", Score: 5, RTT: 0.05},
+		{ClientID: "b", Score: 5, RTT: 0.05},
+	}, s.events)
+
+	tm.updateNetworkQuality(q)
+	assert.Empty(t, sentCh, "nothing changed")
+
+	// 3 of 5 packets of a are lost
+	for _, seq := range []uint16{1, 5} {
+		require.NoError(t, writer.WriteRTP(&rtp.Packet{Header: rtp.Header{SequenceNumber: seq}}))
+	}
+	tm.peers["b"].trackListener.downlink.observe(48000, []rtcp.Packet{
+		&rtcp.ReceiverReport{Reports: []rtcp.ReceptionReport{{FractionLost: 26}}},
+	})
+
+	tm.updateNetworkQuality(q)
+	s = <-sentCh
+	require.Len(t, s.events, 2)
+	assert.Equal(t, "a", s.events[0].ClientID)
+	assert.Equal(t, 1, s.events[0].Score)
+	assert.Equal(t, 0.6, s.events[0].Loss)
+	assert.Equal(t, "b", s.events[1].ClientID)
+	assert.Equal(t, 2, s.events[1].Score)
+
+	tm.updateNetworkQuality(q)
+	s = <-sentCh
+	assert.Equal(t, 5, s.events[0].Score, "no packets lost since the last update")
+	assert.Equal(t, 5, s.events[1].Score, "no reports since the last update")
+}
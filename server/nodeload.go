@@ -0,0 +1,249 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const loadSampleInterval = 5 * time.Second
+
+// ErrRoomFullOnNode is sent to clients of the sfu network type instead of
+// creating a peer connection for them when this instance has reached one of
+// its node capacity thresholds, so that the clients already publishing are
+// not degraded.
+var ErrRoomFullOnNode = &JoinError{Code: "roomFullOnNode", Message: "Server is too busy to accept more publishers"}
+
+// NodeLoad is the load of an instance.
+type NodeLoad struct {
+	// CPU is the fraction of the CPU time of the host which was in use during
+	// the last sample interval, between 0 and 1.
+	CPU float64 `json:"cpu"`
+	// Bitrate is the total bitrate of the published tracks received by the
+	// instance, in bits per second.
+	Bitrate uint64 `json:"bitrate"`
+	// Tracks is the number of published tracks.
+	Tracks int `json:"tracks"`
+	// Score is the highest fraction of a node capacity threshold reached.
+	// New publishers are refused when it is 1 or more.
+	Score float64 `json:"score"`
+}
+
+// Score returns the highest fraction of a threshold reached by load.
+func (c NodeCapacityConfig) Score(load NodeLoad) float64 {
+	score := 0.0
+	if c.MaxCPU > 0 {
+		score = math.Max(score, load.CPU/c.MaxCPU)
+	}
+	if c.MaxBitrate > 0 {
+		score = math.Max(score, float64(load.Bitrate)/float64(c.MaxBitrate))
+	}
+	if c.MaxTracks > 0 {
+		score = math.Max(score, float64(load.Tracks)/float64(c.MaxTracks))
+	}
+	return score
+}
+
+// LoadMonitor samples the load of this instance. The CPU usage is read from
+// /proc/stat, and is always zero on other operating systems. A nil
+// LoadMonitor reports no load.
+type LoadMonitor struct {
+	log        Logger
+	trackStats func() []TrackStats
+
+	mu   sync.Mutex
+	load NodeLoad
+	// CPU times of the previous sample, in clock ticks
+	cpuBusy  uint64
+	cpuTotal uint64
+	// cpuUnavailable is set when /proc/stat cannot be read
+	cpuUnavailable bool
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewLoadMonitor creates a LoadMonitor reading the published tracks from
+// trackStats.
+func NewLoadMonitor(loggerFactory LoggerFactory, trackStats func() []TrackStats) *LoadMonitor {
+	return &LoadMonitor{
+		log:        loggerFactory.GetLogger("load"),
+		trackStats: trackStats,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Start samples the load right away and then every five seconds until Stop
+// is called.
+func (m *LoadMonitor) Start() {
+	m.Sample()
+
+	go func() {
+		defer close(m.done)
+
+		ticker := time.NewTicker(loadSampleInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.Sample()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops sampling. It must only be called after Start.
+func (m *LoadMonitor) Stop() {
+	if m == nil {
+		return
+	}
+
+	m.stopOnce.Do(func() {
+		close(m.stop)
+		<-m.done
+	})
+}
+
+// Sample updates the load. The CPU usage is measured since the previous
+// sample, so it is zero after the first one.
+func (m *LoadMonitor) Sample() {
+	if m == nil {
+		return
+	}
+
+	var load NodeLoad
+	for _, stats := range m.trackStats() {
+		load.Tracks++
+		load.Bitrate += stats.Bitrate
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.cpuUnavailable {
+		busy, total, err := readCPUTimes("/proc/stat")
+		if err != nil {
+			m.log.Printf("Error reading CPU usage, only tracks and bitrate are measured: %s", err)
+			m.cpuUnavailable = true
+		} else {
+			if total > m.cpuTotal && m.cpuTotal > 0 {
+				load.CPU = float64(busy-m.cpuBusy) / float64(total-m.cpuTotal)
+			}
+			m.cpuBusy = busy
+			m.cpuTotal = total
+		}
+	}
+
+	m.load = load
+}
+
+// Load returns the load of the last sample, without a score.
+func (m *LoadMonitor) Load() NodeLoad {
+	if m == nil {
+		return NodeLoad{}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.load
+}
+
+// readCPUTimes returns the busy and total CPU times of all CPUs from the
+// first line of a file in the format of /proc/stat. Time spent idle or
+// waiting for I/O is not busy.
+func readCPUTimes(fileName string) (busy uint64, total uint64, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil {
+		return 0, 0, err
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, fmt.Errorf("unexpected format: %q", line)
+	}
+
+	// user, nice, system, idle, iowait, irq, softirq and steal. Guest time is
+	// already included in user time.
+	for i, field := range fields[1:] {
+		if i >= 8 {
+			break
+		}
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unexpected format: %q", line)
+		}
+		total += value
+		if i != 3 && i != 4 {
+			busy += value
+		}
+	}
+	return busy, total, nil
+}
+
+// SetLoadMonitor sets the LoadMonitor the load of this instance is read
+// from.
+func (wss *WSS) SetLoadMonitor(monitor *LoadMonitor) {
+	wss.loadMonitor = monitor
+}
+
+// NodeLoad returns the last sampled load of this instance, scored against
+// the configured node capacity.
+func (wss *WSS) NodeLoad() NodeLoad {
+	wss.connectionsMu.Lock()
+	config := wss.capacity.Node
+	wss.connectionsMu.Unlock()
+
+	load := wss.loadMonitor.Load()
+	load.Score = config.Score(load)
+	return load
+}
+
+// checkNodeCapacity returns ErrRoomFullOnNode with the configured redirect
+// URL when this instance has reached one of its node capacity thresholds.
+func (wss *WSS) checkNodeCapacity() *JoinError {
+	wss.connectionsMu.Lock()
+	config := wss.capacity.Node
+	wss.connectionsMu.Unlock()
+
+	if config.Score(wss.loadMonitor.Load()) < 1 {
+		return nil
+	}
+
+	joinErr := *ErrRoomFullOnNode
+	joinErr.Redirect = config.RedirectURL
+	return &joinErr
+}
+
+// refusePublisher sends joinErr to clientID and disconnects it.
+func (wss *WSS) refusePublisher(room string, clientID string, joinErr *JoinError) {
+	wss.connectionsMu.Lock()
+	conn, ok := wss.connections[room][clientID]
+	wss.connectionsMu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	wss.log.Printf("Refusing publisher clientID: %s in room: %s: %s", clientID, room, joinErr.Code)
+
+	if err := conn.client.Write(NewMessageJoinError(room, joinErr)); err != nil {
+		wss.log.Printf("Error sending join error to clientID: %s: %s", clientID, err)
+	}
+	conn.cancel()
+}
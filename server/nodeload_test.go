@@ -0,0 +1,107 @@
+package server_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestNodeCapacityConfig_Score(t *testing.T) {
+	load := server.NodeLoad{CPU: 0.4, Bitrate: 3000000, Tracks: 10}
+
+	assert.Equal(t, 0.0, server.NodeCapacityConfig{}.Score(load), "no thresholds")
+	assert.Equal(t, 0.5, server.NodeCapacityConfig{MaxCPU: 0.8}.Score(load))
+	assert.Equal(t, 1.5, server.NodeCapacityConfig{MaxCPU: 0.8, MaxBitrate: 2000000}.Score(load))
+	assert.Equal(t, 2.0, server.NodeCapacityConfig{MaxCPU: 0.8, MaxBitrate: 2000000, MaxTracks: 5}.Score(load))
+}
+
+func TestLoadMonitor(t *testing.T) {
+	var nilMonitor *server.LoadMonitor
+	nilMonitor.Sample()
+	assert.Equal(t, server.NodeLoad{}, nilMonitor.Load())
+
+	stats := []server.TrackStats{{Bitrate: 1000}, {Bitrate: 2500}}
+	monitor := server.NewLoadMonitor(loggerFactory, func() []server.TrackStats {
+		return stats
+	})
+	monitor.Start()
+	defer monitor.Stop()
+
+	load := monitor.Load()
+	assert.Equal(t, 2, load.Tracks)
+	assert.Equal(t, uint64(3500), load.Bitrate)
+	assert.Equal(t, 0.0, load.Score)
+
+	stats = nil
+	monitor.Sample()
+	load = monitor.Load()
+	assert.Equal(t, 0, load.Tracks)
+	assert.Equal(t, uint64(0), load.Bitrate)
+	assert.True(t, load.CPU >= 0 && load.CPU <= 1, "cpu: %f", load.CPU)
+}
+
+func TestWS_P2S_RoomFullOnNode(t *testing.T) {
+	newAdapter := server.NewAdapterFactory(loggerFactory, server.StoreConfig{})
+	defer newAdapter.Close()
+	rooms := server.NewAdapterRoomManager(newAdapter.NewAdapter)
+	wss := server.NewWSS(loggerFactory, rooms)
+	monitor := server.NewLoadMonitor(loggerFactory, func() []server.TrackStats {
+		return []server.TrackStats{{Bitrate: 1000}, {Bitrate: 1000}}
+	})
+	monitor.Sample()
+	wss.SetLoadMonitor(monitor)
+	wss.SetCapacity(server.CapacityConfig{
+		Node: server.NodeCapacityConfig{
+			MaxTracks:   2,
+			RedirectURL: "https://overflow.example.com",
+		},
+	})
+	handler := server.NewSFUHandler(
+		loggerFactory,
+		wss,
+		func() []server.ICEServer { return nil },
+		server.NetworkConfigSFU{},
+		server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{}),
+		nil,
+	)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/" + roomName + "/"
+	ready := func(ws *websocket.Conn) {
+		mustWriteWS(t, ctx, ws, server.NewMessage("ready", roomName, map[string]interface{}{
+			"nickname": "user",
+		}))
+	}
+
+	assert.Equal(t, 1.0, wss.NodeLoad().Score)
+
+	ws1 := mustDialWS(t, ctx, wsURL+"user1")
+	defer ws1.Close(websocket.StatusNormalClosure, "")
+	ready(ws1)
+	msg := mustReadWSType(t, ctx, ws1, server.MessageTypeJoinError)
+	assert.Equal(t, map[string]interface{}{
+		"code":     "roomFullOnNode",
+		"message":  "Server is too busy to accept more publishers",
+		"redirect": "https://overflow.example.com",
+	}, msg.Payload)
+	_, _, err := ws1.Read(ctx)
+	require.Error(t, err, "connection is closed")
+
+	wss.SetCapacity(server.CapacityConfig{
+		Node: server.NodeCapacityConfig{MaxTracks: 3},
+	})
+	ws2 := mustDialWS(t, ctx, wsURL+"user2")
+	defer ws2.Close(websocket.StatusNormalClosure, "")
+	ready(ws2)
+	payload := mustReadWSType(t, ctx, ws2, "users").Payload.(map[string]interface{})
+	assert.Equal(t, "__SERVER__", payload["initiator"])
+}
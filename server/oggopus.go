@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+const (
+	oggPageHeaderSize = 27
+	opusSampleRate    = 48000
+)
+
+var (
+	oggCapturePattern = []byte("OggS")
+	opusHeadSignature = []byte("OpusHead")
+	opusTagsSignature = []byte("OpusTags")
+)
+
+// OggOpusReader reads Opus packets from an Ogg container, as described in
+// RFC 7845. Only the first logical bitstream is supported.
+type OggOpusReader struct {
+	reader *bufio.Reader
+	// packets read from the last page, but not returned yet
+	packets [][]byte
+	// beginning of a packet continued on the next page
+	partial []byte
+}
+
+// NewOggOpusReader reads the Opus headers from reader and returns an error
+// if reader does not contain an Ogg Opus stream.
+func NewOggOpusReader(reader io.Reader) (*OggOpusReader, error) {
+	o := &OggOpusReader{
+		reader: bufio.NewReader(reader),
+	}
+
+	head, err := o.ReadPacket()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading OpusHead: %w", err)
+	}
+	if !bytes.HasPrefix(head, opusHeadSignature) {
+		return nil, fmt.Errorf("Not an Ogg Opus stream")
+	}
+
+	tags, err := o.ReadPacket()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading OpusTags: %w", err)
+	}
+	if !bytes.HasPrefix(tags, opusTagsSignature) {
+		return nil, fmt.Errorf("Missing OpusTags header")
+	}
+
+	return o, nil
+}
+
+// ReadPacket returns the next Opus packet, or io.EOF at the end of the
+// stream.
+func (o *OggOpusReader) ReadPacket() ([]byte, error) {
+	for len(o.packets) == 0 {
+		if err := o.readPage(); err != nil {
+			return nil, err
+		}
+	}
+
+	packet := o.packets[0]
+	o.packets = o.packets[1:]
+	return packet, nil
+}
+
+func (o *OggOpusReader) readPage() error {
+	header := make([]byte, oggPageHeaderSize)
+	if _, err := io.ReadFull(o.reader, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("Truncated Ogg page header")
+		}
+		return err
+	}
+	if !bytes.Equal(header[:4], oggCapturePattern) {
+		return fmt.Errorf("Invalid Ogg capture pattern")
+	}
+
+	segmentTable := make([]byte, header[26])
+	if _, err := io.ReadFull(o.reader, segmentTable); err != nil {
+		return fmt.Errorf("Truncated Ogg segment table: %w", err)
+	}
+
+	for _, segmentSize := range segmentTable {
+		segment := make([]byte, segmentSize)
+		if _, err := io.ReadFull(o.reader, segment); err != nil {
+			return fmt.Errorf("Truncated Ogg page: %w", err)
+		}
+
+		o.partial = append(o.partial, segment...)
+		// Segments shorter than 255 bytes terminate a packet
+		if segmentSize < 255 {
+			o.packets = append(o.packets, o.partial)
+			o.partial = nil
+		}
+	}
+
+	return nil
+}
+
+// OpusPacketSamples returns the number of samples per channel at 48 kHz in
+// an Opus packet, as described in RFC 6716 section 3.1.
+func OpusPacketSamples(packet []byte) uint32 {
+	if len(packet) == 0 {
+		return 0
+	}
+
+	toc := packet[0]
+	config := toc >> 3
+
+	var frameSamples uint32
+	switch {
+	case config < 12:
+		// SILK-only: 10, 20, 40 or 60 ms
+		frameSamples = [4]uint32{480, 960, 1920, 2880}[config%4]
+	case config < 16:
+		// Hybrid: 10 or 20 ms
+		frameSamples = [2]uint32{480, 960}[config%2]
+	default:
+		// CELT-only: 2.5, 5, 10 or 20 ms
+		frameSamples = [4]uint32{120, 240, 480, 960}[config%4]
+	}
+
+	frames := uint32(1)
+	switch toc & 0x03 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(packet) < 2 {
+			return 0
+		}
+		frames = uint32(packet[1] & 0x3f)
+	}
+
+	return frameSamples * frames
+}
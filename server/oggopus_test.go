@@ -0,0 +1,94 @@
+package server_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2/pkg/media/oggwriter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOggOpusReader(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := oggwriter.NewWith(&buf, 48000, 2)
+	require.NoError(t, err)
+
+	payloads := [][]byte{
+		{0xfc, 1, 2, 3},
+		{0xfc, 4},
+	}
+	for i, payload := range payloads {
+		require.NoError(t, w.WriteRTP(&rtp.Packet{
+			Header:  rtp.Header{SequenceNumber: uint16(i), Timestamp: uint32(i * 960)},
+			Payload: payload,
+		}))
+	}
+	require.NoError(t, w.Close())
+
+	r, err := server.NewOggOpusReader(&buf)
+	require.NoError(t, err)
+
+	for _, payload := range payloads {
+		packet, err := r.ReadPacket()
+		require.NoError(t, err)
+		assert.Equal(t, payload, packet)
+	}
+	_, err = r.ReadPacket()
+	assert.Equal(t, io.EOF, err)
+}
+
+// oggPage creates an Ogg page containing packets. The checksum is not
+// calculated.
+func oggPage(packets ...[]byte) []byte {
+	var segmentTable, data []byte
+	for _, packet := range packets {
+		size := len(packet)
+		for ; size >= 255; size -= 255 {
+			segmentTable = append(segmentTable, 255)
+		}
+		segmentTable = append(segmentTable, byte(size))
+		data = append(data, packet...)
+	}
+	header := make([]byte, 27)
+	copy(header, "OggS")
+	header[26] = byte(len(segmentTable))
+	return append(append(header, segmentTable...), data...)
+}
+
+func TestOggOpusReader_lacing(t *testing.T) {
+	long := bytes.Repeat([]byte{0xfc}, 510)
+	var buf bytes.Buffer
+	buf.Write(oggPage([]byte("OpusHead")))
+	buf.Write(oggPage([]byte("OpusTags")))
+	buf.Write(oggPage(long, []byte{0xfc, 1}))
+
+	r, err := server.NewOggOpusReader(&buf)
+	require.NoError(t, err)
+
+	packet, err := r.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, long, packet)
+	packet, err = r.ReadPacket()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xfc, 1}, packet)
+}
+
+func TestOggOpusReader_invalid(t *testing.T) {
+	_, err := server.NewOggOpusReader(bytes.NewReader([]byte("not an ogg file")))
+	assert.Error(t, err)
+}
+
+func TestMemoryTracksManager_InjectAudio_roomNotFound(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := oggwriter.NewWith(&buf, 48000, 2)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{})
+	_, err = tracks.InjectAudio("missing", &buf)
+	assert.Equal(t, server.ErrRoomNotFound, err)
+}
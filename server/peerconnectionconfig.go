@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/sdp/v2"
+	"github.com/pion/webrtc/v2"
+)
+
+// Bundle policies of peer connections to the SFU.
+const (
+	BundlePolicyBalanced  = "balanced"
+	BundlePolicyMaxBundle = "max-bundle"
+)
+
+// DTLS roles of the SFU when it answers an offer.
+const (
+	DTLSRoleAuto   = "auto"
+	DTLSRoleClient = "client"
+	DTLSRoleServer = "server"
+)
+
+// apply sets the DTLS role of settingEngine. Returns an error when c is
+// invalid.
+func (c PeerConnectionConfig) apply(settingEngine *webrtc.SettingEngine) error {
+	switch c.BundlePolicy {
+	case "", BundlePolicyBalanced, BundlePolicyMaxBundle:
+	default:
+		return fmt.Errorf("unsupported bundle policy: %q", c.BundlePolicy)
+	}
+
+	switch c.DTLSRole {
+	case "", DTLSRoleAuto:
+		return nil
+	case DTLSRoleClient:
+		return settingEngine.SetAnsweringDTLSRole(webrtc.DTLSRoleClient)
+	case DTLSRoleServer:
+		return settingEngine.SetAnsweringDTLSRole(webrtc.DTLSRoleServer)
+	default:
+		return fmt.Errorf("invalid DTLS role: %q", c.DTLSRole)
+	}
+}
+
+// configure sets the bundle and rtcp-mux policies of webrtcConfig.
+func (c PeerConnectionConfig) configure(webrtcConfig *webrtc.Configuration) {
+	webrtcConfig.BundlePolicy = webrtc.BundlePolicyBalanced
+	if c.BundlePolicy == BundlePolicyMaxBundle {
+		webrtcConfig.BundlePolicy = webrtc.BundlePolicyMaxBundle
+	}
+
+	webrtcConfig.RTCPMuxPolicy = webrtc.RTCPMuxPolicyNegotiate
+	if c.RTCPMuxRequired {
+		webrtcConfig.RTCPMuxPolicy = webrtc.RTCPMuxPolicyRequire
+	}
+}
+
+// CheckSDP returns an error when a remote session description does not
+// bundle all media sections with the max-bundle policy, or when one of its
+// audio or video sections does not multiplex RTCP while it is required.
+// Rejected media sections are ignored.
+func (c PeerConnectionConfig) CheckSDP(sessionDescription string) error {
+	maxBundle := c.BundlePolicy == BundlePolicyMaxBundle
+	if !maxBundle && !c.RTCPMuxRequired {
+		return nil
+	}
+
+	parsed := sdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(sessionDescription)); err != nil {
+		return fmt.Errorf("Error parsing SDP: %w", err)
+	}
+
+	bundled := map[string]struct{}{}
+	if group, ok := parsed.Attribute(sdp.AttrKeyGroup); ok {
+		fields := strings.Fields(group)
+		if len(fields) > 0 && fields[0] == "BUNDLE" {
+			for _, mid := range fields[1:] {
+				bundled[mid] = struct{}{}
+			}
+		}
+	}
+
+	for i, md := range parsed.MediaDescriptions {
+		if md.MediaName.Port.Value == 0 {
+			continue
+		}
+
+		if maxBundle {
+			mid, _ := md.Attribute(sdp.AttrKeyMID)
+			if _, ok := bundled[mid]; !ok {
+				return fmt.Errorf("media section %d (mid: %q) is not bundled", i, mid)
+			}
+		}
+
+		if c.RTCPMuxRequired && md.MediaName.Media != "application" {
+			if _, ok := md.Attribute(sdp.AttrKeyRTCPMux); !ok {
+				return fmt.Errorf("media section %d does not multiplex RTCP", i)
+			}
+		}
+	}
+
+	return nil
+}
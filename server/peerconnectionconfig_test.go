@@ -0,0 +1,64 @@
+package server_test
+
+import (
+	"testing"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+)
+
+const sdpBundled = `v=0
+o=- 1 1 IN IP4 0.0.0.0
+s=-
+t=0 0
+a=group:BUNDLE 0 1 2
+m=audio 9 UDP/TLS/RTP/SAVPF 111
+c=IN IP4 0.0.0.0
+a=mid:0
+a=rtcp-mux
+a=rtpmap:111 opus/48000/2
+m=video 9 UDP/TLS/RTP/SAVPF 96
+c=IN IP4 0.0.0.0
+a=mid:1
+a=rtcp-mux
+a=rtpmap:96 VP8/90000
+m=application 9 UDP/DTLS/SCTP webrtc-datachannel
+c=IN IP4 0.0.0.0
+a=mid:2
+`
+
+const sdpUnbundled = `v=0
+o=- 1 1 IN IP4 0.0.0.0
+s=-
+t=0 0
+a=group:BUNDLE 0
+m=audio 9 UDP/TLS/RTP/SAVPF 111
+c=IN IP4 0.0.0.0
+a=mid:0
+a=rtcp-mux
+a=rtpmap:111 opus/48000/2
+m=video 9 UDP/TLS/RTP/SAVPF 96
+c=IN IP4 0.0.0.0
+a=mid:1
+a=rtpmap:96 VP8/90000
+m=video 0 UDP/TLS/RTP/SAVPF 96
+c=IN IP4 0.0.0.0
+a=mid:2
+a=rtpmap:96 VP8/90000
+`
+
+func TestPeerConnectionConfig_CheckSDP(t *testing.T) {
+	maxBundle := server.PeerConnectionConfig{BundlePolicy: server.BundlePolicyMaxBundle}
+	rtcpMux := server.PeerConnectionConfig{RTCPMuxRequired: true}
+
+	assert.NoError(t, server.PeerConnectionConfig{}.CheckSDP(sdpUnbundled), "no policies")
+	assert.NoError(t, server.PeerConnectionConfig{}.CheckSDP("invalid"), "not parsed")
+
+	assert.NoError(t, maxBundle.CheckSDP(sdpBundled))
+	assert.EqualError(t, maxBundle.CheckSDP(sdpUnbundled), `media section 1 (mid: "1") is not bundled`)
+
+	assert.NoError(t, rtcpMux.CheckSDP(sdpBundled), "data channel does not use rtcp")
+	assert.EqualError(t, rtcpMux.CheckSDP(sdpUnbundled), "media section 1 does not multiplex RTCP")
+
+	assert.Error(t, rtcpMux.CheckSDP("invalid"))
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pion/webrtc/v2"
+)
+
+const MessageTypePeerConnectionState = "peerConnectionState"
+
+// PeerConnectionState is the state of the peer connection between a client
+// and the server, as shown to the other clients in the room.
+type PeerConnectionState string
+
+const (
+	PeerConnectionStateConnected PeerConnectionState = "connected"
+	// PeerConnectionStateReconnecting means ICE was disconnected and the
+	// connection is given a grace period to recover.
+	PeerConnectionStateReconnecting PeerConnectionState = "reconnecting"
+	// PeerConnectionStateFailed means the connection did not recover and is
+	// about to be closed.
+	PeerConnectionStateFailed PeerConnectionState = "failed"
+)
+
+// PeerConnectionStateEvent is broadcast to the room when the peer connection
+// of a client changes its state.
+type PeerConnectionStateEvent struct {
+	ClientID string              `json:"userId"`
+	State    PeerConnectionState `json:"state"`
+}
+
+const defaultDisconnectGracePeriod = 10 * time.Second
+
+// SetDisconnectGracePeriod sets how long the peer connection may stay
+// disconnected before it is considered failed and closed. Zero closes it as
+// soon as it is disconnected.
+func (s *Signaller) SetDisconnectGracePeriod(gracePeriod time.Duration) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.disconnectGracePeriod = gracePeriod
+}
+
+// OnConnectionState registers fn, which is called when the peer connection
+// becomes connected, starts reconnecting or fails.
+func (s *Signaller) OnConnectionState(fn func(PeerConnectionState)) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	s.onConnectionState = fn
+}
+
+func (s *Signaller) handleICEConnectionStateChange(connectionState webrtc.ICEConnectionState) {
+	s.log.Printf("Peer connection state changed: %s", connectionState.String())
+
+	switch connectionState {
+	case webrtc.ICEConnectionStateConnected:
+		s.endSpan(nil)
+		s.setConnectionState(PeerConnectionStateConnected)
+	case webrtc.ICEConnectionStateDisconnected:
+		s.endSpan(fmt.Errorf("Peer connection %s", connectionState))
+		if !s.startDisconnectTimer() {
+			s.fail()
+		}
+	case webrtc.ICEConnectionStateFailed:
+		s.endSpan(fmt.Errorf("Peer connection %s", connectionState))
+		s.fail()
+	case webrtc.ICEConnectionStateClosed:
+		s.endSpan(fmt.Errorf("Peer connection %s", connectionState))
+		s.Close()
+	}
+}
+
+// startDisconnectTimer sets the reconnecting state and fails the connection
+// when it is not connected again within the grace period. Returns false when
+// there is no grace period.
+func (s *Signaller) startDisconnectTimer() bool {
+	s.stateMu.Lock()
+	if s.disconnectGracePeriod <= 0 {
+		s.stateMu.Unlock()
+		return false
+	}
+	if s.disconnectTimer == nil {
+		gracePeriod := s.disconnectGracePeriod
+		s.disconnectTimer = time.AfterFunc(gracePeriod, func() {
+			s.log.Printf("Peer connection not reconnected within %s", gracePeriod)
+			s.fail()
+		})
+	}
+	s.stateMu.Unlock()
+
+	s.setConnectionState(PeerConnectionStateReconnecting)
+	return true
+}
+
+func (s *Signaller) stopDisconnectTimer() {
+	if s.disconnectTimer != nil {
+		s.disconnectTimer.Stop()
+		s.disconnectTimer = nil
+	}
+}
+
+func (s *Signaller) fail() {
+	s.setConnectionState(PeerConnectionStateFailed)
+	s.Close()
+}
+
+// setConnectionState calls the OnConnectionState callback when state is
+// different from the last state. States are not changed after the
+// connection failed.
+func (s *Signaller) setConnectionState(state PeerConnectionState) {
+	s.stateMu.Lock()
+	if state != PeerConnectionStateReconnecting {
+		s.stopDisconnectTimer()
+	}
+	if s.connectionState == state || s.connectionState == PeerConnectionStateFailed {
+		s.stateMu.Unlock()
+		return
+	}
+	s.connectionState = state
+	fn := s.onConnectionState
+	s.stateMu.Unlock()
+
+	if fn != nil {
+		fn(state)
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server/logger"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStateSignaller(t *testing.T, gracePeriod time.Duration) (*Signaller, <-chan PeerConnectionState) {
+	t.Helper()
+
+	mediaEngine := webrtc.MediaEngine{}
+	mediaEngine.RegisterDefaultCodecs()
+	pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+
+	loggerFactory := logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout)
+	s, err := NewSignaller(loggerFactory, false, pc, &mediaEngine, "__SERVER__", "a")
+	require.NoError(t, err)
+
+	states := make(chan PeerConnectionState, 10)
+	s.SetDisconnectGracePeriod(gracePeriod)
+	s.OnConnectionState(func(state PeerConnectionState) {
+		states <- state
+	})
+	return s, states
+}
+
+func readTestState(t *testing.T, states <-chan PeerConnectionState, timeout time.Duration) PeerConnectionState {
+	t.Helper()
+	select {
+	case state := <-states:
+		return state
+	case <-time.After(timeout):
+		t.Fatal("timed out waiting for connection state")
+		return ""
+	}
+}
+
+func assertSignallerClosed(t *testing.T, s *Signaller, timeout time.Duration) {
+	t.Helper()
+	select {
+	case <-s.CloseChannel():
+	case <-time.After(timeout):
+		t.Fatal("timed out waiting for signaller to close")
+	}
+}
+
+func TestSignaller_disconnectGracePeriod_reconnected(t *testing.T) {
+	s, states := newTestStateSignaller(t, 100*time.Millisecond)
+	defer s.Close()
+
+	s.handleICEConnectionStateChange(webrtc.ICEConnectionStateConnected)
+	assert.Equal(t, PeerConnectionStateConnected, readTestState(t, states, time.Second))
+
+	s.handleICEConnectionStateChange(webrtc.ICEConnectionStateDisconnected)
+	assert.Equal(t, PeerConnectionStateReconnecting, readTestState(t, states, time.Second))
+
+	s.handleICEConnectionStateChange(webrtc.ICEConnectionStateConnected)
+	assert.Equal(t, PeerConnectionStateConnected, readTestState(t, states, time.Second))
+
+	select {
+	case <-s.CloseChannel():
+		t.Fatal("reconnected signaller should not be closed")
+	case state := <-states:
+		t.Fatalf("unexpected state: %s", state)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSignaller_disconnectGracePeriod_expired(t *testing.T) {
+	s, states := newTestStateSignaller(t, 50*time.Millisecond)
+	defer s.Close()
+
+	s.handleICEConnectionStateChange(webrtc.ICEConnectionStateConnected)
+	assert.Equal(t, PeerConnectionStateConnected, readTestState(t, states, time.Second))
+
+	s.handleICEConnectionStateChange(webrtc.ICEConnectionStateDisconnected)
+	assert.Equal(t, PeerConnectionStateReconnecting, readTestState(t, states, time.Second))
+	assert.Equal(t, PeerConnectionStateFailed, readTestState(t, states, time.Second))
+	assertSignallerClosed(t, s, time.Second)
+}
+
+func TestSignaller_disconnectGracePeriod_failed(t *testing.T) {
+	s, states := newTestStateSignaller(t, time.Minute)
+	defer s.Close()
+
+	s.handleICEConnectionStateChange(webrtc.ICEConnectionStateDisconnected)
+	assert.Equal(t, PeerConnectionStateReconnecting, readTestState(t, states, time.Second))
+
+	s.handleICEConnectionStateChange(webrtc.ICEConnectionStateFailed)
+	assert.Equal(t, PeerConnectionStateFailed, readTestState(t, states, time.Second))
+	assertSignallerClosed(t, s, time.Second)
+}
+
+func TestSignaller_disconnectGracePeriod_zero(t *testing.T) {
+	s, states := newTestStateSignaller(t, 0)
+	defer s.Close()
+
+	s.handleICEConnectionStateChange(webrtc.ICEConnectionStateDisconnected)
+	assert.Equal(t, PeerConnectionStateFailed, readTestState(t, states, time.Second))
+	assertSignallerClosed(t, s, time.Second)
+}
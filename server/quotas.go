@@ -0,0 +1,195 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// MessageTypeQuotaExceeded is sent to a client instead of creating a peer
+// connection when its IP address has too many of them.
+const MessageTypeQuotaExceeded = "ws_quota_exceeded"
+
+const (
+	defaultQuotaBackoff    = time.Second
+	defaultQuotaMaxBackoff = 5 * time.Minute
+)
+
+var (
+	ErrIPConnectionLimit     = &JoinError{Code: "ipConnectionLimit", Message: "Too many connections"}
+	ErrIPPeerConnectionLimit = &JoinError{Code: "ipPeerConnectionLimit", Message: "Too many peer connections"}
+	ErrRoomCreationLimit     = &JoinError{Code: "roomCreationLimit", Message: "Too many rooms created"}
+)
+
+// ipQuotas limits the signaling connections, peer connections and room
+// creations of clients from the same IP address, so that a single host
+// cannot exhaust the UDP ports and goroutines of the server. A nil ipQuotas
+// has no limits.
+//
+// Clients exceeding a quota are rejected with a JoinError containing the
+// time they have to wait, during which all their attempts are rejected. The
+// time doubles with each violation until it is reset after a quiet period.
+type ipQuotas struct {
+	config     RateLimitConfig
+	backoff    time.Duration
+	maxBackoff time.Duration
+	rooms      *keyedTokenBuckets
+
+	mu sync.Mutex
+	// key is IP address
+	connections map[string]int
+	// key is IP address
+	peerConnections map[string]int
+	// key is IP address
+	backoffs  map[string]*quotaBackoff
+	lastPrune time.Time
+}
+
+type quotaBackoff struct {
+	violations int
+	until      time.Time
+	err        *JoinError
+}
+
+func newIPQuotas(config RateLimitConfig) *ipQuotas {
+	if config.MaxIPConnections <= 0 && config.MaxIPPeerConnections <= 0 &&
+		(config.RoomCreationInterval <= 0 || config.RoomCreationBurst <= 0) {
+		return nil
+	}
+
+	q := &ipQuotas{
+		config:          config,
+		backoff:         config.Backoff,
+		maxBackoff:      config.MaxBackoff,
+		connections:     map[string]int{},
+		peerConnections: map[string]int{},
+		backoffs:        map[string]*quotaBackoff{},
+	}
+	if q.backoff <= 0 {
+		q.backoff = defaultQuotaBackoff
+	}
+	if q.maxBackoff <= 0 {
+		q.maxBackoff = defaultQuotaMaxBackoff
+	}
+	if q.maxBackoff < q.backoff {
+		q.maxBackoff = q.backoff
+	}
+	if config.RoomCreationInterval > 0 && config.RoomCreationBurst > 0 {
+		q.rooms = newKeyedTokenBuckets(config.RoomCreationInterval, config.RoomCreationBurst)
+	}
+	return q
+}
+
+// acquireConnection counts a signaling connection from ip. release must be
+// called once the connection is closed.
+func (q *ipQuotas) acquireConnection(ip string, now time.Time) (release func(), joinErr *JoinError) {
+	if q == nil {
+		return func() {}, nil
+	}
+	return q.acquire(q.connections, q.config.MaxIPConnections, ErrIPConnectionLimit, ip, now)
+}
+
+// acquirePeerConnection counts a peer connection with a client from ip.
+// release must be called once the peer connection is closed.
+func (q *ipQuotas) acquirePeerConnection(ip string, now time.Time) (release func(), joinErr *JoinError) {
+	if q == nil {
+		return func() {}, nil
+	}
+	return q.acquire(q.peerConnections, q.config.MaxIPPeerConnections, ErrIPPeerConnectionLimit, ip, now)
+}
+
+func (q *ipQuotas) acquire(counts map[string]int, max int, limitErr *JoinError, ip string, now time.Time) (func(), *JoinError) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if joinErr := q.blocked(ip, now); joinErr != nil {
+		return nil, joinErr
+	}
+	if max > 0 && counts[ip] >= max {
+		return nil, q.reject(ip, limitErr, now)
+	}
+
+	counts[ip]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			if counts[ip]--; counts[ip] <= 0 {
+				delete(counts, ip)
+			}
+		})
+	}, nil
+}
+
+// allowRoomCreation returns an error when a client from ip cannot create
+// another room.
+func (q *ipQuotas) allowRoomCreation(ip string, now time.Time) *JoinError {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if joinErr := q.blocked(ip, now); joinErr != nil {
+		return joinErr
+	}
+	if !q.rooms.allow(ip, now) {
+		return q.reject(ip, ErrRoomCreationLimit, now)
+	}
+	return nil
+}
+
+// blocked returns an error when ip must still wait after exceeding a quota.
+// It must be called with mu held.
+func (q *ipQuotas) blocked(ip string, now time.Time) *JoinError {
+	if now.Sub(q.lastPrune) >= q.maxBackoff {
+		q.prune(now)
+	}
+
+	b, ok := q.backoffs[ip]
+	if !ok || !now.Before(b.until) {
+		return nil
+	}
+	return withRetryAfter(b.err, b.until.Sub(now))
+}
+
+// reject records a violation of a quota by ip and returns limitErr with the
+// time ip has to wait. It must be called with mu held.
+func (q *ipQuotas) reject(ip string, limitErr *JoinError, now time.Time) *JoinError {
+	b, ok := q.backoffs[ip]
+	if !ok || now.Sub(b.until) >= q.maxBackoff {
+		b = &quotaBackoff{}
+		q.backoffs[ip] = b
+	}
+
+	wait := q.backoff
+	for i := 0; i < b.violations && wait < q.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > q.maxBackoff {
+		wait = q.maxBackoff
+	}
+
+	b.violations++
+	b.until = now.Add(wait)
+	b.err = limitErr
+	return withRetryAfter(limitErr, wait)
+}
+
+// prune discards backoffs which have been quiet for long enough to be reset.
+// It must be called with mu held.
+func (q *ipQuotas) prune(now time.Time) {
+	for ip, b := range q.backoffs {
+		if now.Sub(b.until) >= q.maxBackoff {
+			delete(q.backoffs, ip)
+		}
+	}
+	q.lastPrune = now
+}
+
+func withRetryAfter(joinErr *JoinError, wait time.Duration) *JoinError {
+	result := *joinErr
+	result.RetryAfter = int64((wait + time.Millisecond - 1) / time.Millisecond)
+	return &result
+}
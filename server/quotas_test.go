@@ -0,0 +1,82 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPQuotas_connections(t *testing.T) {
+	now := time.Unix(0, 0)
+	q := newIPQuotas(RateLimitConfig{
+		MaxIPConnections: 2,
+		Backoff:          time.Second,
+		MaxBackoff:       3 * time.Second,
+	})
+
+	release, joinErr := q.acquireConnection("1.1.1.1", now)
+	require.Nil(t, joinErr)
+	_, joinErr = q.acquireConnection("1.1.1.1", now)
+	require.Nil(t, joinErr)
+	_, joinErr = q.acquireConnection("2.2.2.2", now)
+	require.Nil(t, joinErr, "other IP address")
+
+	_, joinErr = q.acquireConnection("1.1.1.1", now)
+	assert.Equal(t, &JoinError{Code: "ipConnectionLimit", Message: "Too many connections", RetryAfter: 1000}, joinErr)
+
+	release()
+	release()
+	_, joinErr = q.acquireConnection("1.1.1.1", now.Add(500*time.Millisecond))
+	assert.Equal(t, int64(500), joinErr.RetryAfter, "rejected until the backoff has passed")
+
+	now = now.Add(time.Second)
+	_, joinErr = q.acquireConnection("1.1.1.1", now)
+	require.Nil(t, joinErr)
+
+	// repeated violations double the backoff up to the maximum
+	for _, retryAfter := range []int64{2000, 3000, 3000} {
+		_, joinErr = q.acquireConnection("1.1.1.1", now)
+		assert.Equal(t, retryAfter, joinErr.RetryAfter)
+		now = now.Add(time.Duration(retryAfter) * time.Millisecond)
+	}
+
+	// quiet for the maximum backoff
+	now = now.Add(3 * time.Second)
+	_, joinErr = q.acquireConnection("1.1.1.1", now)
+	assert.Equal(t, int64(1000), joinErr.RetryAfter)
+}
+
+func TestIPQuotas_roomCreation(t *testing.T) {
+	now := time.Unix(0, 0)
+	q := newIPQuotas(RateLimitConfig{
+		RoomCreationInterval: time.Minute,
+		RoomCreationBurst:    1,
+	})
+
+	assert.Nil(t, q.allowRoomCreation("1.1.1.1", now))
+	joinErr := q.allowRoomCreation("1.1.1.1", now)
+	assert.Equal(t, "roomCreationLimit", joinErr.Code)
+	assert.Equal(t, int64(1000), joinErr.RetryAfter)
+
+	release, joinErr := q.acquirePeerConnection("1.1.1.1", now)
+	assert.Equal(t, "roomCreationLimit", joinErr.Code, "blocked by the backoff")
+	assert.Nil(t, release)
+
+	release, joinErr = q.acquirePeerConnection("1.1.1.1", now.Add(time.Second))
+	assert.Nil(t, joinErr, "peer connections are not limited")
+	release()
+
+	assert.Nil(t, q.allowRoomCreation("1.1.1.1", now.Add(time.Minute)))
+}
+
+func TestIPQuotas_disabled(t *testing.T) {
+	q := newIPQuotas(RateLimitConfig{})
+	assert.Nil(t, q)
+
+	release, joinErr := q.acquireConnection("1.1.1.1", time.Now())
+	assert.Nil(t, joinErr)
+	release()
+	assert.Nil(t, q.allowRoomCreation("1.1.1.1", time.Now()))
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const MessageTypeRateLimited = "ws_rate_limited"
+
+var (
+	ErrJoinRateLimited    = &JoinError{Code: "rateLimited", Message: "Too many join attempts"}
+	ErrMessageRateLimited = errors.New("too many messages")
+)
+
+// rateLimits limits signaling of clients. Nil limiters are disabled.
+type rateLimits struct {
+	config     RateLimitConfig
+	joins      *keyedTokenBuckets
+	ipMessages *keyedTokenBuckets
+	quotas     *ipQuotas
+}
+
+// SetRateLimit sets the limits of join attempts and signaling messages, and
+// the quotas of IP addresses. It must be called before any connections are
+// handled.
+func (wss *WSS) SetRateLimit(config RateLimitConfig) {
+	limits := rateLimits{config: config, quotas: newIPQuotas(config)}
+	if config.JoinInterval > 0 && config.JoinBurst > 0 {
+		limits.joins = newKeyedTokenBuckets(config.JoinInterval, config.JoinBurst)
+	}
+	if config.IPMessageInterval > 0 && config.IPMessageBurst > 0 {
+		limits.ipMessages = newKeyedTokenBuckets(config.IPMessageInterval, config.IPMessageBurst)
+	}
+	wss.rateLimits = limits
+}
+
+// clientIP returns the IP address of the client which sent r. The
+// X-Forwarded-For header is only used when the server is behind a trusted
+// proxy, otherwise clients could choose their own address.
+//
+// The proxy appends the address it received the request from to the header,
+// so only the rightmost entry is used. Entries left of it were sent by the
+// client and may be forged.
+func clientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			forwarded := values[len(values)-1]
+			if ip := strings.TrimSpace(forwarded[strings.LastIndex(forwarded, ",")+1:]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allowJoin returns ErrJoinRateLimited when ip has made too many join
+// attempts.
+func (wss *WSS) allowJoin(ip string) *JoinError {
+	if !wss.rateLimits.joins.allow(ip, time.Now()) {
+		return ErrJoinRateLimited
+	}
+	return nil
+}
+
+// allowMessage returns false when a message received from conn should be
+// dropped because the connection, or all connections from ip, have sent too
+// many messages. The client is notified with a MessageTypeRateLimited
+// message once until it is allowed to send messages again.
+func (wss *WSS) allowMessage(conn *wsConnection, room string, ip string) bool {
+	now := time.Now()
+	allowed := conn.allowMessage(wss.rateLimits.config, now) &&
+		wss.rateLimits.ipMessages.allow(ip, now)
+
+	conn.mu.Lock()
+	notify := !allowed && !conn.rateLimited
+	conn.rateLimited = !allowed
+	conn.mu.Unlock()
+
+	if notify {
+		wss.log.Printf("[%s] Dropping messages in room: %s from ip: %s, rate limited", conn.client.ID(), room, ip)
+		err := conn.client.Write(NewMessage(MessageTypeRateLimited, room, map[string]interface{}{
+			"error": ErrMessageRateLimited.Error(),
+		}))
+		if err != nil {
+			wss.log.Printf("[%s] Error sending rate limited message: %s", conn.client.ID(), err)
+		}
+	}
+	return allowed
+}
+
+func (c *wsConnection) allowMessage(config RateLimitConfig, now time.Time) bool {
+	if config.MessageInterval <= 0 || config.MessageBurst <= 0 {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.messages == nil {
+		c.messages = newTokenBucket(config.MessageInterval, config.MessageBurst, now)
+	}
+	return c.messages.allow(now)
+}
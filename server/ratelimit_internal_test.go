@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	assert.Equal(t, "10.0.0.1", clientIP(r, true), "without header")
+
+	r.Header.Set("X-Forwarded-For", "1.2.3.4")
+	assert.Equal(t, "10.0.0.1", clientIP(r, false), "untrusted proxy")
+	assert.Equal(t, "1.2.3.4", clientIP(r, true))
+
+	r.Header.Set("X-Forwarded-For", "6.6.6.6, 1.2.3.4")
+	assert.Equal(t, "1.2.3.4", clientIP(r, true), "entries sent by the client are ignored")
+
+	r.Header.Set("X-Forwarded-For", "6.6.6.6")
+	r.Header.Add("X-Forwarded-For", "1.2.3.4")
+	assert.Equal(t, "1.2.3.4", clientIP(r, true), "the last header is appended by the proxy")
+
+	r.Header.Set("X-Forwarded-For", "6.6.6.6,")
+	assert.Equal(t, "10.0.0.1", clientIP(r, true), "empty entry")
+}
@@ -0,0 +1,120 @@
+package server_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"nhooyr.io/websocket"
+)
+
+func TestWSS_rateLimit(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	wss := server.NewWSS(loggerFactory, rooms)
+	wss.SetRateLimit(server.RateLimitConfig{
+		MessageInterval: time.Hour,
+		MessageBurst:    2,
+		JoinInterval:    time.Hour,
+		JoinBurst:       1,
+	})
+	s := httptest.NewServer(server.NewMeshHandler(loggerFactory, wss))
+	defer s.Close()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + room + "/"
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ws := mustDialWS(t, ctx, wsURL+"a")
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	mustReadRoles(t, ctx, ws)
+
+	rejected := mustDialWS(t, ctx, wsURL+"b")
+	defer rejected.Close(websocket.StatusNormalClosure, "")
+	msg, status := readJoinResult(t, ctx, rejected)
+	assert.Equal(t, server.MessageTypeJoinError, msg.Type)
+	assert.Equal(t, map[string]interface{}{
+		"code":    "rateLimited",
+		"message": "Too many join attempts",
+	}, msg.Payload)
+	assert.Equal(t, websocket.StatusTryAgainLater, status)
+
+	ready := server.NewMessage("ready", room, map[string]interface{}{
+		"nickname": "a",
+	})
+	for i := 0; i < 3; i++ {
+		mustWriteWS(t, ctx, ws, ready)
+	}
+	msg = mustReadWSType(t, ctx, ws, server.MessageTypeRateLimited)
+	assert.Equal(t, map[string]interface{}{
+		"error": "too many messages",
+	}, msg.Payload)
+}
+
+func TestWSS_ipQuotas(t *testing.T) {
+	dial := func(t *testing.T, config server.RateLimitConfig) (join func(room string, clientID string) server.Message, cleanup func()) {
+		rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+			return server.NewMemoryAdapter(room)
+		})
+		wss := server.NewWSS(loggerFactory, rooms)
+		wss.SetRateLimit(config)
+		s := httptest.NewServer(server.NewMeshHandler(loggerFactory, wss))
+		wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/"
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+		var conns []*websocket.Conn
+		join = func(room string, clientID string) server.Message {
+			ws := mustDialWS(t, ctx, wsURL+room+"/"+clientID)
+			conns = append(conns, ws)
+			msg, _ := readJoinResult(t, ctx, ws)
+			return msg
+		}
+		return join, func() {
+			for _, ws := range conns {
+				ws.Close(websocket.StatusNormalClosure, "")
+			}
+			cancel()
+			s.Close()
+		}
+	}
+
+	t.Run("connections", func(t *testing.T) {
+		join, cleanup := dial(t, server.RateLimitConfig{MaxIPConnections: 1})
+		defer cleanup()
+
+		assert.NotEqual(t, server.MessageTypeJoinError, join(room, "a").Type)
+		msg := join(room, "b")
+		assert.Equal(t, server.MessageTypeJoinError, msg.Type)
+		assert.Equal(t, map[string]interface{}{
+			"code":       "ipConnectionLimit",
+			"message":    "Too many connections",
+			"retryAfter": float64(1000),
+		}, msg.Payload)
+	})
+
+	t.Run("room creation", func(t *testing.T) {
+		join, cleanup := dial(t, server.RateLimitConfig{
+			RoomCreationInterval: time.Hour,
+			RoomCreationBurst:    1,
+			Backoff:              10 * time.Millisecond,
+		})
+		defer cleanup()
+
+		assert.NotEqual(t, server.MessageTypeJoinError, join(room, "a").Type)
+		msg := join("other-room", "b")
+		assert.Equal(t, server.MessageTypeJoinError, msg.Type)
+		assert.Equal(t, map[string]interface{}{
+			"code":       "roomCreationLimit",
+			"message":    "Too many rooms created",
+			"retryAfter": float64(10),
+		}, msg.Payload)
+
+		time.Sleep(10 * time.Millisecond)
+		assert.NotEqual(t, server.MessageTypeJoinError, join(room, "c").Type, "joining an existing room")
+	})
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"time"
+)
+
+const (
+	MessageTypeReaction  = "reaction"
+	MessageTypeHandRaise = "handRaise"
+	MessageTypeHandLower = "handLower"
+)
+
+const (
+	defaultReactionInterval = 500 * time.Millisecond
+	defaultReactionBurst    = 5
+	// maxReactionLength is the maximum length of a reaction in bytes, which
+	// allows emoji with modifiers and joiners.
+	maxReactionLength = 32
+)
+
+// SetReactions sets how often clients can send reactions and raise or lower
+// their hand. It must be called before any connections are handled.
+func (wss *WSS) SetReactions(config ReactionsConfig) {
+	wss.reactions = config
+}
+
+// handleReaction broadcasts reactions and hand raises to all clients in the
+// room, including the sender. They are not stored, so clients joining later
+// do not receive them. It returns false when message is not a reaction.
+func (wss *WSS) handleReaction(adapter Adapter, room string, clientID string, conn *wsConnection, message Message) bool {
+	payload := map[string]interface{}{
+		"userId": clientID,
+	}
+
+	switch message.Type {
+	case MessageTypeReaction:
+		data, _ := message.Payload.(map[string]interface{})
+		reaction, _ := data["reaction"].(string)
+		if reaction == "" || len(reaction) > maxReactionLength {
+			wss.log.Printf("[%s] Invalid reaction in room: %s", clientID, room)
+			return true
+		}
+		payload["reaction"] = reaction
+	case MessageTypeHandRaise, MessageTypeHandLower:
+	default:
+		return false
+	}
+
+	if !conn.allowReaction(wss.reactions) {
+		wss.log.Printf("[%s] Dropping %s in room: %s, rate limited", clientID, message.Type, room)
+		return true
+	}
+
+	if err := adapter.Broadcast(NewMessage(message.Type, room, payload)); err != nil {
+		wss.log.Printf("[%s] Error broadcasting %s: %s", clientID, message.Type, err)
+	}
+	return true
+}
+
+func (c *wsConnection) allowReaction(config ReactionsConfig) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.reactions == nil {
+		if config.Interval <= 0 {
+			config.Interval = defaultReactionInterval
+		}
+		if config.Burst <= 0 {
+			config.Burst = defaultReactionBurst
+		}
+		c.reactions = newTokenBucket(config.Interval, config.Burst, now)
+	}
+	return c.reactions.allow(now)
+}
@@ -0,0 +1,71 @@
+package server_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"nhooyr.io/websocket"
+)
+
+func TestWSS_reactions(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	wss := server.NewWSS(loggerFactory, rooms)
+	wss.SetReactions(server.ReactionsConfig{
+		Interval: time.Hour,
+		Burst:    2,
+	})
+	s := httptest.NewServer(server.NewMeshHandler(loggerFactory, wss))
+	defer s.Close()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + room + "/"
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ready := func(clientID string) server.Message {
+		return server.NewMessage("ready", room, map[string]interface{}{
+			"nickname": clientID,
+		})
+	}
+
+	wsB := mustDialWS(t, ctx, wsURL+"b")
+	defer wsB.Close(websocket.StatusNormalClosure, "")
+	mustWriteWS(t, ctx, wsB, ready("b"))
+	mustReadWSType(t, ctx, wsB, "users")
+
+	wsA := mustDialWS(t, ctx, wsURL+"a")
+	defer wsA.Close(websocket.StatusNormalClosure, "")
+	mustWriteWS(t, ctx, wsA, ready("a"))
+	mustReadWSType(t, ctx, wsB, "users")
+
+	reaction := func(reaction string) server.Message {
+		return server.NewMessage(server.MessageTypeReaction, room, map[string]interface{}{
+			"reaction": reaction,
+		})
+	}
+	mustWriteWS(t, ctx, wsA, reaction("👍"))
+	mustWriteWS(t, ctx, wsA, reaction(""))
+	mustWriteWS(t, ctx, wsA, server.NewMessage(server.MessageTypeHandRaise, room, nil))
+	// exceeds the burst and is dropped
+	mustWriteWS(t, ctx, wsA, reaction("🎉"))
+	mustWriteWS(t, ctx, wsA, ready("a"))
+
+	msg := mustReadWS(t, ctx, wsB)
+	assert.Equal(t, server.MessageTypeReaction, msg.Type)
+	assert.Equal(t, map[string]interface{}{
+		"userId":   "a",
+		"reaction": "👍",
+	}, msg.Payload)
+
+	msg = mustReadWS(t, ctx, wsB)
+	assert.Equal(t, server.MessageTypeHandRaise, msg.Type)
+	assert.Equal(t, map[string]interface{}{"userId": "a"}, msg.Payload)
+
+	assert.Equal(t, "users", mustReadWS(t, ctx, wsB).Type)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"time"
+)
+
+const (
+	// driftWindow is the period over which the minimum delay of packets is
+	// measured at the start and at the end of a track. Network jitter only
+	// increases delay, so the minimum is the best estimate of the offset
+	// between the clocks of the sender and the server.
+	driftWindow = 5 * time.Second
+	// minDriftDuration is the minimum duration of a track for which drift
+	// is estimated.
+	minDriftDuration = 30 * time.Second
+)
+
+// mediaClock compares RTP timestamps of a track to arrival times to
+// estimate how much the media clock of the sender drifts from the clock of
+// the server.
+type mediaClock struct {
+	clockRate uint32
+
+	firstAt        time.Time
+	lastAt         time.Time
+	firstTimestamp uint32
+	lastTimestamp  uint32
+	// elapsed is the number of RTP clock ticks since the first packet,
+	// accounting for timestamp wraparound
+	elapsed int64
+
+	// delays are how much later than predicted by their RTP timestamps
+	// packets arrived, relative to the first packet
+	early          packetDelay
+	late           packetDelay
+	prevLate       packetDelay
+	lateWindowFrom time.Time
+}
+
+// packetDelay is the minimum delay of packets in a window and the time the
+// packet with that delay arrived.
+type packetDelay struct {
+	delay time.Duration
+	at    time.Time
+}
+
+func (c *mediaClock) observe(timestamp uint32, now time.Time) {
+	if c.firstAt.IsZero() {
+		c.firstAt = now
+		c.lastAt = now
+		c.firstTimestamp = timestamp
+		c.lastTimestamp = timestamp
+		c.early = packetDelay{0, now}
+		c.late = c.early
+		c.prevLate = c.early
+		c.lateWindowFrom = now
+		return
+	}
+
+	c.elapsed += int64(int32(timestamp - c.lastTimestamp))
+	c.lastTimestamp = timestamp
+	c.lastAt = now
+
+	if c.clockRate == 0 {
+		return
+	}
+
+	wall := now.Sub(c.firstAt)
+	media := time.Duration(c.elapsed) * time.Second / time.Duration(c.clockRate)
+	delay := packetDelay{wall - media, now}
+
+	if wall < driftWindow && delay.delay < c.early.delay {
+		c.early = delay
+	}
+
+	if now.Sub(c.lateWindowFrom) >= driftWindow {
+		c.prevLate = c.late
+		c.late = delay
+		c.lateWindowFrom = now
+	} else if delay.delay < c.late.delay {
+		c.late = delay
+	}
+}
+
+// driftPPM returns how much faster the media clock ran than the server
+// clock, in parts per million. Returns zero when the track is shorter than
+// minDriftDuration.
+func (c *mediaClock) driftPPM() float64 {
+	if c.clockRate == 0 || c.lastAt.Sub(c.firstAt) < minDriftDuration {
+		return 0
+	}
+
+	late := c.late
+	if c.prevLate.delay < late.delay {
+		late = c.prevLate
+	}
+
+	// a fast media clock makes packets appear to arrive earlier over time
+	return float64(c.early.delay-late.delay) / float64(late.at.Sub(c.early.at)) * 1e6
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMediaClock_drift(t *testing.T) {
+	const (
+		clockRate   = 48000
+		driftPPM    = 100
+		packetTicks = 960
+	)
+
+	random := rand.New(rand.NewSource(1))
+	clock := mediaClock{clockRate: clockRate}
+	start := time.Unix(0, 0)
+	// starts close to wraparound
+	timestamp := uint32(1<<32 - 10*packetTicks)
+
+	packetDuration := 20 * time.Millisecond * 1e6 / (1e6 + driftPPM)
+	for i := 0; i < 3000; i++ {
+		jitter := time.Duration(random.Int63n(int64(30 * time.Millisecond)))
+		clock.observe(timestamp, start.Add(time.Duration(i)*packetDuration+jitter))
+		timestamp += packetTicks
+	}
+
+	assert.Equal(t, uint32(1<<32-10*packetTicks), clock.firstTimestamp)
+	assert.InDelta(t, driftPPM, clock.driftPPM(), 10)
+}
+
+func TestMediaClock_short(t *testing.T) {
+	clock := mediaClock{clockRate: 90000}
+	start := time.Unix(0, 0)
+	clock.observe(0, start)
+	clock.observe(90000*10, start.Add(9*time.Second))
+
+	assert.Equal(t, float64(0), clock.driftPPM())
+}
@@ -0,0 +1,533 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// WebhookRecordingProcessed is sent by the notify step once all other
+	// post-processing steps of a recording have succeeded.
+	WebhookRecordingProcessed = "recording.processed"
+	// WebhookRecordingProcessingFailed is sent when a post-processing step
+	// has failed after all attempts and the notify step is configured.
+	WebhookRecordingProcessingFailed = "recording.processing_failed"
+)
+
+const (
+	RecordingStepRemux     = "remux"
+	RecordingStepThumbnail = "thumbnail"
+	RecordingStepUpload    = "upload"
+	RecordingStepNotify    = "notify"
+)
+
+// recordingSteps are all post-processing steps in the order they are run.
+var recordingSteps = []string{
+	RecordingStepRemux,
+	RecordingStepThumbnail,
+	RecordingStepUpload,
+	RecordingStepNotify,
+}
+
+// recordingThumbnailFile is the name of the image written by the thumbnail
+// step to the directory of a recording.
+const recordingThumbnailFile = "thumbnail.jpg"
+
+const (
+	recordingJobQueueSize            = 64
+	defaultRecordingJobMaxAttempts   = 3
+	defaultRecordingJobRetryInterval = 5 * time.Second
+	maxRecordingJobRetryInterval     = 5 * time.Minute
+	// maxFinishedRecordingJobs is the number of finished jobs kept for
+	// status queries.
+	maxFinishedRecordingJobs = 100
+)
+
+var (
+	ErrInvalidRecordingStep  = errors.New("invalid recording post-processing step")
+	ErrRecordingJobQueueFull = errors.New("recording job queue full")
+	ErrInvalidRecordingFile  = errors.New("invalid recording file")
+)
+
+type RecordingJobStatus string
+
+const (
+	RecordingJobQueued    RecordingJobStatus = "queued"
+	RecordingJobRunning   RecordingJobStatus = "running"
+	RecordingJobSucceeded RecordingJobStatus = "succeeded"
+	RecordingJobFailed    RecordingJobStatus = "failed"
+)
+
+// RecordingJob is the post-processing status of a stopped recording.
+type RecordingJob struct {
+	// ID is the ID of the recording
+	ID     string             `json:"id"`
+	Room   string             `json:"room"`
+	Status RecordingJobStatus `json:"status"`
+	Steps  []RecordingJobStep `json:"steps"`
+	// Outputs are the names of files written to the directory of the
+	// recording by the remux and thumbnail steps.
+	Outputs []string `json:"outputs"`
+	// Keys of the files uploaded by the upload step
+	Keys      []string  `json:"keys"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type RecordingJobStep struct {
+	Name     string             `json:"name"`
+	Status   RecordingJobStatus `json:"status"`
+	Attempts int                `json:"attempts"`
+	Error    string             `json:"error,omitempty"`
+}
+
+func (j RecordingJob) clone() RecordingJob {
+	j.Steps = append([]RecordingJobStep{}, j.Steps...)
+	j.Outputs = append([]string{}, j.Outputs...)
+	j.Keys = append([]string{}, j.Keys...)
+	return j
+}
+
+type recordingJob struct {
+	RecordingJob
+	recording Recording
+}
+
+// RecordingJobs post-processes stopped recordings. The configured steps of
+// a recording are run one at a time, and recordings are processed in the
+// order they were stopped. A failed step is retried with exponential
+// backoff, and the remaining steps are skipped once it has failed after all
+// attempts. A nil RecordingJobs processes nothing.
+//
+// The remux step copies each VP8 track into a WebM file playable in
+// browsers, and the thumbnail step writes the first frame of the first video
+// track to thumbnail.jpg. Both run ffmpeg. The upload step uploads all files
+// of the recording, including the outputs of the previous steps, and the
+// notify step sends a recording.processed webhook event.
+type RecordingJobs struct {
+	log      Logger
+	config   RecordingConfig
+	steps    map[string]bool
+	uploads  *Uploads
+	webhooks *Webhooks
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu sync.Mutex
+	// key is recording ID
+	jobs map[string]*recordingJob
+	// finished are IDs of finished jobs, oldest first
+	finished []string
+
+	// queueMu guards closing queue
+	queueMu sync.RWMutex
+	closed  bool
+	queue   chan *recordingJob
+	done    chan struct{}
+}
+
+// NewRecordingJobs creates RecordingJobs which run the steps in
+// config.PostProcess. Returns nil when no steps are configured. The upload
+// step requires uploads.
+func NewRecordingJobs(loggerFactory LoggerFactory, config RecordingConfig, uploads *Uploads, webhooks *Webhooks) (*RecordingJobs, error) {
+	if len(config.PostProcess) == 0 {
+		return nil, nil
+	}
+
+	steps := map[string]bool{}
+	for _, step := range config.PostProcess {
+		step = strings.TrimSpace(step)
+		switch step {
+		case RecordingStepRemux, RecordingStepThumbnail, RecordingStepNotify:
+		case RecordingStepUpload:
+			if uploads == nil {
+				return nil, fmt.Errorf("%w: %s requires storage", ErrInvalidRecordingStep, step)
+			}
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRecordingStep, step)
+		}
+		steps[step] = true
+	}
+
+	if config.FFmpeg == "" {
+		config.FFmpeg = "ffmpeg"
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultRecordingJobMaxAttempts
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = defaultRecordingJobRetryInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &RecordingJobs{
+		log:      loggerFactory.GetLogger("recordingjobs"),
+		config:   config,
+		steps:    steps,
+		uploads:  uploads,
+		webhooks: webhooks,
+		ctx:      ctx,
+		cancel:   cancel,
+		jobs:     map[string]*recordingJob{},
+		queue:    make(chan *recordingJob, recordingJobQueueSize),
+		done:     make(chan struct{}),
+	}
+	go j.run()
+	return j, nil
+}
+
+// Process queues a stopped recording for post-processing.
+func (j *RecordingJobs) Process(rec Recording) {
+	if j == nil {
+		return
+	}
+
+	now := time.Now()
+	job := &recordingJob{
+		RecordingJob: RecordingJob{
+			ID:        rec.ID,
+			Room:      rec.Room,
+			Status:    RecordingJobQueued,
+			Steps:     []RecordingJobStep{},
+			Outputs:   []string{},
+			Keys:      []string{},
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		recording: rec,
+	}
+	for _, step := range recordingSteps {
+		if j.steps[step] {
+			job.Steps = append(job.Steps, RecordingJobStep{Name: step, Status: RecordingJobQueued})
+		}
+	}
+
+	j.mu.Lock()
+	j.jobs[rec.ID] = job
+	j.mu.Unlock()
+
+	j.queueMu.RLock()
+	defer j.queueMu.RUnlock()
+
+	if j.closed {
+		return
+	}
+
+	select {
+	case j.queue <- job:
+	default:
+		j.log.Printf("Recording job queue full, not processing recording: %s", rec.ID)
+		j.update(job, func() {
+			job.Status = RecordingJobFailed
+			for i := range job.Steps {
+				job.Steps[i].Status = RecordingJobFailed
+				job.Steps[i].Error = ErrRecordingJobQueueFull.Error()
+			}
+		})
+		j.finish(job)
+	}
+}
+
+// Job returns the status of post-processing recording id.
+func (j *RecordingJobs) Job(id string) (RecordingJob, bool) {
+	if j == nil {
+		return RecordingJob{}, false
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job, ok := j.jobs[id]
+	if !ok {
+		return RecordingJob{}, false
+	}
+	return job.clone(), true
+}
+
+// Jobs returns the status of queued, running and recently finished jobs,
+// oldest first.
+func (j *RecordingJobs) Jobs() []RecordingJob {
+	if j == nil {
+		return nil
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	jobs := make([]RecordingJob, 0, len(j.jobs))
+	for _, job := range j.jobs {
+		jobs = append(jobs, job.clone())
+	}
+	sort.Slice(jobs, func(i, k int) bool {
+		return jobs[i].CreatedAt.Before(jobs[k].CreatedAt)
+	})
+	return jobs
+}
+
+// Close cancels the step in progress and drops queued jobs.
+func (j *RecordingJobs) Close() {
+	if j == nil {
+		return
+	}
+
+	j.queueMu.Lock()
+	if !j.closed {
+		j.closed = true
+		j.cancel()
+		close(j.queue)
+	}
+	j.queueMu.Unlock()
+
+	<-j.done
+}
+
+func (j *RecordingJobs) run() {
+	defer close(j.done)
+
+	for job := range j.queue {
+		if j.ctx.Err() != nil {
+			continue
+		}
+
+		j.process(job)
+	}
+}
+
+func (j *RecordingJobs) process(job *recordingJob) {
+	j.update(job, func() {
+		job.Status = RecordingJobRunning
+	})
+
+	for i := range job.Steps {
+		if err := j.runStepWithRetry(job, i); err != nil {
+			j.log.Printf("Error processing recording: %s: %s: %s", job.ID, job.Steps[i].Name, err)
+			j.update(job, func() {
+				job.Status = RecordingJobFailed
+				job.Steps[i].Status = RecordingJobFailed
+				job.Steps[i].Error = err.Error()
+			})
+			if j.steps[RecordingStepNotify] {
+				j.webhooks.Emit(WebhookRecordingProcessingFailed, job.Room, "", j.snapshot(job))
+			}
+			j.finish(job)
+			return
+		}
+	}
+
+	j.update(job, func() {
+		job.Status = RecordingJobSucceeded
+	})
+	j.log.Printf("Processed recording: %s of room: %s", job.ID, job.Room)
+	j.finish(job)
+}
+
+func (j *RecordingJobs) runStepWithRetry(job *recordingJob, i int) error {
+	retryInterval := j.config.RetryInterval
+
+	for attempt := 1; ; attempt++ {
+		j.update(job, func() {
+			job.Steps[i].Status = RecordingJobRunning
+			job.Steps[i].Attempts = attempt
+		})
+
+		err := j.runStep(job, job.Steps[i].Name)
+		if err == nil {
+			j.update(job, func() {
+				job.Steps[i].Status = RecordingJobSucceeded
+				job.Steps[i].Error = ""
+			})
+			return nil
+		}
+
+		if attempt >= j.config.MaxAttempts || j.ctx.Err() != nil {
+			return err
+		}
+
+		j.log.Printf("Error processing recording: %s: %s, retrying in %s: %s", job.ID, job.Steps[i].Name, retryInterval, err)
+		j.update(job, func() {
+			job.Steps[i].Error = err.Error()
+		})
+
+		timer := time.NewTimer(retryInterval)
+		select {
+		case <-timer.C:
+		case <-j.ctx.Done():
+			timer.Stop()
+			return err
+		}
+
+		retryInterval *= 2
+		if retryInterval > maxRecordingJobRetryInterval {
+			retryInterval = maxRecordingJobRetryInterval
+		}
+	}
+}
+
+func (j *RecordingJobs) runStep(job *recordingJob, step string) error {
+	switch step {
+	case RecordingStepRemux:
+		return j.remux(job)
+	case RecordingStepThumbnail:
+		return j.thumbnail(job)
+	case RecordingStepUpload:
+		upload, err := j.uploads.putRecording(j.ctx, job.recording)
+		if err != nil {
+			return err
+		}
+		j.update(job, func() {
+			job.Keys = upload.Keys
+		})
+		return nil
+	case RecordingStepNotify:
+		j.webhooks.Emit(WebhookRecordingProcessed, job.Room, "", j.snapshot(job))
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidRecordingStep, step)
+	}
+}
+
+// remux copies the VP8 tracks of a recording from IVF into WebM files.
+func (j *RecordingJobs) remux(job *recordingJob) error {
+	manifest, err := readRecordingManifest(job.recording)
+	if err != nil {
+		return err
+	}
+
+	for _, track := range manifest.Tracks {
+		if filepath.Ext(track.File) != ".ivf" {
+			continue
+		}
+		output := strings.TrimSuffix(track.File, ".ivf") + ".webm"
+		if err := j.ffmpeg(job, "-i", track.File, "-c", "copy", output); err != nil {
+			return err
+		}
+		j.addOutput(job, output)
+	}
+	return nil
+}
+
+// thumbnail writes the first frame of the first video track of a recording
+// to thumbnail.jpg. Recordings without video tracks have no thumbnail.
+func (j *RecordingJobs) thumbnail(job *recordingJob) error {
+	manifest, err := readRecordingManifest(job.recording)
+	if err != nil {
+		return err
+	}
+
+	for _, track := range manifest.Tracks {
+		if track.Kind != "video" {
+			continue
+		}
+		if err := j.ffmpeg(job, "-i", track.File, "-frames:v", "1", recordingThumbnailFile); err != nil {
+			return err
+		}
+		j.addOutput(job, recordingThumbnailFile)
+		return nil
+	}
+	return nil
+}
+
+// ffmpeg runs ffmpeg in the directory of a recording, overwriting existing
+// output files.
+func (j *RecordingJobs) ffmpeg(job *recordingJob, args ...string) error {
+	args = append([]string{"-y", "-nostdin", "-loglevel", "error"}, args...)
+	cmd := exec.CommandContext(j.ctx, j.config.FFmpeg, args...)
+	cmd.Dir = job.recording.Dir
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return fmt.Errorf("%s: %s", err, message)
+		}
+		return err
+	}
+	return nil
+}
+
+func readRecordingManifest(rec Recording) (RecordingManifest, error) {
+	var manifest RecordingManifest
+
+	data, err := ioutil.ReadFile(filepath.Join(rec.Dir, recordingManifestFile))
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, err
+	}
+
+	// files are passed to ffmpeg and must stay in the recording directory
+	for _, track := range manifest.Tracks {
+		if track.File != filepath.Base(track.File) || strings.HasPrefix(track.File, "-") {
+			return manifest, fmt.Errorf("%w: %q", ErrInvalidRecordingFile, track.File)
+		}
+	}
+	return manifest, nil
+}
+
+func (j *RecordingJobs) addOutput(job *recordingJob, output string) {
+	j.update(job, func() {
+		for _, existing := range job.Outputs {
+			if existing == output {
+				return
+			}
+		}
+		job.Outputs = append(job.Outputs, output)
+	})
+}
+
+// update changes job with mu held.
+func (j *RecordingJobs) update(job *recordingJob, fn func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn()
+	job.UpdatedAt = time.Now()
+}
+
+func (j *RecordingJobs) snapshot(job *recordingJob) RecordingJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return job.clone()
+}
+
+// finish records that job has finished and forgets the oldest finished
+// jobs.
+func (j *RecordingJobs) finish(job *recordingJob) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.finished = append(j.finished, job.ID)
+	for len(j.finished) > maxFinishedRecordingJobs {
+		id := j.finished[0]
+		j.finished = j.finished[1:]
+		if existing, ok := j.jobs[id]; ok && isRecordingJobFinished(existing.Status) {
+			delete(j.jobs, id)
+		}
+	}
+}
+
+func isRecordingJobFinished(status RecordingJobStatus) bool {
+	return status == RecordingJobSucceeded || status == RecordingJobFailed
+}
+
+// SetRecordingJobs sets the RecordingJobs whose status is served by the
+// admin API.
+func (wss *WSS) SetRecordingJobs(jobs *RecordingJobs) {
+	wss.recordingJobs = jobs
+}
+
+// RecordingJobs returns the RecordingJobs set with SetRecordingJobs.
+func (wss *WSS) RecordingJobs() *RecordingJobs {
+	return wss.recordingJobs
+}
@@ -0,0 +1,248 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testFFmpeg creates the output file, which is the last argument. It fails
+// the first failures times it is run.
+const testFFmpeg = `#!/bin/sh
+count=$(cat "$0.count" 2>/dev/null || echo 0)
+echo $((count + 1)) > "$0.count"
+if [ "$count" -lt "%d" ]; then
+	echo "ffmpeg failed" >&2
+	exit 1
+fi
+for output; do :; done
+echo "$@" > "$output"
+`
+
+func newTestRecording(t *testing.T, ffmpegFailures int) (server.Recording, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "recordingjobs")
+	require.NoError(t, err)
+
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	require.NoError(t, ioutil.WriteFile(ffmpeg, []byte(fmt.Sprintf(testFFmpeg, ffmpegFailures)), 0755))
+
+	recDir := filepath.Join(dir, "rec1")
+	require.NoError(t, os.Mkdir(recDir, 0755))
+	manifest, err := json.Marshal(server.RecordingManifest{
+		Room: "room",
+		Tracks: []server.RecordedTrack{
+			{ClientID: "a", Kind: "audio", File: "a_audio.ogg"},
+			{ClientID: "a", Kind: "video", File: "a_video.ivf"},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(recDir, "manifest.json"), manifest, 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(recDir, "a_audio.ogg"), []byte("ogg"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(recDir, "a_video.ivf"), []byte("ivf"), 0644))
+
+	return server.Recording{ID: "rec1", Room: "room", Dir: recDir}, ffmpeg
+}
+
+func waitRecordingJob(t *testing.T, jobs *server.RecordingJobs, id string) server.RecordingJob {
+	t.Helper()
+	var job server.RecordingJob
+	require.Eventually(t, func() bool {
+		job, _ = jobs.Job(id)
+		return job.Status == server.RecordingJobSucceeded || job.Status == server.RecordingJobFailed
+	}, timeout, 5*time.Millisecond)
+	return job
+}
+
+func adminGetJSON(t *testing.T, url string, value interface{}) int {
+	t.Helper()
+	return adminJSONRequest(t, "GET", url, value)
+}
+
+func adminPostJSON(t *testing.T, url string, value interface{}) int {
+	t.Helper()
+	return adminJSONRequest(t, "POST", url, value)
+}
+
+func adminJSONRequest(t *testing.T, method string, url string, value interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.NoError(t, json.NewDecoder(res.Body).Decode(value))
+	return res.StatusCode
+}
+
+func TestNewRecordingJobs(t *testing.T) {
+	jobs, err := server.NewRecordingJobs(loggerFactory, server.RecordingConfig{}, nil, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, jobs)
+
+	_, err = server.NewRecordingJobs(loggerFactory, server.RecordingConfig{PostProcess: []string{"transcode"}}, nil, nil)
+	assert.True(t, errors.Is(err, server.ErrInvalidRecordingStep))
+	_, err = server.NewRecordingJobs(loggerFactory, server.RecordingConfig{PostProcess: []string{"upload"}}, nil, nil)
+	assert.True(t, errors.Is(err, server.ErrInvalidRecordingStep), "upload requires storage")
+}
+
+func TestRecordingJobs(t *testing.T) {
+	rec, ffmpeg := newTestRecording(t, 1)
+	defer os.RemoveAll(filepath.Dir(ffmpeg))
+
+	s, requests := newWebhookServer(t)
+	defer s.Close()
+	webhooks := newTestWebhooks(s.URL)
+	defer webhooks.Close()
+
+	store := &testBlobStore{}
+	uploads := server.NewUploads(loggerFactory, store, server.StorageConfig{})
+	defer uploads.Close()
+
+	jobs, err := server.NewRecordingJobs(loggerFactory, server.RecordingConfig{
+		// steps run in the order they are listed in
+		PostProcess:   []string{"notify", "upload", "thumbnail", "remux"},
+		FFmpeg:        ffmpeg,
+		RetryInterval: time.Millisecond,
+	}, uploads, webhooks)
+	require.NoError(t, err)
+	defer jobs.Close()
+
+	jobs.Process(rec)
+	job := waitRecordingJob(t, jobs, rec.ID)
+
+	assert.Equal(t, server.RecordingJobSucceeded, job.Status)
+	assert.Equal(t, []server.RecordingJobStep{
+		{Name: "remux", Status: server.RecordingJobSucceeded, Attempts: 2},
+		{Name: "thumbnail", Status: server.RecordingJobSucceeded, Attempts: 1},
+		{Name: "upload", Status: server.RecordingJobSucceeded, Attempts: 1},
+		{Name: "notify", Status: server.RecordingJobSucceeded, Attempts: 1},
+	}, job.Steps)
+	assert.Equal(t, []string{"a_video.webm", "thumbnail.jpg"}, job.Outputs)
+	assert.Equal(t, []string{
+		"recordings/rec1/a_audio.ogg",
+		"recordings/rec1/a_video.ivf",
+		"recordings/rec1/a_video.webm",
+		"recordings/rec1/manifest.json",
+		"recordings/rec1/thumbnail.jpg",
+	}, job.Keys)
+
+	store.mu.Lock()
+	assert.Equal(t, "-y -nostdin -loglevel error -i a_video.ivf -c copy a_video.webm\n", store.blobs["recordings/rec1/a_video.webm"])
+	store.mu.Unlock()
+
+	req := receiveWebhook(t, requests)
+	assert.Equal(t, server.WebhookRecordingProcessed, req.event.Type)
+	assert.Equal(t, "room", req.event.Room)
+
+	assert.Len(t, jobs.Jobs(), 1)
+}
+
+func TestRecordingJobs_failed(t *testing.T) {
+	rec, ffmpeg := newTestRecording(t, 5)
+	defer os.RemoveAll(filepath.Dir(ffmpeg))
+
+	s, requests := newWebhookServer(t)
+	defer s.Close()
+	webhooks := newTestWebhooks(s.URL)
+	defer webhooks.Close()
+
+	jobs, err := server.NewRecordingJobs(loggerFactory, server.RecordingConfig{
+		PostProcess:   []string{"thumbnail", "notify"},
+		FFmpeg:        ffmpeg,
+		MaxAttempts:   2,
+		RetryInterval: time.Millisecond,
+	}, nil, webhooks)
+	require.NoError(t, err)
+	defer jobs.Close()
+
+	jobs.Process(rec)
+	job := waitRecordingJob(t, jobs, rec.ID)
+
+	assert.Equal(t, server.RecordingJobFailed, job.Status)
+	assert.Equal(t, []server.RecordingJobStep{
+		{Name: "thumbnail", Status: server.RecordingJobFailed, Attempts: 2, Error: "exit status 1: ffmpeg failed"},
+		{Name: "notify", Status: server.RecordingJobQueued},
+	}, job.Steps)
+
+	req := receiveWebhook(t, requests)
+	assert.Equal(t, server.WebhookRecordingProcessingFailed, req.event.Type)
+}
+
+func TestRecordingJobs_invalidFile(t *testing.T) {
+	rec, ffmpeg := newTestRecording(t, 0)
+	defer os.RemoveAll(filepath.Dir(ffmpeg))
+
+	manifest, err := json.Marshal(server.RecordingManifest{
+		Room: "room",
+		Tracks: []server.RecordedTrack{
+			{ClientID: "../../x", Kind: "video", File: "../../x_video.ivf"},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(rec.Dir, "manifest.json"), manifest, 0644))
+
+	jobs, err := server.NewRecordingJobs(loggerFactory, server.RecordingConfig{
+		PostProcess: []string{"remux"},
+		FFmpeg:      ffmpeg,
+		MaxAttempts: 1,
+	}, nil, nil)
+	require.NoError(t, err)
+	defer jobs.Close()
+
+	jobs.Process(rec)
+	job := waitRecordingJob(t, jobs, rec.ID)
+
+	assert.Equal(t, server.RecordingJobFailed, job.Status)
+	require.Len(t, job.Steps, 1)
+	assert.Contains(t, job.Steps[0].Error, server.ErrInvalidRecordingFile.Error())
+	_, err = os.Stat(ffmpeg + ".count")
+	assert.True(t, os.IsNotExist(err), "ffmpeg should not run")
+}
+
+func TestRecordingJobs_admin(t *testing.T) {
+	rec, ffmpeg := newTestRecording(t, 0)
+	defer os.RemoveAll(filepath.Dir(ffmpeg))
+
+	s, _ := setupAdminServer(t)
+	defer s.Close()
+
+	statusCode, _ := adminRequest(t, "GET", s.URL+"/api/admin/recordings/jobs", adminToken)
+	assert.Equal(t, http.StatusNotFound, statusCode, "disabled")
+
+	jobs, err := server.NewRecordingJobs(loggerFactory, server.RecordingConfig{
+		PostProcess: []string{"remux"},
+		FFmpeg:      ffmpeg,
+	}, nil, nil)
+	require.NoError(t, err)
+	defer jobs.Close()
+	s.Config.Handler.(*server.Mux).WSS.SetRecordingJobs(jobs)
+
+	jobs.Process(rec)
+	waitRecordingJob(t, jobs, rec.ID)
+
+	var list []server.RecordingJob
+	statusCode = adminGetJSON(t, s.URL+"/api/admin/recordings/jobs", &list)
+	assert.Equal(t, http.StatusOK, statusCode)
+	require.Len(t, list, 1)
+	assert.Equal(t, rec.ID, list[0].ID)
+
+	var job server.RecordingJob
+	statusCode = adminGetJSON(t, s.URL+"/api/admin/recordings/"+rec.ID+"/job", &job)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, server.RecordingJobSucceeded, job.Status)
+
+	statusCode, _ = adminRequest(t, "GET", s.URL+"/api/admin/recordings/missing/job", adminToken)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+)
+
+// RecordingManifest describes the tracks recorded in a room and who was
+// speaking when, so that post-processing and transcription services can
+// attribute speech without analyzing the audio again. Offsets are in
+// milliseconds since StartedAt. RoomRecorder writes a manifest next to the
+// track files so that editors can align all tracks on a common timeline.
+type RecordingManifest struct {
+	Room      string           `json:"room"`
+	StartedAt time.Time        `json:"startedAt"`
+	Tracks    []RecordedTrack  `json:"tracks"`
+	Speakers  []SpeakerSegment `json:"speakers"`
+}
+
+type RecordedTrack struct {
+	ClientID string `json:"clientId"`
+	TrackID  string `json:"trackId"`
+	Kind     string `json:"kind"`
+	StartMs  int64  `json:"startMs"`
+
+	// The following fields are only set by RoomRecorder.
+
+	// EndMs is the offset of the last recorded packet.
+	EndMs int64 `json:"endMs,omitempty"`
+	// File is the name of the recorded file, relative to the manifest.
+	File      string `json:"file,omitempty"`
+	Codec     string `json:"codec,omitempty"`
+	ClockRate uint32 `json:"clockRate,omitempty"`
+	Channels  uint16 `json:"channels,omitempty"`
+	// FirstTimestamp is the RTP timestamp of the first recorded packet.
+	FirstTimestamp uint32 `json:"firstTimestamp,omitempty"`
+	// DriftPPM is how much faster the media clock of the sender ran than the
+	// clock of the server, in parts per million. Position p of the file is
+	// at StartMs + p / (1 + DriftPPM / 1e6) on the common timeline. It is
+	// zero when the track was too short to measure drift.
+	DriftPPM float64 `json:"driftPpm,omitempty"`
+}
+
+// SpeakerSegment is a time range during which a single peer was speaking.
+type SpeakerSegment struct {
+	ClientID string `json:"clientId"`
+	TrackID  string `json:"trackId"`
+	StartMs  int64  `json:"startMs"`
+	EndMs    int64  `json:"endMs"`
+}
+
+// RecordingManifestFactory creates interceptors which build a
+// RecordingManifest for each room, for recorders other than RoomRecorder,
+// which writes its own manifest. It skips end-to-end encrypted tracks the
+// same way. Speech is detected the same way as ActivityDetectorFactory does.
+type RecordingManifestFactory struct {
+	mu    sync.Mutex
+	rooms map[string]*recordingManifestState
+}
+
+type recordingManifestState struct {
+	// manifest.Speakers is only set by Manifest
+	manifest RecordingManifest
+	speakers *speakerTimeline
+}
+
+// speakerTimeline collects the segments during which audio tracks carried
+// speech. Speech separated by less than speakingTimeout is one segment.
+type speakerTimeline struct {
+	startedAt time.Time
+	segments  []SpeakerSegment
+	// value is index of the last segment of a track in segments
+	lastSegment map[speakerKey]int
+	lastSpeech  map[speakerKey]time.Time
+}
+
+type speakerKey struct {
+	clientID string
+	trackID  string
+}
+
+func newSpeakerTimeline(startedAt time.Time) *speakerTimeline {
+	return &speakerTimeline{
+		startedAt:   startedAt,
+		segments:    []SpeakerSegment{},
+		lastSegment: map[speakerKey]int{},
+		lastSpeech:  map[speakerKey]time.Time{},
+	}
+}
+
+func (s *speakerTimeline) markSpeech(clientID string, trackID string, now time.Time) {
+	key := speakerKey{clientID, trackID}
+	offset := offsetMs(s.startedAt, now)
+
+	index, ok := s.lastSegment[key]
+	if ok && now.Sub(s.lastSpeech[key]) <= speakingTimeout {
+		s.segments[index].EndMs = offset
+	} else {
+		s.lastSegment[key] = len(s.segments)
+		s.segments = append(s.segments, SpeakerSegment{
+			ClientID: clientID,
+			TrackID:  trackID,
+			StartMs:  offset,
+			EndMs:    offset,
+		})
+	}
+	s.lastSpeech[key] = now
+}
+
+// Segments returns a copy of the segments.
+func (s *speakerTimeline) Segments() []SpeakerSegment {
+	return append([]SpeakerSegment{}, s.segments...)
+}
+
+func NewRecordingManifestFactory() *RecordingManifestFactory {
+	return &RecordingManifestFactory{
+		rooms: map[string]*recordingManifestState{},
+	}
+}
+
+func (f *RecordingManifestFactory) NewInterceptor(params InterceptorParams) (Interceptor, error) {
+	if params.Encrypted {
+		return NoOpInterceptor{}, nil
+	}
+
+	trackID := params.LocalTrack.ID()
+	kind := params.LocalTrack.Kind()
+
+	f.mu.Lock()
+	state := f.roomState(params.Room, time.Now())
+	state.manifest.Tracks = append(state.manifest.Tracks, RecordedTrack{
+		ClientID: params.ClientID,
+		TrackID:  trackID,
+		Kind:     kind.String(),
+		StartMs:  offsetMs(state.manifest.StartedAt, time.Now()),
+	})
+	f.mu.Unlock()
+
+	if kind != webrtc.RTPCodecTypeAudio {
+		return NoOpInterceptor{}, nil
+	}
+
+	return &speakerDiarizer{
+		factory:  f,
+		room:     params.Room,
+		clientID: params.ClientID,
+		trackID:  trackID,
+	}, nil
+}
+
+// Manifest returns a copy of the manifest of room.
+func (f *RecordingManifestFactory) Manifest(room string) (RecordingManifest, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	state, ok := f.rooms[room]
+	if !ok {
+		return RecordingManifest{}, false
+	}
+
+	manifest := state.manifest
+	manifest.Tracks = append([]RecordedTrack(nil), manifest.Tracks...)
+	manifest.Speakers = state.speakers.Segments()
+	return manifest, true
+}
+
+// Finish returns the manifest of room and forgets it, so that the next
+// recording in the same room starts with a new manifest.
+func (f *RecordingManifestFactory) Finish(room string) (RecordingManifest, bool) {
+	manifest, ok := f.Manifest(room)
+
+	f.mu.Lock()
+	delete(f.rooms, room)
+	f.mu.Unlock()
+
+	return manifest, ok
+}
+
+// roomState must be called with f.mu held.
+func (f *RecordingManifestFactory) roomState(room string, now time.Time) *recordingManifestState {
+	state, ok := f.rooms[room]
+	if !ok {
+		state = &recordingManifestState{
+			manifest: RecordingManifest{
+				Room:      room,
+				StartedAt: now,
+				Tracks:    []RecordedTrack{},
+			},
+			speakers: newSpeakerTimeline(now),
+		}
+		f.rooms[room] = state
+	}
+	return state
+}
+
+func (f *RecordingManifestFactory) markSpeech(room string, clientID string, trackID string, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.roomState(room, now).speakers.markSpeech(clientID, trackID, now)
+}
+
+func offsetMs(start time.Time, now time.Time) int64 {
+	return int64(now.Sub(start) / time.Millisecond)
+}
+
+type speakerDiarizer struct {
+	NoOpInterceptor
+
+	factory  *RecordingManifestFactory
+	room     string
+	clientID string
+	trackID  string
+}
+
+func (s *speakerDiarizer) BindRTP(next RTPWriter) RTPWriter {
+	return RTPWriterFunc(func(packet *rtp.Packet) error {
+		if isSpeech(packet) {
+			s.factory.markSpeech(s.room, s.clientID, s.trackID, time.Now())
+		}
+		return next.WriteRTP(packet)
+	})
+}
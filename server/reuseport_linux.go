@@ -0,0 +1,8 @@
+//go:build linux && !mips && !mipsle && !mips64 && !mips64le
+// +build linux,!mips,!mipsle,!mips64,!mips64le
+
+package server
+
+// soReusePort is SO_REUSEPORT, which package syscall does not define on all
+// architectures.
+const soReusePort = 0xf
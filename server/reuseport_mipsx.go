@@ -0,0 +1,8 @@
+//go:build (linux && mips) || (linux && mipsle) || (linux && mips64) || (linux && mips64le)
+// +build linux,mips linux,mipsle linux,mips64 linux,mips64le
+
+package server
+
+// soReusePort is SO_REUSEPORT, which package syscall does not define on all
+// architectures.
+const soReusePort = 0x200
@@ -0,0 +1,348 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Role of a client in a meeting.
+type Role string
+
+const (
+	RoleHost   Role = "host"
+	RoleCohost Role = "cohost"
+	RoleGuest  Role = "guest"
+	// RoleViewer is a guest which only watches the meeting, and can be
+	// exempt from the inactivity policy.
+	RoleViewer Role = "viewer"
+)
+
+// Permission to perform a moderation action.
+type Permission string
+
+const (
+	PermissionMute    Permission = "mute"
+	PermissionKick    Permission = "kick"
+	PermissionRecord  Permission = "record"
+	PermissionLock    Permission = "lock"
+	PermissionSetRole Permission = "setRole"
+)
+
+const (
+	MessageTypeRoles           = "roles"
+	MessageTypeSetRole         = "setRole"
+	MessageTypeKickPeer        = "kickPeer"
+	MessageTypeMutePeer        = "mutePeer"
+	MessageTypeStartRecording  = "startRecording"
+	MessageTypeStopRecording   = "stopRecording"
+	MessageTypeModeration      = "moderation"
+	MessageTypeModerationError = "moderationError"
+)
+
+var (
+	ErrInvalidRole      = errors.New("invalid role")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrClientNotFound   = errors.New("client not found")
+	ErrNotSupported     = errors.New("not supported")
+)
+
+// rank orders roles by their permissions.
+func (r Role) rank() int {
+	switch r {
+	case RoleHost:
+		return 2
+	case RoleCohost:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Valid returns true for known roles.
+func (r Role) Valid() bool {
+	return r == RoleHost || r == RoleCohost || r == RoleGuest || r == RoleViewer
+}
+
+// Can returns true when clients with role r are allowed to perform actions
+// requiring permission. Hosts can do everything, cohosts everything except
+// changing roles, and guests and viewers nothing.
+func (r Role) Can(permission Permission) bool {
+	switch r {
+	case RoleHost:
+		return true
+	case RoleCohost:
+		return permission != PermissionSetRole
+	default:
+		return false
+	}
+}
+
+// PeerMuter stops forwarding audio of clients.
+type PeerMuter interface {
+	SetMuted(clientID string, muted bool) error
+}
+
+// RecordingController starts and stops recordings of rooms.
+type RecordingController interface {
+	Start(room string) (Recording, error)
+	Stop(room string) (Recording, bool)
+	Recording(room string) (Recording, bool)
+}
+
+// SetModeration sets how mute and recording actions of hosts and cohosts
+// are performed. It must be called before any connections are handled.
+func (wss *WSS) SetModeration(muter PeerMuter, recorder RecordingController) {
+	wss.muter = muter
+	wss.recorder = recorder
+}
+
+// Role returns the role of clientID in the current meeting in room.
+func (wss *WSS) Role(room string, clientID string) (Role, bool) {
+	wss.connectionsMu.Lock()
+	defer wss.connectionsMu.Unlock()
+
+	l, ok := wss.lifecycles[room]
+	if !ok {
+		return "", false
+	}
+	role, ok := l.roles[clientID]
+	return role, ok
+}
+
+// SetRole changes the role of clientID, which must be connected to room on
+// this instance, and sends the new roles to all clients in the room.
+func (wss *WSS) SetRole(room string, clientID string, role Role) error {
+	if !role.Valid() {
+		return fmt.Errorf("%w: %q", ErrInvalidRole, role)
+	}
+
+	wss.connectionsMu.Lock()
+	l, ok := wss.lifecycles[room]
+	conn, connected := wss.connections[room][clientID]
+	if !ok || !connected || conn.hidden {
+		wss.connectionsMu.Unlock()
+		return ErrClientNotFound
+	}
+	wss.log.Printf("Setting role of clientID: %s in room: %s to: %s", clientID, room, role)
+	l.roles[clientID] = role
+	wss.connectionsMu.Unlock()
+
+	wss.sendRoles(room)
+	return nil
+}
+
+// Authorize returns true when clientID is allowed to perform an action
+// requiring permission in room.
+func (wss *WSS) Authorize(room string, clientID string, permission Permission) bool {
+	role, _ := wss.Role(room, clientID)
+	return role.Can(permission)
+}
+
+// assignRole makes clientID the host when no host is connected to room,
+// otherwise it keeps the role from a previous connection during the same
+// meeting, or becomes a guest. It must be called with connectionsMu held,
+// after the connection has been added.
+func (wss *WSS) assignRole(room string, clientID string) {
+	l := wss.lifecycles[room]
+
+	if wss.connectedHost(room, l, clientID) == "" {
+		l.roles[clientID] = RoleHost
+		return
+	}
+	if _, ok := l.roles[clientID]; !ok {
+		l.roles[clientID] = RoleGuest
+	}
+}
+
+// connectedHost returns the clientID of a host connected to room, other than
+// exceptClientID. It must be called with connectionsMu held.
+func (wss *WSS) connectedHost(room string, l *roomLifecycle, exceptClientID string) string {
+	for clientID, conn := range wss.connections[room] {
+		if clientID != exceptClientID && !conn.hidden && l.roles[clientID] == RoleHost {
+			return clientID
+		}
+	}
+	return ""
+}
+
+// promoteHost makes the earliest connected client the host when the last
+// connected host has left room. Returns false when no client was promoted.
+// It must be called with connectionsMu held, after the connection has been
+// removed.
+func (wss *WSS) promoteHost(room string) bool {
+	l, ok := wss.lifecycles[room]
+	if !ok || wss.connectedHost(room, l, "") != "" {
+		return false
+	}
+
+	var (
+		promotedID string
+		promoted   *wsConnection
+	)
+	for clientID, conn := range wss.connections[room] {
+		if conn.hidden {
+			continue
+		}
+		if promoted == nil || conn.connectedAt.Before(promoted.connectedAt) {
+			promotedID, promoted = clientID, conn
+		}
+	}
+	if promoted == nil {
+		return false
+	}
+
+	wss.log.Printf("Promoting clientID: %s to host of room: %s", promotedID, room)
+	l.roles[promotedID] = RoleHost
+	return true
+}
+
+// sendRoles sends roles of clients connected to room to all of them.
+func (wss *WSS) sendRoles(room string) {
+	wss.connectionsMu.Lock()
+	l, ok := wss.lifecycles[room]
+	if !ok {
+		wss.connectionsMu.Unlock()
+		return
+	}
+	roles := map[string]Role{}
+	conns := make([]*wsConnection, 0, len(wss.connections[room]))
+	for clientID, conn := range wss.connections[room] {
+		if role, ok := l.roles[clientID]; ok && !conn.hidden {
+			roles[clientID] = role
+		}
+		conns = append(conns, conn)
+	}
+	wss.connectionsMu.Unlock()
+
+	msg := NewMessage(MessageTypeRoles, room, map[string]interface{}{
+		"roles": roles,
+	})
+	for _, conn := range conns {
+		if err := conn.client.Write(msg); err != nil {
+			wss.log.Printf("Error sending roles to clientID: %s: %s", conn.client.ID(), err)
+		}
+	}
+}
+
+// handleModeration performs moderation actions of hosts and cohosts. Other
+// clients in the room are notified with a moderation message, and the
+// sender with a moderationError message when the action failed. It returns
+// false when message is not a moderation action.
+func (wss *WSS) handleModeration(adapter Adapter, room string, clientID string, message Message) bool {
+	var permission Permission
+	switch message.Type {
+	case MessageTypeSetRole:
+		permission = PermissionSetRole
+	case MessageTypeKickPeer:
+		permission = PermissionKick
+	case MessageTypeMutePeer:
+		permission = PermissionMute
+	case MessageTypeStartRecording, MessageTypeStopRecording:
+		permission = PermissionRecord
+	case MessageTypeLockRoom, MessageTypeUnlockRoom:
+		permission = PermissionLock
+	default:
+		return false
+	}
+
+	payload, _ := message.Payload.(map[string]interface{})
+	targetID, _ := payload["userId"].(string)
+
+	result := map[string]interface{}{
+		"action": message.Type,
+		"userId": clientID,
+	}
+
+	err := wss.moderate(room, clientID, permission, targetID, message.Type, payload, result)
+	if err != nil {
+		wss.log.Printf("[%s] Moderation action: %s in room: %s failed: %s", clientID, message.Type, room, err)
+		errMsg := NewMessage(MessageTypeModerationError, room, map[string]interface{}{
+			"action": message.Type,
+			"error":  err.Error(),
+		})
+		if err := adapter.Emit(clientID, errMsg); err != nil {
+			wss.log.Printf("[%s] Error sending moderation error: %s", clientID, err)
+		}
+		return true
+	}
+
+	wss.log.Printf("[%s] Moderation action: %s in room: %s, target: %s", clientID, message.Type, room, targetID)
+	if err := adapter.Broadcast(NewMessage(MessageTypeModeration, room, result)); err != nil {
+		wss.log.Printf("[%s] Error broadcasting moderation action: %s", clientID, err)
+	}
+	return true
+}
+
+func (wss *WSS) moderate(
+	room string,
+	clientID string,
+	permission Permission,
+	targetID string,
+	action string,
+	payload map[string]interface{},
+	result map[string]interface{},
+) error {
+	role, _ := wss.Role(room, clientID)
+	if !role.Can(permission) {
+		return ErrPermissionDenied
+	}
+
+	switch action {
+	case MessageTypeLockRoom:
+		return wss.LockRoom(room)
+	case MessageTypeUnlockRoom:
+		return wss.UnlockRoom(room)
+	}
+
+	if action == MessageTypeStartRecording || action == MessageTypeStopRecording {
+		if wss.recorder == nil {
+			return ErrNotSupported
+		}
+		if action == MessageTypeStopRecording {
+			if _, ok := wss.recorder.Stop(room); !ok {
+				return ErrRecordingNotFound
+			}
+			return nil
+		}
+		if !wss.RoomFeatures(room).Recording {
+			return ErrRecordingNotAllowed
+		}
+		_, err := wss.recorder.Start(room)
+		return err
+	}
+
+	if targetID == clientID {
+		return fmt.Errorf("%w: cannot moderate self", ErrPermissionDenied)
+	}
+	targetRole, ok := wss.Role(room, targetID)
+	if !ok {
+		return ErrClientNotFound
+	}
+	if targetRole.rank() > role.rank() {
+		return ErrPermissionDenied
+	}
+	result["targetId"] = targetID
+
+	switch action {
+	case MessageTypeSetRole:
+		newRole, _ := payload["role"].(string)
+		result["role"] = newRole
+		return wss.SetRole(room, targetID, Role(newRole))
+	case MessageTypeKickPeer:
+		if !wss.kick(room, targetID, clientID) {
+			return ErrClientNotFound
+		}
+		return nil
+	default:
+		if wss.muter == nil {
+			return ErrNotSupported
+		}
+		muted, _ := payload["muted"].(bool)
+		result["muted"] = muted
+		if err := wss.muter.SetMuted(targetID, muted); err != nil {
+			return ErrClientNotFound
+		}
+		wss.eventLog.emitMuted(room, targetID, clientID, muted)
+		return nil
+	}
+}
@@ -0,0 +1,147 @@
+package server_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+type mockMuter struct {
+	muted chan string
+}
+
+func (m *mockMuter) SetMuted(clientID string, muted bool) error {
+	m.muted <- clientID
+	return nil
+}
+
+func TestRole_Can(t *testing.T) {
+	assert.True(t, server.RoleHost.Can(server.PermissionSetRole))
+	assert.True(t, server.RoleCohost.Can(server.PermissionKick))
+	assert.True(t, server.RoleCohost.Can(server.PermissionLock))
+	assert.False(t, server.RoleCohost.Can(server.PermissionSetRole))
+	assert.False(t, server.RoleGuest.Can(server.PermissionMute))
+	assert.False(t, server.Role("").Can(server.PermissionMute))
+}
+
+func mustReadRoles(t *testing.T, ctx context.Context, ws *websocket.Conn) map[string]interface{} {
+	t.Helper()
+	msg := mustReadWSType(t, ctx, ws, server.MessageTypeRoles)
+	payload, _ := msg.Payload.(map[string]interface{})
+	roles, _ := payload["roles"].(map[string]interface{})
+	return roles
+}
+
+func TestWSS_moderation(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	wss := server.NewWSS(loggerFactory, rooms)
+	muter := &mockMuter{muted: make(chan string, 1)}
+	wss.SetModeration(muter, nil)
+	eventLog := server.NewEventLog(loggerFactory, server.NewMemoryEventLogStore(), 100)
+	wss.SetEventLog(eventLog)
+	s := httptest.NewServer(server.NewMeshHandler(loggerFactory, wss))
+	defer s.Close()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + room + "/"
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	wsA := mustDialWS(t, ctx, wsURL+"a")
+	defer wsA.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, map[string]interface{}{"a": "host"}, mustReadRoles(t, ctx, wsA))
+
+	wsB := mustDialWS(t, ctx, wsURL+"b")
+	defer wsB.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, map[string]interface{}{"a": "host", "b": "guest"}, mustReadRoles(t, ctx, wsB))
+
+	wsC := mustDialWS(t, ctx, wsURL+"c")
+	defer wsC.Close(websocket.StatusNormalClosure, "")
+	mustReadRoles(t, ctx, wsC)
+
+	role, ok := wss.Role(room, "c")
+	assert.True(t, ok)
+	assert.Equal(t, server.RoleGuest, role)
+
+	moderationError := func(ws *websocket.Conn) string {
+		msg := mustReadWSType(t, ctx, ws, server.MessageTypeModerationError)
+		payload, _ := msg.Payload.(map[string]interface{})
+		errorMessage, _ := payload["error"].(string)
+		return errorMessage
+	}
+
+	// guests cannot moderate
+	mustWriteWS(t, ctx, wsB, server.NewMessage(server.MessageTypeKickPeer, room, map[string]interface{}{
+		"userId": "a",
+	}))
+	assert.Equal(t, "permission denied", moderationError(wsB))
+
+	mustWriteWS(t, ctx, wsA, server.NewMessage(server.MessageTypeSetRole, room, map[string]interface{}{
+		"userId": "b",
+		"role":   "cohost",
+	}))
+	msg := mustReadWSType(t, ctx, wsC, server.MessageTypeModeration)
+	assert.Equal(t, map[string]interface{}{
+		"action":   server.MessageTypeSetRole,
+		"userId":   "a",
+		"targetId": "b",
+		"role":     "cohost",
+	}, msg.Payload)
+	assert.Equal(t, map[string]interface{}{"a": "host", "b": "cohost", "c": "guest"}, mustReadRoles(t, ctx, wsB))
+
+	mustWriteWS(t, ctx, wsB, server.NewMessage(server.MessageTypeMutePeer, room, map[string]interface{}{
+		"userId": "c",
+		"muted":  true,
+	}))
+	assert.Equal(t, "c", <-muter.muted)
+
+	// cohosts cannot moderate hosts
+	mustWriteWS(t, ctx, wsB, server.NewMessage(server.MessageTypeKickPeer, room, map[string]interface{}{
+		"userId": "a",
+	}))
+	assert.Equal(t, "permission denied", moderationError(wsB))
+
+	mustWriteWS(t, ctx, wsB, server.NewMessage(server.MessageTypeStartRecording, room, nil))
+	assert.Equal(t, "not supported", moderationError(wsB))
+
+	// the earliest connected client becomes the host when the host leaves
+	wsA.Close(websocket.StatusNormalClosure, "")
+	roles := mustReadRoles(t, ctx, wsC)
+	for roles["a"] != nil {
+		roles = mustReadRoles(t, ctx, wsC)
+	}
+	assert.Equal(t, map[string]interface{}{"b": "host", "c": "guest"}, roles)
+
+	mustWriteWS(t, ctx, wsB, server.NewMessage(server.MessageTypeKickPeer, room, map[string]interface{}{
+		"userId": "c",
+	}))
+	for {
+		if _, _, err := wsC.Read(ctx); err != nil {
+			require.NoError(t, ctx.Err(), "websocket should be closed before timeout")
+			break
+		}
+	}
+
+	eventLog.Close()
+	page, err := eventLog.Events(room, 0, 0)
+	require.NoError(t, err)
+	var moderated []server.EventLogEntry
+	for _, event := range page.Events {
+		if event.ActorID != "" {
+			event.Seq, event.Time = 0, time.Time{}
+			moderated = append(moderated, event)
+		}
+	}
+	assert.Equal(t, []server.EventLogEntry{
+		{Type: server.EventLogMute, Room: room, ClientID: "c", ActorID: "b"},
+		{Type: server.EventLogKick, Room: room, ClientID: "c", ActorID: "b"},
+	}, moderated)
+}
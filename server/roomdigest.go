@@ -0,0 +1,176 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const MessageTypeRoomDigest = "roomDigest"
+
+// RoomDigest is a compact summary of a room on this instance, for clients
+// which only need to track the state of rooms, like status bots or wall
+// displays.
+type RoomDigest struct {
+	Room string `json:"room"`
+	// MeetingID is empty when there is no meeting in room on this instance
+	MeetingID string    `json:"meetingId,omitempty"`
+	Time      time.Time `json:"time"`
+	// Participants is the number of clients connected to the room, including
+	// SFU peers whose websocket connection is no longer open
+	Participants int `json:"participants"`
+	// ActiveSpeakers are clientIDs of peers currently speaking, most recent
+	// speaker first
+	ActiveSpeakers []string `json:"activeSpeakers"`
+	// Bitrate is the total bitrate of all tracks published to the room in
+	// bits per second
+	Bitrate   uint64 `json:"bitrate"`
+	Recording bool   `json:"recording"`
+}
+
+// RoomDigestProvider is the part of TracksManager used by room digests.
+type RoomDigestProvider interface {
+	RoomStateProvider
+	LastActive(clientID string) time.Time
+}
+
+// RoomDigests creates digests of rooms on this instance and broadcasts them
+// to clients in the room at a fixed interval.
+type RoomDigests struct {
+	log    Logger
+	wss    *WSS
+	tracks RoomDigestProvider
+
+	recorderMu sync.Mutex
+	recorder   *RoomRecorder
+
+	closeChannel chan struct{}
+	closeOnce    sync.Once
+	done         chan struct{}
+}
+
+func NewRoomDigests(loggerFactory LoggerFactory, wss *WSS, tracks RoomDigestProvider) *RoomDigests {
+	return &RoomDigests{
+		log:          loggerFactory.GetLogger("digest"),
+		wss:          wss,
+		tracks:       tracks,
+		closeChannel: make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+// SetRecorder sets the recorder the recording state of rooms is read from.
+func (d *RoomDigests) SetRecorder(recorder *RoomRecorder) {
+	d.recorderMu.Lock()
+	defer d.recorderMu.Unlock()
+	d.recorder = recorder
+}
+
+// Digest returns the digest of room. Returns false when there are no
+// clients in room.
+func (d *RoomDigests) Digest(room string) (RoomDigest, bool) {
+	now := time.Now()
+	digest := RoomDigest{
+		Room:           room,
+		Time:           now,
+		ActiveSpeakers: []string{},
+	}
+
+	digest.MeetingID, _ = d.wss.MeetingID(room)
+	participants := d.wss.LocalClients(room)
+
+	type speaker struct {
+		clientID   string
+		lastActive time.Time
+	}
+	var speakers []speaker
+	for _, p := range d.tracks.RoomPeers(room) {
+		if d.wss.isHidden(room, p.ClientID) {
+			continue
+		}
+		participants[p.ClientID] = p.JoinedAt
+		if lastActive := d.tracks.LastActive(p.ClientID); now.Sub(lastActive) < speakingTimeout {
+			speakers = append(speakers, speaker{p.ClientID, lastActive})
+		}
+	}
+	if len(participants) == 0 {
+		return RoomDigest{}, false
+	}
+	digest.Participants = len(participants)
+
+	sort.Slice(speakers, func(i, j int) bool {
+		return speakers[i].lastActive.After(speakers[j].lastActive)
+	})
+	for _, s := range speakers {
+		digest.ActiveSpeakers = append(digest.ActiveSpeakers, s.clientID)
+	}
+
+	for _, track := range d.tracks.RoomTracks(room) {
+		digest.Bitrate += track.Bitrate
+	}
+
+	d.recorderMu.Lock()
+	recorder := d.recorder
+	d.recorderMu.Unlock()
+	if recorder != nil {
+		_, digest.Recording = recorder.Recording(room)
+	}
+
+	return digest, true
+}
+
+// Start broadcasts the digest of each room to its clients on this instance
+// every interval, until Close is called.
+func (d *RoomDigests) Start(interval time.Duration) {
+	go d.run(interval)
+}
+
+// Close stops broadcasting digests. It must only be called after Start.
+func (d *RoomDigests) Close() {
+	d.closeOnce.Do(func() {
+		close(d.closeChannel)
+	})
+	<-d.done
+}
+
+func (d *RoomDigests) run(interval time.Duration) {
+	defer close(d.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.broadcast()
+		case <-d.closeChannel:
+			return
+		}
+	}
+}
+
+func (d *RoomDigests) broadcast() {
+	for _, room := range d.wss.LocalRooms() {
+		digest, ok := d.Digest(room)
+		if !ok {
+			continue
+		}
+		d.wss.writeLocal(room, NewMessage(MessageTypeRoomDigest, room, digest))
+	}
+}
+
+// writeLocal writes msg to all clients in room connected to this instance.
+func (wss *WSS) writeLocal(room string, msg Message) {
+	wss.connectionsMu.Lock()
+	conns := make([]*wsConnection, 0, len(wss.connections[room]))
+	for _, conn := range wss.connections[room] {
+		conns = append(conns, conn)
+	}
+	wss.connectionsMu.Unlock()
+
+	for _, conn := range conns {
+		if err := conn.client.Write(msg); err != nil {
+			wss.log.Printf("Error sending %s to clientID: %s: %s", msg.Type, conn.client.ID(), err)
+		}
+	}
+}
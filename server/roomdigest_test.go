@@ -0,0 +1,65 @@
+package server_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestRoomDigests_API(t *testing.T) {
+	s, wsURL := setupAdminServer(t)
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ws := mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	mustReadWS(t, ctx, ws)
+
+	var digest server.RoomDigest
+	require.Equal(t, http.StatusOK, roomStateRequest(t, s.URL+"/api/rooms/"+roomName+"/digest", adminToken, &digest))
+	assert.Equal(t, roomName, digest.Room)
+	// the mock SFU peer zombie and the websocket client
+	assert.Equal(t, 2, digest.Participants)
+	assert.Equal(t, []string{"zombie"}, digest.ActiveSpeakers)
+	assert.Equal(t, uint64(32000), digest.Bitrate)
+	assert.False(t, digest.Recording)
+
+	assert.Equal(t, http.StatusNotFound, roomStateRequest(t, s.URL+"/api/rooms/missing/digest", adminToken, &digest))
+}
+
+func TestRoomDigests_broadcast(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, server.AdminConfig{}, server.InactivityConfig{}, server.ClientConfig{}, rooms, newMockTracksManager())
+	mux.Digests.Start(10 * time.Millisecond)
+	defer mux.Digests.Close()
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ws := mustDialWS(t, ctx, "ws"+strings.TrimPrefix(s.URL, "http")+"/ws/"+roomName+"/"+clientID)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+
+	for {
+		msg := mustReadWS(t, ctx, ws)
+		if msg.Type != server.MessageTypeRoomDigest {
+			continue
+		}
+		assert.Equal(t, roomName, msg.Room)
+		digest := msg.Payload.(map[string]interface{})
+		assert.Equal(t, float64(2), digest["participants"])
+		assert.Equal(t, []interface{}{"zombie"}, digest["activeSpeakers"])
+		assert.Equal(t, float64(32000), digest["bitrate"])
+		return
+	}
+}
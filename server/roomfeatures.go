@@ -0,0 +1,52 @@
+package server
+
+import (
+	"errors"
+)
+
+const MessageTypeRoomFeatures = "roomFeatures"
+
+var ErrRecordingNotAllowed = errors.New("recording is not allowed in room")
+
+// RoomFeatures are sent to clients when they join a room. Chat and Recording
+// are enforced by the server, E2EE and the maximum video resolution are
+// applied by clients.
+type RoomFeatures struct {
+	Chat      bool `json:"chat"`
+	Recording bool `json:"recording"`
+	// E2EE requires clients to encrypt their media end-to-end.
+	E2EE bool `json:"e2ee"`
+	// MaxVideoWidth and MaxVideoHeight limit the resolution of published
+	// video. Zero means unlimited.
+	MaxVideoWidth  int `json:"maxVideoWidth"`
+	MaxVideoHeight int `json:"maxVideoHeight"`
+}
+
+// RoomFeatures returns the features of rooms without features of their own.
+func (c RoomFeaturesConfig) RoomFeatures() RoomFeatures {
+	return RoomFeatures{
+		Chat:           !c.DisableChat,
+		Recording:      !c.DisableRecording,
+		E2EE:           c.RequireE2EE,
+		MaxVideoWidth:  c.MaxVideoWidth,
+		MaxVideoHeight: c.MaxVideoHeight,
+	}
+}
+
+// SetRoomFeatures sets the features of rooms which are not registered with
+// features of their own. It must be called before any connections are
+// handled.
+func (wss *WSS) SetRoomFeatures(config RoomFeaturesConfig) {
+	wss.roomFeatures = config.RoomFeatures()
+}
+
+// RoomFeatures returns the features of room.
+func (wss *WSS) RoomFeatures(room string) RoomFeatures {
+	return wss.registry.features(room, wss.roomFeatures)
+}
+
+func (wss *WSS) sendRoomFeatures(client *Client, room string) error {
+	return client.Write(NewMessage(MessageTypeRoomFeatures, room, map[string]interface{}{
+		"features": wss.RoomFeatures(room),
+	}))
+}
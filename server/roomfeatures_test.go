@@ -0,0 +1,103 @@
+package server_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+type mockRecorder struct{}
+
+func (mockRecorder) Start(room string) (server.Recording, error) {
+	return server.Recording{}, nil
+}
+
+func (mockRecorder) Stop(room string) (server.Recording, bool) {
+	return server.Recording{}, true
+}
+
+func (mockRecorder) Recording(room string) (server.Recording, bool) {
+	return server.Recording{}, false
+}
+
+func TestRoomFeaturesConfig_RoomFeatures(t *testing.T) {
+	assert.Equal(t, server.RoomFeatures{
+		Chat:      true,
+		Recording: true,
+	}, server.RoomFeaturesConfig{}.RoomFeatures())
+
+	assert.Equal(t, server.RoomFeatures{
+		E2EE:           true,
+		MaxVideoWidth:  1280,
+		MaxVideoHeight: 720,
+	}, server.RoomFeaturesConfig{
+		DisableChat:      true,
+		DisableRecording: true,
+		RequireE2EE:      true,
+		MaxVideoWidth:    1280,
+		MaxVideoHeight:   720,
+	}.RoomFeatures())
+}
+
+func TestWSS_roomFeatures(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	wss := server.NewWSS(loggerFactory, rooms)
+	wss.SetRoomFeatures(server.RoomFeaturesConfig{MaxVideoHeight: 1080})
+	wss.SetModeration(nil, mockRecorder{})
+	_, err := wss.RoomRegistry().Save(server.RegisteredRoom{
+		Name: "restricted",
+		Features: &server.RoomFeatures{
+			Chat:           true,
+			E2EE:           true,
+			MaxVideoHeight: 720,
+		},
+	})
+	require.NoError(t, err)
+	s := httptest.NewServer(server.NewMeshHandler(loggerFactory, wss))
+	defer s.Close()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/"
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	features := func(ws *websocket.Conn) interface{} {
+		msg := mustReadWSType(t, ctx, ws, server.MessageTypeRoomFeatures)
+		payload, _ := msg.Payload.(map[string]interface{})
+		return payload["features"]
+	}
+
+	ws := mustDialWS(t, ctx, wsURL+room+"/a")
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, map[string]interface{}{
+		"chat":           true,
+		"recording":      true,
+		"e2ee":           false,
+		"maxVideoWidth":  float64(0),
+		"maxVideoHeight": float64(1080),
+	}, features(ws))
+
+	ws = mustDialWS(t, ctx, wsURL+"restricted/a")
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, map[string]interface{}{
+		"chat":           true,
+		"recording":      false,
+		"e2ee":           true,
+		"maxVideoWidth":  float64(0),
+		"maxVideoHeight": float64(720),
+	}, features(ws))
+
+	mustWriteWS(t, ctx, ws, server.NewMessage(server.MessageTypeStartRecording, "restricted", nil))
+	msg := mustReadWSType(t, ctx, ws, server.MessageTypeModerationError)
+	assert.Equal(t, map[string]interface{}{
+		"action": server.MessageTypeStartRecording,
+		"error":  "recording is not allowed in room",
+	}, msg.Payload)
+}
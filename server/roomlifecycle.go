@@ -0,0 +1,237 @@
+package server
+
+import (
+	"time"
+)
+
+const MessageTypeRoomEndingWarning = "roomEndingWarning"
+
+const (
+	// RoomCloseReasonIdle means the room was empty for longer than the idle
+	// timeout.
+	RoomCloseReasonIdle = "idle"
+	// RoomCloseReasonMaxDuration means the room was closed because it reached
+	// the maximum meeting duration.
+	RoomCloseReasonMaxDuration = "maxDuration"
+)
+
+// RoomCreated is the data of a room.created event.
+type RoomCreated struct {
+	// MeetingID identifies the meeting, so that state of a previous meeting
+	// in a room with the same name is not mixed up with the current one.
+	MeetingID string `json:"meetingId"`
+}
+
+// RoomClosed is the data of a room.closed event.
+type RoomClosed struct {
+	MeetingID string    `json:"meetingId"`
+	StartedAt time.Time `json:"startedAt"`
+	ClosedAt  time.Time `json:"closedAt"`
+	// Duration in seconds, including the idle timeout
+	Duration float64 `json:"duration"`
+	// PeakClients is the largest number of clients connected at the same time
+	PeakClients int    `json:"peakClients"`
+	Reason      string `json:"reason"`
+}
+
+// RoomEnding is the data of a room.ending event.
+type RoomEnding struct {
+	MeetingID string `json:"meetingId"`
+	// EndsIn is the number of seconds until the room is closed
+	EndsIn float64 `json:"endsIn"`
+}
+
+// roomLifecycle tracks a meeting on this instance, from the first client
+// connecting to the room until it is closed. All fields are guarded by
+// wss.connectionsMu.
+type roomLifecycle struct {
+	meetingID   string
+	startedAt   time.Time
+	peakClients int
+	// roles of clients which have joined the meeting. Key is clientID.
+	roles map[string]Role
+	// locked prevents clients which have not joined the meeting from joining
+	locked bool
+	// positions of clients in the room. Key is clientID.
+	positions map[string]Position
+
+	// idleTimer is set while the room is empty
+	idleTimer    *time.Timer
+	warningTimer *time.Timer
+	endTimer     *time.Timer
+}
+
+func (l *roomLifecycle) stopTimers() {
+	for _, timer := range []*time.Timer{l.idleTimer, l.warningTimer, l.endTimer} {
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// SetRoomLifecycle sets the idle timeout and maximum duration of rooms. It
+// must be called before any connections are handled.
+func (wss *WSS) SetRoomLifecycle(config RoomLifecycleConfig) {
+	wss.lifecycle = config
+}
+
+// SetRoomClosed sets the function called after a meeting has ended, for
+// example to stop a recording so that it does not continue into the next
+// meeting with the same room name. It is called in a separate goroutine and
+// must be set before any connections are handled.
+func (wss *WSS) SetRoomClosed(onClosed func(room string, closed RoomClosed)) {
+	wss.onRoomClosed = onClosed
+}
+
+// MeetingID returns the ID of the meeting in room. Returns false when there
+// is no meeting in room on this instance.
+func (wss *WSS) MeetingID(room string) (string, bool) {
+	wss.connectionsMu.Lock()
+	defer wss.connectionsMu.Unlock()
+
+	l, ok := wss.lifecycles[room]
+	if !ok {
+		return "", false
+	}
+	return l.meetingID, true
+}
+
+// enterRoom starts a new meeting in room or, when the room is within its
+// idle timeout, continues the current one. Returns the ID of the meeting. It
+// must be called with connectionsMu held, after the connection has been
+// added.
+func (wss *WSS) enterRoom(room string) string {
+	clients := wss.visibleConnections(room)
+
+	if l, ok := wss.lifecycles[room]; ok {
+		if l.idleTimer != nil {
+			l.idleTimer.Stop()
+			l.idleTimer = nil
+		}
+		if clients > l.peakClients {
+			l.peakClients = clients
+		}
+		return l.meetingID
+	}
+
+	l := &roomLifecycle{
+		meetingID:   NewUUIDBase62(),
+		startedAt:   time.Now(),
+		peakClients: clients,
+		roles:       map[string]Role{},
+	}
+	wss.lifecycles[room] = l
+	wss.webhooks.Emit(WebhookRoomCreated, room, "", RoomCreated{
+		MeetingID: l.meetingID,
+	})
+
+	config := wss.lifecycle
+	if config.MaxDuration <= 0 {
+		return l.meetingID
+	}
+
+	l.endTimer = time.AfterFunc(config.MaxDuration, func() {
+		wss.endRoom(room, l)
+	})
+	if config.Warning > 0 && config.Warning < config.MaxDuration {
+		l.warningTimer = time.AfterFunc(config.MaxDuration-config.Warning, func() {
+			wss.warnRoom(room, l, config.Warning)
+		})
+	}
+	return l.meetingID
+}
+
+// leaveRoom schedules closing of an empty room after the idle timeout. It
+// must be called with connectionsMu held, after the last connection has
+// been removed.
+func (wss *WSS) leaveRoom(room string) {
+	l, ok := wss.lifecycles[room]
+	if !ok {
+		// already closed because of max duration
+		return
+	}
+
+	if wss.lifecycle.IdleTimeout <= 0 || wss.lifecycle.Reuse == RoomReuseFresh {
+		wss.closeRoom(room, l, RoomCloseReasonIdle)
+		return
+	}
+
+	l.idleTimer = time.AfterFunc(wss.lifecycle.IdleTimeout, func() {
+		wss.connectionsMu.Lock()
+		defer wss.connectionsMu.Unlock()
+
+		if wss.lifecycles[room] == l && len(wss.connections[room]) == 0 {
+			wss.closeRoom(room, l, RoomCloseReasonIdle)
+		}
+	})
+}
+
+// closeRoom must be called with connectionsMu held.
+func (wss *WSS) closeRoom(room string, l *roomLifecycle, reason string) {
+	l.stopTimers()
+	delete(wss.lifecycles, room)
+
+	closedAt := time.Now()
+	wss.log.Printf("Closing room: %s, meetingID: %s, reason: %s, duration: %s", room, l.meetingID, reason, closedAt.Sub(l.startedAt))
+	closed := RoomClosed{
+		MeetingID:   l.meetingID,
+		StartedAt:   l.startedAt,
+		ClosedAt:    closedAt,
+		Duration:    closedAt.Sub(l.startedAt).Seconds(),
+		PeakClients: l.peakClients,
+		Reason:      reason,
+	}
+	wss.webhooks.Emit(WebhookRoomClosed, room, "", closed)
+	if wss.onRoomClosed != nil {
+		go wss.onRoomClosed(room, closed)
+	}
+}
+
+// warnRoom tells all clients in room that the meeting is about to end.
+func (wss *WSS) warnRoom(room string, l *roomLifecycle, endsIn time.Duration) {
+	wss.connectionsMu.Lock()
+	if wss.lifecycles[room] != l {
+		wss.connectionsMu.Unlock()
+		return
+	}
+	conns := make([]*wsConnection, 0, len(wss.connections[room]))
+	for _, conn := range wss.connections[room] {
+		conns = append(conns, conn)
+	}
+	wss.connectionsMu.Unlock()
+
+	wss.log.Printf("Warning room: %s ends in: %s", room, endsIn)
+	wss.webhooks.Emit(WebhookRoomEnding, room, "", RoomEnding{
+		MeetingID: l.meetingID,
+		EndsIn:    endsIn.Seconds(),
+	})
+
+	msg := NewMessage(MessageTypeRoomEndingWarning, room, map[string]interface{}{
+		"endsIn": endsIn.Seconds(),
+	})
+	for _, conn := range conns {
+		if err := conn.client.Write(msg); err != nil {
+			wss.log.Printf("Error sending room ending warning to clientID: %s: %s", conn.client.ID(), err)
+		}
+	}
+}
+
+// endRoom closes room and disconnects all of its clients once it reaches
+// the maximum duration. Clients joining afterwards start a new meeting.
+func (wss *WSS) endRoom(room string, l *roomLifecycle) {
+	wss.connectionsMu.Lock()
+	if wss.lifecycles[room] != l {
+		wss.connectionsMu.Unlock()
+		return
+	}
+	wss.closeRoom(room, l, RoomCloseReasonMaxDuration)
+	conns := make([]*wsConnection, 0, len(wss.connections[room]))
+	for _, conn := range wss.connections[room] {
+		conns = append(conns, conn)
+	}
+	wss.connectionsMu.Unlock()
+
+	for _, conn := range conns {
+		conn.cancel()
+	}
+}
@@ -0,0 +1,165 @@
+package server_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func newLifecycleServer(t *testing.T, lifecycle server.RoomLifecycleConfig) (*httptest.Server, <-chan webhookRequest, func()) {
+	hooks, requests := newWebhookServer(t)
+	webhooks := newTestWebhooks(hooks.URL)
+
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, server.AdminConfig{}, server.InactivityConfig{}, server.ClientConfig{}, rooms, newMockTracksManager())
+	mux.WSS.SetWebhooks(webhooks)
+	mux.WSS.SetRoomLifecycle(lifecycle)
+	s := httptest.NewServer(mux)
+
+	return s, requests, func() {
+		s.Close()
+		webhooks.Close()
+		hooks.Close()
+	}
+}
+
+// receiveWebhookTypes returns the types of the next n webhooks.
+func receiveWebhookTypes(t *testing.T, requests <-chan webhookRequest, n int) []string {
+	types := make([]string, n)
+	for i := range types {
+		types[i] = receiveWebhook(t, requests).event.Type
+	}
+	return types
+}
+
+// readMeetingID reads the join ack and returns its meeting ID.
+func readMeetingID(t *testing.T, ctx context.Context, ws *websocket.Conn) string {
+	msg := mustReadWS(t, ctx, ws)
+	require.Equal(t, server.MessageTypeJoinAck, msg.Type)
+	payload, _ := msg.Payload.(map[string]interface{})
+	meetingID, _ := payload["meetingID"].(string)
+	require.NotEmpty(t, meetingID)
+	return meetingID
+}
+
+func TestRoomLifecycle_idleTimeout(t *testing.T) {
+	s, requests, cleanup := newLifecycleServer(t, server.RoomLifecycleConfig{
+		IdleTimeout: 300 * time.Millisecond,
+	})
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + roomName + "/" + clientID
+
+	ws := mustDialWS(t, ctx, wsURL)
+	meetingID := readMeetingID(t, ctx, ws)
+	created := receiveWebhook(t, requests)
+	assert.Equal(t, server.WebhookRoomCreated, created.event.Type)
+	data, _ := created.event.Data.(map[string]interface{})
+	assert.Equal(t, meetingID, data["meetingId"])
+	assert.Equal(t, []string{server.WebhookPeerJoined}, receiveWebhookTypes(t, requests, 1))
+	ws.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, []string{server.WebhookPeerLeft}, receiveWebhookTypes(t, requests, 1))
+
+	// rejoining within the idle timeout continues the meeting
+	ws = mustDialWS(t, ctx, wsURL)
+	assert.Equal(t, meetingID, readMeetingID(t, ctx, ws))
+	assert.Equal(t, []string{server.WebhookPeerJoined}, receiveWebhookTypes(t, requests, 1))
+	ws.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, []string{server.WebhookPeerLeft}, receiveWebhookTypes(t, requests, 1))
+
+	closed := receiveWebhook(t, requests)
+	assert.Equal(t, server.WebhookRoomClosed, closed.event.Type)
+	assert.Equal(t, roomName, closed.event.Room)
+	data, _ = closed.event.Data.(map[string]interface{})
+	assert.Equal(t, meetingID, data["meetingId"])
+	assert.Equal(t, server.RoomCloseReasonIdle, data["reason"])
+	assert.Equal(t, float64(1), data["peakClients"])
+	assert.GreaterOrEqual(t, data["duration"], 0.3)
+}
+
+func TestRoomLifecycle_maxDuration(t *testing.T) {
+	s, requests, cleanup := newLifecycleServer(t, server.RoomLifecycleConfig{
+		MaxDuration: 500 * time.Millisecond,
+		Warning:     300 * time.Millisecond,
+	})
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + roomName + "/" + clientID
+	ws := mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+
+	warned := false
+	for {
+		_, data, err := ws.Read(ctx)
+		if err != nil {
+			require.NoError(t, ctx.Err(), "websocket should be closed before timeout")
+			break
+		}
+		message, err := serializer.Deserialize(data)
+		require.NoError(t, err)
+		if message.Type == server.MessageTypeRoomEndingWarning {
+			warned = true
+		}
+	}
+	assert.True(t, warned, "expected a warning before the room ends")
+
+	assert.Equal(t, []string{
+		server.WebhookRoomCreated,
+		server.WebhookPeerJoined,
+		server.WebhookRoomEnding,
+	}, receiveWebhookTypes(t, requests, 3))
+
+	closed := receiveWebhook(t, requests)
+	assert.Equal(t, server.WebhookRoomClosed, closed.event.Type)
+	data, _ := closed.event.Data.(map[string]interface{})
+	assert.Equal(t, server.RoomCloseReasonMaxDuration, data["reason"])
+
+	assert.Equal(t, []string{server.WebhookPeerLeft}, receiveWebhookTypes(t, requests, 1))
+}
+
+func TestRoomLifecycle_reuseFresh(t *testing.T) {
+	s, requests, cleanup := newLifecycleServer(t, server.RoomLifecycleConfig{
+		IdleTimeout: time.Hour,
+		Reuse:       server.RoomReuseFresh,
+	})
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + roomName + "/" + clientID
+
+	ws := mustDialWS(t, ctx, wsURL)
+	meetingID := readMeetingID(t, ctx, ws)
+	assert.Equal(t, []string{
+		server.WebhookRoomCreated,
+		server.WebhookPeerJoined,
+	}, receiveWebhookTypes(t, requests, 2))
+	ws.Close(websocket.StatusNormalClosure, "")
+
+	// the room is closed as soon as it is empty, regardless of the idle timeout
+	assert.Equal(t, []string{
+		server.WebhookPeerLeft,
+		server.WebhookRoomClosed,
+	}, receiveWebhookTypes(t, requests, 2))
+
+	ws = mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	assert.NotEqual(t, meetingID, readMeetingID(t, ctx, ws))
+	assert.Equal(t, []string{
+		server.WebhookRoomCreated,
+		server.WebhookPeerJoined,
+	}, receiveWebhookTypes(t, requests, 2))
+}
@@ -0,0 +1,72 @@
+package server
+
+const (
+	MessageTypeLockRoom   = "lockRoom"
+	MessageTypeUnlockRoom = "unlockRoom"
+	MessageTypeRoomLock   = "roomLock"
+)
+
+var ErrRoomLocked = &JoinError{Code: "roomLocked", Message: "Room is locked"}
+
+// LockRoom prevents new clients from joining the current meeting in room on
+// this instance, even when they are otherwise allowed to. Clients that have
+// already joined the meeting can still reconnect. The lock is released by
+// UnlockRoom or when the meeting ends. Returns ErrRoomNotFound when there is
+// no meeting in room.
+func (wss *WSS) LockRoom(room string) error {
+	return wss.setRoomLocked(room, true)
+}
+
+// UnlockRoom allows new clients to join room again.
+func (wss *WSS) UnlockRoom(room string) error {
+	return wss.setRoomLocked(room, false)
+}
+
+// RoomLocked returns true when the current meeting in room is locked.
+func (wss *WSS) RoomLocked(room string) bool {
+	wss.connectionsMu.Lock()
+	defer wss.connectionsMu.Unlock()
+
+	l, ok := wss.lifecycles[room]
+	return ok && l.locked
+}
+
+func (wss *WSS) setRoomLocked(room string, locked bool) error {
+	wss.connectionsMu.Lock()
+	l, ok := wss.lifecycles[room]
+	if !ok {
+		wss.connectionsMu.Unlock()
+		return ErrRoomNotFound
+	}
+	wss.log.Printf("Setting room: %s locked: %t", room, locked)
+	l.locked = locked
+	conns := make([]*wsConnection, 0, len(wss.connections[room]))
+	for _, conn := range wss.connections[room] {
+		conns = append(conns, conn)
+	}
+	wss.connectionsMu.Unlock()
+
+	msg := NewMessage(MessageTypeRoomLock, room, map[string]interface{}{
+		"locked": locked,
+	})
+	for _, conn := range conns {
+		if err := conn.client.Write(msg); err != nil {
+			wss.log.Printf("Error sending room lock to clientID: %s: %s", conn.client.ID(), err)
+		}
+	}
+	return nil
+}
+
+// checkRoomLocked returns ErrRoomLocked when room is locked and clientID has
+// not joined the current meeting before. It must be called with
+// connectionsMu held.
+func (wss *WSS) checkRoomLocked(room string, clientID string) *JoinError {
+	l, ok := wss.lifecycles[room]
+	if !ok || !l.locked {
+		return nil
+	}
+	if _, joined := l.roles[clientID]; joined {
+		return nil
+	}
+	return ErrRoomLocked
+}
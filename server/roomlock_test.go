@@ -0,0 +1,70 @@
+package server_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"nhooyr.io/websocket"
+)
+
+func TestWSS_roomLock(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	wss := server.NewWSS(loggerFactory, rooms)
+	s := httptest.NewServer(server.NewMeshHandler(loggerFactory, wss))
+	defer s.Close()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + room + "/"
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	assert.Equal(t, server.ErrRoomNotFound, wss.LockRoom(room))
+
+	wsA := mustDialWS(t, ctx, wsURL+"a")
+	defer wsA.Close(websocket.StatusNormalClosure, "")
+	mustReadRoles(t, ctx, wsA)
+
+	wsB := mustDialWS(t, ctx, wsURL+"b")
+	mustReadRoles(t, ctx, wsB)
+
+	mustWriteWS(t, ctx, wsA, server.NewMessage(server.MessageTypeLockRoom, room, nil))
+	msg := mustReadWSType(t, ctx, wsB, server.MessageTypeRoomLock)
+	assert.Equal(t, map[string]interface{}{"locked": true}, msg.Payload)
+	assert.True(t, wss.RoomLocked(room))
+
+	wsC := mustDialWS(t, ctx, wsURL+"c")
+	defer wsC.Close(websocket.StatusNormalClosure, "")
+	msg, status := readJoinResult(t, ctx, wsC)
+	assert.Equal(t, server.MessageTypeJoinError, msg.Type)
+	assert.Equal(t, map[string]interface{}{
+		"code":    "roomLocked",
+		"message": "Room is locked",
+	}, msg.Payload)
+	assert.Equal(t, websocket.StatusTryAgainLater, status)
+
+	// clients which have joined the meeting can reconnect
+	wsB.Close(websocket.StatusNormalClosure, "")
+	wsB = mustDialWS(t, ctx, wsURL+"b")
+	defer wsB.Close(websocket.StatusNormalClosure, "")
+	mustReadRoles(t, ctx, wsB)
+
+	// guests cannot unlock the room
+	mustWriteWS(t, ctx, wsB, server.NewMessage(server.MessageTypeUnlockRoom, room, nil))
+	msg = mustReadWSType(t, ctx, wsB, server.MessageTypeModerationError)
+	assert.Equal(t, map[string]interface{}{
+		"action": server.MessageTypeUnlockRoom,
+		"error":  "permission denied",
+	}, msg.Payload)
+
+	assert.NoError(t, wss.UnlockRoom(room))
+	assert.False(t, wss.RoomLocked(room))
+
+	wsC = mustDialWS(t, ctx, wsURL+"c")
+	defer wsC.Close(websocket.StatusNormalClosure, "")
+	mustReadRoles(t, ctx, wsC)
+}
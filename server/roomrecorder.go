@@ -0,0 +1,420 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+	"github.com/pion/webrtc/v2/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v2/pkg/media/oggwriter"
+)
+
+var (
+	ErrRecordingDisabled = errors.New("recording is not configured")
+	ErrRecordingStarted  = errors.New("recording already started")
+	ErrRecordingNotFound = errors.New("room is not being recorded")
+)
+
+// recordingManifestFile is the name of the manifest written to the
+// directory of each recording when it is stopped.
+const recordingManifestFile = "manifest.json"
+
+// Recording is a recording of a room in progress.
+type Recording struct {
+	ID        string    `json:"id"`
+	Room      string    `json:"room"`
+	Dir       string    `json:"dir"`
+	StartedAt time.Time `json:"startedAt"`
+	// Manifest is the path of the RecordingManifest. It is only set once the
+	// recording has been stopped.
+	Manifest string `json:"manifest,omitempty"`
+}
+
+// RoomRecorder records rooms on demand. It must be registered as an
+// interceptor factory so that it sees the packets of all tracks, but only
+// writes them while a recording of the room is in progress. Each track is
+// written to its own file in the directory of the recording: Opus as Ogg
+// and VP8 as IVF. Tracks with other codecs and end-to-end encrypted tracks
+// are not recorded. When a recording is stopped, a RecordingManifest with
+// the start offset, codec and clock drift of every track, and the segments
+// during which recorded audio tracks carried speech, is written to
+// manifest.json.
+type RoomRecorder struct {
+	log      Logger
+	dir      string
+	webhooks *Webhooks
+	eventLog *EventLog
+	metering *Metering
+	uploads  *Uploads
+	jobs     *RecordingJobs
+
+	mu sync.Mutex
+	// key is room
+	recordings map[string]*roomRecording
+}
+
+type roomRecording struct {
+	Recording
+
+	mu sync.Mutex
+	// nil value marks a track which cannot be recorded
+	tracks   map[*webrtc.Track]*recordedTrack
+	speakers *speakerTimeline
+	closed   bool
+}
+
+// recordedTrack is guarded by roomRecording.mu.
+type recordedTrack struct {
+	// writer is nil once the track has been closed
+	writer RTPWriteCloser
+	info   RecordedTrack
+	clock  mediaClock
+}
+
+// finish returns the track info with the end offset and drift set.
+func (t *recordedTrack) finish(startedAt time.Time) RecordedTrack {
+	info := t.info
+	info.FirstTimestamp = t.clock.firstTimestamp
+	info.EndMs = offsetMs(startedAt, t.clock.lastAt)
+	info.DriftPPM = t.clock.driftPPM()
+	return info
+}
+
+// NewRoomRecorder creates a recorder which writes recordings to
+// subdirectories of dir. Recording is disabled when dir is empty.
+func NewRoomRecorder(loggerFactory LoggerFactory, dir string) *RoomRecorder {
+	return &RoomRecorder{
+		log:        loggerFactory.GetLogger("recorder"),
+		dir:        dir,
+		recordings: map[string]*roomRecording{},
+	}
+}
+
+// Start starts recording room.
+func (r *RoomRecorder) Start(room string) (Recording, error) {
+	if r.dir == "" {
+		return Recording{}, ErrRecordingDisabled
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.recordings[room]; ok {
+		return Recording{}, ErrRecordingStarted
+	}
+
+	id := NewUUIDBase62()
+	dir := filepath.Join(r.dir, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Recording{}, fmt.Errorf("Error creating recording dir: %w", err)
+	}
+
+	rec := &roomRecording{
+		Recording: Recording{
+			ID:        id,
+			Room:      room,
+			Dir:       dir,
+			StartedAt: time.Now(),
+		},
+		tracks: map[*webrtc.Track]*recordedTrack{},
+	}
+	rec.speakers = newSpeakerTimeline(rec.StartedAt)
+	r.recordings[room] = rec
+
+	r.log.Printf("Recording room: %s to: %s", room, dir)
+	r.eventLog.Emit(EventLogEntry{
+		Type:    EventLogRecordingStart,
+		Room:    room,
+		Details: map[string]string{"recordingId": id},
+	})
+	r.metering.recordingStarted(room)
+	return rec.Recording, nil
+}
+
+// Stop stops recording room and closes all files. Returns false when the
+// room was not being recorded.
+func (r *RoomRecorder) Stop(room string) (Recording, bool) {
+	return r.StopStartedBefore(room, time.Time{})
+}
+
+// StopStartedBefore is like Stop, but only stops a recording started before
+// t. A zero t stops any recording. It is used to end the recording of a
+// meeting which has closed without stopping a recording of the next meeting
+// in a room with the same name.
+func (r *RoomRecorder) StopStartedBefore(room string, t time.Time) (Recording, bool) {
+	r.mu.Lock()
+	rec, ok := r.recordings[room]
+	if ok && !t.IsZero() && rec.StartedAt.After(t) {
+		ok = false
+	}
+	if ok {
+		delete(r.recordings, room)
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		return Recording{}, false
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.closed = true
+	for track, t := range rec.tracks {
+		if t != nil && t.writer != nil {
+			r.closeWriter(rec, track, t)
+		}
+	}
+
+	manifest, err := r.writeManifest(rec)
+	if err != nil {
+		r.log.Printf("Error writing manifest of recording: %s: %s", rec.ID, err)
+	}
+	rec.Manifest = manifest
+
+	r.log.Printf("Stopped recording room: %s", room)
+	r.webhooks.Emit(WebhookRecordingFinished, room, "", rec.Recording)
+	r.eventLog.Emit(EventLogEntry{
+		Type:    EventLogRecordingStop,
+		Room:    room,
+		Details: map[string]string{"recordingId": rec.ID},
+	})
+	r.metering.recordingStopped(room)
+	r.uploads.UploadRecording(rec.Recording)
+	r.jobs.Process(rec.Recording)
+	return rec.Recording, true
+}
+
+// SetWebhooks sets the webhooks recording.finished events are sent to.
+func (r *RoomRecorder) SetWebhooks(webhooks *Webhooks) {
+	r.webhooks = webhooks
+}
+
+// SetEventLog sets the log started and stopped recordings are recorded to.
+func (r *RoomRecorder) SetEventLog(eventLog *EventLog) {
+	r.eventLog = eventLog
+}
+
+// SetMetering sets the Metering recording minutes are counted by.
+func (r *RoomRecorder) SetMetering(metering *Metering) {
+	r.metering = metering
+}
+
+// SetUploads sets the Uploads stopped recordings are uploaded with.
+func (r *RoomRecorder) SetUploads(uploads *Uploads) {
+	r.uploads = uploads
+}
+
+// SetRecordingJobs sets the RecordingJobs stopped recordings are
+// post-processed by. Recordings should not be uploaded with Uploads as well.
+func (r *RoomRecorder) SetRecordingJobs(jobs *RecordingJobs) {
+	r.jobs = jobs
+}
+
+// Recording returns the recording of room in progress.
+func (r *RoomRecorder) Recording(room string) (Recording, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec, ok := r.recordings[room]
+	if !ok {
+		return Recording{}, false
+	}
+	return rec.Recording, true
+}
+
+func (r *RoomRecorder) recording(room string) *roomRecording {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.recordings[room]
+}
+
+func (r *RoomRecorder) NewInterceptor(params InterceptorParams) (Interceptor, error) {
+	if params.Encrypted {
+		return NoOpInterceptor{}, nil
+	}
+
+	return &roomRecorderTap{
+		recorder: r,
+		params:   params,
+	}, nil
+}
+
+func (r *RoomRecorder) writeRTP(params InterceptorParams, packet *rtp.Packet) {
+	rec := r.recording(params.Room)
+	if rec == nil {
+		return
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.closed {
+		return
+	}
+
+	track := params.LocalTrack
+	t, ok := rec.tracks[track]
+	if !ok {
+		var err error
+		t, err = r.newRecordedTrack(rec, params)
+		if err != nil {
+			r.log.Printf("[%s] Not recording track: %s: %s", params.ClientID, track.ID(), err)
+		}
+		rec.tracks[track] = t
+	}
+
+	if t == nil || t.writer == nil {
+		return
+	}
+
+	// FEC packets cannot be written to files
+	packet = mediaPacket(packet)
+	if packet == nil {
+		return
+	}
+
+	now := time.Now()
+	t.clock.observe(packet.Timestamp, now)
+	if track.Kind() == webrtc.RTPCodecTypeAudio && isSpeech(packet) {
+		rec.speakers.markSpeech(params.ClientID, track.ID(), now)
+	}
+
+	if err := t.writer.WriteRTP(packet); err != nil {
+		r.log.Printf("[%s] Error recording packet of track: %s: %s", params.ClientID, track.ID(), err)
+	}
+}
+
+func (r *RoomRecorder) newRecordedTrack(rec *roomRecording, params InterceptorParams) (*recordedTrack, error) {
+	// file names are generated, because client and track IDs are chosen by
+	// clients
+	name := fmt.Sprintf("track-%d", len(rec.tracks)+1)
+	writer, fileName, err := newTrackFileWriter(rec.Dir, name, params)
+	if err != nil {
+		return nil, err
+	}
+
+	track := params.LocalTrack
+	codec := track.Codec()
+	return &recordedTrack{
+		writer: writer,
+		info: RecordedTrack{
+			ClientID:  params.ClientID,
+			TrackID:   track.ID(),
+			Kind:      track.Kind().String(),
+			StartMs:   offsetMs(rec.StartedAt, time.Now()),
+			File:      fileName,
+			Codec:     codec.Name,
+			ClockRate: codec.ClockRate,
+			Channels:  codec.Channels,
+		},
+		clock: mediaClock{clockRate: codec.ClockRate},
+	}, nil
+}
+
+// writeManifest must be called with rec.mu held, after all tracks have
+// been closed.
+func (r *RoomRecorder) writeManifest(rec *roomRecording) (string, error) {
+	manifest := RecordingManifest{
+		Room:      rec.Room,
+		StartedAt: rec.StartedAt,
+		Tracks:    []RecordedTrack{},
+		Speakers:  rec.speakers.Segments(),
+	}
+	for _, t := range rec.tracks {
+		if t != nil {
+			manifest.Tracks = append(manifest.Tracks, t.finish(rec.StartedAt))
+		}
+	}
+	sort.Slice(manifest.Tracks, func(i, j int) bool {
+		a, b := manifest.Tracks[i], manifest.Tracks[j]
+		if a.StartMs != b.StartMs {
+			return a.StartMs < b.StartMs
+		}
+		return a.File < b.File
+	})
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	fileName := filepath.Join(rec.Dir, recordingManifestFile)
+	if err := ioutil.WriteFile(fileName, data, 0644); err != nil {
+		return "", err
+	}
+	return fileName, nil
+}
+
+// closeTrack closes the file of a track which was unpublished during a
+// recording.
+func (r *RoomRecorder) closeTrack(params InterceptorParams) {
+	rec := r.recording(params.Room)
+	if rec == nil {
+		return
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if t := rec.tracks[params.LocalTrack]; t != nil && t.writer != nil {
+		r.closeWriter(rec, params.LocalTrack, t)
+	}
+}
+
+// closeWriter must be called with rec.mu held. A closed track stays in the
+// manifest, and its file is not reopened.
+func (r *RoomRecorder) closeWriter(rec *roomRecording, track *webrtc.Track, t *recordedTrack) {
+	if err := t.writer.Close(); err != nil {
+		r.log.Printf("Error closing recording of track: %s in room: %s: %s", track.ID(), rec.Room, err)
+	}
+	t.writer = nil
+}
+
+// newTrackFileWriter returns a writer and the name of the file in dir. The
+// file name is name with the extension of the container of the codec.
+func newTrackFileWriter(dir string, name string, params InterceptorParams) (RTPWriteCloser, string, error) {
+	codec := params.LocalTrack.Codec()
+	fileName := name
+
+	switch strings.ToLower(codec.Name) {
+	case strings.ToLower(webrtc.Opus):
+		fileName += ".ogg"
+		writer, err := oggwriter.New(filepath.Join(dir, fileName), codec.ClockRate, codec.Channels)
+		return writer, fileName, err
+	case strings.ToLower(webrtc.VP8):
+		fileName += ".ivf"
+		writer, err := ivfwriter.New(filepath.Join(dir, fileName))
+		return writer, fileName, err
+	default:
+		return nil, "", fmt.Errorf("unsupported codec: %s", codec.Name)
+	}
+}
+
+type roomRecorderTap struct {
+	NoOpInterceptor
+
+	recorder *RoomRecorder
+	params   InterceptorParams
+}
+
+func (t *roomRecorderTap) BindRTP(next RTPWriter) RTPWriter {
+	return RTPWriterFunc(func(packet *rtp.Packet) error {
+		t.recorder.writeRTP(t.params, packet)
+		return next.WriteRTP(packet)
+	})
+}
+
+func (t *roomRecorderTap) Close() error {
+	t.recorder.closeTrack(t.params)
+	return nil
+}
@@ -0,0 +1,141 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoomRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	recorder := server.NewRoomRecorder(loggerFactory, dir)
+	track := newTestServerTrack(t)
+	interceptor, err := recorder.NewInterceptor(server.InterceptorParams{
+		ClientID:   "a",
+		Room:       "room",
+		LocalTrack: track,
+	})
+	require.NoError(t, err)
+
+	var forwarded int
+	writer := interceptor.BindRTP(server.RTPWriterFunc(func(packet *rtp.Packet) error {
+		forwarded++
+		return nil
+	}))
+	packet := &rtp.Packet{
+		Header:  rtp.Header{Version: 2, SSRC: track.SSRC()},
+		Payload: []byte{0xfc, 0xff, 0xfe},
+	}
+	speech := &rtp.Packet{
+		Header:  rtp.Header{Version: 2, SSRC: track.SSRC(), SequenceNumber: 1, Timestamp: 960},
+		Payload: append([]byte{0xfc}, make([]byte, 100)...),
+	}
+
+	// not recording yet
+	require.NoError(t, writer.WriteRTP(packet))
+
+	recording, err := recorder.Start("room")
+	require.NoError(t, err)
+	_, err = recorder.Start("room")
+	assert.Equal(t, server.ErrRecordingStarted, err)
+
+	require.NoError(t, writer.WriteRTP(packet))
+	require.NoError(t, writer.WriteRTP(speech))
+	require.NoError(t, interceptor.Close())
+
+	stopped, ok := recorder.Stop("room")
+	assert.True(t, ok)
+	_, ok = recorder.Stop("room")
+	assert.False(t, ok)
+
+	assert.Equal(t, 3, forwarded)
+	files, err := filepath.Glob(filepath.Join(recording.Dir, "track-*.ogg"))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	assert.Equal(t, filepath.Join(recording.Dir, "manifest.json"), stopped.Manifest)
+	data, err := ioutil.ReadFile(stopped.Manifest)
+	require.NoError(t, err)
+	var manifest server.RecordingManifest
+	require.NoError(t, json.Unmarshal(data, &manifest))
+	assert.Equal(t, "room", manifest.Room)
+	require.Len(t, manifest.Tracks, 1)
+	recorded := manifest.Tracks[0]
+	assert.Equal(t, "a", recorded.ClientID)
+	assert.Equal(t, track.ID(), recorded.TrackID)
+	assert.Equal(t, filepath.Base(files[0]), recorded.File)
+	assert.Equal(t, "opus", recorded.Codec)
+	assert.Equal(t, uint32(48000), recorded.ClockRate)
+	assert.LessOrEqual(t, recorded.StartMs, recorded.EndMs)
+
+	// only the packet carrying speech starts a segment
+	require.Len(t, manifest.Speakers, 1)
+	speaker := manifest.Speakers[0]
+	assert.Equal(t, "a", speaker.ClientID)
+	assert.Equal(t, track.ID(), speaker.TrackID)
+	assert.LessOrEqual(t, recorded.StartMs, speaker.StartMs)
+	assert.LessOrEqual(t, speaker.StartMs, speaker.EndMs)
+}
+
+func TestRoomRecorder_StopStartedBefore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	recorder := server.NewRoomRecorder(loggerFactory, dir)
+	before := time.Now().Add(-time.Second)
+	_, err = recorder.Start("room")
+	require.NoError(t, err)
+
+	// a recording of the next meeting is kept
+	_, ok := recorder.StopStartedBefore("room", before)
+	assert.False(t, ok)
+	_, ok = recorder.Recording("room")
+	assert.True(t, ok)
+
+	_, ok = recorder.StopStartedBefore("room", time.Now().Add(time.Second))
+	assert.True(t, ok)
+	_, ok = recorder.Recording("room")
+	assert.False(t, ok)
+}
+
+func TestRoomRecorder_uploads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := &testBlobStore{}
+	uploads, hooks := newTestUploads(store, server.StorageConfig{})
+	defer uploads.Close()
+
+	recorder := server.NewRoomRecorder(loggerFactory, dir)
+	recorder.SetUploads(uploads)
+	recording, err := recorder.Start("room")
+	require.NoError(t, err)
+	recorder.Stop("room")
+
+	select {
+	case upload := <-hooks.uploaded:
+		assert.Equal(t, recording.ID, upload.ID)
+		assert.Equal(t, []string{"recordings/" + recording.ID + "/manifest.json"}, upload.Keys)
+	case <-time.After(timeout):
+		t.Fatal("timed out waiting for upload")
+	}
+}
+
+func TestRoomRecorder_disabled(t *testing.T) {
+	recorder := server.NewRoomRecorder(loggerFactory, "")
+	_, err := recorder.Start("room")
+	assert.Equal(t, server.ErrRecordingDisabled, err)
+}
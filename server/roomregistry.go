@@ -0,0 +1,310 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidRegisteredRoom = errors.New("invalid room")
+
+var (
+	ErrRoomNotRegistered = &JoinError{Code: "roomNotFound", Message: "Room does not exist"}
+	ErrRoomDisabled      = &JoinError{Code: "roomDisabled", Message: "Room is disabled"}
+	ErrRoomNotStarted    = &JoinError{Code: "roomNotStarted", Message: "Meeting has not started yet"}
+	ErrRoomEnded         = &JoinError{Code: "roomEnded", Message: "Meeting has ended"}
+	ErrInvalidPassword   = &JoinError{Code: "invalidPassword", Message: "Invalid password"}
+	ErrRoomUnavailable   = &JoinError{Code: "roomUnavailable", Message: "Room is not available"}
+)
+
+// RegisteredRoom is a room created in advance with its settings.
+type RegisteredRoom struct {
+	Name string `json:"name"`
+	// Password is only set in requests. It is stored as PasswordHash.
+	Password     string `json:"password,omitempty"`
+	PasswordHash string `json:"passwordHash,omitempty"`
+	// MaxParticipants overrides the capacity of the room when set.
+	MaxParticipants int `json:"maxParticipants"`
+	// StartsAt and EndsAt limit when clients can join the room.
+	StartsAt *time.Time `json:"startsAt,omitempty"`
+	EndsAt   *time.Time `json:"endsAt,omitempty"`
+	Disabled bool       `json:"disabled"`
+	// Features replace the default features of rooms when set.
+	Features *RoomFeatures `json:"features,omitempty"`
+}
+
+// RoomStore keeps registered rooms.
+type RoomStore interface {
+	SaveRoom(room RegisteredRoom) error
+	// Room returns false when room is not registered.
+	Room(name string) (RegisteredRoom, bool, error)
+	Rooms() ([]RegisteredRoom, error)
+	// DeleteRoom returns false when room was not registered.
+	DeleteRoom(name string) (bool, error)
+}
+
+type MemoryRoomStore struct {
+	mu sync.Mutex
+	// key is name of the room
+	rooms map[string]RegisteredRoom
+}
+
+var _ RoomStore = &MemoryRoomStore{}
+
+func NewMemoryRoomStore() *MemoryRoomStore {
+	return &MemoryRoomStore{
+		rooms: map[string]RegisteredRoom{},
+	}
+}
+
+func (s *MemoryRoomStore) SaveRoom(room RegisteredRoom) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rooms[room.Name] = room
+	return nil
+}
+
+func (s *MemoryRoomStore) Room(name string) (RegisteredRoom, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room, ok := s.rooms[name]
+	return room, ok, nil
+}
+
+func (s *MemoryRoomStore) Rooms() ([]RegisteredRoom, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rooms := make([]RegisteredRoom, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms, nil
+}
+
+func (s *MemoryRoomStore) DeleteRoom(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.rooms[name]
+	delete(s.rooms, name)
+	return ok, nil
+}
+
+// RedisRoomStore keeps registered rooms in a redis hash, so that all
+// instances sharing the store know the same rooms.
+type RedisRoomStore struct {
+	client *redis.Client
+	key    string
+}
+
+var _ RoomStore = &RedisRoomStore{}
+
+func NewRedisRoomStore(client *redis.Client, prefix string) *RedisRoomStore {
+	return &RedisRoomStore{
+		client: client,
+		key:    prefix + ":rooms",
+	}
+}
+
+func (s *RedisRoomStore) SaveRoom(room RegisteredRoom) error {
+	data, err := json.Marshal(room)
+	if err != nil {
+		return err
+	}
+	return s.client.HSet(s.key, room.Name, data).Err()
+}
+
+func (s *RedisRoomStore) Room(name string) (RegisteredRoom, bool, error) {
+	var room RegisteredRoom
+
+	value, err := s.client.HGet(s.key, name).Result()
+	if err == redis.Nil {
+		return room, false, nil
+	}
+	if err != nil {
+		return room, false, err
+	}
+
+	err = json.Unmarshal([]byte(value), &room)
+	return room, err == nil, err
+}
+
+func (s *RedisRoomStore) Rooms() ([]RegisteredRoom, error) {
+	values, err := s.client.HGetAll(s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	rooms := make([]RegisteredRoom, 0, len(values))
+	for _, value := range values {
+		var room RegisteredRoom
+		if err := json.Unmarshal([]byte(value), &room); err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, nil
+}
+
+func (s *RedisRoomStore) DeleteRoom(name string) (bool, error) {
+	deleted, err := s.client.HDel(s.key, name).Result()
+	return deleted > 0, err
+}
+
+// RoomRegistry decides which rooms clients can join. Registered rooms can
+// only be joined during their schedule and with their password, if set.
+// Rooms which are not registered are created on demand, unless ad-hoc rooms
+// are disabled.
+type RoomRegistry struct {
+	log    Logger
+	store  RoomStore
+	config RegistryConfig
+}
+
+func NewRoomRegistry(loggerFactory LoggerFactory, store RoomStore, config RegistryConfig) *RoomRegistry {
+	return &RoomRegistry{
+		log:    loggerFactory.GetLogger("roomregistry"),
+		store:  store,
+		config: config,
+	}
+}
+
+// Save registers room or replaces its settings. The password is hashed
+// before it is stored, and the room has no password when it is empty.
+func (r *RoomRegistry) Save(room RegisteredRoom) (RegisteredRoom, error) {
+	if room.Name == "" || strings.Contains(room.Name, "/") {
+		return room, fmt.Errorf("%w: name: %q", ErrInvalidRegisteredRoom, room.Name)
+	}
+	if room.MaxParticipants < 0 {
+		return room, fmt.Errorf("%w: maxParticipants: %d", ErrInvalidRegisteredRoom, room.MaxParticipants)
+	}
+	if room.StartsAt != nil && room.EndsAt != nil && !room.EndsAt.After(*room.StartsAt) {
+		return room, fmt.Errorf("%w: endsAt must be after startsAt", ErrInvalidRegisteredRoom)
+	}
+	if len(room.Password) > maxRoomPasswordLength {
+		return room, fmt.Errorf("%w: password is longer than %d bytes", ErrInvalidRegisteredRoom, maxRoomPasswordLength)
+	}
+
+	room.PasswordHash = ""
+	if room.Password != "" {
+		hash, err := hashRoomPassword(room.Password)
+		if err != nil {
+			return room, fmt.Errorf("Error hashing room password: %w", err)
+		}
+		room.PasswordHash = hash
+		room.Password = ""
+	}
+
+	if err := r.store.SaveRoom(room); err != nil {
+		return room, err
+	}
+	r.log.Printf("Saved room: %s", room.Name)
+	return room, nil
+}
+
+// Room returns the registered room name.
+func (r *RoomRegistry) Room(name string) (RegisteredRoom, bool, error) {
+	return r.store.Room(name)
+}
+
+// Rooms returns registered rooms sorted by name.
+func (r *RoomRegistry) Rooms() ([]RegisteredRoom, error) {
+	rooms, err := r.store.Rooms()
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Name < rooms[j].Name
+	})
+	return rooms, err
+}
+
+// Delete unregisters room name. Returns false when it was not registered.
+func (r *RoomRegistry) Delete(name string) (bool, error) {
+	return r.store.DeleteRoom(name)
+}
+
+// authorize returns the registered settings of room when a client with
+// password can join it at now. Settings of rooms created on demand are
+// empty.
+func (r *RoomRegistry) authorize(room string, password string, now time.Time) (RegisteredRoom, *JoinError) {
+	registered, ok, err := r.store.Room(room)
+	if err != nil {
+		r.log.Printf("Error reading registered room: %s: %s", room, err)
+		return registered, ErrRoomUnavailable
+	}
+
+	switch {
+	case !ok && r.config.DisableAdHoc:
+		return registered, ErrRoomNotRegistered
+	case !ok:
+		return registered, nil
+	case registered.Disabled:
+		return registered, ErrRoomDisabled
+	case registered.StartsAt != nil && now.Before(*registered.StartsAt):
+		return registered, ErrRoomNotStarted
+	case registered.EndsAt != nil && !now.Before(*registered.EndsAt):
+		return registered, ErrRoomEnded
+	case registered.PasswordHash != "" && !checkRoomPassword(registered.PasswordHash, password):
+		return registered, ErrInvalidPassword
+	}
+	return registered, nil
+}
+
+// features returns the features of room, or defaults when room has no
+// features of its own.
+func (r *RoomRegistry) features(room string, defaults RoomFeatures) RoomFeatures {
+	registered, ok, err := r.store.Room(room)
+	if err != nil {
+		r.log.Printf("Error reading registered room: %s, using default features: %s", room, err)
+	}
+	if !ok || registered.Features == nil {
+		return defaults
+	}
+	return *registered.Features
+}
+
+// maxRoomPasswordLength is the length of the longest password bcrypt
+// hashes without ignoring the rest.
+const maxRoomPasswordLength = 72
+
+// hashRoomPassword returns the bcrypt hash of password.
+func hashRoomPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// checkRoomPassword returns true when password matches passwordHash. Hashes
+// stored before bcrypt was used, the SHA-256 of a salt and the password in
+// the form salt$hash, are still accepted until the room is saved again.
+func checkRoomPassword(passwordHash string, password string) bool {
+	if strings.HasPrefix(passwordHash, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) == nil
+	}
+	salt := strings.SplitN(passwordHash, "$", 2)[0]
+	sum := sha256.Sum256([]byte(salt + password))
+	expected := salt + "$" + hex.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(passwordHash)) == 1
+}
+
+// SetRoomRegistry replaces the default registry, which keeps rooms in memory
+// and allows ad-hoc rooms. It must be called before any connections are
+// handled.
+func (wss *WSS) SetRoomRegistry(registry *RoomRegistry) {
+	wss.registry = registry
+}
+
+// RoomRegistry returns the registry of rooms clients can join.
+func (wss *WSS) RoomRegistry() *RoomRegistry {
+	return wss.registry
+}
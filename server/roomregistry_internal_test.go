@@ -0,0 +1,23 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckRoomPassword(t *testing.T) {
+	hash, err := hashRoomPassword("secret")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$2"))
+	assert.True(t, checkRoomPassword(hash, "secret"))
+	assert.False(t, checkRoomPassword(hash, "Secret"))
+	assert.False(t, checkRoomPassword(hash, ""))
+
+	// the salted SHA-256 of "secret" stored by earlier versions
+	legacy := "salt$bede90386d450cea8b77b822f8887065e4e5abf132c2f9dccfcc7fbd4cba5e35"
+	assert.True(t, checkRoomPassword(legacy, "secret"))
+	assert.False(t, checkRoomPassword(legacy, "Secret"))
+}
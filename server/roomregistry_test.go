@@ -0,0 +1,143 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func testRoomStore(t *testing.T, store server.RoomStore) {
+	t.Helper()
+
+	startsAt := time.Now().UTC().Truncate(time.Second)
+	rooms := []server.RegisteredRoom{
+		{Name: "b", MaxParticipants: 5},
+		{Name: "a", PasswordHash: "salt$hash", StartsAt: &startsAt},
+	}
+	for _, room := range rooms {
+		require.NoError(t, store.SaveRoom(room))
+	}
+
+	room, ok, err := store.Room("a")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, rooms[1].PasswordHash, room.PasswordHash)
+	assert.True(t, startsAt.Equal(*room.StartsAt))
+
+	_, ok, err = store.Room("c")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	all, err := store.Rooms()
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	deleted, err := store.DeleteRoom("b")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	deleted, err = store.DeleteRoom("b")
+	require.NoError(t, err)
+	assert.False(t, deleted)
+}
+
+func TestMemoryRoomStore(t *testing.T) {
+	testRoomStore(t, server.NewMemoryRoomStore())
+}
+
+func TestRedisRoomStore(t *testing.T) {
+	pub, _, stop := configureRedis(t)
+	defer stop()
+	store := server.NewRedisRoomStore(pub, "peercalls-test")
+	defer store.DeleteRoom("a")
+	testRoomStore(t, store)
+}
+
+func TestRoomRegistry_Save(t *testing.T) {
+	registry := server.NewRoomRegistry(loggerFactory, server.NewMemoryRoomStore(), server.RegistryConfig{})
+
+	now := time.Now()
+	earlier := now.Add(-time.Hour)
+	for _, room := range []server.RegisteredRoom{
+		{Name: ""},
+		{Name: "a/b"},
+		{Name: "a", MaxParticipants: -1},
+		{Name: "a", StartsAt: &now, EndsAt: &earlier},
+		{Name: "a", Password: strings.Repeat("a", 73)},
+	} {
+		_, err := registry.Save(room)
+		assert.True(t, errors.Is(err, server.ErrInvalidRegisteredRoom), "room: %+v", room)
+	}
+
+	room, err := registry.Save(server.RegisteredRoom{Name: "a", Password: "secret"})
+	require.NoError(t, err)
+	assert.Empty(t, room.Password)
+	assert.NotEmpty(t, room.PasswordHash)
+	assert.NotContains(t, room.PasswordHash, "secret")
+	assert.True(t, room.Public().HasPassword)
+
+	rooms, err := registry.Rooms()
+	require.NoError(t, err)
+	assert.Equal(t, []server.RegisteredRoom{room}, rooms)
+}
+
+func TestWSS_roomRegistry(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	wss := server.NewWSS(loggerFactory, rooms)
+	registry := server.NewRoomRegistry(loggerFactory, server.NewMemoryRoomStore(), server.RegistryConfig{
+		DisableAdHoc: true,
+	})
+	wss.SetRoomRegistry(registry)
+	s := httptest.NewServer(server.NewMeshHandler(loggerFactory, wss))
+	defer s.Close()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/"
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	joinError := func(url string) string {
+		ws := mustDialWS(t, ctx, wsURL+url)
+		defer ws.Close(websocket.StatusNormalClosure, "")
+		msg, _ := readJoinResult(t, ctx, ws)
+		if msg.Type != server.MessageTypeJoinError {
+			return ""
+		}
+		payload, _ := msg.Payload.(map[string]interface{})
+		code, _ := payload["code"].(string)
+		return code
+	}
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	for _, room := range []server.RegisteredRoom{
+		{Name: "private", Password: "secret", MaxParticipants: 1},
+		{Name: "disabled", Disabled: true},
+		{Name: "scheduled", StartsAt: &future},
+		{Name: "ended", EndsAt: &past},
+	} {
+		_, err := registry.Save(room)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, "roomNotFound", joinError("adhoc/a"))
+	assert.Equal(t, "roomDisabled", joinError("disabled/a"))
+	assert.Equal(t, "roomNotStarted", joinError("scheduled/a"))
+	assert.Equal(t, "roomEnded", joinError("ended/a"))
+	assert.Equal(t, "invalidPassword", joinError("private/a"))
+	assert.Equal(t, "invalidPassword", joinError("private/a?password=wrong"))
+
+	ws := mustDialWS(t, ctx, wsURL+"private/a?password=secret")
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	mustReadRoles(t, ctx, ws)
+
+	assert.Equal(t, "roomFull", joinError("private/b?password=secret"))
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/pion/webrtc/v2"
+)
+
+// RoomInfo summarizes a room on this instance.
+type RoomInfo struct {
+	Room string `json:"room"`
+	// Clients is the number of websocket connections to this instance
+	Clients int `json:"clients"`
+	// Peers is the number of peer connections to the SFU
+	Peers  int `json:"peers"`
+	Tracks int `json:"tracks"`
+}
+
+// PeerInfo describes a participant of a room. Connected is false for SFU
+// peers whose websocket connection is no longer open.
+type PeerInfo struct {
+	ClientID    string    `json:"clientId"`
+	JoinedAt    time.Time `json:"joinedAt"`
+	Connected   bool      `json:"connected"`
+	AudioOnly   bool      `json:"audioOnly"`
+	VideoPaused bool      `json:"videoPaused"`
+	Muted       bool      `json:"muted"`
+	Tracks      int       `json:"tracks"`
+	// Role is set for clients connected to this instance
+	Role Role `json:"role,omitempty"`
+	// Bandwidth is set for publishers in rooms with a maximum bitrate
+	Bandwidth *PublisherBandwidth `json:"bandwidth,omitempty"`
+	// SendQueue is set for SFU peers
+	SendQueue *SendQueueStats `json:"sendQueue,omitempty"`
+	// TrackEvents is set for SFU peers
+	TrackEvents *TrackEventStats `json:"trackEvents,omitempty"`
+}
+
+// TrackInfo describes a track published to a room. Tracks published by the
+// server have ClientID set to localPeerID. It is a snapshot: it does not
+// change when the track does.
+type TrackInfo struct {
+	ClientID string      `json:"clientId"`
+	TrackID  string      `json:"trackId"`
+	Kind     string      `json:"kind"`
+	SSRC     uint32      `json:"ssrc"`
+	Source   TrackSource `json:"source,omitempty"`
+	Language string      `json:"language,omitempty"`
+	// Bitrate in bits per second
+	Bitrate     uint64 `json:"bitrate"`
+	Subscribers int    `json:"subscribers"`
+}
+
+// trackSnapshot is a TrackInfo together with the track it describes, for
+// room logic which needs the track itself.
+type trackSnapshot struct {
+	TrackInfo
+	track *webrtc.Track
+}
+
+func newTrackSnapshot(clientID string, track *webrtc.Track) trackSnapshot {
+	return trackSnapshot{
+		TrackInfo: TrackInfo{
+			ClientID: clientID,
+			TrackID:  track.ID(),
+			Kind:     track.Kind().String(),
+			SSRC:     track.SSRC(),
+		},
+		track: track,
+	}
+}
+
+// RoomStateProvider is the part of TracksManager used by the room state
+// API.
+type RoomStateProvider interface {
+	RoomNames() []string
+	RoomPeers(room string) []PeerInfo
+	RoomTracks(room string) []TrackInfo
+}
+
+// RoomNames returns sorted names of rooms with peers or server tracks.
+func (t *MemoryTracksManager) RoomNames() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	rooms := make([]string, 0, len(t.peerIDsByRoom))
+	for room := range t.peerIDsByRoom {
+		rooms = append(rooms, room)
+	}
+	for room := range t.serverTracks {
+		if _, ok := t.peerIDsByRoom[room]; !ok {
+			rooms = append(rooms, room)
+		}
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// RoomPeers returns peers in room ordered by join time.
+func (t *MemoryTracksManager) RoomPeers(room string) []PeerInfo {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	peers := make([]PeerInfo, 0, len(t.peerIDsByRoom[room]))
+	for clientID := range t.peerIDsByRoom[room] {
+		p, ok := t.peers[clientID]
+		if !ok {
+			continue
+		}
+		info := PeerInfo{
+			ClientID:    clientID,
+			JoinedAt:    p.joinedAt,
+			AudioOnly:   p.audioOnly,
+			VideoPaused: p.videoPaused,
+			Muted:       p.muted,
+			Tracks:      len(p.trackListener.Snapshot()),
+		}
+		if bandwidth, ok := t.bandwidth.PublisherBandwidth(clientID); ok {
+			info.Bandwidth = &bandwidth
+		}
+		sendQueue := p.trackListener.SendQueueStats()
+		info.SendQueue = &sendQueue
+		trackEvents := p.trackListener.TrackEventStats()
+		info.TrackEvents = &trackEvents
+		peers = append(peers, info)
+	}
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].JoinedAt.Before(peers[j].JoinedAt)
+	})
+	return peers
+}
+
+// RoomTracks returns tracks published to room, ordered by clientID and
+// track ID.
+func (t *MemoryTracksManager) RoomTracks(room string) []TrackInfo {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	subscribers := map[*webrtc.Track]int{}
+	for clientID := range t.peerIDsByRoom[room] {
+		if p, ok := t.peers[clientID]; ok {
+			for track := range p.forwarded {
+				subscribers[track]++
+			}
+		}
+	}
+
+	var tracks []TrackInfo
+	for clientID := range t.peerIDsByRoom[room] {
+		p, ok := t.peers[clientID]
+		if !ok {
+			continue
+		}
+		for _, snapshot := range p.trackListener.Snapshot() {
+			snapshot.Language = p.trackLanguages[snapshot.TrackID]
+			tracks = append(tracks, t.trackInfo(snapshot, subscribers[snapshot.track]))
+		}
+	}
+	for _, track := range t.serverTracks[room] {
+		snapshot := newTrackSnapshot(localPeerID, track)
+		tracks = append(tracks, t.trackInfo(snapshot, subscribers[track]))
+	}
+
+	sort.Slice(tracks, func(i, j int) bool {
+		if tracks[i].ClientID != tracks[j].ClientID {
+			return tracks[i].ClientID < tracks[j].ClientID
+		}
+		return tracks[i].TrackID < tracks[j].TrackID
+	})
+	return tracks
+}
+
+// trackInfo returns the info of snapshot with the number of subscribers and
+// the bitrate of its track set.
+func (t *MemoryTracksManager) trackInfo(snapshot trackSnapshot, subscribers int) TrackInfo {
+	info := snapshot.TrackInfo
+	info.Subscribers = subscribers
+	if stats, ok := t.stats.TrackStats(snapshot.track); ok {
+		info.Bitrate = stats.Bitrate
+	}
+	return info
+}
+
+type roomStateAPI struct {
+	wss     *WSS
+	tracks  RoomStateProvider
+	digests *RoomDigests
+}
+
+// NewRoomStateHandler creates a handler for the read-only room state API.
+// It is protected by the same bearer token and API keys as the admin API,
+// and tenants only see their own rooms. Rooms, peers and tracks are limited to this
+// instance.
+func NewRoomStateHandler(token string, wss *WSS, tracks RoomStateProvider, digests *RoomDigests) http.Handler {
+	api := &roomStateAPI{
+		wss:     wss,
+		tracks:  tracks,
+		digests: digests,
+	}
+
+	router := chi.NewRouter()
+	router.Use(adminAuth(token, wss))
+	router.Get("/", api.listRooms)
+	router.Get("/{room}/peers", api.listPeers)
+	router.Get("/{room}/tracks", api.listTracks)
+	router.Get("/{room}/digest", api.getDigest)
+
+	return router
+}
+
+func (a *roomStateAPI) listRooms(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantFromContext(r.Context())
+	rooms := []RoomInfo{}
+	for _, info := range roomInfos(a.wss, a.tracks) {
+		if tenant.Owns(info.Room) {
+			rooms = append(rooms, info)
+		}
+	}
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+// roomInfos summarizes rooms with websocket clients or SFU peers, sorted by
+// name.
+func roomInfos(wss *WSS, tracks RoomStateProvider) []RoomInfo {
+	roomsByName := map[string]*RoomInfo{}
+	room := func(name string) *RoomInfo {
+		info, ok := roomsByName[name]
+		if !ok {
+			info = &RoomInfo{Room: name}
+			roomsByName[name] = info
+		}
+		return info
+	}
+
+	for _, name := range wss.LocalRooms() {
+		room(name).Clients = len(wss.LocalClients(name))
+	}
+	for _, name := range tracks.RoomNames() {
+		info := room(name)
+		info.Peers = len(tracks.RoomPeers(name))
+		info.Tracks = len(tracks.RoomTracks(name))
+	}
+
+	rooms := make([]RoomInfo, 0, len(roomsByName))
+	for _, info := range roomsByName {
+		rooms = append(rooms, *info)
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Room < rooms[j].Room
+	})
+	return rooms
+}
+
+// listPeers lists clients connected to room merged with SFU peers. JoinedAt
+// is the earlier of the websocket connection and the peer connection.
+func (a *roomStateAPI) listPeers(w http.ResponseWriter, r *http.Request) {
+	room := roomParam(r)
+	clients := a.wss.LocalClients(room)
+	sfuPeers := a.tracks.RoomPeers(room)
+
+	if len(clients) == 0 && len(sfuPeers) == 0 {
+		writeJSON(w, http.StatusNotFound, AdminError{"Room not found"})
+		return
+	}
+
+	peers := make([]PeerInfo, 0, len(clients)+len(sfuPeers))
+	for _, p := range sfuPeers {
+		if connectedAt, ok := clients[p.ClientID]; ok {
+			p.Connected = true
+			p.Role, _ = a.wss.Role(room, p.ClientID)
+			if connectedAt.Before(p.JoinedAt) {
+				p.JoinedAt = connectedAt
+			}
+			delete(clients, p.ClientID)
+		}
+		peers = append(peers, p)
+	}
+	for clientID, connectedAt := range clients {
+		role, _ := a.wss.Role(room, clientID)
+		peers = append(peers, PeerInfo{
+			ClientID:  clientID,
+			JoinedAt:  connectedAt,
+			Connected: true,
+			Role:      role,
+		})
+	}
+
+	sort.Slice(peers, func(i, j int) bool {
+		if !peers[i].JoinedAt.Equal(peers[j].JoinedAt) {
+			return peers[i].JoinedAt.Before(peers[j].JoinedAt)
+		}
+		return peers[i].ClientID < peers[j].ClientID
+	})
+
+	writeJSON(w, http.StatusOK, peers)
+}
+
+func (a *roomStateAPI) listTracks(w http.ResponseWriter, r *http.Request) {
+	room := roomParam(r)
+	tracks := a.tracks.RoomTracks(room)
+
+	if len(tracks) == 0 && len(a.wss.LocalClients(room)) == 0 && len(a.tracks.RoomPeers(room)) == 0 {
+		writeJSON(w, http.StatusNotFound, AdminError{"Room not found"})
+		return
+	}
+
+	if tracks == nil {
+		tracks = []TrackInfo{}
+	}
+	writeJSON(w, http.StatusOK, tracks)
+}
+
+func (a *roomStateAPI) getDigest(w http.ResponseWriter, r *http.Request) {
+	digest, ok := a.digests.Digest(roomParam(r))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, AdminError{"Room not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, digest)
+}
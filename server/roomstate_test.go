@@ -0,0 +1,66 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func roomStateRequest(t *testing.T, url string, token string, value interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(res.Body).Decode(value))
+	}
+	return res.StatusCode
+}
+
+func TestRoomState(t *testing.T) {
+	s, wsURL := setupAdminServer(t)
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ws := mustDialWS(t, ctx, wsURL)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+
+	var rooms []server.RoomInfo
+	assert.Equal(t, http.StatusUnauthorized, roomStateRequest(t, s.URL+"/api/rooms", "invalid", &rooms))
+
+	require.Eventually(t, func() bool {
+		roomStateRequest(t, s.URL+"/api/rooms", adminToken, &rooms)
+		return len(rooms) == 1 && rooms[0].Clients == 1
+	}, timeout, 10*time.Millisecond)
+	assert.Equal(t, []server.RoomInfo{{Room: roomName, Clients: 1, Peers: 1, Tracks: 1}}, rooms)
+
+	var peers []server.PeerInfo
+	statusCode := roomStateRequest(t, s.URL+"/api/rooms/"+roomName+"/peers", adminToken, &peers)
+	assert.Equal(t, http.StatusOK, statusCode)
+	require.Len(t, peers, 2)
+	assert.Equal(t, "zombie", peers[0].ClientID)
+	assert.False(t, peers[0].Connected)
+	assert.Equal(t, clientID, peers[1].ClientID)
+	assert.True(t, peers[1].Connected)
+	assert.False(t, peers[1].JoinedAt.IsZero())
+
+	var tracks []server.TrackInfo
+	statusCode = roomStateRequest(t, s.URL+"/api/rooms/"+roomName+"/tracks", adminToken, &tracks)
+	assert.Equal(t, http.StatusOK, statusCode)
+	require.Len(t, tracks, 1)
+	assert.Equal(t, uint32(123), tracks[0].SSRC)
+	assert.Equal(t, uint64(32000), tracks[0].Bitrate)
+
+	assert.Equal(t, http.StatusNotFound, roomStateRequest(t, s.URL+"/api/rooms/missing/peers", adminToken, &peers))
+	assert.Equal(t, http.StatusNotFound, roomStateRequest(t, s.URL+"/api/rooms/missing/tracks", adminToken, &tracks))
+}
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+)
+
+const (
+	IngestTypeRTP  = "rtp"
+	IngestTypeRTSP = "rtsp"
+
+	ingestMaxPacketSize = 1500
+)
+
+// ingestCodecs contains codecs which can be ingested, keyed by lowercase
+// name.
+var ingestCodecs = map[string]func() *webrtc.RTPCodec{
+	"opus": func() *webrtc.RTPCodec {
+		return webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, opusSampleRate)
+	},
+	"vp8": func() *webrtc.RTPCodec {
+		return webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000)
+	},
+	"h264": func() *webrtc.RTPCodec {
+		return webrtc.NewRTPH264Codec(webrtc.DefaultPayloadTypeH264, 90000)
+	},
+}
+
+// IngestRequest describes an external media source to publish into a room.
+type IngestRequest struct {
+	// Type is either rtp or rtsp.
+	Type string `json:"type"`
+	// Codec of a raw RTP source: opus, vp8 or h264.
+	Codec string `json:"codec"`
+	// URL of an RTSP source to pull from.
+	URL string `json:"url"`
+	// Source is the IP, or IP and port, a raw RTP source sends from. Packets
+	// from other addresses are dropped. When empty, the address of the first
+	// packet is used.
+	Source string `json:"source"`
+}
+
+// IngestSource is an external media source publishing into a room. It
+// appears to other peers as a participant with ClientID.
+type IngestSource struct {
+	ID       string `json:"id"`
+	Room     string `json:"room"`
+	ClientID string `json:"clientId"`
+	Type     string `json:"type"`
+	// Port is the UDP port a raw RTP source should send packets to.
+	Port int `json:"port,omitempty"`
+	// URL of an RTSP source, without credentials.
+	URL string `json:"url,omitempty"`
+}
+
+type ingest struct {
+	IngestSource
+	tracks []*webrtc.Track
+	closer io.Closer
+	// run forwards media until the source is closed
+	run func()
+
+	closeOnce sync.Once
+}
+
+// Ingest starts publishing media from an external source into room. A raw
+// RTP source gets a UDP port to send packets to, and an RTSP source is
+// pulled over TCP with interleaved RTP.
+func (t *MemoryTracksManager) Ingest(room string, req IngestRequest) (IngestSource, error) {
+	id := NewUUIDBase62()
+	source := IngestSource{
+		ID:       id,
+		Room:     room,
+		ClientID: "ingest_" + id,
+		Type:     req.Type,
+	}
+
+	var in *ingest
+	var err error
+	switch req.Type {
+	case IngestTypeRTP:
+		in, err = t.ingestRTP(source, req.Codec, req.Source)
+	case IngestTypeRTSP:
+		in, err = t.ingestRTSP(source, req.URL)
+	default:
+		err = fmt.Errorf("Unsupported ingest type: %q", req.Type)
+	}
+	if err != nil {
+		return IngestSource{}, err
+	}
+
+	t.mu.Lock()
+	t.log.Printf("Ingest: %s %s into room: %s", in.ID, in.Type, room)
+	t.ingests[id] = in
+	for _, track := range in.tracks {
+		t.addServerTrack(room, track)
+	}
+	t.mu.Unlock()
+
+	go func() {
+		defer t.removeIngest(in)
+		in.run()
+	}()
+
+	return in.IngestSource, nil
+}
+
+// StopIngest stops an ingest source in room. Returns false when the source
+// was not found.
+func (t *MemoryTracksManager) StopIngest(room string, id string) bool {
+	t.mu.Lock()
+	in, ok := t.ingests[id]
+	t.mu.Unlock()
+
+	if !ok || in.Room != room {
+		return false
+	}
+
+	t.removeIngest(in)
+	return true
+}
+
+func (t *MemoryTracksManager) removeIngest(in *ingest) {
+	in.closeOnce.Do(func() {
+		if err := in.closer.Close(); err != nil {
+			t.log.Printf("Ingest: Error closing source: %s: %s", in.ID, err)
+		}
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		t.log.Printf("Ingest: %s stopped in room: %s", in.ID, in.Room)
+		delete(t.ingests, in.ID)
+		for _, track := range in.tracks {
+			t.removeServerTrack(in.Room, track)
+		}
+	})
+}
+
+func newIngestTrack(source IngestSource, codecName string) (*webrtc.Track, error) {
+	newCodec, ok := ingestCodecs[codecName]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported ingest codec: %q", codecName)
+	}
+
+	codec := newCodec()
+	track, err := webrtc.NewTrack(
+		codec.PayloadType,
+		rand.Uint32(),
+		getLocalTrackID(NewUUIDBase62()),
+		"sfu_"+source.ClientID+"_"+source.ID,
+		codec,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating ingest track: %w", err)
+	}
+	return track, nil
+}
+
+// writeIngestPacket parses an RTP packet from an external source and writes
+// it to track, rewriting the payload type and SSRC.
+func writeIngestPacket(track *webrtc.Track, data []byte) error {
+	var packet rtp.Packet
+	if err := packet.Unmarshal(data); err != nil {
+		return err
+	}
+
+	packet.PayloadType = track.PayloadType()
+	packet.SSRC = track.SSRC()
+	err := track.WriteRTP(&packet)
+	if err == io.ErrClosedPipe {
+		// no subscribers yet
+		return nil
+	}
+	return err
+}
+
+// rtpSourceFilter accepts packets from a single address. A zero port
+// accepts any port of the IP. When the IP is nil, the address of the first
+// packet is latched.
+type rtpSourceFilter struct {
+	ip   net.IP
+	port int
+}
+
+func newRTPSourceFilter(source string) (*rtpSourceFilter, error) {
+	if source == "" {
+		return &rtpSourceFilter{}, nil
+	}
+
+	host, port := source, 0
+	if h, p, err := net.SplitHostPort(source); err == nil {
+		host = h
+		if port, err = strconv.Atoi(p); err != nil || port <= 0 || port > 0xFFFF {
+			return nil, fmt.Errorf("Invalid ingest source port: %q", source)
+		}
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid ingest source: %q", source)
+	}
+	return &rtpSourceFilter{ip: ip, port: port}, nil
+}
+
+func (f *rtpSourceFilter) accept(addr *net.UDPAddr) bool {
+	if f.ip == nil {
+		f.ip = addr.IP
+		f.port = addr.Port
+		return true
+	}
+	return f.ip.Equal(addr.IP) && (f.port == 0 || f.port == addr.Port)
+}
+
+// listenIngest opens the UDP port of a raw RTP source on the configured IP
+// and port range.
+func (t *MemoryTracksManager) listenIngest() (*net.UDPConn, error) {
+	config := t.sfuConfig.Ingest
+
+	var ip net.IP
+	if config.ListenIP != "" {
+		if ip = net.ParseIP(config.ListenIP); ip == nil {
+			return nil, fmt.Errorf("Invalid ingest listen IP: %q", config.ListenIP)
+		}
+	}
+
+	ports := config.UDP
+	if ports.PortMin == 0 && ports.PortMax == 0 {
+		ports = t.sfuConfig.UDP
+	}
+	return ports.listen(ip)
+}
+
+func (t *MemoryTracksManager) ingestRTP(source IngestSource, codecName string, sourceAddr string) (*ingest, error) {
+	filter, err := newRTPSourceFilter(sourceAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	track, err := newIngestTrack(source, codecName)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := t.listenIngest()
+	if err != nil {
+		return nil, fmt.Errorf("Error opening ingest port: %w", err)
+	}
+	source.Port = conn.LocalAddr().(*net.UDPAddr).Port
+
+	in := &ingest{
+		IngestSource: source,
+		tracks:       []*webrtc.Track{track},
+		closer:       conn,
+	}
+
+	in.run = func() {
+		buf := make([]byte, ingestMaxPacketSize)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !filter.accept(addr) {
+				continue
+			}
+			if err := writeIngestPacket(track, buf[:n]); err != nil {
+				t.log.Printf("Ingest: Error writing RTP packet: %s: %s", in.ID, err)
+			}
+		}
+	}
+
+	return in, nil
+}
+
+func (t *MemoryTracksManager) ingestRTSP(source IngestSource, rawURL string) (*ingest, error) {
+	client, err := dialRTSP(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	source.URL = client.url.String()
+
+	medias, err := client.setup()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	// key is interleaved channel
+	tracksByChannel := map[uint8]*webrtc.Track{}
+	var tracks []*webrtc.Track
+	for _, media := range medias {
+		track, err := newIngestTrack(source, media.codec)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		tracksByChannel[media.channel] = track
+		tracks = append(tracks, track)
+	}
+
+	in := &ingest{
+		IngestSource: source,
+		tracks:       tracks,
+		closer:       client,
+	}
+
+	in.run = func() {
+		stopKeepAlive := make(chan struct{})
+		defer close(stopKeepAlive)
+
+		go func() {
+			ticker := time.NewTicker(client.keepAliveInterval())
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					if err := client.sendKeepAlive(); err != nil {
+						t.log.Printf("Ingest: Error sending RTSP keep-alive: %s: %s", in.ID, err)
+					}
+				case <-stopKeepAlive:
+					return
+				}
+			}
+		}()
+
+		for {
+			channel, data, err := client.readPacket()
+			if err != nil {
+				return
+			}
+			track, ok := tracksByChannel[channel]
+			if !ok {
+				// RTCP
+				continue
+			}
+			if err := writeIngestPacket(track, data); err != nil {
+				t.log.Printf("Ingest: Error writing RTP packet: %s: %s", in.ID, err)
+			}
+		}
+	}
+
+	return in, nil
+}
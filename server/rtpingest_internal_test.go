@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRTPSourceFilter(t *testing.T) {
+	source := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5004}
+	otherPort := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5006}
+	other := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 5004}
+
+	// the first source is latched
+	filter, err := newRTPSourceFilter("")
+	require.NoError(t, err)
+	assert.True(t, filter.accept(source))
+	assert.True(t, filter.accept(source))
+	assert.False(t, filter.accept(otherPort))
+	assert.False(t, filter.accept(other))
+
+	filter, err = newRTPSourceFilter("192.0.2.1")
+	require.NoError(t, err)
+	assert.False(t, filter.accept(other))
+	assert.True(t, filter.accept(source))
+	assert.True(t, filter.accept(otherPort))
+
+	filter, err = newRTPSourceFilter("192.0.2.1:5004")
+	require.NoError(t, err)
+	assert.False(t, filter.accept(otherPort))
+	assert.True(t, filter.accept(source))
+
+	_, err = newRTPSourceFilter("192.0.2.1:0")
+	assert.Error(t, err)
+	_, err = newRTPSourceFilter("example.com")
+	assert.Error(t, err)
+}
@@ -0,0 +1,142 @@
+package server_test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryTracksManager_Ingest_rtp(t *testing.T) {
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{})
+
+	source, err := tracks.Ingest("room", server.IngestRequest{
+		Type:  server.IngestTypeRTP,
+		Codec: "vp8",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "room", source.Room)
+	assert.Equal(t, "ingest_"+source.ID, source.ClientID)
+	assert.NotZero(t, source.Port)
+
+	assert.False(t, tracks.StopIngest("other-room", source.ID))
+	assert.True(t, tracks.StopIngest("room", source.ID))
+	assert.False(t, tracks.StopIngest("room", source.ID))
+
+	_, err = tracks.Ingest("room", server.IngestRequest{
+		Type:  server.IngestTypeRTP,
+		Codec: "pcmu",
+	})
+	assert.Error(t, err)
+}
+
+func TestMemoryTracksManager_Ingest_rtpPorts(t *testing.T) {
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{
+		Ingest: server.IngestConfig{
+			ListenIP: "127.0.0.1",
+			UDP:      server.UDPConfig{PortMin: 51000, PortMax: 51010},
+		},
+	})
+
+	source, err := tracks.Ingest("room", server.IngestRequest{
+		Type:   server.IngestTypeRTP,
+		Codec:  "opus",
+		Source: "127.0.0.1:5004",
+	})
+	require.NoError(t, err)
+	defer tracks.StopIngest("room", source.ID)
+	assert.GreaterOrEqual(t, source.Port, 51000)
+	assert.LessOrEqual(t, source.Port, 51010)
+
+	_, err = tracks.Ingest("room", server.IngestRequest{
+		Type:   server.IngestTypeRTP,
+		Codec:  "opus",
+		Source: "not-an-ip",
+	})
+	assert.Error(t, err)
+}
+
+// serveRTSP accepts a single RTSP connection and answers requests until
+// TEARDOWN, which is sent to teardown.
+func serveRTSP(t *testing.T, listener net.Listener, teardown chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	sdp := "v=0\r\n" +
+		"o=- 1 1 IN IP4 127.0.0.1\r\n" +
+		"s=camera\r\n" +
+		"t=0 0\r\n" +
+		"m=audio 0 RTP/AVP 0\r\n" +
+		"a=rtpmap:0 PCMU/8000\r\n" +
+		"a=control:trackID=0\r\n" +
+		"m=video 0 RTP/AVP 96\r\n" +
+		"a=rtpmap:96 H264/90000\r\n" +
+		"a=control:trackID=1\r\n"
+
+	for {
+		requestLine, err := reader.ReadLine()
+		if err != nil {
+			return
+		}
+		header, err := reader.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+
+		expectedAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass"))
+		if header.Get("Authorization") != expectedAuth {
+			fmt.Fprintf(conn, "RTSP/1.0 401 Unauthorized\r\nCSeq: %s\r\n\r\n", header.Get("CSeq"))
+			continue
+		}
+
+		method := strings.Fields(requestLine)[0]
+		switch method {
+		case "DESCRIBE":
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\nContent-Base: rtsp://%s/stream/\r\nContent-Length: %d\r\n\r\n%s",
+				header.Get("CSeq"), listener.Addr(), len(sdp), sdp)
+		case "SETUP":
+			assert.Equal(t, "SETUP rtsp://"+listener.Addr().String()+"/stream/trackID=1 RTSP/1.0", requestLine)
+			assert.Equal(t, "RTP/AVP/TCP;unicast;interleaved=0-1", header.Get("Transport"))
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\nSession: abc;timeout=60\r\n\r\n", header.Get("CSeq"))
+		case "PLAY":
+			assert.Equal(t, "abc", header.Get("Session"))
+			fmt.Fprintf(conn, "RTSP/1.0 200 OK\r\nCSeq: %s\r\n\r\n", header.Get("CSeq"))
+			// interleaved RTP packet on channel 0
+			conn.Write([]byte{'$', 0, 0, 12, 0x80, 96, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1})
+		case "TEARDOWN":
+			teardown <- header.Get("Session")
+			return
+		}
+	}
+}
+
+func TestMemoryTracksManager_Ingest_rtsp(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	teardown := make(chan string, 1)
+	go serveRTSP(t, listener, teardown)
+
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{})
+	source, err := tracks.Ingest("room", server.IngestRequest{
+		Type: server.IngestTypeRTSP,
+		URL:  "rtsp://user:pass@" + listener.Addr().String() + "/stream",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "rtsp://"+listener.Addr().String()+"/stream", source.URL)
+
+	assert.True(t, tracks.StopIngest("room", source.ID))
+	assert.Equal(t, "abc", <-teardown)
+}
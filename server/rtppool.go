@@ -0,0 +1,47 @@
+package server
+
+import (
+	"io"
+	"sync"
+
+	"github.com/pion/rtp"
+)
+
+// rtpReadBufferSize is the size of buffers packets of remote tracks are read
+// into, the same as the receive MTU of pion/webrtc.
+const rtpReadBufferSize = 1460
+
+// rtpReadBufferPool holds buffers for reading packets of remote tracks. A
+// buffer is only used for a single read, so all tracks share the pool.
+var rtpReadBufferPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, rtpReadBufferSize)
+		return &b
+	},
+}
+
+// readRTP reads a single packet from r, which is usually a remote track.
+//
+// Track.ReadRTP allocates a new buffer of the receive MTU for every packet,
+// which is retained for as long as the packet is, for example in NACK and
+// rewind buffers. Packets are read into a pooled buffer instead and copied
+// to a buffer of their own size, so that audio packets especially retain
+// far less memory.
+func readRTP(r io.Reader) (*rtp.Packet, error) {
+	buf := rtpReadBufferPool.Get().(*[]byte)
+	defer rtpReadBufferPool.Put(buf)
+
+	n, err := r.Read(*buf)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, n)
+	copy(raw, (*buf)[:n])
+
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(raw); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// packetReader returns the same packet from every read, like a remote track
+// receiving packets.
+type packetReader struct {
+	raw []byte
+}
+
+func (r *packetReader) Read(b []byte) (int, error) {
+	return copy(b, r.raw), nil
+}
+
+func newTestPacketReader(t testing.TB, payloadSize int) *packetReader {
+	packet := &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    111,
+			SequenceNumber: 5,
+			Timestamp:      1000,
+			SSRC:           1234,
+		},
+		Payload: bytes.Repeat([]byte{1}, payloadSize),
+	}
+	raw, err := packet.Marshal()
+	require.NoError(t, err)
+	return &packetReader{raw: raw}
+}
+
+func TestReadRTP(t *testing.T) {
+	r := newTestPacketReader(t, 100)
+
+	first, err := readRTP(r)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(5), first.SequenceNumber)
+	assert.Equal(t, uint32(1234), first.SSRC)
+	assert.Len(t, first.Payload, 100)
+	assert.Len(t, first.Raw, len(r.raw), "packets retain only their own size")
+
+	r.raw[len(r.raw)-1] = 2
+	second, err := readRTP(r)
+	require.NoError(t, err)
+	assert.Equal(t, byte(2), second.Payload[99])
+	assert.Equal(t, byte(1), first.Payload[99], "read buffers are not shared with packets")
+}
+
+// readRTPUnpooled reads packets the same way as Track.ReadRTP.
+func readRTPUnpooled(r *packetReader) (*rtp.Packet, error) {
+	b := make([]byte, rtpReadBufferSize)
+	n, err := r.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	packet := &rtp.Packet{}
+	if err := packet.Unmarshal(b[:n]); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+func benchmarkReadRTP(b *testing.B, payloadSize int, read func(r *packetReader) (*rtp.Packet, error)) {
+	r := newTestPacketReader(b, payloadSize)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := read(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadRTP_audio_pooled(b *testing.B) {
+	benchmarkReadRTP(b, 100, func(r *packetReader) (*rtp.Packet, error) { return readRTP(r) })
+}
+
+func BenchmarkReadRTP_audio_unpooled(b *testing.B) {
+	benchmarkReadRTP(b, 100, readRTPUnpooled)
+}
+
+func BenchmarkReadRTP_video_pooled(b *testing.B) {
+	benchmarkReadRTP(b, 1100, func(r *packetReader) (*rtp.Packet, error) { return readRTP(r) })
+}
+
+func BenchmarkReadRTP_video_unpooled(b *testing.B) {
+	benchmarkReadRTP(b, 1100, readRTPUnpooled)
+}
@@ -0,0 +1,366 @@
+package server
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/sdp/v2"
+)
+
+const (
+	rtspDefaultPort  = "554"
+	rtspDialTimeout  = 10 * time.Second
+	rtspReadTimeout  = 30 * time.Second
+	rtspInterleaved  = '$'
+	rtspUserAgent    = "peer-calls"
+	rtspKeepAliveMin = 5 * time.Second
+)
+
+// rtspClient is a minimal RTSP client (RFC 2326) which pulls media over a
+// single TCP connection with interleaved RTP. Only Basic authentication is
+// supported.
+type rtspClient struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	url     *url.URL
+	auth    string
+	session string
+	timeout time.Duration
+
+	// mu guards writes once playback started, because keep-alives are sent
+	// concurrently with Close.
+	mu   sync.Mutex
+	cseq int
+}
+
+type rtspResponse struct {
+	statusCode int
+	header     textproto.MIMEHeader
+	body       []byte
+}
+
+// rtspMedia is a media stream set up with SETUP. RTP packets of the stream
+// are received on the interleaved channel.
+type rtspMedia struct {
+	kind        string
+	codec       string
+	payloadType uint8
+	channel     uint8
+}
+
+func dialRTSP(rawURL string) (*rtspClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid RTSP URL: %w", err)
+	}
+	if u.Scheme != "rtsp" {
+		return nil, fmt.Errorf("Unsupported RTSP URL scheme: %s", u.Scheme)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), rtspDefaultPort)
+	}
+
+	conn, err := net.DialTimeout("tcp", host, rtspDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to RTSP server: %w", err)
+	}
+
+	c := &rtspClient{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		url:    u,
+	}
+
+	if u.User != nil {
+		password, _ := u.User.Password()
+		credentials := u.User.Username() + ":" + password
+		c.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+		// credentials must not be sent in the Request-URI
+		stripped := *u
+		stripped.User = nil
+		c.url = &stripped
+	}
+
+	return c, nil
+}
+
+// setup describes the presentation, sets up all supported media streams and
+// starts playback.
+func (c *rtspClient) setup() ([]rtspMedia, error) {
+	res, err := c.request("DESCRIBE", c.url.String(), map[string]string{
+		"Accept": "application/sdp",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := c.url
+	if contentBase := res.header.Get("Content-Base"); contentBase != "" {
+		if u, err := url.Parse(contentBase); err == nil {
+			baseURL = u
+		}
+	}
+
+	var desc sdp.SessionDescription
+	if err := desc.Unmarshal(res.body); err != nil {
+		return nil, fmt.Errorf("Error parsing RTSP SDP: %w", err)
+	}
+
+	var medias []rtspMedia
+	for _, media := range desc.MediaDescriptions {
+		codec, payloadType, ok := rtspMediaCodec(&desc, media)
+		if !ok {
+			continue
+		}
+
+		channel := uint8(len(medias) * 2)
+		control, _ := media.Attribute("control")
+		res, err := c.request("SETUP", rtspControlURL(baseURL, control), map[string]string{
+			"Transport": fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", channel, channel+1),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if c.session == "" {
+			session := strings.Split(res.header.Get("Session"), ";")
+			c.session = strings.TrimSpace(session[0])
+			for _, param := range session[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "timeout=") {
+					seconds, _ := strconv.Atoi(strings.TrimPrefix(param, "timeout="))
+					c.timeout = time.Duration(seconds) * time.Second
+				}
+			}
+		}
+
+		medias = append(medias, rtspMedia{
+			kind:        media.MediaName.Media,
+			codec:       codec,
+			payloadType: payloadType,
+			channel:     channel,
+		})
+	}
+
+	if len(medias) == 0 {
+		return nil, fmt.Errorf("RTSP source has no Opus, VP8 or H264 media")
+	}
+
+	if _, err := c.request("PLAY", baseURL.String(), nil); err != nil {
+		return nil, err
+	}
+	// media is read with its own deadlines
+	c.conn.SetDeadline(time.Time{})
+
+	return medias, nil
+}
+
+// rtspMediaCodec returns the first supported codec of media.
+func rtspMediaCodec(desc *sdp.SessionDescription, media *sdp.MediaDescription) (string, uint8, bool) {
+	for _, format := range media.MediaName.Formats {
+		payloadType, err := strconv.Atoi(format)
+		if err != nil {
+			continue
+		}
+
+		codec, err := desc.GetCodecForPayloadType(uint8(payloadType))
+		if err != nil {
+			continue
+		}
+
+		name := strings.ToLower(codec.Name)
+		if _, ok := ingestCodecs[name]; ok {
+			return name, uint8(payloadType), true
+		}
+	}
+	return "", 0, false
+}
+
+func rtspControlURL(baseURL *url.URL, control string) string {
+	if control == "" || control == "*" {
+		return baseURL.String()
+	}
+	u, err := url.Parse(control)
+	if err != nil {
+		return baseURL.String()
+	}
+	if u.IsAbs() {
+		return u.String()
+	}
+
+	base := *baseURL
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	return base.ResolveReference(u).String()
+}
+
+func (c *rtspClient) request(method string, uri string, headers map[string]string) (*rtspResponse, error) {
+	c.cseq++
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s RTSP/1.0\r\n", method, uri)
+	fmt.Fprintf(&b, "CSeq: %d\r\n", c.cseq)
+	fmt.Fprintf(&b, "User-Agent: %s\r\n", rtspUserAgent)
+	if c.auth != "" {
+		fmt.Fprintf(&b, "Authorization: %s\r\n", c.auth)
+	}
+	if c.session != "" {
+		fmt.Fprintf(&b, "Session: %s\r\n", c.session)
+	}
+	for name, value := range headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+	}
+	b.WriteString("\r\n")
+
+	c.conn.SetDeadline(time.Now().Add(rtspReadTimeout))
+	if _, err := io.WriteString(c.conn, b.String()); err != nil {
+		return nil, fmt.Errorf("Error sending RTSP %s: %w", method, err)
+	}
+
+	res, err := c.readResponse()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading RTSP %s response: %w", method, err)
+	}
+	if res.statusCode == 401 {
+		return nil, fmt.Errorf("RTSP %s unauthorized, only Basic authentication is supported", method)
+	}
+	if res.statusCode != 200 {
+		return nil, fmt.Errorf("RTSP %s failed with status: %d", method, res.statusCode)
+	}
+	return res, nil
+}
+
+func (c *rtspClient) readResponse() (*rtspResponse, error) {
+	reader := textproto.NewReader(c.reader)
+	statusLine, err := reader.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.SplitN(statusLine, " ", 3)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "RTSP/") {
+		return nil, fmt.Errorf("Invalid RTSP status line: %q", statusLine)
+	}
+	statusCode, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid RTSP status code: %q", statusLine)
+	}
+
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &rtspResponse{
+		statusCode: statusCode,
+		header:     header,
+	}
+
+	if contentLength := header.Get("Content-Length"); contentLength != "" {
+		length, err := strconv.Atoi(contentLength)
+		if err != nil || length < 0 {
+			return nil, fmt.Errorf("Invalid RTSP Content-Length: %q", contentLength)
+		}
+		res.body = make([]byte, length)
+		if _, err := io.ReadFull(c.reader, res.body); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// keepAliveInterval returns how often the session must be refreshed.
+func (c *rtspClient) keepAliveInterval() time.Duration {
+	timeout := c.timeout
+	if timeout <= 0 {
+		// RFC 2326 default session timeout
+		timeout = 60 * time.Second
+	}
+	interval := timeout / 2
+	if interval < rtspKeepAliveMin {
+		interval = rtspKeepAliveMin
+	}
+	return interval
+}
+
+// sendKeepAlive refreshes the session. The response is read by readPacket
+// because it is interleaved with media.
+func (c *rtspClient) sendKeepAlive() error {
+	return c.send("OPTIONS")
+}
+
+// send writes a request without a body and without waiting for the
+// response.
+func (c *rtspClient) send(method string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cseq++
+	msg := fmt.Sprintf(
+		"%s %s RTSP/1.0\r\nCSeq: %d\r\nUser-Agent: %s\r\nSession: %s\r\n",
+		method, c.url, c.cseq, rtspUserAgent, c.session,
+	)
+	if c.auth != "" {
+		msg += "Authorization: " + c.auth + "\r\n"
+	}
+	_, err := io.WriteString(c.conn, msg+"\r\n")
+	return err
+}
+
+// readPacket returns the next interleaved packet and its channel. RTSP
+// responses interleaved with media are skipped.
+func (c *rtspClient) readPacket() (uint8, []byte, error) {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(rtspReadTimeout))
+
+		marker, err := c.reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if marker != rtspInterleaved {
+			if err := c.reader.UnreadByte(); err != nil {
+				return 0, nil, err
+			}
+			if _, err := c.readResponse(); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+
+		var header [3]byte
+		if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+			return 0, nil, err
+		}
+
+		data := make([]byte, binary.BigEndian.Uint16(header[1:]))
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return 0, nil, err
+		}
+
+		return header[0], data, nil
+	}
+}
+
+// Close tears down the session and closes the connection.
+func (c *rtspClient) Close() error {
+	if c.session != "" {
+		c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.send("TEARDOWN")
+	}
+	return c.conn.Close()
+}
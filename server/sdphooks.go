@@ -0,0 +1,89 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/pion/webrtc/v2"
+)
+
+// SDPHookParams identify the peer a session description is sent to.
+type SDPHookParams struct {
+	Room     string
+	ClientID string
+}
+
+// SDPHook inspects or modifies offers and answers generated by the SFU
+// before they are sent to a client, for example to strip candidates, reorder
+// codecs or add bandwidth lines. The type of sessionDescription tells offers
+// and answers apart.
+//
+// Hooks only change what is sent to the client, the local description of the
+// peer connection has already been set.
+type SDPHook interface {
+	HookSDP(params SDPHookParams, sessionDescription webrtc.SessionDescription) (webrtc.SessionDescription, error)
+}
+
+// SDPHookFunc is an adapter to use a function as an SDPHook.
+type SDPHookFunc func(params SDPHookParams, sessionDescription webrtc.SessionDescription) (webrtc.SessionDescription, error)
+
+func (f SDPHookFunc) HookSDP(params SDPHookParams, sessionDescription webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	return f(params, sessionDescription)
+}
+
+// SDPHooks is the chain of SDPHooks applied to session descriptions sent to
+// clients of the SFU.
+type SDPHooks struct {
+	log Logger
+
+	mu    sync.RWMutex
+	hooks []SDPHook
+}
+
+func NewSDPHooks(loggerFactory LoggerFactory) *SDPHooks {
+	return &SDPHooks{
+		log: loggerFactory.GetLogger("sdphooks"),
+	}
+}
+
+// Use appends hooks to the chain. Hooks are applied in the order they were
+// added, each receiving the session description returned by the previous
+// one.
+func (h *SDPHooks) Use(hooks ...SDPHook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.hooks = append(h.hooks, hooks...)
+}
+
+// Apply applies all hooks to sessionDescription. When a hook returns an
+// error, the error is logged and the session description is sent as it was
+// before the hook, since dropping it would stall the negotiation.
+func (h *SDPHooks) Apply(params SDPHookParams, sessionDescription webrtc.SessionDescription) webrtc.SessionDescription {
+	if h == nil {
+		return sessionDescription
+	}
+
+	h.mu.RLock()
+	hooks := h.hooks
+	h.mu.RUnlock()
+
+	for _, hook := range hooks {
+		hooked, err := hook.HookSDP(params, sessionDescription)
+		if err != nil {
+			h.log.Errorf("[%s] Error in SDP hook of %s: %s", params.ClientID, sessionDescription.Type, err)
+			continue
+		}
+		sessionDescription = hooked
+	}
+
+	return sessionDescription
+}
+
+// applyToPayload applies hooks to payload when it contains a session
+// description.
+func (h *SDPHooks) applyToPayload(params SDPHookParams, payload Payload) Payload {
+	if sessionDescription, ok := payload.Signal.(webrtc.SessionDescription); ok {
+		payload.Signal = h.Apply(params, sessionDescription)
+	}
+	return payload
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// SecretProvider returns the value of a secret identified by a provider
+// specific key.
+type SecretProvider interface {
+	GetSecret(key string) (string, error)
+}
+
+type SecretProviderFunc func(key string) (string, error)
+
+func (f SecretProviderFunc) GetSecret(key string) (string, error) {
+	return f(key)
+}
+
+// SecretResolver replaces references to secrets in config values with the
+// values of the secrets. A reference has the form ${provider:key}, for
+// example ${env:TURN_SECRET} or ${file:/run/secrets/turn}. Values which are
+// not references are returned as they are.
+type SecretResolver struct {
+	providers map[string]SecretProvider
+}
+
+// NewSecretResolver creates a resolver with env and file providers
+// registered. Kubernetes secrets can be referenced through the file provider
+// when mounted as a volume.
+func NewSecretResolver() *SecretResolver {
+	return &SecretResolver{
+		providers: map[string]SecretProvider{
+			"env":  SecretProviderFunc(getEnvSecret),
+			"file": SecretProviderFunc(getFileSecret),
+		},
+	}
+}
+
+// Register adds a provider used to resolve references with prefix name.
+func (r *SecretResolver) Register(name string, provider SecretProvider) {
+	r.providers[name] = provider
+}
+
+// Resolve returns the secret referenced by value, or value when it is not a
+// reference.
+func (r *SecretResolver) Resolve(value string) (string, error) {
+	if !strings.HasPrefix(value, "${") || !strings.HasSuffix(value, "}") {
+		return value, nil
+	}
+
+	ref := value[2 : len(value)-1]
+	colon := strings.Index(ref, ":")
+	if colon < 0 {
+		return "", fmt.Errorf("Invalid secret reference: %s", value)
+	}
+
+	name, key := ref[:colon], ref[colon+1:]
+	provider, ok := r.providers[name]
+	if !ok {
+		return "", fmt.Errorf("Unknown secret provider: %s", name)
+	}
+
+	secret, err := provider.GetSecret(key)
+	if err != nil {
+		return "", fmt.Errorf("Error resolving secret %s: %w", value, err)
+	}
+	return secret, nil
+}
+
+func getEnvSecret(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("Environment variable not set: %s", name)
+	}
+	return value, nil
+}
+
+func getFileSecret(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// NewVaultSecretProvider creates a provider which reads secrets from a
+// HashiCorp Vault server. Keys have the form path#field, for example
+// secret/data/peer-calls#turn. Both KV version 1 and 2 engines are
+// supported.
+func NewVaultSecretProvider(addr string, token string, client *http.Client) SecretProvider {
+	addr = strings.TrimSuffix(addr, "/")
+
+	return SecretProviderFunc(func(key string) (string, error) {
+		hash := strings.LastIndex(key, "#")
+		if hash < 0 {
+			return "", fmt.Errorf("Vault secret key must have the form path#field: %s", key)
+		}
+		secretPath, field := key[:hash], key[hash+1:]
+
+		req, err := http.NewRequest("GET", addr+"/v1/"+(&url.URL{Path: secretPath}).EscapedPath(), nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("X-Vault-Token", token)
+
+		res, err := client.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("Error reading secret from Vault: %w", err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("Error reading secret from Vault: %s", res.Status)
+		}
+
+		var body struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			return "", fmt.Errorf("Error parsing Vault response: %w", err)
+		}
+
+		data := body.Data
+		// KV version 2 nests the secret data and its metadata
+		if nested, ok := data["data"].(map[string]interface{}); ok {
+			data = nested
+		}
+
+		value, ok := data[field].(string)
+		if !ok {
+			return "", fmt.Errorf("Field %s not found in Vault secret: %s", field, secretPath)
+		}
+		return value, nil
+	})
+}
+
+// ResolveConfigSecrets replaces secret references in c with the values of
+// the secrets. The Vault provider is registered when a Vault address is
+// configured, and uses client for its requests.
+func ResolveConfigSecrets(resolver *SecretResolver, c *Config, client *http.Client) error {
+	if c.Secrets.Vault.Addr != "" {
+		token, err := resolver.Resolve(c.Secrets.Vault.Token)
+		if err != nil {
+			return err
+		}
+		resolver.Register("vault", NewVaultSecretProvider(c.Secrets.Vault.Addr, token, client))
+	}
+
+	for _, secret := range configSecrets(c) {
+		value, err := resolver.Resolve(*secret)
+		if err != nil {
+			return err
+		}
+		*secret = value
+	}
+
+	return nil
+}
+
+// configSecrets returns the fields of c which can hold secret references.
+func configSecrets(c *Config) []*string {
+	secrets := []*string{&c.Admin.Token, &c.Audit.HTTP.Token, &c.Webhook.Secret, &c.Metering.Webhook.Secret, &c.Egress.Secret, &c.Transcription.Secret, &c.Storage.S3.SecretAccessKey, &c.SIP.Password}
+	for i := range c.ICEServers {
+		secrets = append(secrets, &c.ICEServers[i].AuthSecret.Secret)
+	}
+	for i := range c.Tenants {
+		secrets = append(secrets, &c.Tenants[i].APIKey, &c.Tenants[i].JoinSecret)
+	}
+	return secrets
+}
+
+// RedactConfigSecrets returns a copy of c with the values of secrets and
+// secret references replaced, so that it can be logged.
+func RedactConfigSecrets(c Config) Config {
+	c.ICEServers = append([]ICEServer(nil), c.ICEServers...)
+	c.Tenants = append([]TenantConfig(nil), c.Tenants...)
+
+	for _, secret := range append(configSecrets(&c), &c.Secrets.Vault.Token) {
+		if *secret != "" {
+			*secret = "REDACTED"
+		}
+	}
+	return c
+}
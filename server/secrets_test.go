@@ -0,0 +1,129 @@
+package server_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretResolver_env(t *testing.T) {
+	os.Setenv("PEERCALLSTEST_SECRET", "env-secret")
+	defer os.Unsetenv("PEERCALLSTEST_SECRET")
+
+	r := server.NewSecretResolver()
+
+	value, err := r.Resolve("${env:PEERCALLSTEST_SECRET}")
+	require.NoError(t, err)
+	assert.Equal(t, "env-secret", value)
+
+	_, err = r.Resolve("${env:PEERCALLSTEST_MISSING}")
+	assert.Error(t, err)
+}
+
+func TestSecretResolver_file(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peer-calls-secrets")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "secret")
+	require.NoError(t, ioutil.WriteFile(filename, []byte("file-secret\n"), 0600))
+
+	value, err := server.NewSecretResolver().Resolve("${file:" + filename + "}")
+	require.NoError(t, err)
+	assert.Equal(t, "file-secret", value)
+}
+
+func TestSecretResolver_plain(t *testing.T) {
+	r := server.NewSecretResolver()
+
+	value, err := r.Resolve("plain:secret")
+	require.NoError(t, err)
+	assert.Equal(t, "plain:secret", value)
+
+	_, err = r.Resolve("${unknown:key}")
+	assert.Error(t, err)
+}
+
+func TestResolveConfigSecrets_vault(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		assert.Equal(t, "/v1/secret/data/peer-calls", r.URL.Path)
+		w.Write([]byte(`{"data":{"data":{"turn":"turn-secret","admin":"admin-secret"}}}`))
+	}))
+	defer vault.Close()
+
+	os.Setenv("PEERCALLSTEST_VAULT_TOKEN", "vault-token")
+	defer os.Unsetenv("PEERCALLSTEST_VAULT_TOKEN")
+
+	var c server.Config
+	c.Secrets.Vault.Addr = vault.URL
+	c.Secrets.Vault.Token = "${env:PEERCALLSTEST_VAULT_TOKEN}"
+	c.Admin.Token = "${vault:secret/data/peer-calls#admin}"
+	c.ICEServers = []server.ICEServer{{}}
+	c.ICEServers[0].AuthSecret.Secret = "${vault:secret/data/peer-calls#turn}"
+
+	err := server.ResolveConfigSecrets(server.NewSecretResolver(), &c, http.DefaultClient)
+	require.NoError(t, err)
+	assert.Equal(t, "admin-secret", c.Admin.Token)
+	assert.Equal(t, "turn-secret", c.ICEServers[0].AuthSecret.Secret)
+
+	c.Admin.Token = "${vault:secret/data/peer-calls#missing}"
+	err = server.ResolveConfigSecrets(server.NewSecretResolver(), &c, http.DefaultClient)
+	assert.Error(t, err)
+}
+
+func TestResolveConfigSecrets_vaultTimeout(t *testing.T) {
+	done := make(chan struct{})
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer vault.Close()
+	defer close(done)
+
+	var c server.Config
+	c.Secrets.Vault.Addr = vault.URL
+	c.Admin.Token = "${vault:secret/data/peer-calls#admin}"
+
+	err := server.ResolveConfigSecrets(server.NewSecretResolver(), &c, &http.Client{
+		Timeout: 10 * time.Millisecond,
+	})
+	assert.Error(t, err)
+}
+
+func TestRedactConfigSecrets(t *testing.T) {
+	var c server.Config
+	c.Admin.Token = "admin-token"
+	c.Secrets.Vault.Token = "vault-token"
+	c.SIP.Password = "${env:SIP_PASSWORD}"
+	c.ICEServers = []server.ICEServer{{URLs: []string{"turn:example.com"}}}
+	c.ICEServers[0].AuthSecret.Secret = "ice-secret"
+	c.Tenants = []server.TenantConfig{{Name: "acme", APIKey: "api-key"}}
+
+	redacted := server.RedactConfigSecrets(c)
+
+	assert.Equal(t, "REDACTED", redacted.Admin.Token)
+	assert.Equal(t, "REDACTED", redacted.Secrets.Vault.Token)
+	assert.Equal(t, "REDACTED", redacted.SIP.Password)
+	assert.Equal(t, "REDACTED", redacted.ICEServers[0].AuthSecret.Secret)
+	assert.Equal(t, "REDACTED", redacted.Tenants[0].APIKey)
+	assert.Equal(t, "", redacted.Webhook.Secret)
+	assert.Equal(t, "acme", redacted.Tenants[0].Name)
+	for _, s := range []string{"admin-token", "vault-token", "ice-secret", "api-key"} {
+		assert.NotContains(t, fmt.Sprintf("%+v", redacted), s)
+	}
+
+	// the config itself is not changed
+	assert.Equal(t, "ice-secret", c.ICEServers[0].AuthSecret.Secret)
+	assert.Equal(t, "api-key", c.Tenants[0].APIKey)
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/pion/rtp"
+)
+
+// defaultSendQueueSize is the number of packets queued for a subscriber
+// before video packets are dropped.
+const defaultSendQueueSize = 256
+
+// SendQueueStats contains counters of the packets sent to a subscriber.
+type SendQueueStats struct {
+	Queued        int    `json:"queued"`
+	MaxQueueDepth int    `json:"maxQueueDepth"`
+	Sent          uint64 `json:"sent"`
+	// Dropped is the number of video packets dropped because the subscriber
+	// could not keep up.
+	Dropped uint64 `json:"dropped"`
+	Errors  uint64 `json:"errors"`
+}
+
+// Add returns the sum of s and other. The maximum queue depth is the larger
+// of both.
+func (s SendQueueStats) Add(other SendQueueStats) SendQueueStats {
+	s.Queued += other.Queued
+	if other.MaxQueueDepth > s.MaxQueueDepth {
+		s.MaxQueueDepth = other.MaxQueueDepth
+	}
+	s.Sent += other.Sent
+	s.Dropped += other.Dropped
+	s.Errors += other.Errors
+	return s
+}
+
+// sendJob is a packet queued for a single sender.
+type sendJob struct {
+	header  rtp.Header
+	payload []byte
+	// droppable is true for video packets which do not start a keyframe
+	droppable bool
+	// stream is written to, and told when the packet is dropped
+	stream *senderStream
+}
+
+// sendQueue writes packets of all tracks forwarded to a subscriber on a
+// goroutine of its own, so that a subscriber which cannot keep up does not
+// delay forwarding to other subscribers.
+//
+// When more than size packets are queued, the oldest video packets which do
+// not start a keyframe are dropped. Audio and keyframes are never dropped.
+// Streams which lost packets wait for the next keyframe, see trackWriter.
+type sendQueue struct {
+	log   Logger
+	size  int
+	write func(sendJob) error
+
+	notify       chan struct{}
+	closeChannel chan struct{}
+	closeOnce    sync.Once
+
+	mu    sync.Mutex
+	jobs  []sendJob
+	stats SendQueueStats
+}
+
+// newSendQueue creates a queue of size packets which are written by write.
+func newSendQueue(log Logger, size int, write func(sendJob) error) *sendQueue {
+	q := &sendQueue{
+		log:          log,
+		size:         size,
+		write:        write,
+		notify:       make(chan struct{}, 1),
+		closeChannel: make(chan struct{}),
+	}
+
+	go q.run()
+
+	return q
+}
+
+// Push queues job. It never blocks. Jobs pushed after Close are discarded.
+func (q *sendQueue) Push(job sendJob) {
+	select {
+	case <-q.closeChannel:
+		return
+	default:
+	}
+
+	q.mu.Lock()
+
+	if len(q.jobs) >= q.size && !q.dropOldest() && job.droppable {
+		q.drop(job)
+		q.mu.Unlock()
+		return
+	}
+
+	q.jobs = append(q.jobs, job)
+	if len(q.jobs) > q.stats.MaxQueueDepth {
+		q.stats.MaxQueueDepth = len(q.jobs)
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// dropOldest removes the oldest droppable job from the queue. Returns false
+// when there is none. Must be called with q.mu held.
+func (q *sendQueue) dropOldest() bool {
+	for i, job := range q.jobs {
+		if job.droppable {
+			copy(q.jobs[i:], q.jobs[i+1:])
+			q.jobs[len(q.jobs)-1] = sendJob{}
+			q.jobs = q.jobs[:len(q.jobs)-1]
+			q.drop(job)
+			return true
+		}
+	}
+	return false
+}
+
+// drop counts a dropped job and tells its stream. Must be called with q.mu
+// held.
+func (q *sendQueue) drop(job sendJob) {
+	q.stats.Dropped++
+	if job.stream != nil {
+		job.stream.setDropped()
+	}
+}
+
+// Stats returns a snapshot of the counters of the queue.
+func (q *sendQueue) Stats() SendQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := q.stats
+	stats.Queued = len(q.jobs)
+	return stats
+}
+
+// Close stops writing packets. Queued packets are discarded.
+func (q *sendQueue) Close() {
+	q.closeOnce.Do(func() {
+		close(q.closeChannel)
+
+		q.mu.Lock()
+		q.jobs = nil
+		q.mu.Unlock()
+	})
+}
+
+func (q *sendQueue) run() {
+	for {
+		select {
+		case <-q.notify:
+		case <-q.closeChannel:
+			return
+		}
+
+		for job, ok := q.next(); ok; job, ok = q.next() {
+			select {
+			case <-q.closeChannel:
+				return
+			default:
+			}
+
+			err := q.write(job)
+
+			q.mu.Lock()
+			if err != nil {
+				q.stats.Errors++
+			} else {
+				q.stats.Sent++
+			}
+			q.mu.Unlock()
+
+			if err != nil {
+				q.log.Debugf("Error writing packet to sender: %s", err)
+			}
+		}
+	}
+}
+
+// next removes the first job from the queue. Returns false when the queue
+// is empty.
+func (q *sendQueue) next() (sendJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.jobs) == 0 {
+		return sendJob{}, false
+	}
+
+	job := q.jobs[0]
+	q.jobs[0] = sendJob{}
+	q.jobs = q.jobs[1:]
+	return job, true
+}
+
+// writeSendJob writes the packet of job to the track of its stream.
+func writeSendJob(job sendJob) error {
+	return job.stream.write(job.header, job.payload)
+}
+
+// setDropped marks that packets of the stream were dropped.
+func (s *senderStream) setDropped() {
+	atomic.StoreInt32(&s.dropped, 1)
+}
+
+// takeDropped returns true when packets of the stream were dropped since the
+// last call.
+func (s *senderStream) takeDropped() bool {
+	return atomic.SwapInt32(&s.dropped, 0) == 1
+}
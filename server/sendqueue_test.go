@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server/logger"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSendQueue(t *testing.T, size int, write func(sendJob) error) *sendQueue {
+	t.Helper()
+	log := logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout).GetLogger("test")
+	return newSendQueue(log, size, write)
+}
+
+func newTestSendJob(seq uint16, droppable bool, stream *senderStream) sendJob {
+	return sendJob{
+		header:    rtp.Header{SequenceNumber: seq},
+		droppable: droppable,
+		stream:    stream,
+	}
+}
+
+func TestSendQueue_dropPolicy(t *testing.T) {
+	written := make(chan uint16, 10)
+	unblock := make(chan struct{})
+	q := newTestSendQueue(t, 3, func(job sendJob) error {
+		<-unblock
+		written <- job.header.SequenceNumber
+		return nil
+	})
+	defer q.Close()
+
+	video := &senderStream{}
+
+	// the first job is taken off the queue by the writer, which blocks
+	q.Push(newTestSendJob(1, false, nil))
+	assert.Eventually(t, func() bool {
+		return q.Stats().Queued == 0
+	}, time.Second, time.Millisecond)
+
+	q.Push(newTestSendJob(2, true, video))
+	q.Push(newTestSendJob(3, false, nil))
+	q.Push(newTestSendJob(4, true, video))
+	assert.False(t, video.takeDropped())
+
+	// the queue is full, the oldest video packet is dropped
+	q.Push(newTestSendJob(5, false, nil))
+	assert.True(t, video.takeDropped())
+	// the next one, too
+	q.Push(newTestSendJob(6, false, nil))
+	assert.True(t, video.takeDropped())
+	// no video packet left to drop: droppable packets are dropped, others
+	// are queued regardless
+	q.Push(newTestSendJob(7, true, video))
+	assert.True(t, video.takeDropped())
+	q.Push(newTestSendJob(8, false, nil))
+
+	assert.Equal(t, SendQueueStats{Queued: 4, MaxQueueDepth: 4, Dropped: 3}, q.Stats())
+
+	close(unblock)
+	var seqs []uint16
+	for i := 0; i < 5; i++ {
+		select {
+		case seq := <-written:
+			seqs = append(seqs, seq)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for packets to be written")
+		}
+	}
+	assert.Equal(t, []uint16{1, 3, 5, 6, 8}, seqs)
+	assert.Eventually(t, func() bool {
+		return q.Stats().Sent == 5
+	}, time.Second, time.Millisecond)
+}
+
+func TestSendQueue_writeError(t *testing.T) {
+	q := newTestSendQueue(t, 10, func(job sendJob) error {
+		return errors.New("test")
+	})
+	defer q.Close()
+
+	q.Push(newTestSendJob(1, false, nil))
+	q.Push(newTestSendJob(2, false, nil))
+
+	assert.Eventually(t, func() bool {
+		return q.Stats().Errors == 2
+	}, time.Second, time.Millisecond)
+}
+
+func TestSendQueue_Close(t *testing.T) {
+	written := make(chan uint16, 10)
+	q := newTestSendQueue(t, 10, func(job sendJob) error {
+		written <- job.header.SequenceNumber
+		return nil
+	})
+
+	q.Close()
+	q.Push(newTestSendJob(1, false, nil))
+
+	select {
+	case <-written:
+		t.Fatal("packet written after close")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
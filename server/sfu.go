@@ -1,10 +1,12 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
 	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/pion/logging"
@@ -40,16 +42,16 @@ func (p pionLoggerFactory) NewLogger(subsystem string) logging.LeveledLogger {
 }
 
 func (p *pionLogger) Trace(msg string) {
-	p.traceLogger.Println(msg)
+	p.traceLogger.Debugf("%s", msg)
 }
 func (p *pionLogger) Tracef(format string, args ...interface{}) {
-	p.traceLogger.Printf(format, args...)
+	p.traceLogger.Debugf(format, args...)
 }
 func (p *pionLogger) Debug(msg string) {
-	p.debugLogger.Println(msg)
+	p.debugLogger.Debugf("%s", msg)
 }
 func (p *pionLogger) Debugf(format string, args ...interface{}) {
-	p.debugLogger.Printf(format, args...)
+	p.debugLogger.Debugf(format, args...)
 }
 func (p *pionLogger) Info(msg string) {
 	p.infoLogger.Println(msg)
@@ -58,33 +60,54 @@ func (p *pionLogger) Infof(format string, args ...interface{}) {
 	p.infoLogger.Printf(format, args...)
 }
 func (p *pionLogger) Warn(msg string) {
-	p.warnLogger.Println(msg)
+	p.warnLogger.Warnf("%s", msg)
 }
 func (p *pionLogger) Warnf(format string, args ...interface{}) {
-	p.warnLogger.Printf(format, args...)
+	p.warnLogger.Warnf(format, args...)
 }
 func (p *pionLogger) Error(msg string) {
-	p.errorLogger.Println(msg)
+	p.errorLogger.Errorf("%s", msg)
 }
 func (p *pionLogger) Errorf(format string, args ...interface{}) {
-	p.errorLogger.Printf(format, args...)
+	p.errorLogger.Errorf(format, args...)
 }
 
 const serverIsInitiator = true
 
+const defaultNegotiationDebounce = 20 * time.Millisecond
+
 func NewSFUHandler(
 	loggerFactory LoggerFactory,
 	wss *WSS,
-	iceServers []ICEServer,
+	iceServers func() []ICEServer,
 	sfuConfig NetworkConfigSFU,
 	tracksManager TracksManager,
+	sdpHooks *SDPHooks,
 ) http.Handler {
+	return wss.WebSocketHandler(NewSFUSignalingHandler(loggerFactory, wss, iceServers, sfuConfig, tracksManager, sdpHooks))
+}
+
+// NewSFUSignalingHandler creates a handler for clients of the SFU, which can
+// be served over any signaling transport.
+func NewSFUSignalingHandler(
+	loggerFactory LoggerFactory,
+	wss *WSS,
+	iceServers func() []ICEServer,
+	sfuConfig NetworkConfigSFU,
+	tracksManager TracksManager,
+	sdpHooks *SDPHooks,
+) SignalingHandler {
 	log := loggerFactory.GetLogger("sfu")
 
-	fn := func(w http.ResponseWriter, r *http.Request) {
+	negotiationDebounce := sfuConfig.NegotiationDebounce
+	if negotiationDebounce == 0 {
+		negotiationDebounce = defaultNegotiationDebounce
+	}
+
+	fn := func(conn SignalingConn, req SignalingRequest) {
 
 		webrtcICEServers := []webrtc.ICEServer{}
-		for _, iceServer := range GetICEAuthServers(iceServers) {
+		for _, iceServer := range GetICEAuthServers(iceServers()) {
 			var c webrtc.ICECredentialType
 			if iceServer.Username != "" && iceServer.Credential != "" {
 				c = webrtc.ICECredentialTypePassword
@@ -98,24 +121,17 @@ func NewSFUHandler(
 		}
 
 		webrtcConfig := webrtc.Configuration{
-			ICEServers: webrtcICEServers,
-		}
-
-		allowedInterfaces := map[string]struct{}{}
-		for _, iface := range sfuConfig.Interfaces {
-			allowedInterfaces[iface] = struct{}{}
+			ICEServers:   webrtcICEServers,
+			Certificates: wss.DTLSCertificates().Certificates(),
 		}
+		sfuConfig.PeerConnection.configure(&webrtcConfig)
 
-		settingEngine := webrtc.SettingEngine{
-			LoggerFactory: newPionLoggerFactory(loggerFactory),
-		}
-		if len(allowedInterfaces) > 0 {
-			settingEngine.SetInterfaceFilter(func(iface string) bool {
-				_, ok := allowedInterfaces[iface]
-				return ok
-			})
+		settingEngine, err := newSettingEngine(loggerFactory, sfuConfig)
+		if err != nil {
+			log.Printf("Error configuring ICE: %s", err)
+			conn.Close(err)
+			return
 		}
-		// settingEngine.SetTrickle(true)
 		api := webrtc.NewAPI(
 			webrtc.WithMediaEngine(webrtc.MediaEngine{}),
 			webrtc.WithSettingEngine(settingEngine),
@@ -129,11 +145,16 @@ func NewSFUHandler(
 		mediaEngine, ok := unsafeField.Interface().(*webrtc.MediaEngine)
 		if !ok {
 			log.Printf("Error in hack to obtain mediaEngine")
-			w.WriteHeader(http.StatusInternalServerError)
+			conn.Close(errors.New("mediaEngine not found"))
 			return
 		}
 
 		var signaller *Signaller
+		// releasePeerConnection releases the quota of the peer connection of
+		// signaller
+		releasePeerConnection := func() {}
+		// signalingConn is the data channel the websocket can be handed over to
+		var signalingConn *DataChannelConn
 		var signallerMu sync.Mutex
 
 		cleanup := func(event CleanupEvent) {
@@ -185,9 +206,31 @@ func NewSFUHandler(
 			case "ready":
 				log.Printf("[%s] Initiator: %s", clientID, initiator)
 
+				if signaller == nil && !tracksManager.HasPeer(clientID) {
+					// peers replacing their own peer connection are not new
+					// publishers
+					if joinErr := wss.checkNodeCapacity(); joinErr != nil {
+						wss.refusePublisher(room, clientID, joinErr)
+						break
+					}
+				}
+
+				if signaller == nil {
+					var joinErr *JoinError
+					releasePeerConnection, joinErr = wss.rateLimits.quotas.acquirePeerConnection(req.IP, time.Now())
+					if joinErr != nil {
+						log.Printf("[%s] Not creating peer connection: %s", clientID, joinErr)
+						err = adapter.Emit(clientID, NewMessage(MessageTypeQuotaExceeded, room, joinErr))
+						break
+					}
+				}
+
 				peerConnection, pcErr := api.NewPeerConnection(webrtcConfig)
 				if pcErr != nil {
 					err = fmt.Errorf("[%s] Error creating peer connection: %s", clientID, pcErr)
+					if signaller == nil {
+						releasePeerConnection()
+					}
 					break
 				}
 				peerConnection.OnICEGatheringStateChange(func(state webrtc.ICEGathererState) {
@@ -203,18 +246,55 @@ func NewSFUHandler(
 					log.Printf("[%s] Error retrieving clients: %s", clientID, err)
 				}
 
-				broadcastErr := adapter.Broadcast(
-					NewMessage("users", room, map[string]interface{}{
-						"initiator": initiator,
-						"peerIds":   []string{localPeerID},
-						"nicknames": clients,
-					}),
-				)
+				if sfuConfig.DirectTwoParty && len(clients) <= 2 && len(tracksManager.RoomPeers(room)) == 0 && wss.directCallAllowed(sfuConfig, room) {
+					// peers connect to each other like in mesh mode
+					log.Printf("[%s] Direct call in room: %s", clientID, room)
+					peerConnection.Close()
+					if signaller == nil {
+						releasePeerConnection()
+						releasePeerConnection = func() {}
+					}
+					err = adapter.Broadcast(
+						NewMessage("users", room, map[string]interface{}{
+							"initiator": clientID,
+							"peerIds":   clientsToPeerIDs(clients),
+							"nicknames": clients,
+							"e2ee":      sfuConfig.IsE2EERoom(room),
+						}),
+					)
+					break
+				}
+
+				users := map[string]interface{}{
+					"initiator": initiator,
+					"peerIds":   []string{localPeerID},
+					"nicknames": clients,
+					"e2ee":      sfuConfig.IsE2EERoom(room),
+				}
+				if sfuConfig.DirectTwoParty {
+					// clients still connected directly close their connections
+					// and send ready again to connect to the SFU
+					users["closePeerIds"] = clientsToPeerIDs(clients)
+				}
+				if sfuConfig.DataChannelSignaling {
+					users["signalingDataChannel"] = true
+				}
+				broadcastErr := adapter.Broadcast(NewMessage("users", room, users))
 				if broadcastErr != nil {
 					log.Printf("[%s] Error broadcasting users message: %s", clientID, err)
 					break
 				}
 
+				if messages := tracksManager.ChatHistory(room); len(messages) > 0 {
+					// replay messages sent before the client joined
+					historyErr := adapter.Emit(clientID, NewMessage("chatHistory", room, map[string]interface{}{
+						"messages": messages,
+					}))
+					if historyErr != nil {
+						log.Printf("[%s] Error sending chat history: %s", clientID, historyErr)
+					}
+				}
+
 				var dataChannel *webrtc.DataChannel
 				if initiator == localPeerID {
 					// need to do this to connect with simple peer
@@ -229,6 +309,24 @@ func NewSFUHandler(
 				// TODO use this to get all client IDs and request all tracks of all users
 				// adapter.Clients()
 				if signaller == nil {
+					span := wss.tracer.Join(clientID).Child("sfu.negotiate")
+					span.SetAttribute("initiator", initiator)
+					videoCodecs := sfuConfig.Codecs.RoomVideoCodecs(room)
+					var peerSignalingConn *DataChannelConn
+					if sfuConfig.DataChannelSignaling {
+						var dcErr error
+						peerSignalingConn, dcErr = NewSignalingDataChannel(peerConnection)
+						if dcErr != nil {
+							log.Printf("[%s] Error creating signaling data channel: %s", clientID, dcErr)
+						}
+						signalingConn = peerSignalingConn
+					}
+					if initiator == localPeerID {
+						RegisterCodecs(mediaEngine, videoCodecs)
+						if sfuConfig.FEC {
+							RegisterFECCodecs(mediaEngine)
+						}
+					}
 					signaller, err = NewSignaller(
 						loggerFactory,
 						initiator == localPeerID,
@@ -239,13 +337,47 @@ func NewSFUHandler(
 					)
 					if err != nil {
 						err = fmt.Errorf("[%s] Error initializing signaller: %s", clientID, err)
+						span.SetError(err)
+						span.End()
+						releasePeerConnection()
 						break
 					}
+					signaller.SetVideoCodecs(videoCodecs)
+					signaller.SetOpusParameters(sfuConfig.Opus.Parameters())
+					if sfuConfig.Bandwidth.Advertise {
+						signaller.SetMaxBitrate(sfuConfig.Bandwidth.RoomMaxBitrate(room))
+					}
+					signaller.SetNegotiationDebounce(negotiationDebounce)
+					signaller.SetPeerConnectionConfig(sfuConfig.PeerConnection)
+					if policy := wss.ConnectionPolicy(); policy != nil {
+						signaller.SetCandidateFilter(func(candidate string) bool {
+							return policy.AllowCandidate(room, candidate)
+						})
+					}
+					signaller.SetHeaderExtensions(sfuConfig.HeaderExtensions)
+					if sfuConfig.DisconnectGracePeriod > 0 {
+						signaller.SetDisconnectGracePeriod(sfuConfig.DisconnectGracePeriod)
+					}
+					signaller.OnConnectionState(func(state PeerConnectionState) {
+						err := adapter.Broadcast(NewMessage(MessageTypePeerConnectionState, room, PeerConnectionStateEvent{
+							ClientID: clientID,
+							State:    state,
+						}))
+						if err != nil {
+							log.Printf("[%s] Error broadcasting peer connection state: %s", clientID, err)
+						}
+					})
+					signaller.TraceNegotiation(span)
 					signalChannel := signaller.SignalChannel()
 					tracksManager.Add(room, clientID, peerConnection, dataChannel, signaller)
+					hookParams := SDPHookParams{Room: room, ClientID: clientID}
+					release := releasePeerConnection
 					go func() {
 						for signal := range signalChannel {
-							err := adapter.Emit(clientID, NewMessage("signal", room, signal))
+							signal = sdpHooks.applyToPayload(hookParams, signal)
+							msg := NewMessage("signal", room, signal)
+							msg.TraceParent = span.TraceParent()
+							err := adapter.Emit(clientID, msg)
 							if err != nil {
 								log.Printf("[%s] Error sending local signal: %s", clientID, err)
 								// TODO abort connection
@@ -255,6 +387,12 @@ func NewSFUHandler(
 						signallerMu.Lock()
 						defer signallerMu.Unlock()
 						signaller = nil
+						release()
+						if peerSignalingConn != nil {
+							// ends the connection of clients which closed their websocket
+							peerSignalingConn.Close()
+							signalingConn = nil
+						}
 						log.Printf("[%s] Peer connection closed, emitting hangUp event", clientID)
 						adapter.SetMetadata(clientID, "")
 
@@ -269,8 +407,74 @@ func NewSFUHandler(
 						return
 					}()
 				}
+			case "trackLanguage":
+				payload, _ := msg.Payload.(map[string]interface{})
+				trackID, _ := payload["trackId"].(string)
+				language, _ := payload["language"].(string)
+				err = tracksManager.SetTrackLanguage(clientID, trackID, language)
+			case "e2eeKey":
+				err = relayE2EEKey(adapter, room, clientID, msg, sfuConfig.IsE2EERoom(room))
+			case "trackSource":
+				payload, _ := msg.Payload.(map[string]interface{})
+				trackID, _ := payload["trackId"].(string)
+				source, _ := payload["source"].(string)
+				err = tracksManager.SetTrackSource(clientID, trackID, TrackSource(source))
+			case "languageFilter":
+				payload, _ := msg.Payload.(map[string]interface{})
+				language, _ := payload["language"].(string)
+				err = tracksManager.SetLanguageFilter(clientID, language)
+			case "subscribe":
+				payload, _ := msg.Payload.(map[string]interface{})
+				if all, _ := payload["all"].(bool); all {
+					err = tracksManager.SubscribeAll(clientID)
+				} else {
+					err = tracksManager.Subscribe(clientID, getStringSlice(payload["userIds"]))
+				}
+			case "unsubscribe":
+				payload, _ := msg.Payload.(map[string]interface{})
+				err = tracksManager.Unsubscribe(clientID, getStringSlice(payload["userIds"]))
+			case "audioOnly":
+				payload, _ := msg.Payload.(map[string]interface{})
+				enabled, _ := payload["enabled"].(bool)
+				err = tracksManager.SetAudioOnly(clientID, enabled)
+			case MessageTypeRequestFloor:
+				var granted bool
+				granted, err = tracksManager.RequestFloor(clientID)
+				if err == nil && !granted {
+					err = adapter.Emit(clientID, NewMessage(MessageTypeFloorDenied, room, nil))
+				}
+			case MessageTypeReleaseFloor:
+				err = tracksManager.ReleaseFloor(clientID)
+			case "videoPaused":
+				payload, _ := msg.Payload.(map[string]interface{})
+				paused, _ := payload["paused"].(bool)
+				err = tracksManager.SetVideoPaused(clientID, paused)
+			case "pin":
+				payload, _ := msg.Payload.(map[string]interface{})
+				publisherID, _ := payload["userId"].(string)
+				trackID, _ := payload["trackId"].(string)
+				seconds, _ := payload["duration"].(float64)
+				duration := time.Duration(seconds * float64(time.Second))
+				err = tracksManager.SetPin(clientID, publisherID, trackID, duration)
+			case "handover":
+				switch {
+				case signalingConn == nil:
+					err = fmt.Errorf("[%s] handover: no signaling data channel", clientID)
+				case !signalingConn.Ready():
+					err = fmt.Errorf("[%s] handover: signaling data channel is not open", clientID)
+				case !wss.Handover(room, clientID, signalingConn):
+					err = fmt.Errorf("[%s] handover: client is not connected", clientID)
+				default:
+					// the first message sent over the data channel tells the client
+					// it can close its websocket
+					err = adapter.Emit(clientID, NewMessage("handover", room, nil))
+				}
 			case "signal":
 				payload, _ := msg.Payload.(map[string]interface{})
+				if targetClientID, _ := payload["userId"].(string); sfuConfig.DirectTwoParty && targetClientID != localPeerID {
+					err = relaySignal(adapter, room, clientID, payload)
+					break
+				}
 				if signaller == nil {
 					err = fmt.Errorf("[%s] Ignoring signal because signaller is not initialized", clientID)
 				} else {
@@ -283,7 +487,69 @@ func NewSFUHandler(
 			}
 		}
 
-		wss.HandleRoomWithCleanup(w, r, handleMessage, cleanup)
+		wss.ServeRoom(conn, req, handleMessage, cleanup)
+	}
+	return SignalingHandlerFunc(fn)
+}
+
+// directCallAllowed returns false for rooms whose media has to pass through
+// the SFU: rooms which are being recorded, have running egresses or are
+// end-to-end encrypted.
+func (wss *WSS) directCallAllowed(sfuConfig NetworkConfigSFU, room string) bool {
+	if sfuConfig.IsE2EERoom(room) {
+		return false
+	}
+	if wss.recorder != nil {
+		if _, recording := wss.recorder.Recording(room); recording {
+			return false
+		}
+	}
+	return len(wss.egress.List(room)) == 0
+}
+
+func getStringSlice(value interface{}) (result []string) {
+	values, _ := value.([]interface{})
+	for _, v := range values {
+		if str, ok := v.(string); ok {
+			result = append(result, str)
+		}
+	}
+	return
+}
+
+// relayE2EEKey forwards key distribution metadata from clientID to the peer
+// identified by the userId field of the payload, or to all other peers in
+// the room when it is empty. The rest of the payload is opaque to the server.
+func relayE2EEKey(adapter Adapter, room string, clientID string, msg Message, e2ee bool) error {
+	if !e2ee {
+		return fmt.Errorf("[%s] e2eeKey: room %s is not end-to-end encrypted", clientID, room)
+	}
+
+	payload, _ := msg.Payload.(map[string]interface{})
+	targetClientID, _ := payload["userId"].(string)
+
+	relayed := make(map[string]interface{}, len(payload))
+	for key, value := range payload {
+		relayed[key] = value
+	}
+	relayed["userId"] = clientID
+
+	relayMsg := NewMessage("e2eeKey", room, relayed)
+	if targetClientID != "" {
+		return adapter.Emit(targetClientID, relayMsg)
+	}
+
+	clients, err := adapter.Clients()
+	if err != nil {
+		return fmt.Errorf("[%s] e2eeKey: error retrieving clients: %w", clientID, err)
+	}
+	for otherClientID := range clients {
+		if otherClientID == clientID {
+			continue
+		}
+		if err := adapter.Emit(otherClientID, relayMsg); err != nil {
+			return err
+		}
 	}
-	return http.HandlerFunc(fn)
+	return nil
 }
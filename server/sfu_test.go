@@ -2,11 +2,14 @@ package server_test
 
 import (
 	"context"
+	"fmt"
 	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/peer-calls/peer-calls/pkg/client"
 	"github.com/peer-calls/peer-calls/server"
+	"github.com/pion/rtp/codecs"
 	"github.com/pion/webrtc/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -17,9 +20,10 @@ func setupSFUServer(rooms server.RoomManager) (s *httptest.Server, url string) {
 	handler := server.NewSFUHandler(
 		loggerFactory,
 		server.NewWSS(loggerFactory, rooms),
-		[]server.ICEServer{},
+		func() []server.ICEServer { return nil },
 		server.NetworkConfigSFU{},
-		server.NewMemoryTracksManager(loggerFactory),
+		server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{}),
+		nil,
 	)
 	s = httptest.NewServer(handler)
 	url = "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + roomName + "/" + clientID
@@ -138,3 +142,338 @@ loop:
 		t.Errorf("context timeout: %s", ctx.Err())
 	}
 }
+
+func TestWS_P2S_E2EEKey(t *testing.T) {
+	newAdapter := server.NewAdapterFactory(loggerFactory, server.StoreConfig{})
+	defer newAdapter.Close()
+	rooms := server.NewAdapterRoomManager(newAdapter.NewAdapter)
+	sfuConfig := server.NetworkConfigSFU{E2EERooms: []string{roomName}}
+	handler := server.NewSFUHandler(
+		loggerFactory,
+		server.NewWSS(loggerFactory, rooms),
+		func() []server.ICEServer { return nil },
+		sfuConfig,
+		server.NewMemoryTracksManager(loggerFactory, sfuConfig),
+		nil,
+	)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/" + roomName + "/"
+	ws1 := mustDialWS(t, ctx, wsURL+"user1")
+	defer ws1.Close(websocket.StatusNormalClosure, "")
+	ws2 := mustDialWS(t, ctx, wsURL+"user2")
+	defer ws2.Close(websocket.StatusNormalClosure, "")
+
+	client2 := server.NewClientWithID(ws2, "user2")
+	msgChan := client2.Subscribe(ctx)
+
+	// wait until both clients have joined
+	for msg := range msgChan {
+		if msg.Type == "ws_room_join" {
+			break
+		}
+	}
+
+	mustWriteWS(t, ctx, ws1, server.NewMessage("e2eeKey", roomName, map[string]interface{}{
+		"userId": "user2",
+		"keyId":  float64(1),
+		"key":    "encrypted-key",
+	}))
+
+	for msg := range msgChan {
+		if msg.Type != "e2eeKey" {
+			continue
+		}
+		assert.Equal(t, map[string]interface{}{
+			"userId": "user1",
+			"keyId":  float64(1),
+			"key":    "encrypted-key",
+		}, msg.Payload)
+		return
+	}
+	t.Fatalf("did not receive e2eeKey message: %s", ctx.Err())
+}
+
+// mustReadWSType reads messages from ws until one of msgType is received.
+func mustReadWSType(t testing.TB, ctx context.Context, ws *websocket.Conn, msgType string) server.Message {
+	t.Helper()
+	for {
+		if msg := mustReadWS(t, ctx, ws); msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+func TestWS_P2S_DirectTwoParty(t *testing.T) {
+	newAdapter := server.NewAdapterFactory(loggerFactory, server.StoreConfig{})
+	defer newAdapter.Close()
+	rooms := server.NewAdapterRoomManager(newAdapter.NewAdapter)
+	sfuConfig := server.NetworkConfigSFU{DirectTwoParty: true}
+	handler := server.NewSFUHandler(
+		loggerFactory,
+		server.NewWSS(loggerFactory, rooms),
+		func() []server.ICEServer { return nil },
+		sfuConfig,
+		server.NewMemoryTracksManager(loggerFactory, sfuConfig),
+		nil,
+	)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/" + roomName + "/"
+	ready := func(ws *websocket.Conn) {
+		mustWriteWS(t, ctx, ws, server.NewMessage("ready", roomName, map[string]interface{}{
+			"nickname": "user",
+		}))
+	}
+
+	ws1 := mustDialWS(t, ctx, wsURL+"user1")
+	defer ws1.Close(websocket.StatusNormalClosure, "")
+	ready(ws1)
+	payload := mustReadWSType(t, ctx, ws1, "users").Payload.(map[string]interface{})
+	assert.Equal(t, "user1", payload["initiator"])
+
+	ws2 := mustDialWS(t, ctx, wsURL+"user2")
+	defer ws2.Close(websocket.StatusNormalClosure, "")
+	ready(ws2)
+	payload = mustReadWSType(t, ctx, ws1, "users").Payload.(map[string]interface{})
+	assert.Equal(t, "user2", payload["initiator"])
+	assert.ElementsMatch(t, []interface{}{"user1", "user2"}, payload["peerIds"])
+	assert.Equal(t, false, payload["e2ee"])
+
+	// signals between the two peers are relayed
+	mustWriteWS(t, ctx, ws1, server.NewMessage("signal", roomName, map[string]interface{}{
+		"userId": "user2",
+		"signal": map[string]interface{}{"type": "offer", "sdp": "test"},
+	}))
+	signal := mustReadWSType(t, ctx, ws2, "signal")
+	assert.Equal(t, map[string]interface{}{
+		"userId": "user1",
+		"signal": map[string]interface{}{"type": "offer", "sdp": "test"},
+	}, signal.Payload)
+
+	// a third peer moves the call to the SFU
+	ws3 := mustDialWS(t, ctx, wsURL+"user3")
+	defer ws3.Close(websocket.StatusNormalClosure, "")
+	ready(ws3)
+	payload = mustReadWSType(t, ctx, ws1, "users").Payload.(map[string]interface{})
+	assert.Equal(t, "__SERVER__", payload["initiator"])
+	assert.Equal(t, []interface{}{"__SERVER__"}, payload["peerIds"])
+	assert.ElementsMatch(t, []interface{}{"user1", "user2", "user3"}, payload["closePeerIds"])
+}
+
+// recordingRecorder is recording all rooms.
+type recordingRecorder struct {
+	mockRecorder
+}
+
+func (recordingRecorder) Recording(room string) (server.Recording, bool) {
+	return server.Recording{Room: room}, true
+}
+
+func TestWS_P2S_DirectTwoParty_disabled(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		sfuConfig server.NetworkConfigSFU
+		recorder  server.RecordingController
+	}{
+		{"e2ee", server.NetworkConfigSFU{DirectTwoParty: true, E2EERooms: []string{roomName}}, nil},
+		{"recording", server.NetworkConfigSFU{DirectTwoParty: true}, recordingRecorder{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			newAdapter := server.NewAdapterFactory(loggerFactory, server.StoreConfig{})
+			defer newAdapter.Close()
+			rooms := server.NewAdapterRoomManager(newAdapter.NewAdapter)
+			wss := server.NewWSS(loggerFactory, rooms)
+			wss.SetModeration(nil, test.recorder)
+			handler := server.NewSFUHandler(
+				loggerFactory,
+				wss,
+				func() []server.ICEServer { return nil },
+				test.sfuConfig,
+				server.NewMemoryTracksManager(loggerFactory, test.sfuConfig),
+				nil,
+			)
+			srv := httptest.NewServer(handler)
+			defer srv.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			ws := mustDialWS(t, ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/"+roomName+"/user1")
+			defer ws.Close(websocket.StatusNormalClosure, "")
+			mustWriteWS(t, ctx, ws, server.NewMessage("ready", roomName, map[string]interface{}{
+				"nickname": "user",
+			}))
+
+			// media of the room has to pass through the SFU
+			payload := mustReadWSType(t, ctx, ws, "users").Payload.(map[string]interface{})
+			assert.Equal(t, "__SERVER__", payload["initiator"])
+			assert.Equal(t, []interface{}{"__SERVER__"}, payload["peerIds"])
+		})
+	}
+}
+
+func TestWS_P2S_SDPHooks(t *testing.T) {
+	newAdapter := server.NewAdapterFactory(loggerFactory, server.StoreConfig{})
+	defer newAdapter.Close()
+	rooms := server.NewAdapterRoomManager(newAdapter.NewAdapter)
+	sdpHooks := server.NewSDPHooks(loggerFactory)
+	hooked := make(chan server.SDPHookParams, 10)
+	sdpHooks.Use(
+		server.SDPHookFunc(func(params server.SDPHookParams, sd webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+			return sd, fmt.Errorf("test error")
+		}),
+		server.SDPHookFunc(func(params server.SDPHookParams, sd webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+			hooked <- params
+			sd.SDP += "a=x-hooked\r\n"
+			return sd, nil
+		}),
+	)
+	handler := server.NewSFUHandler(
+		loggerFactory,
+		server.NewWSS(loggerFactory, rooms),
+		func() []server.ICEServer { return nil },
+		server.NetworkConfigSFU{},
+		server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{}),
+		sdpHooks,
+	)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ws := mustDialWS(t, ctx, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/"+roomName+"/"+clientID)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	mustWriteWS(t, ctx, ws, server.NewMessage("ready", roomName, map[string]interface{}{
+		"nickname": "user",
+	}))
+
+	for {
+		payload := mustReadWSType(t, ctx, ws, "signal").Payload.(map[string]interface{})
+		signal := payload["signal"].(map[string]interface{})
+		if signal["type"] != "offer" {
+			continue
+		}
+		assert.True(t, strings.HasSuffix(signal["sdp"].(string), "a=x-hooked\r\n"), "offer was hooked")
+		break
+	}
+
+	assert.Equal(t, server.SDPHookParams{Room: roomName, ClientID: clientID}, <-hooked)
+}
+
+func TestWS_P2S_RoundTrip(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	srv, _ := setupSFUServer(rooms)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	video := readTestVideo(t)
+	audio := readTestAudio(t)
+
+	join := func(clientID string, onTrack func(*webrtc.Track, *webrtc.RTPReceiver)) *client.Client {
+		conn, err := client.DialWebSocket(ctx, srv.URL, server.SignalingHello{
+			Room:     roomName,
+			ClientID: clientID,
+		})
+		require.NoError(t, err)
+		c, err := client.Join(ctx, conn, client.Params{
+			Room:          roomName,
+			ClientID:      clientID,
+			LoggerFactory: loggerFactory,
+			OnTrack:       onTrack,
+		})
+		require.NoError(t, err)
+		return c
+	}
+
+	type sample struct {
+		kind webrtc.RTPCodecType
+		// err is set when the sample is not one of the fixtures
+		err error
+	}
+	received := make(chan sample, 16)
+	report := func(kind webrtc.RTPCodecType, err error) {
+		select {
+		case received <- sample{kind, err}:
+		case <-ctx.Done():
+		}
+	}
+
+	subscriber := join("subscriber", func(track *webrtc.Track, receiver *webrtc.RTPReceiver) {
+		var frame []byte
+		started := false
+		for {
+			packet, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+
+			if track.Kind() == webrtc.RTPCodecTypeAudio {
+				if !audio.isTestSample(packet.Payload) {
+					report(track.Kind(), fmt.Errorf("unexpected audio packet of %d bytes", len(packet.Payload)))
+					continue
+				}
+				report(track.Kind(), nil)
+				continue
+			}
+
+			vp8 := &codecs.VP8Packet{}
+			if _, err := vp8.Unmarshal(packet.Payload); err != nil {
+				report(track.Kind(), err)
+				continue
+			}
+			if vp8.S == 1 && vp8.PID == 0 {
+				started = true
+				frame = nil
+			}
+			if !started {
+				// the first frame forwarded can be incomplete
+				continue
+			}
+			frame = append(frame, vp8.Payload...)
+			if !packet.Marker {
+				continue
+			}
+			started = false
+			if !video.isTestSample(frame) {
+				report(track.Kind(), fmt.Errorf("unexpected video frame of %d bytes", len(frame)))
+				continue
+			}
+			report(track.Kind(), nil)
+		}
+	})
+	defer subscriber.Close()
+
+	publisher := join("publisher", nil)
+	defer publisher.Close()
+
+	for i, media := range []*testMedia{audio, video} {
+		track := media.newTrack(t, uint32(1000+i), fmt.Sprintf("track-%d", i), publisher.ID())
+		_, err := publisher.Publish(track)
+		require.NoError(t, err)
+		defer publishTestMedia(media, track)()
+	}
+
+	// a second of audio and video
+	want := map[webrtc.RTPCodecType]int{
+		webrtc.RTPCodecTypeAudio: len(audio.samples),
+		webrtc.RTPCodecTypeVideo: len(video.samples),
+	}
+	for want[webrtc.RTPCodecTypeAudio] > 0 || want[webrtc.RTPCodecTypeVideo] > 0 {
+		select {
+		case s := <-received:
+			require.NoError(t, s.err)
+			want[s.kind]--
+		case <-ctx.Done():
+			t.Fatalf("samples not received: %v: %s", want, ctx.Err())
+		}
+	}
+}
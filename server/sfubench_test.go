@@ -0,0 +1,307 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/pion/logging"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+// The benchmarks in this file measure negotiation through the SFU handler,
+// so that changes to signalling and track listeners can be checked for
+// regressions. Run them with `make bench`, which compares the results to the
+// baseline in testdata/sfubench.txt.
+
+// benchChurnPeers is the number of peers concurrently joining and leaving
+// other rooms while the benchmarks run.
+const benchChurnPeers = 4
+
+type benchSFU struct {
+	url    string
+	tracks *server.MemoryTracksManager
+	close  func()
+}
+
+func newBenchSFU(b *testing.B) *benchSFU {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{})
+	handler := server.NewSFUHandler(
+		loggerFactory,
+		server.NewWSS(loggerFactory, rooms),
+		func() []server.ICEServer { return nil },
+		server.NetworkConfigSFU{},
+		tracks,
+		nil,
+	)
+	s := httptest.NewServer(handler)
+
+	return &benchSFU{
+		url:    "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/",
+		tracks: tracks,
+		close:  s.Close,
+	}
+}
+
+// churn joins and leaves rooms other than the benchmarked ones until the
+// returned function is called.
+func (s *benchSFU) churn() (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	for i := 0; i < benchChurnPeers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ctx.Err() == nil; j++ {
+				room := fmt.Sprintf("churn-%d", i)
+				ws, _, err := websocket.Dial(ctx, s.url+room+fmt.Sprintf("/churn-%d-%d", i, j), nil)
+				if err != nil {
+					continue
+				}
+				client := server.NewClientWithID(ws, "")
+				msgChan := client.Subscribe(ctx)
+				_ = client.Write(server.NewMessage("ready", room, map[string]interface{}{
+					"nickname": "churn",
+				}))
+				for msg := range msgChan {
+					if msg.Type == "users" {
+						break
+					}
+				}
+				ws.Close(websocket.StatusNormalClosure, "")
+			}
+		}(i)
+	}
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// benchPeer is a client connected to the SFU through a websocket and a peer
+// connection.
+type benchPeer struct {
+	ws        *websocket.Conn
+	client    *server.Client
+	signaller *server.Signaller
+	// answers receives a value every time the peer answers an offer
+	answers chan struct{}
+	// packets receives a value when the first RTP packet of a track is read
+	packets chan struct{}
+	// connected is closed when the peer connection is connected
+	connected chan struct{}
+	cancel    context.CancelFunc
+}
+
+// joinBenchPeer joins room and waits until the first offer from the server
+// has been answered.
+func (s *benchSFU) joinBenchPeer(b *testing.B, room string, clientID string) *benchPeer {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ws := mustDialWS(b, ctx, s.url+room+"/"+clientID)
+	client := server.NewClientWithID(ws, clientID)
+	msgChan := client.Subscribe(ctx)
+	require.NoError(b, client.Write(server.NewMessage("ready", room, map[string]interface{}{
+		"nickname": clientID,
+	})))
+	for msg := range msgChan {
+		if msg.Type == "users" {
+			break
+		}
+	}
+
+	mediaEngine := webrtc.MediaEngine{}
+	mediaEngine.RegisterDefaultCodecs()
+	// errors of peers closed during negotiation would break benchmark output
+	pionLoggerFactory := logging.NewDefaultLoggerFactory()
+	pionLoggerFactory.DefaultLogLevel = logging.LogLevelDisabled
+	settingEngine := webrtc.SettingEngine{LoggerFactory: pionLoggerFactory}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithSettingEngine(settingEngine))
+	pc, err := api.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(b, err)
+	signaller, err := server.NewSignaller(loggerFactory, false, pc, &mediaEngine, clientID, "__SERVER__")
+	require.NoError(b, err)
+
+	p := &benchPeer{
+		ws:        ws,
+		client:    client,
+		signaller: signaller,
+		answers:   make(chan struct{}, 16),
+		packets:   make(chan struct{}, 16),
+		connected: make(chan struct{}),
+		cancel:    cancel,
+	}
+
+	var connectedOnce sync.Once
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			connectedOnce.Do(func() { close(p.connected) })
+		}
+	})
+
+	pc.OnTrack(func(track *webrtc.Track, receiver *webrtc.RTPReceiver) {
+		first := true
+		for {
+			if _, err := track.ReadRTP(); err != nil {
+				return
+			}
+			if first {
+				first = false
+				p.packets <- struct{}{}
+			}
+		}
+	})
+
+	go func() {
+		signalChan := signaller.SignalChannel()
+		for {
+			select {
+			case msg, ok := <-msgChan:
+				if !ok {
+					return
+				}
+				if msg.Type == "signal" {
+					payload, _ := msg.Payload.(map[string]interface{})
+					_ = signaller.Signal(payload)
+				}
+			case signal, ok := <-signalChan:
+				if !ok {
+					return
+				}
+				_ = client.Write(server.NewMessage("signal", room, signal))
+				if sd, ok := signal.Signal.(webrtc.SessionDescription); ok && sd.Type == webrtc.SDPTypeAnswer {
+					p.answers <- struct{}{}
+				}
+			}
+		}
+	}()
+
+	select {
+	case <-p.answers:
+	case <-time.After(timeout):
+		b.Fatalf("[%s] timed out waiting for the first offer", clientID)
+	}
+
+	return p
+}
+
+func (p *benchPeer) Close() {
+	p.signaller.Close()
+	p.ws.Close(websocket.StatusNormalClosure, "")
+	p.cancel()
+}
+
+// BenchmarkSFU_joins measures joining a room until the first offer of the
+// server is answered, with peers joining several rooms in parallel.
+func BenchmarkSFU_joins(b *testing.B) {
+	sfu := newBenchSFU(b)
+	defer sfu.close()
+	defer sfu.churn()()
+
+	var counter int64
+	b.ResetTimer()
+	start := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&counter, 1)
+			peer := sfu.joinBenchPeer(b, fmt.Sprintf("room-%d", i%4), fmt.Sprintf("peer-%d", i))
+			peer.Close()
+		}
+	})
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "joins/s")
+}
+
+// BenchmarkSFU_offers measures renegotiations requested by a peer, from the
+// request until the offer of the server is answered.
+func BenchmarkSFU_offers(b *testing.B) {
+	sfu := newBenchSFU(b)
+	defer sfu.close()
+	defer sfu.churn()()
+
+	peer := sfu.joinBenchPeer(b, roomName, clientID)
+	defer peer.Close()
+	select {
+	case <-peer.connected:
+	case <-time.After(timeout):
+		b.Fatalf("timed out waiting for connection")
+	}
+
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		require.NoError(b, peer.client.Write(server.NewMessage("signal", roomName, server.NewPayloadRenegotiate(clientID))))
+		select {
+		case <-peer.answers:
+		case <-time.After(timeout):
+			b.Fatalf("timed out waiting for offer")
+		}
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "offers/s")
+}
+
+// BenchmarkSFU_timeToFirstPacket measures joining a room until the first RTP
+// packet of a track published in the room is received.
+func BenchmarkSFU_timeToFirstPacket(b *testing.B) {
+	sfu := newBenchSFU(b)
+	defer sfu.close()
+	defer sfu.churn()()
+
+	codec := webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000)
+	track, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeOpus, rand.Uint32(), "bench", "bench", codec)
+	require.NoError(b, err)
+	sfu.tracks.AddServerTrack(roomName, track)
+	defer sfu.tracks.RemoveServerTrack(roomName, track)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		packet := &rtp.Packet{
+			Header: rtp.Header{
+				Version:     2,
+				PayloadType: webrtc.DefaultPayloadTypeOpus,
+				SSRC:        track.SSRC(),
+			},
+			Payload: []byte{0xfc, 0xff, 0xfe},
+		}
+		for {
+			select {
+			case <-ticker.C:
+				packet.SequenceNumber++
+				packet.Timestamp += 240
+				_ = track.WriteRTP(packet)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		peer := sfu.joinBenchPeer(b, roomName, fmt.Sprintf("peer-%d", i))
+		select {
+		case <-peer.packets:
+		case <-time.After(timeout):
+			b.Fatalf("timed out waiting for first packet")
+		}
+		b.StopTimer()
+		peer.Close()
+		b.StartTimer()
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/pion/webrtc/v2"
+)
+
+// newSettingEngine returns the SettingEngine of peer connections to the
+// SFU.
+func newSettingEngine(loggerFactory LoggerFactory, sfuConfig NetworkConfigSFU) (webrtc.SettingEngine, error) {
+	settingEngine := webrtc.SettingEngine{
+		LoggerFactory: newPionLoggerFactory(loggerFactory),
+	}
+
+	interfaceFilter, err := newInterfaceFilter(sfuConfig.Interfaces, sfuConfig.ExcludeInterfaces)
+	if err != nil {
+		return settingEngine, err
+	}
+	if interfaceFilter != nil {
+		settingEngine.SetInterfaceFilter(interfaceFilter)
+	}
+
+	if len(sfuConfig.NetworkTypes) > 0 {
+		networkTypes, err := parseNetworkTypes(sfuConfig.NetworkTypes)
+		if err != nil {
+			return settingEngine, err
+		}
+		settingEngine.SetNetworkTypes(networkTypes)
+	}
+
+	if err := sfuConfig.UDP.apply(&settingEngine); err != nil {
+		return settingEngine, err
+	}
+
+	if len(sfuConfig.NAT1To1IPs) > 0 {
+		if err := validateNAT1To1IPs(sfuConfig.NAT1To1IPs); err != nil {
+			return settingEngine, err
+		}
+		settingEngine.SetNAT1To1IPs(sfuConfig.NAT1To1IPs, webrtc.ICECandidateTypeHost)
+	}
+
+	if err := sfuConfig.PeerConnection.apply(&settingEngine); err != nil {
+		return settingEngine, err
+	}
+
+	settingEngine.SetTrickle(true)
+	return settingEngine, nil
+}
+
+// newInterfaceFilter returns a filter which allows only interfaces in
+// allowed, when it is not empty, and no interfaces matching any of the
+// exclude patterns. Returns nil when all interfaces are allowed.
+func newInterfaceFilter(allowed []string, exclude []string) (func(string) bool, error) {
+	if len(allowed) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+
+	for _, pattern := range exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid interface pattern: %q: %w", pattern, err)
+		}
+	}
+
+	allowedInterfaces := map[string]struct{}{}
+	for _, iface := range allowed {
+		allowedInterfaces[iface] = struct{}{}
+	}
+
+	return func(iface string) bool {
+		if _, ok := allowedInterfaces[iface]; len(allowedInterfaces) > 0 && !ok {
+			return false
+		}
+		for _, pattern := range exclude {
+			if matched, _ := path.Match(pattern, iface); matched {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// parseNetworkTypes parses the network types of candidates. Only UDP network
+// types are supported.
+func parseNetworkTypes(values []string) ([]webrtc.NetworkType, error) {
+	networkTypes := make([]webrtc.NetworkType, 0, len(values))
+	for _, value := range values {
+		networkType, err := webrtc.NewNetworkType(value)
+		if err != nil || (networkType != webrtc.NetworkTypeUDP4 && networkType != webrtc.NetworkTypeUDP6) {
+			return nil, fmt.Errorf("unsupported network type: %q", value)
+		}
+		networkTypes = append(networkTypes, networkType)
+	}
+	return networkTypes, nil
+}
+
+// validateNAT1To1IPs checks that each of ips is either a public IP, or a
+// public and a local IP separated by a slash.
+func validateNAT1To1IPs(ips []string) error {
+	for _, mapping := range ips {
+		for _, ip := range strings.SplitN(mapping, "/", 2) {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("invalid NAT 1:1 IP mapping: %q", mapping)
+			}
+		}
+	}
+	return nil
+}
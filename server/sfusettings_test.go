@@ -0,0 +1,145 @@
+package server
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server/logger"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateNAT1To1IPs(t *testing.T) {
+	assert.NoError(t, validateNAT1To1IPs([]string{"203.0.113.1", "2001:db8::1", "203.0.113.2/10.0.0.2"}))
+	assert.Error(t, validateNAT1To1IPs([]string{"example.com"}))
+	assert.Error(t, validateNAT1To1IPs([]string{"203.0.113.2/"}))
+	assert.Error(t, validateNAT1To1IPs([]string{"203.0.113.2/10.0.0.2/10.0.0.3"}))
+}
+
+func TestNewSettingEngine_invalid(t *testing.T) {
+	loggerFactory := logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout)
+
+	_, err := newSettingEngine(loggerFactory, NetworkConfigSFU{UDP: UDPConfig{PortMin: 2, PortMax: 1}})
+	assert.Error(t, err)
+
+	_, err = newSettingEngine(loggerFactory, NetworkConfigSFU{NAT1To1IPs: []string{"public"}})
+	assert.Error(t, err)
+
+	_, err = newSettingEngine(loggerFactory, NetworkConfigSFU{PeerConnection: PeerConnectionConfig{BundlePolicy: "max-compat"}})
+	assert.Error(t, err)
+
+	_, err = newSettingEngine(loggerFactory, NetworkConfigSFU{PeerConnection: PeerConnectionConfig{DTLSRole: "actpass"}})
+	assert.Error(t, err)
+}
+
+func TestNewSettingEngine_DTLSRole(t *testing.T) {
+	loggerFactory := logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout)
+
+	for role, setup := range map[string]string{
+		DTLSRoleAuto:   "a=setup:passive",
+		DTLSRoleClient: "a=setup:active",
+		DTLSRoleServer: "a=setup:passive",
+	} {
+		config := PeerConnectionConfig{DTLSRole: role}
+		settingEngine, err := newSettingEngine(loggerFactory, NetworkConfigSFU{PeerConnection: config})
+		require.NoError(t, err)
+
+		offerer, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		require.NoError(t, err)
+		defer offerer.Close()
+		_, err = offerer.CreateDataChannel("data", nil)
+		require.NoError(t, err)
+		offer, err := offerer.CreateOffer(nil)
+		require.NoError(t, err)
+
+		var webrtcConfig webrtc.Configuration
+		config.configure(&webrtcConfig)
+		answerer, err := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine)).NewPeerConnection(webrtcConfig)
+		require.NoError(t, err)
+		defer answerer.Close()
+		require.NoError(t, answerer.SetRemoteDescription(offer))
+		answer, err := answerer.CreateAnswer(nil)
+		require.NoError(t, err)
+		assert.Contains(t, answer.SDP, setup, "role: %s", role)
+	}
+}
+
+func TestNewSettingEngine_NAT1To1IPs(t *testing.T) {
+	loggerFactory := logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout)
+
+	settingEngine, err := newSettingEngine(loggerFactory, NetworkConfigSFU{NAT1To1IPs: []string{"203.0.113.1"}})
+	require.NoError(t, err)
+
+	pc, err := webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine)).NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	defer pc.Close()
+
+	candidates := make(chan *webrtc.ICECandidate, 16)
+	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		candidates <- c
+	})
+
+	_, err = pc.CreateDataChannel("data", nil)
+	require.NoError(t, err)
+	offer, err := pc.CreateOffer(nil)
+	require.NoError(t, err)
+	require.NoError(t, pc.SetLocalDescription(offer))
+
+	var addresses []string
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case c := <-candidates:
+			if c == nil {
+				require.NotEmpty(t, addresses, "no host candidates gathered")
+				for _, address := range addresses {
+					assert.Equal(t, "203.0.113.1", address)
+				}
+				return
+			}
+			if c.Typ == webrtc.ICECandidateTypeHost {
+				addresses = append(addresses, c.Address)
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for candidates")
+		}
+	}
+}
+
+func TestNewInterfaceFilter(t *testing.T) {
+	filter, err := newInterfaceFilter(nil, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, filter)
+
+	filter, err = newInterfaceFilter([]string{"eth0", "docker0"}, nil)
+	require.NoError(t, err)
+	assert.True(t, filter("eth0"))
+	assert.False(t, filter("eth1"))
+
+	filter, err = newInterfaceFilter(nil, []string{"docker*", "veth*"})
+	require.NoError(t, err)
+	assert.True(t, filter("eth0"))
+	assert.False(t, filter("docker0"))
+	assert.False(t, filter("veth1234"))
+
+	filter, err = newInterfaceFilter([]string{"eth0", "docker0"}, []string{"docker*"})
+	require.NoError(t, err)
+	assert.True(t, filter("eth0"))
+	assert.False(t, filter("docker0"))
+
+	_, err = newInterfaceFilter(nil, []string{"["})
+	assert.Error(t, err)
+}
+
+func TestParseNetworkTypes(t *testing.T) {
+	networkTypes, err := parseNetworkTypes([]string{"udp4", "udp6"})
+	assert.NoError(t, err)
+	assert.Equal(t, []webrtc.NetworkType{webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6}, networkTypes)
+
+	_, err = parseNetworkTypes([]string{"tcp4"})
+	assert.Error(t, err)
+	_, err = parseNetworkTypes([]string{"ipv6"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,39 @@
+package server
+
+// SFUStats contains the state of the workers and queues of the SFU.
+type SFUStats struct {
+	// FanOut contains the shards which apply track changes to subscribers.
+	FanOut []ShardStats `json:"fanOut"`
+	// TrackEvents is the sum of the track events of all peers.
+	TrackEvents TrackEventStats `json:"trackEvents"`
+	// SendQueue is the sum of the send queues of all peers.
+	SendQueue SendQueueStats `json:"sendQueue"`
+	// Forwarding contains the shards which forward packets of published
+	// tracks.
+	Forwarding []ShardStats `json:"forwarding"`
+}
+
+// SFUStats returns the state of the workers and queues of the SFU.
+func (t *MemoryTracksManager) SFUStats() SFUStats {
+	return SFUStats{
+		FanOut:      t.FanOutStats(),
+		TrackEvents: t.TrackEventStats(),
+		SendQueue:   t.SendQueueStats(),
+		Forwarding:  t.ForwardingStats(),
+	}
+}
+
+// SetSFUStats sets the function which returns the stats of the SFU served
+// by the admin API.
+func (wss *WSS) SetSFUStats(stats func() SFUStats) {
+	wss.sfuStats = stats
+}
+
+// SFUStats returns the stats of the SFU, or false when SetSFUStats has not
+// been called.
+func (wss *WSS) SFUStats() (SFUStats, bool) {
+	if wss.sfuStats == nil {
+		return SFUStats{}, false
+	}
+	return wss.sfuStats(), true
+}
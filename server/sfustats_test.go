@@ -0,0 +1,42 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmin_sfuStats(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	admin := server.AdminConfig{Token: adminToken}
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, admin, server.InactivityConfig{}, server.ClientConfig{}, rooms, newMockTracksManager())
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	var stats server.SFUStats
+	statusCode := adminGetJSON(t, s.URL+"/api/admin/sfu/stats", &stats)
+	assert.Equal(t, http.StatusNotFound, statusCode, "not set")
+
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{
+		FanOutWorkers:     2,
+		ForwardingWorkers: 3,
+	})
+	mux.WSS.SetSFUStats(tracks.SFUStats)
+	signaller := addTestPeer(t, tracks, roomName, clientID)
+	defer signaller.Close()
+
+	statusCode = adminGetJSON(t, s.URL+"/api/admin/sfu/stats", &stats)
+	assert.Equal(t, http.StatusOK, statusCode)
+	require.Len(t, stats.FanOut, 2)
+	assert.Equal(t, server.ShardStats{Shard: 1}, stats.FanOut[1])
+	assert.Equal(t, tracks.TrackEventStats(), stats.TrackEvents)
+	assert.Equal(t, tracks.SendQueueStats(), stats.SendQueue)
+	require.Len(t, stats.Forwarding, 3)
+	assert.Equal(t, server.ShardStats{Shard: 2}, stats.Forwarding[2])
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path"
+	"sync"
+
+	"nhooyr.io/websocket"
+)
+
+// ErrSignalingClosed is returned when reading from or writing to a
+// signaling connection after it was closed.
+var ErrSignalingClosed = errors.New("signaling connection closed")
+
+// SignalingConn is a connection of a client to the signaling server. Messages
+// are serialized the same way over all transports. Websockets are the
+// default transport, other transports are gRPC streams and length-prefixed
+// TCP connections.
+type SignalingConn interface {
+	WSReadWriter
+	// Close closes the transport. reason is nil when the connection is
+	// closed normally, a *JoinError when the client was rejected, and the
+	// error the connection failed with otherwise.
+	Close(reason error) error
+}
+
+// SignalingRequest describes the client joining a room over a signaling
+// connection.
+type SignalingRequest struct {
+	// Context ends when the connection is closed by the client. Defaults to
+	// context.Background.
+	Context  context.Context
+	Room     string
+	ClientID string
+	// IP is the address of the client, used to rate limit joins.
+	IP string
+	// Host is the host the client connected to, which selects its client
+	// config.
+	Host        string
+	Password    string
+	EgressToken string
+	// Token is the join token of a tenant, required when tenants are
+	// configured.
+	Token string
+	// TraceParent continues the trace of the client, when set.
+	TraceParent string
+}
+
+func (r SignalingRequest) context() context.Context {
+	if r.Context == nil {
+		return context.Background()
+	}
+	return r.Context
+}
+
+// SignalingHandler handles clients connected over any signaling transport.
+type SignalingHandler interface {
+	ServeSignaling(conn SignalingConn, req SignalingRequest)
+}
+
+// SignalingHandlerFunc is an adapter to use a function as a
+// SignalingHandler.
+type SignalingHandlerFunc func(conn SignalingConn, req SignalingRequest)
+
+func (f SignalingHandlerFunc) ServeSignaling(conn SignalingConn, req SignalingRequest) {
+	f(conn, req)
+}
+
+// WebSocketHandler accepts websocket connections to /ws/{room}/{clientID}
+// and passes them to handler.
+func (wss *WSS) WebSocketHandler(handler SignalingHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+			CompressionMode: websocket.CompressionDisabled,
+		})
+		if err != nil {
+			wss.log.Printf("Error accepting websocket connection: %s", err)
+			return
+		}
+
+		query := r.URL.Query()
+		handler.ServeSignaling(websocketSignalingConn{c}, SignalingRequest{
+			Context:     r.Context(),
+			Room:        path.Base(path.Dir(r.URL.Path)),
+			ClientID:    path.Base(r.URL.Path),
+			IP:          clientIP(r, wss.rateLimits.config.TrustProxy),
+			Host:        r.Host,
+			Password:    query.Get("password"),
+			EgressToken: query.Get("egressToken"),
+			Token:       query.Get("token"),
+			TraceParent: query.Get("traceparent"),
+		})
+	})
+}
+
+// websocketSignalingConn is the SignalingConn of websocket clients.
+type websocketSignalingConn struct {
+	*websocket.Conn
+}
+
+var _ SignalingConn = websocketSignalingConn{}
+
+// NewWebSocketSignalingConn returns the SignalingConn of a websocket
+// connection, for clients dialing the websocket transport.
+func NewWebSocketSignalingConn(conn *websocket.Conn) SignalingConn {
+	return websocketSignalingConn{conn}
+}
+
+func (c websocketSignalingConn) Close(reason error) error {
+	code, text := websocketCloseStatus(reason)
+	return c.Conn.Close(code, text)
+}
+
+// websocketCloseStatus returns the websocket close status and reason of a
+// signaling connection closed with reason. Rejected clients are closed with
+// the code of the join error as the reason.
+func websocketCloseStatus(reason error) (websocket.StatusCode, string) {
+	var joinErr *JoinError
+	switch {
+	case reason == nil:
+		return websocket.StatusNormalClosure, ""
+	case errors.As(reason, &joinErr):
+		return websocket.StatusTryAgainLater, joinErr.Code
+	default:
+		return websocket.StatusInternalError, ""
+	}
+}
+
+// SignalingHello is the first message of clients of the gRPC and TCP
+// signaling transports. It carries what websocket clients send in the URL.
+type SignalingHello struct {
+	Room        string `protobuf:"bytes,1,opt,name=room,proto3" json:"room"`
+	ClientID    string `protobuf:"bytes,2,opt,name=client_id,json=clientId,proto3" json:"clientId"`
+	Password    string `protobuf:"bytes,3,opt,name=password,proto3" json:"password,omitempty"`
+	EgressToken string `protobuf:"bytes,4,opt,name=egress_token,json=egressToken,proto3" json:"egressToken,omitempty"`
+	TraceParent string `protobuf:"bytes,5,opt,name=traceparent,proto3" json:"traceparent,omitempty"`
+	Token       string `protobuf:"bytes,6,opt,name=token,proto3" json:"token,omitempty"`
+}
+
+func (h SignalingHello) request(ctx context.Context, ip string) SignalingRequest {
+	return SignalingRequest{
+		Context:     ctx,
+		Room:        h.Room,
+		ClientID:    h.ClientID,
+		IP:          ip,
+		Password:    h.Password,
+		EgressToken: h.EgressToken,
+		Token:       h.Token,
+		TraceParent: h.TraceParent,
+	}
+}
+
+// streamSignalingConn adapts a transport which sends and receives whole
+// messages to a SignalingConn. Messages are received on a goroutine of their
+// own so that reads can be canceled.
+type streamSignalingConn struct {
+	send  func(ctx context.Context, data []byte) error
+	close func() error
+
+	writeMu  sync.Mutex
+	messages chan []byte
+	// err is set before received is closed
+	err       error
+	received  chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var _ SignalingConn = &streamSignalingConn{}
+
+func newStreamSignalingConn(
+	recv func() ([]byte, error),
+	send func(ctx context.Context, data []byte) error,
+	close func() error,
+) *streamSignalingConn {
+	c := &streamSignalingConn{
+		send:     send,
+		close:    close,
+		messages: make(chan []byte),
+		received: make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+
+	go c.receive(recv)
+
+	return c
+}
+
+func (c *streamSignalingConn) receive(recv func() ([]byte, error)) {
+	defer close(c.received)
+
+	for {
+		data, err := recv()
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		select {
+		case c.messages <- data:
+		case <-c.closed:
+			c.err = ErrSignalingClosed
+			return
+		}
+	}
+}
+
+func (c *streamSignalingConn) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	select {
+	case data := <-c.messages:
+		return websocket.MessageText, data, nil
+	case <-c.received:
+		return 0, nil, c.err
+	case <-c.closed:
+		return 0, nil, ErrSignalingClosed
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	}
+}
+
+func (c *streamSignalingConn) Write(ctx context.Context, typ websocket.MessageType, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	select {
+	case <-c.closed:
+		return ErrSignalingClosed
+	default:
+	}
+
+	return c.send(ctx, data)
+}
+
+// Close closes the transport. Clients of stream transports receive the join
+// error message before the connection is closed, so reason is not sent.
+func (c *streamSignalingConn) Close(reason error) (err error) {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.close()
+	})
+	return err
+}
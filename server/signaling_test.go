@@ -0,0 +1,111 @@
+package server_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestSignaling_TCPAndGRPC(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	wss := server.NewWSS(loggerFactory, rooms)
+	handler := server.NewMeshSignalingHandler(loggerFactory, wss)
+
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer tcpListener.Close()
+	go server.NewTCPSignalingServer(loggerFactory, handler).Serve(tcpListener)
+
+	grpcListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	rpc := server.NewSignalingRPCServer(loggerFactory, handler)
+	defer rpc.Stop()
+	go rpc.Serve(grpcListener)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	tcpConn, err := server.DialTCPSignaling(ctx, tcpListener.Addr().String(), server.SignalingHello{
+		Room:     roomName,
+		ClientID: "tcp-bot",
+	})
+	require.NoError(t, err)
+	tcpClient := server.NewClientWithID(tcpConn, "tcp-bot")
+	tcpMessages := tcpClient.Subscribe(ctx)
+
+	cc, err := grpc.Dial(grpcListener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer cc.Close()
+	grpcConn, err := server.DialGRPCSignaling(ctx, cc, server.SignalingHello{
+		Room:     roomName,
+		ClientID: "grpc-bot",
+	})
+	require.NoError(t, err)
+	grpcClient := server.NewClientWithID(grpcConn, "grpc-bot")
+	grpcMessages := grpcClient.Subscribe(ctx)
+
+	readType := func(messages <-chan server.Message, msgType string) server.Message {
+		t.Helper()
+		for msg := range messages {
+			if msg.Type == msgType {
+				return msg
+			}
+		}
+		t.Fatalf("did not receive %s message", msgType)
+		return server.Message{}
+	}
+
+	require.NoError(t, tcpClient.Write(server.NewMessage("ready", roomName, map[string]interface{}{
+		"nickname": "tcp",
+	})))
+	readType(tcpMessages, "users")
+
+	require.NoError(t, grpcClient.Write(server.NewMessage("ready", roomName, map[string]interface{}{
+		"nickname": "grpc",
+	})))
+	users := readType(tcpMessages, "users").Payload.(map[string]interface{})
+	assert.Equal(t, "grpc-bot", users["initiator"])
+	assert.Equal(t, map[string]interface{}{"tcp-bot": "tcp", "grpc-bot": "grpc"}, users["nicknames"])
+
+	require.NoError(t, grpcClient.Write(server.NewMessage("signal", roomName, map[string]interface{}{
+		"userId": "tcp-bot",
+		"signal": map[string]interface{}{"type": "offer", "sdp": "v=0"},
+	})))
+	signal := readType(tcpMessages, "signal").Payload.(map[string]interface{})
+	assert.Equal(t, "grpc-bot", signal["userId"])
+
+	require.NoError(t, grpcConn.Close(nil))
+	leave := readType(tcpMessages, "ws_room_leave").Payload
+	assert.Equal(t, "grpc-bot", leave)
+
+	require.NoError(t, tcpConn.Close(nil))
+	for range grpcMessages {
+	}
+}
+
+func TestSignaling_TCPHelloRequired(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	wss := server.NewWSS(loggerFactory, rooms)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go server.NewTCPSignalingServer(loggerFactory, server.NewMeshSignalingHandler(loggerFactory, wss)).Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, server.WriteSignalingFrame(conn, []byte(`{"room":"`+roomName+`"}`)))
+	_, err = server.ReadSignalingFrame(conn)
+	assert.Error(t, err, "connection is closed without a client ID")
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcpeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Messages of the signaling gRPC API defined in signaling.proto.
+
+type SignalingFrame struct {
+	Hello *SignalingHello `protobuf:"bytes,1,opt,name=hello,proto3" json:"hello,omitempty"`
+	// Message is a JSON message, the same as over websockets.
+	Message []byte `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+}
+
+func (m *SignalingFrame) Reset()         { *m = SignalingFrame{} }
+func (m *SignalingFrame) String() string { return proto.CompactTextString(m) }
+func (*SignalingFrame) ProtoMessage()    {}
+
+func (m *SignalingHello) Reset()         { *m = SignalingHello{} }
+func (m *SignalingHello) String() string { return proto.CompactTextString(m) }
+func (*SignalingHello) ProtoMessage()    {}
+
+const signalingServiceName = "peercalls.SignalingService"
+
+var signalingStreamDesc = grpc.StreamDesc{
+	StreamName: "Connect",
+	Handler: func(srv interface{}, stream grpc.ServerStream) error {
+		return srv.(*signalingRPCServer).Connect(stream)
+	},
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+var signalingServiceDesc = grpc.ServiceDesc{
+	ServiceName: signalingServiceName,
+	HandlerType: (*interface{})(nil),
+	Streams:     []grpc.StreamDesc{signalingStreamDesc},
+	Metadata:    "signaling.proto",
+}
+
+type signalingRPCServer struct {
+	log     Logger
+	handler SignalingHandler
+}
+
+// NewSignalingRPCServer creates a gRPC server with the signaling service
+// registered, which serves clients over bidirectional streams. The first
+// frame sent by a client carries only the hello, the following ones carry
+// messages. Options like the TLS credentials returned by GRPCServerOptions
+// are passed to the gRPC server.
+func NewSignalingRPCServer(loggerFactory LoggerFactory, handler SignalingHandler, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(opts...)
+	s.RegisterService(&signalingServiceDesc, &signalingRPCServer{
+		log:     loggerFactory.GetLogger("signalingrpc"),
+		handler: handler,
+	})
+	return s
+}
+
+func (s *signalingRPCServer) Connect(stream grpc.ServerStream) error {
+	first := new(SignalingFrame)
+	if err := stream.RecvMsg(first); err != nil {
+		return err
+	}
+
+	hello := first.Hello
+	if hello == nil || hello.Room == "" || hello.ClientID == "" {
+		return status.Error(codes.InvalidArgument, "The first frame must carry a hello with room and clientId")
+	}
+
+	var ip string
+	if p, ok := grpcpeer.FromContext(stream.Context()); ok {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+
+	conn := newGRPCSignalingConn(stream)
+	s.handler.ServeSignaling(conn, hello.request(stream.Context(), ip))
+	return nil
+}
+
+// newGRPCSignalingConn creates a SignalingConn over either end of a
+// signaling stream. Closing it does not end a server stream, which ends when
+// the handler returns.
+func newGRPCSignalingConn(stream grpc.Stream) *streamSignalingConn {
+	return newStreamSignalingConn(
+		func() ([]byte, error) {
+			frame := new(SignalingFrame)
+			if err := stream.RecvMsg(frame); err != nil {
+				return nil, err
+			}
+			return frame.Message, nil
+		},
+		func(ctx context.Context, data []byte) error {
+			return stream.SendMsg(&SignalingFrame{Message: data})
+		},
+		func() error {
+			if clientStream, ok := stream.(grpc.ClientStream); ok {
+				return clientStream.CloseSend()
+			}
+			return nil
+		},
+	)
+}
+
+// DialGRPCSignaling opens a signaling stream over cc and sends hello.
+// Messages can be sent and received with a Client using the returned
+// connection. The stream ends when ctx is canceled.
+func DialGRPCSignaling(ctx context.Context, cc *grpc.ClientConn, hello SignalingHello) (SignalingConn, error) {
+	stream, err := cc.NewStream(ctx, &signalingStreamDesc, "/"+signalingServiceName+"/Connect")
+	if err != nil {
+		return nil, fmt.Errorf("open signaling stream: %w", err)
+	}
+
+	if err := stream.SendMsg(&SignalingFrame{Hello: &hello}); err != nil {
+		return nil, fmt.Errorf("send signaling hello: %w", err)
+	}
+
+	return newGRPCSignalingConn(stream), nil
+}
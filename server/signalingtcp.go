@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	// maxSignalingFrameSize limits the size of frames of the TCP signaling
+	// transport.
+	maxSignalingFrameSize = 1 << 20
+	// signalingHelloTimeout is how long the TCP signaling transport waits for
+	// the hello of a client.
+	signalingHelloTimeout = 10 * time.Second
+	// signalingWriteTimeout limits writes of the TCP signaling transport when
+	// the context has no deadline.
+	signalingWriteTimeout = 10 * time.Second
+)
+
+var ErrSignalingFrameTooLarge = errors.New("signaling frame too large")
+
+// WriteSignalingFrame writes data as a frame of the TCP signaling transport:
+// the length of data as a 32 bit big-endian integer, followed by data.
+func WriteSignalingFrame(w io.Writer, data []byte) error {
+	if len(data) > maxSignalingFrameSize {
+		return ErrSignalingFrameTooLarge
+	}
+
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadSignalingFrame reads a frame of the TCP signaling transport written by
+// WriteSignalingFrame.
+func ReadSignalingFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxSignalingFrameSize {
+		return nil, ErrSignalingFrameTooLarge
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// NewTCPSignalingConn creates a SignalingConn which sends and receives
+// messages as frames over conn. It is used by both ends of the TCP signaling
+// transport.
+func NewTCPSignalingConn(conn net.Conn) SignalingConn {
+	return newStreamSignalingConn(
+		func() ([]byte, error) {
+			return ReadSignalingFrame(conn)
+		},
+		func(ctx context.Context, data []byte) error {
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				deadline = time.Now().Add(signalingWriteTimeout)
+			}
+			if err := conn.SetWriteDeadline(deadline); err != nil {
+				return err
+			}
+			return WriteSignalingFrame(conn, data)
+		},
+		conn.Close,
+	)
+}
+
+// DialTCPSignaling connects to the TCP signaling transport at addr and sends
+// hello. Messages can be sent and received with a Client using the returned
+// connection.
+func DialTCPSignaling(ctx context.Context, addr string, hello SignalingHello) (SignalingConn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial signaling: %w", err)
+	}
+
+	data, err := json.Marshal(hello)
+	if err == nil {
+		err = WriteSignalingFrame(conn, data)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("send signaling hello: %w", err)
+	}
+
+	return NewTCPSignalingConn(conn), nil
+}
+
+// TCPSignalingServer serves signaling over plain TCP connections, for
+// server-side clients like bots and ingest agents which do not want to
+// depend on a websocket library.
+//
+// Every frame is a JSON message, prefixed by its length. The first frame
+// sent by a client is a SignalingHello, after which messages are the same as
+// over websockets.
+type TCPSignalingServer struct {
+	log     Logger
+	handler SignalingHandler
+}
+
+func NewTCPSignalingServer(loggerFactory LoggerFactory, handler SignalingHandler) *TCPSignalingServer {
+	return &TCPSignalingServer{
+		log:     loggerFactory.GetLogger("signalingtcp"),
+		handler: handler,
+	}
+}
+
+// Serve accepts connections from l until it is closed.
+func (s *TCPSignalingServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go s.serveConn(conn)
+	}
+}
+
+func (s *TCPSignalingServer) serveConn(conn net.Conn) {
+	hello, err := s.readHello(conn)
+	if err != nil {
+		s.log.Printf("Error reading hello from: %s: %s", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	ip, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		ip = conn.RemoteAddr().String()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s.handler.ServeSignaling(NewTCPSignalingConn(conn), hello.request(ctx, ip))
+}
+
+func (s *TCPSignalingServer) readHello(conn net.Conn) (hello SignalingHello, err error) {
+	if err := conn.SetReadDeadline(time.Now().Add(signalingHelloTimeout)); err != nil {
+		return hello, err
+	}
+
+	data, err := ReadSignalingFrame(conn)
+	if err != nil {
+		return hello, err
+	}
+
+	if err := json.Unmarshal(data, &hello); err != nil {
+		return hello, err
+	}
+	if hello.Room == "" || hello.ClientID == "" {
+		return hello, fmt.Errorf("room and clientId are required")
+	}
+
+	return hello, conn.SetReadDeadline(time.Time{})
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sipRealm = "peercalls"
+	// sipNonceTimeout is how long a nonce of a digest challenge is accepted.
+	sipNonceTimeout = 5 * time.Minute
+)
+
+// sipDigestAuth authenticates SIP requests with digest authentication as
+// described in RFC 3261 section 22. Nonces are signed with a random key, so
+// that no state is kept between a challenge and the request answering it.
+type sipDigestAuth struct {
+	username string
+	password string
+	key      []byte
+	// now is replaced in tests
+	now func() time.Time
+}
+
+func newSIPDigestAuth(username string, password string) *sipDigestAuth {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Errorf("Error generating SIP nonce key: %w", err))
+	}
+	return &sipDigestAuth{
+		username: username,
+		password: password,
+		key:      key,
+		now:      time.Now,
+	}
+}
+
+func (a *sipDigestAuth) sign(timestamp string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(timestamp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// challenge returns the value of the WWW-Authenticate header of a 401
+// response.
+func (a *sipDigestAuth) challenge() string {
+	timestamp := strconv.FormatInt(a.now().Unix(), 16)
+	nonce := timestamp + "." + a.sign(timestamp)
+	return fmt.Sprintf(`Digest realm="%s", nonce="%s", algorithm=MD5, qop="auth"`, sipRealm, nonce)
+}
+
+// validNonce returns true when nonce was created by challenge and has not
+// expired.
+func (a *sipDigestAuth) validNonce(nonce string) bool {
+	dot := strings.IndexByte(nonce, '.')
+	if dot < 0 {
+		return false
+	}
+	timestamp := nonce[:dot]
+	if !hmac.Equal([]byte(nonce[dot+1:]), []byte(a.sign(timestamp))) {
+		return false
+	}
+	created, err := strconv.ParseInt(timestamp, 16, 64)
+	if err != nil {
+		return false
+	}
+	return a.now().Sub(time.Unix(created, 0)) <= sipNonceTimeout
+}
+
+// authorize returns true when the Authorization header of a request with
+// method has the response to a valid challenge.
+func (a *sipDigestAuth) authorize(method string, authorization string) bool {
+	if !strings.HasPrefix(authorization, "Digest ") {
+		return false
+	}
+	params := parseSIPDigestParams(strings.TrimPrefix(authorization, "Digest "))
+
+	if params["username"] != a.username || params["realm"] != sipRealm {
+		return false
+	}
+	if algorithm := params["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+		return false
+	}
+	nonce := params["nonce"]
+	if !a.validNonce(nonce) {
+		return false
+	}
+
+	ha1 := md5Hex(a.username + ":" + sipRealm + ":" + a.password)
+	ha2 := md5Hex(method + ":" + params["uri"])
+	var expected string
+	switch params["qop"] {
+	case "":
+		expected = md5Hex(ha1 + ":" + nonce + ":" + ha2)
+	case "auth":
+		expected = md5Hex(ha1 + ":" + nonce + ":" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+	default:
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(strings.ToLower(params["response"])), []byte(expected)) == 1
+}
+
+func md5Hex(value string) string {
+	sum := md5.Sum([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseSIPDigestParams parses the comma separated parameters of a digest
+// Authorization header. Values can be quoted.
+func parseSIPDigestParams(value string) map[string]string {
+	params := map[string]string{}
+	for value != "" {
+		value = strings.TrimLeft(value, " \t,")
+		eq := strings.IndexByte(value, '=')
+		if eq < 0 {
+			break
+		}
+		name := strings.ToLower(strings.TrimSpace(value[:eq]))
+		value = strings.TrimLeft(value[eq+1:], " \t")
+
+		var param string
+		if strings.HasPrefix(value, `"`) {
+			end := strings.IndexByte(value[1:], '"')
+			if end < 0 {
+				break
+			}
+			param = value[1 : end+1]
+			value = value[end+2:]
+		} else {
+			end := strings.IndexByte(value, ',')
+			if end < 0 {
+				end = len(value)
+			}
+			param = strings.TrimSpace(value[:end])
+			value = value[end:]
+		}
+		params[name] = param
+	}
+	return params
+}
@@ -0,0 +1,836 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/sdp/v2"
+	"github.com/pion/webrtc/v2"
+	"nhooyr.io/websocket"
+)
+
+const (
+	sipVersion       = "SIP/2.0"
+	sipMaxPacketSize = 65535
+	// opusFrameSamples is the number of samples in a 20ms Opus frame, used to
+	// keep RTP timestamps monotonic when the forwarded speaker changes.
+	opusFrameSamples = 960
+	// defaultSIPMaxCalls is the number of concurrent calls when not
+	// configured.
+	defaultSIPMaxCalls = 20
+	// sipJoinTimeout limits how long joining the room of a call may take.
+	sipJoinTimeout = 5 * time.Second
+)
+
+// errSIPJoinTimeout is returned when a call did not join its room within
+// sipJoinTimeout.
+var errSIPJoinTimeout = &JoinError{Code: "timeout", Message: "Timed out joining room"}
+
+// sipCompactHeaders maps compact header names from RFC 3261 section 7.3.3 to
+// their full names.
+var sipCompactHeaders = map[string]string{
+	"v": "Via",
+	"f": "From",
+	"t": "To",
+	"i": "Call-ID",
+	"m": "Contact",
+	"l": "Content-Length",
+	"c": "Content-Type",
+}
+
+// SIPTracks is the subset of MemoryTracksManager used by SIPGateway.
+type SIPTracks interface {
+	AddServerTrack(room string, track *webrtc.Track)
+	RemoveServerTrack(room string, track *webrtc.Track)
+	LastActive(clientID string) time.Time
+	Use(factories ...InterceptorFactory)
+}
+
+// SIPGateway is a minimal SIP user agent server which lets phone users dial
+// into a room. The user part of the Request-URI is the room name, for
+// example sip:my-room@peercalls.example.com.
+//
+// Calls must negotiate Opus. The phone's audio is forwarded to everyone in
+// the room on a server-originated track, and the audio of the most recently
+// active speaker in the room is sent back to the phone.
+//
+// Calls are accepted from allowed networks, and must authenticate with
+// digest authentication when a username is configured. Each call joins its
+// room through the signaling handler like other clients, so room passwords,
+// locks, participant limits, tenants, the connection policy and quotas apply
+// to calls too. The room password and the join token of a tenant are read
+// from the X-Room-Password and X-Room-Token headers of the INVITE.
+type SIPGateway struct {
+	log      Logger
+	conn     net.PacketConn
+	publicIP net.IP
+	tracks   SIPTracks
+	handler  SignalingHandler
+	// auth is nil when digest authentication is disabled
+	auth            *sipDigestAuth
+	allowedNetworks []*net.IPNet
+	maxCalls        int
+
+	mu sync.RWMutex
+	// key is SIP Call-ID
+	calls map[string]*sipCall
+}
+
+// NewSIPGateway creates a gateway which receives SIP requests on conn and
+// advertises publicIP in its SDP answers. Calls join their rooms through
+// handler. It registers an interceptor with tracks to receive the audio of
+// peers. Call Serve to start handling requests. Returns an error when config
+// does not restrict who can call.
+func NewSIPGateway(
+	loggerFactory LoggerFactory,
+	conn net.PacketConn,
+	publicIP net.IP,
+	tracks SIPTracks,
+	handler SignalingHandler,
+	config SIPConfig,
+) (*SIPGateway, error) {
+	if config.Username == "" && len(config.AllowedNetworks) == 0 {
+		return nil, fmt.Errorf("SIP gateway requires a username or allowed networks")
+	}
+
+	allowedNetworks := make([]*net.IPNet, 0, len(config.AllowedNetworks))
+	for _, cidr := range config.AllowedNetworks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid SIP allowed network: %q: %w", cidr, err)
+		}
+		allowedNetworks = append(allowedNetworks, network)
+	}
+
+	maxCalls := config.MaxCalls
+	if maxCalls <= 0 {
+		maxCalls = defaultSIPMaxCalls
+	}
+
+	g := &SIPGateway{
+		log:             loggerFactory.GetLogger("sip"),
+		conn:            conn,
+		publicIP:        publicIP,
+		tracks:          tracks,
+		handler:         handler,
+		allowedNetworks: allowedNetworks,
+		maxCalls:        maxCalls,
+		calls:           map[string]*sipCall{},
+	}
+	if config.Username != "" {
+		g.auth = newSIPDigestAuth(config.Username, config.Password)
+	}
+	tracks.Use(InterceptorFactoryFunc(g.newInterceptor))
+	return g, nil
+}
+
+// Serve handles SIP requests until the connection is closed.
+func (g *SIPGateway) Serve() error {
+	buf := make([]byte, sipMaxPacketSize)
+	for {
+		n, addr, err := g.conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		msg, err := parseSIPMessage(buf[:n])
+		if err != nil {
+			g.log.Printf("Error parsing SIP message from %s: %s", addr, err)
+			continue
+		}
+
+		g.handleRequest(msg, addr)
+	}
+}
+
+// Close hangs up all calls and closes the connection.
+func (g *SIPGateway) Close() error {
+	g.mu.Lock()
+	calls := g.calls
+	g.calls = map[string]*sipCall{}
+	g.mu.Unlock()
+
+	for _, call := range calls {
+		g.closeCall(call)
+	}
+
+	return g.conn.Close()
+}
+
+func (g *SIPGateway) handleRequest(req *sipMessage, addr net.Addr) {
+	if req.method == "" {
+		// responses are not expected because the gateway never sends requests
+		return
+	}
+
+	g.log.Printf("%s %s from %s", req.method, req.requestURI, addr)
+
+	switch req.method {
+	case "INVITE":
+		g.handleInvite(req, addr)
+	case "ACK":
+		// the call is already established when the 200 OK is sent
+	case "BYE", "CANCEL":
+		call, ok := g.removeCall(req.header("Call-ID"))
+		if !ok {
+			g.respond(addr, req, 481, "Call/Transaction Does Not Exist", nil)
+			return
+		}
+		g.closeCall(call)
+		g.respond(addr, req, 200, "OK", nil)
+	case "OPTIONS":
+		g.respond(addr, req, 200, "OK", nil)
+	default:
+		g.respond(addr, req, 501, "Not Implemented", nil)
+	}
+}
+
+// allowed returns true when addr is in one of the allowed networks, or when
+// no networks are configured.
+func (g *SIPGateway) allowed(addr net.Addr) bool {
+	if len(g.allowedNetworks) == 0 {
+		return true
+	}
+	ip := sipAddrIP(addr)
+	for _, network := range g.allowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *SIPGateway) handleInvite(req *sipMessage, addr net.Addr) {
+	callID := req.header("Call-ID")
+
+	if !g.allowed(addr) {
+		g.log.Printf("INVITE: Rejecting call from %s: address not allowed", addr)
+		g.respond(addr, req, 403, "Forbidden", nil)
+		return
+	}
+	if g.auth != nil && !g.auth.authorize(req.method, req.header("Authorization")) {
+		g.respond(addr, req, 401, "Unauthorized", nil, sipHeader{"WWW-Authenticate", g.auth.challenge()})
+		return
+	}
+
+	g.mu.RLock()
+	call, ok := g.calls[callID]
+	g.mu.RUnlock()
+	if ok {
+		// re-INVITE, for example a session refresh
+		g.respond(addr, req, 200, "OK", call.answer)
+		return
+	}
+
+	room, err := sipRoom(req.requestURI)
+	if err != nil {
+		g.log.Printf("INVITE: %s", err)
+		g.respond(addr, req, 404, "Not Found", nil)
+		return
+	}
+
+	g.mu.RLock()
+	full := len(g.calls) >= g.maxCalls
+	g.mu.RUnlock()
+	if full {
+		g.log.Printf("INVITE: Rejecting call %s: too many calls", callID)
+		g.respond(addr, req, 503, "Service Unavailable", nil)
+		return
+	}
+
+	g.respond(addr, req, 100, "Trying", nil)
+
+	call, err = g.newCall(callID, addr, req.body)
+	if err == errSIPNoOpus {
+		g.respond(addr, req, 488, "Not Acceptable Here", nil)
+		return
+	}
+	if err != nil {
+		g.log.Printf("INVITE: Error creating call: %s: %s", callID, err)
+		g.respond(addr, req, 500, "Server Internal Error", nil)
+		return
+	}
+
+	go g.handler.ServeSignaling(call.signaling, SignalingRequest{
+		Room:     room,
+		ClientID: call.clientID,
+		IP:       sipAddrIP(addr).String(),
+		Password: req.header("X-Room-Password"),
+		Token:    req.header("X-Room-Token"),
+	})
+	// the room differs from the requested one for rooms of tenants
+	call.room, err = call.signaling.wait(sipJoinTimeout)
+	if err != nil {
+		g.log.Printf("[%s] Call %s rejected in room: %s: %s", call.clientID, callID, room, err)
+		call.close()
+		if errors.Is(err, ErrRoomFull) || errors.Is(err, ErrServerFull) || errors.Is(err, ErrTenantFull) {
+			g.respond(addr, req, 486, "Busy Here", nil)
+			return
+		}
+		g.respond(addr, req, 403, "Forbidden", nil)
+		return
+	}
+
+	g.mu.Lock()
+	g.calls[callID] = call
+	g.mu.Unlock()
+
+	g.log.Printf("[%s] Call %s joined room: %s", call.clientID, callID, call.room)
+	g.tracks.AddServerTrack(call.room, call.track)
+	go call.readRTP()
+	go g.hangupOnLeave(callID, call)
+
+	g.respond(addr, req, 200, "OK", call.answer)
+}
+
+// hangupOnLeave ends call when it leaves its room without a BYE, for
+// example when it is kicked.
+func (g *SIPGateway) hangupOnLeave(callID string, call *sipCall) {
+	<-call.signaling.closed
+
+	g.mu.Lock()
+	current, ok := g.calls[callID]
+	if ok && current == call {
+		delete(g.calls, callID)
+	}
+	g.mu.Unlock()
+
+	if ok && current == call {
+		g.closeCall(call)
+	}
+}
+
+func (g *SIPGateway) removeCall(callID string) (*sipCall, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	call, ok := g.calls[callID]
+	delete(g.calls, callID)
+	return call, ok
+}
+
+func (g *SIPGateway) closeCall(call *sipCall) {
+	g.log.Printf("[%s] Call left room: %s", call.clientID, call.room)
+	g.tracks.RemoveServerTrack(call.room, call.track)
+	if err := call.close(); err != nil {
+		g.log.Printf("[%s] Error closing RTP connection: %s", call.clientID, err)
+	}
+}
+
+var errSIPNoOpus = fmt.Errorf("SDP offer does not contain Opus audio")
+
+// newCall creates a call for an offer received from addr. Its RTP connection
+// is bound to the IP the gateway listens on, and the public IP is only
+// advertised in the answer.
+func (g *SIPGateway) newCall(callID string, addr net.Addr, offer []byte) (*sipCall, error) {
+	var desc sdp.SessionDescription
+	if err := desc.Unmarshal(offer); err != nil {
+		return nil, fmt.Errorf("Error parsing SDP offer: %w", err)
+	}
+
+	payloadType, err := desc.GetPayloadTypeForCodec(sdp.Codec{Name: "opus"})
+	if err != nil {
+		return nil, errSIPNoOpus
+	}
+
+	remoteAddr, err := sdpAudioAddr(&desc)
+	if err != nil {
+		return nil, err
+	}
+
+	listenIP := net.IPv4zero
+	if localAddr, ok := g.conn.LocalAddr().(*net.UDPAddr); ok {
+		listenIP = localAddr.IP
+	}
+	rtpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: listenIP})
+	if err != nil {
+		return nil, fmt.Errorf("Error opening RTP connection: %w", err)
+	}
+
+	clientID := "sip_" + NewUUIDBase62()
+	codec := webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, opusSampleRate)
+	track, err := webrtc.NewTrack(
+		webrtc.DefaultPayloadTypeOpus,
+		rand.Uint32(),
+		getLocalTrackID(clientID),
+		"sfu_"+clientID+"_"+clientID,
+		codec,
+	)
+	if err != nil {
+		rtpConn.Close()
+		return nil, fmt.Errorf("Error creating call track: %w", err)
+	}
+
+	call := &sipCall{
+		log:         g.log,
+		clientID:    clientID,
+		track:       track,
+		rtpConn:     rtpConn,
+		signaling:   newSIPSignalingConn(),
+		sourceIPs:   []net.IP{remoteAddr.IP, sipAddrIP(addr)},
+		remoteAddr:  remoteAddr,
+		payloadType: payloadType,
+		ssrc:        rand.Uint32(),
+	}
+	call.answer = g.sdpAnswer(payloadType, rtpConn.LocalAddr().(*net.UDPAddr).Port)
+
+	return call, nil
+}
+
+func (g *SIPGateway) sdpAnswer(payloadType uint8, port int) []byte {
+	sessionID := strconv.FormatUint(uint64(rand.Uint32()), 10)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "v=0\r\n")
+	fmt.Fprintf(&b, "o=peercalls %s %s IN IP4 %s\r\n", sessionID, sessionID, g.publicIP)
+	fmt.Fprintf(&b, "s=peercalls\r\n")
+	fmt.Fprintf(&b, "c=IN IP4 %s\r\n", g.publicIP)
+	fmt.Fprintf(&b, "t=0 0\r\n")
+	fmt.Fprintf(&b, "m=audio %d RTP/AVP %d\r\n", port, payloadType)
+	fmt.Fprintf(&b, "a=rtpmap:%d opus/%d/2\r\n", payloadType, opusSampleRate)
+	fmt.Fprintf(&b, "a=sendrecv\r\n")
+	return b.Bytes()
+}
+
+func (g *SIPGateway) respond(addr net.Addr, req *sipMessage, code int, reason string, body []byte, headers ...sipHeader) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %d %s\r\n", sipVersion, code, reason)
+	for _, h := range req.headers {
+		switch h.name {
+		case "Via", "From", "Call-ID", "CSeq":
+			fmt.Fprintf(&b, "%s: %s\r\n", h.name, h.value)
+		case "To":
+			value := h.value
+			if code > 100 && !strings.Contains(value, ";tag=") {
+				value += ";tag=" + sipTag(req.header("Call-ID"))
+			}
+			fmt.Fprintf(&b, "%s: %s\r\n", h.name, value)
+		}
+	}
+	if req.method == "INVITE" && code == 200 {
+		fmt.Fprintf(&b, "Contact: <sip:peercalls@%s>\r\n", g.conn.LocalAddr())
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", h.name, h.value)
+	}
+	fmt.Fprintf(&b, "Allow: INVITE, ACK, BYE, CANCEL, OPTIONS\r\n")
+	if len(body) > 0 {
+		fmt.Fprintf(&b, "Content-Type: application/sdp\r\n")
+	}
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n", len(body))
+	b.Write(body)
+
+	if _, err := g.conn.WriteTo(b.Bytes(), addr); err != nil {
+		g.log.Printf("Error sending SIP response to %s: %s", addr, err)
+	}
+}
+
+// newInterceptor taps the audio of peers so it can be forwarded to calls in
+// the same room.
+func (g *SIPGateway) newInterceptor(params InterceptorParams) (Interceptor, error) {
+	if params.Encrypted || params.LocalTrack.Kind() != webrtc.RTPCodecTypeAudio {
+		return NoOpInterceptor{}, nil
+	}
+
+	return &sipTap{
+		gateway:  g,
+		room:     params.Room,
+		clientID: params.ClientID,
+	}, nil
+}
+
+func (g *SIPGateway) forward(room string, clientID string, packet *rtp.Packet) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	for _, call := range g.calls {
+		if call.room == room {
+			call.forward(clientID, packet, g.tracks.LastActive)
+		}
+	}
+}
+
+type sipTap struct {
+	gateway  *SIPGateway
+	room     string
+	clientID string
+}
+
+func (s *sipTap) BindRTP(next RTPWriter) RTPWriter {
+	return RTPWriterFunc(func(packet *rtp.Packet) error {
+		s.gateway.forward(s.room, s.clientID, packet)
+		return next.WriteRTP(packet)
+	})
+}
+
+func (s *sipTap) BindRTCP(next RTCPWriter) RTCPWriter {
+	return next
+}
+
+func (s *sipTap) Close() error {
+	return nil
+}
+
+type sipCall struct {
+	log      Logger
+	clientID string
+	room     string
+	// track carries the phone's audio to the room
+	track   *webrtc.Track
+	rtpConn *net.UDPConn
+	answer  []byte
+	// signaling is the connection of the call to its room
+	signaling *sipSignalingConn
+	// sourceIPs are the addresses RTP of the phone is accepted from: the
+	// address in the SDP offer and the address the INVITE was received from
+	sourceIPs []net.IP
+
+	// payloadType of Opus negotiated with the phone
+	payloadType uint8
+	// ssrc of the audio sent to the phone
+	ssrc uint32
+
+	mu sync.Mutex
+	// remoteAddr starts as the address from the SDP offer and is latched to
+	// the source of the first RTP packet received from one of sourceIPs, so
+	// that calls work behind NAT. RTP from other sources is dropped.
+	remoteAddr *net.UDPAddr
+	latched    bool
+	// speaker is the clientID whose audio is sent to the phone
+	speaker   string
+	switched  bool
+	seqOffset uint16
+	tsOffset  uint32
+	lastSeq   uint16
+	lastTS    uint32
+}
+
+// readRTP forwards the phone's audio to the room until the RTP connection
+// is closed.
+func (c *sipCall) readRTP() {
+	buf := make([]byte, sipMaxPacketSize)
+	for {
+		n, addr, err := c.rtpConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		var packet rtp.Packet
+		if err := packet.Unmarshal(buf[:n]); err != nil {
+			// RTCP is multiplexed by some phones
+			if _, rtcpErr := rtcp.Unmarshal(buf[:n]); rtcpErr != nil {
+				c.log.Printf("[%s] Error parsing RTP packet: %s", c.clientID, err)
+			}
+			continue
+		}
+		if packet.PayloadType != c.payloadType {
+			// for example telephone-event or comfort noise
+			continue
+		}
+
+		if !c.latch(addr) {
+			continue
+		}
+
+		packet.PayloadType = c.track.PayloadType()
+		packet.SSRC = c.track.SSRC()
+		if err := c.track.WriteRTP(&packet); err != nil {
+			c.log.Printf("[%s] Error writing RTP to room: %s", c.clientID, err)
+		}
+	}
+}
+
+// latch returns true when RTP from addr is accepted.
+func (c *sipCall) latch(addr *net.UDPAddr) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.latched {
+		return addr.IP.Equal(c.remoteAddr.IP) && addr.Port == c.remoteAddr.Port
+	}
+	for _, ip := range c.sourceIPs {
+		if ip.Equal(addr.IP) {
+			c.remoteAddr = addr
+			c.latched = true
+			return true
+		}
+	}
+	return false
+}
+
+// close leaves the room and closes the RTP connection.
+func (c *sipCall) close() error {
+	c.signaling.Close(nil)
+	return c.rtpConn.Close()
+}
+
+// forward sends packet from clientID to the phone when clientID is the
+// current speaker, or when it spoke more recently than the current speaker.
+// Sequence numbers and timestamps are rewritten so that the phone sees a
+// single continuous stream.
+func (c *sipCall) forward(clientID string, packet *rtp.Packet, lastActive func(clientID string) time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.speaker != clientID {
+		if c.speaker != "" && !lastActive(clientID).After(lastActive(c.speaker)) {
+			return
+		}
+		c.speaker = clientID
+		c.switched = true
+	}
+
+	if c.switched {
+		c.seqOffset = c.lastSeq + 1 - packet.SequenceNumber
+		c.tsOffset = c.lastTS + opusFrameSamples - packet.Timestamp
+		c.switched = false
+	}
+
+	out := rtp.Packet{
+		Header:  packet.Header,
+		Payload: packet.Payload,
+	}
+	out.Extension = false
+	out.ExtensionPayload = nil
+	out.PayloadType = c.payloadType
+	out.SSRC = c.ssrc
+	out.SequenceNumber = packet.SequenceNumber + c.seqOffset
+	out.Timestamp = packet.Timestamp + c.tsOffset
+	c.lastSeq = out.SequenceNumber
+	c.lastTS = out.Timestamp
+
+	data, err := out.Marshal()
+	if err != nil {
+		c.log.Printf("[%s] Error marshaling RTP packet: %s", c.clientID, err)
+		return
+	}
+	if _, err := c.rtpConn.WriteToUDP(data, c.remoteAddr); err != nil {
+		c.log.Printf("[%s] Error sending RTP to phone: %s", c.clientID, err)
+	}
+}
+
+type sipHeader struct {
+	name  string
+	value string
+}
+
+type sipMessage struct {
+	// method and requestURI are empty for responses
+	method     string
+	requestURI string
+	headers    []sipHeader
+	body       []byte
+}
+
+func (m *sipMessage) header(name string) string {
+	for _, h := range m.headers {
+		if strings.EqualFold(h.name, name) {
+			return h.value
+		}
+	}
+	return ""
+}
+
+func parseSIPMessage(data []byte) (*sipMessage, error) {
+	head := data
+	var body []byte
+	if i := bytes.Index(data, []byte("\r\n\r\n")); i >= 0 {
+		head = data[:i]
+		body = data[i+4:]
+	}
+
+	lines := strings.Split(string(head), "\r\n")
+	startLine := strings.Fields(lines[0])
+	if len(startLine) != 3 {
+		return nil, fmt.Errorf("Invalid start line: %q", lines[0])
+	}
+
+	msg := &sipMessage{}
+	if startLine[2] == sipVersion {
+		msg.method = startLine[0]
+		msg.requestURI = startLine[1]
+	} else if startLine[0] != sipVersion {
+		return nil, fmt.Errorf("Unsupported SIP version: %q", lines[0])
+	}
+
+	for _, line := range lines[1:] {
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			return nil, fmt.Errorf("Invalid header: %q", line)
+		}
+		name := strings.TrimSpace(line[:colon])
+		if fullName, ok := sipCompactHeaders[strings.ToLower(name)]; ok {
+			name = fullName
+		}
+		msg.headers = append(msg.headers, sipHeader{
+			name:  name,
+			value: strings.TrimSpace(line[colon+1:]),
+		})
+	}
+
+	if contentLength := msg.header("Content-Length"); contentLength != "" {
+		length, err := strconv.Atoi(contentLength)
+		if err != nil || length < 0 || length > len(body) {
+			return nil, fmt.Errorf("Invalid Content-Length: %q", contentLength)
+		}
+		body = body[:length]
+	}
+	msg.body = body
+
+	return msg, nil
+}
+
+// sipRoom returns the user part of a SIP URI.
+func sipRoom(requestURI string) (string, error) {
+	uri := strings.TrimPrefix(strings.TrimPrefix(requestURI, "sips:"), "sip:")
+	at := strings.IndexByte(uri, '@')
+	if at <= 0 {
+		return "", fmt.Errorf("No room in Request-URI: %q", requestURI)
+	}
+	return url.PathUnescape(uri[:at])
+}
+
+// sipTag derives the local tag of a dialog from its Call-ID so that
+// retransmitted requests get the same tag.
+func sipTag(callID string) string {
+	h := fnv.New32a()
+	h.Write([]byte(callID))
+	return strconv.FormatUint(uint64(h.Sum32()), 36)
+}
+
+// sipAddrIP returns the IP of a SIP or RTP address.
+func sipAddrIP(addr net.Addr) net.IP {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP
+	}
+	host, _, _ := net.SplitHostPort(addr.String())
+	return net.ParseIP(host)
+}
+
+func sdpAudioAddr(desc *sdp.SessionDescription) (*net.UDPAddr, error) {
+	for _, media := range desc.MediaDescriptions {
+		if media.MediaName.Media != "audio" {
+			continue
+		}
+
+		connection := media.ConnectionInformation
+		if connection == nil {
+			connection = desc.ConnectionInformation
+		}
+		if connection == nil || connection.Address == nil {
+			return nil, fmt.Errorf("SDP offer has no connection address")
+		}
+
+		ip := net.ParseIP(connection.Address.Address)
+		if ip == nil {
+			return nil, fmt.Errorf("Invalid SDP connection address: %q", connection.Address.Address)
+		}
+
+		return &net.UDPAddr{IP: ip, Port: media.MediaName.Port.Value}, nil
+	}
+
+	return nil, fmt.Errorf("SDP offer has no audio")
+}
+
+// sipSignalingConn is the signaling connection of a call, which joins it to
+// its room like any other client. The result of the join is received from
+// wait. Other messages sent to the call are discarded, and reads block
+// until the connection is closed.
+type sipSignalingConn struct {
+	// joined receives the room the call joined, or the reason it was
+	// rejected
+	joined   chan sipJoinResult
+	joinOnce sync.Once
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type sipJoinResult struct {
+	room string
+	err  error
+}
+
+var _ SignalingConn = &sipSignalingConn{}
+
+func newSIPSignalingConn() *sipSignalingConn {
+	return &sipSignalingConn{
+		joined: make(chan sipJoinResult, 1),
+		closed: make(chan struct{}),
+	}
+}
+
+func (c *sipSignalingConn) join(result sipJoinResult) {
+	c.joinOnce.Do(func() {
+		c.joined <- result
+	})
+}
+
+// wait returns the room the call joined, or an error when it was rejected
+// or did not join within timeout.
+func (c *sipSignalingConn) wait(timeout time.Duration) (string, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-c.joined:
+		if result.err != nil {
+			return "", result.err
+		}
+		return result.room, nil
+	case <-timer.C:
+		return "", errSIPJoinTimeout
+	}
+}
+
+func (c *sipSignalingConn) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	select {
+	case <-c.closed:
+		return 0, nil, ErrSignalingClosed
+	case <-ctx.Done():
+		return 0, nil, ctx.Err()
+	}
+}
+
+// Write completes the join on the first message which is not a join error.
+// Clients receive the join ack or the features of their room first.
+func (c *sipSignalingConn) Write(ctx context.Context, typ websocket.MessageType, data []byte) error {
+	select {
+	case <-c.closed:
+		return ErrSignalingClosed
+	default:
+	}
+
+	msg, err := ByteSerializer{}.Deserialize(data)
+	if err != nil {
+		return err
+	}
+	if msg.Type != MessageTypeJoinError {
+		c.join(sipJoinResult{room: msg.Room})
+	}
+	return nil
+}
+
+// Close rejects the call when it has not joined yet, with the join error
+// the client was rejected with when reason is one.
+func (c *sipSignalingConn) Close(reason error) error {
+	if reason == nil {
+		reason = ErrSignalingClosed
+	}
+	c.join(sipJoinResult{err: reason})
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSIPCall_latch(t *testing.T) {
+	call := &sipCall{
+		sourceIPs:  []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("198.51.100.1")},
+		remoteAddr: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4000},
+	}
+
+	assert.False(t, call.latch(&net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 4000}))
+	assert.Equal(t, 4000, call.remoteAddr.Port)
+
+	// the phone is behind NAT, and RTP comes from the address of its INVITE
+	phone := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 30000}
+	assert.True(t, call.latch(phone))
+	assert.Equal(t, phone, call.remoteAddr)
+	assert.True(t, call.latch(&net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 30000}))
+
+	assert.False(t, call.latch(&net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 30001}))
+	assert.False(t, call.latch(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4000}))
+	assert.Equal(t, phone, call.remoteAddr)
+}
+
+func TestSIPDigestAuth_nonce(t *testing.T) {
+	auth := newSIPDigestAuth("phone", "secret")
+	now := time.Unix(1000000, 0)
+	auth.now = func() time.Time { return now }
+
+	params := parseSIPDigestParams(strings.TrimPrefix(auth.challenge(), "Digest "))
+	assert.Equal(t, "peercalls", params["realm"])
+	assert.Equal(t, "MD5", params["algorithm"])
+	assert.True(t, auth.validNonce(params["nonce"]))
+	assert.False(t, auth.validNonce(params["nonce"]+"0"))
+	assert.False(t, newSIPDigestAuth("phone", "secret").validNonce(params["nonce"]))
+
+	now = now.Add(sipNonceTimeout + time.Second)
+	assert.False(t, auth.validNonce(params["nonce"]))
+}
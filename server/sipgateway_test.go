@@ -0,0 +1,360 @@
+package server_test
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockSIPTracks struct {
+	mu        sync.Mutex
+	added     map[string][]*webrtc.Track
+	factories []server.InterceptorFactory
+}
+
+func newMockSIPTracks() *mockSIPTracks {
+	return &mockSIPTracks{added: map[string][]*webrtc.Track{}}
+}
+
+func (m *mockSIPTracks) AddServerTrack(room string, track *webrtc.Track) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.added[room] = append(m.added[room], track)
+}
+
+func (m *mockSIPTracks) RemoveServerTrack(room string, track *webrtc.Track) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.added, room)
+}
+
+func (m *mockSIPTracks) LastActive(clientID string) time.Time {
+	return time.Time{}
+}
+
+func (m *mockSIPTracks) Use(factories ...server.InterceptorFactory) {
+	m.factories = append(m.factories, factories...)
+}
+
+func (m *mockSIPTracks) roomTracks(room string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.added[room])
+}
+
+// mockSIPSignaling joins calls like WSS.ServeRoom, and rejects them when
+// their password is "wrong". Rooms are prefixed like those of tenants.
+type mockSIPSignaling struct {
+	requests chan server.SignalingRequest
+	conns    chan server.SignalingConn
+}
+
+func newMockSIPSignaling() *mockSIPSignaling {
+	return &mockSIPSignaling{
+		requests: make(chan server.SignalingRequest, 10),
+		conns:    make(chan server.SignalingConn, 10),
+	}
+}
+
+func (m *mockSIPSignaling) ServeSignaling(conn server.SignalingConn, req server.SignalingRequest) {
+	m.requests <- req
+	client := server.NewClientWithID(conn, req.ClientID)
+	if req.Password == "wrong" {
+		client.Write(server.NewMessageJoinError(req.Room, server.ErrInvalidPassword))
+		conn.Close(server.ErrInvalidPassword)
+		return
+	}
+
+	client.Write(server.NewMessage("room_features", "tenant/"+req.Room, nil))
+	m.conns <- conn
+	conn.Read(context.Background())
+	conn.Close(nil)
+}
+
+type sipTestClient struct {
+	t    *testing.T
+	conn *net.UDPConn
+	addr net.Addr
+}
+
+func (c *sipTestClient) send(method string, callID string, body string, headers ...string) {
+	contentType := ""
+	if body != "" {
+		contentType = "Content-Type: application/sdp\r\n"
+	}
+	for _, header := range headers {
+		contentType = header + "\r\n" + contentType
+	}
+	msg := fmt.Sprintf(
+		"%s sip:my%%20room@127.0.0.1 SIP/2.0\r\n"+
+			"Via: SIP/2.0/UDP %s;branch=z9hG4bK%s\r\n"+
+			"From: <sip:alice@example.com>;tag=1234\r\n"+
+			"To: <sip:my%%20room@127.0.0.1>\r\n"+
+			"i: %s\r\n"+
+			"CSeq: 1 %s\r\n"+
+			"%s"+
+			"Content-Length: %d\r\n\r\n%s",
+		method, c.conn.LocalAddr(), method, callID, method, contentType, len(body), body,
+	)
+	_, err := c.conn.WriteTo([]byte(msg), c.addr)
+	require.NoError(c.t, err)
+}
+
+func (c *sipTestClient) read() string {
+	buf := make([]byte, 65535)
+	require.NoError(c.t, c.conn.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := c.conn.Read(buf)
+	require.NoError(c.t, err)
+	return string(buf[:n])
+}
+
+var sipTestConfig = server.SIPConfig{
+	AllowedNetworks: []string{"127.0.0.0/8"},
+}
+
+func setupSIPGateway(t *testing.T, config server.SIPConfig) (*mockSIPSignaling, *mockSIPTracks, *sipTestClient) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	tracks := newMockSIPTracks()
+	signaling := newMockSIPSignaling()
+	// RTP is received on the listen IP, the public IP is only advertised
+	gateway, err := server.NewSIPGateway(loggerFactory, conn, net.ParseIP("203.0.113.10"), tracks, signaling, config)
+	require.NoError(t, err)
+	go gateway.Serve()
+
+	clientConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		clientConn.Close()
+		gateway.Close()
+	})
+
+	return signaling, tracks, &sipTestClient{t, clientConn, conn.LocalAddr()}
+}
+
+func sipOffer(port int, rtpmap string) string {
+	return "v=0\r\n" +
+		"o=alice 1 1 IN IP4 127.0.0.1\r\n" +
+		"s=call\r\n" +
+		"c=IN IP4 127.0.0.1\r\n" +
+		"t=0 0\r\n" +
+		fmt.Sprintf("m=audio %d RTP/AVP 0 101\r\n", port) +
+		"a=rtpmap:0 PCMU/8000\r\n" +
+		rtpmap
+}
+
+func TestSIPGateway_OPTIONS(t *testing.T) {
+	_, _, client := setupSIPGateway(t, sipTestConfig)
+
+	client.send("OPTIONS", "options-call", "")
+	res := client.read()
+	assert.True(t, strings.HasPrefix(res, "SIP/2.0 200 OK\r\n"), res)
+	assert.Contains(t, res, "Call-ID: options-call\r\n")
+	assert.Contains(t, res, "CSeq: 1 OPTIONS\r\n")
+}
+
+func TestSIPGateway_INVITE_noOpus(t *testing.T) {
+	_, tracks, client := setupSIPGateway(t, sipTestConfig)
+
+	client.send("INVITE", "pcmu-call", sipOffer(4000, ""))
+	assert.True(t, strings.HasPrefix(client.read(), "SIP/2.0 100 Trying\r\n"))
+	res := client.read()
+	assert.True(t, strings.HasPrefix(res, "SIP/2.0 488 Not Acceptable Here\r\n"), res)
+	assert.Equal(t, 0, tracks.roomTracks("tenant/my room"))
+}
+
+func TestNewSIPGateway_config(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = server.NewSIPGateway(loggerFactory, conn, nil, newMockSIPTracks(), newMockSIPSignaling(), server.SIPConfig{})
+	assert.Error(t, err)
+	_, err = server.NewSIPGateway(loggerFactory, conn, nil, newMockSIPTracks(), newMockSIPSignaling(), server.SIPConfig{
+		AllowedNetworks: []string{"not-a-network"},
+	})
+	assert.Error(t, err)
+}
+
+func TestSIPGateway_INVITE_notAllowed(t *testing.T) {
+	signaling, tracks, client := setupSIPGateway(t, server.SIPConfig{
+		AllowedNetworks: []string{"10.0.0.0/8"},
+	})
+
+	client.send("INVITE", "denied-call", sipOffer(4000, "a=rtpmap:101 opus/48000/2\r\n"))
+	res := client.read()
+	assert.True(t, strings.HasPrefix(res, "SIP/2.0 403 Forbidden\r\n"), res)
+	assert.Equal(t, 0, tracks.roomTracks("tenant/my room"))
+	assert.Equal(t, 0, len(signaling.requests))
+}
+
+func md5Hex(value string) string {
+	sum := md5.Sum([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestSIPGateway_INVITE_digestAuth(t *testing.T) {
+	_, tracks, client := setupSIPGateway(t, server.SIPConfig{
+		Username: "phone",
+		Password: "sip-secret",
+	})
+	offer := sipOffer(4000, "a=rtpmap:101 opus/48000/2\r\n")
+
+	client.send("INVITE", "auth-call", offer)
+	res := client.read()
+	require.True(t, strings.HasPrefix(res, "SIP/2.0 401 Unauthorized\r\n"), res)
+	match := regexp.MustCompile(`WWW-Authenticate: Digest realm="peercalls", nonce="([^"]+)", algorithm=MD5, qop="auth"\r\n`).FindStringSubmatch(res)
+	require.Len(t, match, 2, res)
+	nonce := match[1]
+
+	authorization := func(password string) string {
+		uri := "sip:my%20room@127.0.0.1"
+		ha1 := md5Hex("phone:peercalls:" + password)
+		ha2 := md5Hex("INVITE:" + uri)
+		response := md5Hex(ha1 + ":" + nonce + ":00000001:abc:auth:" + ha2)
+		return fmt.Sprintf(`Authorization: Digest username="phone", realm="peercalls", nonce="%s", uri="%s", response="%s", algorithm=MD5, cnonce="abc", qop=auth, nc=00000001`, nonce, uri, response)
+	}
+
+	client.send("INVITE", "auth-call", offer, authorization("wrong"))
+	res = client.read()
+	assert.True(t, strings.HasPrefix(res, "SIP/2.0 401 Unauthorized\r\n"), res)
+
+	client.send("INVITE", "auth-call", offer, authorization("sip-secret"))
+	assert.True(t, strings.HasPrefix(client.read(), "SIP/2.0 100 Trying\r\n"))
+	res = client.read()
+	assert.True(t, strings.HasPrefix(res, "SIP/2.0 200 OK\r\n"), res)
+	assert.Equal(t, 1, tracks.roomTracks("tenant/my room"))
+}
+
+func TestSIPGateway_INVITE_maxCalls(t *testing.T) {
+	_, tracks, client := setupSIPGateway(t, server.SIPConfig{
+		AllowedNetworks: []string{"127.0.0.0/8"},
+		MaxCalls:        1,
+	})
+	offer := sipOffer(4000, "a=rtpmap:101 opus/48000/2\r\n")
+
+	client.send("INVITE", "call-1", offer)
+	assert.True(t, strings.HasPrefix(client.read(), "SIP/2.0 100 Trying\r\n"))
+	res := client.read()
+	assert.True(t, strings.HasPrefix(res, "SIP/2.0 200 OK\r\n"), res)
+
+	client.send("INVITE", "call-2", offer)
+	res = client.read()
+	assert.True(t, strings.HasPrefix(res, "SIP/2.0 503 Service Unavailable\r\n"), res)
+	assert.Equal(t, 1, tracks.roomTracks("tenant/my room"))
+}
+
+func TestSIPGateway_INVITE_joinRejected(t *testing.T) {
+	signaling, tracks, client := setupSIPGateway(t, sipTestConfig)
+
+	client.send("INVITE", "rejected-call", sipOffer(4000, "a=rtpmap:101 opus/48000/2\r\n"), "X-Room-Password: wrong")
+	assert.True(t, strings.HasPrefix(client.read(), "SIP/2.0 100 Trying\r\n"))
+	res := client.read()
+	assert.True(t, strings.HasPrefix(res, "SIP/2.0 403 Forbidden\r\n"), res)
+	assert.Equal(t, 0, tracks.roomTracks("tenant/my room"))
+
+	req := <-signaling.requests
+	assert.Equal(t, "my room", req.Room)
+	assert.Equal(t, "127.0.0.1", req.IP)
+	assert.Equal(t, "wrong", req.Password)
+	assert.True(t, strings.HasPrefix(req.ClientID, "sip_"), req.ClientID)
+}
+
+func TestSIPGateway_call_leave(t *testing.T) {
+	signaling, tracks, client := setupSIPGateway(t, sipTestConfig)
+
+	client.send("INVITE", "kicked-call", sipOffer(4000, "a=rtpmap:101 opus/48000/2\r\n"))
+	assert.True(t, strings.HasPrefix(client.read(), "SIP/2.0 100 Trying\r\n"))
+	res := client.read()
+	require.True(t, strings.HasPrefix(res, "SIP/2.0 200 OK\r\n"), res)
+	assert.Equal(t, 1, tracks.roomTracks("tenant/my room"))
+
+	// for example when the call is kicked from the room
+	conn := <-signaling.conns
+	conn.Close(nil)
+	require.Eventually(t, func() bool {
+		return tracks.roomTracks("tenant/my room") == 0
+	}, time.Second, 10*time.Millisecond)
+
+	client.send("BYE", "kicked-call", "")
+	res = client.read()
+	assert.True(t, strings.HasPrefix(res, "SIP/2.0 481 "), res)
+}
+
+func TestSIPGateway_call(t *testing.T) {
+	_, tracks, client := setupSIPGateway(t, sipTestConfig)
+
+	phoneRTP, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer phoneRTP.Close()
+	phonePort := phoneRTP.LocalAddr().(*net.UDPAddr).Port
+
+	client.send("INVITE", "opus-call", sipOffer(phonePort, "a=rtpmap:101 opus/48000/2\r\n"))
+	assert.True(t, strings.HasPrefix(client.read(), "SIP/2.0 100 Trying\r\n"))
+	res := client.read()
+	require.True(t, strings.HasPrefix(res, "SIP/2.0 200 OK\r\n"), res)
+	assert.Contains(t, res, "Content-Type: application/sdp\r\n")
+	assert.Contains(t, res, "a=rtpmap:101 opus/48000/2\r\n")
+	assert.Contains(t, res, "c=IN IP4 203.0.113.10\r\n")
+	assert.Regexp(t, `To: <sip:my%20room@127.0.0.1>;tag=\w+`, res)
+	assert.Equal(t, 1, tracks.roomTracks("tenant/my room"))
+
+	// Audio of peers in the room is sent to the phone using the negotiated
+	// payload type.
+	require.Len(t, tracks.factories, 1)
+	codec := webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000)
+	localTrack, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeOpus, 123, "track-id", "label", codec)
+	require.NoError(t, err)
+	interceptor, err := tracks.factories[0].NewInterceptor(server.InterceptorParams{
+		ClientID:   "peer-1",
+		Room:       "tenant/my room",
+		LocalTrack: localTrack,
+	})
+	require.NoError(t, err)
+	writer := interceptor.BindRTP(server.RTPWriterFunc(func(packet *rtp.Packet) error {
+		return nil
+	}))
+	err = writer.WriteRTP(&rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			PayloadType:    webrtc.DefaultPayloadTypeOpus,
+			SequenceNumber: 500,
+			SSRC:           123,
+		},
+		Payload: []byte{0xfc, 0x01},
+	})
+	require.NoError(t, err)
+
+	buf := make([]byte, 1500)
+	require.NoError(t, phoneRTP.SetReadDeadline(time.Now().Add(time.Second)))
+	n, err := phoneRTP.Read(buf)
+	require.NoError(t, err)
+	var packet rtp.Packet
+	require.NoError(t, packet.Unmarshal(buf[:n]))
+	assert.Equal(t, uint8(101), packet.PayloadType)
+	assert.Equal(t, uint16(1), packet.SequenceNumber)
+	assert.Equal(t, []byte{0xfc, 0x01}, packet.Payload)
+
+	client.send("BYE", "opus-call", "")
+	res = client.read()
+	assert.True(t, strings.HasPrefix(res, "SIP/2.0 200 OK\r\n"), res)
+	assert.Equal(t, 0, tracks.roomTracks("tenant/my room"))
+
+	client.send("BYE", "opus-call", "")
+	res = client.read()
+	assert.True(t, strings.HasPrefix(res, "SIP/2.0 481 "), res)
+}
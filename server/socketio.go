@@ -0,0 +1,334 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// Packet types of the engine.io protocol.
+const (
+	engineIOOpen    = '0'
+	engineIOClose   = '1'
+	engineIOPing    = '2'
+	engineIOPong    = '3'
+	engineIOMessage = '4'
+)
+
+// Packet types of the socket.io protocol, sent in engine.io messages.
+const (
+	socketIOConnect    = '0'
+	socketIODisconnect = '1'
+	socketIOEvent      = '2'
+)
+
+const (
+	defaultSocketIOPingInterval = 25 * time.Second
+	defaultSocketIOPingTimeout  = 20 * time.Second
+)
+
+// SetSocketIO enables the Socket.IO compatible signaling endpoint at
+// /socket.io/. It must be called before the Mux serves requests.
+func (mux *Mux) SetSocketIO(config SocketIOConfig) {
+	mux.socketIO = config
+}
+
+func (mux *Mux) serveSocketIO(w http.ResponseWriter, r *http.Request) {
+	if !mux.socketIO.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	mux.WSS.SocketIOHandler(mux.Signaling, mux.socketIO).ServeHTTP(w, r)
+}
+
+// SocketIOHandler accepts connections of Socket.IO clients and passes them
+// to handler. Only the websocket transport of engine.io protocol versions 3
+// and 4 is supported, so clients must be created with the websocket
+// transport only. The room and client ID are taken from the room and userId
+// query parameters.
+//
+// Socket.IO events are mapped to messages of the same type and events are
+// emitted for all messages sent to the client, with the payload as the only
+// argument. Only the default namespace is supported.
+func (wss *WSS) SocketIOHandler(handler SignalingHandler, config SocketIOConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		eio := query.Get("EIO")
+		if eio != "3" && eio != "4" {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"code":    5,
+				"message": "Unsupported protocol version",
+			})
+			return
+		}
+		if query.Get("transport") != "websocket" {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+				"code":    0,
+				"message": "Transport unknown",
+			})
+			return
+		}
+
+		room := query.Get("room")
+		clientID := query.Get("userId")
+		if room == "" || clientID == "" {
+			http.Error(w, "room and userId are required", http.StatusBadRequest)
+			return
+		}
+
+		c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+			CompressionMode: websocket.CompressionDisabled,
+		})
+		if err != nil {
+			wss.log.Printf("Error accepting socket.io connection: %s", err)
+			return
+		}
+
+		conn, err := newSocketIOConn(r.Context(), c, room, eio == "3", config)
+		if err != nil {
+			wss.log.Printf("Error opening socket.io connection: %s", err)
+			c.Close(websocket.StatusInternalError, "")
+			return
+		}
+
+		handler.ServeSignaling(conn, SignalingRequest{
+			Context:     r.Context(),
+			Room:        room,
+			ClientID:    clientID,
+			IP:          clientIP(r, wss.rateLimits.config.TrustProxy),
+			Host:        r.Host,
+			Password:    query.Get("password"),
+			EgressToken: query.Get("egressToken"),
+			Token:       query.Get("token"),
+			TraceParent: query.Get("traceparent"),
+		})
+	})
+}
+
+// socketIOConn translates between socket.io event packets and messages. It
+// answers pings of engine.io 3 clients and pings engine.io 4 clients.
+type socketIOConn struct {
+	ws   *websocket.Conn
+	sid  string
+	room string
+	// legacy is true for engine.io 3, in which clients send pings
+	legacy       bool
+	pingInterval time.Duration
+	pingTimeout  time.Duration
+
+	mu       sync.Mutex
+	lastPong time.Time
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var _ SignalingConn = &socketIOConn{}
+
+func newSocketIOConn(ctx context.Context, ws *websocket.Conn, room string, legacy bool, config SocketIOConfig) (*socketIOConn, error) {
+	c := &socketIOConn{
+		ws:           ws,
+		sid:          NewUUIDBase62(),
+		room:         room,
+		legacy:       legacy,
+		pingInterval: config.PingInterval,
+		pingTimeout:  config.PingTimeout,
+		lastPong:     time.Now(),
+		closed:       make(chan struct{}),
+	}
+	if c.pingInterval <= 0 {
+		c.pingInterval = defaultSocketIOPingInterval
+	}
+	if c.pingTimeout <= 0 {
+		c.pingTimeout = defaultSocketIOPingTimeout
+	}
+
+	open, err := json.Marshal(map[string]interface{}{
+		"sid":          c.sid,
+		"upgrades":     []string{},
+		"pingInterval": c.pingInterval.Milliseconds(),
+		"pingTimeout":  c.pingTimeout.Milliseconds(),
+		"maxPayload":   maxSignalingFrameSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.writePacket(ctx, string(engineIOOpen)+string(open)); err != nil {
+		return nil, err
+	}
+
+	if legacy {
+		// the default namespace is connected without a request in socket.io 2
+		if err := c.writePacket(ctx, string([]byte{engineIOMessage, socketIOConnect})); err != nil {
+			return nil, err
+		}
+	} else {
+		go c.ping()
+	}
+
+	return c, nil
+}
+
+func (c *socketIOConn) writePacket(ctx context.Context, packet string) error {
+	return c.ws.Write(ctx, websocket.MessageText, []byte(packet))
+}
+
+// ping pings the client every ping interval and closes the connection when
+// the client has not answered within the ping timeout.
+func (c *socketIOConn) ping() {
+	ticker := time.NewTicker(c.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		lastPong := c.lastPong
+		c.mu.Unlock()
+
+		if time.Since(lastPong) > c.pingInterval+c.pingTimeout {
+			c.close(websocket.StatusGoingAway, "ping timeout")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.pingTimeout)
+		err := c.writePacket(ctx, string(engineIOPing))
+		cancel()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Read reads packets until an event is received, and returns it as a
+// message.
+func (c *socketIOConn) Read(ctx context.Context) (websocket.MessageType, []byte, error) {
+	for {
+		typ, packet, err := c.ws.Read(ctx)
+		if err != nil {
+			return typ, nil, err
+		}
+		if len(packet) == 0 {
+			continue
+		}
+
+		switch packet[0] {
+		case engineIOPing:
+			if err := c.writePacket(ctx, string(engineIOPong)+string(packet[1:])); err != nil {
+				return 0, nil, err
+			}
+		case engineIOPong:
+			c.mu.Lock()
+			c.lastPong = time.Now()
+			c.mu.Unlock()
+		case engineIOClose:
+			return 0, nil, io.EOF
+		case engineIOMessage:
+			data, err := c.handleSocketIOPacket(ctx, packet[1:])
+			if err != nil {
+				return 0, nil, err
+			}
+			if data != nil {
+				return websocket.MessageText, data, nil
+			}
+		}
+	}
+}
+
+// handleSocketIOPacket returns a message when packet is an event of the
+// default namespace.
+func (c *socketIOConn) handleSocketIOPacket(ctx context.Context, packet []byte) ([]byte, error) {
+	if len(packet) == 0 {
+		return nil, nil
+	}
+
+	switch packet[0] {
+	case socketIOConnect:
+		connect, err := json.Marshal(map[string]string{"sid": c.sid})
+		if err != nil {
+			return nil, err
+		}
+		return nil, c.writePacket(ctx, string([]byte{engineIOMessage, socketIOConnect})+string(connect))
+	case socketIODisconnect:
+		return nil, io.EOF
+	case socketIOEvent:
+	default:
+		return nil, nil
+	}
+
+	data := packet[1:]
+	if len(data) > 0 && data[0] == '/' {
+		// other namespaces are not supported
+		return nil, nil
+	}
+	// skip the acknowledgement ID, acknowledgements are not sent
+	data = bytes.TrimLeft(data, "0123456789")
+
+	var args []json.RawMessage
+	if err := json.Unmarshal(data, &args); err != nil || len(args) == 0 {
+		return nil, fmt.Errorf("invalid socket.io event: %q", packet)
+	}
+
+	var msgType string
+	if err := json.Unmarshal(args[0], &msgType); err != nil {
+		return nil, fmt.Errorf("invalid socket.io event name: %q", packet)
+	}
+
+	var payload json.RawMessage
+	if len(args) > 1 {
+		payload = args[1]
+	}
+
+	return json.Marshal(struct {
+		Type    string          `json:"type"`
+		Room    string          `json:"room"`
+		Payload json.RawMessage `json:"payload,omitempty"`
+	}{msgType, c.room, payload})
+}
+
+// Write emits the message in data as an event.
+func (c *socketIOConn) Write(ctx context.Context, typ websocket.MessageType, data []byte) error {
+	var msg struct {
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return fmt.Errorf("socket.io: invalid message: %w", err)
+	}
+
+	args := []json.RawMessage{nil, msg.Payload}
+	var err error
+	if args[0], err = json.Marshal(msg.Type); err != nil {
+		return err
+	}
+	event, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	return c.writePacket(ctx, string([]byte{engineIOMessage, socketIOEvent})+string(event))
+}
+
+func (c *socketIOConn) Close(reason error) error {
+	return c.close(websocketCloseStatus(reason))
+}
+
+func (c *socketIOConn) close(code websocket.StatusCode, reason string) error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return c.ws.Close(code, reason)
+}
@@ -0,0 +1,104 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestSocketIO(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	wss := server.NewWSS(loggerFactory, rooms)
+	handler := wss.SocketIOHandler(server.NewMeshSignalingHandler(loggerFactory, wss), server.SocketIOConfig{
+		PingInterval: 50 * time.Millisecond,
+	})
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	url := s.URL + "/socket.io/?room=" + roomName + "&userId=" + clientID
+
+	res, err := http.Get(url + "&EIO=4&transport=polling")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	read := func(ws *websocket.Conn) string {
+		t.Helper()
+		_, data, err := ws.Read(ctx)
+		require.NoError(t, err)
+		return string(data)
+	}
+	write := func(ws *websocket.Conn, packet string) {
+		t.Helper()
+		require.NoError(t, ws.Write(ctx, websocket.MessageText, []byte(packet)))
+	}
+	// readPacket skips events and pings until packet is received
+	readPacket := func(ws *websocket.Conn, packet string) {
+		t.Helper()
+		for read(ws) != packet {
+		}
+	}
+	readEvent := func(ws *websocket.Conn, name string) interface{} {
+		t.Helper()
+		for {
+			packet := read(ws)
+			if !strings.HasPrefix(packet, "42") {
+				continue
+			}
+			var args []interface{}
+			require.NoError(t, json.Unmarshal([]byte(packet[2:]), &args))
+			if args[0] == name {
+				return args[1]
+			}
+		}
+	}
+
+	ws := mustDialWS(t, ctx, "ws"+strings.TrimPrefix(url, "http")+"&EIO=4&transport=websocket")
+	defer ws.Close(websocket.StatusNormalClosure, "")
+
+	open := read(ws)
+	require.Equal(t, "0", open[:1])
+	var handshake map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(open[1:]), &handshake))
+	assert.Equal(t, 50.0, handshake["pingInterval"])
+	assert.Equal(t, []interface{}{}, handshake["upgrades"])
+
+	write(ws, "40")
+	readPacket(ws, `40{"sid":"`+handshake["sid"].(string)+`"}`)
+
+	readPacket(ws, "2")
+	write(ws, "3")
+
+	write(ws, `42["ready",{"nickname":"some-user"}]`)
+	users := readEvent(ws, "users").(map[string]interface{})
+	assert.Equal(t, clientID, users["initiator"])
+	assert.Equal(t, map[string]interface{}{clientID: "some-user"}, users["nicknames"])
+
+	// socket.io 2 clients ping the server and are connected to the default
+	// namespace without a request
+	legacy := mustDialWS(t, ctx, "ws"+strings.TrimPrefix(s.URL, "http")+"/socket.io/?room="+roomName+"&userId=legacy&EIO=3&transport=websocket")
+	defer legacy.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, "0", read(legacy)[:1])
+	assert.Equal(t, "40", read(legacy))
+	write(legacy, "2")
+	readPacket(legacy, "3")
+
+	write(legacy, `421["signal",{"userId":"`+clientID+`","signal":{"type":"offer"}}]`)
+	signal := readEvent(ws, "signal").(map[string]interface{})
+	assert.Equal(t, "legacy", signal["userId"])
+	assert.Equal(t, map[string]interface{}{"type": "offer"}, signal["signal"])
+}
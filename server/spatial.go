@@ -0,0 +1,189 @@
+package server
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	MessageTypePosition = "position"
+	// MessageTypePositions is sent to joining clients with the positions of
+	// clients already in the room.
+	MessageTypePositions = "positions"
+)
+
+const (
+	defaultPositionInterval = 100 * time.Millisecond
+	defaultMaxCoordinate    = 10000
+)
+
+// Position is the virtual position of a client, used by clients to render
+// spatial audio. The server does not interpret it.
+type Position struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+	// Heading is the direction the client faces in degrees, in the range
+	// [0, 360).
+	Heading float64 `json:"heading"`
+}
+
+// PositionEvent is broadcast when a client changes its position.
+type PositionEvent struct {
+	ClientID string `json:"userId"`
+	Position
+}
+
+// SetSpatial sets how positions are validated and how often they are
+// broadcast. It must be called before any connections are handled.
+func (wss *WSS) SetSpatial(config SpatialConfig) {
+	wss.spatial = config
+}
+
+// parsePosition returns false when payload is not a valid position. X and Y
+// are required, and all coordinates must be within maxCoordinate of the
+// origin.
+func parsePosition(payload interface{}, maxCoordinate float64) (Position, bool) {
+	data, _ := payload.(map[string]interface{})
+	x, okX := data["x"].(float64)
+	y, okY := data["y"].(float64)
+	z, _ := data["z"].(float64)
+	heading, _ := data["heading"].(float64)
+
+	if !okX || !okY {
+		return Position{}, false
+	}
+
+	for _, coordinate := range []float64{x, y, z} {
+		if math.Abs(coordinate) > maxCoordinate {
+			return Position{}, false
+		}
+	}
+
+	heading = math.Mod(heading, 360)
+	if heading < 0 {
+		heading += 360
+	}
+
+	return Position{X: x, Y: y, Z: z, Heading: heading}, true
+}
+
+// handlePosition stores the position of clientID and broadcasts it to all
+// clients in the room, including the sender. Positions are broadcast at
+// most once per interval, later ones are coalesced so that the last
+// position is always broadcast. It returns false when message is not a
+// position.
+func (wss *WSS) handlePosition(adapter Adapter, room string, clientID string, conn *wsConnection, message Message) bool {
+	if message.Type != MessageTypePosition {
+		return false
+	}
+
+	if conn.hidden {
+		return true
+	}
+
+	maxCoordinate := wss.spatial.MaxCoordinate
+	if maxCoordinate <= 0 {
+		maxCoordinate = defaultMaxCoordinate
+	}
+
+	position, ok := parsePosition(message.Payload, maxCoordinate)
+	if !ok {
+		wss.log.Printf("[%s] Invalid position in room: %s", clientID, room)
+		return true
+	}
+
+	wss.connectionsMu.Lock()
+	l, ok := wss.lifecycles[room]
+	if !ok || wss.connections[room][clientID] != conn {
+		wss.connectionsMu.Unlock()
+		return true
+	}
+	if l.positions == nil {
+		l.positions = map[string]Position{}
+	}
+	l.positions[clientID] = position
+	wss.connectionsMu.Unlock()
+
+	interval := wss.spatial.Interval
+	if interval <= 0 {
+		interval = defaultPositionInterval
+	}
+
+	if conn.throttlePosition(interval, time.Now(), func() {
+		wss.broadcastPosition(adapter, room, clientID, conn)
+	}) {
+		wss.broadcastPosition(adapter, room, clientID, conn)
+	}
+	return true
+}
+
+// broadcastPosition broadcasts the last position of clientID, unless the
+// client has disconnected in the meantime.
+func (wss *WSS) broadcastPosition(adapter Adapter, room string, clientID string, conn *wsConnection) {
+	wss.connectionsMu.Lock()
+	l, ok := wss.lifecycles[room]
+	if !ok || wss.connections[room][clientID] != conn {
+		wss.connectionsMu.Unlock()
+		return
+	}
+	position := l.positions[clientID]
+	wss.connectionsMu.Unlock()
+
+	err := adapter.Broadcast(NewMessage(MessageTypePosition, room, PositionEvent{
+		ClientID: clientID,
+		Position: position,
+	}))
+	if err != nil {
+		wss.log.Printf("[%s] Error broadcasting position: %s", clientID, err)
+	}
+}
+
+// sendPositions sends the positions of clients in room to client.
+func (wss *WSS) sendPositions(client *Client, room string) error {
+	wss.connectionsMu.Lock()
+	positions := map[string]Position{}
+	if l, ok := wss.lifecycles[room]; ok {
+		for clientID, position := range l.positions {
+			positions[clientID] = position
+		}
+	}
+	wss.connectionsMu.Unlock()
+
+	if len(positions) == 0 {
+		return nil
+	}
+
+	return client.Write(NewMessage(MessageTypePositions, room, map[string]interface{}{
+		"positions": positions,
+	}))
+}
+
+// throttlePosition returns true when a position can be broadcast now.
+// Otherwise, flush is called once the interval since the last broadcast has
+// passed, unless it is already scheduled.
+func (c *wsConnection) throttlePosition(interval time.Duration, now time.Time, flush func()) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.positionTimer != nil {
+		// the scheduled flush broadcasts the latest position
+		return false
+	}
+
+	elapsed := now.Sub(c.positionSentAt)
+	if elapsed >= interval {
+		c.positionSentAt = now
+		return true
+	}
+
+	c.positionTimer = time.AfterFunc(interval-elapsed, func() {
+		c.mu.Lock()
+		c.positionTimer = nil
+		c.positionSentAt = time.Now()
+		c.mu.Unlock()
+
+		flush()
+	})
+	return false
+}
@@ -0,0 +1,94 @@
+package server_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"nhooyr.io/websocket"
+)
+
+func TestWSS_positions(t *testing.T) {
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	wss := server.NewWSS(loggerFactory, rooms)
+	wss.SetSpatial(server.SpatialConfig{
+		Interval:      50 * time.Millisecond,
+		MaxCoordinate: 100,
+	})
+	s := httptest.NewServer(server.NewMeshHandler(loggerFactory, wss))
+	defer s.Close()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + room + "/"
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ready := func(clientID string) server.Message {
+		return server.NewMessage("ready", room, map[string]interface{}{
+			"nickname": clientID,
+		})
+	}
+
+	wsB := mustDialWS(t, ctx, wsURL+"b")
+	defer wsB.Close(websocket.StatusNormalClosure, "")
+	mustWriteWS(t, ctx, wsB, ready("b"))
+	mustReadWSType(t, ctx, wsB, "users")
+
+	wsA := mustDialWS(t, ctx, wsURL+"a")
+	defer wsA.Close(websocket.StatusNormalClosure, "")
+	mustWriteWS(t, ctx, wsA, ready("a"))
+	mustReadWSType(t, ctx, wsB, "users")
+
+	position := func(x, y float64, heading float64) server.Message {
+		return server.NewMessage(server.MessageTypePosition, room, map[string]interface{}{
+			"x":       x,
+			"y":       y,
+			"heading": heading,
+		})
+	}
+	mustWriteWS(t, ctx, wsA, position(1, 2, 90))
+	// coalesced with the next position
+	mustWriteWS(t, ctx, wsA, position(3, 4, 90))
+	// out of bounds and dropped
+	mustWriteWS(t, ctx, wsA, position(1000, 4, 0))
+	mustWriteWS(t, ctx, wsA, position(5, 6, -90))
+
+	msg := mustReadWS(t, ctx, wsB)
+	assert.Equal(t, server.MessageTypePosition, msg.Type)
+	assert.Equal(t, map[string]interface{}{
+		"userId":  "a",
+		"x":       1.0,
+		"y":       2.0,
+		"z":       0.0,
+		"heading": 90.0,
+	}, msg.Payload)
+
+	msg = mustReadWS(t, ctx, wsB)
+	assert.Equal(t, server.MessageTypePosition, msg.Type)
+	assert.Equal(t, map[string]interface{}{
+		"userId":  "a",
+		"x":       5.0,
+		"y":       6.0,
+		"z":       0.0,
+		"heading": 270.0,
+	}, msg.Payload)
+
+	wsC := mustDialWS(t, ctx, wsURL+"c")
+	defer wsC.Close(websocket.StatusNormalClosure, "")
+	msg = mustReadWSType(t, ctx, wsC, server.MessageTypePositions)
+	assert.Equal(t, map[string]interface{}{
+		"positions": map[string]interface{}{
+			"a": map[string]interface{}{
+				"x":       5.0,
+				"y":       6.0,
+				"z":       0.0,
+				"heading": 270.0,
+			},
+		},
+	}, msg.Payload)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/pion/rtcp"
+)
+
+// ssrcRegistry keeps the SSRCs of local tracks unique. Publishers choose
+// their SSRCs independently, so two of them may use the same one, and a
+// subscriber receiving both tracks on its peer connection would not be able
+// to tell their packets apart. SSRCs are unique across the server, not only
+// within a room, so they stay unique when peers move between rooms.
+type ssrcRegistry struct {
+	mu    sync.Mutex
+	inUse map[uint32]struct{}
+}
+
+func newSSRCRegistry() *ssrcRegistry {
+	return &ssrcRegistry{
+		inUse: map[uint32]struct{}{},
+	}
+}
+
+// acquire returns ssrc when it is not in use by another local track, or a
+// random unused SSRC otherwise. The returned SSRC must be released when the
+// track is removed.
+func (r *ssrcRegistry) acquire(ssrc uint32) uint32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for {
+		if _, ok := r.inUse[ssrc]; !ok && ssrc != 0 {
+			r.inUse[ssrc] = struct{}{}
+			return ssrc
+		}
+		ssrc = rand.Uint32()
+	}
+}
+
+func (r *ssrcRegistry) release(ssrc uint32) {
+	r.mu.Lock()
+	delete(r.inUse, ssrc)
+	r.mu.Unlock()
+}
+
+// ssrcTranslator writes RTCP feedback received for a local track to the
+// publisher, with the SSRC of the local track replaced by the SSRC of the
+// remote track when they differ.
+type ssrcTranslator struct {
+	localSSRC  uint32
+	remoteSSRC uint32
+	next       RTCPWriter
+}
+
+func newSSRCTranslator(localSSRC, remoteSSRC uint32, next RTCPWriter) RTCPWriter {
+	if localSSRC == remoteSSRC {
+		return next
+	}
+	return &ssrcTranslator{
+		localSSRC:  localSSRC,
+		remoteSSRC: remoteSSRC,
+		next:       next,
+	}
+}
+
+func (s *ssrcTranslator) WriteRTCP(packets []rtcp.Packet) error {
+	translated := make([]rtcp.Packet, len(packets))
+	for i, packet := range packets {
+		translated[i] = s.translate(packet)
+	}
+	return s.next.WriteRTCP(translated)
+}
+
+// translate returns a copy of packet with the media SSRC translated. Packets
+// are copied because the same packets may be written to other writers.
+func (s *ssrcTranslator) translate(packet rtcp.Packet) rtcp.Packet {
+	switch p := packet.(type) {
+	case *rtcp.PictureLossIndication:
+		c := *p
+		c.MediaSSRC = s.ssrc(c.MediaSSRC)
+		return &c
+	case *rtcp.TransportLayerNack:
+		c := *p
+		c.MediaSSRC = s.ssrc(c.MediaSSRC)
+		return &c
+	case *rtcp.SliceLossIndication:
+		c := *p
+		c.MediaSSRC = s.ssrc(c.MediaSSRC)
+		return &c
+	case *rtcp.RapidResynchronizationRequest:
+		c := *p
+		c.MediaSSRC = s.ssrc(c.MediaSSRC)
+		return &c
+	case *rtcp.ReceiverEstimatedMaximumBitrate:
+		c := *p
+		c.SSRCs = make([]uint32, len(p.SSRCs))
+		for i, ssrc := range p.SSRCs {
+			c.SSRCs[i] = s.ssrc(ssrc)
+		}
+		return &c
+	case *rtcp.ReceiverReport:
+		c := *p
+		c.Reports = make([]rtcp.ReceptionReport, len(p.Reports))
+		for i, report := range p.Reports {
+			report.SSRC = s.ssrc(report.SSRC)
+			c.Reports[i] = report
+		}
+		return &c
+	}
+	return packet
+}
+
+func (s *ssrcTranslator) ssrc(ssrc uint32) uint32 {
+	if ssrc == s.localSSRC {
+		return s.remoteSSRC
+	}
+	return ssrc
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pion/rtcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSRCRegistry(t *testing.T) {
+	r := newSSRCRegistry()
+
+	assert.Equal(t, uint32(1234), r.acquire(1234))
+
+	remapped := r.acquire(1234)
+	assert.NotEqual(t, uint32(1234), remapped, "duplicate SSRCs are remapped")
+	assert.NotEqual(t, uint32(0), remapped)
+
+	r.release(1234)
+	assert.Equal(t, uint32(1234), r.acquire(1234), "released SSRCs can be used again")
+	assert.NotEqual(t, uint32(0), r.acquire(0))
+}
+
+func TestSSRCTranslator(t *testing.T) {
+	var written []rtcp.Packet
+	next := RTCPWriterFunc(func(packets []rtcp.Packet) error {
+		written = packets
+		return nil
+	})
+
+	_, ok := newSSRCTranslator(1234, 1234, next).(*ssrcTranslator)
+	assert.False(t, ok, "nothing to translate")
+
+	pli := &rtcp.PictureLossIndication{SenderSSRC: 1, MediaSSRC: 5678}
+	packets := []rtcp.Packet{
+		pli,
+		&rtcp.PictureLossIndication{SenderSSRC: 1, MediaSSRC: 42},
+		&rtcp.TransportLayerNack{MediaSSRC: 5678, Nacks: []rtcp.NackPair{{PacketID: 5}}},
+		&rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: 100000, SSRCs: []uint32{5678, 42}},
+		&rtcp.ReceiverReport{SSRC: 1, Reports: []rtcp.ReceptionReport{{SSRC: 5678}}},
+		&rtcp.Goodbye{Sources: []uint32{5678}},
+	}
+
+	err := newSSRCTranslator(5678, 1234, next).WriteRTCP(packets)
+	require.NoError(t, err)
+	require.Len(t, written, len(packets))
+
+	assert.Equal(t, &rtcp.PictureLossIndication{SenderSSRC: 1, MediaSSRC: 1234}, written[0])
+	assert.Equal(t, uint32(5678), pli.MediaSSRC, "packets are copied")
+	assert.Equal(t, uint32(42), written[1].(*rtcp.PictureLossIndication).MediaSSRC, "other SSRCs are kept")
+	assert.Equal(t, uint32(1234), written[2].(*rtcp.TransportLayerNack).MediaSSRC)
+	assert.Equal(t, []uint32{1234, 42}, written[3].(*rtcp.ReceiverEstimatedMaximumBitrate).SSRCs)
+	assert.Equal(t, uint32(1234), written[4].(*rtcp.ReceiverReport).Reports[0].SSRC)
+	assert.Equal(t, uint32(1), written[4].(*rtcp.ReceiverReport).SSRC)
+	assert.Equal(t, packets[5], written[5])
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"net"
 	"net/http"
 )
@@ -8,6 +9,8 @@ import (
 type ServerParams struct {
 	TLSCertFile string
 	TLSKeyFile  string
+	// Hosts with TLS certificates are served via SNI
+	Hosts []HostConfig
 }
 
 type StartStopper struct {
@@ -26,8 +29,17 @@ func NewStartStopper(params ServerParams, handler http.Handler) *StartStopper {
 }
 
 func (s StartStopper) Start(l net.Listener) (err error) {
-	if s.params.TLSCertFile != "" {
-		err = s.server.ServeTLS(l, s.params.TLSCertFile, s.params.TLSKeyFile)
+	tlsConfig, err := NewSNIConfig(TLSConfig{
+		Cert: s.params.TLSCertFile,
+		Key:  s.params.TLSKeyFile,
+	}, s.params.Hosts)
+	if err != nil {
+		return err
+	}
+
+	if tlsConfig != nil {
+		s.server.TLSConfig = tlsConfig
+		err = s.server.ServeTLS(l, "", "")
 	} else {
 		err = s.server.Serve(l)
 	}
@@ -37,3 +49,10 @@ func (s StartStopper) Start(l net.Listener) (err error) {
 func (s StartStopper) Stop() error {
 	return s.server.Close()
 }
+
+// Shutdown stops accepting connections and waits for the active HTTP
+// requests to complete. Websocket connections are not closed. Start returns
+// http.ErrServerClosed afterwards.
+func (s StartStopper) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
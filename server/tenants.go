@@ -0,0 +1,267 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// tenantSeparator separates the tenant from the name of a room, for example
+// acme:standup.
+const tenantSeparator = ":"
+
+var (
+	ErrInvalidToken    = &JoinError{Code: "invalidToken", Message: "Invalid token"}
+	ErrTenantRoomLimit = &JoinError{Code: "tenantRoomLimit", Message: "Too many rooms"}
+	ErrTenantFull      = &JoinError{Code: "tenantFull", Message: "Too many participants"}
+)
+
+var ErrInvalidTenant = errors.New("invalid tenant")
+
+// JoinTokenClaims are the claims of the HS256 JWT clients of a tenant join
+// rooms with.
+type JoinTokenClaims struct {
+	Tenant string `json:"tenant"`
+	// Room restricts the token to a single room of the tenant, when set.
+	Room string `json:"room,omitempty"`
+	// ExpiresAt is the expiration time in seconds since the epoch. Tokens
+	// without an expiration time never expire.
+	ExpiresAt int64 `json:"exp,omitempty"`
+}
+
+type joinTokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// SignJoinToken creates a join token with claims, signed with the join
+// secret of the tenant.
+func SignJoinToken(secret string, claims JoinTokenClaims) (string, error) {
+	header, err := json.Marshal(joinTokenHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + signJoinToken(secret, unsigned), nil
+}
+
+func signJoinToken(secret string, unsigned string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Tenant is a customer sharing the server with other tenants. Rooms of a
+// tenant are named <tenant>:<room>, so that tenants can use the same room
+// names without meeting each other. A nil Tenant has access to all rooms.
+type Tenant struct {
+	TenantConfig
+}
+
+// Room returns the name of room within the namespace of t.
+func (t *Tenant) Room(room string) string {
+	if t == nil {
+		return room
+	}
+	return t.Name + tenantSeparator + room
+}
+
+// Owns returns true when room is in the namespace of t.
+func (t *Tenant) Owns(room string) bool {
+	return t == nil || strings.HasPrefix(room, t.Name+tenantSeparator)
+}
+
+// Tenants are the tenants configured on the server. A nil Tenants means the
+// server is not multi-tenant.
+type Tenants struct {
+	// key is tenant name
+	tenants map[string]*Tenant
+}
+
+// NewTenants validates configs. Returns nil when configs is empty.
+func NewTenants(configs []TenantConfig) (*Tenants, error) {
+	if len(configs) == 0 {
+		return nil, nil
+	}
+
+	tenants := &Tenants{
+		tenants: make(map[string]*Tenant, len(configs)),
+	}
+	for _, config := range configs {
+		switch {
+		case config.Name == "" || strings.ContainsAny(config.Name, tenantSeparator+"/"):
+			return nil, fmt.Errorf("%w name: %q", ErrInvalidTenant, config.Name)
+		case config.JoinSecret == "":
+			return nil, fmt.Errorf("%w: %s: join secret is required", ErrInvalidTenant, config.Name)
+		}
+		if _, ok := tenants.tenants[config.Name]; ok {
+			return nil, fmt.Errorf("%w: %s: duplicate name", ErrInvalidTenant, config.Name)
+		}
+		tenants.tenants[config.Name] = &Tenant{config}
+	}
+	return tenants, nil
+}
+
+// Tenant returns the tenant with name.
+func (t *Tenants) Tenant(name string) (*Tenant, bool) {
+	if t == nil {
+		return nil, false
+	}
+	tenant, ok := t.tenants[name]
+	return tenant, ok
+}
+
+// RoomTenant returns the tenant room belongs to.
+func (t *Tenants) RoomTenant(room string) (*Tenant, bool) {
+	i := strings.Index(room, tenantSeparator)
+	if i < 0 {
+		return nil, false
+	}
+	return t.Tenant(room[:i])
+}
+
+// ByAPIKey returns the tenant with the admin API key key.
+func (t *Tenants) ByAPIKey(key string) (*Tenant, bool) {
+	if t == nil || key == "" {
+		return nil, false
+	}
+
+	var found *Tenant
+	for _, tenant := range t.tenants {
+		// compare all keys so that the time taken does not reveal which
+		// tenant matched
+		if tenant.APIKey != "" && subtle.ConstantTimeCompare([]byte(tenant.APIKey), []byte(key)) == 1 {
+			found = tenant
+		}
+	}
+	return found, found != nil
+}
+
+// VerifyJoinToken returns the tenant of a client joining room with token.
+func (t *Tenants) VerifyJoinToken(token string, room string, now time.Time) (*Tenant, *JoinError) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var header joinTokenHeader
+	if err := decodeJoinTokenPart(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+
+	var claims JoinTokenClaims
+	if err := decodeJoinTokenPart(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	tenant, ok := t.Tenant(claims.Tenant)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	signature := signJoinToken(tenant.JoinSecret, parts[0]+"."+parts[1])
+	if !hmac.Equal([]byte(signature), []byte(parts[2])) {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.ExpiresAt != 0 && now.Unix() >= claims.ExpiresAt {
+		return nil, ErrInvalidToken
+	}
+	if claims.Room != "" && claims.Room != mainRoom(room) {
+		return nil, ErrInvalidToken
+	}
+
+	return tenant, nil
+}
+
+func decodeJoinTokenPart(part string, value interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// RoomMaxBitrate returns the maximum bitrate of publishers in room set by
+// its tenant, zero when not set.
+func (t *Tenants) RoomMaxBitrate(room string) int {
+	if tenant, ok := t.RoomTenant(room); ok {
+		return tenant.MaxBitrate
+	}
+	return 0
+}
+
+// SetTenants enables multi-tenancy. Clients must then join with a join token
+// of a tenant, and the admin API also accepts the API keys of tenants. It
+// must be called before any connections are handled.
+func (wss *WSS) SetTenants(tenants *Tenants) {
+	wss.tenants = tenants
+}
+
+// Tenants returns the tenants set with SetTenants.
+func (wss *WSS) Tenants() *Tenants {
+	return wss.tenants
+}
+
+// tenantSignaling namespaces the room of clients with the tenant of their
+// join token, or rejects them when the token is invalid. Egress peers join
+// with the namespaced room name.
+func (wss *WSS) tenantSignaling(next SignalingHandler) SignalingHandler {
+	return SignalingHandlerFunc(func(conn SignalingConn, req SignalingRequest) {
+		if wss.tenants == nil || wss.egress.Authorize(req.Room, req.ClientID, req.EgressToken) {
+			next.ServeSignaling(conn, req)
+			return
+		}
+
+		tenant, joinErr := wss.tenants.VerifyJoinToken(req.Token, req.Room, time.Now())
+		if joinErr != nil {
+			wss.rejectJoin(conn, NewClientWithID(conn, req.ClientID), req.Room, joinErr)
+			return
+		}
+
+		req.Room = tenant.Room(req.Room)
+		next.ServeSignaling(conn, req)
+	})
+}
+
+// checkTenantLimits returns an error when a new client in room would exceed
+// the limits of the tenant of room on this instance. It must be called with
+// connectionsMu held.
+func (wss *WSS) checkTenantLimits(room string) *JoinError {
+	tenant, ok := wss.tenants.RoomTenant(room)
+	if !ok || (tenant.MaxRooms <= 0 && tenant.MaxParticipants <= 0) {
+		return nil
+	}
+
+	rooms := map[string]struct{}{}
+	participants := 0
+	for name := range wss.connections {
+		if !tenant.Owns(name) {
+			continue
+		}
+		if count := wss.visibleConnections(name); count > 0 {
+			rooms[mainRoom(name)] = struct{}{}
+			participants += count
+		}
+	}
+
+	if _, ok := rooms[mainRoom(room)]; !ok && tenant.MaxRooms > 0 && len(rooms) >= tenant.MaxRooms {
+		return ErrTenantRoomLimit
+	}
+	if tenant.MaxParticipants > 0 && participants >= tenant.MaxParticipants {
+		return ErrTenantFull
+	}
+	return nil
+}
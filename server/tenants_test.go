@@ -0,0 +1,194 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+var testTenants = []server.TenantConfig{{
+	Name:            "acme",
+	APIKey:          "acme-key",
+	JoinSecret:      "acme-secret",
+	MaxRooms:        1,
+	MaxParticipants: 2,
+	MaxBitrate:      500000,
+}, {
+	Name:       "globex",
+	APIKey:     "globex-key",
+	JoinSecret: "globex-secret",
+}}
+
+func setupTenantServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	tenants, err := server.NewTenants(testTenants)
+	require.NoError(t, err)
+
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	admin := server.AdminConfig{Token: adminToken}
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, admin, server.InactivityConfig{}, server.ClientConfig{}, rooms, newMockTracksManager())
+	mux.WSS.SetTenants(tenants)
+	return httptest.NewServer(mux)
+}
+
+func mustSignJoinToken(t *testing.T, secret string, claims server.JoinTokenClaims) string {
+	t.Helper()
+	token, err := server.SignJoinToken(secret, claims)
+	require.NoError(t, err)
+	return token
+}
+
+func dialTenant(t *testing.T, ctx context.Context, s *httptest.Server, room string, clientID string, token string) (*websocket.Conn, server.Message) {
+	t.Helper()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + room + "/" + clientID + "?token=" + url.QueryEscape(token)
+	ws := mustDialWS(t, ctx, wsURL)
+	message, _ := readJoinResult(t, ctx, ws)
+	return ws, message
+}
+
+func TestNewTenants(t *testing.T) {
+	tenants, err := server.NewTenants(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, tenants)
+
+	_, err = server.NewTenants([]server.TenantConfig{{Name: "a:b", JoinSecret: "secret"}})
+	assert.True(t, errors.Is(err, server.ErrInvalidTenant))
+
+	_, err = server.NewTenants([]server.TenantConfig{{Name: "acme"}})
+	assert.True(t, errors.Is(err, server.ErrInvalidTenant))
+
+	_, err = server.NewTenants([]server.TenantConfig{
+		{Name: "acme", JoinSecret: "a"},
+		{Name: "acme", JoinSecret: "b"},
+	})
+	assert.True(t, errors.Is(err, server.ErrInvalidTenant))
+}
+
+func TestTenants_VerifyJoinToken(t *testing.T) {
+	tenants, err := server.NewTenants(testTenants)
+	require.NoError(t, err)
+	now := time.Unix(1000, 0)
+
+	token := mustSignJoinToken(t, "acme-secret", server.JoinTokenClaims{Tenant: "acme", ExpiresAt: 1001})
+	tenant, joinErr := tenants.VerifyJoinToken(token, "standup", now)
+	require.Nil(t, joinErr)
+	assert.Equal(t, "acme", tenant.Name)
+	assert.Equal(t, "acme:standup", tenant.Room("standup"))
+
+	_, joinErr = tenants.VerifyJoinToken(token, "standup", now.Add(time.Second))
+	assert.Equal(t, server.ErrInvalidToken, joinErr, "expired")
+
+	token = mustSignJoinToken(t, "globex-secret", server.JoinTokenClaims{Tenant: "acme"})
+	_, joinErr = tenants.VerifyJoinToken(token, "standup", now)
+	assert.Equal(t, server.ErrInvalidToken, joinErr, "signed by another tenant")
+
+	token = mustSignJoinToken(t, "acme-secret", server.JoinTokenClaims{Tenant: "acme", Room: "standup"})
+	_, joinErr = tenants.VerifyJoinToken(token, "standup/group-1", now)
+	assert.Nil(t, joinErr, "breakout room of the room of the token")
+	_, joinErr = tenants.VerifyJoinToken(token, "retro", now)
+	assert.Equal(t, server.ErrInvalidToken, joinErr, "other room")
+
+	_, joinErr = tenants.VerifyJoinToken("invalid", "standup", now)
+	assert.Equal(t, server.ErrInvalidToken, joinErr)
+}
+
+func TestTenants_join(t *testing.T) {
+	s := setupTenantServer(t)
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	acme := mustSignJoinToken(t, "acme-secret", server.JoinTokenClaims{Tenant: "acme"})
+	globex := mustSignJoinToken(t, "globex-secret", server.JoinTokenClaims{Tenant: "globex"})
+
+	ws, message := dialTenant(t, ctx, s, "standup", "a", "")
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, server.MessageTypeJoinError, message.Type)
+	assert.Equal(t, "invalidToken", message.Payload.(map[string]interface{})["code"])
+
+	ws, message = dialTenant(t, ctx, s, "standup", "a", acme)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, server.MessageTypeJoinAck, message.Type)
+	assert.Equal(t, "acme:standup", message.Room)
+
+	// the same room name of another tenant is a different room
+	ws, message = dialTenant(t, ctx, s, "standup", "b", globex)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, server.MessageTypeJoinAck, message.Type)
+	assert.Equal(t, "globex:standup", message.Room)
+
+	ws, message = dialTenant(t, ctx, s, "retro", "c", acme)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, server.MessageTypeJoinError, message.Type)
+	assert.Equal(t, "tenantRoomLimit", message.Payload.(map[string]interface{})["code"])
+
+	ws, message = dialTenant(t, ctx, s, "standup", "d", acme)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, server.MessageTypeJoinAck, message.Type)
+
+	ws, message = dialTenant(t, ctx, s, "standup", "e", acme)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	assert.Equal(t, server.MessageTypeJoinError, message.Type)
+	assert.Equal(t, "tenantFull", message.Payload.(map[string]interface{})["code"])
+}
+
+func TestTenants_admin(t *testing.T) {
+	s := setupTenantServer(t)
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	acme := mustSignJoinToken(t, "acme-secret", server.JoinTokenClaims{Tenant: "acme"})
+	globex := mustSignJoinToken(t, "globex-secret", server.JoinTokenClaims{Tenant: "globex"})
+
+	ws, message := dialTenant(t, ctx, s, "standup", "a", acme)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	require.Equal(t, server.MessageTypeJoinAck, message.Type)
+	ws, message = dialTenant(t, ctx, s, "standup", "b", globex)
+	defer ws.Close(websocket.StatusNormalClosure, "")
+	require.Equal(t, server.MessageTypeJoinAck, message.Type)
+
+	url := s.URL + "/api/admin/rooms/standup"
+	statusCode, result := adminRequest(t, "DELETE", url+"?dryRun=true", "acme-key")
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, "acme:standup", result.Room)
+	assert.Equal(t, []string{"a"}, result.ClientIDs)
+
+	// clients of other tenants cannot be managed
+	statusCode, _ = adminRequest(t, "DELETE", url+"/clients/b?dryRun=true", "acme-key")
+	assert.Equal(t, http.StatusNotFound, statusCode)
+	statusCode, _ = adminRequest(t, "DELETE", url+"/clients/a?dryRun=true", "acme-key")
+	assert.Equal(t, http.StatusOK, statusCode)
+
+	statusCode, _ = adminRequest(t, "GET", s.URL+"/api/admin/captures/test", "acme-key")
+	assert.Equal(t, http.StatusForbidden, statusCode)
+
+	// the admin token has access to all rooms
+	statusCode, result = adminRequest(t, "DELETE", s.URL+"/api/admin/rooms/globex:standup?dryRun=true", adminToken)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, []string{"b"}, result.ClientIDs)
+
+	req, err := http.NewRequest("GET", s.URL+"/api/rooms/", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer globex-key")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	var rooms []server.RoomInfo
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&rooms))
+	require.Len(t, rooms, 1)
+	assert.Equal(t, "globex:standup", rooms[0].Room)
+}
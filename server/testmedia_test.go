@@ -0,0 +1,179 @@
+package server_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+	"github.com/pion/webrtc/v2/pkg/media/ivfreader"
+	"github.com/stretchr/testify/require"
+)
+
+// The media in testdata was encoded with libvpx and libopus, so that tests
+// can send streams which real decoders accept. video.ivf is a second of
+// 64x48 VP8 video at 30 frames per second with keyframes at the first and
+// the 16th frame, and audio.ogg is a second of a 440 Hz tone in 20 ms Opus
+// packets.
+const (
+	testVideoFile = "testdata/video.ivf"
+	testAudioFile = "testdata/audio.ogg"
+)
+
+// testMTU is the maximum payload size of generated RTP packets.
+const testMTU = 1200
+
+type testSample struct {
+	data     []byte
+	duration time.Duration
+}
+
+// testMedia contains the frames of a media file from testdata.
+type testMedia struct {
+	codec   *webrtc.RTPCodec
+	samples []testSample
+}
+
+func readTestVideo(t testing.TB) *testMedia {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(testVideoFile)
+	require.NoError(t, err)
+
+	reader, header, err := ivfreader.NewWith(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, "VP80", header.FourCC)
+
+	duration := time.Duration(header.TimebaseNumerator) * time.Second / time.Duration(header.TimebaseDenominator)
+
+	m := &testMedia{
+		codec: webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000),
+	}
+	for {
+		frame, _, err := reader.ParseNextFrame()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		m.samples = append(m.samples, testSample{data: frame, duration: duration})
+	}
+
+	return m
+}
+
+func readTestAudio(t testing.TB) *testMedia {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(testAudioFile)
+	require.NoError(t, err)
+
+	reader, err := server.NewOggOpusReader(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	m := &testMedia{
+		codec: webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000),
+	}
+	for {
+		packet, err := reader.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		duration := time.Duration(server.OpusPacketSamples(packet)) * time.Second / 48000
+		m.samples = append(m.samples, testSample{data: packet, duration: duration})
+	}
+
+	return m
+}
+
+// newTrack creates a track with the codec of m.
+func (m *testMedia) newTrack(t testing.TB, ssrc uint32, id string, label string) *webrtc.Track {
+	t.Helper()
+
+	track, err := webrtc.NewTrack(m.codec.PayloadType, ssrc, id, label, m.codec)
+	require.NoError(t, err)
+	return track
+}
+
+// testRTPStream packetizes the samples of a testMedia like a track does,
+// starting over at the end, with continuous sequence numbers and timestamps.
+type testRTPStream struct {
+	media      *testMedia
+	packetizer rtp.Packetizer
+	index      int
+}
+
+func newTestRTPStream(media *testMedia, ssrc uint32) *testRTPStream {
+	return &testRTPStream{
+		media: media,
+		packetizer: rtp.NewPacketizer(
+			testMTU,
+			media.codec.PayloadType,
+			ssrc,
+			media.codec.Payloader,
+			rtp.NewRandomSequencer(),
+			media.codec.ClockRate,
+		),
+	}
+}
+
+// next returns the packets of the next sample and the time until the
+// sample after it is due.
+func (s *testRTPStream) next() ([]*rtp.Packet, time.Duration) {
+	sample := s.media.samples[s.index]
+	s.index = (s.index + 1) % len(s.media.samples)
+
+	samples := uint32(sample.duration * time.Duration(s.media.codec.ClockRate) / time.Second)
+	return s.packetizer.Packetize(sample.data, samples), sample.duration
+}
+
+// publishTestMedia writes the media to track in real time until the
+// returned function is called.
+func publishTestMedia(media *testMedia, track *webrtc.Track) (stop func()) {
+	stream := newTestRTPStream(media, track.SSRC())
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-timer.C:
+			case <-done:
+				return
+			}
+
+			packets, duration := stream.next()
+			for _, packet := range packets {
+				// io.ErrClosedPipe until the track has been negotiated
+				_ = track.WriteRTP(packet)
+			}
+			timer.Reset(duration)
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// isTestSample returns true when data is one of the samples of m.
+func (m *testMedia) isTestSample(data []byte) bool {
+	for _, sample := range m.samples {
+		if bytes.Equal(sample.data, data) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket limits the rate of events. It allows burst events at once and
+// refills one token per interval.
+type tokenBucket struct {
+	interval time.Duration
+	burst    int
+	tokens   int
+	last     time.Time
+}
+
+func newTokenBucket(interval time.Duration, burst int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		interval: interval,
+		burst:    burst,
+		tokens:   burst,
+		last:     now,
+	}
+}
+
+// allow returns true when an event can happen at now, and consumes a token.
+func (l *tokenBucket) allow(now time.Time) bool {
+	l.refill(now)
+	if l.tokens == 0 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+func (l *tokenBucket) refill(now time.Time) {
+	if refill := int(now.Sub(l.last) / l.interval); refill > 0 {
+		l.tokens += refill
+		l.last = l.last.Add(time.Duration(refill) * l.interval)
+		if l.tokens >= l.burst {
+			l.tokens = l.burst
+			l.last = now
+		}
+	}
+}
+
+// full returns true when no tokens have been consumed since they were last
+// refilled, so the bucket can be discarded.
+func (l *tokenBucket) full(now time.Time) bool {
+	l.refill(now)
+	return l.tokens == l.burst
+}
+
+// keyedTokenBuckets keeps a token bucket per key, for example per IP
+// address. Full buckets are discarded periodically.
+type keyedTokenBuckets struct {
+	interval time.Duration
+	burst    int
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+func newKeyedTokenBuckets(interval time.Duration, burst int) *keyedTokenBuckets {
+	return &keyedTokenBuckets{
+		interval: interval,
+		burst:    burst,
+		buckets:  map[string]*tokenBucket{},
+	}
+}
+
+// allow returns true when an event for key can happen at now. It always
+// returns true when b is nil.
+func (b *keyedTokenBuckets) allow(key string, now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.Sub(b.lastPrune) >= time.Duration(b.burst)*b.interval {
+		b.prune(now)
+	}
+
+	bucket, ok := b.buckets[key]
+	if !ok {
+		bucket = newTokenBucket(b.interval, b.burst, now)
+		b.buckets[key] = bucket
+	}
+	return bucket.allow(now)
+}
+
+func (b *keyedTokenBuckets) prune(now time.Time) {
+	for key, bucket := range b.buckets {
+		if bucket.full(now) {
+			delete(b.buckets, key)
+		}
+	}
+	b.lastPrune = now
+}
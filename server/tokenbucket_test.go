@@ -0,0 +1,46 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newTokenBucket(time.Second, 2, now)
+
+	assert.True(t, l.allow(now))
+	assert.True(t, l.allow(now))
+	assert.False(t, l.allow(now))
+
+	// tokens are refilled one interval at a time
+	assert.False(t, l.allow(now.Add(900*time.Millisecond)))
+	assert.True(t, l.allow(now.Add(1500*time.Millisecond)))
+	assert.False(t, l.allow(now.Add(1500*time.Millisecond)))
+	assert.True(t, l.allow(now.Add(2*time.Second)))
+
+	// no more than burst tokens are accumulated
+	now = now.Add(time.Minute)
+	assert.True(t, l.allow(now))
+	assert.True(t, l.allow(now))
+	assert.False(t, l.allow(now))
+}
+
+func TestKeyedTokenBuckets(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newKeyedTokenBuckets(time.Second, 1)
+
+	assert.True(t, b.allow("a", now))
+	assert.False(t, b.allow("a", now))
+	assert.True(t, b.allow("b", now))
+
+	// full buckets are discarded
+	now = now.Add(time.Minute)
+	assert.True(t, b.allow("a", now))
+	assert.Len(t, b.buckets, 1)
+
+	var disabled *keyedTokenBuckets
+	assert.True(t, disabled.allow("a", now))
+}
@@ -0,0 +1,420 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	tracingQueueSize     = 1024
+	tracingBatchSize     = 128
+	tracingFlushInterval = time.Second
+	defaultServiceName   = "peer-calls"
+)
+
+type traceID [16]byte
+type spanID [8]byte
+
+// SpanData is a finished span passed to a SpanExporter. IDs are hex encoded
+// and ParentSpanID is empty for root spans.
+type SpanData struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]string
+	// Error is set when the span failed
+	Error string
+}
+
+type SpanExporter interface {
+	ExportSpans(spans []SpanData) error
+}
+
+type SpanExporterFunc func(spans []SpanData) error
+
+func (f SpanExporterFunc) ExportSpans(spans []SpanData) error {
+	return f(spans)
+}
+
+// Span measures a single step of the join flow. All methods of a nil Span
+// are no-ops, so spans can be created regardless of whether tracing is
+// enabled.
+type Span struct {
+	tracer   *Tracer
+	traceID  traceID
+	spanID   spanID
+	parentID spanID
+	name     string
+	start    time.Time
+
+	mu    sync.Mutex
+	attrs map[string]string
+	err   string
+	ended bool
+}
+
+// Child starts a span of the same trace.
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.newSpan(name, s.traceID, s.spanID)
+}
+
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err.Error()
+	s.mu.Unlock()
+}
+
+// TraceParent returns the W3C traceparent of the span, which is sent to
+// clients in signaling messages.
+func (s *Span) TraceParent() string {
+	if s == nil {
+		return ""
+	}
+	return "00-" + hex.EncodeToString(s.traceID[:]) + "-" + hex.EncodeToString(s.spanID[:]) + "-01"
+}
+
+// End finishes the span and queues it for export. Only the first call has
+// an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := SpanData{
+		TraceID:    hex.EncodeToString(s.traceID[:]),
+		SpanID:     hex.EncodeToString(s.spanID[:]),
+		Name:       s.name,
+		Start:      s.start,
+		End:        time.Now(),
+		Attributes: make(map[string]string, len(s.attrs)),
+		Error:      s.err,
+	}
+	for key, value := range s.attrs {
+		data.Attributes[key] = value
+	}
+	if s.parentID != (spanID{}) {
+		data.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	s.mu.Unlock()
+
+	s.tracer.export(data)
+}
+
+// Tracer creates spans for the join flow of clients, from the websocket
+// upgrade through SDP negotiation to forwarding of published tracks to
+// other peers, and exports them in batches. Each client has a join span for
+// as long as it is connected, which later spans of the client are children
+// of. A nil Tracer creates no spans.
+type Tracer struct {
+	log      Logger
+	exporter SpanExporter
+
+	mu sync.Mutex
+	// key is clientID
+	joins map[string]*Span
+
+	spans        chan SpanData
+	closeChannel chan struct{}
+	closeOnce    sync.Once
+	done         chan struct{}
+}
+
+func NewTracer(loggerFactory LoggerFactory, exporter SpanExporter) *Tracer {
+	t := &Tracer{
+		log:          loggerFactory.GetLogger("tracing"),
+		exporter:     exporter,
+		joins:        map[string]*Span{},
+		spans:        make(chan SpanData, tracingQueueSize),
+		closeChannel: make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// StartJoin starts the join span of clientID. When traceParent is a valid
+// W3C traceparent, for example passed by the client in the websocket URL,
+// the span continues the client's trace.
+func (t *Tracer) StartJoin(room string, clientID string, traceParent string) *Span {
+	if t == nil {
+		return nil
+	}
+
+	var span *Span
+	if trace, parent, ok := parseTraceParent(traceParent); ok {
+		span = t.newSpan("ws.join", trace, parent)
+	} else {
+		var trace traceID
+		rand.Read(trace[:])
+		span = t.newSpan("ws.join", trace, spanID{})
+	}
+	span.SetAttribute("room", room)
+	span.SetAttribute("clientID", clientID)
+
+	t.mu.Lock()
+	t.joins[clientID] = span
+	t.mu.Unlock()
+
+	return span
+}
+
+// Join returns the join span of clientID, or nil when clientID is not
+// connected.
+func (t *Tracer) Join(clientID string) *Span {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.joins[clientID]
+}
+
+// Leave forgets the join span of clientID, unless the client has
+// reconnected with a new span in the meantime.
+func (t *Tracer) Leave(clientID string, span *Span) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	if t.joins[clientID] == span {
+		delete(t.joins, clientID)
+	}
+	t.mu.Unlock()
+}
+
+// Close exports queued spans and stops the exporter.
+func (t *Tracer) Close() {
+	if t == nil {
+		return
+	}
+	t.closeOnce.Do(func() {
+		close(t.closeChannel)
+	})
+	<-t.done
+}
+
+func (t *Tracer) newSpan(name string, trace traceID, parent spanID) *Span {
+	span := &Span{
+		tracer:   t,
+		traceID:  trace,
+		parentID: parent,
+		name:     name,
+		start:    time.Now(),
+		attrs:    map[string]string{},
+	}
+	rand.Read(span.spanID[:])
+	return span
+}
+
+func (t *Tracer) export(span SpanData) {
+	select {
+	case t.spans <- span:
+	default:
+		t.log.Printf("Dropping span: %s, queue is full", span.Name)
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(tracingFlushInterval)
+	defer ticker.Stop()
+
+	var batch []SpanData
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.ExportSpans(batch); err != nil {
+			t.log.Printf("Error exporting %d spans: %s", len(batch), err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span)
+			if len(batch) >= tracingBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.closeChannel:
+			for {
+				select {
+				case span := <-t.spans:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// parseTraceParent parses a W3C traceparent header value.
+func parseTraceParent(value string) (trace traceID, parent spanID, ok bool) {
+	// version-traceid-parentid-flags
+	if len(value) != 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return
+	}
+	if value[:2] == "ff" {
+		return
+	}
+	if _, err := hex.Decode(trace[:], []byte(value[3:35])); err != nil {
+		return
+	}
+	if _, err := hex.Decode(parent[:], []byte(value[36:52])); err != nil {
+		return
+	}
+	ok = trace != traceID{} && parent != spanID{}
+	return
+}
+
+// NewOTLPExporter creates an exporter which posts spans to an OpenTelemetry
+// collector using OTLP over HTTP with JSON encoding. url is the full URL of
+// the traces endpoint, for example http://collector:4318/v1/traces.
+func NewOTLPExporter(url string, serviceName string, client *http.Client) SpanExporter {
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+
+	return SpanExporterFunc(func(spans []SpanData) error {
+		body, err := json.Marshal(newOTLPRequest(serviceName, spans))
+		if err != nil {
+			return fmt.Errorf("Error encoding spans: %w", err)
+		}
+
+		res, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("Error posting spans: %w", err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			return fmt.Errorf("Unexpected status code: %d", res.StatusCode)
+		}
+		return nil
+	})
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string          `json:"key"`
+	Value otlpStringValue `json:"value"`
+}
+
+type otlpStringValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Message string `json:"message,omitempty"`
+	// Code is 0 for unset and 2 for error
+	Code int `json:"code,omitempty"`
+}
+
+const otlpSpanKindServer = 2
+
+func newOTLPRequest(serviceName string, spans []SpanData) otlpRequest {
+	otlpSpans := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentSpanID,
+			Name:              span.Name,
+			Kind:              otlpSpanKindServer,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		}
+		for key, value := range span.Attributes {
+			s.Attributes = append(s.Attributes, otlpAttribute{key, otlpStringValue{value}})
+		}
+		if span.Error != "" {
+			s.Status = otlpStatus{Message: span.Error, Code: 2}
+		}
+		otlpSpans = append(otlpSpans, s)
+	}
+
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{{"service.name", otlpStringValue{serviceName}}},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: defaultServiceName},
+				Spans: otlpSpans,
+			}},
+		}},
+	}
+}
@@ -0,0 +1,153 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+const (
+	testTraceID    = "0af7651916cd43dd8448eb211c80319c"
+	testParentSpan = "b7ad6b7169203331"
+)
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []server.SpanData
+}
+
+func (r *spanRecorder) ExportSpans(spans []server.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *spanRecorder) Spans() []server.SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.spans
+}
+
+func TestTracer_join(t *testing.T) {
+	recorder := &spanRecorder{}
+	tracer := server.NewTracer(loggerFactory, recorder)
+	defer tracer.Close()
+
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, server.AdminConfig{}, server.InactivityConfig{}, server.ClientConfig{}, rooms, newMockTracksManager())
+	mux.WSS.SetTracer(tracer)
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + roomName + "/" + clientID
+
+	ws := mustDialWS(t, ctx, wsURL+"?traceparent=00-"+testTraceID+"-"+testParentSpan+"-01")
+	message, _ := readJoinResult(t, ctx, ws)
+	assert.Equal(t, server.MessageTypeJoinAck, message.Type)
+	assert.Regexp(t, "^00-"+testTraceID+"-[0-9a-f]{16}-01$", message.TraceParent)
+	ws.Close(websocket.StatusNormalClosure, "")
+
+	// an invalid traceparent starts a new trace
+	ws = mustDialWS(t, ctx, wsURL+"?traceparent=invalid")
+	message, _ = readJoinResult(t, ctx, ws)
+	assert.Equal(t, server.MessageTypeJoinAck, message.Type)
+	assert.NotContains(t, message.TraceParent, testTraceID)
+	ws.Close(websocket.StatusNormalClosure, "")
+
+	tracer.Close()
+
+	spans := recorder.Spans()
+	require.Len(t, spans, 2)
+	if spans[0].TraceID != testTraceID {
+		spans[0], spans[1] = spans[1], spans[0]
+	}
+	assert.Equal(t, "ws.join", spans[0].Name)
+	assert.Equal(t, testTraceID, spans[0].TraceID)
+	assert.Equal(t, testParentSpan, spans[0].ParentSpanID)
+	assert.Equal(t, map[string]string{"room": roomName, "clientID": clientID}, spans[0].Attributes)
+	assert.Equal(t, "", spans[0].Error)
+	assert.Equal(t, "", spans[1].ParentSpanID)
+	assert.NotEqual(t, testTraceID, spans[1].TraceID)
+}
+
+func TestTracer_nil(t *testing.T) {
+	var tracer *server.Tracer
+	span := tracer.StartJoin(roomName, clientID, "")
+	child := span.Child("child")
+	child.SetAttribute("key", "value")
+	child.SetError(errors.New("test"))
+	child.End()
+	assert.Equal(t, "", span.TraceParent())
+	assert.Nil(t, tracer.Join(clientID))
+	tracer.Leave(clientID, span)
+	tracer.Close()
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]interface{}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+	}))
+	defer s.Close()
+
+	exporter := server.NewOTLPExporter(s.URL, "", s.Client())
+	err := exporter.ExportSpans([]server.SpanData{{
+		TraceID:      testTraceID,
+		SpanID:       "00f067aa0ba902b7",
+		ParentSpanID: testParentSpan,
+		Name:         "sfu.negotiate",
+		Start:        time.Unix(1, 0),
+		End:          time.Unix(2, 0),
+		Attributes:   map[string]string{"initiator": clientID},
+		Error:        "Peer connection failed",
+	}})
+	require.NoError(t, err)
+
+	resourceSpans := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"attributes": []interface{}{map[string]interface{}{
+			"key":   "service.name",
+			"value": map[string]interface{}{"stringValue": "peer-calls"},
+		}},
+	}, resourceSpans["resource"])
+	spans := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"]
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"traceId":           testTraceID,
+		"spanId":            "00f067aa0ba902b7",
+		"parentSpanId":      testParentSpan,
+		"name":              "sfu.negotiate",
+		"kind":              float64(2),
+		"startTimeUnixNano": "1000000000",
+		"endTimeUnixNano":   "2000000000",
+		"attributes": []interface{}{map[string]interface{}{
+			"key":   "initiator",
+			"value": map[string]interface{}{"stringValue": clientID},
+		}},
+		"status": map[string]interface{}{
+			"message": "Peer connection failed",
+			"code":    float64(2),
+		},
+	}}, spans)
+
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	exporter = server.NewOTLPExporter(notFound.URL, "", notFound.Client())
+	assert.Error(t, exporter.ExportSpans(nil))
+}
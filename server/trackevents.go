@@ -0,0 +1,183 @@
+package server
+
+import (
+	"sync"
+)
+
+// trackEventQueueSize is the number of track events of a single peer which
+// may wait for the consumer of TracksChannel.
+const trackEventQueueSize = 64
+
+// TrackEventStats contains counters of the track events of a peer.
+type TrackEventStats struct {
+	// Queued is the number of events waiting to be delivered.
+	Queued        int `json:"queued"`
+	MaxQueueDepth int `json:"maxQueueDepth"`
+	Delivered     int `json:"delivered"`
+	// Coalesced is the number of add events which were removed from the
+	// queue, together with the remove event of the same track, before they
+	// were delivered.
+	Coalesced int `json:"coalesced"`
+	// Dropped is the number of add events which were dropped because the
+	// queue was full.
+	Dropped int `json:"dropped"`
+}
+
+// Add returns the sum of s and other. The maximum queue depth is the larger
+// of both.
+func (s TrackEventStats) Add(other TrackEventStats) TrackEventStats {
+	s.Queued += other.Queued
+	if other.MaxQueueDepth > s.MaxQueueDepth {
+		s.MaxQueueDepth = other.MaxQueueDepth
+	}
+	s.Delivered += other.Delivered
+	s.Coalesced += other.Coalesced
+	s.Dropped += other.Dropped
+	return s
+}
+
+// trackEventQueue delivers track events to a channel without blocking the
+// sender, which is usually the OnTrack callback of a peer connection. A slow
+// consumer would otherwise stall the peer connection.
+//
+// When the queue is full, add events are dropped: the track is not forwarded,
+// but the rooms stay consistent. Remove events are never dropped, since
+// forwarded tracks could not be removed otherwise, and there are never more
+// of them than tracks which were added.
+type trackEventQueue struct {
+	log  Logger
+	size int
+
+	out          chan TrackEvent
+	notify       chan struct{}
+	closeChannel chan struct{}
+	closeOnce    sync.Once
+
+	mu     sync.Mutex
+	events []TrackEvent
+	stats  TrackEventStats
+}
+
+func newTrackEventQueue(log Logger, size int) *trackEventQueue {
+	q := &trackEventQueue{
+		log:          log,
+		size:         size,
+		out:          make(chan TrackEvent),
+		notify:       make(chan struct{}, 1),
+		closeChannel: make(chan struct{}),
+	}
+
+	go q.run()
+
+	return q
+}
+
+// Channel returns the channel events are delivered to. It is closed when the
+// queue is closed.
+func (q *trackEventQueue) Channel() <-chan TrackEvent {
+	return q.out
+}
+
+// Push queues event for delivery. It never blocks.
+func (q *trackEventQueue) Push(event TrackEvent) {
+	q.mu.Lock()
+
+	select {
+	case <-q.closeChannel:
+		q.mu.Unlock()
+		q.log.Debugf("Track event dropped, queue closed")
+		return
+	default:
+	}
+
+	switch event.Type {
+	case TrackEventTypeRemove:
+		for i, queued := range q.events {
+			if queued.Type == TrackEventTypeAdd && queued.Track == event.Track {
+				q.events = append(q.events[:i:i], q.events[i+1:]...)
+				q.stats.Coalesced++
+				q.mu.Unlock()
+				return
+			}
+		}
+	case TrackEventTypeAdd:
+		if len(q.events) >= q.size {
+			q.stats.Dropped++
+			q.mu.Unlock()
+			q.log.Errorf("Track event queue full, dropped add event of track: %s", event.Track.ID())
+			return
+		}
+	}
+
+	q.events = append(q.events, event)
+	if len(q.events) > q.stats.MaxQueueDepth {
+		q.stats.MaxQueueDepth = len(q.events)
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Stats returns a snapshot of the counters of the queue.
+func (q *trackEventQueue) Stats() TrackEventStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := q.stats
+	stats.Queued = len(q.events)
+	return stats
+}
+
+// Close stops delivering events and closes the channel. Queued events are
+// dropped.
+func (q *trackEventQueue) Close() {
+	q.closeOnce.Do(func() {
+		q.mu.Lock()
+		close(q.closeChannel)
+		q.mu.Unlock()
+	})
+}
+
+func (q *trackEventQueue) run() {
+	defer close(q.out)
+
+	for {
+		event, ok := q.next()
+		if !ok {
+			select {
+			case <-q.notify:
+				continue
+			case <-q.closeChannel:
+				return
+			}
+		}
+
+		select {
+		case q.out <- event:
+			q.mu.Lock()
+			q.stats.Delivered++
+			q.mu.Unlock()
+		case <-q.closeChannel:
+			return
+		}
+	}
+}
+
+// next removes the first event from the queue. Returns false when the queue
+// is empty.
+func (q *trackEventQueue) next() (TrackEvent, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.events) == 0 {
+		return TrackEvent{}, false
+	}
+
+	event := q.events[0]
+	q.events[0] = TrackEvent{}
+	q.events = q.events[1:]
+	return event, true
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server/logger"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTrackEventQueue(t *testing.T, size int) *trackEventQueue {
+	t.Helper()
+	log := logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout).GetLogger("test")
+	return newTrackEventQueue(log, size)
+}
+
+func newTestEventTrack(t *testing.T, id string) *webrtc.Track {
+	t.Helper()
+	track, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeOpus, 1, id, id, webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000))
+	require.NoError(t, err)
+	return track
+}
+
+func readTestTrackEvent(t *testing.T, q *trackEventQueue) TrackEvent {
+	t.Helper()
+	select {
+	case event := <-q.Channel():
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for track event")
+		return TrackEvent{}
+	}
+}
+
+func TestTrackEventQueue_nonBlocking(t *testing.T) {
+	q := newTestTrackEventQueue(t, 2)
+	defer q.Close()
+
+	a := newTestEventTrack(t, "a")
+	b := newTestEventTrack(t, "b")
+	c := newTestEventTrack(t, "c")
+
+	// nothing reads the channel yet. The first event is taken off the queue
+	// by the delivery goroutine, which waits for the consumer.
+	q.Push(TrackEvent{Track: a, Type: TrackEventTypeAdd})
+	assert.Eventually(t, func() bool {
+		return q.Stats().Queued == 0
+	}, time.Second, time.Millisecond)
+
+	q.Push(TrackEvent{Track: b, Type: TrackEventTypeAdd})
+	q.Push(TrackEvent{Track: c, Type: TrackEventTypeAdd})
+	q.Push(TrackEvent{Track: newTestEventTrack(t, "d"), Type: TrackEventTypeAdd})
+	q.Push(TrackEvent{Track: a, Type: TrackEventTypeRemove})
+
+	assert.Equal(t, TrackEventStats{Queued: 3, MaxQueueDepth: 3, Dropped: 1}, q.Stats(),
+		"add events are dropped when the queue is full, remove events are not")
+
+	assert.Equal(t, a, readTestTrackEvent(t, q).Track)
+	assert.Equal(t, b, readTestTrackEvent(t, q).Track)
+	assert.Equal(t, c, readTestTrackEvent(t, q).Track)
+	event := readTestTrackEvent(t, q)
+	assert.Equal(t, a, event.Track)
+	assert.Equal(t, TrackEventType(TrackEventTypeRemove), event.Type)
+
+	assert.Eventually(t, func() bool {
+		return q.Stats().Delivered == 4
+	}, time.Second, time.Millisecond)
+}
+
+func TestTrackEventQueue_coalesce(t *testing.T) {
+	q := newTestTrackEventQueue(t, 10)
+	defer q.Close()
+
+	a := newTestEventTrack(t, "a")
+	b := newTestEventTrack(t, "b")
+	c := newTestEventTrack(t, "c")
+
+	q.Push(TrackEvent{Track: a, Type: TrackEventTypeAdd})
+	assert.Eventually(t, func() bool {
+		return q.Stats().Queued == 0
+	}, time.Second, time.Millisecond)
+
+	q.Push(TrackEvent{Track: b, Type: TrackEventTypeAdd})
+	q.Push(TrackEvent{Track: c, Type: TrackEventTypeAdd})
+	q.Push(TrackEvent{Track: b, Type: TrackEventTypeRemove})
+
+	assert.Equal(t, 1, q.Stats().Coalesced, "tracks removed before their add event was delivered are never delivered")
+	assert.Equal(t, a, readTestTrackEvent(t, q).Track)
+	assert.Equal(t, c, readTestTrackEvent(t, q).Track)
+}
+
+func TestTrackEventQueue_Close(t *testing.T) {
+	q := newTestTrackEventQueue(t, 10)
+
+	q.Push(TrackEvent{Track: newTestEventTrack(t, "a"), Type: TrackEventTypeAdd})
+	q.Close()
+	q.Push(TrackEvent{Track: newTestEventTrack(t, "b"), Type: TrackEventTypeAdd})
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-q.Channel():
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for channel to be closed")
+		}
+	}
+}
@@ -4,16 +4,12 @@ import (
 	"fmt"
 	"io"
 	"sync"
-	"time"
 
 	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v2"
 )
 
-const (
-	rtcpPLIInterval = time.Second * 3
-)
-
 type TrackEventType uint32
 
 const (
@@ -21,76 +17,190 @@ const (
 	TrackEventTypeRemove
 )
 
+// TrackSource is the media source of a track, signaled by the publisher.
+type TrackSource string
+
+const (
+	TrackSourceUnknown TrackSource = ""
+	TrackSourceCamera  TrackSource = "camera"
+	TrackSourceScreen  TrackSource = "screen"
+)
+
+func (s TrackSource) Valid() bool {
+	switch s {
+	case TrackSourceUnknown, TrackSourceCamera, TrackSourceScreen:
+		return true
+	}
+	return false
+}
+
 type TrackEvent struct {
 	ClientID string
 	Track    *webrtc.Track
 	Type     TrackEventType
+	Source   TrackSource
 }
 
+// trackListener copies the tracks published by a single peer to local
+// tracks, and adds tracks of other peers to the peer's connection.
+//
+// Locking:
+//
+//   - Tracks are added to and removed from the peer connection with
+//     peerConnectionMu held, the lock of its Signaller, so that they are
+//     never changed while a description is set.
+//   - mu guards the track state: localTracks, rtpSenderByTrack,
+//     writersBySender, interceptorsByTrack, writersByTrack,
+//     remoteSSRCByTrack, trackSources and pausedSenders. It is never held
+//     while calling out of the trackListener, except into the peer
+//     connection, its senders and the trackWriters of other peers.
+//   - Events are queued with sendTrackEvent, which never blocks, so neither
+//     the OnTrack callback nor Close waits for the consumer of TracksChannel.
+//     This allows the consumer to hold its own locks while calling Close.
 type trackListener struct {
-	log              Logger
-	clientID         string
-	peerConnection   *webrtc.PeerConnection
+	log            Logger
+	clientID       string
+	room           string
+	peerConnection *webrtc.PeerConnection
+	// peerConnectionMu is held while tracks are added or removed
+	peerConnectionMu sync.Locker
+
+	interceptorFactories []InterceptorFactory
+	ssrcs                *ssrcRegistry
+	forwarding           *ForwardingPool
+	captures             *PacketCaptures
+	// encrypted is true when the peer encrypts its media end-to-end
+	encrypted bool
+
+	mu               sync.RWMutex
 	localTracks      []*webrtc.Track
-	localTracksMu    sync.RWMutex
 	rtpSenderByTrack map[*webrtc.Track]*webrtc.RTPSender
-
-	tracksChannel       chan TrackEvent
-	tracksChannelClosed bool
-	closeChannel        chan struct{}
-	mu                  sync.RWMutex
-	closeOnce           sync.Once
+	// writers of tracks of other peers which write to the senders
+	writersBySender     map[*webrtc.RTPSender]*trackWriter
+	interceptorsByTrack map[*webrtc.Track]*interceptorChain
+	writersByTrack      map[*webrtc.Track]*trackWriter
+	// SSRCs of the remote tracks local tracks are copied from, when the SSRC
+	// of the local track was remapped to avoid a collision
+	remoteSSRCByTrack map[*webrtc.Track]uint32
+	// key is local track ID
+	trackSources map[string]TrackSource
+	// senders of video tracks of other peers which are not written to while
+	// this peer's video is paused
+	pausedSenders map[*webrtc.RTPSender]struct{}
+
+	events *trackEventQueue
+	// sendQueue writes packets of tracks of other peers to this peer
+	sendQueue *sendQueue
+	// downlink is the quality of tracks of other peers sent to this peer,
+	// as reported by it
+	downlink downlinkQuality
 }
 
 func newTrackListener(
 	loggerFactory LoggerFactory,
 	clientID string,
+	room string,
 	peerConnection *webrtc.PeerConnection,
+	peerConnectionMu sync.Locker,
+	interceptorFactories []InterceptorFactory,
+	ssrcs *ssrcRegistry,
+	forwarding *ForwardingPool,
+	captures *PacketCaptures,
+	encrypted bool,
+	sendQueueSize int,
 ) *trackListener {
 	p := &trackListener{
-		log:              loggerFactory.GetLogger("peer"),
+		log:              loggerFactory.GetLogger("peer").WithCtx(LogCtx{"room": room, "clientID": clientID}),
 		clientID:         clientID,
+		room:             room,
 		peerConnection:   peerConnection,
+		peerConnectionMu: peerConnectionMu,
 		rtpSenderByTrack: map[*webrtc.Track]*webrtc.RTPSender{},
-
-		tracksChannel: make(chan TrackEvent),
-		closeChannel:  make(chan struct{}),
+		writersBySender:  map[*webrtc.RTPSender]*trackWriter{},
+
+		interceptorFactories: interceptorFactories,
+		interceptorsByTrack:  map[*webrtc.Track]*interceptorChain{},
+		writersByTrack:       map[*webrtc.Track]*trackWriter{},
+		remoteSSRCByTrack:    map[*webrtc.Track]uint32{},
+		ssrcs:                ssrcs,
+		forwarding:           forwarding,
+		captures:             captures,
+		encrypted:            encrypted,
+		trackSources:         map[string]TrackSource{},
+		pausedSenders:        map[*webrtc.RTPSender]struct{}{},
 	}
+	p.events = newTrackEventQueue(p.log, trackEventQueueSize)
+	p.sendQueue = newSendQueue(p.log, sendQueueSize, p.writeSendJob)
 
-	p.log.Printf("[%s] Setting PeerConnection.OnTrack listener", clientID)
+	p.log.Debugf("Setting PeerConnection.OnTrack listener")
 	peerConnection.OnTrack(p.handleTrack)
 
 	return p
 }
 
+// writeSendJob writes a packet of another peer to this peer, capturing it
+// when a capture of this peer is in progress.
+func (p *trackListener) writeSendJob(job sendJob) error {
+	if p.captures != nil {
+		p.captures.writeOutbound(p.clientID, &job.header, job.payload)
+	}
+	return writeSendJob(job)
+}
+
 // FIXME add support for data channel messages for sending chat messages, and images/files
 
 func (p *trackListener) Close() {
-	p.closeOnce.Do(func() {
-		close(p.closeChannel)
+	p.events.Close()
+	p.sendQueue.Close()
+}
 
-		p.mu.Lock()
-		defer p.mu.Unlock()
+func (p *trackListener) TracksChannel() <-chan TrackEvent {
+	return p.events.Channel()
+}
 
-		close(p.tracksChannel)
-		p.tracksChannelClosed = true
-	})
+// TrackEventStats returns the counters of track events of the peer.
+func (p *trackListener) TrackEventStats() TrackEventStats {
+	return p.events.Stats()
 }
 
-func (p *trackListener) TracksChannel() <-chan TrackEvent {
-	return p.tracksChannel
+// SendQueueStats returns the counters of packets sent to the peer.
+func (p *trackListener) SendQueueStats() SendQueueStats {
+	return p.sendQueue.Stats()
 }
 
 func (p *trackListener) ClientID() string {
 	return p.clientID
 }
 
-func (p *trackListener) AddTrack(track *webrtc.Track) error {
-	p.localTracksMu.Lock()
-	defer p.localTracksMu.Unlock()
+// AddTrack adds a track of another peer to this peer's connection. RTCP
+// feedback received for the track is written to feedback.
+//
+// Tracks of peers are written by a trackWriter, and this peer is sent a
+// track of its own which only the writer writes to. Tracks of the server are
+// added as they are.
+func (p *trackListener) AddTrack(track *webrtc.Track, feedback RTCPWriter) error {
+	// not under the lock of this peer, the writer is looked up under the
+	// lock of the publisher
+	var writer *trackWriter
+	if senderFeedback, ok := feedback.(*trackFeedback); ok {
+		writer = senderFeedback.writer()
+	}
+
+	sendTrack := track
+	if writer != nil {
+		var err error
+		if sendTrack, err = writer.newSubscriberTrack(); err != nil {
+			return fmt.Errorf("[%s] peer.AddTrack: error creating track: %s: %s", p.clientID, track.ID(), err)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
-	p.log.Printf("[%s] peer.AddTrack: add sendonly transceiver for track: %s", p.clientID, track.ID())
-	rtpSender, err := p.peerConnection.AddTrack(track)
+	p.log.WithCtx(LogCtx{"trackID": track.ID()}).Debugf("peer.AddTrack: add sendonly transceiver")
+	p.peerConnectionMu.Lock()
+	rtpSender, err := p.peerConnection.AddTrack(sendTrack)
+	p.peerConnectionMu.Unlock()
 	// t, err := p.peerConnection.AddTransceiverFromTrack(
 	// 	track,
 	// 	webrtc.RtpTransceiverInit{
@@ -104,56 +214,248 @@ func (p *trackListener) AddTrack(track *webrtc.Track) error {
 
 	// p.rtpSenderByTrack[track] = t.Sender()
 	p.rtpSenderByTrack[track] = rtpSender
+	if writer != nil {
+		p.writersBySender[rtpSender] = writer
+		writer.add(rtpSender, sendTrack, p.sendQueue)
+	}
+
+	go p.readRTCP(rtpSender, track, feedback, writer)
 	return nil
 }
 
+func (p *trackListener) readRTCP(rtpSender *webrtc.RTPSender, track *webrtc.Track, feedback RTCPWriter, writer *trackWriter) {
+	log := p.log.WithCtx(LogCtx{"trackID": track.ID()})
+	started := false
+	for {
+		// blocks until the sender was started by a negotiation
+		packets, err := rtpSender.ReadRTCP()
+		if err != nil {
+			log.Debugf("Stopped reading RTCP: %s", err)
+			if writer != nil {
+				writer.remove(rtpSender)
+			}
+			return
+		}
+		p.downlink.observe(track.Codec().ClockRate, packets)
+		if writer != nil {
+			if !started {
+				started = true
+				writer.started(rtpSender)
+			}
+			packets = writer.translateRTCP(rtpSender, packets)
+		}
+		if err := feedback.WriteRTCP(packets); err != nil {
+			log.Errorf("Error writing RTCP feedback: %s", err)
+		}
+	}
+}
+
+// RTCPWriter returns the writer for RTCP feedback from subscribers of one of
+// the local tracks.
+func (p *trackListener) RTCPWriter(track *webrtc.Track) RTCPWriter {
+	return &trackFeedback{listener: p, track: track}
+}
+
+// trackFeedback writes RTCP feedback from subscribers of a local track to
+// its interceptors.
+type trackFeedback struct {
+	listener *trackListener
+	track    *webrtc.Track
+}
+
+func (f *trackFeedback) WriteRTCP(packets []rtcp.Packet) error {
+	p := f.listener
+	p.mu.RLock()
+	chain, ok := p.interceptorsByTrack[f.track]
+	p.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("[%s] No interceptors for track: %s", p.clientID, f.track.ID())
+	}
+	return chain.WriteRTCP(packets)
+}
+
+func (f *trackFeedback) writer() *trackWriter {
+	f.listener.mu.RLock()
+	defer f.listener.mu.RUnlock()
+	return f.listener.writersByTrack[f.track]
+}
+
 func (p *trackListener) RemoveTrack(track *webrtc.Track) error {
-	p.localTracksMu.Lock()
-	defer p.localTracksMu.Unlock()
-	p.log.Printf("[%s] peer.RemoveTrack: %s", p.clientID, track.ID())
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.log.WithCtx(LogCtx{"trackID": track.ID()}).Debugf("peer.RemoveTrack")
 	rtpSender, ok := p.rtpSenderByTrack[track]
 	if !ok {
 		return fmt.Errorf("[%s] peer.RemoveTrack: cannot find sender for track: %s", p.clientID, track.ID())
 	}
 	delete(p.rtpSenderByTrack, track)
+	delete(p.pausedSenders, rtpSender)
+	if writer, ok := p.writersBySender[rtpSender]; ok {
+		delete(p.writersBySender, rtpSender)
+		writer.remove(rtpSender)
+	}
+
+	p.peerConnectionMu.Lock()
+	defer p.peerConnectionMu.Unlock()
 	return p.peerConnection.RemoveTrack(rtpSender)
 }
 
+// SetVideoPaused stops or resumes writing RTP packets of video tracks of
+// other peers to this peer, without renegotiation. Video tracks of the
+// server are not paused. Returns the tracks which were resumed.
+func (p *trackListener) SetVideoPaused(paused bool) []*webrtc.Track {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !paused {
+		resumed := make([]*webrtc.Track, 0, len(p.pausedSenders))
+		for rtpSender := range p.pausedSenders {
+			writer := p.writersBySender[rtpSender]
+			if writer.setPaused(rtpSender, false) {
+				resumed = append(resumed, writer.track)
+			}
+		}
+		p.pausedSenders = map[*webrtc.RTPSender]struct{}{}
+		return resumed
+	}
+
+	for track, rtpSender := range p.rtpSenderByTrack {
+		if track.Kind() != webrtc.RTPCodecTypeVideo {
+			continue
+		}
+		if _, ok := p.pausedSenders[rtpSender]; ok {
+			continue
+		}
+		writer, ok := p.writersBySender[rtpSender]
+		if ok && writer.setPaused(rtpSender, true) {
+			p.pausedSenders[rtpSender] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// SetTrackSource sets the source of a track with remoteTrackID. When the
+// track has already been received, the publisher replaced the track of its
+// sender: interceptors are notified and a keyframe of the new source is
+// requested, while the track keeps being forwarded.
+func (p *trackListener) SetTrackSource(remoteTrackID string, source TrackSource) {
+	localTrackID := getLocalTrackID(remoteTrackID)
+
+	p.mu.Lock()
+	if source == TrackSourceUnknown {
+		delete(p.trackSources, localTrackID)
+	} else {
+		p.trackSources[localTrackID] = source
+	}
+	var localTrack *webrtc.Track
+	for _, track := range p.localTracks {
+		if track.ID() == localTrackID {
+			localTrack = track
+			break
+		}
+	}
+	chain := p.interceptorsByTrack[localTrack]
+	writer := p.writersByTrack[localTrack]
+	ssrc, ok := p.remoteSSRCByTrack[localTrack]
+	if !ok && localTrack != nil {
+		ssrc = localTrack.SSRC()
+	}
+	p.mu.Unlock()
+
+	if chain == nil {
+		return
+	}
+
+	p.log.WithCtx(LogCtx{"trackID": localTrackID}).Printf("peer.SetTrackSource: source of received track changed to: %s", source)
+	chain.SetSource(source)
+	if writer != nil {
+		writer.setScreen(source == TrackSourceScreen)
+	}
+	if localTrack.Kind() == webrtc.RTPCodecTypeVideo {
+		err := p.peerConnection.WriteRTCP([]rtcp.Packet{
+			&rtcp.PictureLossIndication{MediaSSRC: ssrc},
+		})
+		if err != nil {
+			p.log.Errorf("Error requesting keyframe after source change: %s", err)
+		}
+	}
+}
+
+// TrackSource returns the source of one of the local tracks.
+func (p *trackListener) TrackSource(track *webrtc.Track) TrackSource {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.trackSources[track.ID()]
+}
+
 func (p *trackListener) handleTrack(remoteTrack *webrtc.Track, receiver *webrtc.RTPReceiver) {
-	p.log.Printf("[%s] peer.handleTrack (id: %s, label: %s, type: %s, ssrc: %d)",
-		p.clientID, remoteTrack.ID(), remoteTrack.Label(), remoteTrack.Kind(), remoteTrack.SSRC())
+	p.log.Printf("peer.handleTrack (id: %s, label: %s, type: %s, ssrc: %d)",
+		remoteTrack.ID(), remoteTrack.Label(), remoteTrack.Kind(), remoteTrack.SSRC())
 	localTrack, err := p.startCopyingTrack(remoteTrack)
 	if err != nil {
-		p.log.Printf("Error copying remote track: %s", err)
+		p.log.Errorf("Error copying remote track: %s", err)
 		return
 	}
-	p.localTracksMu.Lock()
+	p.mu.Lock()
 	p.localTracks = append(p.localTracks, localTrack)
-	p.localTracksMu.Unlock()
-
-	p.log.Printf("[%s] peer.handleTrack add track to list of local tracks: %s", p.clientID, localTrack.ID())
-	p.tracksChannel <- TrackEvent{p.clientID, localTrack, TrackEventTypeAdd}
+	p.mu.Unlock()
+
+	p.log.WithCtx(LogCtx{"trackID": localTrack.ID()}).Debugf("peer.handleTrack add track to list of local tracks")
+	p.sendTrackEvent(TrackEvent{
+		ClientID: p.clientID,
+		Track:    localTrack,
+		Type:     TrackEventTypeAdd,
+		Source:   p.TrackSource(localTrack),
+	})
 }
 
+// sendTrackEvent queues an event for the consumer of TracksChannel, or drops
+// it when the trackListener is closed.
 func (p *trackListener) sendTrackEvent(t TrackEvent) {
+	p.events.Push(t)
+}
+
+// Tracks returns a copy of the list of local tracks.
+func (p *trackListener) Tracks() []*webrtc.Track {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	ch := p.tracksChannel
-	if p.tracksChannelClosed {
-		ch = nil
+	tracks := make([]*webrtc.Track, len(p.localTracks))
+	copy(tracks, p.localTracks)
+	return tracks
+}
+
+// Snapshot returns a snapshot of the local tracks. Sources are read under the
+// same lock as the list of tracks, so they always belong to it.
+func (p *trackListener) Snapshot() []trackSnapshot {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	snapshots := make([]trackSnapshot, len(p.localTracks))
+	for i, track := range p.localTracks {
+		snapshots[i] = newTrackSnapshot(p.clientID, track)
+		snapshots[i].Source = p.trackSources[track.ID()]
 	}
+	return snapshots
+}
 
-	select {
-	case ch <- t:
-		p.log.Printf("[%s] sendTrackEvent success", p.clientID)
-	case <-p.closeChannel:
-		p.log.Printf("[%s] sendTrackEvent channel closed", p.clientID)
+func (p *trackListener) removeLocalTrack(track *webrtc.Track) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, localTrack := range p.localTracks {
+		if localTrack == track {
+			p.localTracks = append(p.localTracks[:i:i], p.localTracks[i+1:]...)
+			return
+		}
 	}
 }
 
-func (p *trackListener) Tracks() []*webrtc.Track {
-	return p.localTracks
+// getLocalTrackID returns the ID of the local track created for a track with
+// remoteTrackID published by a peer.
+func getLocalTrackID(remoteTrackID string) string {
+	return "sfu_" + remoteTrackID
 }
 
 func (p *trackListener) startCopyingTrack(remoteTrack *webrtc.Track) (*webrtc.Track, error) {
@@ -170,76 +472,105 @@ func (p *trackListener) startCopyingTrack(remoteTrack *webrtc.Track) (*webrtc.Tr
 	}
 	localTrackLabel := "sfu_" + p.clientID + "_" + remoteTrackLabel
 
-	localTrackID := "sfu_" + remoteTrackID
-	p.log.Printf("[%s] peer.startCopyingTrack: (id: %s, label: %s) to (id: %s, label: %s), ssrc: %d",
-		p.clientID, remoteTrack.ID(), remoteTrack.Label(), localTrackID, localTrackLabel, remoteTrack.SSRC())
+	localTrackID := getLocalTrackID(remoteTrackID)
+	log := p.log.WithCtx(LogCtx{"trackID": localTrackID})
+	log.Printf("peer.startCopyingTrack: (id: %s, label: %s) to (id: %s, label: %s), ssrc: %d",
+		remoteTrack.ID(), remoteTrack.Label(), localTrackID, localTrackLabel, remoteTrack.SSRC())
 
-	ssrc := remoteTrack.SSRC()
+	remoteSSRC := remoteTrack.SSRC()
+	ssrc := p.ssrcs.acquire(remoteSSRC)
+	if ssrc != remoteSSRC {
+		log.Printf("peer.startCopyingTrack: ssrc: %d already in use, remapping to: %d", remoteSSRC, ssrc)
+	}
 	// Create a local track, all our SFU clients will be fed via this track
 	localTrack, err := p.peerConnection.NewTrack(remoteTrack.PayloadType(), ssrc, localTrackID, localTrackLabel)
 	if err != nil {
+		p.ssrcs.release(ssrc)
 		err = fmt.Errorf("[%s] peer.startCopyingTrack: error creating new track, trackID: %s, error: %s", p.clientID, remoteTrack.ID(), err)
 		return nil, err
 	}
 
-	// Send a PLI on an interval so that the publisher is pushing a keyframe every rtcpPLIInterval
-	// This can be less wasteful by processing incoming RTCP events, then we would emit a NACK/PLI when a viewer requests it
-
-	ticker := time.NewTicker(rtcpPLIInterval)
-	go func() {
-		writeRTCP := func() {
-			err := p.peerConnection.WriteRTCP(
-				[]rtcp.Packet{
-					&rtcp.PictureLossIndication{
-						MediaSSRC: ssrc,
-					},
-				},
-			)
-			if err != nil {
-				p.log.Printf("[%s] Error sending rtcp PLI for local track: %s: %s",
-					p.clientID,
-					localTrackID,
-					err,
-				)
-			}
+	var chain *interceptorChain
+	// Keyframes cannot be detected in encrypted payloads
+	writer := newTrackWriter(localTrack, !p.encrypted, func() {
+		err := chain.WriteRTCP([]rtcp.Packet{
+			&rtcp.PictureLossIndication{MediaSSRC: ssrc},
+		})
+		if err != nil {
+			log.Errorf("Error requesting keyframe for new subscriber: %s", err)
 		}
+	})
+	writer.setScreen(p.TrackSource(localTrack) == TrackSourceScreen)
+	chain, err = newInterceptorChain(
+		p.interceptorFactories,
+		InterceptorParams{
+			ClientID:    p.clientID,
+			Room:        p.room,
+			RemoteTrack: remoteTrack,
+			LocalTrack:  localTrack,
+			Source:      p.TrackSource(localTrack),
+			Encrypted:   p.encrypted,
+			Subscribers: writer.subscribers,
+		},
+		RTPWriterFunc(func(packet *rtp.Packet) error {
+			// ErrClosedPipe means we don't have any subscribers, this is ok if no peers have connected yet
+			if err := writer.WriteRTP(packet); err != nil && err != io.ErrClosedPipe {
+				return err
+			}
+			return nil
+		}),
+		newSSRCTranslator(ssrc, remoteSSRC, RTCPWriterFunc(p.peerConnection.WriteRTCP)),
+	)
+	if err != nil {
+		p.ssrcs.release(ssrc)
+		return nil, fmt.Errorf("[%s] peer.startCopyingTrack: %w", p.clientID, err)
+	}
 
-		writeRTCP()
-		for range ticker.C {
-			writeRTCP()
-		}
-	}()
+	p.mu.Lock()
+	p.interceptorsByTrack[localTrack] = chain
+	p.writersByTrack[localTrack] = writer
+	if ssrc != remoteSSRC {
+		p.remoteSSRCByTrack[localTrack] = remoteSSRC
+	}
+	p.mu.Unlock()
+
+	forwarded := p.forwarding.Track(localTrackID, chain)
 
 	go func() {
-		defer ticker.Stop()
 		defer func() {
-			p.mu.RLock()
-			if !p.tracksChannelClosed {
-				p.tracksChannel <- TrackEvent{p.clientID, localTrack, TrackEventTypeRemove}
+			forwarded.Close()
+
+			p.mu.Lock()
+			delete(p.interceptorsByTrack, localTrack)
+			delete(p.writersByTrack, localTrack)
+			delete(p.remoteSSRCByTrack, localTrack)
+			p.mu.Unlock()
+
+			p.ssrcs.release(ssrc)
+
+			if err := chain.Close(); err != nil {
+				log.Errorf("Error closing interceptors: %s", err)
 			}
-			p.mu.RUnlock()
 		}()
-		rtpBuf := make([]byte, 1400)
+		defer func() {
+			p.removeLocalTrack(localTrack)
+
+			p.sendTrackEvent(TrackEvent{
+				ClientID: p.clientID,
+				Track:    localTrack,
+				Type:     TrackEventTypeRemove,
+				Source:   p.TrackSource(localTrack),
+			})
+		}()
 		for {
-			i, err := remoteTrack.Read(rtpBuf)
+			packet, err := readRTP(remoteTrack)
 			if err != nil {
-				p.log.Printf(
-					"[%s] Error reading from remote track: %s: %s",
-					p.clientID,
-					remoteTrack.ID(),
-					err,
-				)
+				log.Errorf("Error reading from remote track: %s: %s", remoteTrack.ID(), err)
 				return
 			}
 
-			// ErrClosedPipe means we don't have any subscribers, this is ok if no peers have connected yet
-			if _, err = localTrack.Write(rtpBuf[:i]); err != nil && err != io.ErrClosedPipe {
-				p.log.Printf(
-					"[%s] Error writing to local track: %s: %s",
-					p.clientID,
-					localTrackID,
-					err,
-				)
+			if err := forwarded.WriteRTP(packet); err != nil {
+				log.Errorf("Error writing to local track: %s", err)
 				return
 			}
 		}
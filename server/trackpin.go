@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v2"
+)
+
+// maxPinDuration limits how long a track stays pinned when the client
+// does not clear the pin, for example because it was disconnected while a
+// tile was maximized. Clients can renew the pin before it expires.
+const maxPinDuration = 5 * time.Minute
+
+// trackPin is a track the subscriber has pinned, for example by
+// maximizing its tile.
+type trackPin struct {
+	publisherID string
+	trackID     string
+	timer       *time.Timer
+}
+
+func (p *peer) isPinned(publisherID string, track *webrtc.Track) bool {
+	return p.pin != nil && p.pin.publisherID == publisherID && p.pin.trackID == track.ID()
+}
+
+// SetPin pins the video track trackID of publisherID for clientID for the
+// given duration, or maxPinDuration when duration is zero. The pinned
+// track is always forwarded, even when last-N forwarding would drop it, and
+// takes one of the subscriber's last-N slots while other video tracks share
+// the rest. A keyframe is requested from the publisher so that the pinned
+// tile is refreshed immediately. An empty trackID clears the pin.
+func (t *MemoryTracksManager) SetPin(clientID string, publisherID string, trackID string, duration time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[clientID]
+	if !ok {
+		return fmt.Errorf("[%s] SetPin: peer not found", clientID)
+	}
+
+	if p.pin != nil {
+		p.pin.timer.Stop()
+		p.pin = nil
+	}
+
+	if trackID == "" {
+		t.log.Printf("[%s] SetPin: cleared", clientID)
+		t.reconcile(p.room)
+		return nil
+	}
+
+	publisher, ok := t.peers[publisherID]
+	if !ok || publisher.room != p.room {
+		t.reconcile(p.room)
+		return fmt.Errorf("[%s] SetPin: publisher not found: %s", clientID, publisherID)
+	}
+
+	var track *webrtc.Track
+	for _, snapshot := range publisher.trackListener.Snapshot() {
+		if snapshot.TrackID == trackID && snapshot.Kind == webrtc.RTPCodecTypeVideo.String() {
+			track = snapshot.track
+			break
+		}
+	}
+	if track == nil {
+		t.reconcile(p.room)
+		return fmt.Errorf("[%s] SetPin: video track not found: %s", clientID, trackID)
+	}
+
+	if duration <= 0 || duration > maxPinDuration {
+		duration = maxPinDuration
+	}
+
+	t.log.Printf("[%s] SetPin: %s of %s for %s", clientID, trackID, publisherID, duration)
+	pin := &trackPin{
+		publisherID: publisherID,
+		trackID:     trackID,
+	}
+	pin.timer = time.AfterFunc(duration, func() {
+		t.expirePin(clientID, pin)
+	})
+	p.pin = pin
+
+	t.reconcile(p.room)
+
+	feedback := publisher.trackListener.RTCPWriter(track)
+	go func() {
+		err := feedback.WriteRTCP([]rtcp.Packet{
+			&rtcp.PictureLossIndication{MediaSSRC: track.SSRC()},
+		})
+		if err != nil {
+			t.log.Printf("[%s] SetPin: Error requesting keyframe of track: %s: %s", clientID, trackID, err)
+		}
+	}()
+
+	return nil
+}
+
+func (t *MemoryTracksManager) expirePin(clientID string, pin *trackPin) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[clientID]
+	if !ok || p.pin != pin {
+		return
+	}
+
+	t.log.Printf("[%s] Pin of track: %s expired", clientID, pin.trackID)
+	p.pin = nil
+	t.reconcile(p.room)
+}
@@ -3,61 +3,380 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"runtime"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v2"
 )
 
 const DataChannelName = "data"
 
+// MemoryTracksManager forwards tracks between peers of the same room.
+//
+// Ownership:
+//
+//   - mu guards room membership and all peer state, including
+//     peer.forwarded, which is the desired set of tracks of each subscriber.
+//   - Tracks of subscribers are only added and removed by functions
+//     dispatched to fanOut with the subscriber's clientID, so they are
+//     applied in order. Descriptions and candidates are set by the
+//     subscriber's Signaller on other goroutines, so all of these changes
+//     are made with the lock of the Signaller held, and a peer connection
+//     is never changed concurrently. Dispatched functions must not acquire
+//     mu.
+//   - Each trackListener guards its own state. mu may be held while calling
+//     into a trackListener, but a trackListener never calls back into the
+//     MemoryTracksManager while holding its own locks.
 type MemoryTracksManager struct {
 	loggerFactory LoggerFactory
 	log           Logger
 	mu            sync.RWMutex
 	// key is clientID
-	peers map[string]peer
+	peers map[string]*peer
 	// key is room, value is clientID
 	peerIDsByRoom map[string]map[string]struct{}
+
+	stats                *StatsInterceptorFactory
+	activity             *ActivityDetectorFactory
+	bandwidth            *BandwidthEnforcer
+	audit                *AuditLog
+	webhooks             *Webhooks
+	eventLog             *EventLog
+	tracer               *Tracer
+	chatHistory          *ChatHistory
+	chatAllowed          func(room string) bool
+	interceptorFactories []InterceptorFactory
+	// ssrcs keeps SSRCs of forwarded tracks unique
+	ssrcs *ssrcRegistry
+	// nack and keyframes are kept to change their settings at runtime
+	nack      *nackResponderFactory
+	keyframes *KeyframeRequester
+	// sendQueueSize is the size of send queues of peers joining afterwards
+	sendQueueSize int
+
+	// lastN is the maximum number of video tracks forwarded to each
+	// subscriber in rooms with more than lastN peers.
+	lastN int
+	// rooms in which video tracks are not forwarded
+	audioOnlyRooms map[string]struct{}
+	sfuConfig      NetworkConfigSFU
+
+	// fanOut applies track changes to subscribers' peer connections. Changes
+	// of each subscriber are applied in order, so a slow negotiation only
+	// delays subscribers sharing the same shard.
+	fanOut *ShardedDispatcher
+	// forwarding writes packets of published tracks to their interceptors
+	forwarding *ForwardingPool
+	floor      *floorControl
+	// captures writes packets of peers to files for debugging
+	captures *PacketCaptures
+
+	// key is injection ID
+	injections map[string]*audioInjection
+	// key is ingest source ID
+	ingests map[string]*ingest
+	// tracks originating from the server which are forwarded to all peers.
+	// Key is room.
+	serverTracks map[string][]*webrtc.Track
+	// breakout rooms peers have been moved to. Key is clientID.
+	breakoutRooms map[string]string
+}
+
+func NewMemoryTracksManager(loggerFactory LoggerFactory, sfuConfig NetworkConfigSFU) *MemoryTracksManager {
+	t := &MemoryTracksManager{
+		loggerFactory:  loggerFactory,
+		log:            loggerFactory.GetLogger("tracks"),
+		peers:          map[string]*peer{},
+		peerIDsByRoom:  map[string]map[string]struct{}{},
+		stats:          NewStatsInterceptorFactory(),
+		lastN:          sfuConfig.LastN,
+		audioOnlyRooms: map[string]struct{}{},
+		sfuConfig:      sfuConfig,
+		injections:     map[string]*audioInjection{},
+		ingests:        map[string]*ingest{},
+		serverTracks:   map[string][]*webrtc.Track{},
+		breakoutRooms:  map[string]string{},
+		ssrcs:          newSSRCRegistry(),
+		nack:           newNACKResponderFactory(loggerFactory),
+		keyframes:      NewPLIThrottlerFactory(loggerFactory, rtcpPLIInterval, rtcpPLIMinInterval, rtcpPLIMinIntervalScreen),
+		sendQueueSize:  defaultSendQueueSize,
+	}
+
+	fanOutWorkers := sfuConfig.FanOutWorkers
+	if fanOutWorkers <= 0 {
+		fanOutWorkers = runtime.NumCPU()
+	}
+	t.fanOut = NewShardedDispatcher(fanOutWorkers)
+
+	forwardingWorkers := sfuConfig.ForwardingWorkers
+	if forwardingWorkers <= 0 {
+		forwardingWorkers = runtime.NumCPU()
+	}
+	t.forwarding = NewForwardingPool(forwardingWorkers)
+
+	for _, room := range sfuConfig.AudioOnlyRooms {
+		t.audioOnlyRooms[room] = struct{}{}
+	}
+
+	t.activity = NewActivityDetectorFactory(t.handleActiveSpeaker)
+	// The bandwidth enforcer must come before other interceptors so that
+	// dropped packets are neither detected as activity nor buffered.
+	t.bandwidth = NewBandwidthEnforcer(loggerFactory, sfuConfig.Bandwidth)
+	t.interceptorFactories = []InterceptorFactory{
+		t.stats,
+		t.bandwidth,
+	}
+	t.captures = NewPacketCaptures(loggerFactory, sfuConfig.Capture)
+	if t.captures.Enabled() {
+		// Captures packets as they were received, before any interceptor
+		// drops or changes them.
+		t.interceptorFactories = append([]InterceptorFactory{t.captures}, t.interceptorFactories...)
+	}
+	t.floor = newFloorControl(t.log, sfuConfig.PushToTalk)
+	if t.floor.Enabled() {
+		// Like the bandwidth enforcer, must come before the activity detector
+		// so that peers without the floor are not detected as speaking.
+		t.interceptorFactories = append(t.interceptorFactories, t.floor)
+	}
+	if sfuConfig.Reorder.Enabled() {
+		// Must come before interceptors which depend on the order of
+		// packets, like keyframe detection of the rewind buffer.
+		t.interceptorFactories = append(t.interceptorFactories, NewReorderBufferFactory(loggerFactory, sfuConfig.Reorder))
+	}
+	t.interceptorFactories = append(t.interceptorFactories,
+		t.activity,
+		t.nack,
+	)
+	if sfuConfig.Rewind.Duration > 0 {
+		// Must come before the PLI throttler to see all keyframe requests
+		t.interceptorFactories = append(t.interceptorFactories, NewRewindBufferFactory(
+			loggerFactory,
+			sfuConfig.Rewind.Duration,
+			sfuConfig.Rewind.MaxRoomBytes,
+		))
+	}
+	t.interceptorFactories = append(t.interceptorFactories, t.keyframes)
+	if sfuConfig.HeaderExtensions {
+		t.interceptorFactories = append(t.interceptorFactories, NewHeaderExtensionRewriterFactory())
+	}
+
+	return t
+}
+
+// Use appends interceptors to the chain of every track published after this
+// call.
+func (t *MemoryTracksManager) Use(factories ...InterceptorFactory) {
+	t.mu.Lock()
+	t.interceptorFactories = append(t.interceptorFactories, factories...)
+	t.mu.Unlock()
 }
 
-func NewMemoryTracksManager(loggerFactory LoggerFactory) *MemoryTracksManager {
-	return &MemoryTracksManager{
-		loggerFactory: loggerFactory,
-		log:           loggerFactory.GetLogger("tracks"),
-		peers:         map[string]peer{},
-		peerIDsByRoom: map[string]map[string]struct{}{},
+// TrackStats returns packet statistics for all published tracks.
+func (t *MemoryTracksManager) TrackStats() []TrackStats {
+	return t.stats.Stats()
+}
+
+// SetBandwidthDisconnect sets the function which disconnects publishers
+// exceeding the maximum bitrate when the bandwidth action is disconnect. It
+// must be called before any tracks are published.
+func (t *MemoryTracksManager) SetBandwidthDisconnect(disconnect func(room string, clientID string) bool) {
+	t.bandwidth.SetDisconnect(disconnect)
+}
+
+// SetTenants sets the tenants whose maximum bitrate applies to their rooms.
+// It must be called before any tracks are published.
+func (t *MemoryTracksManager) SetTenants(tenants *Tenants) {
+	t.bandwidth.SetTenants(tenants)
+}
+
+// FanOutStats returns the state of the workers which apply track changes to
+// subscribers.
+func (t *MemoryTracksManager) FanOutStats() []ShardStats {
+	return t.fanOut.Stats()
+}
+
+// TrackEventStats returns the sum of the counters of track events of all
+// peers.
+func (t *MemoryTracksManager) TrackEventStats() TrackEventStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var stats TrackEventStats
+	for _, p := range t.peers {
+		stats = stats.Add(p.trackListener.TrackEventStats())
+	}
+	return stats
+}
+
+// SendQueueStats returns the sum of the counters of packets sent to all
+// peers.
+func (t *MemoryTracksManager) SendQueueStats() SendQueueStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var stats SendQueueStats
+	for _, p := range t.peers {
+		stats = stats.Add(p.trackListener.SendQueueStats())
 	}
+	return stats
 }
 
+// ForwardingStats returns the state of the workers which forward packets of
+// published tracks.
+func (t *MemoryTracksManager) ForwardingStats() []ShardStats {
+	return t.forwarding.Stats()
+}
+
+// LastMediaActivity returns the time media was last received from clientID.
+func (t *MemoryTracksManager) LastMediaActivity(clientID string) time.Time {
+	return t.stats.LastPacketTime(clientID)
+}
+
+// LastActive returns the time clientID was last detected speaking.
+func (t *MemoryTracksManager) LastActive(clientID string) time.Time {
+	return t.activity.LastActive(clientID)
+}
+
+// AddServerTrack forwards a track originating from the server to all peers
+// in room, regardless of their subscriptions. Peers joining the room later
+// receive it too.
+func (t *MemoryTracksManager) AddServerTrack(room string, track *webrtc.Track) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.addServerTrack(room, track)
+}
+
+// RemoveServerTrack stops forwarding a track added with AddServerTrack.
+func (t *MemoryTracksManager) RemoveServerTrack(room string, track *webrtc.Track) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.removeServerTrack(room, track)
+}
+
+func (t *MemoryTracksManager) addServerTrack(room string, track *webrtc.Track) {
+	t.serverTracks[room] = append(t.serverTracks[room], track)
+	if _, ok := t.peerIDsByRoom[room]; ok {
+		t.reconcile(room)
+	}
+}
+
+func (t *MemoryTracksManager) removeServerTrack(room string, track *webrtc.Track) {
+	tracks := t.serverTracks[room]
+	for i, serverTrack := range tracks {
+		if serverTrack == track {
+			tracks = append(tracks[:i:i], tracks[i+1:]...)
+			break
+		}
+	}
+	if len(tracks) == 0 {
+		delete(t.serverTracks, room)
+	} else {
+		t.serverTracks[room] = tracks
+	}
+
+	for clientID := range t.peerIDsByRoom[room] {
+		subscriber := t.peers[clientID]
+		if _, ok := subscriber.forwarded[track]; !ok {
+			continue
+		}
+		delete(subscriber.forwarded, track)
+		t.updateForwardedTracks(subscriber, nil, []*webrtc.Track{track})
+	}
+}
+
+// discardRTCP drops RTCP feedback for server-originated tracks.
+var discardRTCP = RTCPWriterFunc(func(packets []rtcp.Packet) error {
+	return nil
+})
+
 type peer struct {
 	trackListener   *trackListener
 	dataTransceiver *DataTransceiver
 	room            string
 	signaller       *Signaller
+	joinedAt        time.Time
+
+	// key is local track ID, value is language code
+	trackLanguages map[string]string
+	// preferred audio language of this peer when subscribing
+	languageFilter string
+	// clientIDs of peers whose tracks this peer receives. When nil, tracks
+	// of all peers in the room are received.
+	subscriptions map[string]struct{}
+	// when true, no video tracks are forwarded to this peer
+	audioOnly bool
+	// when true, RTP packets of video tracks forwarded to this peer are not
+	// written, and no video tracks are added
+	videoPaused bool
+	// when true, audio tracks of this peer are not forwarded to anyone
+	muted bool
+	// video track pinned by this peer
+	pin *trackPin
+	// tracks of other peers that are forwarded, or queued to be forwarded, to
+	// this peer. Value is the publisher's clientID.
+	forwarded map[*webrtc.Track]string
+}
+
+func (p *peer) isSubscribedTo(clientID string) bool {
+	if p.subscriptions == nil {
+		return true
+	}
+	_, ok := p.subscriptions[clientID]
+	return ok
 }
 
 func (t *MemoryTracksManager) addTrack(room string, clientID string, track *webrtc.Track) {
+	t.log.Printf("[%s] addTrack ssrc: %d to other peers", clientID, track.SSRC())
+
+	span := t.tracer.Join(clientID).Child("sfu.track")
+	span.SetAttribute("trackID", track.ID())
+	span.SetAttribute("kind", track.Kind().String())
+	defer span.End()
+
+	t.webhooks.Emit(WebhookTrackPublished, room, clientID, WebhookTrack{
+		TrackID: track.ID(),
+		Kind:    track.Kind().String(),
+		SSRC:    track.SSRC(),
+	})
+	t.eventLog.Emit(EventLogEntry{
+		Type:     EventLogTrackAdd,
+		Room:     room,
+		ClientID: clientID,
+		Details:  eventLogTrackDetails(track),
+	})
+
 	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	for otherClientID, otherPeerInRoom := range t.peers {
-		if otherClientID != clientID {
-			if err := addTrackToPeer(t.log, otherPeerInRoom, track); err != nil {
-				t.log.Printf("[%s] MemoryTracksManager.addTrack Error adding track: %s", otherClientID, err)
-				continue
-			}
-		}
+	// the peer might be in a breakout room
+	if p, ok := t.peers[clientID]; ok {
+		room = p.room
 	}
-
-	t.mu.Unlock()
+	t.reconcile(room)
 }
 
 func (t *MemoryTracksManager) broadcast(clientID string, msg webrtc.DataChannelMessage) {
 	t.mu.Lock()
 
-	for otherClientID, otherPeerInRoom := range t.peers {
+	p, ok := t.peers[clientID]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+
+	if msg.IsString {
+		t.chatHistory.add(p.room, clientID, msg.Data)
+	}
+
+	for otherClientID := range t.peerIDsByRoom[p.room] {
 		if otherClientID != clientID {
 			t.log.Printf("[%s] broadcast from %s", otherClientID, clientID)
-			tr := otherPeerInRoom.dataTransceiver
+			tr := t.peers[otherClientID].dataTransceiver
 			var err error
 			if msg.IsString {
 				textData := msg.Data
@@ -79,9 +398,9 @@ func (t *MemoryTracksManager) broadcast(clientID string, msg webrtc.DataChannelM
 	t.mu.Unlock()
 }
 
-func addTrackToPeer(log Logger, p peer, track *webrtc.Track) error {
+func addTrackToPeer(log Logger, p *peer, track *webrtc.Track, feedback RTCPWriter) error {
 	trackListener := p.trackListener
-	if err := trackListener.AddTrack(track); err != nil {
+	if err := trackListener.AddTrack(track, feedback); err != nil {
 		return fmt.Errorf("[%s] addTrackToPeer Error adding track: %s: %s", trackListener.ClientID(), track.ID(), err)
 	}
 
@@ -97,6 +416,641 @@ func addTrackToPeer(log Logger, p peer, track *webrtc.Track) error {
 	return nil
 }
 
+// forwardedTrack is a track queued to be added to a subscriber.
+type forwardedTrack struct {
+	track       *webrtc.Track
+	feedback    RTCPWriter
+	publisherID string
+}
+
+// updateForwardedTracks queues adding and removing tracks to subscriber's
+// peer connection. The changes must already be reflected in
+// subscriber.forwarded.
+func (t *MemoryTracksManager) updateForwardedTracks(subscriber *peer, added []forwardedTrack, removed []*webrtc.Track) {
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	clientID := subscriber.trackListener.ClientID()
+
+	// spans measure the time from queueing until the track is added
+	spans := make([]*Span, len(added))
+	for i, f := range added {
+		if f.publisherID == localPeerID {
+			continue
+		}
+		spans[i] = t.tracer.Join(f.publisherID).Child("sfu.add_track")
+		spans[i].SetAttribute("subscriber", clientID)
+		spans[i].SetAttribute("trackID", f.track.ID())
+	}
+
+	t.fanOut.Dispatch(clientID, func() {
+		for _, track := range removed {
+			if err := subscriber.trackListener.RemoveTrack(track); err != nil {
+				t.log.Printf("[%s] Error removing track: %s", clientID, err)
+			}
+		}
+		for i, f := range added {
+			err := addTrackToPeer(t.log, subscriber, f.track, f.feedback)
+			if err != nil {
+				t.log.Printf("[%s] Error adding track: %s", clientID, err)
+			}
+			spans[i].SetError(err)
+			spans[i].End()
+		}
+		if len(removed) > 0 {
+			subscriber.signaller.Negotiate()
+		}
+	})
+}
+
+// reconcile adds tracks to and removes tracks from all peers in room so that
+// every peer receives exactly the tracks it is subscribed to. It must be
+// called with t.mu held.
+func (t *MemoryTracksManager) reconcile(room string) {
+	clientIDs, ok := t.peerIDsByRoom[room]
+	if !ok {
+		t.log.Printf("reconcile: Cannot find any peers in room: %s", room)
+		return
+	}
+
+	state := reconcileState{
+		languages: t.trackLanguagesInRoom(room),
+		speakers:  t.activeSpeakersInRoom(room),
+	}
+
+	for clientID := range clientIDs {
+		subscriber, ok := t.peers[clientID]
+		if !ok {
+			t.log.Printf("[%s] reconcile: Cannot find peer", clientID)
+			continue
+		}
+
+		var added []forwardedTrack
+		var removed []*webrtc.Track
+
+		for publisherID := range clientIDs {
+			if publisherID == clientID {
+				continue
+			}
+			publisher := t.peers[publisherID]
+
+			for _, track := range publisher.trackListener.Tracks() {
+				_, isForwarded := subscriber.forwarded[track]
+				shouldForward := t.shouldForward(subscriber, publisher, track, state)
+
+				switch {
+				case shouldForward && !isForwarded:
+					subscriber.forwarded[track] = publisherID
+					added = append(added, forwardedTrack{
+						track:       track,
+						feedback:    publisher.trackListener.RTCPWriter(track),
+						publisherID: publisherID,
+					})
+				case !shouldForward && isForwarded:
+					delete(subscriber.forwarded, track)
+					removed = append(removed, track)
+				}
+			}
+		}
+
+		for _, track := range t.serverTracks[room] {
+			if _, isForwarded := subscriber.forwarded[track]; !isForwarded {
+				subscriber.forwarded[track] = localPeerID
+				added = append(added, forwardedTrack{
+					track:       track,
+					feedback:    discardRTCP,
+					publisherID: localPeerID,
+				})
+			}
+		}
+
+		t.updateForwardedTracks(subscriber, added, removed)
+	}
+}
+
+// reconcileState contains room-wide information needed to decide which
+// tracks to forward.
+type reconcileState struct {
+	// languages of all published audio tracks
+	languages map[string]struct{}
+	// clientIDs of peers ordered by most recent speaker activity. Nil when
+	// all video tracks should be forwarded.
+	speakers []string
+}
+
+// shouldForward returns true when track published by publisher should be
+// forwarded to subscriber.
+func (t *MemoryTracksManager) shouldForward(subscriber *peer, publisher *peer, track *webrtc.Track, state reconcileState) bool {
+	publisherID := publisher.trackListener.ClientID()
+	if !subscriber.isSubscribedTo(publisherID) {
+		return false
+	}
+	if track.Kind() == webrtc.RTPCodecTypeAudio {
+		if publisher.muted {
+			return false
+		}
+		return matchesLanguageFilter(subscriber.languageFilter, publisher.trackLanguages[track.ID()], state.languages)
+	}
+	if subscriber.audioOnly || t.isAudioOnlyRoom(subscriber.room) {
+		return false
+	}
+	if subscriber.videoPaused {
+		// avoids renegotiation while the video is not displayed anyway
+		_, isForwarded := subscriber.forwarded[track]
+		return isForwarded
+	}
+	if subscriber.isPinned(publisherID, track) {
+		return true
+	}
+	// Screen shares are forwarded regardless of speaker activity
+	if state.speakers != nil && publisher.trackListener.TrackSource(track) != TrackSourceScreen {
+		speakers, n := state.speakers, t.lastN
+		if pin := subscriber.pin; pin != nil {
+			// the pinned track takes one of the slots
+			speakers, n = withoutSpeaker(speakers, pin.publisherID), n-1
+		}
+		return isLastNSpeaker(speakers, n, subscriber.trackListener.ClientID(), publisherID)
+	}
+	return true
+}
+
+func withoutSpeaker(speakers []string, clientID string) []string {
+	result := make([]string, 0, len(speakers))
+	for _, speakerID := range speakers {
+		if speakerID != clientID {
+			result = append(result, speakerID)
+		}
+	}
+	return result
+}
+
+func (t *MemoryTracksManager) isAudioOnlyRoom(room string) bool {
+	_, ok := t.audioOnlyRooms[room]
+	return ok
+}
+
+// isLastNSpeaker returns true when publisherID is among the first n
+// speakers, not counting the subscriber itself.
+func isLastNSpeaker(speakers []string, n int, subscriberID string, publisherID string) bool {
+	for _, speakerID := range speakers {
+		if n == 0 {
+			return false
+		}
+		if speakerID == publisherID {
+			return true
+		}
+		if speakerID != subscriberID {
+			n--
+		}
+	}
+	return false
+}
+
+// activeSpeakersInRoom returns clientIDs of peers in room ordered by most
+// recent speaker activity, or nil when last-N forwarding is not in effect
+// for the room. Peers that have not spoken yet are ordered by join time.
+func (t *MemoryTracksManager) activeSpeakersInRoom(room string) []string {
+	clientIDs := t.peerIDsByRoom[room]
+	if t.lastN <= 0 || len(clientIDs) <= t.lastN {
+		return nil
+	}
+
+	type speaker struct {
+		clientID   string
+		lastActive time.Time
+		joinedAt   time.Time
+	}
+
+	speakers := make([]speaker, 0, len(clientIDs))
+	for clientID := range clientIDs {
+		p, ok := t.peers[clientID]
+		if !ok {
+			continue
+		}
+		speakers = append(speakers, speaker{clientID, t.activity.LastActive(clientID), p.joinedAt})
+	}
+
+	sort.Slice(speakers, func(i, j int) bool {
+		if !speakers[i].lastActive.Equal(speakers[j].lastActive) {
+			return speakers[i].lastActive.After(speakers[j].lastActive)
+		}
+		return speakers[i].joinedAt.Before(speakers[j].joinedAt)
+	})
+
+	result := make([]string, len(speakers))
+	for i, s := range speakers {
+		result[i] = s.clientID
+	}
+	return result
+}
+
+// handleActiveSpeaker switches forwarded video tracks when a peer starts
+// speaking in a room with last-N forwarding in effect.
+func (t *MemoryTracksManager) handleActiveSpeaker(clientID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[clientID]
+	if !ok {
+		return
+	}
+
+	if t.lastN > 0 && len(t.peerIDsByRoom[p.room]) > t.lastN {
+		t.log.Printf("[%s] handleActiveSpeaker: reconciling room: %s", clientID, p.room)
+		t.reconcile(p.room)
+	}
+}
+
+// matchesLanguageFilter returns true when an audio track with trackLanguage
+// should be forwarded to a subscriber with languageFilter. Untagged tracks
+// are floor audio, which is forwarded unless there is a track in the
+// subscriber's preferred language available.
+func matchesLanguageFilter(languageFilter string, trackLanguage string, languages map[string]struct{}) bool {
+	if trackLanguage != "" {
+		return trackLanguage == languageFilter
+	}
+	if languageFilter == "" {
+		return true
+	}
+	_, ok := languages[languageFilter]
+	return !ok
+}
+
+// trackLanguagesInRoom returns the set of languages of all published audio
+// tracks in room.
+func (t *MemoryTracksManager) trackLanguagesInRoom(room string) map[string]struct{} {
+	languages := map[string]struct{}{}
+	for clientID := range t.peerIDsByRoom[room] {
+		publisher, ok := t.peers[clientID]
+		if !ok {
+			continue
+		}
+		for _, snapshot := range publisher.trackListener.Snapshot() {
+			if snapshot.Kind != webrtc.RTPCodecTypeAudio.String() {
+				continue
+			}
+			if language := publisher.trackLanguages[snapshot.TrackID]; language != "" {
+				languages[language] = struct{}{}
+			}
+		}
+	}
+	return languages
+}
+
+// SetTrackLanguage tags an audio track published by clientID with a language
+// code. The trackID is the ID of the track on the publisher's side. An empty
+// language marks the track as floor audio.
+func (t *MemoryTracksManager) SetTrackLanguage(clientID string, trackID string, language string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[clientID]
+	if !ok {
+		return fmt.Errorf("[%s] SetTrackLanguage: peer not found", clientID)
+	}
+
+	t.log.Printf("[%s] SetTrackLanguage track: %s, language: %s", clientID, trackID, language)
+	if language == "" {
+		delete(p.trackLanguages, getLocalTrackID(trackID))
+	} else {
+		p.trackLanguages[getLocalTrackID(trackID)] = language
+	}
+
+	t.reconcile(p.room)
+	return nil
+}
+
+// SetTrackSource sets the media source of a track published by clientID.
+// The trackID is the ID of the track on the publisher's side. The source
+// should be set before the track is negotiated so that the interceptors of
+// the track can take it into account.
+func (t *MemoryTracksManager) SetTrackSource(clientID string, trackID string, source TrackSource) error {
+	if !source.Valid() {
+		return fmt.Errorf("[%s] SetTrackSource: invalid source: %s", clientID, source)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[clientID]
+	if !ok {
+		return fmt.Errorf("[%s] SetTrackSource: peer not found", clientID)
+	}
+
+	t.log.Printf("[%s] SetTrackSource track: %s, source: %s", clientID, trackID, source)
+	p.trackListener.SetTrackSource(trackID, source)
+
+	t.reconcile(p.room)
+	return nil
+}
+
+// SetLanguageFilter sets the preferred audio language of clientID. An empty
+// language receives floor audio only.
+func (t *MemoryTracksManager) SetLanguageFilter(clientID string, language string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[clientID]
+	if !ok {
+		return fmt.Errorf("[%s] SetLanguageFilter: peer not found", clientID)
+	}
+
+	t.log.Printf("[%s] SetLanguageFilter language: %s", clientID, language)
+	p.languageFilter = language
+
+	t.reconcile(p.room)
+	return nil
+}
+
+// Subscribe adds peers to the list of peers whose tracks clientID receives.
+// The first call switches clientID from receiving tracks of all peers in
+// the room to receiving only tracks of subscribed peers.
+func (t *MemoryTracksManager) Subscribe(clientID string, publisherIDs []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[clientID]
+	if !ok {
+		return fmt.Errorf("[%s] Subscribe: peer not found", clientID)
+	}
+
+	t.log.Printf("[%s] Subscribe to: %v", clientID, publisherIDs)
+	if p.subscriptions == nil {
+		p.subscriptions = map[string]struct{}{}
+	}
+	for _, publisherID := range publisherIDs {
+		p.subscriptions[publisherID] = struct{}{}
+	}
+
+	t.reconcile(p.room)
+	return nil
+}
+
+// Unsubscribe stops forwarding tracks of publisherIDs to clientID.
+func (t *MemoryTracksManager) Unsubscribe(clientID string, publisherIDs []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[clientID]
+	if !ok {
+		return fmt.Errorf("[%s] Unsubscribe: peer not found", clientID)
+	}
+
+	t.log.Printf("[%s] Unsubscribe from: %v", clientID, publisherIDs)
+	if p.subscriptions == nil {
+		p.subscriptions = map[string]struct{}{}
+		for otherClientID := range t.peerIDsByRoom[p.room] {
+			if otherClientID != clientID {
+				p.subscriptions[otherClientID] = struct{}{}
+			}
+		}
+	}
+	for _, publisherID := range publisherIDs {
+		delete(p.subscriptions, publisherID)
+	}
+
+	t.reconcile(p.room)
+	return nil
+}
+
+// SubscribeAll resets the subscriptions of clientID so that tracks of all
+// peers in the room are received again.
+func (t *MemoryTracksManager) SubscribeAll(clientID string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[clientID]
+	if !ok {
+		return fmt.Errorf("[%s] SubscribeAll: peer not found", clientID)
+	}
+
+	t.log.Printf("[%s] SubscribeAll", clientID)
+	p.subscriptions = nil
+
+	t.reconcile(p.room)
+	return nil
+}
+
+// RequestFloor grants clientID the floor of its push-to-talk room, so that
+// its audio is forwarded. Returns false when the floor is held by as many
+// peers as can speak at the same time.
+func (t *MemoryTracksManager) RequestFloor(clientID string) (bool, error) {
+	granted, err := t.floor.Request(clientID)
+	if err != nil {
+		return false, fmt.Errorf("[%s] RequestFloor: %w", clientID, err)
+	}
+	return granted, nil
+}
+
+// ReleaseFloor releases the floor held by clientID.
+func (t *MemoryTracksManager) ReleaseFloor(clientID string) error {
+	if err := t.floor.Release(clientID); err != nil {
+		return fmt.Errorf("[%s] ReleaseFloor: %w", clientID, err)
+	}
+	return nil
+}
+
+// SetFloorChanged sets the function called with the peers holding the floor
+// of a push-to-talk room when it changes, and when a peer joins the room. It
+// must be called before any peers are added.
+func (t *MemoryTracksManager) SetFloorChanged(changed func(room string, speakers []string)) {
+	t.floor.OnChanged(changed)
+}
+
+// SetAudioOnly enables or disables forwarding of video tracks to clientID.
+// Video is never forwarded in audio-only rooms, regardless of this setting.
+func (t *MemoryTracksManager) SetAudioOnly(clientID string, audioOnly bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[clientID]
+	if !ok {
+		return fmt.Errorf("[%s] SetAudioOnly: peer not found", clientID)
+	}
+
+	t.log.Printf("[%s] SetAudioOnly: %t", clientID, audioOnly)
+	p.audioOnly = audioOnly
+
+	t.reconcile(p.room)
+	return nil
+}
+
+// SetVideoPaused stops or resumes writing video to clientID, for example
+// while its tab is hidden. Audio is still written. Forwarded video tracks are
+// kept so that no renegotiation is needed, and a keyframe is requested from
+// their publishers on resume.
+func (t *MemoryTracksManager) SetVideoPaused(clientID string, paused bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[clientID]
+	if !ok {
+		return fmt.Errorf("[%s] SetVideoPaused: peer not found", clientID)
+	}
+	if p.videoPaused == paused {
+		return nil
+	}
+
+	t.log.Printf("[%s] SetVideoPaused: %t", clientID, paused)
+	p.videoPaused = paused
+
+	// dispatched functions must not acquire mu
+	feedback := map[*webrtc.Track]RTCPWriter{}
+	if !paused {
+		for track, publisherID := range p.forwarded {
+			if publisher, ok := t.peers[publisherID]; ok && track.Kind() == webrtc.RTPCodecTypeVideo {
+				feedback[track] = publisher.trackListener.RTCPWriter(track)
+			}
+		}
+	}
+
+	trackListener := p.trackListener
+	t.fanOut.Dispatch(clientID, func() {
+		for _, track := range trackListener.SetVideoPaused(paused) {
+			writer, ok := feedback[track]
+			if !ok {
+				continue
+			}
+			err := writer.WriteRTCP([]rtcp.Packet{
+				&rtcp.PictureLossIndication{MediaSSRC: track.SSRC()},
+			})
+			if err != nil {
+				t.log.Printf("[%s] Error requesting keyframe of track: %s: %s", clientID, track.ID(), err)
+			}
+		}
+	})
+
+	if !paused {
+		// adds video tracks published while paused
+		t.reconcile(p.room)
+	}
+	return nil
+}
+
+// SetAuditLog sets the log security events of peer connections are written
+// to. It must be called before any peers are added.
+func (t *MemoryTracksManager) SetAuditLog(audit *AuditLog) {
+	t.audit = audit
+}
+
+// SetAudioSink streams the audio of speakers in rooms enabled in config to
+// sink, and sends the transcripts it publishes to the speaker's room. It
+// must be called before any peers are added.
+func (t *MemoryTracksManager) SetAudioSink(sink AudioSink, config TranscriptionConfig) {
+	t.Use(newAudioTranscription(t.log, sink, config, t.publishTranscript))
+}
+
+// publishTranscript sends transcript to all peers in the room of the
+// speaker, including the speaker.
+func (t *MemoryTracksManager) publishTranscript(transcript Transcript) {
+	data, err := json.Marshal(map[string]interface{}{
+		"type":   "transcription",
+		"userId": transcript.ClientID,
+		"payload": transcriptMessage{
+			TrackID:  transcript.TrackID,
+			Text:     transcript.Text,
+			Final:    transcript.Final,
+			Language: transcript.Language,
+		},
+	})
+	if err != nil {
+		t.log.Printf("[%s] publishTranscript error: %s", transcript.ClientID, err)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[transcript.ClientID]
+	if !ok {
+		return
+	}
+
+	for clientID := range t.peerIDsByRoom[p.room] {
+		if err := t.peers[clientID].dataTransceiver.SendText(string(data)); err != nil {
+			t.log.Printf("[%s] publishTranscript error: %s", clientID, err)
+		}
+	}
+}
+
+// SetWebhooks sets the webhooks track.published events are sent to. It must
+// be called before any peers are added.
+func (t *MemoryTracksManager) SetWebhooks(webhooks *Webhooks) {
+	t.webhooks = webhooks
+}
+
+// SetEventLog sets the log published and unpublished tracks are recorded
+// to. It must be called before any peers are added.
+func (t *MemoryTracksManager) SetEventLog(eventLog *EventLog) {
+	t.eventLog = eventLog
+}
+
+// SetTracer sets the tracer which measures adding published tracks to other
+// peers. It must be called before any peers are added.
+func (t *MemoryTracksManager) SetTracer(tracer *Tracer) {
+	t.tracer = tracer
+}
+
+// SetChatHistory sets the history text messages sent over data channels
+// are recorded in. It must be called before any peers are added.
+func (t *MemoryTracksManager) SetChatHistory(chatHistory *ChatHistory) {
+	t.chatHistory = chatHistory
+}
+
+// SetChatAllowed sets the function which decides whether data channel
+// messages are forwarded in room. All messages are forwarded when it is not
+// set. It must be called before any peers are added.
+func (t *MemoryTracksManager) SetChatAllowed(allowed func(room string) bool) {
+	t.chatAllowed = allowed
+}
+
+// ChatHistory returns the recorded chat messages of room, oldest first.
+func (t *MemoryTracksManager) ChatHistory(room string) []ChatMessage {
+	return t.chatHistory.Messages(room)
+}
+
+// SetMuted stops or resumes forwarding of audio tracks published by
+// clientID. The publisher keeps sending audio, so it cannot unmute itself.
+func (t *MemoryTracksManager) SetMuted(clientID string, muted bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[clientID]
+	if !ok {
+		return fmt.Errorf("[%s] SetMuted: peer not found", clientID)
+	}
+
+	t.log.Printf("[%s] SetMuted: %t", clientID, muted)
+	p.muted = muted
+
+	t.reconcile(p.room)
+	return nil
+}
+
+// SetMaxBitrate overrides the maximum bitrate of the room for tracks
+// published by clientID until its peer connection is closed. Zero restores
+// the maximum bitrate of the room. When the maximum bitrate is advertised,
+// the peer connection is renegotiated to update the SDP bandwidth lines.
+func (t *MemoryTracksManager) SetMaxBitrate(clientID string, maxBitrate int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.peers[clientID]
+	if !ok {
+		return fmt.Errorf("[%s] SetMaxBitrate: peer not found", clientID)
+	}
+
+	t.log.Printf("[%s] SetMaxBitrate: %d", clientID, maxBitrate)
+	t.bandwidth.SetPeerMaxBitrate(clientID, maxBitrate)
+
+	if t.sfuConfig.Bandwidth.Advertise {
+		p.signaller.SetMaxBitrate(t.bandwidth.MaxBitrate(p.room, clientID))
+		t.fanOut.Dispatch(clientID, p.signaller.Negotiate)
+	}
+	return nil
+}
+
 func (t *MemoryTracksManager) Add(
 	room string,
 	clientID string,
@@ -106,48 +1060,69 @@ func (t *MemoryTracksManager) Add(
 ) {
 	t.log.Printf("[%s] TrackManager.Add peer to room: %s", clientID, room)
 
+	e2ee := t.sfuConfig.IsE2EERoom(room)
+	t.audit.observeRoomE2EE(room, clientID, e2ee)
+	t.audit.observePeerConnection(room, clientID, peerConnection)
+
+	t.mu.Lock()
+	if previous, ok := t.peers[clientID]; ok {
+		// The same client reconnected before its previous peer connection was
+		// closed.
+		t.log.Printf("[%s] TrackManager.Add replacing previous peer", clientID)
+		t.deletePeer(clientID, previous)
+	}
+
 	trackListener := newTrackListener(
 		t.loggerFactory,
 		clientID,
+		room,
 		peerConnection,
+		signaller.peerConnectionLocker(),
+		t.interceptorFactories,
+		t.ssrcs,
+		t.forwarding,
+		t.captures,
+		e2ee,
+		t.sendQueueSize,
 	)
 
-	t.mu.Lock()
 	dataTransceiver := newDataTransceiver(t.loggerFactory, clientID, dataChannel, peerConnection)
-	peerJoiningRoom := peer{trackListener, dataTransceiver, room, signaller}
 
-	peersSet, ok := t.peerIDsByRoom[room]
-	if !ok {
-		peersSet = map[string]struct{}{}
-		t.peerIDsByRoom[room] = peersSet
+	peerRoom := room
+	if breakoutRoom, ok := t.breakoutRooms[clientID]; ok && mainRoom(breakoutRoom) == room {
+		// the client reconnected while in a breakout room
+		peerRoom = breakoutRoom
 	}
 
-	for existingPeerClientID := range peersSet {
-		existingPeerInRoom, ok := t.peers[existingPeerClientID]
-		if !ok {
-			t.log.Printf("[%s] Cannot find existing peer", existingPeerClientID)
-			continue
-		}
-		for _, track := range existingPeerInRoom.trackListener.Tracks() {
-			// TODO what if tracks list changes in the meantime?
-			err := addTrackToPeer(t.log, peerJoiningRoom, track)
-			if err != nil {
-				t.log.Printf(
-					"Error adding peer clientID: %s track to clientID: %s - reason: %s",
-					existingPeerClientID,
-					clientID,
-					err,
-				)
-			}
-		}
+	peerJoiningRoom := &peer{
+		trackListener:   trackListener,
+		dataTransceiver: dataTransceiver,
+		room:            peerRoom,
+		signaller:       signaller,
+		joinedAt:        time.Now(),
+		trackLanguages:  map[string]string{},
+		forwarded:       map[*webrtc.Track]string{},
+	}
+
+	peersSet, ok := t.peerIDsByRoom[peerRoom]
+	if !ok {
+		peersSet = map[string]struct{}{}
+		t.peerIDsByRoom[peerRoom] = peersSet
 	}
 
 	t.peers[clientID] = peerJoiningRoom
 	peersSet[clientID] = struct{}{}
+	t.floor.SetRoom(clientID, peerRoom)
+
+	t.reconcile(peerRoom)
 
 	messagesChannel := dataTransceiver.MessagesChannel()
 	go func() {
 		for msg := range messagesChannel {
+			if t.chatAllowed != nil && !t.chatAllowed(room) {
+				t.log.Printf("[%s] Dropping data channel message, chat is disabled in room: %s", clientID, room)
+				continue
+			}
 			t.broadcast(clientID, msg)
 		}
 	}()
@@ -166,67 +1141,129 @@ func (t *MemoryTracksManager) Add(
 
 	go func() {
 		<-signaller.CloseChannel()
-		t.removePeer(clientID)
+		t.removePeer(clientID, signaller)
 	}()
 
 	t.mu.Unlock()
 }
 
-func (t *MemoryTracksManager) removePeer(clientID string) {
+// HasPeer returns true when clientID has a peer connection.
+func (t *MemoryTracksManager) HasPeer(clientID string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	_, ok := t.peers[clientID]
+	return ok
+}
+
+// HasPeerInRoom returns true when clientID has a peer connection in room or
+// in one of its breakout rooms.
+func (t *MemoryTracksManager) HasPeerInRoom(room string, clientID string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	p, ok := t.peers[clientID]
+	return ok && mainRoom(p.room) == room
+}
+
+// RemovePeer closes the peer connection of clientID and stops forwarding its
+// tracks. Returns false when clientID has no peer connection.
+func (t *MemoryTracksManager) RemovePeer(clientID string) bool {
+	t.mu.RLock()
+	p, ok := t.peers[clientID]
+	t.mu.RUnlock()
+
+	if !ok {
+		return false
+	}
+
+	if err := p.signaller.Close(); err != nil {
+		t.log.Printf("[%s] RemovePeer: Error closing peer connection: %s", clientID, err)
+	}
+	t.removePeer(clientID, p.signaller)
+	return true
+}
+
+// removePeer removes the peer with clientID when its peer connection is
+// still the one signaled by signaller, so that a peer which has already
+// reconnected is not removed.
+func (t *MemoryTracksManager) removePeer(clientID string, signaller *Signaller) {
 	t.log.Printf("removePeer: %s", clientID)
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	peerLeavingRoom, ok := t.peers[clientID]
-	if !ok {
+	if !ok || peerLeavingRoom.signaller != signaller {
 		t.log.Printf("Cannot remove peer clientID: %s (not found)", clientID)
 		return
 	}
 
+	t.deletePeer(clientID, peerLeavingRoom)
+}
+
+// deletePeer must be called with t.mu held.
+func (t *MemoryTracksManager) deletePeer(clientID string, peerLeavingRoom *peer) {
+	if peerLeavingRoom.pin != nil {
+		peerLeavingRoom.pin.timer.Stop()
+	}
 	peerLeavingRoom.trackListener.Close()
 	peerLeavingRoom.dataTransceiver.Close()
 	t.removePeerTracks(peerLeavingRoom)
+	t.bandwidth.SetPeerMaxBitrate(clientID, 0)
 
 	delete(t.peers, clientID)
+	t.floor.Remove(clientID)
 	peerIDs, ok := t.peerIDsByRoom[peerLeavingRoom.room]
 	if !ok {
 		t.log.Printf("Cannot remove peer ID from room: %s (not found)", clientID)
 		return
 	}
 	delete(peerIDs, clientID)
+	t.activity.Remove(clientID)
+
+	if len(peerIDs) == 0 {
+		delete(t.peerIDsByRoom, peerLeavingRoom.room)
+		t.audit.forgetRoom(peerLeavingRoom.room)
+		return
+	}
+
+	t.reconcile(peerLeavingRoom.room)
 }
 
-func (t *MemoryTracksManager) removePeerTracks(peerLeavingRoom peer) {
+func (t *MemoryTracksManager) removePeerTracks(peerLeavingRoom *peer) {
 	leavingClientID := peerLeavingRoom.trackListener.ClientID()
 	t.log.Printf("Remove all peer tracks for clientID: %s", leavingClientID)
+	for _, track := range peerLeavingRoom.trackListener.Tracks() {
+		t.eventLog.Emit(EventLogEntry{
+			Type:     EventLogTrackRemove,
+			Room:     mainRoom(peerLeavingRoom.room),
+			ClientID: leavingClientID,
+			Details:  eventLogTrackDetails(track),
+		})
+	}
 	clientIDs, ok := t.peerIDsByRoom[peerLeavingRoom.room]
 	if !ok {
 		t.log.Println("Cannot find any peers in room", peerLeavingRoom.room)
 		return
 	}
 
-	tracks := peerLeavingRoom.trackListener.Tracks()
 	for clientID := range clientIDs {
 		if clientID != leavingClientID {
 			otherPeerInRoom := t.peers[clientID]
-			for _, track := range tracks {
+			var removed []*webrtc.Track
+			for track, publisherID := range otherPeerInRoom.forwarded {
+				if publisherID != leavingClientID {
+					continue
+				}
 				t.log.Printf(
 					"Removing track: %s from peer clientID: %s (source clientID: %s)",
 					track.ID(),
 					clientID,
 					leavingClientID,
 				)
-				err := otherPeerInRoom.trackListener.RemoveTrack(track)
-				if err != nil {
-					t.log.Printf(
-						"Error removing track: %s from peer clientID: %s (source clientID: %s): %s",
-						track.ID(),
-						clientID,
-						leavingClientID,
-						err,
-					)
-				}
+				delete(otherPeerInRoom.forwarded, track)
+				removed = append(removed, track)
 			}
-			otherPeerInRoom.signaller.Negotiate()
+			t.updateForwardedTracks(otherPeerInRoom, nil, removed)
 		}
 	}
 }
@@ -239,9 +1276,15 @@ func (t *MemoryTracksManager) removeTrack(clientID string, track *webrtc.Track)
 
 	peer, ok := t.peers[clientID]
 	if !ok {
-		t.log.Printf("[%s] removeTrack: Cannot find peer with clientID: %s", clientID)
+		t.log.Printf("[%s] removeTrack: Cannot find peer", clientID)
 		return
 	}
+	t.eventLog.Emit(EventLogEntry{
+		Type:     EventLogTrackRemove,
+		Room:     mainRoom(peer.room),
+		ClientID: clientID,
+		Details:  eventLogTrackDetails(track),
+	})
 	clientIDs, ok := t.peerIDsByRoom[peer.room]
 	if !ok {
 		t.log.Printf("[%s] removeTrack: Cannot find any peers in room: %s", clientID, peer.room)
@@ -250,11 +1293,13 @@ func (t *MemoryTracksManager) removeTrack(clientID string, track *webrtc.Track)
 	for otherClientID := range clientIDs {
 		if otherClientID != clientID {
 			otherPeerInRoom := t.peers[otherClientID]
-			err := otherPeerInRoom.trackListener.RemoveTrack(track)
-			if err != nil {
-				t.log.Printf("[%s] removeTrack error removing track: %s", clientID, err)
+			if _, ok := otherPeerInRoom.forwarded[track]; !ok {
+				continue
 			}
-			otherPeerInRoom.signaller.Negotiate()
+			delete(otherPeerInRoom.forwarded, track)
+			t.updateForwardedTracks(otherPeerInRoom, nil, []*webrtc.Track{track})
 		}
 	}
+
+	t.reconcile(peer.room)
 }
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server/logger"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTracksManager(sfuConfig NetworkConfigSFU) *MemoryTracksManager {
+	return NewMemoryTracksManager(logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout), sfuConfig)
+}
+
+// joinTestPeer adds a peer with an unconnected peer connection to tm.
+// Closing the returned signaller removes the peer.
+func joinTestPeer(t *testing.T, tm *MemoryTracksManager, room string, clientID string) *Signaller {
+	t.Helper()
+
+	mediaEngine := webrtc.MediaEngine{}
+	mediaEngine.RegisterDefaultCodecs()
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine))
+	pc, err := api.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+
+	signaller, err := NewSignaller(logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout), true, pc, &mediaEngine, localPeerID, clientID)
+	require.NoError(t, err)
+
+	tm.Add(room, clientID, pc, nil, signaller)
+	return signaller
+}
+
+// publishTestTrack adds a track to the tracks published by clientID, as if
+// it had been received from its peer connection. The ID of the track is the
+// local ID of remoteTrackID.
+func publishTestTrack(t *testing.T, tm *MemoryTracksManager, clientID string, kind webrtc.RTPCodecType, remoteTrackID string) *webrtc.Track {
+	t.Helper()
+
+	codec := webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000)
+	if kind == webrtc.RTPCodecTypeVideo {
+		codec = webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000)
+	}
+	track, err := webrtc.NewTrack(codec.PayloadType, rand.Uint32(), getLocalTrackID(remoteTrackID), "sfu_"+clientID, codec)
+	require.NoError(t, err)
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	p, ok := tm.peers[clientID]
+	require.True(t, ok, "peer not found: %s", clientID)
+	p.trackListener.mu.Lock()
+	p.trackListener.localTracks = append(p.trackListener.localTracks, track)
+	p.trackListener.mu.Unlock()
+	tm.reconcile(p.room)
+	return track
+}
+
+// forwardedTrackIDs returns the sorted IDs of tracks forwarded to clientID.
+func forwardedTrackIDs(tm *MemoryTracksManager, clientID string) []string {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	ids := []string{}
+	for track := range tm.peers[clientID].forwarded {
+		ids = append(ids, track.ID())
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// sentTrackIDs returns the sorted IDs of tracks added to the peer connection
+// of clientID.
+func sentTrackIDs(tm *MemoryTracksManager, clientID string) []string {
+	tm.mu.RLock()
+	p := tm.peers[clientID]
+	tm.mu.RUnlock()
+
+	p.trackListener.mu.RLock()
+	defer p.trackListener.mu.RUnlock()
+
+	ids := []string{}
+	for track := range p.trackListener.rtpSenderByTrack {
+		ids = append(ids, track.ID())
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// requireSent waits until exactly the tracks with remoteTrackIDs have been
+// forwarded to and added to the peer connection of clientID.
+func requireSent(t *testing.T, tm *MemoryTracksManager, clientID string, remoteTrackIDs ...string) {
+	t.Helper()
+
+	expected := []string{}
+	for _, id := range remoteTrackIDs {
+		expected = append(expected, getLocalTrackID(id))
+	}
+	sort.Strings(expected)
+
+	assert.Equal(t, expected, forwardedTrackIDs(tm, clientID))
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(expected, sentTrackIDs(tm, clientID))
+	}, time.Second, time.Millisecond, "expected tracks %v to be sent to %s, got: %v", expected, clientID, sentTrackIDs(tm, clientID))
+}
+
+func TestMatchesLanguageFilter(t *testing.T) {
+	languages := map[string]struct{}{"en": {}, "fr": {}}
+
+	for _, tc := range []struct {
+		name          string
+		filter        string
+		trackLanguage string
+		matches       bool
+	}{
+		{"floor without filter", "", "", true},
+		{"interpretation without filter", "", "en", false},
+		{"interpretation in filter language", "en", "en", true},
+		{"interpretation in other language", "en", "fr", false},
+		{"floor with available language", "en", "", false},
+		{"floor with unavailable language", "de", "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.matches, matchesLanguageFilter(tc.filter, tc.trackLanguage, languages))
+		})
+	}
+}
+
+func TestMemoryTracksManager_languageFilter(t *testing.T) {
+	tm := newTestTracksManager(NetworkConfigSFU{})
+	speaker := joinTestPeer(t, tm, "room", "speaker")
+	defer speaker.Close()
+	listener := joinTestPeer(t, tm, "room", "listener")
+	defer listener.Close()
+
+	publishTestTrack(t, tm, "speaker", webrtc.RTPCodecTypeAudio, "floor")
+	publishTestTrack(t, tm, "speaker", webrtc.RTPCodecTypeAudio, "interpretation")
+	requireSent(t, tm, "listener", "floor", "interpretation")
+
+	// interpretations are only forwarded to peers asking for their language
+	require.NoError(t, tm.SetTrackLanguage("speaker", "interpretation", "en"))
+	requireSent(t, tm, "listener", "floor")
+
+	require.NoError(t, tm.SetLanguageFilter("listener", "en"))
+	requireSent(t, tm, "listener", "interpretation")
+
+	// floor audio is forwarded when no interpretation in the language is
+	// available
+	require.NoError(t, tm.SetLanguageFilter("listener", "de"))
+	requireSent(t, tm, "listener", "floor")
+
+	require.NoError(t, tm.SetLanguageFilter("listener", "en"))
+	requireSent(t, tm, "listener", "interpretation")
+	require.NoError(t, tm.SetTrackLanguage("speaker", "interpretation", "fr"))
+	requireSent(t, tm, "listener", "floor")
+
+	require.NoError(t, tm.SetLanguageFilter("listener", ""))
+	require.NoError(t, tm.SetTrackLanguage("speaker", "interpretation", ""))
+	requireSent(t, tm, "listener", "floor", "interpretation")
+
+	assert.Error(t, tm.SetTrackLanguage("missing", "interpretation", "en"))
+	assert.Error(t, tm.SetLanguageFilter("missing", "en"))
+}
+
+func TestMemoryTracksManager_subscriptions(t *testing.T) {
+	tm := newTestTracksManager(NetworkConfigSFU{})
+	for _, clientID := range []string{"a", "b", "c"} {
+		signaller := joinTestPeer(t, tm, "room", clientID)
+		defer signaller.Close()
+		publishTestTrack(t, tm, clientID, webrtc.RTPCodecTypeAudio, "audio-"+clientID)
+	}
+	requireSent(t, tm, "a", "audio-b", "audio-c")
+
+	require.NoError(t, tm.Subscribe("a", []string{"b"}))
+	requireSent(t, tm, "a", "audio-b")
+
+	require.NoError(t, tm.Unsubscribe("a", []string{"b"}))
+	requireSent(t, tm, "a")
+
+	require.NoError(t, tm.Subscribe("a", []string{"b", "c"}))
+	requireSent(t, tm, "a", "audio-b", "audio-c")
+
+	require.NoError(t, tm.SubscribeAll("a"))
+	// unsubscribing without subscriptions keeps the other peers
+	require.NoError(t, tm.Unsubscribe("a", []string{"c"}))
+	requireSent(t, tm, "a", "audio-b")
+
+	require.NoError(t, tm.SubscribeAll("a"))
+	requireSent(t, tm, "a", "audio-b", "audio-c")
+	requireSent(t, tm, "b", "audio-a", "audio-c")
+
+	assert.Error(t, tm.Subscribe("missing", []string{"a"}))
+	assert.Error(t, tm.Unsubscribe("missing", []string{"a"}))
+	assert.Error(t, tm.SubscribeAll("missing"))
+}
+
+func TestMemoryTracksManager_SetAudioOnly(t *testing.T) {
+	tm := newTestTracksManager(NetworkConfigSFU{AudioOnlyRooms: []string{"quiet"}})
+	for _, room := range []string{"room", "quiet"} {
+		for _, clientID := range []string{"publisher", "subscriber"} {
+			signaller := joinTestPeer(t, tm, room, room+"-"+clientID)
+			defer signaller.Close()
+		}
+		publishTestTrack(t, tm, room+"-publisher", webrtc.RTPCodecTypeAudio, room+"-audio")
+		publishTestTrack(t, tm, room+"-publisher", webrtc.RTPCodecTypeVideo, room+"-video")
+	}
+	requireSent(t, tm, "room-subscriber", "room-audio", "room-video")
+
+	require.NoError(t, tm.SetAudioOnly("room-subscriber", true))
+	requireSent(t, tm, "room-subscriber", "room-audio")
+
+	require.NoError(t, tm.SetAudioOnly("room-subscriber", false))
+	requireSent(t, tm, "room-subscriber", "room-audio", "room-video")
+
+	// video is never forwarded in audio-only rooms
+	requireSent(t, tm, "quiet-subscriber", "quiet-audio")
+	require.NoError(t, tm.SetAudioOnly("quiet-subscriber", true))
+	require.NoError(t, tm.SetAudioOnly("quiet-subscriber", false))
+	requireSent(t, tm, "quiet-subscriber", "quiet-audio")
+
+	assert.Error(t, tm.SetAudioOnly("missing", true))
+}
+
+// TestMemoryTracksManager_snapshots reads the tracks of a peer while it
+// publishes and unpublishes tracks. It is meant to be run with the race
+// detector.
+func TestMemoryTracksManager_snapshots(t *testing.T) {
+	tm := newTestTracksManager(NetworkConfigSFU{})
+	publisher := joinTestPeer(t, tm, "room", "publisher")
+	defer publisher.Close()
+
+	tm.mu.RLock()
+	listener := tm.peers["publisher"].trackListener
+	tm.mu.RUnlock()
+
+	codec := webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000)
+	track, err := webrtc.NewTrack(codec.PayloadType, 1234, getLocalTrackID("video"), "sfu_publisher", codec)
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	var wg, started sync.WaitGroup
+	read := func(snapshot func()) {
+		wg.Add(1)
+		started.Add(1)
+		go func() {
+			defer wg.Done()
+			snapshot()
+			started.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					snapshot()
+				}
+			}
+		}()
+	}
+
+	read(func() {
+		assert.LessOrEqual(t, len(listener.Tracks()), 1)
+	})
+	read(func() {
+		for _, snapshot := range listener.Snapshot() {
+			assert.Equal(t, getLocalTrackID("video"), snapshot.TrackID)
+		}
+	})
+	read(func() {
+		for _, info := range tm.RoomTracks("room") {
+			assert.Equal(t, "publisher", info.ClientID)
+		}
+		assert.Len(t, tm.RoomPeers("room"), 1)
+	})
+
+	started.Wait()
+	for i := 0; i < 10000; i++ {
+		// like handleTrack
+		listener.mu.Lock()
+		listener.localTracks = append(listener.localTracks, track)
+		listener.mu.Unlock()
+		listener.SetTrackSource("video", TrackSourceScreen)
+		listener.removeLocalTrack(track)
+		listener.SetTrackSource("video", TrackSourceUnknown)
+	}
+	close(done)
+	wg.Wait()
+
+	assert.Empty(t, listener.Tracks())
+}
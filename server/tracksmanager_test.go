@@ -0,0 +1,370 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/pion/webrtc/v2"
+	"github.com/pion/webrtc/v2/pkg/media"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addTestPeer adds a peer with an unconnected peer connection to tracks.
+// Closing the returned signaller removes the peer.
+func addTestPeer(t testing.TB, tracks *server.MemoryTracksManager, room string, clientID string) *server.Signaller {
+	t.Helper()
+
+	mediaEngine := webrtc.MediaEngine{}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine))
+	pc, err := api.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+
+	signaller, err := server.NewSignaller(loggerFactory, true, pc, &mediaEngine, "__SERVER__", clientID)
+	require.NoError(t, err)
+
+	tracks.Add(room, clientID, pc, nil, signaller)
+	return signaller
+}
+
+func newTestServerTrack(t testing.TB) *webrtc.Track {
+	t.Helper()
+	codec := webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000)
+	track, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeOpus, rand.Uint32(), "server-track", "server", codec)
+	require.NoError(t, err)
+	return track
+}
+
+func TestMemoryTracksManager_reconnect(t *testing.T) {
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{})
+
+	first := addTestPeer(t, tracks, "room", "a")
+	second := addTestPeer(t, tracks, "room", "a")
+	defer second.Close()
+
+	// Closing the replaced peer connection must not remove the reconnected
+	// peer.
+	require.NoError(t, first.Close())
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, tracks.SetAudioOnly("a", true))
+}
+
+// testPublisher is a client peer connected to a MemoryTracksManager which
+// publishes an audio track.
+type testPublisher struct {
+	server *server.Signaller
+	client *server.Signaller
+	track  *webrtc.Track
+
+	stopRelay chan struct{}
+	relays    sync.WaitGroup
+}
+
+// relaySignals delivers signals of from to to until stop is closed.
+func relaySignals(from *server.Signaller, to *server.Signaller, stop <-chan struct{}) {
+	for {
+		select {
+		case payload := <-from.SignalChannel():
+			data, _ := json.Marshal(payload)
+			var signal map[string]interface{}
+			_ = json.Unmarshal(data, &signal)
+			_ = to.Signal(signal)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// connectTestPeer adds a peer to tracks whose peer connection is negotiated
+// with a client peer connection publishing an audio track.
+func connectTestPeer(t *testing.T, tracks *server.MemoryTracksManager, room string, clientID string) *testPublisher {
+	t.Helper()
+
+	serverMediaEngine := webrtc.MediaEngine{}
+	serverMediaEngine.RegisterDefaultCodecs()
+	serverPC, err := webrtc.NewAPI(webrtc.WithMediaEngine(serverMediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	serverSignaller, err := server.NewSignaller(loggerFactory, true, serverPC, &serverMediaEngine, "__SERVER__", clientID)
+	require.NoError(t, err)
+
+	clientMediaEngine := webrtc.MediaEngine{}
+	clientMediaEngine.RegisterDefaultCodecs()
+	clientPC, err := webrtc.NewAPI(webrtc.WithMediaEngine(clientMediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	codec := webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000)
+	track, err := clientPC.NewTrack(codec.PayloadType, rand.Uint32(), clientID+"-audio", clientID)
+	require.NoError(t, err)
+	_, err = clientPC.AddTrack(track)
+	require.NoError(t, err)
+	clientSignaller, err := server.NewSignaller(loggerFactory, false, clientPC, &clientMediaEngine, clientID, "__SERVER__")
+	require.NoError(t, err)
+
+	tracks.Add(room, clientID, serverPC, nil, serverSignaller)
+
+	p := &testPublisher{
+		server:    serverSignaller,
+		client:    clientSignaller,
+		track:     track,
+		stopRelay: make(chan struct{}),
+	}
+	p.relays.Add(2)
+	go func() {
+		defer p.relays.Done()
+		relaySignals(serverSignaller, clientSignaller, p.stopRelay)
+	}()
+	go func() {
+		defer p.relays.Done()
+		relaySignals(clientSignaller, serverSignaller, p.stopRelay)
+	}()
+	return p
+}
+
+// publish writes audio samples until stop is closed, so that the server
+// receives the published track.
+func (p *testPublisher) publish(stop <-chan struct{}) {
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = p.track.WriteSample(media.Sample{Data: []byte{0xfc, 0xff, 0xfe}, Samples: 960})
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Close stops relaying signals before closing the peer connections, because
+// pion does not support closing a peer connection while a description is
+// being set.
+func (p *testPublisher) Close() {
+	close(p.stopRelay)
+	p.relays.Wait()
+	p.client.Close()
+	p.server.Close()
+}
+
+// TestMemoryTracksManager_stress joins and leaves peers in several rooms
+// concurrently. Each peer connects and publishes an audio track, which is
+// forwarded to the other peers of its room, while server tracks are
+// published and subscriptions change. It is meant to be run with the race
+// detector.
+func TestMemoryTracksManager_stress(t *testing.T) {
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{LastN: 2})
+	rooms := []string{"room-a", "room-b", "room-c"}
+	const peers = 9
+	const iterations = 3
+
+	var wg sync.WaitGroup
+	for i := 0; i < peers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			room := rooms[i%len(rooms)]
+			clientID := fmt.Sprintf("peer-%d", i)
+
+			for j := 0; j < iterations; j++ {
+				publisher := connectTestPeer(t, tracks, room, clientID)
+				stop := make(chan struct{})
+				go publisher.publish(stop)
+
+				track := newTestServerTrack(t)
+				tracks.AddServerTrack(room, track)
+
+				assert.NoError(t, tracks.Subscribe(clientID, []string{fmt.Sprintf("peer-%d", (i+1)%peers)}))
+				assert.NoError(t, tracks.SetAudioOnly(clientID, j%2 == 0))
+				assert.Eventually(t, func() bool {
+					for _, info := range tracks.RoomTracks(room) {
+						if info.ClientID == clientID {
+							return true
+						}
+					}
+					return false
+				}, 10*time.Second, 10*time.Millisecond, "track of %s should be published", clientID)
+				assert.NoError(t, tracks.SubscribeAll(clientID))
+
+				tracks.RemoveServerTrack(room, track)
+				close(stop)
+				publisher.Close()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < peers; i++ {
+		clientID := fmt.Sprintf("peer-%d", i)
+		assert.Eventually(t, func() bool {
+			return tracks.SetAudioOnly(clientID, false) != nil
+		}, time.Second, 10*time.Millisecond, "peer %s should be removed", clientID)
+	}
+
+	assert.Eventually(t, func() bool {
+		for _, shard := range tracks.FanOutStats() {
+			if shard.QueueDepth > 0 {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond, "fan-out queues should drain")
+}
+
+// BenchmarkMemoryTracksManager_renegotiation measures reconciling tracks of
+// many rooms concurrently. Peer connection changes are applied
+// asynchronously by the fan-out workers and are not included.
+func BenchmarkMemoryTracksManager_renegotiation(b *testing.B) {
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{})
+	const rooms = 4
+	const peersPerRoom = 4
+
+	var signallers []*server.Signaller
+	for r := 0; r < rooms; r++ {
+		for p := 0; p < peersPerRoom; p++ {
+			room := fmt.Sprintf("room-%d", r)
+			clientID := fmt.Sprintf("peer-%d-%d", r, p)
+			signallers = append(signallers, addTestPeer(b, tracks, room, clientID))
+		}
+	}
+	defer func() {
+		for _, signaller := range signallers {
+			signaller.Close()
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		for r := 0; r < rooms; r++ {
+			wg.Add(1)
+			go func(room string) {
+				defer wg.Done()
+				track := newTestServerTrack(b)
+				tracks.AddServerTrack(room, track)
+				tracks.RemoveServerTrack(room, track)
+			}(fmt.Sprintf("room-%d", r))
+		}
+		wg.Wait()
+	}
+}
+
+func TestMemoryTracksManager_RemovePeer(t *testing.T) {
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{})
+	addTestPeer(t, tracks, "room", "a")
+
+	assert.True(t, tracks.HasPeer("a"))
+	assert.True(t, tracks.RemovePeer("a"))
+	assert.False(t, tracks.HasPeer("a"))
+	assert.False(t, tracks.RemovePeer("a"))
+}
+
+func TestMemoryTracksManager_RoomState(t *testing.T) {
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{})
+	signaller := addTestPeer(t, tracks, "room", "a")
+	defer signaller.Close()
+	track := newTestServerTrack(t)
+	tracks.AddServerTrack("room", track)
+	defer tracks.RemoveServerTrack("room", track)
+
+	assert.Equal(t, []string{"room"}, tracks.RoomNames())
+
+	peers := tracks.RoomPeers("room")
+	require.Len(t, peers, 1)
+	assert.Equal(t, "a", peers[0].ClientID)
+	assert.False(t, peers[0].JoinedAt.IsZero())
+	assert.NotNil(t, peers[0].SendQueue)
+	assert.NotNil(t, peers[0].TrackEvents)
+
+	assert.Equal(t, []server.TrackInfo{{
+		ClientID:    "__SERVER__",
+		TrackID:     track.ID(),
+		Kind:        "audio",
+		SSRC:        track.SSRC(),
+		Subscribers: 1,
+	}}, tracks.RoomTracks("room"))
+
+	assert.Empty(t, tracks.RoomPeers("other"))
+	assert.Empty(t, tracks.RoomTracks("other"))
+}
+
+func TestMemoryTracksManager_SetMuted(t *testing.T) {
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{})
+	signaller := addTestPeer(t, tracks, "room", "a")
+	defer signaller.Close()
+
+	require.NoError(t, tracks.SetMuted("a", true))
+	peers := tracks.RoomPeers("room")
+	require.Len(t, peers, 1)
+	assert.True(t, peers[0].Muted)
+
+	assert.Error(t, tracks.SetMuted("missing", true))
+}
+
+func TestMemoryTracksManager_SetPin(t *testing.T) {
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{LastN: 1})
+	a := addTestPeer(t, tracks, "room", "a")
+	defer a.Close()
+	b := addTestPeer(t, tracks, "room", "b")
+	defer b.Close()
+	c := addTestPeer(t, tracks, "other", "c")
+	defer c.Close()
+
+	assert.Error(t, tracks.SetPin("missing", "b", "video", time.Minute))
+	assert.Error(t, tracks.SetPin("a", "missing", "video", time.Minute))
+	assert.Error(t, tracks.SetPin("a", "c", "video", time.Minute), "publisher in other room")
+	assert.Error(t, tracks.SetPin("a", "b", "video", time.Minute), "track not published")
+	assert.NoError(t, tracks.SetPin("a", "", "", 0))
+}
+
+func TestMemoryTracksManager_SetVideoPaused(t *testing.T) {
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{})
+	signaller := addTestPeer(t, tracks, "room", "a")
+	defer signaller.Close()
+
+	require.NoError(t, tracks.SetVideoPaused("a", true))
+	peers := tracks.RoomPeers("room")
+	require.Len(t, peers, 1)
+	assert.True(t, peers[0].VideoPaused)
+
+	require.NoError(t, tracks.SetVideoPaused("a", false))
+	assert.False(t, tracks.RoomPeers("room")[0].VideoPaused)
+
+	assert.Error(t, tracks.SetVideoPaused("missing", true))
+}
+
+func TestMemoryTracksManager_breakoutRooms(t *testing.T) {
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{})
+	a := addTestPeer(t, tracks, "room", "a")
+	defer a.Close()
+	b := addTestPeer(t, tracks, "room", "b")
+	defer b.Close()
+	c := addTestPeer(t, tracks, "other", "c")
+	defer c.Close()
+
+	err := tracks.MoveToBreakoutRooms("room", map[string][]string{"x": {"a", "c"}})
+	assert.True(t, errors.Is(err, server.ErrPeerNotInRoom), "peer in other room")
+	err = tracks.MoveToBreakoutRooms("room", map[string][]string{"x/y": {"a"}})
+	assert.True(t, errors.Is(err, server.ErrInvalidBreakoutRoom))
+	assert.Len(t, tracks.RoomPeers("room"), 2, "no peers should be moved on error")
+
+	require.NoError(t, tracks.MoveToBreakoutRooms("room", map[string][]string{
+		"x": {"a"},
+		"y": {"b"},
+	}))
+	assert.Equal(t, []string{"other", "room/x", "room/y"}, tracks.RoomNames())
+
+	// reconnecting peers stay in their breakout room
+	reconnected := addTestPeer(t, tracks, "room", "a")
+	defer reconnected.Close()
+	require.Len(t, tracks.RoomPeers("room/x"), 1)
+	assert.Empty(t, tracks.RoomPeers("room"))
+
+	assert.Equal(t, []string{"a", "b"}, tracks.ReturnToMainRoom("room"))
+	assert.Equal(t, []string{"other", "room"}, tracks.RoomNames())
+	assert.Len(t, tracks.RoomPeers("room"), 2)
+	assert.Empty(t, tracks.ReturnToMainRoom("room"))
+}
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+)
+
+// trackWriterResumeWindow is the number of packets after a sender was
+// resumed during which packets sent before it was resumed, for example
+// replayed keyframes or retransmissions, are not written to it.
+const trackWriterResumeWindow = 1 << 14
+
+// trackWriter writes packets of a local track to a track of each of its
+// subscribers. The local track is shared by the publisher's interceptors,
+// while each subscriber is sent a track of its own with the same SSRC, so that
+// packets can be written to subscribers individually: sequence numbers and
+// timestamps are rewritten per subscriber, so that a subscriber whose video
+// was paused receives a continuous stream when it is resumed, instead of a
+// gap the size of the pause which decoders would detect as loss. The SSRC is
+// set to the one of the track, which differs from the publisher's when it was
+// remapped.
+//
+// Video of codecs whose keyframes can be detected is only written to a
+// subscriber starting with a keyframe, when it is added, started or resumed,
+// or after its send queue dropped packets, so that subscribers do not decode
+// a corrupted picture until the next one. Packets of screen shares are never
+// dropped by send queues, because their keyframes are large and rare.
+type trackWriter struct {
+	track     *webrtc.Track
+	ssrc      uint32
+	clockRate uint32
+	video     bool
+	// keyframeCodec is the codec of the track when senders wait for
+	// keyframes
+	keyframeCodec string
+	// requestKeyframe is called in a new goroutine when a sender starts
+	// waiting for a keyframe
+	requestKeyframe func()
+
+	mu sync.Mutex
+	// screen is true when the track is a screen share
+	screen  bool
+	streams map[*webrtc.RTPSender]*senderStream
+}
+
+// senderStream is the state of the stream sent to a single sender.
+type senderStream struct {
+	// track is the track of the subscriber the sender was created for
+	track *webrtc.Track
+	// paused is true while packets are not written to the sender
+	paused bool
+	// started is true once a packet was written to the sender
+	started bool
+	// waiting is true while packets are not written to the sender until the
+	// next keyframe
+	waiting bool
+	// active is true when the last packet was written to the sender
+	active    bool
+	seqOffset uint16
+	tsOffset  uint32
+	// lastSeq and lastTS are the rewritten sequence number and timestamp of
+	// the newest packet written to the sender
+	lastSeq   uint16
+	lastTS    uint32
+	lastWrite time.Time
+	// resumeSeq is the sequence number of the first packet written after
+	// the sender was resumed, when resumed is true
+	resumeSeq uint16
+	resumed   bool
+	// queue is the send queue of the subscriber, packets are written to the
+	// track directly when it is nil
+	queue *sendQueue
+	// dropped is set to 1 by the queue when it dropped packets of the
+	// stream, accessed atomically
+	dropped int32
+	// packet is reused for writing to the track, by the trackWriter when
+	// queue is nil and by the queue otherwise
+	packet rtp.Packet
+}
+
+// newTrackWriter creates a writer for track. When waitKeyframe is true,
+// senders of video tracks wait for a keyframe, and requestKeyframe is called
+// to ask the publisher for one.
+func newTrackWriter(track *webrtc.Track, waitKeyframe bool, requestKeyframe func()) *trackWriter {
+	w := &trackWriter{
+		track:           track,
+		ssrc:            track.SSRC(),
+		video:           track.Kind() == webrtc.RTPCodecTypeVideo,
+		requestKeyframe: requestKeyframe,
+		streams:         map[*webrtc.RTPSender]*senderStream{},
+	}
+	if codec := track.Codec(); codec != nil {
+		w.clockRate = codec.ClockRate
+		if waitKeyframe && w.video && canDetectKeyframes(codec.Name) {
+			w.keyframeCodec = codec.Name
+		}
+	}
+	return w
+}
+
+// newSubscriberTrack creates the track a subscriber is sent, with the same
+// SSRC, ID and label as the local track.
+func (w *trackWriter) newSubscriberTrack() (*webrtc.Track, error) {
+	return webrtc.NewTrack(w.track.PayloadType(), w.ssrc, w.track.ID(), w.track.Label(), w.track.Codec())
+}
+
+// WriteRTP writes packet to the tracks of all subscribers which are not
+// paused. Returns io.ErrClosedPipe when the track has no subscribers.
+// Packets of senders with a send queue are queued, errors writing them are
+// not returned.
+func (w *trackWriter) WriteRTP(packet *rtp.Packet) error {
+	now := time.Now()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.streams) == 0 {
+		return io.ErrClosedPipe
+	}
+
+	keyframe := false
+	if w.keyframeCodec != "" {
+		if media := mediaPacket(packet); media != nil {
+			keyframe = isKeyframeStart(w.keyframeCodec, media.Payload)
+		}
+	}
+	requestKeyframe := false
+
+	var firstErr error
+	for _, stream := range w.streams {
+		if stream.paused {
+			stream.active = false
+			continue
+		}
+
+		dropped := stream.takeDropped()
+		if w.keyframeCodec != "" && (!stream.started || !stream.active || dropped) && !stream.waiting {
+			// the sender was added, started or resumed, or packets were
+			// dropped
+			stream.waiting = true
+			requestKeyframe = requestKeyframe || !keyframe
+		}
+		if stream.waiting {
+			if !keyframe {
+				continue
+			}
+			stream.waiting = false
+		}
+
+		header, ok := stream.rewrite(packet.Header, now, w.clockRate, !stream.started)
+		if !ok {
+			continue
+		}
+		stream.started = true
+		header.SSRC = w.ssrc
+		if stream.queue != nil {
+			stream.queue.Push(sendJob{
+				header:    header,
+				payload:   packet.Payload,
+				droppable: w.droppable(keyframe),
+				stream:    stream,
+			})
+			continue
+		}
+		if err := stream.write(header, packet.Payload); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if requestKeyframe && w.requestKeyframe != nil {
+		go w.requestKeyframe()
+	}
+	return firstErr
+}
+
+// write writes a packet to the track of the stream. It must not be called
+// concurrently for the same stream.
+func (s *senderStream) write(header rtp.Header, payload []byte) error {
+	s.packet.Header = header
+	s.packet.Payload = payload
+	err := s.track.WriteRTP(&s.packet)
+	s.packet.Payload = nil
+	return err
+}
+
+// setScreen sets whether the track is a screen share.
+func (w *trackWriter) setScreen(screen bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.screen = screen
+}
+
+// droppable returns true when send queues may drop a packet. It must be
+// called with mu held.
+func (w *trackWriter) droppable(keyframe bool) bool {
+	return w.video && !w.screen && !keyframe
+}
+
+// rewrite returns header with the sequence number and timestamp of the
+// stream, or false when the packet must not be written to the sender.
+func (s *senderStream) rewrite(header rtp.Header, now time.Time, clockRate uint32, first bool) (rtp.Header, bool) {
+	switch {
+	case first:
+		s.lastSeq = header.SequenceNumber - 1
+		s.lastTS = header.Timestamp
+	case !s.active:
+		// continue from the last packet written before the sender was
+		// paused, advancing the timestamp by the time that passed
+		elapsed := uint32(now.Sub(s.lastWrite).Seconds() * float64(clockRate))
+		if elapsed == 0 {
+			elapsed = 1
+		}
+		s.seqOffset = s.lastSeq + 1 - header.SequenceNumber
+		s.tsOffset = s.lastTS + elapsed - header.Timestamp
+		s.resumeSeq = header.SequenceNumber
+		s.resumed = true
+	case s.resumed:
+		diff := int16(header.SequenceNumber - s.resumeSeq)
+		if diff < 0 {
+			return header, false
+		}
+		if diff > trackWriterResumeWindow {
+			s.resumed = false
+		}
+	}
+	s.active = true
+
+	header.SequenceNumber += s.seqOffset
+	header.Timestamp += s.tsOffset
+
+	if int16(header.SequenceNumber-s.lastSeq) > 0 {
+		s.lastSeq = header.SequenceNumber
+		s.lastTS = header.Timestamp
+	}
+	s.lastWrite = now
+	return header, true
+}
+
+// translateRTCP returns packets received from sender with the sequence
+// numbers of NACKs translated to those of the packets written by the
+// publisher.
+func (w *trackWriter) translateRTCP(sender *webrtc.RTPSender, packets []rtcp.Packet) []rtcp.Packet {
+	w.mu.Lock()
+	stream, ok := w.streams[sender]
+	var seqOffset uint16
+	if ok {
+		seqOffset = stream.seqOffset
+	}
+	w.mu.Unlock()
+
+	if seqOffset == 0 {
+		return packets
+	}
+
+	translated := make([]rtcp.Packet, len(packets))
+	for i, packet := range packets {
+		if nack, ok := packet.(*rtcp.TransportLayerNack); ok {
+			var seqs []uint16
+			for _, pair := range nack.Nacks {
+				for _, seq := range pair.PacketList() {
+					seqs = append(seqs, seq-seqOffset)
+				}
+			}
+			packet = &rtcp.TransportLayerNack{
+				SenderSSRC: nack.SenderSSRC,
+				MediaSSRC:  nack.MediaSSRC,
+				Nacks:      nackPairsFromSequenceNumbers(seqs),
+			}
+		}
+		translated[i] = packet
+	}
+	return translated
+}
+
+// add writes packets to track, which was added to sender. Packets are
+// queued to queue when it is not nil.
+func (w *trackWriter) add(sender *webrtc.RTPSender, track *webrtc.Track, queue *sendQueue) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.streams[sender] = &senderStream{track: track, queue: queue}
+}
+
+// started is called when RTCP is first read from sender. Senders drop the
+// packets written to them until they are started by a negotiation, so video
+// waits for the next keyframe again.
+func (w *trackWriter) started(sender *webrtc.RTPSender) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if stream, ok := w.streams[sender]; ok {
+		stream.setDropped()
+	}
+}
+
+// setPaused stops or resumes writing packets to sender. Returns false when
+// the sender is unknown.
+func (w *trackWriter) setPaused(sender *webrtc.RTPSender, paused bool) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stream, ok := w.streams[sender]
+	if ok {
+		stream.paused = paused
+	}
+	return ok
+}
+
+// subscribers returns the number of senders packets are written to.
+func (w *trackWriter) subscribers() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := 0
+	for _, stream := range w.streams {
+		if !stream.paused {
+			n++
+		}
+	}
+	return n
+}
+
+// remove forgets the stream of sender after it was removed from the track.
+func (w *trackWriter) remove(sender *webrtc.RTPSender) {
+	w.mu.Lock()
+	delete(w.streams, sender)
+	w.mu.Unlock()
+}
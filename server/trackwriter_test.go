@@ -0,0 +1,140 @@
+package server
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSenderStream_rewrite(t *testing.T) {
+	const clockRate = 90000
+	now := time.Unix(100, 0)
+	s := &senderStream{}
+
+	header, ok := s.rewrite(rtp.Header{SequenceNumber: 65535, Timestamp: 1000}, now, clockRate, true)
+	require.True(t, ok)
+	assert.Equal(t, uint16(65535), header.SequenceNumber, "first packets are written as they are")
+	assert.Equal(t, uint32(1000), header.Timestamp)
+
+	header, ok = s.rewrite(rtp.Header{SequenceNumber: 0, Timestamp: 4000}, now, clockRate, false)
+	require.True(t, ok)
+	assert.Equal(t, uint16(0), header.SequenceNumber)
+
+	// the sender is paused for a second while the publisher sends 100
+	// packets
+	s.active = false
+	now = now.Add(time.Second)
+	header, ok = s.rewrite(rtp.Header{SequenceNumber: 100, Timestamp: 94000}, now, clockRate, false)
+	require.True(t, ok)
+	assert.Equal(t, uint16(1), header.SequenceNumber)
+	assert.Equal(t, uint32(4000+clockRate), header.Timestamp)
+
+	header, ok = s.rewrite(rtp.Header{SequenceNumber: 101, Timestamp: 97000}, now, clockRate, false)
+	require.True(t, ok)
+	assert.Equal(t, uint16(2), header.SequenceNumber)
+	assert.Equal(t, uint32(7000+clockRate), header.Timestamp)
+
+	// packets sent while the sender was paused are not written to it
+	_, ok = s.rewrite(rtp.Header{SequenceNumber: 99, Timestamp: 91000}, now, clockRate, false)
+	assert.False(t, ok)
+
+	// retransmissions after the sender was resumed are
+	header, ok = s.rewrite(rtp.Header{SequenceNumber: 100, Timestamp: 94000}, now, clockRate, false)
+	require.True(t, ok)
+	assert.Equal(t, uint16(1), header.SequenceNumber)
+	assert.Equal(t, uint16(2), s.lastSeq, "older packets do not move the stream back")
+}
+
+func TestTrackWriter_translateRTCP(t *testing.T) {
+	track, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeVP8, 1234, "video", "video", webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000))
+	require.NoError(t, err)
+	w := newTrackWriter(track, false, nil)
+	sender := &webrtc.RTPSender{}
+
+	pli := &rtcp.PictureLossIndication{MediaSSRC: 1234}
+	nack := &rtcp.TransportLayerNack{
+		MediaSSRC: 1234,
+		Nacks:     []rtcp.NackPair{{PacketID: 5, LostPackets: 0x1}},
+	}
+	packets := []rtcp.Packet{pli, nack}
+	assert.Equal(t, packets, w.translateRTCP(sender, packets), "unknown senders have no offset")
+
+	w.add(sender, track, nil)
+	w.streams[sender].seqOffset = 65535
+	translated := w.translateRTCP(sender, packets)
+	require.Len(t, translated, 2)
+	assert.Equal(t, pli, translated[0])
+	assert.Equal(t, []uint16{6, 7}, translated[1].(*rtcp.TransportLayerNack).Nacks[0].PacketList())
+
+	w.remove(sender)
+	assert.Empty(t, w.streams)
+}
+
+func TestTrackWriter_droppable(t *testing.T) {
+	video, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeVP8, 1234, "video", "video", webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000))
+	require.NoError(t, err)
+	audio, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeOpus, 1235, "audio", "audio", webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000))
+	require.NoError(t, err)
+
+	w := newTrackWriter(video, true, nil)
+	assert.True(t, w.droppable(false))
+	assert.False(t, w.droppable(true), "keyframes are never dropped")
+
+	w.setScreen(true)
+	assert.False(t, w.droppable(false), "screen shares are never dropped")
+	w.setScreen(false)
+	assert.True(t, w.droppable(false))
+
+	assert.False(t, newTrackWriter(audio, true, nil).droppable(false), "audio is never dropped")
+}
+
+func TestTrackWriter_setPaused(t *testing.T) {
+	track, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeVP8, 1234, "video", "video", webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000))
+	require.NoError(t, err)
+	w := newTrackWriter(track, false, nil)
+
+	packet := &rtp.Packet{Header: rtp.Header{SequenceNumber: 10, Timestamp: 1000}}
+	assert.Equal(t, io.ErrClosedPipe, w.WriteRTP(packet), "no subscribers")
+
+	written := make(chan sendJob, 10)
+	queue := newTestSendQueue(t, 10, func(job sendJob) error {
+		written <- job
+		return nil
+	})
+	defer queue.Close()
+
+	subscriberTrack, err := w.newSubscriberTrack()
+	require.NoError(t, err)
+	assert.Equal(t, track.SSRC(), subscriberTrack.SSRC())
+	assert.Equal(t, track.ID(), subscriberTrack.ID())
+
+	sender := &webrtc.RTPSender{}
+	w.add(sender, subscriberTrack, queue)
+	assert.Equal(t, 1, w.subscribers())
+
+	require.NoError(t, w.WriteRTP(packet))
+	job := <-written
+	assert.Equal(t, subscriberTrack, job.stream.track)
+	assert.Equal(t, uint16(10), job.header.SequenceNumber)
+
+	assert.True(t, w.setPaused(sender, true))
+	assert.Equal(t, 0, w.subscribers())
+	packet.SequenceNumber = 11
+	require.NoError(t, w.WriteRTP(packet))
+
+	assert.True(t, w.setPaused(sender, false))
+	packet.SequenceNumber = 12
+	require.NoError(t, w.WriteRTP(packet))
+	job = <-written
+	assert.Equal(t, uint16(11), job.header.SequenceNumber, "the stream continues where it was paused")
+	assert.Empty(t, written)
+
+	w.remove(sender)
+	assert.False(t, w.setPaused(sender, true))
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+)
+
+// AudioSink receives the audio of speakers, for example to transcribe it.
+type AudioSink interface {
+	// OpenAudioStream is called once for each published audio track. publish
+	// may be called from any goroutine until the stream is closed, and some
+	// time after, so that results of the last words are not lost.
+	OpenAudioStream(info AudioStreamInfo, publish func(Transcript)) (AudioStream, error)
+}
+
+// AudioStreamInfo describes the audio track of a speaker.
+type AudioStreamInfo struct {
+	Room      string
+	ClientID  string
+	TrackID   string
+	Codec     string
+	ClockRate uint32
+}
+
+// AudioStream receives the audio packets of a single track.
+type AudioStream interface {
+	// WriteAudio is called for every packet received from the speaker. It
+	// must not block, since it delays forwarding of the packet.
+	WriteAudio(payload []byte, timestamp uint32) error
+	// Close is called after the track has ended.
+	Close() error
+}
+
+// Transcript is a text recognized in the speech of a speaker.
+type Transcript struct {
+	ClientID string
+	TrackID  string
+	Text     string
+	// Final is false for interim results, which are replaced by later ones.
+	Final    bool
+	Language string
+}
+
+// transcriptMessage is the payload of transcription data channel messages.
+type transcriptMessage struct {
+	TrackID  string `json:"trackId"`
+	Text     string `json:"text"`
+	Final    bool   `json:"final"`
+	Language string `json:"language,omitempty"`
+}
+
+// audioTranscription taps the audio of speakers in configured rooms and
+// writes it to an AudioSink.
+type audioTranscription struct {
+	log     Logger
+	sink    AudioSink
+	config  TranscriptionConfig
+	publish func(Transcript)
+}
+
+var _ InterceptorFactory = &audioTranscription{}
+
+func newAudioTranscription(log Logger, sink AudioSink, config TranscriptionConfig, publish func(Transcript)) *audioTranscription {
+	return &audioTranscription{
+		log:     log,
+		sink:    sink,
+		config:  config,
+		publish: publish,
+	}
+}
+
+// NewInterceptor taps audio tracks which are not encrypted end-to-end.
+func (a *audioTranscription) NewInterceptor(params InterceptorParams) (Interceptor, error) {
+	if params.Encrypted || params.LocalTrack.Kind() != webrtc.RTPCodecTypeAudio || !a.config.IsTranscriptionRoom(params.Room) {
+		return NoOpInterceptor{}, nil
+	}
+
+	tap := &audioTranscriptionTap{
+		log: a.log.WithCtx(LogCtx{"clientID": params.ClientID, "trackID": params.LocalTrack.ID()}),
+	}
+
+	codec := params.LocalTrack.Codec()
+	info := AudioStreamInfo{
+		Room:      params.Room,
+		ClientID:  params.ClientID,
+		TrackID:   params.LocalTrack.ID(),
+		Codec:     codec.Name,
+		ClockRate: codec.ClockRate,
+	}
+
+	// opening the stream might wait for the connection to the service, so
+	// packets received in the meantime are not transcribed.
+	go tap.open(a.sink, info, a.publish)
+
+	return tap, nil
+}
+
+type audioTranscriptionTap struct {
+	NoOpInterceptor
+
+	log Logger
+
+	mu     sync.Mutex
+	stream AudioStream
+	closed bool
+}
+
+func (a *audioTranscriptionTap) open(sink AudioSink, info AudioStreamInfo, publish func(Transcript)) {
+	stream, err := sink.OpenAudioStream(info, publish)
+	if err != nil {
+		a.log.Printf("Error opening audio stream: %s", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		_ = stream.Close()
+		return
+	}
+	a.stream = stream
+}
+
+func (a *audioTranscriptionTap) BindRTP(next RTPWriter) RTPWriter {
+	return RTPWriterFunc(func(packet *rtp.Packet) error {
+		if media := mediaPacket(packet); media != nil {
+			a.mu.Lock()
+			stream := a.stream
+			a.mu.Unlock()
+
+			if stream != nil {
+				if err := stream.WriteAudio(media.Payload, media.Timestamp); err != nil {
+					a.log.Debugf("Error writing audio: %s", err)
+				}
+			}
+		}
+		return next.WriteRTP(packet)
+	})
+}
+
+func (a *audioTranscriptionTap) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.closed = true
+	if a.stream == nil {
+		return nil
+	}
+	return a.stream.Close()
+}
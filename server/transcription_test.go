@@ -0,0 +1,150 @@
+package server
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server/logger"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type testTranscriptionServer struct {
+	auth   chan string
+	starts chan *TranscribeStart
+}
+
+func (s *testTranscriptionServer) Transcribe(stream TranscribeServerStream) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	if values := md.Get("authorization"); len(values) > 0 {
+		s.auth <- values[0]
+	}
+
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	s.starts <- req.Start
+
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			// the client closed the stream
+			return stream.Send(&TranscribeResponse{Text: "bye", Final: true})
+		}
+		err = stream.Send(&TranscribeResponse{Text: string(req.Audio), Language: "en"})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func TestAudioTranscription(t *testing.T) {
+	loggerFactory := logger.NewFactoryFromEnv("PEERCALLS_", os.Stdout)
+
+	srv := &testTranscriptionServer{
+		auth:   make(chan string, 1),
+		starts: make(chan *TranscribeStart, 1),
+	}
+	rpc := grpc.NewServer()
+	RegisterTranscriptionServiceServer(rpc, srv)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go rpc.Serve(l)
+	defer rpc.Stop()
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	transcripts := make(chan Transcript, 10)
+	transcription := newAudioTranscription(
+		loggerFactory.GetLogger("transcription"),
+		NewGRPCTranscriber(loggerFactory, conn, "secret"),
+		TranscriptionConfig{Rooms: []string{"room"}},
+		func(transcript Transcript) {
+			transcripts <- transcript
+		},
+	)
+
+	audio, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeOpus, 1, "audio", "stream", webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000))
+	require.NoError(t, err)
+	video, err := webrtc.NewTrack(webrtc.DefaultPayloadTypeVP8, 2, "video", "stream", webrtc.NewRTPVP8Codec(webrtc.DefaultPayloadTypeVP8, 90000))
+	require.NoError(t, err)
+
+	for _, params := range []InterceptorParams{
+		{ClientID: "a", Room: "room", LocalTrack: video},
+		{ClientID: "a", Room: "other", LocalTrack: audio},
+		{ClientID: "a", Room: "room", LocalTrack: audio, Encrypted: true},
+	} {
+		interceptor, err := transcription.NewInterceptor(params)
+		require.NoError(t, err)
+		assert.Equal(t, NoOpInterceptor{}, interceptor)
+	}
+
+	interceptor, err := transcription.NewInterceptor(InterceptorParams{
+		ClientID:   "a",
+		Room:       "room",
+		LocalTrack: audio,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "Bearer secret", <-srv.auth)
+	assert.Equal(t, &TranscribeStart{
+		Room:      "room",
+		ClientID:  "a",
+		TrackID:   "audio",
+		Codec:     webrtc.Opus,
+		ClockRate: 48000,
+	}, <-srv.starts)
+
+	writer := interceptor.BindRTP(RTPWriterFunc(func(packet *rtp.Packet) error {
+		return nil
+	}))
+
+	receive := func() Transcript {
+		t.Helper()
+		select {
+		case transcript := <-transcripts:
+			return transcript
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for transcript")
+			return Transcript{}
+		}
+	}
+
+	// the stream is opened asynchronously, so packets written before it is
+	// ready are not transcribed
+	for i := 0; ; i++ {
+		require.Less(t, i, 100, "stream was not opened")
+		require.NoError(t, writer.WriteRTP(&rtp.Packet{
+			Header: rtp.Header{
+				PayloadType: webrtc.DefaultPayloadTypeOpus,
+			},
+			Payload: []byte("hello"),
+		}))
+		select {
+		case transcript := <-transcripts:
+			assert.Equal(t, Transcript{
+				ClientID: "a",
+				TrackID:  "audio",
+				Text:     "hello",
+				Language: "en",
+			}, transcript)
+		case <-time.After(10 * time.Millisecond):
+			continue
+		}
+		break
+	}
+
+	require.NoError(t, interceptor.Close())
+
+	for transcript := receive(); transcript.Text != "bye"; transcript = receive() {
+	}
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Messages of the transcription gRPC API defined in transcription.proto.
+
+type TranscribeRequest struct {
+	Start     *TranscribeStart `protobuf:"bytes,1,opt,name=start,proto3" json:"start,omitempty"`
+	Audio     []byte           `protobuf:"bytes,2,opt,name=audio,proto3" json:"audio,omitempty"`
+	Timestamp uint32           `protobuf:"varint,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+}
+
+type TranscribeStart struct {
+	Room      string `protobuf:"bytes,1,opt,name=room,proto3" json:"room,omitempty"`
+	ClientID  string `protobuf:"bytes,2,opt,name=client_id,json=clientId,proto3" json:"client_id,omitempty"`
+	TrackID   string `protobuf:"bytes,3,opt,name=track_id,json=trackId,proto3" json:"track_id,omitempty"`
+	Codec     string `protobuf:"bytes,4,opt,name=codec,proto3" json:"codec,omitempty"`
+	ClockRate uint32 `protobuf:"varint,5,opt,name=clock_rate,json=clockRate,proto3" json:"clock_rate,omitempty"`
+}
+
+type TranscribeResponse struct {
+	Text     string `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
+	Final    bool   `protobuf:"varint,2,opt,name=final,proto3" json:"final,omitempty"`
+	Language string `protobuf:"bytes,3,opt,name=language,proto3" json:"language,omitempty"`
+}
+
+func (m *TranscribeRequest) Reset()         { *m = TranscribeRequest{} }
+func (m *TranscribeRequest) String() string { return proto.CompactTextString(m) }
+func (*TranscribeRequest) ProtoMessage()    {}
+
+func (m *TranscribeStart) Reset()         { *m = TranscribeStart{} }
+func (m *TranscribeStart) String() string { return proto.CompactTextString(m) }
+func (*TranscribeStart) ProtoMessage()    {}
+
+func (m *TranscribeResponse) Reset()         { *m = TranscribeResponse{} }
+func (m *TranscribeResponse) String() string { return proto.CompactTextString(m) }
+func (*TranscribeResponse) ProtoMessage()    {}
+
+const transcriptionServiceName = "peercalls.TranscriptionService"
+
+// TranscriptionServiceServer is implemented by transcription services
+// written in Go.
+type TranscriptionServiceServer interface {
+	Transcribe(stream TranscribeServerStream) error
+}
+
+// TranscribeServerStream is the server side of a Transcribe call.
+type TranscribeServerStream interface {
+	Send(*TranscribeResponse) error
+	Recv() (*TranscribeRequest, error)
+	grpc.ServerStream
+}
+
+type transcribeServerStream struct {
+	grpc.ServerStream
+}
+
+func (s *transcribeServerStream) Send(res *TranscribeResponse) error {
+	return s.ServerStream.SendMsg(res)
+}
+
+func (s *transcribeServerStream) Recv() (*TranscribeRequest, error) {
+	req := new(TranscribeRequest)
+	if err := s.ServerStream.RecvMsg(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// RegisterTranscriptionServiceServer registers srv as the transcription
+// service of s.
+func RegisterTranscriptionServiceServer(s *grpc.Server, srv TranscriptionServiceServer) {
+	s.RegisterService(&transcriptionServiceDesc, srv)
+}
+
+var transcribeStreamDesc = grpc.StreamDesc{
+	StreamName: "Transcribe",
+	Handler: func(srv interface{}, stream grpc.ServerStream) error {
+		return srv.(TranscriptionServiceServer).Transcribe(&transcribeServerStream{stream})
+	},
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+var transcriptionServiceDesc = grpc.ServiceDesc{
+	ServiceName: transcriptionServiceName,
+	HandlerType: (*TranscriptionServiceServer)(nil),
+	Streams:     []grpc.StreamDesc{transcribeStreamDesc},
+	Metadata:    "transcription.proto",
+}
+
+const (
+	// grpcTranscriberQueueSize is the number of packets queued for each
+	// stream. Packets are dropped while it is full.
+	grpcTranscriberQueueSize = 256
+	// grpcTranscriberCloseTimeout is how long transcripts are received after
+	// a stream was closed.
+	grpcTranscriberCloseTimeout = 10 * time.Second
+)
+
+// GRPCTranscriber is an AudioSink which streams audio to a transcription
+// service over the transcription gRPC API.
+type GRPCTranscriber struct {
+	log    Logger
+	cc     *grpc.ClientConn
+	secret string
+}
+
+var _ AudioSink = &GRPCTranscriber{}
+
+// NewGRPCTranscriber creates an AudioSink using cc. When secret is set, it
+// is sent in the authorization metadata as a bearer token.
+func NewGRPCTranscriber(loggerFactory LoggerFactory, cc *grpc.ClientConn, secret string) *GRPCTranscriber {
+	return &GRPCTranscriber{
+		log:    loggerFactory.GetLogger("transcription"),
+		cc:     cc,
+		secret: secret,
+	}
+}
+
+func (g *GRPCTranscriber) OpenAudioStream(info AudioStreamInfo, publish func(Transcript)) (AudioStream, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if g.secret != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+g.secret)
+	}
+
+	stream, err := g.cc.NewStream(ctx, &transcribeStreamDesc, "/"+transcriptionServiceName+"/Transcribe")
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	err = stream.SendMsg(&TranscribeRequest{
+		Start: &TranscribeStart{
+			Room:      info.Room,
+			ClientID:  info.ClientID,
+			TrackID:   info.TrackID,
+			Codec:     info.Codec,
+			ClockRate: info.ClockRate,
+		},
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	s := &grpcAudioStream{
+		log:          g.log.WithCtx(LogCtx{"clientID": info.ClientID, "trackID": info.TrackID}),
+		stream:       stream,
+		cancel:       cancel,
+		requests:     make(chan *TranscribeRequest, grpcTranscriberQueueSize),
+		closeChannel: make(chan struct{}),
+	}
+
+	go s.send()
+	go s.receive(info, publish)
+
+	return s, nil
+}
+
+type grpcAudioStream struct {
+	log    Logger
+	stream grpc.ClientStream
+	cancel context.CancelFunc
+
+	requests     chan *TranscribeRequest
+	closeChannel chan struct{}
+	closeOnce    sync.Once
+}
+
+func (s *grpcAudioStream) WriteAudio(payload []byte, timestamp uint32) error {
+	select {
+	case <-s.closeChannel:
+		return io.ErrClosedPipe
+	default:
+	}
+
+	select {
+	case s.requests <- &TranscribeRequest{Audio: payload, Timestamp: timestamp}:
+	default:
+		// the service cannot keep up, a gap in the audio is better than
+		// delaying forwarding
+	}
+	return nil
+}
+
+func (s *grpcAudioStream) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closeChannel)
+		time.AfterFunc(grpcTranscriberCloseTimeout, s.cancel)
+	})
+	return nil
+}
+
+func (s *grpcAudioStream) send() {
+	for {
+		select {
+		case req := <-s.requests:
+			if err := s.stream.SendMsg(req); err != nil {
+				// the error is returned by RecvMsg
+				return
+			}
+		case <-s.closeChannel:
+			if err := s.stream.CloseSend(); err != nil {
+				s.log.Printf("Error closing transcription stream: %s", err)
+			}
+			return
+		}
+	}
+}
+
+func (s *grpcAudioStream) receive(info AudioStreamInfo, publish func(Transcript)) {
+	defer s.cancel()
+
+	for {
+		res := new(TranscribeResponse)
+		if err := s.stream.RecvMsg(res); err != nil {
+			if err != io.EOF {
+				s.log.Printf("Error receiving transcripts: %s", err)
+			}
+			return
+		}
+
+		publish(Transcript{
+			ClientID: info.ClientID,
+			TrackID:  info.TrackID,
+			Text:     res.Text,
+			Final:    res.Final,
+			Language: res.Language,
+		})
+	}
+}
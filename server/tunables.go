@@ -0,0 +1,134 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+const (
+	minNACKBufferSize = 16
+	maxNACKBufferSize = 32768
+)
+
+var ErrInvalidRuntimeConfig = errors.New("invalid runtime config")
+
+// RuntimeTunables applies a RuntimeConfig to a running server, so that
+// operators can tune a loaded node without a restart. A nil RuntimeTunables
+// cannot be changed.
+type RuntimeTunables struct {
+	log               Logger
+	tracks            *MemoryTracksManager
+	defaultGOMAXPROCS int
+
+	mu     sync.Mutex
+	config RuntimeConfig
+}
+
+// NewRuntimeTunables creates RuntimeTunables changing the settings of
+// tracks and of the Go runtime.
+func NewRuntimeTunables(loggerFactory LoggerFactory, tracks *MemoryTracksManager) *RuntimeTunables {
+	t := &RuntimeTunables{
+		log:               loggerFactory.GetLogger("runtime"),
+		tracks:            tracks,
+		defaultGOMAXPROCS: runtime.GOMAXPROCS(0),
+	}
+	t.config = t.withDefaults(RuntimeConfig{})
+	return t
+}
+
+// Config returns the config in use, with the defaults of values which have
+// not been set.
+func (t *RuntimeTunables) Config() RuntimeConfig {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.config
+}
+
+// Set applies config, in which zero values restore the defaults. Returns
+// ErrInvalidRuntimeConfig when a value is out of range, in which case
+// nothing is changed.
+func (t *RuntimeTunables) Set(config RuntimeConfig) error {
+	if t == nil {
+		return fmt.Errorf("%w: runtime tunables are not available", ErrInvalidRuntimeConfig)
+	}
+
+	config = t.withDefaults(config)
+	if err := validateRuntimeConfig(config); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	runtime.GOMAXPROCS(config.GOMAXPROCS)
+	t.tracks.setRuntime(config)
+
+	if config != t.config {
+		t.log.Printf("Runtime config changed: %+v", config)
+	}
+	t.config = config
+	return nil
+}
+
+func (t *RuntimeTunables) withDefaults(config RuntimeConfig) RuntimeConfig {
+	if config.GOMAXPROCS == 0 {
+		config.GOMAXPROCS = t.defaultGOMAXPROCS
+	}
+	if config.SendQueueSize == 0 {
+		config.SendQueueSize = defaultSendQueueSize
+	}
+	if config.NACKBufferSize == 0 {
+		config.NACKBufferSize = defaultNACKBufferSize
+	}
+	if config.PLIInterval == 0 {
+		config.PLIInterval = rtcpPLIInterval
+	}
+	if config.PLIMinInterval == 0 {
+		config.PLIMinInterval = rtcpPLIMinInterval
+	}
+	if config.PLIMinIntervalScreen == 0 {
+		config.PLIMinIntervalScreen = rtcpPLIMinIntervalScreen
+	}
+	return config
+}
+
+func validateRuntimeConfig(config RuntimeConfig) error {
+	switch {
+	case config.GOMAXPROCS < 0:
+		return fmt.Errorf("%w: gomaxprocs must not be negative", ErrInvalidRuntimeConfig)
+	case config.SendQueueSize < 0:
+		return fmt.Errorf("%w: send queue size must not be negative", ErrInvalidRuntimeConfig)
+	case config.NACKBufferSize < minNACKBufferSize || config.NACKBufferSize > maxNACKBufferSize ||
+		config.NACKBufferSize&(config.NACKBufferSize-1) != 0:
+		return fmt.Errorf("%w: nack buffer size must be a power of two between %d and %d", ErrInvalidRuntimeConfig, minNACKBufferSize, maxNACKBufferSize)
+	case config.PLIInterval < 0 || config.PLIMinInterval < 0 || config.PLIMinIntervalScreen < 0:
+		return fmt.Errorf("%w: pli intervals must not be negative", ErrInvalidRuntimeConfig)
+	case config.PLIMinInterval > config.PLIInterval || config.PLIMinIntervalScreen > config.PLIInterval:
+		return fmt.Errorf("%w: pli minimum intervals must not exceed the pli interval", ErrInvalidRuntimeConfig)
+	}
+	return nil
+}
+
+// setRuntime changes the send queue size of peers connecting afterwards, the
+// NACK buffer size of tracks published afterwards and the keyframe request
+// intervals of all tracks.
+func (t *MemoryTracksManager) setRuntime(config RuntimeConfig) {
+	t.mu.Lock()
+	t.sendQueueSize = config.SendQueueSize
+	t.mu.Unlock()
+
+	t.nack.setBufferSize(config.NACKBufferSize)
+	t.keyframes.SetIntervals(config.PLIInterval, config.PLIMinInterval, config.PLIMinIntervalScreen)
+}
+
+// SetRuntimeTunables sets the RuntimeTunables changed through the admin API.
+func (wss *WSS) SetRuntimeTunables(tunables *RuntimeTunables) {
+	wss.tunables = tunables
+}
+
+// RuntimeTunables returns the RuntimeTunables set with SetRuntimeTunables.
+func (wss *WSS) RuntimeTunables() *RuntimeTunables {
+	return wss.tunables
+}
@@ -0,0 +1,123 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuntimeTunables(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{})
+	tunables := server.NewRuntimeTunables(loggerFactory, tracks)
+
+	defaults := server.RuntimeConfig{
+		GOMAXPROCS:           runtime.GOMAXPROCS(0),
+		SendQueueSize:        256,
+		NACKBufferSize:       512,
+		PLIInterval:          3 * time.Second,
+		PLIMinInterval:       500 * time.Millisecond,
+		PLIMinIntervalScreen: 100 * time.Millisecond,
+	}
+	assert.Equal(t, defaults, tunables.Config())
+
+	config := server.RuntimeConfig{
+		GOMAXPROCS:           1,
+		SendQueueSize:        64,
+		NACKBufferSize:       2048,
+		PLIInterval:          time.Second,
+		PLIMinInterval:       200 * time.Millisecond,
+		PLIMinIntervalScreen: 50 * time.Millisecond,
+	}
+	require.NoError(t, tunables.Set(config))
+	assert.Equal(t, config, tunables.Config())
+	assert.Equal(t, 1, runtime.GOMAXPROCS(0))
+
+	for _, invalid := range []server.RuntimeConfig{
+		{GOMAXPROCS: -1},
+		{SendQueueSize: -1},
+		{NACKBufferSize: 1000},
+		{NACKBufferSize: 8},
+		{NACKBufferSize: 65536},
+		{PLIInterval: -time.Second},
+		{PLIInterval: time.Second, PLIMinInterval: 2 * time.Second},
+	} {
+		err := tunables.Set(invalid)
+		assert.True(t, errors.Is(err, server.ErrInvalidRuntimeConfig), "invalid: %+v", invalid)
+	}
+	assert.Equal(t, config, tunables.Config(), "unchanged")
+
+	require.NoError(t, tunables.Set(server.RuntimeConfig{}))
+	assert.Equal(t, defaults, tunables.Config(), "defaults restored")
+}
+
+func TestAdmin_runtime(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	admin := server.AdminConfig{Token: adminToken, Pprof: true}
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, admin, server.InactivityConfig{}, server.ClientConfig{}, rooms, newMockTracksManager())
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	setRuntime := func(body string) (int, server.AdminRuntime) {
+		req, err := http.NewRequest("PUT", s.URL+"/api/admin/runtime", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+adminToken)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		var result server.AdminRuntime
+		json.NewDecoder(res.Body).Decode(&result)
+		return res.StatusCode, result
+	}
+
+	var result server.AdminRuntime
+	statusCode := adminGetJSON(t, s.URL+"/api/admin/runtime", &result)
+	assert.Equal(t, http.StatusNotFound, statusCode, "not available")
+
+	tracks := server.NewMemoryTracksManager(loggerFactory, server.NetworkConfigSFU{})
+	mux.WSS.SetRuntimeTunables(server.NewRuntimeTunables(loggerFactory, tracks))
+
+	statusCode = adminGetJSON(t, s.URL+"/api/admin/runtime", &result)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, server.AdminRuntime{
+		GOMAXPROCS:           runtime.GOMAXPROCS(0),
+		SendQueueSize:        256,
+		NACKBufferSize:       512,
+		PLIInterval:          3000,
+		PLIMinInterval:       500,
+		PLIMinIntervalScreen: 100,
+	}, result)
+
+	statusCode, result = setRuntime(`{"nackBufferSize":1024,"pliInterval":2000}`)
+	assert.Equal(t, http.StatusOK, statusCode)
+	assert.Equal(t, 1024, result.NACKBufferSize)
+	assert.Equal(t, int64(2000), result.PLIInterval)
+	assert.Equal(t, 256, result.SendQueueSize, "missing fields are kept")
+
+	statusCode, _ = setRuntime(`{"nackBufferSize":1000}`)
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+	statusCode, _ = setRuntime(`invalid`)
+	assert.Equal(t, http.StatusBadRequest, statusCode)
+
+	req, err := http.NewRequest("GET", s.URL+"/api/admin/debug/pprof/goroutine?debug=1", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
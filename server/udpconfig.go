@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+
+	"github.com/pion/webrtc/v2"
+)
+
+// ValidateSFUUDPConfig returns an error when c is not a valid range of local
+// ports for peer connections to the SFU. Each peer connection listens on its
+// own port, so a range of a single port, with which only one peer could
+// connect at a time, is rejected.
+func ValidateSFUUDPConfig(c UDPConfig) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if c.PortMin != 0 && c.PortMin == c.PortMax {
+		return fmt.Errorf("UDP port range has a single port: %d, but each peer needs a port of its own", c.PortMin)
+	}
+	return nil
+}
+
+// validate returns an error when the range is invalid. A range where both
+// ports are zero is valid and allows any port.
+func (c UDPConfig) validate() error {
+	if c.PortMin == 0 && c.PortMax == 0 {
+		return nil
+	}
+	if c.PortMin <= 0 || c.PortMax > 0xFFFF || c.PortMin > c.PortMax {
+		return fmt.Errorf("invalid UDP port range: %d-%d", c.PortMin, c.PortMax)
+	}
+	return nil
+}
+
+// apply limits the ports of ICE UDP candidates of settingEngine.
+func (c UDPConfig) apply(settingEngine *webrtc.SettingEngine) error {
+	if c.PortMin == 0 && c.PortMax == 0 {
+		return nil
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	return settingEngine.SetEphemeralUDPPortRange(uint16(c.PortMin), uint16(c.PortMax))
+}
+
+// listen opens a UDP connection on ip with a port in the range, starting at
+// a random port. Any port is used when both are zero.
+func (c UDPConfig) listen(ip net.IP) (*net.UDPConn, error) {
+	if c.PortMin == 0 && c.PortMax == 0 {
+		return net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	count := c.PortMax - c.PortMin + 1
+	offset := rand.Intn(count)
+	var err error
+	for i := 0; i < count; i++ {
+		port := c.PortMin + (offset+i)%count
+		var conn *net.UDPConn
+		conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: ip, Port: port})
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("no free port in UDP port range: %d-%d: %w", c.PortMin, c.PortMax, err)
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUDPConfig_apply(t *testing.T) {
+	for _, c := range []UDPConfig{
+		{},
+		{PortMin: 50000, PortMax: 50100},
+		{PortMin: 50000, PortMax: 50000},
+	} {
+		assert.NoError(t, c.apply(&webrtc.SettingEngine{}), "valid range: %v", c)
+	}
+
+	for _, c := range []UDPConfig{
+		{PortMin: 50000},
+		{PortMax: 50000},
+		{PortMin: 50100, PortMax: 50000},
+		{PortMin: 50000, PortMax: 70000},
+		{PortMin: -1, PortMax: 50000},
+	} {
+		assert.Error(t, c.apply(&webrtc.SettingEngine{}), "invalid range: %v", c)
+	}
+}
+
+func TestValidateSFUUDPConfig(t *testing.T) {
+	assert.NoError(t, ValidateSFUUDPConfig(UDPConfig{}))
+	assert.NoError(t, ValidateSFUUDPConfig(UDPConfig{PortMin: 50000, PortMax: 50100}))
+	assert.Error(t, ValidateSFUUDPConfig(UDPConfig{PortMin: 50000, PortMax: 50000}), "single port")
+	assert.Error(t, ValidateSFUUDPConfig(UDPConfig{PortMin: 50100, PortMax: 50000}))
+	assert.Error(t, ValidateSFUUDPConfig(UDPConfig{PortMin: 50000}))
+}
@@ -0,0 +1,376 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// WebhookUploadFinished is sent when all files of a recording or chat log
+	// have been uploaded.
+	WebhookUploadFinished = "upload.finished"
+	// WebhookUploadFailed is sent when an upload has failed after all
+	// attempts. The local files of failed recordings are kept.
+	WebhookUploadFailed = "upload.failed"
+)
+
+const (
+	uploadQueueSize            = 64
+	defaultUploadMaxAttempts   = 5
+	defaultUploadRetryInterval = 5 * time.Second
+	maxUploadRetryInterval     = 5 * time.Minute
+)
+
+var ErrUploadQueueFull = errors.New("upload queue full")
+
+type UploadKind string
+
+const (
+	UploadKindRecording UploadKind = "recording"
+	UploadKindChat      UploadKind = "chat"
+)
+
+// Upload is the data of upload.finished and upload.failed events.
+type Upload struct {
+	Kind UploadKind `json:"kind"`
+	Room string     `json:"room"`
+	// ID is the ID of the recording, or the ID of the meeting of a chat log.
+	ID string `json:"id"`
+	// Keys of the uploaded files
+	Keys  []string `json:"keys"`
+	Error string   `json:"error,omitempty"`
+}
+
+// ChatLog is the file a chat log is uploaded as.
+type ChatLog struct {
+	Room      string        `json:"room"`
+	MeetingID string        `json:"meetingId"`
+	Messages  []ChatMessage `json:"messages"`
+}
+
+type uploadJob struct {
+	Upload
+	files []uploadFile
+	// dir is removed after a successful upload when DeleteAfterUpload is set
+	dir string
+}
+
+type uploadFile struct {
+	key string
+	// path of the file to upload, data is uploaded when empty
+	path        string
+	data        []byte
+	contentType string
+}
+
+// Uploads copies finished recordings and chat logs to a BlobStore. Uploads
+// are processed one at a time in the order they were queued, and failed
+// files are retried with exponential backoff. A nil Uploads discards all
+// uploads.
+//
+// Recordings are uploaded as recordings/<id>/<file> and chat logs as
+// chat/<meeting id>.json, below the configured prefix.
+type Uploads struct {
+	log    Logger
+	store  BlobStore
+	config StorageConfig
+
+	onUploaded []func(Upload)
+	onFailed   []func(Upload, error)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// jobsMu guards closing jobs
+	jobsMu sync.RWMutex
+	closed bool
+	jobs   chan uploadJob
+	done   chan struct{}
+}
+
+// NewUploads creates Uploads which copy files to store.
+func NewUploads(loggerFactory LoggerFactory, store BlobStore, config StorageConfig) *Uploads {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultUploadMaxAttempts
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = defaultUploadRetryInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	u := &Uploads{
+		log:    loggerFactory.GetLogger("uploads"),
+		store:  store,
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+		jobs:   make(chan uploadJob, uploadQueueSize),
+		done:   make(chan struct{}),
+	}
+	go u.run()
+	return u
+}
+
+// OnUploaded adds a function called after all files of an upload have been
+// stored. It must be called before any uploads are queued.
+func (u *Uploads) OnUploaded(fn func(Upload)) {
+	u.onUploaded = append(u.onUploaded, fn)
+}
+
+// OnFailed adds a function called when an upload has been given up on. It
+// must be called before any uploads are queued.
+func (u *Uploads) OnFailed(fn func(Upload, error)) {
+	u.onFailed = append(u.onFailed, fn)
+}
+
+// UploadRecording queues the files in the directory of a stopped recording.
+func (u *Uploads) UploadRecording(rec Recording) {
+	if u == nil {
+		return
+	}
+
+	job, err := u.recordingJob(rec)
+	if err != nil {
+		u.failed(job, err)
+		return
+	}
+
+	u.enqueue(job)
+}
+
+// putRecording uploads the files of a stopped recording right away, making
+// a single attempt per file. It is used by recording post-processing, which
+// retries failed steps itself.
+func (u *Uploads) putRecording(ctx context.Context, rec Recording) (Upload, error) {
+	job, err := u.recordingJob(rec)
+	if err != nil {
+		return job.Upload, err
+	}
+
+	for _, file := range job.files {
+		if err := u.put(ctx, file); err != nil {
+			return job.Upload, err
+		}
+		job.Keys = append(job.Keys, file.key)
+	}
+
+	u.uploaded(job)
+	return job.Upload, nil
+}
+
+func (u *Uploads) recordingJob(rec Recording) (uploadJob, error) {
+	job := uploadJob{
+		Upload: Upload{
+			Kind: UploadKindRecording,
+			Room: rec.Room,
+			ID:   rec.ID,
+		},
+		dir: rec.Dir,
+	}
+
+	files, err := ioutil.ReadDir(rec.Dir)
+	if err != nil {
+		return job, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		job.files = append(job.files, uploadFile{
+			key:         u.config.Prefix + "recordings/" + rec.ID + "/" + file.Name(),
+			path:        filepath.Join(rec.Dir, file.Name()),
+			contentType: uploadContentType(file.Name()),
+		})
+	}
+
+	return job, nil
+}
+
+// UploadChat queues the chat log of a meeting in room. Nothing is uploaded
+// when there are no messages.
+func (u *Uploads) UploadChat(room string, meetingID string, messages []ChatMessage) {
+	if u == nil || len(messages) == 0 {
+		return
+	}
+
+	if meetingID == "" {
+		meetingID = NewUUIDBase62()
+	}
+
+	job := uploadJob{
+		Upload: Upload{
+			Kind: UploadKindChat,
+			Room: room,
+			ID:   meetingID,
+		},
+	}
+
+	data, err := json.Marshal(ChatLog{
+		Room:      room,
+		MeetingID: meetingID,
+		Messages:  messages,
+	})
+	if err != nil {
+		u.failed(job, err)
+		return
+	}
+
+	job.files = []uploadFile{{
+		key:         u.config.Prefix + "chat/" + meetingID + ".json",
+		data:        data,
+		contentType: "application/json",
+	}}
+
+	u.enqueue(job)
+}
+
+func (u *Uploads) enqueue(job uploadJob) {
+	u.jobsMu.RLock()
+	defer u.jobsMu.RUnlock()
+
+	if u.closed {
+		return
+	}
+
+	select {
+	case u.jobs <- job:
+	default:
+		u.failed(job, ErrUploadQueueFull)
+	}
+}
+
+// Close stops retrying failed uploads, cancels the upload in progress and
+// drops queued uploads.
+func (u *Uploads) Close() {
+	if u == nil {
+		return
+	}
+
+	u.jobsMu.Lock()
+	if !u.closed {
+		u.closed = true
+		u.cancel()
+		close(u.jobs)
+	}
+	u.jobsMu.Unlock()
+
+	<-u.done
+}
+
+func (u *Uploads) run() {
+	defer close(u.done)
+
+	for job := range u.jobs {
+		if u.ctx.Err() != nil {
+			continue
+		}
+
+		u.process(job)
+	}
+}
+
+func (u *Uploads) process(job uploadJob) {
+	for _, file := range job.files {
+		if err := u.putWithRetry(job, file); err != nil {
+			u.failed(job, err)
+			return
+		}
+		job.Keys = append(job.Keys, file.key)
+	}
+
+	u.uploaded(job)
+}
+
+// uploaded removes the local files of an uploaded recording when configured
+// and calls the OnUploaded hooks.
+func (u *Uploads) uploaded(job uploadJob) {
+	u.log.Printf("Uploaded %s: %s of room: %s", job.Kind, job.ID, job.Room)
+
+	if job.dir != "" && u.config.DeleteAfterUpload {
+		if err := os.RemoveAll(job.dir); err != nil {
+			u.log.Printf("Error deleting uploaded %s: %s: %s", job.Kind, job.ID, err)
+		}
+	}
+
+	for _, fn := range u.onUploaded {
+		fn(job.Upload)
+	}
+}
+
+func (u *Uploads) putWithRetry(job uploadJob, file uploadFile) error {
+	retryInterval := u.config.RetryInterval
+
+	for attempt := 1; ; attempt++ {
+		err := u.put(u.ctx, file)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= u.config.MaxAttempts || u.ctx.Err() != nil {
+			return err
+		}
+
+		u.log.Printf("Error uploading %s: %s, retrying in %s: %s", job.Kind, file.key, retryInterval, err)
+
+		timer := time.NewTimer(retryInterval)
+		select {
+		case <-timer.C:
+		case <-u.ctx.Done():
+			timer.Stop()
+			return err
+		}
+
+		retryInterval *= 2
+		if retryInterval > maxUploadRetryInterval {
+			retryInterval = maxUploadRetryInterval
+		}
+	}
+}
+
+func (u *Uploads) put(ctx context.Context, file uploadFile) error {
+	if file.path == "" {
+		return u.store.Put(ctx, file.key, bytes.NewReader(file.data), file.contentType)
+	}
+
+	f, err := os.Open(file.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return u.store.Put(ctx, file.key, f, file.contentType)
+}
+
+func (u *Uploads) failed(job uploadJob, err error) {
+	u.log.Printf("Error uploading %s: %s of room: %s: %s", job.Kind, job.ID, job.Room, err)
+
+	job.Error = err.Error()
+	for _, fn := range u.onFailed {
+		fn(job.Upload, err)
+	}
+}
+
+func uploadContentType(fileName string) string {
+	switch filepath.Ext(fileName) {
+	case ".ogg":
+		return "audio/ogg"
+	case ".ivf":
+		return "video/x-ivf"
+	}
+	if contentType := mime.TypeByExtension(filepath.Ext(fileName)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
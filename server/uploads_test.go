@@ -0,0 +1,174 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testBlobStore struct {
+	mu sync.Mutex
+	// failures is the number of Put calls which fail
+	failures int
+	calls    int
+	blobs    map[string]string
+}
+
+func (s *testBlobStore) Put(ctx context.Context, key string, body io.ReadSeeker, contentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.calls <= s.failures {
+		return errors.New("unavailable")
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if s.blobs == nil {
+		s.blobs = map[string]string{}
+	}
+	s.blobs[key] = string(data)
+	return nil
+}
+
+type testUploadHooks struct {
+	uploaded chan server.Upload
+	failed   chan server.Upload
+}
+
+func newTestUploads(store server.BlobStore, config server.StorageConfig) (*server.Uploads, testUploadHooks) {
+	config.RetryInterval = time.Millisecond
+	uploads := server.NewUploads(loggerFactory, store, config)
+	hooks := testUploadHooks{
+		uploaded: make(chan server.Upload, 1),
+		failed:   make(chan server.Upload, 1),
+	}
+	uploads.OnUploaded(func(upload server.Upload) {
+		hooks.uploaded <- upload
+	})
+	uploads.OnFailed(func(upload server.Upload, err error) {
+		hooks.failed <- upload
+	})
+	return uploads, hooks
+}
+
+func newTestRecordingDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "recording")
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "manifest.json"), []byte("{}"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a_track.ogg"), []byte("ogg"), 0644))
+	return dir
+}
+
+func TestUploads_recording(t *testing.T) {
+	dir := newTestRecordingDir(t)
+	defer os.RemoveAll(dir)
+
+	store := &testBlobStore{failures: 2}
+	uploads, hooks := newTestUploads(store, server.StorageConfig{
+		Prefix:            "meet/",
+		DeleteAfterUpload: true,
+	})
+	defer uploads.Close()
+
+	uploads.UploadRecording(server.Recording{ID: "rec1", Room: "room", Dir: dir})
+
+	select {
+	case upload := <-hooks.uploaded:
+		assert.Equal(t, server.Upload{
+			Kind: server.UploadKindRecording,
+			Room: "room",
+			ID:   "rec1",
+			Keys: []string{"meet/recordings/rec1/a_track.ogg", "meet/recordings/rec1/manifest.json"},
+		}, upload)
+	case <-time.After(timeout):
+		t.Fatal("timed out waiting for upload")
+	}
+
+	store.mu.Lock()
+	assert.Equal(t, map[string]string{
+		"meet/recordings/rec1/a_track.ogg":   "ogg",
+		"meet/recordings/rec1/manifest.json": "{}",
+	}, store.blobs)
+	store.mu.Unlock()
+
+	_, err := os.Stat(dir)
+	assert.True(t, os.IsNotExist(err), "uploaded recording is deleted")
+}
+
+func TestUploads_failed(t *testing.T) {
+	dir := newTestRecordingDir(t)
+	defer os.RemoveAll(dir)
+
+	store := &testBlobStore{failures: 3}
+	uploads, hooks := newTestUploads(store, server.StorageConfig{
+		MaxAttempts:       3,
+		DeleteAfterUpload: true,
+	})
+	defer uploads.Close()
+
+	uploads.UploadRecording(server.Recording{ID: "rec1", Room: "room", Dir: dir})
+
+	select {
+	case upload := <-hooks.failed:
+		assert.Equal(t, "rec1", upload.ID)
+		assert.Equal(t, "unavailable", upload.Error)
+	case <-time.After(timeout):
+		t.Fatal("timed out waiting for upload")
+	}
+
+	store.mu.Lock()
+	assert.Equal(t, 3, store.calls)
+	store.mu.Unlock()
+
+	_, err := os.Stat(dir)
+	assert.NoError(t, err, "failed recording is kept")
+}
+
+func TestUploads_chat(t *testing.T) {
+	store := &testBlobStore{}
+	uploads, hooks := newTestUploads(store, server.StorageConfig{})
+	defer uploads.Close()
+
+	uploads.UploadChat("room", "meeting1", nil)
+	messages := []server.ChatMessage{{UserID: "a", Message: "hello", Timestamp: time.Now().UTC()}}
+	uploads.UploadChat("room", "meeting1", messages)
+
+	select {
+	case upload := <-hooks.uploaded:
+		assert.Equal(t, server.UploadKindChat, upload.Kind)
+		assert.Equal(t, "meeting1", upload.ID)
+		assert.Equal(t, []string{"chat/meeting1.json"}, upload.Keys)
+	case <-time.After(timeout):
+		t.Fatal("timed out waiting for upload")
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	assert.Len(t, store.blobs, 1, "empty chat logs are not uploaded")
+	var chatLog server.ChatLog
+	require.NoError(t, json.Unmarshal([]byte(store.blobs["chat/meeting1.json"]), &chatLog))
+	assert.Equal(t, server.ChatLog{Room: "room", MeetingID: "meeting1", Messages: messages}, chatLog)
+}
+
+func TestUploads_nil(t *testing.T) {
+	var uploads *server.Uploads
+	uploads.UploadRecording(server.Recording{ID: "rec1"})
+	uploads.UploadChat("room", "meeting1", []server.ChatMessage{{Message: "hello"}})
+	uploads.Close()
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// WebhookRoomCreated is sent when the first client of a room connects to
+	// this instance, unless the room is within its idle timeout.
+	WebhookRoomCreated = "room.created"
+	// WebhookRoomEnding is sent before a room reaches its maximum duration.
+	WebhookRoomEnding = "room.ending"
+	// WebhookRoomClosed is sent when a room reaches its maximum duration or
+	// has been empty for longer than the idle timeout.
+	WebhookRoomClosed        = "room.closed"
+	WebhookPeerJoined        = "peer.joined"
+	WebhookPeerLeft          = "peer.left"
+	WebhookTrackPublished    = "track.published"
+	WebhookRecordingFinished = "recording.finished"
+)
+
+const (
+	// WebhookSignatureHeader contains the hex encoded HMAC-SHA256 of the
+	// request body, keyed with the webhook secret, prefixed with "sha256=".
+	WebhookSignatureHeader = "X-Peer-Calls-Signature"
+	WebhookEventHeader     = "X-Peer-Calls-Event"
+	// WebhookDeliveryHeader contains the event ID, which is the same for all
+	// attempts to deliver an event.
+	WebhookDeliveryHeader = "X-Peer-Calls-Delivery"
+	// WebhookKeyIDHeader contains the ID of the API key which signed the
+	// request, when requests are signed with an API key.
+	WebhookKeyIDHeader = "X-Peer-Calls-Key-Id"
+)
+
+const (
+	webhookQueueSize            = 256
+	defaultWebhookMaxAttempts   = 5
+	defaultWebhookRetryInterval = time.Second
+	maxWebhookRetryInterval     = time.Minute
+)
+
+// WebhookEvent is the JSON body of a webhook request.
+type WebhookEvent struct {
+	ID       string      `json:"id"`
+	Type     string      `json:"type"`
+	Time     time.Time   `json:"time"`
+	Room     string      `json:"room"`
+	ClientID string      `json:"clientId,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+}
+
+// WebhookTrack is the data of a track.published event.
+type WebhookTrack struct {
+	TrackID string `json:"trackId"`
+	Kind    string `json:"kind"`
+	SSRC    uint32 `json:"ssrc"`
+}
+
+// SignWebhook returns the value of the signature header of a webhook
+// request with body. Requests signed with an API key use the hex encoded
+// SHA-256 of the key as secret.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Webhooks posts lifecycle events to a URL. Events are delivered one at a
+// time in the order they were emitted. Failed deliveries are retried with
+// exponential backoff, so a slow or unavailable endpoint delays later
+// events. A nil Webhooks discards all events.
+type Webhooks struct {
+	log     Logger
+	config  WebhookConfig
+	client  *http.Client
+	apiKeys *APIKeys
+
+	// eventsMu guards closing events
+	eventsMu sync.RWMutex
+	closed   bool
+	events   chan WebhookEvent
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewWebhooks creates Webhooks which post events to config.URL.
+func NewWebhooks(loggerFactory LoggerFactory, config WebhookConfig, client *http.Client) *Webhooks {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultWebhookMaxAttempts
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = defaultWebhookRetryInterval
+	}
+
+	w := &Webhooks{
+		log:    loggerFactory.GetLogger("webhooks"),
+		config: config,
+		client: client,
+		events: make(chan WebhookEvent, webhookQueueSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// SetAPIKeys sets the API keys the signing key config.KeyID is read from. It
+// must be called before any events are emitted.
+func (w *Webhooks) SetAPIKeys(keys *APIKeys) {
+	w.apiKeys = keys
+}
+
+// Emit queues an event of eventType. The event is dropped when the queue is
+// full or Webhooks is closed.
+func (w *Webhooks) Emit(eventType string, room string, clientID string, data interface{}) {
+	if w == nil {
+		return
+	}
+
+	event := WebhookEvent{
+		ID:       NewUUIDBase62(),
+		Type:     eventType,
+		Time:     time.Now(),
+		Room:     room,
+		ClientID: clientID,
+		Data:     data,
+	}
+
+	w.eventsMu.RLock()
+	defer w.eventsMu.RUnlock()
+
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.events <- event:
+	default:
+		w.log.Printf("Webhook queue full, dropping event: %s in room: %s", eventType, room)
+	}
+}
+
+// Close stops retrying failed deliveries, drops queued events and waits
+// for the delivery in progress to finish.
+func (w *Webhooks) Close() {
+	if w == nil {
+		return
+	}
+
+	w.eventsMu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.stop)
+		close(w.events)
+	}
+	w.eventsMu.Unlock()
+
+	<-w.done
+}
+
+func (w *Webhooks) run() {
+	defer close(w.done)
+
+	for event := range w.events {
+		select {
+		case <-w.stop:
+			continue
+		default:
+		}
+
+		w.deliver(event)
+	}
+}
+
+func (w *Webhooks) deliver(event WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		w.log.Printf("Error serializing webhook event: %s: %s", event.Type, err)
+		return
+	}
+
+	retryInterval := w.config.RetryInterval
+
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(event, body)
+		if err == nil {
+			return
+		}
+
+		if !retry || attempt >= w.config.MaxAttempts {
+			w.log.Printf("Error delivering webhook event: %s (%s) after %d attempts: %s", event.Type, event.ID, attempt, err)
+			return
+		}
+
+		w.log.Printf("Error delivering webhook event: %s (%s), retrying in %s: %s", event.Type, event.ID, retryInterval, err)
+
+		timer := time.NewTimer(retryInterval)
+		select {
+		case <-timer.C:
+		case <-w.stop:
+			timer.Stop()
+			return
+		}
+
+		retryInterval *= 2
+		if retryInterval > maxWebhookRetryInterval {
+			retryInterval = maxWebhookRetryInterval
+		}
+	}
+}
+
+// post sends a single delivery attempt. Network errors, server errors and
+// rate limiting are retried, other client errors are not.
+func (w *Webhooks) post(event WebhookEvent, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, event.Type)
+	req.Header.Set(WebhookDeliveryHeader, event.ID)
+	switch {
+	case w.config.KeyID != "":
+		// read on every attempt so that rotated keys are used right away
+		secret, err := w.apiKeys.webhookSecret(w.config.KeyID, time.Now())
+		if err != nil {
+			return false, fmt.Errorf("Error reading webhook key: %s: %w", w.config.KeyID, err)
+		}
+		req.Header.Set(WebhookKeyIDHeader, w.config.KeyID)
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(secret, body))
+	case w.config.Secret != "":
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(w.config.Secret, body))
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("Webhook responded with: %s", res.Status)
+	retry = res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+	return retry, err
+}
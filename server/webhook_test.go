@@ -0,0 +1,194 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+	event  server.WebhookEvent
+}
+
+// newWebhookServer responds with statuses in order, and with 204 once all
+// have been used.
+func newWebhookServer(t *testing.T, statuses ...int) (*httptest.Server, <-chan webhookRequest) {
+	requests := make(chan webhookRequest, 10)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		var event server.WebhookEvent
+		assert.NoError(t, json.Unmarshal(body, &event))
+		requests <- webhookRequest{r.Header, body, event}
+
+		status := http.StatusNoContent
+		if len(statuses) > 0 {
+			status, statuses = statuses[0], statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	return s, requests
+}
+
+func newTestWebhooks(url string) *server.Webhooks {
+	return server.NewWebhooks(loggerFactory, server.WebhookConfig{
+		URL:           url,
+		Secret:        "webhook-secret",
+		RetryInterval: 10 * time.Millisecond,
+	}, http.DefaultClient)
+}
+
+func receiveWebhook(t *testing.T, requests <-chan webhookRequest) webhookRequest {
+	select {
+	case req := <-requests:
+		return req
+	case <-time.After(timeout):
+		require.Fail(t, "timed out waiting for webhook")
+		return webhookRequest{}
+	}
+}
+
+func TestWebhooks_signedAndRetried(t *testing.T) {
+	s, requests := newWebhookServer(t, http.StatusServiceUnavailable)
+	defer s.Close()
+	webhooks := newTestWebhooks(s.URL)
+	defer webhooks.Close()
+
+	webhooks.Emit(server.WebhookTrackPublished, "room", "a", server.WebhookTrack{
+		TrackID: "track-1",
+		Kind:    "audio",
+		SSRC:    123,
+	})
+
+	first := receiveWebhook(t, requests)
+	second := receiveWebhook(t, requests)
+
+	assert.Equal(t, first.event.ID, second.event.ID)
+	assert.Equal(t, first.body, second.body)
+	assert.Equal(t, server.WebhookTrackPublished, second.header.Get(server.WebhookEventHeader))
+	assert.Equal(t, second.event.ID, second.header.Get(server.WebhookDeliveryHeader))
+	assert.Equal(t, server.SignWebhook("webhook-secret", second.body), second.header.Get(server.WebhookSignatureHeader))
+	assert.Equal(t, "room", second.event.Room)
+	assert.Equal(t, "a", second.event.ClientID)
+	assert.Equal(t, map[string]interface{}{
+		"trackId": "track-1",
+		"kind":    "audio",
+		"ssrc":    float64(123),
+	}, second.event.Data)
+}
+
+func TestWebhooks_clientErrorNotRetried(t *testing.T) {
+	s, requests := newWebhookServer(t, http.StatusBadRequest)
+	defer s.Close()
+	webhooks := newTestWebhooks(s.URL)
+	defer webhooks.Close()
+
+	webhooks.Emit(server.WebhookPeerJoined, "room", "a", nil)
+	webhooks.Emit(server.WebhookPeerLeft, "room", "a", nil)
+
+	assert.Equal(t, server.WebhookPeerJoined, receiveWebhook(t, requests).event.Type)
+	assert.Equal(t, server.WebhookPeerLeft, receiveWebhook(t, requests).event.Type)
+}
+
+func TestWebhooks_roomLifecycle(t *testing.T) {
+	hooks, requests := newWebhookServer(t)
+	defer hooks.Close()
+	webhooks := newTestWebhooks(hooks.URL)
+	defer webhooks.Close()
+
+	rooms := server.NewAdapterRoomManager(func(room string) server.Adapter {
+		return server.NewMemoryAdapter(room)
+	})
+	mux := server.NewMux(loggerFactory, "", "v0.0.0", mesh(), iceServers, server.AdminConfig{}, server.InactivityConfig{}, server.ClientConfig{}, rooms, newMockTracksManager())
+	mux.WSS.SetWebhooks(webhooks)
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws/" + roomName + "/" + clientID
+	ws := mustDialWS(t, ctx, wsURL)
+
+	created := receiveWebhook(t, requests)
+	assert.Equal(t, server.WebhookRoomCreated, created.event.Type)
+	assert.Equal(t, roomName, created.event.Room)
+	assert.Equal(t, "", created.event.ClientID)
+
+	joined := receiveWebhook(t, requests)
+	assert.Equal(t, server.WebhookPeerJoined, joined.event.Type)
+	assert.Equal(t, clientID, joined.event.ClientID)
+
+	ws.Close(websocket.StatusNormalClosure, "")
+
+	left := receiveWebhook(t, requests)
+	assert.Equal(t, server.WebhookPeerLeft, left.event.Type)
+	assert.Equal(t, roomName, left.event.Room)
+	assert.Equal(t, clientID, left.event.ClientID)
+}
+
+func TestWebhooks_recordingFinished(t *testing.T) {
+	hooks, requests := newWebhookServer(t)
+	defer hooks.Close()
+	webhooks := newTestWebhooks(hooks.URL)
+	defer webhooks.Close()
+
+	dir, err := ioutil.TempDir("", "recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	recorder := server.NewRoomRecorder(loggerFactory, dir)
+	recorder.SetWebhooks(webhooks)
+	rec, err := recorder.Start("room")
+	require.NoError(t, err)
+	_, ok := recorder.Stop("room")
+	require.True(t, ok)
+
+	req := receiveWebhook(t, requests)
+	assert.Equal(t, server.WebhookRecordingFinished, req.event.Type)
+	assert.Equal(t, "room", req.event.Room)
+	data, _ := req.event.Data.(map[string]interface{})
+	assert.Equal(t, rec.ID, data["id"])
+}
+
+func TestWebhooks_signedWithAPIKey(t *testing.T) {
+	s, requests := newWebhookServer(t)
+	defer s.Close()
+
+	apiKeys := server.NewAPIKeys(loggerFactory, server.NewMemoryAPIKeyStore())
+	key, _, err := apiKeys.Issue(server.APIKey{Scope: server.APIKeyScopeWebhook})
+	require.NoError(t, err)
+
+	webhooks := server.NewWebhooks(loggerFactory, server.WebhookConfig{
+		URL:   s.URL,
+		KeyID: key.ID,
+	}, http.DefaultClient)
+	webhooks.SetAPIKeys(apiKeys)
+	defer webhooks.Close()
+
+	webhooks.Emit(server.WebhookPeerJoined, "room", "a", nil)
+	req := receiveWebhook(t, requests)
+	assert.Equal(t, key.ID, req.header.Get(server.WebhookKeyIDHeader))
+	require.NotEmpty(t, key.WebhookSecret)
+	assert.NotEqual(t, key.Hash, key.WebhookSecret)
+	assert.Equal(t, server.SignWebhook(key.WebhookSecret, req.body), req.header.Get(server.WebhookSignatureHeader))
+
+	rotated, _, err := apiKeys.Rotate(key.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, key.WebhookSecret, rotated.WebhookSecret)
+	webhooks.Emit(server.WebhookPeerLeft, "room", "a", nil)
+	req = receiveWebhook(t, requests)
+	assert.Equal(t, server.SignWebhook(rotated.WebhookSecret, req.body), req.header.Get(server.WebhookSignatureHeader))
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"sync"
+	"time"
 
 	"github.com/pion/webrtc/v2"
 )
@@ -11,19 +12,33 @@ type TransceiverRequest struct {
 	Init      webrtc.RtpTransceiverInit
 }
 
+// Negotiator serializes negotiations of a peer connection. Only the
+// initiator creates offers, the other peer requests them.
+//
+// The initiator is the impolite peer: when an offer from the remote peer
+// collides with its own, the remote offer is ignored and a new offer is sent
+// once the local one has been answered. The polite remote peer is expected
+// to roll back its offer and answer instead, as in perfect negotiation.
 type Negotiator struct {
 	log Logger
 
-	initiator            bool
-	remotePeerID         string
-	peerConnection       *webrtc.PeerConnection
-	onOffer              func(webrtc.SessionDescription, error)
+	initiator      bool
+	remotePeerID   string
+	peerConnection *webrtc.PeerConnection
+	// peerConnectionMu is held while the peer connection is changed
+	peerConnectionMu     sync.Locker
+	onOffer              func(webrtc.SessionDescription, error) error
 	onRequestNegotiation func()
 
 	isNegotiating     bool
 	mu                sync.Mutex
 	queuedNegotiation bool
 
+	// debounce delays negotiations started while idle, so that changes made
+	// in a burst result in a single offer
+	debounce      time.Duration
+	debounceTimer *time.Timer
+
 	queuedTransceiverRequests []TransceiverRequest
 }
 
@@ -31,14 +46,16 @@ func NewNegotiator(
 	loggerFactory LoggerFactory,
 	initiator bool,
 	peerConnection *webrtc.PeerConnection,
+	peerConnectionMu sync.Locker,
 	remotePeerID string,
-	onOffer func(webrtc.SessionDescription, error),
+	onOffer func(webrtc.SessionDescription, error) error,
 	onRequestNegotiation func(),
 ) *Negotiator {
 	n := &Negotiator{
-		log:                  loggerFactory.GetLogger("negotiator"),
+		log:                  loggerFactory.GetLogger("negotiator").WithCtx(LogCtx{"clientID": remotePeerID}),
 		initiator:            initiator,
 		peerConnection:       peerConnection,
+		peerConnectionMu:     peerConnectionMu,
 		remotePeerID:         remotePeerID,
 		onOffer:              onOffer,
 		onRequestNegotiation: onRequestNegotiation,
@@ -50,17 +67,17 @@ func NewNegotiator(
 
 func (n *Negotiator) AddTransceiverFromKind(t TransceiverRequest) {
 	n.mu.Lock()
-	n.log.Printf("[%s] Queued %s transceiver, direction: %s", n.remotePeerID, t.CodecType, t.Init.Direction)
+	n.log.Debugf("Queued %s transceiver, direction: %s", t.CodecType, t.Init.Direction)
 	n.queuedTransceiverRequests = append(n.queuedTransceiverRequests, t)
 	n.mu.Unlock()
-	n.log.Printf("[%s] Calling Negotiate because a %s transceiver was queued", n.remotePeerID, t.CodecType)
+	n.log.Debugf("Calling Negotiate because a %s transceiver was queued", t.CodecType)
 	n.Negotiate()
 }
 
 func (n *Negotiator) handleSignalingStateChange(state webrtc.SignalingState) {
 	// TODO check if we need to have a check for first stable state
 	// like simple-peer has.
-	n.log.Printf("[%s] Signaling state change for: %s", n.remotePeerID, state)
+	n.log.Debugf("Signaling state change: %s", state)
 
 	if state == webrtc.SignalingStateStable {
 		n.mu.Lock()
@@ -69,53 +86,133 @@ func (n *Negotiator) handleSignalingStateChange(state webrtc.SignalingState) {
 
 		if n.queuedNegotiation {
 			n.isNegotiating = true
-			n.log.Printf("[%s] Executing queued negotiation", n.remotePeerID)
+			n.log.Debugf("Executing queued negotiation")
 			n.queuedNegotiation = false
 			n.negotiate()
 		}
 	}
 }
 
+// SetDebounce sets how long negotiations are delayed when no negotiation is
+// in progress. Negotiations requested in the meantime are merged into one.
+func (n *Negotiator) SetDebounce(debounce time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.debounce = debounce
+}
+
 func (n *Negotiator) Negotiate() {
-	n.log.Printf("[%s] Negotiate", n.remotePeerID)
+	n.log.Debugf("Negotiate")
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.isNegotiating {
+		n.log.Debugf("Negotiate: already negotiating, queueing for later")
+		n.queuedNegotiation = true
+		return
+	}
+
+	if n.debounce > 0 {
+		if n.debounceTimer == nil {
+			n.log.Debugf("Negotiate: starting in %s", n.debounce)
+			n.debounceTimer = time.AfterFunc(n.debounce, n.negotiateDebounced)
+		}
+		return
+	}
+
+	n.log.Debugf("Negotiate: start")
+	n.isNegotiating = true
+
+	n.negotiate()
+}
 
+func (n *Negotiator) negotiateDebounced() {
 	n.mu.Lock()
 	defer n.mu.Unlock()
+
+	n.debounceTimer = nil
 	if n.isNegotiating {
-		n.log.Printf("[%s] Negotiate: already negotiating, queueing for later", n.remotePeerID)
+		n.log.Debugf("Negotiate: already negotiating, queueing for later")
 		n.queuedNegotiation = true
 		return
 	}
 
-	n.log.Printf("[%s] Negotiate: start", n.remotePeerID)
+	n.log.Debugf("Negotiate: start")
 	n.isNegotiating = true
 
 	n.negotiate()
 }
 
+// Close stops a pending debounced negotiation.
+func (n *Negotiator) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.debounceTimer != nil {
+		n.debounceTimer.Stop()
+		n.debounceTimer = nil
+	}
+}
+
+// Polite returns true when remote offers which collide with local ones are
+// accepted. The initiator is impolite.
+func (n *Negotiator) Polite() bool {
+	return !n.initiator
+}
+
+// AcceptRemoteOffer returns false when a remote offer collides with a local
+// offer and must be ignored. The remote peer will receive a new offer once
+// the local one has been answered.
+//
+// Polite peers never have local offers because they request negotiations
+// from the initiator, which is convenient because pion/webrtc v2 cannot roll
+// back a local offer.
+func (n *Negotiator) AcceptRemoteOffer() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.Polite() || n.peerConnection.SignalingState() != webrtc.SignalingStateHaveLocalOffer {
+		return true
+	}
+
+	n.log.Printf("Ignoring colliding remote offer, queueing negotiation")
+	n.queuedNegotiation = true
+	return false
+}
+
+// addQueuedTransceivers must be called with peerConnectionMu held.
 func (n *Negotiator) addQueuedTransceivers() {
 	for _, t := range n.queuedTransceiverRequests {
-		n.log.Printf("[%s] Adding queued %s transceiver, direction: %s", n.remotePeerID, t.CodecType, t.Init.Direction)
+		n.log.Debugf("Adding queued %s transceiver, direction: %s", t.CodecType, t.Init.Direction)
 		_, err := n.peerConnection.AddTransceiverFromKind(t.CodecType, t.Init)
 		if err != nil {
-			n.log.Printf("[%s] Error adding %s transceiver: %s", n.remotePeerID, err)
+			n.log.Errorf("Error adding %s transceiver: %s", t.CodecType, err)
 		}
 	}
 	n.queuedTransceiverRequests = []TransceiverRequest{}
 }
 
 func (n *Negotiator) negotiate() {
+	n.peerConnectionMu.Lock()
+	defer n.peerConnectionMu.Unlock()
+
 	n.addQueuedTransceivers()
 
 	if !n.initiator {
-		n.log.Printf("[%s] negotiate: requesting from initiator", n.remotePeerID)
+		n.log.Debugf("negotiate: requesting from initiator")
 		n.requestNegotiation()
 		return
 	}
 
-	n.log.Printf("[%s] negotiate: creating offer", n.remotePeerID)
+	n.log.Debugf("negotiate: creating offer")
 	offer, err := n.peerConnection.CreateOffer(nil)
-	n.onOffer(offer, err)
+	if err := n.onOffer(offer, err); err != nil {
+		// The signaling state will not change so the negotiation must end
+		// here, otherwise all later negotiations would be queued forever.
+		n.log.Errorf("negotiate: aborting: %s", err)
+		n.isNegotiating = false
+		n.queuedNegotiation = false
+	}
 }
 
 func (n *Negotiator) requestNegotiation() {
@@ -2,7 +2,9 @@ package server
 
 import (
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/pion/webrtc/v2"
 )
@@ -18,11 +20,59 @@ type Signaller struct {
 	remotePeerID   string
 	negotiator     *Negotiator
 
-	signalMu      sync.RWMutex
-	closed        bool
+	// peerConnectionMu is held while descriptions, candidates, transceivers
+	// or tracks of the peer connection are changed, by the Signaller, its
+	// Negotiator and others, see peerConnectionLocker. pion/webrtc v2 does
+	// not synchronize these changes itself.
+	peerConnectionMu sync.Mutex
+
+	// sdpMu guards the options below, which modify SDP
+	sdpMu sync.Mutex
+	// videoCodecs restricts video codecs of remote offers when set
+	videoCodecs []string
+	// opusParameters are set in Opus format parameters of local SDP
+	opusParameters map[string]string
+	// maxBitrate is set in video bandwidth lines of SDP sent to the remote
+	// peer when not zero
+	maxBitrate int
+	// headerExtensions adds RTP header extensions to offers when set
+	headerExtensions bool
+	// peerConnectionConfig rejects remote descriptions with transports
+	// which do not match its policies
+	peerConnectionConfig PeerConnectionConfig
+
+	// candidatesMu guards remoteCandidates, the candidates received before
+	// the remote description was set, localMid, the identification of the
+	// first media section of the local description, and candidateFilter
+	candidatesMu     sync.Mutex
+	remoteCandidates []webrtc.ICECandidateInit
+	localMid         string
+	// candidateFilter returns false for remote candidates which are ignored
+	candidateFilter func(candidate string) bool
+
+	// signalMu guards signalQueue, the signals waiting to be sent to
+	// signalChannel in order
+	signalMu      sync.Mutex
+	signalQueue   []Payload
+	signalReady   chan struct{}
+	signalDone    chan struct{}
 	signalChannel chan Payload
 	closeChannel  chan struct{}
 	closeOnce     sync.Once
+
+	spanMu sync.Mutex
+	// span measures negotiation until the peer connection is connected
+	span *Span
+
+	// stateMu guards the fields below, which track the state of the peer
+	// connection
+	stateMu               sync.Mutex
+	connectionState       PeerConnectionState
+	onConnectionState     func(PeerConnectionState)
+	disconnectGracePeriod time.Duration
+	// disconnectTimer fails the connection when it is not connected again
+	// within disconnectGracePeriod
+	disconnectTimer *time.Timer
 }
 
 func NewSignaller(
@@ -34,21 +84,28 @@ func NewSignaller(
 	remotePeerID string,
 ) (*Signaller, error) {
 	s := &Signaller{
-		log:            loggerFactory.GetLogger("signaller"),
-		sdpLog:         loggerFactory.GetLogger("sdp"),
+		log:            loggerFactory.GetLogger("signaller").WithCtx(LogCtx{"clientID": remotePeerID}),
+		sdpLog:         loggerFactory.GetLogger("sdp").WithCtx(LogCtx{"clientID": remotePeerID}),
 		initiator:      initiator,
 		peerConnection: peerConnection,
 		mediaEngine:    mediaEngine,
 		localPeerID:    localPeerID,
 		remotePeerID:   remotePeerID,
+		signalReady:    make(chan struct{}, 1),
+		signalDone:     make(chan struct{}),
 		signalChannel:  make(chan Payload),
 		closeChannel:   make(chan struct{}),
+
+		disconnectGracePeriod: defaultDisconnectGracePeriod,
 	}
 
+	go s.sendSignals()
+
 	negotiator := NewNegotiator(
 		loggerFactory,
 		initiator,
 		peerConnection,
+		&s.peerConnectionMu,
 		s.remotePeerID,
 		s.handleLocalOffer,
 		s.handleLocalRequestNegotiation,
@@ -57,7 +114,7 @@ func NewSignaller(
 	s.negotiator = negotiator
 
 	peerConnection.OnICEConnectionStateChange(s.handleICEConnectionStateChange)
-	// peerConnection.OnICECandidate(s.handleICECandidate)
+	peerConnection.OnICECandidate(s.handleICECandidate)
 
 	return s, s.initialize()
 }
@@ -72,13 +129,122 @@ func (s *Signaller) SignalChannel() <-chan Payload {
 	return s.signalChannel
 }
 
+// SetVideoCodecs restricts the video codecs accepted from remote offers to
+// videoCodecs. Codecs offered by the initiator are set by registering them
+// in the MediaEngine before the Signaller is created.
+func (s *Signaller) SetVideoCodecs(videoCodecs []string) {
+	s.sdpMu.Lock()
+	defer s.sdpMu.Unlock()
+	s.videoCodecs = videoCodecs
+}
+
+// SetOpusParameters sets params in the Opus format parameters of offers and
+// answers sent to the remote peer.
+func (s *Signaller) SetOpusParameters(params map[string]string) {
+	s.sdpMu.Lock()
+	defer s.sdpMu.Unlock()
+	s.opusParameters = params
+}
+
+func (s *Signaller) setOpusParameters(sessionDescription webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	s.sdpMu.Lock()
+	params := s.opusParameters
+	s.sdpMu.Unlock()
+
+	var err error
+	sessionDescription.SDP, err = SetOpusParameters(sessionDescription.SDP, params)
+	return sessionDescription, err
+}
+
+// SetMaxBitrate sets the maximum bitrate of video in offers and answers
+// sent to the remote peer, in bits per second. Zero removes the limit. It
+// takes effect with the next negotiation.
+func (s *Signaller) SetMaxBitrate(maxBitrate int) {
+	s.sdpMu.Lock()
+	defer s.sdpMu.Unlock()
+	s.maxBitrate = maxBitrate
+}
+
+// withMaxBitrate returns sessionDescription with bandwidth lines for the
+// remote peer. It must be called after sessionDescription has been set as
+// local description.
+func (s *Signaller) withMaxBitrate(sessionDescription webrtc.SessionDescription) webrtc.SessionDescription {
+	s.sdpMu.Lock()
+	maxBitrate := s.maxBitrate
+	s.sdpMu.Unlock()
+
+	sessionDescription.SDP = SetSDPMaxBitrate(sessionDescription.SDP, maxBitrate)
+	return sessionDescription
+}
+
+// SetHeaderExtensions offers the RTP header extensions forwarded by the SFU
+// to the remote peer. It takes effect with the next negotiation.
+func (s *Signaller) SetHeaderExtensions(enabled bool) {
+	s.sdpMu.Lock()
+	defer s.sdpMu.Unlock()
+	s.headerExtensions = enabled
+}
+
+// withHeaderExtensions returns offer with the extmap attributes of RTP
+// header extensions. pion ignores them, so it must be called after offer has
+// been set as local description.
+func (s *Signaller) withHeaderExtensions(offer webrtc.SessionDescription) webrtc.SessionDescription {
+	s.sdpMu.Lock()
+	enabled := s.headerExtensions
+	s.sdpMu.Unlock()
+
+	if enabled {
+		offer.SDP = SetSDPHeaderExtensions(offer.SDP)
+	}
+	return offer
+}
+
+// SetPeerConnectionConfig rejects remote descriptions which do not bundle
+// media or multiplex RTCP as required by config.
+func (s *Signaller) SetPeerConnectionConfig(config PeerConnectionConfig) {
+	s.sdpMu.Lock()
+	defer s.sdpMu.Unlock()
+	s.peerConnectionConfig = config
+}
+
+// SetCandidateFilter ignores remote ICE candidates, trickled or in remote
+// descriptions, for which filter returns false.
+func (s *Signaller) SetCandidateFilter(filter func(candidate string) bool) {
+	s.candidatesMu.Lock()
+	defer s.candidatesMu.Unlock()
+	s.candidateFilter = filter
+}
+
+// allowCandidate returns false when candidate is ignored.
+func (s *Signaller) allowCandidate(candidate string) bool {
+	s.candidatesMu.Lock()
+	filter := s.candidateFilter
+	s.candidatesMu.Unlock()
+
+	return filter == nil || filter(candidate)
+}
+
+// withAllowedCandidates removes the ignored candidates of a remote
+// description.
+func (s *Signaller) withAllowedCandidates(sessionDescription webrtc.SessionDescription) webrtc.SessionDescription {
+	sessionDescription.SDP = FilterSDPCandidates(sessionDescription.SDP, s.allowCandidate)
+	return sessionDescription
+}
+
+// SetNegotiationDebounce delays renegotiations by debounce, so that tracks
+// added or removed in a burst result in a single offer. The first
+// negotiation of an initiator is not delayed.
+func (s *Signaller) SetNegotiationDebounce(debounce time.Duration) {
+	s.negotiator.SetDebounce(debounce)
+}
+
 func (s *Signaller) initialize() error {
-	if s.initiator {
-		s.log.Printf("[%s] NewSignaller: Initiator registering default codecs", s.remotePeerID)
+	if s.initiator && len(s.mediaEngine.GetCodecsByKind(webrtc.RTPCodecTypeVideo)) == 0 {
+		s.log.Debugf("NewSignaller: Initiator registering default codecs")
 		s.mediaEngine.RegisterDefaultCodecs()
 	}
 
-	s.log.Printf("[%s] NewSignaller: Non-Initiator pre-add video transceiver", s.remotePeerID)
+	s.log.Debugf("NewSignaller: Non-Initiator pre-add video transceiver")
 	_, err := s.peerConnection.AddTransceiverFromKind(
 		webrtc.RTPCodecTypeVideo,
 		webrtc.RtpTransceiverInit{
@@ -86,11 +252,11 @@ func (s *Signaller) initialize() error {
 		},
 	)
 	if err != nil {
-		s.log.Printf("ERROR: %s", err)
+		s.log.Errorf("NewSignaller: %s", err)
 		return fmt.Errorf("[%s] NewSignaller: Error pre-adding video transceiver: %s", s.remotePeerID, err)
 	}
 
-	s.log.Printf("[%s] NewSignaller: Non-Initiator pre-add audio transceiver", s.remotePeerID)
+	s.log.Debugf("NewSignaller: Non-Initiator pre-add audio transceiver")
 	_, err = s.peerConnection.AddTransceiverFromKind(
 		webrtc.RTPCodecTypeAudio,
 		webrtc.RtpTransceiverInit{
@@ -102,80 +268,200 @@ func (s *Signaller) initialize() error {
 	}
 
 	if s.initiator {
-		s.log.Printf("[%s] NewSignaller: Initiator calling Negotiate()", s.remotePeerID)
+		s.log.Debugf("NewSignaller: Initiator calling Negotiate()")
 		s.negotiator.Negotiate()
 	}
 
 	return nil
 }
 
+// peerConnectionLocker returns the lock which must be held while tracks are
+// added to or removed from the peer connection.
+func (s *Signaller) peerConnectionLocker() sync.Locker {
+	return &s.peerConnectionMu
+}
+
 func (s *Signaller) Initiator() bool {
 	return s.initiator
 }
 
-func (s *Signaller) handleICEConnectionStateChange(connectionState webrtc.ICEConnectionState) {
-	s.log.Printf("[%s] Peer connection state changed: %s", s.remotePeerID, connectionState.String())
-	if connectionState == webrtc.ICEConnectionStateClosed ||
-		connectionState == webrtc.ICEConnectionStateDisconnected ||
-		connectionState == webrtc.ICEConnectionStateFailed {
-		s.Close()
-	}
+// TraceNegotiation sets the span which ends once the peer connection is
+// connected or fails.
+func (s *Signaller) TraceNegotiation(span *Span) {
+	s.spanMu.Lock()
+	s.span = span
+	s.spanMu.Unlock()
+}
+
+func (s *Signaller) endSpan(err error) {
+	s.spanMu.Lock()
+	span := s.span
+	s.span = nil
+	s.spanMu.Unlock()
 
+	span.SetError(err)
+	span.End()
 }
 
+// onSignal queues payload to be sent to the remote peer without blocking.
+// Signals are sent in order, so that end-of-candidates is never received
+// before the last candidate.
 func (s *Signaller) onSignal(payload Payload) {
-	s.signalMu.RLock()
+	s.signalMu.Lock()
+	s.signalQueue = append(s.signalQueue, payload)
+	s.signalMu.Unlock()
 
-	go func() {
-		defer s.signalMu.RUnlock()
+	select {
+	case s.signalReady <- struct{}{}:
+	default:
+	}
+}
 
-		ch := s.signalChannel
-		if s.closed {
-			// read from nil channel blocks indefinitely
-			ch = nil
-		}
+func (s *Signaller) sendSignals() {
+	defer close(s.signalDone)
 
+	for {
 		select {
-		case ch <- payload:
-			// successfully sent
+		case <-s.signalReady:
 		case <-s.closeChannel:
-			// signaller has been closed
 			return
 		}
-	}()
+
+		s.signalMu.Lock()
+		queue := s.signalQueue
+		s.signalQueue = nil
+		s.signalMu.Unlock()
+
+		for _, payload := range queue {
+			select {
+			case s.signalChannel <- payload:
+				// successfully sent
+			case <-s.closeChannel:
+				// signaller has been closed
+				return
+			}
+		}
+	}
 }
 
 func (s *Signaller) Close() (err error) {
 	s.closeOnce.Do(func() {
 		close(s.closeChannel)
+		s.negotiator.Close()
 
-		s.signalMu.Lock()
-		defer s.signalMu.Unlock()
-
+		<-s.signalDone
 		close(s.signalChannel)
-		s.closed = true
 
+		s.stateMu.Lock()
+		s.stopDisconnectTimer()
+		s.stateMu.Unlock()
+
+		s.peerConnectionMu.Lock()
 		err = s.peerConnection.Close()
+		s.peerConnectionMu.Unlock()
 	})
+	s.endSpan(fmt.Errorf("Signaller closed"))
 	return
 }
 
 func (s *Signaller) handleICECandidate(c *webrtc.ICECandidate) {
 	if c == nil {
+		s.handleICEGatheringComplete()
 		return
 	}
 
-	payload := Payload{
-		UserID: s.localPeerID,
-		Signal: Candidate{
-			Candidate: c.ToJSON(),
-		},
-	}
+	payload := NewPayloadCandidate(s.localPeerID, c.ToJSON())
 
-	s.log.Printf("[%s] Got ice candidate from server peer: %s", payload, s.remotePeerID)
+	s.log.Debugf("Got ice candidate from server peer: %s", payload)
 	s.onSignal(payload)
 }
 
+// handleICEGatheringComplete signals end-of-candidates to the remote peer.
+// All media sections are bundled, so the first one is used.
+func (s *Signaller) handleICEGatheringComplete() {
+	s.candidatesMu.Lock()
+	sdpMid := s.localMid
+	s.candidatesMu.Unlock()
+
+	if sdpMid == "" {
+		s.log.Debugf("No media sections to signal end-of-candidates for")
+		return
+	}
+
+	s.log.Debugf("Local ICE gathering complete, sending end-of-candidates")
+	s.onSignal(NewPayloadEndOfCandidates(s.localPeerID, sdpMid))
+}
+
+// setLocalDescription records the first media section of sessionDescription
+// for end-of-candidates before setting it, since gathering starts once it is
+// set. PeerConnection.LocalDescription cannot be used in the gathering
+// goroutine because it is not synchronized.
+func (s *Signaller) setLocalDescription(sessionDescription webrtc.SessionDescription) error {
+	if sdpMid, ok := firstSDPMid(sessionDescription.SDP); ok {
+		s.candidatesMu.Lock()
+		s.localMid = sdpMid
+		s.candidatesMu.Unlock()
+	}
+
+	return s.peerConnection.SetLocalDescription(sessionDescription)
+}
+
+// addICECandidate adds a remote candidate, or keeps it until the remote
+// description is set since pion/webrtc rejects candidates before that.
+func (s *Signaller) addICECandidate(candidate Candidate) error {
+	if candidate.EndOfCandidates() {
+		// pion/webrtc v2 keeps checking pairs until the connection fails, so
+		// there is nothing to do apart from logging.
+		s.log.Debugf("Remote signal.candidate: end-of-candidates")
+		return nil
+	}
+	if !s.allowCandidate(candidate.Candidate.Candidate) {
+		s.log.Printf("Ignoring remote candidate from address which is not allowed: %s", candidate.Candidate.Candidate)
+		return nil
+	}
+
+	s.peerConnectionMu.Lock()
+	defer s.peerConnectionMu.Unlock()
+
+	select {
+	case <-s.closeChannel:
+		// pion/webrtc v2 panics when candidates are added after the peer
+		// connection was closed, which Close does with peerConnectionMu held
+		// after closing closeChannel.
+		s.log.Debugf("Remote signal.candidate: ignored after close")
+		return nil
+	default:
+	}
+
+	s.candidatesMu.Lock()
+	if s.peerConnection.RemoteDescription() == nil {
+		s.remoteCandidates = append(s.remoteCandidates, candidate.Candidate)
+		s.candidatesMu.Unlock()
+		s.log.Debugf("Remote signal.candidate: buffered until remote description is set")
+		return nil
+	}
+	s.candidatesMu.Unlock()
+
+	return s.peerConnection.AddICECandidate(candidate.Candidate)
+}
+
+// addBufferedICECandidates adds the remote candidates received before the
+// remote description was set. It must be called after setting it, with
+// peerConnectionMu held.
+func (s *Signaller) addBufferedICECandidates() error {
+	s.candidatesMu.Lock()
+	candidates := s.remoteCandidates
+	s.remoteCandidates = nil
+	s.candidatesMu.Unlock()
+
+	for _, candidate := range candidates {
+		if err := s.peerConnection.AddICECandidate(candidate); err != nil {
+			return fmt.Errorf("[%s] Error adding buffered candidate: %w", s.remotePeerID, err)
+		}
+	}
+	return nil
+}
+
 func (s *Signaller) Signal(payload map[string]interface{}) error {
 	signalPayload, err := NewPayloadFromMap(payload)
 
@@ -185,39 +471,56 @@ func (s *Signaller) Signal(payload map[string]interface{}) error {
 
 	switch signal := signalPayload.Signal.(type) {
 	case Candidate:
-		s.log.Printf("[%s] Remote signal.canidate: %s ", signal.Candidate, s.remotePeerID)
-		return s.peerConnection.AddICECandidate(signal.Candidate)
+		s.log.Debugf("Remote signal.candidate: %s", signal.Candidate)
+		return s.addICECandidate(signal)
 	case Renegotiate:
-		s.log.Printf("[%s] Remote signal.renegotiate ", s.remotePeerID)
-		s.log.Printf("[%s] Calling signaller.Negotiate() because remote peer wanted to negotiate", s.remotePeerID)
+		s.log.Debugf("Remote signal.renegotiate, calling signaller.Negotiate()")
 		s.Negotiate()
 		return nil
 	case TransceiverRequestPayload:
-		s.log.Printf("[%s] Remote signal.transceiverRequest: %s", s.remotePeerID, signal.TransceiverRequest.Kind)
+		s.log.Debugf("Remote signal.transceiverRequest: %s", signal.TransceiverRequest.Kind)
 		s.handleTransceiverRequest(signal)
 		return nil
 	case webrtc.SessionDescription:
-		s.sdpLog.Printf("[%s] Remote signal.type: %s, signal.sdp: %s", s.remotePeerID, signal.Type, signal.SDP)
+		s.sdpLog.Printf("Remote signal.type: %s, signal.sdp: %s", signal.Type, signal.SDP)
 		return s.handleRemoteSDP(signal)
 	default:
 		return fmt.Errorf("[%s] Unexpected signal: %#v ", s.remotePeerID, signal)
 	}
 }
 
+// handleTransceiverRequest adds a transceiver requested by the remote peer.
+// Transceivers are sendrecv, unless the remote peer only sends, in which
+// case they are recvonly.
 func (s *Signaller) handleTransceiverRequest(transceiverRequest TransceiverRequestPayload) {
-	s.log.Printf("[%s] handleTransceiverRequest: %v", s.remotePeerID, transceiverRequest)
+	s.log.Debugf("handleTransceiverRequest: %v", transceiverRequest)
 
 	codecType := transceiverRequest.TransceiverRequest.Kind
 
+	direction := webrtc.RTPTransceiverDirectionSendrecv
+	if init := transceiverRequest.TransceiverRequest.Init; init != nil && init.Direction == webrtc.RTPTransceiverDirectionSendonly {
+		direction = webrtc.RTPTransceiverDirectionRecvonly
+	}
+
 	s.negotiator.AddTransceiverFromKind(TransceiverRequest{
 		CodecType: codecType,
 		Init: webrtc.RtpTransceiverInit{
-			Direction: webrtc.RTPTransceiverDirectionSendrecv,
+			Direction: direction,
 		},
 	})
 }
 
 func (s *Signaller) handleRemoteSDP(sessionDescription webrtc.SessionDescription) (err error) {
+	s.sdpMu.Lock()
+	peerConnectionConfig := s.peerConnectionConfig
+	s.sdpMu.Unlock()
+
+	if err := peerConnectionConfig.CheckSDP(sessionDescription.SDP); err != nil {
+		return fmt.Errorf("[%s] Rejecting remote %s: %w", s.remotePeerID, sessionDescription.Type, err)
+	}
+
+	sessionDescription = s.withAllowedCandidates(sessionDescription)
+
 	switch sessionDescription.Type {
 	case webrtc.SDPTypeOffer:
 		return s.handleRemoteOffer(sessionDescription)
@@ -229,53 +532,76 @@ func (s *Signaller) handleRemoteSDP(sessionDescription webrtc.SessionDescription
 }
 
 func (s *Signaller) handleRemoteOffer(sessionDescription webrtc.SessionDescription) (err error) {
-	if err = s.mediaEngine.PopulateFromSDP(sessionDescription); err != nil {
+	if !s.negotiator.AcceptRemoteOffer() {
+		return nil
+	}
+
+	s.sdpMu.Lock()
+	videoCodecs := s.videoCodecs
+	s.sdpMu.Unlock()
+
+	if sessionDescription.SDP, err = FilterSDPVideoCodecs(sessionDescription.SDP, videoCodecs); err != nil {
+		return fmt.Errorf("[%s] Error filtering codecs of SDP: %w", s.remotePeerID, err)
+	}
+
+	s.peerConnectionMu.Lock()
+	defer s.peerConnectionMu.Unlock()
+
+	if err = populateFromSDP(s.mediaEngine, sessionDescription); err != nil {
 		return fmt.Errorf("[%s] Error populating codec info from SDP: %s", s.remotePeerID, err)
 	}
 
 	if err = s.peerConnection.SetRemoteDescription(sessionDescription); err != nil {
 		return fmt.Errorf("[%s] Error setting remote description: %w", s.remotePeerID, err)
 	}
+	if err = s.addBufferedICECandidates(); err != nil {
+		return err
+	}
 	answer, err := s.peerConnection.CreateAnswer(nil)
 	if err != nil {
 		return fmt.Errorf("[%s] Error creating answer: %w", s.remotePeerID, err)
 	}
-	if err := s.peerConnection.SetLocalDescription(answer); err != nil {
+	if answer, err = s.setOpusParameters(answer); err != nil {
+		return fmt.Errorf("[%s] Error setting Opus parameters of answer: %w", s.remotePeerID, err)
+	}
+	if err := s.setLocalDescription(answer); err != nil {
 		return fmt.Errorf("[%s] Error setting local description: %w", s.remotePeerID, err)
 	}
 
-	s.sdpLog.Printf("[%s] Local signal.type: %s, signal.sdp: %s", s.remotePeerID, answer.Type, answer.SDP)
+	answer = s.withMaxBitrate(answer)
+	s.sdpLog.Printf("Local signal.type: %s, signal.sdp: %s", answer.Type, answer.SDP)
 	s.onSignal(NewPayloadSDP(s.localPeerID, answer))
 	return nil
 }
 
 func (s *Signaller) handleLocalRequestNegotiation() {
-	s.log.Printf("[%s] Sending renegotiation request to initiator", s.remotePeerID)
+	s.log.Debugf("Sending renegotiation request to initiator")
 	s.onSignal(NewPayloadRenegotiate(s.localPeerID))
 }
 
-func (s *Signaller) handleLocalOffer(offer webrtc.SessionDescription, err error) {
-	s.sdpLog.Printf("[%s] Local signal.type: %s, signal.sdp: %s", s.remotePeerID, offer.Type, offer.SDP)
+func (s *Signaller) handleLocalOffer(offer webrtc.SessionDescription, err error) error {
+	s.sdpLog.Printf("Local signal.type: %s, signal.sdp: %s", offer.Type, offer.SDP)
 	if err != nil {
-		s.log.Printf("[%s] Error creating local offer: %s", s.remotePeerID, err)
-		// TODO abort connection
-		return
+		return fmt.Errorf("[%s] Error creating local offer: %w", s.remotePeerID, err)
 	}
 
-	err = s.peerConnection.SetLocalDescription(offer)
+	if offer, err = s.setOpusParameters(offer); err != nil {
+		return fmt.Errorf("[%s] Error setting Opus parameters of local offer: %w", s.remotePeerID, err)
+	}
+
+	err = s.setLocalDescription(offer)
 	if err != nil {
-		s.log.Printf("[%s] Error setting local description from local offer: %s", s.remotePeerID, err)
-		// TODO abort connection
-		return
+		return fmt.Errorf("[%s] Error setting local description from local offer: %w", s.remotePeerID, err)
 	}
 
-	s.onSignal(NewPayloadSDP(s.localPeerID, offer))
+	s.onSignal(NewPayloadSDP(s.localPeerID, s.withHeaderExtensions(s.withMaxBitrate(offer))))
+	return nil
 }
 
 // Sends a request for a new transceiver, only if the peer is not the initiator.
 func (s *Signaller) SendTransceiverRequest(kind webrtc.RTPCodecType, direction webrtc.RTPTransceiverDirection) {
 	if !s.initiator {
-		s.log.Printf("[%s] Sending transceiver request to initiator", s.remotePeerID)
+		s.log.Debugf("Sending transceiver request to initiator")
 		s.onSignal(NewTransceiverRequest(s.localPeerID, kind, direction))
 	}
 }
@@ -288,8 +614,22 @@ func (s *Signaller) Negotiate() {
 }
 
 func (s *Signaller) handleRemoteAnswer(sessionDescription webrtc.SessionDescription) (err error) {
+	s.peerConnectionMu.Lock()
+	defer s.peerConnectionMu.Unlock()
+
 	if err = s.peerConnection.SetRemoteDescription(sessionDescription); err != nil {
 		return fmt.Errorf("[%s] Error setting remote description: %w", s.remotePeerID, err)
 	}
-	return nil
+	return s.addBufferedICECandidates()
+}
+
+// firstSDPMid returns the media identification of the first media section
+// of sessionDescription.
+func firstSDPMid(sessionDescription string) (string, bool) {
+	for _, line := range strings.Split(sessionDescription, "\n") {
+		if strings.HasPrefix(line, "a=mid:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "a=mid:")), true
+		}
+	}
+	return "", false
 }
@@ -0,0 +1,269 @@
+package server_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/peer-calls/peer-calls/server"
+	"github.com/pion/webrtc/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTrickleSignaller(t *testing.T, initiator bool, localPeerID, remotePeerID string) (*server.Signaller, <-chan struct{}) {
+	t.Helper()
+
+	mediaEngine := webrtc.MediaEngine{}
+	mediaEngine.RegisterDefaultCodecs()
+	settingEngine := webrtc.SettingEngine{}
+	settingEngine.SetTrickle(true)
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine), webrtc.WithSettingEngine(settingEngine))
+	pc, err := api.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+
+	connected := make(chan struct{})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			close(connected)
+		}
+	})
+
+	signaller, err := server.NewSignaller(loggerFactory, initiator, pc, &mediaEngine, localPeerID, remotePeerID)
+	require.NoError(t, err)
+	return signaller, connected
+}
+
+// toSignalMap converts payload the same way as sending it over a websocket.
+func toSignalMap(t *testing.T, payload server.Payload) map[string]interface{} {
+	t.Helper()
+	data, err := json.Marshal(payload)
+	require.NoError(t, err)
+	var result map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &result))
+	return result
+}
+
+func TestSignaller_trickleICE(t *testing.T) {
+	initiator, initiatorConnected := newTestTrickleSignaller(t, true, "__SERVER__", "a")
+	defer initiator.Close()
+	responder, responderConnected := newTestTrickleSignaller(t, false, "a", "__SERVER__")
+	defer responder.Close()
+
+	// Collect the offer and all candidates of the initiator before sending
+	// any of them, to make sure that candidates are delivered before the
+	// offer.
+	var offer server.Payload
+	var endOfCandidatesMid string
+	var candidates []server.Payload
+	endOfCandidates := false
+	timeout := time.After(10 * time.Second)
+	for offer.Signal == nil || !endOfCandidates {
+		select {
+		case payload := <-initiator.SignalChannel():
+			switch signal := payload.Signal.(type) {
+			case webrtc.SessionDescription:
+				offer = payload
+			case server.Candidate:
+				if signal.EndOfCandidates() {
+					endOfCandidates = true
+					require.NotNil(t, signal.Candidate.SDPMid)
+					endOfCandidatesMid = *signal.Candidate.SDPMid
+				} else {
+					assert.False(t, endOfCandidates, "candidate received after end-of-candidates")
+				}
+				candidates = append(candidates, payload)
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for offer and end-of-candidates")
+		}
+	}
+	require.True(t, len(candidates) > 1, "expected at least one candidate before end-of-candidates")
+	assert.Contains(t, offer.Signal.(webrtc.SessionDescription).SDP, "a=mid:"+endOfCandidatesMid+"\r\n")
+
+	for _, candidate := range candidates {
+		assert.NoError(t, responder.Signal(toSignalMap(t, candidate)))
+	}
+	assert.NoError(t, responder.Signal(toSignalMap(t, offer)))
+
+	relay := func(from *server.Signaller, to *server.Signaller) {
+		for payload := range from.SignalChannel() {
+			data, _ := json.Marshal(payload)
+			var signal map[string]interface{}
+			_ = json.Unmarshal(data, &signal)
+			_ = to.Signal(signal)
+		}
+	}
+	go relay(responder, initiator)
+	go relay(initiator, responder)
+
+	for _, connected := range []<-chan struct{}{initiatorConnected, responderConnected} {
+		select {
+		case <-connected:
+		case <-timeout:
+			t.Fatal("timed out waiting for connection")
+		}
+	}
+}
+
+func TestNewPayloadFromMap_candidate(t *testing.T) {
+	payload, err := server.NewPayloadFromMap(map[string]interface{}{
+		"userId": "a",
+		"signal": map[string]interface{}{
+			"candidate": map[string]interface{}{
+				"candidate":     "candidate:1 1 udp 2130706431 192.168.1.2 50000 typ host",
+				"sdpMLineIndex": float64(1),
+			},
+		},
+	})
+	require.NoError(t, err)
+	candidate, ok := payload.Signal.(server.Candidate)
+	require.True(t, ok)
+	assert.False(t, candidate.EndOfCandidates())
+	assert.Nil(t, candidate.Candidate.SDPMid)
+	require.NotNil(t, candidate.Candidate.SDPMLineIndex)
+	assert.Equal(t, uint16(1), *candidate.Candidate.SDPMLineIndex)
+
+	payload, err = server.NewPayloadFromMap(map[string]interface{}{
+		"userId": "a",
+		"signal": map[string]interface{}{
+			"candidate": map[string]interface{}{
+				"candidate":     "",
+				"sdpMid":        nil,
+				"sdpMLineIndex": nil,
+			},
+		},
+	})
+	require.NoError(t, err)
+	candidate, ok = payload.Signal.(server.Candidate)
+	require.True(t, ok)
+	assert.True(t, candidate.EndOfCandidates())
+
+	_, err = server.NewPayloadFromMap(map[string]interface{}{
+		"userId": "a",
+		"signal": map[string]interface{}{
+			"candidate": map[string]interface{}{
+				"candidate": "candidate:1 1 udp 2130706431 192.168.1.2 50000 typ host",
+			},
+		},
+	})
+	assert.Error(t, err, "media section of candidate is required")
+}
+
+func TestSignaller_offerCollision(t *testing.T) {
+	initiator, _ := newTestTrickleSignaller(t, true, "__SERVER__", "a")
+	defer initiator.Close()
+
+	offer := readTestOffer(t, initiator, 10*time.Second)
+
+	// the remote peer sends its own offer before answering
+	remote, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	defer remote.Close()
+	_, err = remote.CreateDataChannel("data", nil)
+	require.NoError(t, err)
+	remoteOffer, err := remote.CreateOffer(nil)
+	require.NoError(t, err)
+	require.NoError(t, remote.SetLocalDescription(remoteOffer))
+	assert.NoError(t, initiator.Signal(toSignalMap(t, server.NewPayloadSDP("a", remoteOffer))), "colliding offer is ignored")
+
+	// the remote peer rolls back and answers instead
+	answerer := newTestAnswerer(t)
+	defer answerer.Close()
+	answer := answerTestOffer(t, answerer, offer)
+	require.NoError(t, initiator.Signal(toSignalMap(t, server.NewPayloadSDP("a", answer))))
+
+	// the initiator offers again so the remote peer can apply its changes
+	readTestOffer(t, initiator, 10*time.Second)
+}
+
+func TestSignaller_candidateAfterClose(t *testing.T) {
+	initiator, _ := newTestTrickleSignaller(t, true, "__SERVER__", "a")
+	defer initiator.Close()
+	offer := readTestOffer(t, initiator, 10*time.Second)
+
+	mediaEngine := webrtc.MediaEngine{}
+	mediaEngine.RegisterDefaultCodecs()
+	pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	responder, err := server.NewSignaller(loggerFactory, false, pc, &mediaEngine, "a", "__SERVER__")
+	require.NoError(t, err)
+	require.NoError(t, responder.Signal(toSignalMap(t, server.NewPayloadSDP("__SERVER__", offer))))
+	require.NoError(t, responder.Close())
+
+	mid := "0"
+	candidate := server.NewPayloadCandidate("__SERVER__", webrtc.ICECandidateInit{
+		Candidate: "candidate:1 1 udp 2130706431 192.168.1.2 50000 typ host",
+		SDPMid:    &mid,
+	})
+	assert.NoError(t, responder.Signal(toSignalMap(t, candidate)), "candidate is ignored")
+	// pion/ice adds candidates in a goroutine, which would panic with the
+	// agent of the closed peer connection
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestSignaller_negotiationDebounce(t *testing.T) {
+	initiator, _ := newTestTrickleSignaller(t, true, "__SERVER__", "a")
+	defer initiator.Close()
+	initiator.SetNegotiationDebounce(50 * time.Millisecond)
+
+	answerer := newTestAnswerer(t)
+	defer answerer.Close()
+
+	// the first negotiation is not delayed
+	offer := readTestOffer(t, initiator, time.Second)
+	answer := answerTestOffer(t, answerer, offer)
+	require.NoError(t, initiator.Signal(toSignalMap(t, server.NewPayloadSDP("a", answer))))
+
+	// wait for the signaling state change to stable to be handled
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		initiator.Negotiate()
+	}
+
+	offer = readTestOffer(t, initiator, time.Second)
+	answer = answerTestOffer(t, answerer, offer)
+	require.NoError(t, initiator.Signal(toSignalMap(t, server.NewPayloadSDP("a", answer))))
+
+	select {
+	case payload := <-initiator.SignalChannel():
+		_, isSDP := payload.Signal.(webrtc.SessionDescription)
+		assert.False(t, isSDP, "negotiations should have been merged into one offer")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+// readTestOffer skips candidates until an offer is received from signaller.
+func readTestOffer(t *testing.T, signaller *server.Signaller, timeout time.Duration) webrtc.SessionDescription {
+	t.Helper()
+	timer := time.After(timeout)
+	for {
+		select {
+		case payload := <-signaller.SignalChannel():
+			if sd, ok := payload.Signal.(webrtc.SessionDescription); ok {
+				require.Equal(t, webrtc.SDPTypeOffer, sd.Type)
+				return sd
+			}
+		case <-timer:
+			t.Fatal("timed out waiting for offer")
+		}
+	}
+}
+
+func newTestAnswerer(t *testing.T) *webrtc.PeerConnection {
+	t.Helper()
+	mediaEngine := webrtc.MediaEngine{}
+	mediaEngine.RegisterDefaultCodecs()
+	pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	return pc
+}
+
+func answerTestOffer(t *testing.T, pc *webrtc.PeerConnection, offer webrtc.SessionDescription) webrtc.SessionDescription {
+	t.Helper()
+	require.NoError(t, pc.SetRemoteDescription(offer))
+	answer, err := pc.CreateAnswer(nil)
+	require.NoError(t, err)
+	require.NoError(t, pc.SetLocalDescription(answer))
+	return answer
+}
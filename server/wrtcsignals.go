@@ -30,6 +30,12 @@ type Candidate struct {
 	Candidate webrtc.ICECandidateInit `json:"candidate"`
 }
 
+// EndOfCandidates returns true when the remote peer has no more candidates
+// to send.
+func (c Candidate) EndOfCandidates() bool {
+	return c.Candidate.Candidate == ""
+}
+
 type Payload struct {
 	UserID string      `json:"userId"`
 	Signal interface{} `json:"signal"`
@@ -51,6 +57,26 @@ func NewPayloadRenegotiate(userID string) Payload {
 	}
 }
 
+func NewPayloadCandidate(userID string, candidate webrtc.ICECandidateInit) Payload {
+	return Payload{
+		UserID: userID,
+		Signal: Candidate{
+			Candidate: candidate,
+		},
+	}
+}
+
+// NewPayloadEndOfCandidates creates a candidate signal with an empty
+// candidate, which browsers treat as end-of-candidates for the media section
+// identified by sdpMid.
+func NewPayloadEndOfCandidates(userID string, sdpMid string) Payload {
+	var sdpMLineIndex uint16
+	return NewPayloadCandidate(userID, webrtc.ICECandidateInit{
+		SDPMid:        &sdpMid,
+		SDPMLineIndex: &sdpMLineIndex,
+	})
+}
+
 func NewTransceiverRequest(userID string, kind webrtc.RTPCodecType, direction webrtc.RTPTransceiverDirection) Payload {
 	signal := TransceiverRequestJSON{}
 
@@ -73,6 +99,7 @@ func newCandidate(candidate interface{}) (c Candidate, err error) {
 	candidateValue, ok := candidateMap["candidate"]
 	if !ok {
 		err = fmt.Errorf("Expected candidate.candidate %#v", candidate)
+		return
 	}
 
 	candidateString, ok := candidateValue.(string)
@@ -80,28 +107,35 @@ func newCandidate(candidate interface{}) (c Candidate, err error) {
 		err = fmt.Errorf("Expected candidate.candidate to be a string: %#v", candidate)
 		return
 	}
-	sdpMLineIndexValue, ok := candidateMap["sdpMLineIndex"]
-	if !ok {
-		err = fmt.Errorf("Expected candidate.sdpMLineIndex to exist: %#v", sdpMLineIndexValue)
-		return
+	c.Candidate.Candidate = candidateString
+
+	// Either sdpMid or sdpMLineIndex identifies the media section, and both
+	// are omitted by some implementations, for example when signalling
+	// end-of-candidates.
+	if sdpMLineIndexValue, ok := candidateMap["sdpMLineIndex"]; ok && sdpMLineIndexValue != nil {
+		sdpMLineIndex, ok := sdpMLineIndexValue.(float64)
+		if !ok {
+			err = fmt.Errorf("Expected candidate.sdpMLineIndex be float64: %T", sdpMLineIndexValue)
+			return
+		}
+		sdpMLineIndexUint16 := uint16(sdpMLineIndex)
+		c.Candidate.SDPMLineIndex = &sdpMLineIndexUint16
 	}
-	sdpMLineIndex, ok := sdpMLineIndexValue.(float64)
-	if !ok {
-		err = fmt.Errorf("Expected candidate.sdpMLineIndex be float64: %T", sdpMLineIndexValue)
-		return
+
+	if sdpMidValue, ok := candidateMap["sdpMid"]; ok && sdpMidValue != nil {
+		sdpMid, ok := sdpMidValue.(string)
+		if !ok {
+			err = fmt.Errorf("Expected candidate.sdpMid to be string: %#v", candidate)
+			return
+		}
+		c.Candidate.SDPMid = &sdpMid
 	}
 
-	sdpMid, ok := candidateMap["sdpMid"].(string)
-	if !ok {
-		err = fmt.Errorf("Expected candidate.sdpMid to be string: %#v", candidate)
+	if candidateString != "" && c.Candidate.SDPMid == nil && c.Candidate.SDPMLineIndex == nil {
+		err = fmt.Errorf("Expected candidate.sdpMid or candidate.sdpMLineIndex to exist: %#v", candidate)
 		return
 	}
 
-	sdpMLineIndexUint16 := uint16(sdpMLineIndex)
-	c.Candidate.Candidate = candidateString
-	c.Candidate.SDPMLineIndex = &sdpMLineIndexUint16
-	c.Candidate.SDPMid = &sdpMid
-
 	return
 }
 
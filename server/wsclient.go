@@ -25,6 +25,7 @@ type WSReadWriter interface {
 // An abstraction for sending out to websocket using channels.
 type Client struct {
 	id         string
+	connMu     sync.Mutex
 	conn       WSReadWriter
 	metadata   string
 	serializer ByteSerializer
@@ -49,6 +50,21 @@ func NewClientWithID(conn WSReadWriter, id string) *Client {
 	}
 }
 
+func (c *Client) getConn() WSReadWriter {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return c.conn
+}
+
+// Handover replaces the connection messages are written to and read from.
+// Reads from the previous connection continue until it fails, which does
+// not end the subscription.
+func (c *Client) Handover(conn WSReadWriter) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.conn = conn
+}
+
 func (c *Client) SetMetadata(metadata string) {
 	c.metadata = metadata
 }
@@ -65,7 +81,7 @@ func (c *Client) WriteTimeout(ctx context.Context, timeout time.Duration, msg Me
 	if err != nil {
 		return fmt.Errorf("client.WriteTimeout - error serializing message: %w", err)
 	}
-	return c.conn.Write(ctx, websocket.MessageText, data)
+	return c.getConn().Write(ctx, websocket.MessageText, data)
 }
 
 func (c *Client) ID() string {
@@ -84,8 +100,8 @@ func (c *Client) Write(msg Message) error {
 	return nil
 }
 
-func (c *Client) read(ctx context.Context) (message Message, err error) {
-	typ, data, err := c.conn.Read(ctx)
+func (c *Client) read(ctx context.Context, conn WSReader) (message Message, err error) {
+	typ, data, err := conn.Read(ctx)
 	if err != nil {
 		err = fmt.Errorf("client.read - error reading data: %w", err)
 		return
@@ -111,8 +127,15 @@ func (c *Client) Subscribe(ctx context.Context) <-chan Message {
 	msgChan := make(chan Message)
 
 	go func() {
+		conn := c.getConn()
 		for {
-			message, err := c.read(ctx)
+			message, err := c.read(ctx, conn)
+			if next := c.getConn(); err != nil && ctx.Err() == nil && next != conn {
+				// the client has closed the connection it handed over, after
+				// sending all messages over it
+				conn = next
+				continue
+			}
 			if err != nil {
 				c.errMu.Lock()
 				close(msgChan)
@@ -3,8 +3,11 @@ package server
 import (
 	"context"
 	"errors"
+	"io"
 	"net/http"
-	"path"
+	"sort"
+	"sync"
+	"time"
 
 	"nhooyr.io/websocket"
 )
@@ -12,6 +15,73 @@ import (
 type WSS struct {
 	log   Logger
 	rooms RoomManager
+
+	connectionsMu sync.Mutex
+	// key is room, value is a map of clientID to connection
+	connections map[string]map[string]*wsConnection
+	// connectionCount is the number of connections in all rooms
+	connectionCount int
+	// key is room
+	lifecycles map[string]*roomLifecycle
+
+	inactivity    InactivityConfig
+	lifecycle     RoomLifecycleConfig
+	capacity      CapacityConfig
+	registry      *RoomRegistry
+	roomFeatures  RoomFeatures
+	reactions     ReactionsConfig
+	spatial       SpatialConfig
+	rateLimits    rateLimits
+	policy        *ConnectionPolicy
+	keepalive     KeepaliveConfig
+	peerRemover   PeerRemover
+	mediaActivity MediaActivity
+	clientConfig  func(host string) ClientConfigDocument
+	webhooks      *Webhooks
+	eventLog      *EventLog
+	metering      *Metering
+	recordingJobs *RecordingJobs
+	cluster       *Cluster
+	loadMonitor   *LoadMonitor
+	tunables      *RuntimeTunables
+	sfuStats      func() SFUStats
+	tenants       *Tenants
+	apiKeys       *APIKeys
+	tracer        *Tracer
+	onRoomClosed  func(room string, closed RoomClosed)
+	egress        *Egresses
+	muter         PeerMuter
+	recorder      RecordingController
+
+	// dtlsCertificates are shared by peer connections to the SFU
+	dtlsCertificates *DTLSCertificates
+}
+
+type wsConnection struct {
+	client *Client
+	// ip is the address the client connected from
+	ip          string
+	cancel      context.CancelFunc
+	connectedAt time.Time
+	// meetingID is the meeting the client joined
+	meetingID string
+	// hidden is set for egress peers, which are not visible to other clients
+	// and do not keep the room open
+	hidden bool
+
+	mu           sync.Mutex
+	lastActivity time.Time
+	reactions    *tokenBucket
+	messages     *tokenBucket
+	// rateLimited is set after a message has been dropped, until the next
+	// message is allowed
+	rateLimited bool
+	// positionSentAt is when the position of the client was last broadcast
+	positionSentAt time.Time
+	// positionTimer is set while a position is waiting to be broadcast
+	positionTimer *time.Timer
+	// rtt is the round trip time of the last websocket ping
+	rtt time.Duration
 }
 
 func NewWSS(
@@ -19,9 +89,279 @@ func NewWSS(
 	rooms RoomManager,
 ) *WSS {
 	return &WSS{
-		log:   loggerFactory.GetLogger("wss"),
-		rooms: rooms,
+		log:          loggerFactory.GetLogger("wss"),
+		rooms:        rooms,
+		connections:  map[string]map[string]*wsConnection{},
+		lifecycles:   map[string]*roomLifecycle{},
+		registry:     NewRoomRegistry(loggerFactory, NewMemoryRoomStore(), RegistryConfig{}),
+		apiKeys:      NewAPIKeys(loggerFactory, NewMemoryAPIKeyStore()),
+		roomFeatures: RoomFeaturesConfig{}.RoomFeatures(),
+	}
+}
+
+// addConnection returns ErrRoomLocked when the room is locked,
+// ErrServerFull when this instance has reached its maximum number of
+// connections, and ErrRoomCreationLimit when the IP address of the client
+// has created too many rooms. A client replacing its own connection is never
+// rejected.
+func (wss *WSS) addConnection(room string, clientID string, conn *wsConnection) *JoinError {
+	wss.connectionsMu.Lock()
+	defer wss.connectionsMu.Unlock()
+
+	if conn.hidden {
+		wss.addHiddenConnection(room, clientID, conn)
+		return nil
+	}
+
+	if joinErr := wss.checkRoomLocked(room, clientID); joinErr != nil {
+		return joinErr
+	}
+
+	_, reconnected := wss.connections[room][clientID]
+	if !reconnected {
+		if max := wss.capacity.MaxParticipants; max > 0 && wss.connectionCount >= max {
+			return ErrServerFull
+		}
+		if joinErr := wss.checkTenantLimits(room); joinErr != nil {
+			return joinErr
+		}
+		if _, ok := wss.lifecycles[room]; !ok {
+			if joinErr := wss.rateLimits.quotas.allowRoomCreation(conn.ip, time.Now()); joinErr != nil {
+				return joinErr
+			}
+		}
+		wss.connectionCount++
+	}
+
+	clients, ok := wss.connections[room]
+	if !ok {
+		clients = map[string]*wsConnection{}
+		wss.connections[room] = clients
+	}
+	clients[clientID] = conn
+	conn.meetingID = wss.enterRoom(room)
+	wss.assignRole(room, clientID)
+	wss.webhooks.Emit(WebhookPeerJoined, room, clientID, nil)
+	wss.eventLog.Emit(EventLogEntry{Type: EventLogJoin, Room: room, ClientID: clientID})
+	wss.metering.join(room, clientID)
+	return nil
+}
+
+func (wss *WSS) removeConnection(room string, clientID string, conn *wsConnection) {
+	promoted := false
+	defer func() {
+		if promoted {
+			wss.sendRoles(room)
+		}
+	}()
+
+	wss.connectionsMu.Lock()
+	defer wss.connectionsMu.Unlock()
+
+	clients := wss.connections[room]
+	// a client with the same ID might have reconnected in the meantime
+	if clients[clientID] != conn {
+		return
+	}
+
+	delete(clients, clientID)
+	if len(clients) == 0 {
+		delete(wss.connections, room)
+	}
+	if conn.hidden {
+		return
+	}
+	if l, ok := wss.lifecycles[room]; ok {
+		delete(l.positions, clientID)
 	}
+
+	wss.connectionCount--
+	wss.webhooks.Emit(WebhookPeerLeft, room, clientID, nil)
+	wss.eventLog.Emit(EventLogEntry{Type: EventLogLeave, Room: room, ClientID: clientID})
+	wss.metering.leave(room, clientID)
+	if wss.visibleConnections(room) == 0 {
+		wss.leaveRoom(room)
+		return
+	}
+	promoted = wss.promoteHost(room)
+}
+
+// sendLocal sends msg to all clients connected to the room of msg on this
+// instance. Messages of a breakout room are sent to all clients of its main
+// room.
+func (wss *WSS) sendLocal(msg Message) {
+	wss.connectionsMu.Lock()
+	connections := wss.connections[mainRoom(msg.Room)]
+	conns := make([]*wsConnection, 0, len(connections))
+	for _, conn := range connections {
+		conns = append(conns, conn)
+	}
+	wss.connectionsMu.Unlock()
+
+	for _, conn := range conns {
+		if err := conn.client.Write(msg); err != nil {
+			wss.log.Printf("Error sending %s to clientID: %s: %s", msg.Type, conn.client.ID(), err)
+		}
+	}
+}
+
+func (wss *WSS) addHiddenConnection(room string, clientID string, conn *wsConnection) {
+	clients, ok := wss.connections[room]
+	if !ok {
+		clients = map[string]*wsConnection{}
+		wss.connections[room] = clients
+	}
+	clients[clientID] = conn
+	if l, ok := wss.lifecycles[room]; ok {
+		conn.meetingID = l.meetingID
+	}
+}
+
+// isHidden returns true when clientID is connected to room as a hidden
+// egress peer.
+func (wss *WSS) isHidden(room string, clientID string) bool {
+	wss.connectionsMu.Lock()
+	defer wss.connectionsMu.Unlock()
+
+	conn, ok := wss.connections[room][clientID]
+	return ok && conn.hidden
+}
+
+// visibleConnections returns the number of connections in room, excluding
+// hidden ones. It must be called with connectionsMu held.
+func (wss *WSS) visibleConnections(room string) int {
+	count := 0
+	for _, conn := range wss.connections[room] {
+		if !conn.hidden {
+			count++
+		}
+	}
+	return count
+}
+
+// SetTracer sets the tracer which measures the join flow of clients. It must
+// be called before any connections are handled.
+func (wss *WSS) SetTracer(tracer *Tracer) {
+	wss.tracer = tracer
+}
+
+// SetWebhooks sets the webhooks room and peer lifecycle events are sent to.
+// It must be called before any connections are handled.
+func (wss *WSS) SetWebhooks(webhooks *Webhooks) {
+	wss.webhooks = webhooks
+}
+
+// SetEventLog sets the log joins, leaves and moderation of clients are
+// recorded to. It must be called before any connections are handled.
+func (wss *WSS) SetEventLog(eventLog *EventLog) {
+	wss.eventLog = eventLog
+}
+
+// EventLog returns the log set with SetEventLog, or nil when room events are
+// not recorded.
+func (wss *WSS) EventLog() *EventLog {
+	return wss.eventLog
+}
+
+// SetMetering sets the Metering the time clients spend in rooms is counted
+// by. It must be called before any connections are handled.
+func (wss *WSS) SetMetering(metering *Metering) {
+	wss.metering = metering
+}
+
+// Metering returns the Metering set with SetMetering, or nil when usage is
+// not metered.
+func (wss *WSS) Metering() *Metering {
+	return wss.metering
+}
+
+// LocalClientIDs returns sorted IDs of clients in room that are connected to
+// this instance.
+func (wss *WSS) LocalClientIDs(room string) []string {
+	wss.connectionsMu.Lock()
+	defer wss.connectionsMu.Unlock()
+
+	clientIDs := make([]string, 0, len(wss.connections[room]))
+	for clientID, conn := range wss.connections[room] {
+		if !conn.hidden {
+			clientIDs = append(clientIDs, clientID)
+		}
+	}
+	sort.Strings(clientIDs)
+	return clientIDs
+}
+
+// LocalRooms returns sorted names of rooms with clients connected to this
+// instance.
+func (wss *WSS) LocalRooms() []string {
+	wss.connectionsMu.Lock()
+	defer wss.connectionsMu.Unlock()
+
+	rooms := make([]string, 0, len(wss.connections))
+	for room := range wss.connections {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	return rooms
+}
+
+// LocalClients returns connection times of clients in room that are
+// connected to this instance, keyed by clientID. Hidden egress peers are
+// not included.
+func (wss *WSS) LocalClients(room string) map[string]time.Time {
+	wss.connectionsMu.Lock()
+	defer wss.connectionsMu.Unlock()
+
+	clients := make(map[string]time.Time, len(wss.connections[room]))
+	for clientID, conn := range wss.connections[room] {
+		if !conn.hidden {
+			clients[clientID] = conn.connectedAt
+		}
+	}
+	return clients
+}
+
+// Disconnect closes the websocket connection of clientID in room. Returns
+// false when the client is not connected to this instance.
+func (wss *WSS) Disconnect(room string, clientID string) bool {
+	wss.connectionsMu.Lock()
+	conn, ok := wss.connections[room][clientID]
+	wss.connectionsMu.Unlock()
+
+	if ok {
+		wss.log.Printf("Disconnecting room: %s, clientID: %s", room, clientID)
+		conn.cancel()
+	}
+	return ok
+}
+
+// kick disconnects clientID like Disconnect, and records that it was kicked
+// by actorID before its leave is recorded.
+func (wss *WSS) kick(room string, clientID string, actorID string) bool {
+	wss.connectionsMu.Lock()
+	_, ok := wss.connections[room][clientID]
+	wss.connectionsMu.Unlock()
+
+	if !ok {
+		return false
+	}
+	wss.eventLog.Emit(EventLogEntry{Type: EventLogKick, Room: room, ClientID: clientID, ActorID: actorID})
+	return wss.Disconnect(room, clientID)
+}
+
+// Handover moves the connection of clientID in room to conn, after which its
+// websocket can be closed without leaving the room. Returns false when the
+// client is not connected to this instance.
+func (wss *WSS) Handover(room string, clientID string, conn WSReadWriter) bool {
+	wss.connectionsMu.Lock()
+	wsConn, ok := wss.connections[room][clientID]
+	wss.connectionsMu.Unlock()
+
+	if ok {
+		wss.log.Printf("Handing over connection of room: %s, clientID: %s", room, clientID)
+		wsConn.client.Handover(conn)
+	}
+	return ok
 }
 
 type RoomEvent struct {
@@ -42,36 +382,118 @@ func (wss *WSS) HandleRoom(w http.ResponseWriter, r *http.Request, handleMessage
 }
 
 func (wss *WSS) HandleRoomWithCleanup(w http.ResponseWriter, r *http.Request, handleMessage func(RoomEvent), cleanup func(CleanupEvent)) {
-	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		CompressionMode: websocket.CompressionDisabled,
-	})
-	if err != nil {
-		wss.log.Printf("Error accepting websocket connection: %s", err)
-		return
-	}
+	wss.WebSocketHandler(SignalingHandlerFunc(func(conn SignalingConn, req SignalingRequest) {
+		wss.ServeRoom(conn, req, handleMessage, cleanup)
+	})).ServeHTTP(w, r)
+}
 
-	clientID := path.Base(r.URL.Path)
-	room := path.Base(path.Dir(r.URL.Path))
+// ServeRoom joins the client of req to its room and handles its messages
+// until conn fails or the client is disconnected. The transport of conn is
+// closed on return.
+func (wss *WSS) ServeRoom(c SignalingConn, req SignalingRequest, handleMessage func(RoomEvent), cleanup func(CleanupEvent)) {
+	clientID := req.ClientID
+	room := req.Room
 
 	defer func() {
-		wss.log.Printf("Closing websocket connection room: %s, clientID: %s", room, clientID)
-		c.Close(websocket.StatusInternalError, "")
+		wss.log.Printf("Closing signaling connection room: %s, clientID: %s", room, clientID)
+		c.Close(nil)
 	}()
-	ctx := r.Context()
+	ctx, cancel := context.WithCancel(req.context())
+	defer cancel()
 
 	client := NewClientWithID(c, clientID)
-	wss.log.Printf("New websocket connection - room: %s, clientID: %s", room, clientID)
+
+	ip := req.IP
+	if joinErr := wss.allowJoin(ip); joinErr != nil {
+		wss.rejectJoin(c, client, room, joinErr)
+		return
+	}
+	if joinErr := wss.ConnectionPolicy().Allow(room, ip); joinErr != nil {
+		wss.rejectJoin(c, client, room, joinErr)
+		return
+	}
+
+	span := wss.tracer.StartJoin(room, clientID, req.TraceParent)
+	defer wss.tracer.Leave(clientID, span)
+	defer span.End()
 
 	adapter := wss.rooms.Enter(room)
 	defer func() {
 		wss.log.Printf("wss.rooms.Exit room: %s, clientID: %s", room, clientID)
 		wss.rooms.Exit(room)
 	}()
-	err = adapter.Add(client)
+
+	hidden := wss.egress.Authorize(room, clientID, req.EgressToken)
+	if !hidden {
+		release, joinErr := wss.rateLimits.quotas.acquireConnection(ip, time.Now())
+		if joinErr != nil {
+			span.SetError(joinErr)
+			wss.rejectJoin(c, client, room, joinErr)
+			return
+		}
+		defer release()
+
+		registered, joinErr := wss.registry.authorize(room, req.Password, time.Now())
+		if joinErr != nil {
+			span.SetError(joinErr)
+			wss.rejectJoin(c, client, room, joinErr)
+			return
+		}
+		if joinErr := wss.checkRoomCapacity(adapter, room, clientID, registered.MaxParticipants); joinErr != nil {
+			span.SetError(joinErr)
+			wss.rejectJoin(c, client, room, joinErr)
+			return
+		}
+	}
+
+	conn := &wsConnection{
+		client:       client,
+		ip:           ip,
+		cancel:       cancel,
+		connectedAt:  time.Now(),
+		lastActivity: time.Now(),
+		hidden:       hidden,
+	}
+	if joinErr := wss.addConnection(room, clientID, conn); joinErr != nil {
+		span.SetError(joinErr)
+		wss.rejectJoin(c, client, room, joinErr)
+		return
+	}
+	defer wss.removeConnection(room, clientID, conn)
+
+	wss.log.Printf("New signaling connection - room: %s, clientID: %s", room, clientID)
+
+	if wss.clientConfig != nil {
+		ack := NewMessageJoinAck(room, clientID, conn.meetingID, wss.clientConfig(req.Host))
+		ack.TraceParent = span.TraceParent()
+		err := client.Write(ack)
+		if err != nil {
+			wss.log.Printf("Error sending join ack: %s", err)
+			return
+		}
+	}
+
+	if err := wss.sendRoomFeatures(client, room); err != nil {
+		wss.log.Printf("Error sending room features: %s", err)
+		return
+	}
+
+	if err := wss.sendPositions(client, room); err != nil {
+		wss.log.Printf("Error sending positions: %s", err)
+		return
+	}
+
+	err := adapter.Add(client)
 	if err != nil {
+		span.SetError(err)
 		wss.log.Printf("Error adding client to room: %s", err)
 		return
 	}
+	span.End()
+
+	if !hidden {
+		wss.sendRoles(room)
+	}
 
 	if cleanup != nil {
 		defer cleanup(CleanupEvent{
@@ -89,9 +511,23 @@ func (wss *WSS) HandleRoomWithCleanup(w http.ResponseWriter, r *http.Request, ha
 		}
 	}()
 
+	go wss.monitorInactivity(ctx, room, client, conn)
+	if ws, ok := c.(websocketSignalingConn); ok {
+		go wss.monitorKeepalive(ctx, room, ws, conn)
+	}
+
 	msgChan := client.Subscribe(ctx)
 
 	for message := range msgChan {
+		if !wss.allowMessage(conn, room, ip) {
+			continue
+		}
+		conn.touch()
+		if wss.handleReaction(adapter, room, clientID, conn, message) ||
+			wss.handlePosition(adapter, room, clientID, conn, message) ||
+			wss.handleModeration(adapter, room, clientID, message) {
+			continue
+		}
 		handleMessage(RoomEvent{
 			ClientID: clientID,
 			Room:     room,
@@ -101,7 +537,7 @@ func (wss *WSS) HandleRoomWithCleanup(w http.ResponseWriter, r *http.Request, ha
 	}
 	err = client.Err()
 
-	if errors.Is(err, context.Canceled) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, io.EOF) {
 		return
 	}
 	if websocket.CloseStatus(err) == websocket.StatusNormalClosure ||
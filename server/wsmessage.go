@@ -7,6 +7,7 @@ import (
 const (
 	MessageTypeRoomJoin  string = "ws_room_join"
 	MessageTypeRoomLeave string = "ws_room_leave"
+	MessageTypeJoinAck   string = "ws_join_ack"
 )
 
 type Serializer interface {
@@ -26,6 +27,9 @@ type Message struct {
 	Room string `json:"room"`
 	// Payload content
 	Payload interface{} `json:"payload"`
+	// TraceParent is the W3C traceparent of the span the message belongs to,
+	// when tracing is enabled
+	TraceParent string `json:"traceparent,omitempty"`
 }
 
 func NewMessage(typ string, room string, payload interface{}) Message {
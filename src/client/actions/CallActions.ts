@@ -1,10 +1,11 @@
 import { GetAsyncAction, makeAction } from '../async'
-import { DIAL, HANG_UP, SOCKET_EVENT_USERS, SOCKET_EVENT_HANG_UP, SOCKET_CONNECTED, SOCKET_DISCONNECTED } from '../constants'
+import { DIAL, HANG_UP, SOCKET_EVENT_USERS, SOCKET_EVENT_HANG_UP, SOCKET_EVENT_JOIN_ERROR, SOCKET_EVENT_RATE_LIMITED, SOCKET_EVENT_REACTION, SOCKET_EVENT_HAND_RAISE, SOCKET_EVENT_HAND_LOWER, SOCKET_EVENT_SET_ROLE, SOCKET_EVENT_KICK_PEER, SOCKET_EVENT_MUTE_PEER, SOCKET_EVENT_START_RECORDING, SOCKET_EVENT_STOP_RECORDING, SOCKET_EVENT_LOCK_ROOM, SOCKET_EVENT_UNLOCK_ROOM, SOCKET_CONNECTED, SOCKET_DISCONNECTED } from '../constants'
 import socket from '../socket'
 import store, { ThunkResult } from '../store'
 import { callId, userId } from '../window'
 import * as NotifyActions from './NotifyActions'
 import * as SocketActions from './SocketActions'
+import { Role } from '../../shared'
 
 export interface ConnectedAction {
   type: 'SOCKET_CONNECTED'
@@ -33,6 +34,13 @@ export const init = (): ThunkResult<Promise<void>> => async dispatch => {
       dispatch(NotifyActions.error('Server socket disconnected'))
       dispatch(disconnected())
     })
+    socket.on(SOCKET_EVENT_JOIN_ERROR, ({ message }) => {
+      dispatch(NotifyActions.error(message))
+    })
+    socket.on(SOCKET_EVENT_RATE_LIMITED, () => {
+      dispatch(NotifyActions.warning(
+        'You are sending too many messages, some were dropped'))
+    })
   })
 }
 
@@ -67,4 +75,47 @@ export const hangUp = (): HangUpAction => {
   }
 }
 
+// The server drops reactions and hand raises sent too often.
+export const sendReaction = (reaction: string) => {
+  socket.emit(SOCKET_EVENT_REACTION, { reaction })
+}
+
+export const raiseHand = () => {
+  socket.emit(SOCKET_EVENT_HAND_RAISE, {})
+}
+
+export const lowerHand = () => {
+  socket.emit(SOCKET_EVENT_HAND_LOWER, {})
+}
+
+// Moderation actions are only performed when the server allows them for the
+// role of this client, otherwise a moderationError is received.
+export const setRole = (targetId: string, role: Role) => {
+  socket.emit(SOCKET_EVENT_SET_ROLE, { userId: targetId, role })
+}
+
+export const kickPeer = (targetId: string) => {
+  socket.emit(SOCKET_EVENT_KICK_PEER, { userId: targetId })
+}
+
+export const mutePeer = (targetId: string, muted: boolean) => {
+  socket.emit(SOCKET_EVENT_MUTE_PEER, { userId: targetId, muted })
+}
+
+export const startRecording = () => {
+  socket.emit(SOCKET_EVENT_START_RECORDING, undefined)
+}
+
+export const stopRecording = () => {
+  socket.emit(SOCKET_EVENT_STOP_RECORDING, undefined)
+}
+
+export const lockRoom = () => {
+  socket.emit(SOCKET_EVENT_LOCK_ROOM, undefined)
+}
+
+export const unlockRoom = () => {
+  socket.emit(SOCKET_EVENT_UNLOCK_ROOM, undefined)
+}
+
 export type DialAction = GetAsyncAction<ReturnType<typeof dial>>
@@ -0,0 +1,44 @@
+import { CONNECTION_STATE_SET, CONNECTION_STATE_REMOVE } from '../constants'
+import { PeerConnectionState } from '../../shared'
+
+export type ConnectionStates = Record<string, PeerConnectionState>
+
+export interface ConnectionStateSetPayload {
+  userId: string
+  state: PeerConnectionState
+}
+
+export interface ConnectionStateSetAction {
+  type: 'CONNECTION_STATE_SET'
+  payload: ConnectionStateSetPayload
+}
+
+export function setConnectionState(
+  payload: ConnectionStateSetPayload,
+): ConnectionStateSetAction {
+  return {
+    type: CONNECTION_STATE_SET,
+    payload,
+  }
+}
+
+export interface ConnectionStateRemovePayload {
+  userId: string
+}
+
+export interface ConnectionStateRemoveAction {
+  type: 'CONNECTION_STATE_REMOVE'
+  payload: ConnectionStateRemovePayload
+}
+
+export function removeConnectionState(
+  payload: ConnectionStateRemovePayload,
+): ConnectionStateRemoveAction {
+  return {
+    type: CONNECTION_STATE_REMOVE,
+    payload,
+  }
+}
+
+export type ConnectionStateActions =
+  ConnectionStateSetAction | ConnectionStateRemoveAction
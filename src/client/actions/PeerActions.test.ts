@@ -9,6 +9,7 @@ import { createStore, Store, GetState } from '../store'
 import { Dispatch } from 'redux'
 import { ClientSocket } from '../socket'
 import { PEERCALLS, PEER_EVENT_DATA, HANG_UP } from '../constants'
+import { setRoomFeatures } from './RoomFeatureActions'
 
 describe('PeerActions', () => {
   function createSocket () {
@@ -180,6 +181,21 @@ describe('PeerActions', () => {
       .toEqual([[ '{"payload":"test","type":"text"}' ]])
     })
 
+    it('does not send messages when chat is disabled', () => {
+      dispatch(setRoomFeatures({
+        chat: false,
+        recording: true,
+        e2ee: false,
+        maxVideoWidth: 0,
+        maxVideoHeight: 0,
+      }))
+      PeerActions.sendMessage({ payload: 'test', type: 'text' })(
+        dispatch, getState)
+      const { peers } = store.getState()
+      expect((peers['user2'].send as jest.Mock).mock.calls).toEqual([])
+      expect(store.getState().messages.list).toEqual([])
+    })
+
   })
 
   describe('receive message (handleData)', () => {
@@ -213,5 +229,24 @@ describe('PeerActions', () => {
       }])
     })
 
+    it('shows final transcripts', () => {
+      const transcript = (text: string, final: boolean) => {
+        peer.emit(PEER_EVENT_DATA, JSON.stringify({
+          type: 'transcription',
+          userId: 'user3',
+          payload: { trackId: 'audio', text, final },
+        }))
+      }
+      transcript('hel', false)
+      transcript('hello', true)
+      expect(store.getState().messages.list.slice(1))
+      .toEqual([{
+        message: 'hello',
+        userId: 'user3',
+        image: undefined,
+        timestamp: jasmine.any(String),
+      }])
+    })
+
   })
 })
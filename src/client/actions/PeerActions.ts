@@ -21,6 +21,7 @@ export interface PeerHandlerOptions {
   user: { id: string }
   dispatch: Dispatch
   getState: GetState
+  signalingDataChannel?: boolean
 }
 
 class PeerHandler {
@@ -66,6 +67,27 @@ class PeerHandler {
         peer.addTrack(track, s.stream)
       })
     })
+
+    if (this.options.signalingDataChannel) {
+      this.handoverSignaling(peer)
+    }
+  }
+  // handoverSignaling moves the socket to a data channel of the peer
+  // connection with the server, so that the websocket can be closed.
+  handoverSignaling = (peer: Peer.Instance) => {
+    const { socket, user } = this
+    if (!socket.handover) return
+    debug('peer: %s, opening signaling data channel', user.id)
+    // simple-peer does not expose the RTCPeerConnection
+    // eslint-disable-next-line @typescript-eslint/no-explicit-any
+    const pc: RTCPeerConnection = (peer as any)._pc
+    const channel = pc.createDataChannel(constants.SIGNALING_DATA_CHANNEL_NAME, {
+      negotiated: true,
+      id: constants.SIGNALING_DATA_CHANNEL_ID,
+    })
+    channel.addEventListener('open', () => {
+      socket.handover && socket.handover(channel)
+    })
   }
   handleTrack = (track: MediaStreamTrack, stream: MediaStream) => {
     const { user, dispatch } = this
@@ -107,6 +129,18 @@ class PeerHandler {
           image: message.payload.data,
         }))
         break
+      case 'transcription':
+        // interim results are replaced by later ones, so only final
+        // transcripts are shown
+        if (message.payload.final) {
+          dispatch(ChatActions.addMessage({
+            userId: message.userId || user.id,
+            message: message.payload.text,
+            timestamp: new Date().toLocaleString(),
+            image: undefined,
+          }))
+        }
+        break
       default:
         dispatch(ChatActions.addMessage({
           userId: message.userId || user.id,
@@ -133,6 +167,7 @@ export interface CreatePeerOptions {
   user: { id: string }
   initiator: boolean
   stream?: MediaStream
+  signalingDataChannel?: boolean
 }
 
 /**
@@ -144,7 +179,7 @@ export interface CreatePeerOptions {
  * @param {MediaStream} [options.stream]
  */
 export function createPeer (options: CreatePeerOptions) {
-  const { socket, user, initiator, stream } = options
+  const { socket, user, initiator, stream, signalingDataChannel } = options
 
   return (dispatch: Dispatch, getState: GetState) => {
     const userId = user.id
@@ -164,7 +199,10 @@ export function createPeer (options: CreatePeerOptions) {
       initiator,
       config: { iceServers },
       channelName: constants.PEER_DATA_CHANNEL_NAME,
-      trickle: false,
+      // Send ICE candidates as they are gathered instead of waiting for all
+      // of them. Remote candidates received before the remote description
+      // are buffered by simple-peer.
+      trickle: true,
       // Allow the peer to receive video, even if it's not sending stream:
       // https://github.com/feross/simple-peer/issues/95
       offerConstraints: {
@@ -179,6 +217,7 @@ export function createPeer (options: CreatePeerOptions) {
       user,
       dispatch,
       getState,
+      signalingDataChannel,
     })
 
     peer.once(constants.PEER_EVENT_ERROR, handler.handleError)
@@ -246,7 +285,11 @@ function sendData(peer: Peer.Instance, message: Message) {
 
 export const sendMessage = (message: Message) =>
 (dispatch: Dispatch, getState: GetState) => {
-  const { peers } = getState()
+  const { peers, roomFeatures } = getState()
+  if (!roomFeatures.chat) {
+    dispatch(NotifyActions.error('Chat is disabled in this room'))
+    return
+  }
   debug('Sending message type: %s to %s peers.',
     message.type, Object.keys(peers).length)
   switch (message.type) {
@@ -0,0 +1,16 @@
+import { ROLES_SET } from '../constants'
+import { Role } from '../../shared'
+
+export type Roles = Record<string, Role>
+
+export interface RolesSetAction {
+  type: 'ROLES_SET'
+  payload: Roles
+}
+
+export function setRoles(payload: Roles): RolesSetAction {
+  return {
+    type: ROLES_SET,
+    payload,
+  }
+}
@@ -0,0 +1,14 @@
+import { ROOM_FEATURES_SET } from '../constants'
+import { RoomFeatures } from '../../shared'
+
+export interface RoomFeaturesSetAction {
+  type: 'ROOM_FEATURES_SET'
+  payload: RoomFeatures
+}
+
+export function setRoomFeatures(payload: RoomFeatures): RoomFeaturesSetAction {
+  return {
+    type: ROOM_FEATURES_SET,
+    payload,
+  }
+}
@@ -67,6 +67,23 @@ describe('SocketActions', () => {
         expect((instances[0].destroy as jest.Mock).mock.calls.length).toBe(0)
         expect((instances[1].destroy as jest.Mock).mock.calls.length).toBe(0)
       })
+
+      it('closes direct peers and sends ready again', () => {
+        let ready: SocketEvent['ready'] | undefined
+        socket.once(constants.SOCKET_EVENT_READY, payload => {
+          ready = payload
+        })
+        socket.emit(constants.SOCKET_EVENT_USERS, {
+          peerIds: ['__SERVER__'],
+          initiator: '__SERVER__',
+          nicknames,
+          closePeerIds: [peerA, peerB, peerC],
+        })
+
+        expect((instances[0].destroy as jest.Mock).mock.calls.length).toBe(1)
+        expect(ready).toEqual({ room: roomName, nickname, userId })
+        expect(instances.length).toBe(2)
+      })
     })
 
     describe('signal', () => {
@@ -101,6 +118,156 @@ describe('SocketActions', () => {
         expect((instances[0].signal as jest.Mock).mock.calls.length).toBe(0)
       })
     })
+
+    describe('chatHistory', () => {
+      afterEach(() => {
+        SocketActions.removeEventListeners(socket)
+      })
+
+      it('adds messages sent before joining', () => {
+        SocketActions.handshake({ nickname, socket, roomName, userId, store })
+        socket.emit(constants.SOCKET_EVENT_CHAT_HISTORY, {
+          messages: [{
+            userId: peerB,
+            message: 'hello',
+            timestamp: '2020-05-01T10:00:00Z',
+          }],
+        })
+        expect(store.getState().messages.list).toEqual([{
+          userId: peerB,
+          message: 'hello',
+          timestamp: new Date('2020-05-01T10:00:00Z').toLocaleString(),
+          image: undefined,
+        }])
+      })
+    })
+
+    describe('reactions', () => {
+      afterEach(() => {
+        SocketActions.removeEventListeners(socket)
+      })
+
+      it('notifies about reactions and raised hands', () => {
+        SocketActions.handshake({ nickname, socket, roomName, userId, store })
+        store.dispatch(NicknameActions.setNicknames(nicknames))
+        socket.emit(constants.SOCKET_EVENT_REACTION, {
+          userId: peerB,
+          reaction: '👍',
+        })
+        socket.emit(constants.SOCKET_EVENT_HAND_RAISE, { userId: peerC })
+        const messages = Object.values(store.getState().notifications)
+        .map(n => n.message)
+        expect(messages).toEqual([
+          'user two: 👍',
+          'user three raised their hand',
+        ])
+      })
+    })
+
+    describe('roles', () => {
+      afterEach(() => {
+        SocketActions.removeEventListeners(socket)
+      })
+
+      it('sets roles and notifies about moderation', () => {
+        SocketActions.handshake({ nickname, socket, roomName, userId, store })
+        store.dispatch(NicknameActions.setNicknames(nicknames))
+        const roles = { [peerB]: 'host', [userId]: 'guest' } as const
+        socket.emit(constants.SOCKET_EVENT_ROLES, { roles })
+        expect(store.getState().roles).toEqual(roles)
+        socket.emit(constants.SOCKET_EVENT_MODERATION, {
+          action: constants.SOCKET_EVENT_MUTE_PEER,
+          userId: peerB,
+          targetId: userId,
+          muted: true,
+        })
+        socket.emit(constants.SOCKET_EVENT_MODERATION_ERROR, {
+          action: constants.SOCKET_EVENT_KICK_PEER,
+          error: 'permission denied',
+        })
+        socket.emit(constants.SOCKET_EVENT_ROOM_LOCK, { locked: true })
+        const messages = Object.values(store.getState().notifications)
+        .map(n => n.message)
+        expect(messages).toEqual([
+          'You were muted by user two',
+          'kickPeer failed: permission denied',
+          'The room has been locked',
+        ])
+      })
+    })
+
+    describe('peerConnectionState', () => {
+      afterEach(() => {
+        SocketActions.removeEventListeners(socket)
+      })
+
+      it('keeps connection states of peers until they hang up', () => {
+        SocketActions.handshake({ nickname, socket, roomName, userId, store })
+        socket.emit(constants.SOCKET_EVENT_PEER_CONNECTION_STATE, {
+          userId: peerB,
+          state: 'reconnecting',
+        })
+        socket.emit(constants.SOCKET_EVENT_PEER_CONNECTION_STATE, {
+          userId: peerC,
+          state: 'connected',
+        })
+        expect(store.getState().connectionStates).toEqual({
+          [peerB]: 'reconnecting',
+          [peerC]: 'connected',
+        })
+        socket.emit(constants.SOCKET_EVENT_HANG_UP, { userId: peerB })
+        expect(store.getState().connectionStates).toEqual({
+          [peerC]: 'connected',
+        })
+      })
+    })
+
+    describe('inactivityWarning', () => {
+      afterEach(() => {
+        SocketActions.removeEventListeners(socket)
+      })
+
+      it('warns before the client is disconnected', () => {
+        SocketActions.handshake({ nickname, socket, roomName, userId, store })
+        socket.emit(constants.SOCKET_EVENT_INACTIVITY_WARNING, {
+          disconnectIn: 29.5,
+        })
+        const messages = Object.values(store.getState().notifications)
+        .map(n => n.message)
+        expect(messages).toEqual([
+          'You will be disconnected for inactivity in 30 seconds',
+        ])
+      })
+    })
+
+    describe('visibilitychange', () => {
+      const setHidden = (hidden: boolean) => {
+        Object.defineProperty(document, 'hidden', {
+          configurable: true,
+          get: () => hidden,
+        })
+        document.dispatchEvent(new Event('visibilitychange'))
+      }
+      afterEach(() => {
+        SocketActions.removeEventListeners(socket)
+        delete (document as any).hidden
+      })
+
+      it('pauses video while the document is hidden', () => {
+        SocketActions.handshake({ nickname, socket, roomName, userId, store })
+        const payloads: SocketEvent['videoPaused'][] = []
+        socket.on(constants.SOCKET_EVENT_VIDEO_PAUSED, payload => {
+          payloads.push(payload)
+        })
+
+        setHidden(true)
+        setHidden(false)
+        SocketActions.removeEventListeners(socket)
+        setHidden(true)
+
+        expect(payloads).toEqual([{ paused: true }, { paused: false }])
+      })
+    })
   })
 
   describe('peer events', () => {
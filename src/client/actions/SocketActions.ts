@@ -1,3 +1,4 @@
+import * as ChatActions from '../actions/ChatActions'
 import * as NotifyActions from '../actions/NotifyActions'
 import * as PeerActions from '../actions/PeerActions'
 import * as constants from '../constants'
@@ -6,6 +7,9 @@ import { Store, Dispatch, GetState } from '../store'
 import { ClientSocket } from '../socket'
 import { SocketEvent } from '../../shared'
 import { setNicknames, removeNickname } from './NicknameActions'
+import { setRoles } from './RoleActions'
+import { setRoomFeatures } from './RoomFeatureActions'
+import { setConnectionState, removeConnectionState } from './ConnectionStateActions'
 
 const debug = _debug('peercalls')
 const sdpDebug = _debug('peercalls:sdp')
@@ -13,6 +17,7 @@ const sdpDebug = _debug('peercalls:sdp')
 export interface SocketHandlerOptions {
   socket: ClientSocket
   roomName: string
+  nickname: string
   stream?: MediaStream
   dispatch: Dispatch
   getState: GetState
@@ -22,6 +27,7 @@ export interface SocketHandlerOptions {
 class SocketHandler {
   socket: ClientSocket
   roomName: string
+  nickname: string
   stream?: MediaStream
   dispatch: Dispatch
   getState: GetState
@@ -30,6 +36,7 @@ class SocketHandler {
   constructor (options: SocketHandlerOptions) {
     this.socket = options.socket
     this.roomName = options.roomName
+    this.nickname = options.nickname
     this.stream = options.stream
     this.dispatch = options.dispatch
     this.getState = options.getState
@@ -47,11 +54,106 @@ class SocketHandler {
     const { dispatch } = this
     debug('socket hangUp, userId: %s', userId)
     dispatch(removeNickname({ userId }))
+    dispatch(removeConnectionState({ userId }))
+  }
+  handleChatHistory = ({ messages }: SocketEvent['chatHistory']) => {
+    const { dispatch } = this
+    debug('socket chat history: %d messages', messages.length)
+    messages.forEach(message => dispatch(ChatActions.addMessage({
+      userId: message.userId,
+      message: message.message,
+      timestamp: new Date(message.timestamp).toLocaleString(),
+      image: undefined,
+    })))
+  }
+  getNickname = (userId = '') => {
+    return this.getState().nicknames[userId] || userId
+  }
+  handleReaction = ({ userId, reaction }: SocketEvent['reaction']) => {
+    this.dispatch(NotifyActions.info('{0}: {1}', this.getNickname(userId), reaction))
+  }
+  handleHandRaise = ({ userId }: SocketEvent['handRaise']) => {
+    this.dispatch(NotifyActions.info(
+      '{0} raised their hand', this.getNickname(userId)))
+  }
+  handleHandLower = ({ userId }: SocketEvent['handLower']) => {
+    this.dispatch(NotifyActions.info(
+      '{0} lowered their hand', this.getNickname(userId)))
+  }
+  handleBreakout = ({ name }: SocketEvent['breakout']) => {
+    debug('socket breakout room: %s', name)
+    this.dispatch(name
+      ? NotifyActions.info('Moved to breakout room: {0}', name)
+      : NotifyActions.info('Returned to main room'))
+  }
+  handleRoomFeatures = ({ features }: SocketEvent['roomFeatures']) => {
+    debug('socket room features: %o', features)
+    this.dispatch(setRoomFeatures(features))
+  }
+  handleRoles = ({ roles }: SocketEvent['roles']) => {
+    debug('socket roles: %o', roles)
+    this.dispatch(setRoles(roles))
   }
-  handleUsers = ({ initiator, peerIds, nicknames }: SocketEvent['users']) => {
+  handleModeration = (
+    { action, userId, targetId, muted }: SocketEvent['moderation'],
+  ) => {
+    debug('socket moderation: %s by %s of %s', action, userId, targetId)
+    if (targetId !== this.userId) return
+    if (action === constants.SOCKET_EVENT_MUTE_PEER) {
+      this.dispatch(NotifyActions.warning(muted
+        ? 'You were muted by {0}'
+        : 'You were unmuted by {0}', this.getNickname(userId)))
+    }
+  }
+  handleRoomLock = ({ locked }: SocketEvent['roomLock']) => {
+    this.dispatch(NotifyActions.info(locked
+      ? 'The room has been locked'
+      : 'The room has been unlocked'))
+  }
+  handlePeerConnectionState = (
+    { userId, state }: SocketEvent['peerConnectionState'],
+  ) => {
+    debug('socket peer connection state: %s %s', userId, state)
+    this.dispatch(setConnectionState({ userId, state }))
+  }
+  handleModerationError = (
+    { action, error }: SocketEvent['moderationError'],
+  ) => {
+    this.dispatch(NotifyActions.error('{0} failed: {1}', action, error))
+  }
+  handleInactivityWarning = (
+    { disconnectIn }: SocketEvent['inactivityWarning'],
+  ) => {
+    this.dispatch(NotifyActions.warning(
+      'You will be disconnected for inactivity in {0} seconds',
+      Math.ceil(disconnectIn)))
+  }
+  handleUsers = (
+    {
+      initiator,
+      peerIds,
+      nicknames,
+      closePeerIds,
+      signalingDataChannel,
+    }: SocketEvent['users'],
+  ) => {
     const { socket, stream, dispatch, getState } = this
     debug('socket remote peerIds: %o', peerIds)
 
+    const directPeers = getState().peers
+    const closed = (closePeerIds || [])
+    .filter(peerId => directPeers[peerId] && peerId !== this.userId)
+    if (closed.length) {
+      debug('closing direct peers: %o', closed)
+      closed.forEach(peerId => directPeers[peerId].destroy())
+      // the server only connects to peers which are ready
+      socket.emit(constants.SOCKET_EVENT_READY, {
+        room: this.roomName,
+        nickname: this.nickname,
+        userId: this.userId,
+      })
+    }
+
     this.dispatch(NotifyActions.info(
       'Connected users: {0}', Object.keys(nicknames).length))
     const { peers } = this.getState()
@@ -71,10 +173,23 @@ class SocketHandler {
       },
       initiator: isInitiator,
       stream,
+      signalingDataChannel,
     })(dispatch, getState))
   }
 }
 
+let handleVisibilityChange: (() => void) | undefined
+
+function addVisibilityListener (socket: ClientSocket) {
+  handleVisibilityChange = () => {
+    debug('document hidden: %s', document.hidden)
+    socket.emit(constants.SOCKET_EVENT_VIDEO_PAUSED, {
+      paused: document.hidden,
+    })
+  }
+  document.addEventListener('visibilitychange', handleVisibilityChange)
+}
+
 export interface HandshakeOptions {
   socket: ClientSocket
   store: Store
@@ -90,6 +205,7 @@ export function handshake (options: HandshakeOptions) {
   const handler = new SocketHandler({
     socket,
     roomName,
+    nickname,
     stream,
     dispatch: store.dispatch,
     getState: store.getState,
@@ -102,6 +218,24 @@ export function handshake (options: HandshakeOptions) {
   socket.on(constants.SOCKET_EVENT_SIGNAL, handler.handleSignal)
   socket.on(constants.SOCKET_EVENT_USERS, handler.handleUsers)
   socket.on(constants.SOCKET_EVENT_HANG_UP, handler.handleHangUp)
+  socket.on(constants.SOCKET_EVENT_CHAT_HISTORY, handler.handleChatHistory)
+  socket.on(constants.SOCKET_EVENT_REACTION, handler.handleReaction)
+  socket.on(constants.SOCKET_EVENT_HAND_RAISE, handler.handleHandRaise)
+  socket.on(constants.SOCKET_EVENT_HAND_LOWER, handler.handleHandLower)
+  socket.on(constants.SOCKET_EVENT_BREAKOUT, handler.handleBreakout)
+  socket.on(constants.SOCKET_EVENT_ROOM_FEATURES, handler.handleRoomFeatures)
+  socket.on(constants.SOCKET_EVENT_ROLES, handler.handleRoles)
+  socket.on(constants.SOCKET_EVENT_MODERATION, handler.handleModeration)
+  socket.on(constants.SOCKET_EVENT_ROOM_LOCK, handler.handleRoomLock)
+  socket.on(
+    constants.SOCKET_EVENT_MODERATION_ERROR, handler.handleModerationError)
+  socket.on(
+    constants.SOCKET_EVENT_PEER_CONNECTION_STATE,
+    handler.handlePeerConnectionState,
+  )
+  socket.on(
+    constants.SOCKET_EVENT_INACTIVITY_WARNING, handler.handleInactivityWarning)
+  addVisibilityListener(socket)
 
   debug('userId: %s', userId)
   socket.emit(constants.SOCKET_EVENT_READY, {
@@ -115,4 +249,20 @@ export function removeEventListeners (socket: ClientSocket) {
   socket.removeAllListeners(constants.SOCKET_EVENT_SIGNAL)
   socket.removeAllListeners(constants.SOCKET_EVENT_USERS)
   socket.removeAllListeners(constants.SOCKET_EVENT_HANG_UP)
+  socket.removeAllListeners(constants.SOCKET_EVENT_CHAT_HISTORY)
+  socket.removeAllListeners(constants.SOCKET_EVENT_REACTION)
+  socket.removeAllListeners(constants.SOCKET_EVENT_HAND_RAISE)
+  socket.removeAllListeners(constants.SOCKET_EVENT_HAND_LOWER)
+  socket.removeAllListeners(constants.SOCKET_EVENT_BREAKOUT)
+  socket.removeAllListeners(constants.SOCKET_EVENT_ROOM_FEATURES)
+  socket.removeAllListeners(constants.SOCKET_EVENT_ROLES)
+  socket.removeAllListeners(constants.SOCKET_EVENT_MODERATION)
+  socket.removeAllListeners(constants.SOCKET_EVENT_ROOM_LOCK)
+  socket.removeAllListeners(constants.SOCKET_EVENT_MODERATION_ERROR)
+  socket.removeAllListeners(constants.SOCKET_EVENT_PEER_CONNECTION_STATE)
+  socket.removeAllListeners(constants.SOCKET_EVENT_INACTIVITY_WARNING)
+  if (handleVisibilityChange) {
+    document.removeEventListener('visibilitychange', handleVisibilityChange)
+    handleVisibilityChange = undefined
+  }
 }
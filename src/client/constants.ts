@@ -31,12 +31,23 @@ export const MEDIA_PLAY = 'MEDIA_PLAY'
 export const NICKNAMES_SET = 'NICKNAMES_SET'
 export const NICKNAME_REMOVE = 'NICKNAME_REMOVE'
 
+export const ROLES_SET = 'ROLES_SET'
+
+export const ROOM_FEATURES_SET = 'ROOM_FEATURES_SET'
+
+export const CONNECTION_STATE_SET = 'CONNECTION_STATE_SET'
+export const CONNECTION_STATE_REMOVE = 'CONNECTION_STATE_REMOVE'
+
 export const PEER_ADD = 'PEER_ADD'
 export const PEER_REMOVE = 'PEER_REMOVE'
 
 // this data channel must have the same name as the one on the server-side,
 // when SFU is used.
 export const PEER_DATA_CHANNEL_NAME = 'data'
+// negotiated with the server out of band, which allows it to move signaling
+// off the websocket
+export const SIGNALING_DATA_CHANNEL_NAME = 'signaling'
+export const SIGNALING_DATA_CHANNEL_ID = 100
 
 export const PEER_EVENT_ERROR = 'error'
 export const PEER_EVENT_CONNECT = 'connect'
@@ -51,6 +62,29 @@ export const SOCKET_EVENT_READY = 'ready'
 export const SOCKET_EVENT_SIGNAL = 'signal'
 export const SOCKET_EVENT_USERS = 'users'
 export const SOCKET_EVENT_HANG_UP = 'hangUp'
+export const SOCKET_EVENT_JOIN_ERROR = 'ws_join_error'
+export const SOCKET_EVENT_RATE_LIMITED = 'ws_rate_limited'
+export const SOCKET_EVENT_VIDEO_PAUSED = 'videoPaused'
+export const SOCKET_EVENT_HANDOVER = 'handover'
+export const SOCKET_EVENT_CHAT_HISTORY = 'chatHistory'
+export const SOCKET_EVENT_REACTION = 'reaction'
+export const SOCKET_EVENT_HAND_RAISE = 'handRaise'
+export const SOCKET_EVENT_HAND_LOWER = 'handLower'
+export const SOCKET_EVENT_BREAKOUT = 'breakout'
+export const SOCKET_EVENT_ROOM_FEATURES = 'roomFeatures'
+export const SOCKET_EVENT_ROLES = 'roles'
+export const SOCKET_EVENT_SET_ROLE = 'setRole'
+export const SOCKET_EVENT_KICK_PEER = 'kickPeer'
+export const SOCKET_EVENT_MUTE_PEER = 'mutePeer'
+export const SOCKET_EVENT_START_RECORDING = 'startRecording'
+export const SOCKET_EVENT_STOP_RECORDING = 'stopRecording'
+export const SOCKET_EVENT_LOCK_ROOM = 'lockRoom'
+export const SOCKET_EVENT_UNLOCK_ROOM = 'unlockRoom'
+export const SOCKET_EVENT_ROOM_LOCK = 'roomLock'
+export const SOCKET_EVENT_MODERATION = 'moderation'
+export const SOCKET_EVENT_MODERATION_ERROR = 'moderationError'
+export const SOCKET_EVENT_PEER_CONNECTION_STATE = 'peerConnectionState'
+export const SOCKET_EVENT_INACTIVITY_WARNING = 'inactivityWarning'
 
 export const STREAM_ADD = 'PEER_STREAM_ADD'
 export const STREAM_REMOVE = 'PEER_STREAM_REMOVE'
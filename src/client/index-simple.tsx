@@ -11,7 +11,7 @@ socket.on('connect', () => {
     const peer = new Peer({
       initiator: initiator === userId,
       config: { iceServers },
-      trickle: false,
+      trickle: true,
       // Allow the peer to receive video, even if it's not sending stream:
       // https://github.com/feross/simple-peer/issues/95
       //offerConstraints: {
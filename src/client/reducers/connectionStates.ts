@@ -0,0 +1,27 @@
+import { CONNECTION_STATE_SET, CONNECTION_STATE_REMOVE, HANG_UP } from '../constants'
+import { ConnectionStates, ConnectionStateActions } from '../actions/ConnectionStateActions'
+import { HangUpAction } from '../actions/CallActions'
+import omit from 'lodash/omit'
+
+const defaultState: ConnectionStates = {}
+
+// connectionStates keeps the state of the peer connection of each user to
+// the server, so that users which are reconnecting can be shown as such
+export default function connectionStates(
+  state = defaultState,
+  action: ConnectionStateActions | HangUpAction,
+): ConnectionStates {
+  switch (action.type) {
+    case CONNECTION_STATE_SET:
+      return {
+        ...state,
+        [action.payload.userId]: action.payload.state,
+      }
+    case CONNECTION_STATE_REMOVE:
+      return omit(state, [action.payload.userId])
+    case HANG_UP:
+      return defaultState
+    default:
+      return state
+  }
+}
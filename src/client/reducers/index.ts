@@ -5,14 +5,20 @@ import peers from './peers'
 import media from './media'
 import streams from './streams'
 import nicknames from './nicknames'
+import roles from './roles'
+import connectionStates from './connectionStates'
+import roomFeatures from './roomFeatures'
 import { combineReducers } from 'redux'
 
 export default combineReducers({
+  connectionStates,
   notifications,
   messages,
   media,
   nicknames,
   peers,
+  roles,
+  roomFeatures,
   streams,
   windowStates,
 })
@@ -169,6 +169,23 @@ describe('media', () => {
             expect((peer.removeTrack as any).mock.calls).toEqual([])
           })
           await dispatch()
+          peers.forEach(peer => {
+            expect((peer.addTrack as jest.Mock).mock.calls)
+            .toEqual([[ track, stream ]])
+            expect((peer.replaceTrack as jest.Mock).mock.calls)
+            .toEqual([[ track, track, stream ]])
+            expect((peer.removeTrack as jest.Mock).mock.calls).toEqual([])
+          })
+        })
+
+        it('adds and removes tracks which cannot be replaced', async () => {
+          await dispatch()
+          peers.forEach(peer => {
+            (peer.replaceTrack as jest.Mock).mockImplementationOnce(() => {
+              throw new Error('replaceTrack not supported')
+            })
+          })
+          await dispatch()
           peers.forEach(peer => {
             expect((peer.addTrack as jest.Mock).mock.calls)
             .toEqual([[ track, stream ], [ track, stream ]])
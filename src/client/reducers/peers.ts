@@ -19,16 +19,71 @@ let localStreams: Record<StreamType, MediaStream | undefined> = {
   desktop: undefined,
 }
 
+// senderStreams contains the stream each local track was added to a peer
+// with. simple-peer keeps using that stream for tracks which replaced it.
+const senderStreams =
+  new WeakMap<Peer.Instance, Map<MediaStreamTrack, MediaStream>>()
+
+function getSenderStreams(peer: Peer.Instance) {
+  let streams = senderStreams.get(peer)
+  if (!streams) {
+    streams = new Map()
+    senderStreams.set(peer, streams)
+  }
+  return streams
+}
+
+function addTrackToPeer(
+  peer: Peer.Instance,
+  track: MediaStreamTrack,
+  stream: MediaStream,
+) {
+  debug(
+    'Add track to peer, id: %s, kind: %s, label: %s',
+    track.id, track.kind, track.label,
+  )
+  peer.addTrack(track, stream)
+  getSenderStreams(peer).set(track, stream)
+}
+
 function removeTrackFromPeer(
   peer: Peer.Instance,
   track: MediaStreamTrack,
   stream: MediaStream,
 ) {
+  const streams = getSenderStreams(peer)
   try {
-    peer.removeTrack(track, stream)
+    peer.removeTrack(track, streams.get(track) || stream)
   } catch (err) {
     debug('peer.removeTrack: %s', err)
   }
+  streams.delete(track)
+}
+
+// replaceTrackOfPeer sends newTrack instead of oldTrack with the same sender,
+// so that no renegotiation is needed and the SFU keeps forwarding the same
+// track. Returns false when the track could not be replaced.
+function replaceTrackOfPeer(
+  peer: Peer.Instance,
+  oldTrack: MediaStreamTrack,
+  newTrack: MediaStreamTrack,
+  oldStream: MediaStream,
+): boolean {
+  const streams = getSenderStreams(peer)
+  const stream = streams.get(oldTrack) || oldStream
+  debug(
+    'Replace track of peer, id: %s, with id: %s, kind: %s',
+    oldTrack.id, newTrack.id, newTrack.kind,
+  )
+  try {
+    peer.replaceTrack(oldTrack, newTrack, stream)
+  } catch (err) {
+    debug('peer.replaceTrack: %s', err)
+    return false
+  }
+  streams.delete(oldTrack)
+  streams.set(newTrack, stream)
+  return true
 }
 
 function handleRemoveStream(
@@ -61,17 +116,23 @@ function handleMediaStream(
   ) {
     forEach(state, peer => {
       const localStream = localStreams[streamType]
-      localStream && localStream.getTracks().forEach(track => {
-        removeTrackFromPeer(peer, track, localStream)
-      })
+      const oldTracks = localStream ? localStream.getTracks() : []
       const stream = action.payload.stream
-      stream.getTracks().forEach(track => {
-        debug(
-          'Add track to peer, id: %s, kind: %s, label: %s',
-          track.id, track.kind, track.label,
-        )
-        peer.addTrack(track, stream)
+      const newTracks = stream.getTracks().filter(track => {
+        const index = oldTracks.findIndex(t => t.kind === track.kind)
+        if (
+          localStream && index >= 0 &&
+          replaceTrackOfPeer(peer, oldTracks[index], track, localStream)
+        ) {
+          oldTracks.splice(index, 1)
+          return false
+        }
+        return true
+      })
+      localStream && oldTracks.forEach(track => {
+        removeTrackFromPeer(peer, track, localStream)
       })
+      newTracks.forEach(track => addTrackToPeer(peer, track, stream))
     })
     localStreams[streamType] = action.payload.stream
   }
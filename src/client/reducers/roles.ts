@@ -0,0 +1,19 @@
+import { ROLES_SET, HANG_UP } from '../constants'
+import { Roles, RolesSetAction } from '../actions/RoleActions'
+import { HangUpAction } from '../actions/CallActions'
+
+const defaultState: Roles = {}
+
+export default function roles(
+  state = defaultState,
+  action: RolesSetAction | HangUpAction,
+): Roles {
+  switch (action.type) {
+    case ROLES_SET:
+      return action.payload
+    case HANG_UP:
+      return defaultState
+    default:
+      return state
+  }
+}
@@ -0,0 +1,24 @@
+import { ROOM_FEATURES_SET } from '../constants'
+import { RoomFeaturesSetAction } from '../actions/RoomFeatureActions'
+import { RoomFeatures } from '../../shared'
+
+// features are enabled until the server sends the features of the room
+const defaultState: RoomFeatures = {
+  chat: true,
+  recording: true,
+  e2ee: false,
+  maxVideoWidth: 0,
+  maxVideoHeight: 0,
+}
+
+export default function roomFeatures(
+  state = defaultState,
+  action: RoomFeaturesSetAction,
+): RoomFeatures {
+  switch (action.type) {
+    case ROOM_FEATURES_SET:
+      return action.payload
+    default:
+      return state
+  }
+}
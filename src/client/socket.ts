@@ -1,9 +1,15 @@
 import { baseUrl, callId, userId } from './window'
 import { SocketEvent, TypedEmitter } from '../shared'
 import { SocketClient } from './ws'
-export type ClientSocket = TypedEmitter<SocketEvent>
+export type ClientSocket = TypedEmitter<SocketEvent> & {
+  handover?: (channel: RTCDataChannel) => void
+}
+
+// password of a registered room is passed on from the page URL
+const password = new URLSearchParams(location.search).get('password')
 
 const wsUrl = location.origin.replace(/^http/, 'ws') +
-  baseUrl + '/ws/' + callId + '/' + userId
+  baseUrl + '/ws/' + callId + '/' + userId +
+  (password ? '?password=' + encodeURIComponent(password) : '')
 
 export default new SocketClient<SocketEvent>(wsUrl)
@@ -14,6 +14,8 @@ export class SocketClient<E extends Events> implements TypedEmitter<E> {
 
   protected readonly emitter = new EventEmitter()
   protected ws!: WebSocket
+  // messages are sent over channel instead of ws after a handover
+  protected channel: RTCDataChannel | undefined
   protected connected = false
   reconnectTimeout = 2000
 
@@ -61,6 +63,29 @@ export class SocketClient<E extends Events> implements TypedEmitter<E> {
     }
   }
 
+  protected channelHandleClose = () => {
+    debug('signaling data channel closed')
+    this.channel = undefined
+    this.wsHandleClose()
+  }
+
+  // handover moves the connection to channel, a data channel to the server,
+  // and closes the websocket once the server has acknowledged it.
+  handover(channel: RTCDataChannel) {
+    debug('requesting handover to data channel: %s', channel.label)
+    channel.addEventListener('message', this.wsHandleMessage)
+    this.emitter.once('handover', () => {
+      debug('handover acknowledged, closing websocket')
+      this.channel = channel
+      channel.addEventListener('close', this.channelHandleClose)
+      const ws = this.ws
+      ws.removeEventListener('close', this.wsHandleClose)
+      ws.removeEventListener('message', this.wsHandleMessage)
+      ws.close(1000)
+    })
+    this.emit('handover', undefined as E[keyof E])
+  }
+
   protected ping = () => {
     this.emit('ping', undefined as E[keyof E])
   }
@@ -101,6 +126,7 @@ export class SocketClient<E extends Events> implements TypedEmitter<E> {
       type: name as string,
       payload: value,
     }
-    this.ws.send(JSON.stringify(message))
+    const conn = this.channel || this.ws
+    conn.send(JSON.stringify(message))
   }
 }
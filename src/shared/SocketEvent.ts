@@ -6,6 +6,18 @@ export interface Ready {
   nickname: string
 }
 
+export type Role = 'host' | 'cohost' | 'guest' | 'viewer'
+
+export type PeerConnectionState = 'connected' | 'reconnecting' | 'failed'
+
+export interface RoomFeatures {
+  chat: boolean
+  recording: boolean
+  e2ee: boolean
+  maxVideoWidth: number
+  maxVideoHeight: number
+}
+
 export interface SocketEvent {
   users: {
     initiator: string
@@ -13,6 +25,12 @@ export interface SocketEvent {
     peerIds: string[]
     // mapping of userId / nickname
     nicknames: Record<string, string>
+    // direct peer connections to close before connecting to peerIds, when a
+    // two party call is moved to the SFU
+    closePeerIds?: string[]
+    // set by the SFU when signaling can be moved to a data channel once the
+    // peer connection is established
+    signalingDataChannel?: boolean
   }
   hangUp: {
     userId: string
@@ -25,4 +43,102 @@ export interface SocketEvent {
   connect: undefined
   disconnect: undefined
   ready: Ready
+  // sent when the tab is hidden or shown, so that the SFU stops sending
+  // video while it cannot be seen
+  videoPaused: {
+    paused: boolean
+  }
+  // requests the server to send messages over the signaling data channel,
+  // which it acknowledges over the data channel
+  handover: undefined
+  // ephemeral events broadcast by the server to all clients in the room,
+  // including the sender. userId is set by the server.
+  reaction: {
+    userId?: string
+    reaction: string
+  }
+  handRaise: {
+    userId?: string
+  }
+  handLower: {
+    userId?: string
+  }
+  // sent when the client is moved to a breakout room, or with an empty name
+  // when it returns to the main room
+  breakout: {
+    name: string
+  }
+  // features of the room, sent after joining
+  roomFeatures: {
+    features: RoomFeatures
+  }
+  // roles of all clients in the room, sent when they change
+  roles: {
+    roles: Record<string, Role>
+  }
+  // moderation actions, which only hosts and cohosts are allowed to send
+  setRole: {
+    userId: string
+    role: Role
+  }
+  kickPeer: {
+    userId: string
+  }
+  mutePeer: {
+    userId: string
+    muted: boolean
+  }
+  startRecording: undefined
+  stopRecording: undefined
+  lockRoom: undefined
+  unlockRoom: undefined
+  // sent when the room is locked or unlocked
+  roomLock: {
+    locked: boolean
+  }
+  // broadcast after a moderation action has been performed by userId
+  moderation: {
+    action: string
+    userId: string
+    targetId?: string
+    role?: Role
+    muted?: boolean
+  }
+  // sent to the client when its moderation action has failed
+  moderationError: {
+    action: string
+    error: string
+  }
+  // state of the peer connection between userId and the server, broadcast
+  // when it changes
+  peerConnectionState: {
+    userId: string
+    state: PeerConnectionState
+  }
+  // last chat messages of the room, sent after joining
+  chatHistory: {
+    messages: {
+      userId: string
+      message: string
+      timestamp: string
+    }[]
+  }
+  // sent before the server closes the connection, for example when the
+  // room is full
+  ws_join_error: {
+    code: string
+    message: string
+    // URL of another server to connect to instead, when set
+    redirect?: string
+  }
+  // sent when messages of the client are dropped because it sent too many
+  ws_rate_limited: {
+    error: string
+  }
+  // sent before the server disconnects a client that has sent no signaling
+  // messages and no media for too long
+  inactivityWarning: {
+    // seconds until the client is disconnected
+    disconnectIn: number
+  }
 }